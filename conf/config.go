@@ -7,22 +7,82 @@ import (
 type GatewayConfig struct {
 	GatewayServerConfig GatewayServerConfig `json:"server"`
 	MqttConfig          MqttConfig          `json:"mqtt"`
+	Listeners           []ListenerConfig    `json:"listeners"` // 设备接入监听列表,为空时使用 server 中的单个监听配置
+	Polling             PollingConfig       `json:"polling"`   // 轮询配置,用于不主动上报数据的设备
 }
 
 type GatewayServerConfig struct {
-	Name         string        `json:"name"`         // 网关服务名称,启动时显示
-	Addr         string        `json:"addr"`         // 网关服务地址
-	NetType      string        `json:"netType"`      // 网关服务类型
-	SerUpTopic   string        `json:"serUpTopic"`   // 服务上行Topic
-	SerDownTopic string        `json:"serDownTopic"` // 服务下行Topic
-	Duration     time.Duration `json:"duration"`     // 网关服务心跳时长
-	ProductKey   string        `json:"productKey"`   // 网关产品标识
-	DeviceKey    string        `json:"deviceKey"`    // 网关实例标识
-	DeviceName   string        `json:"deviceName"`   // 网关系统名称
-	Description  string        `json:"description"`  // 网关系统描述
-	DeviceType   string        `json:"deviceType"`   // 网关系统类型
-	Manufacturer string        `json:"manufacturer"` // 网关系统厂商
-	PacketConfig PacketConfig  `json:"packetConfig"`
+	Name           string           `json:"name"`           // 网关服务名称,启动时显示
+	Addr           string           `json:"addr"`           // 网关服务地址
+	NetType        string           `json:"netType"`        // 网关服务类型
+	SerUpTopic     string           `json:"serUpTopic"`     // 服务上行Topic
+	SerDownTopic   string           `json:"serDownTopic"`   // 服务下行Topic
+	Duration       time.Duration    `json:"duration"`       // 网关服务心跳时长
+	RequestTimeout time.Duration    `json:"requestTimeout"` // 属性设置与服务调用等待设备完成的超时,默认 10s
+	ProductKey     string           `json:"productKey"`     // 网关产品标识
+	DeviceKey      string           `json:"deviceKey"`      // 网关实例标识
+	DeviceName     string           `json:"deviceName"`     // 网关系统名称
+	Description    string           `json:"description"`    // 网关系统描述
+	DeviceType     string           `json:"deviceType"`     // 网关系统类型
+	Manufacturer   string           `json:"manufacturer"`   // 网关系统厂商
+	PacketConfig   PacketConfig     `json:"packetConfig"`
+	Serial         SerialConfig     `json:"serial"`     // 串口配置,NetType 为 serial 时使用
+	TCPClient      TCPClientConfig  `json:"tcpClient"`  // TCP 客户端配置,NetType 为 tcp-client 时使用
+	TLS            TLSConfig        `json:"tls"`        // 设备接入的 TLS 配置
+	WebSocket      WebSocketConfig  `json:"websocket"`  // WebSocket 配置,NetType 为 ws/wss 时使用
+	HTTP           HTTPConfig       `json:"http"`       // HTTP 接入配置,NetType 为 http 时使用
+	CoAP           CoAPConfig       `json:"coap"`       // CoAP 接入配置,NetType 为 coap 时使用
+	MQTTBroker     MQTTBrokerConfig `json:"mqttBroker"` // 内置 MQTT Broker 配置,NetType 为 mqtt-broker 时使用
+	MQTTSN         MQTTSNConfig     `json:"mqttsn"`     // MQTT-SN 网关配置,NetType 为 mqtt-sn 时使用
+	Semtech        SemtechConfig    `json:"semtech"`    // LoRa 网关 Semtech UDP 转发协议配置,NetType 为 semtech-udp 时使用
+	LwM2M          LwM2MConfig      `json:"lwm2m"`      // LwM2M 服务器配置,NetType 为 lwm2m 时使用,CoAP 传输参数使用 coap 中的配置
+	SNMP           SNMPConfig       `json:"snmp"`       // SNMP 轮询与 Trap 接收配置,NetType 为 snmp 时使用
+	BACnet         BACnetConfig     `json:"bacnet"`     // BACnet/IP 客户端配置,NetType 为 bacnet 时使用
+	Detect         DetectConfig     `json:"detect"`     // 协议识别配置,同一端口接入多种协议的设备时使用
+}
+
+// ListenerConfig 定义了一个设备接入监听，一个网关可以同时运行多个不同网络类型、不同协议的监听
+type ListenerConfig struct {
+	Name         string           `json:"name"`         // 监听名称,用于日志,默认为 netType@addr
+	NetType      string           `json:"netType"`      // 网络类型
+	Addr         string           `json:"addr"`         // 监听地址
+	Protocol     string           `json:"protocol"`     // 协议处理器名称,通过 network.RegisterProtocol 注册,为空时使用创建网关时传入的协议处理器
+	PacketConfig PacketConfig     `json:"packetConfig"` // 粘包处理配置
+	Serial       SerialConfig     `json:"serial"`       // 串口配置
+	TCPClient    TCPClientConfig  `json:"tcpClient"`    // TCP 客户端配置
+	TLS          TLSConfig        `json:"tls"`          // TLS 配置
+	WebSocket    WebSocketConfig  `json:"websocket"`    // WebSocket 配置
+	HTTP         HTTPConfig       `json:"http"`         // HTTP 接入配置
+	CoAP         CoAPConfig       `json:"coap"`         // CoAP 接入配置
+	MQTTBroker   MQTTBrokerConfig `json:"mqttBroker"`   // 内置 MQTT Broker 配置
+	MQTTSN       MQTTSNConfig     `json:"mqttsn"`       // MQTT-SN 网关配置
+	Semtech      SemtechConfig    `json:"semtech"`      // Semtech UDP 转发协议配置
+	LwM2M        LwM2MConfig      `json:"lwm2m"`        // LwM2M 服务器配置
+	SNMP         SNMPConfig       `json:"snmp"`         // SNMP 轮询与 Trap 接收配置
+	BACnet       BACnetConfig     `json:"bacnet"`       // BACnet/IP 客户端配置
+	Detect       DetectConfig     `json:"detect"`       // 协议识别配置
+}
+
+// Listener 将 server 中的单个监听配置转换为监听配置
+func (c GatewayServerConfig) Listener() ListenerConfig {
+	return ListenerConfig{
+		NetType:      c.NetType,
+		Addr:         c.Addr,
+		PacketConfig: c.PacketConfig,
+		Serial:       c.Serial,
+		TCPClient:    c.TCPClient,
+		TLS:          c.TLS,
+		WebSocket:    c.WebSocket,
+		HTTP:         c.HTTP,
+		CoAP:         c.CoAP,
+		MQTTBroker:   c.MQTTBroker,
+		MQTTSN:       c.MQTTSN,
+		Semtech:      c.Semtech,
+		LwM2M:        c.LwM2M,
+		SNMP:         c.SNMP,
+		BACnet:       c.BACnet,
+		Detect:       c.Detect,
+	}
 }
 
 // PacketHandlingType 定义了处理粘包的方法类型
@@ -32,8 +92,242 @@ type PacketHandlingType int
 type PacketConfig struct {
 	Type         PacketHandlingType
 	FixedLength  int    // 用于 FixedLength 类型
-	HeaderLength int    // 用于 HeaderBodySeparate 类型
+	HeaderLength int    // 用于 HeaderBodySeparate 类型,未设置 LengthFieldLength 时表示头部长度,头部前4字节为大端的体长度
 	Delimiter    string // 用于 Delimiter 类型
+
+	// 以下用于 HeaderBodySeparate 类型的长度字段解析
+	// 帧总长度 = LengthFieldOffset + LengthFieldLength + 长度字段的值 + LengthAdjustment
+	LengthFieldOffset   int    // 长度字段在帧中的偏移
+	LengthFieldLength   int    // 长度字段字节数,支持 1/2/3/4/8
+	ByteOrder           string // 长度字段字节序,big(默认)/little
+	LengthAdjustment    int    // 长度修正值,长度字段的值包含头部时为负数,不包含尾部校验等字段时为正数
+	InitialBytesToStrip int    // 交给协议处理器前从帧首部剥离的字节数
+	MaxFrameLength      int    // 最大帧长度,默认 64KB,超出时断开连接
+}
+
+// SerialConfig 定义了串口(RS-232/RS-485)的配置
+type SerialConfig struct {
+	Port       string        `json:"port"`       // 串口设备路径,如 /dev/ttyUSB0、COM3
+	DeviceKey  string        `json:"deviceKey"`  // 串口连接的设备标识,总线上的子设备经该标识轮询和下发
+	BaudRate   int           `json:"baudRate"`   // 波特率,默认 9600
+	DataBits   int           `json:"dataBits"`   // 数据位,默认 8
+	StopBits   int           `json:"stopBits"`   // 停止位,1 或 2,默认 1
+	Parity     string        `json:"parity"`     // 校验位,none/odd/even/mark/space,默认 none
+	FrameGap   time.Duration `json:"frameGap"`   // 帧间隔,不做粘包处理时以此静默时间切分数据帧,默认按3.5个字符时间计算
+	RetryDelay time.Duration `json:"retryDelay"` // 串口异常断开后重新打开的间隔,默认 3 秒
+}
+
+// TCPClientConfig 定义了 TCP 客户端模式的配置，网关主动连接 DTU、串口服务器等设备
+type TCPClientConfig struct {
+	Remotes     []RemoteConfig `json:"remotes"`     // 远端设备列表
+	DialTimeout time.Duration  `json:"dialTimeout"` // 连接超时,默认 10 秒
+	MinBackoff  time.Duration  `json:"minBackoff"`  // 重连最小间隔,默认 1 秒
+	MaxBackoff  time.Duration  `json:"maxBackoff"`  // 重连最大间隔,默认 1 分钟
+}
+
+// RemoteConfig 定义了 TCP 客户端模式下的一个远端设备
+type RemoteConfig struct {
+	Addr      string `json:"addr"`      // 远端地址,如 192.168.1.10:502
+	DeviceKey string `json:"deviceKey"` // 绑定的设备标识,为空时由协议处理器识别
+}
+
+// TLSConfig 定义了设备接入的 TLS 配置
+type TLSConfig struct {
+	Enable        bool     `json:"enable"`        // 是否启用 TLS
+	CertFile      string   `json:"certFile"`      // 服务端证书文件
+	KeyFile       string   `json:"keyFile"`       // 服务端私钥文件
+	ClientCAFile  string   `json:"clientCAFile"`  // 客户端 CA 证书文件,设置后启用双向认证(mTLS)
+	ClientAuth    string   `json:"clientAuth"`    // 客户端证书校验方式,require(配置 ClientCAFile 时默认)/optional
+	MinVersion    string   `json:"minVersion"`    // 最低 TLS 版本,1.0/1.1/1.2/1.3,默认 1.2
+	CipherSuites  []string `json:"cipherSuites"`  // 允许的加密套件名称,如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,为空时使用默认值
+	DeviceKeyFrom string   `json:"deviceKeyFrom"` // 从客户端证书获取设备标识的字段,cn(默认)/dns/uri/email/serial/none
+}
+
+// WebSocketConfig 定义了 WebSocket 设备接入的配置
+type WebSocketConfig struct {
+	Path           string   `json:"path"`           // WebSocket 路径,默认 /ws
+	MessageType    string   `json:"messageType"`    // 下发消息类型,binary/text,默认与设备最近一次上行消息相同
+	AllowedOrigins []string `json:"allowedOrigins"` // 允许的 Origin,为空时不校验
+}
+
+// HTTPConfig 定义了 HTTP 设备接入的配置
+// 设备标识依次从路径 {Path}/{deviceKey}、请求头 DeviceKeyHeader、查询参数 DeviceKeyQuery 中获取
+type HTTPConfig struct {
+	Path            string            `json:"path"`            // 接收数据的路径,默认 /data
+	DeviceKeyHeader string            `json:"deviceKeyHeader"` // 携带设备标识的请求头,默认 X-Device-Key
+	DeviceKeyQuery  string            `json:"deviceKeyQuery"`  // 携带设备标识的查询参数,默认 deviceKey
+	MaxBodySize     int64             `json:"maxBodySize"`     // 请求体最大字节数,默认 1MB
+	MaxQueueSize    int               `json:"maxQueueSize"`    // 每个设备缓存的下发数据条数上限,默认 100,超出时丢弃最早的数据
+	ContentType     string            `json:"contentType"`     // 响应的 Content-Type,默认 application/octet-stream
+	Tokens          map[string]string `json:"tokens"`          // 设备标识 -> 访问令牌,设备以请求头 Authorization: Bearer <令牌> 认证;配置后只接受列出的设备
+	IdleTimeout     time.Duration     `json:"idleTimeout"`     // 设备超过该时长未上报时清除设备记录与缓存的下发数据,默认 24h
+	MaxDevices      int               `json:"maxDevices"`      // 记录的设备数量上限,默认 10000,超出时清除最久未上报的设备
+}
+
+// CoAPConfig 定义了 CoAP 设备接入的配置
+// 设备标识依次从路径 {Path}/{deviceKey}、查询参数 DeviceKeyQuery 中获取
+type CoAPConfig struct {
+	Path           string        `json:"path"`           // 接收数据的资源路径,默认 up
+	DeviceKeyQuery string        `json:"deviceKeyQuery"` // 携带设备标识的查询参数,默认 ep
+	DownPath       string        `json:"downPath"`       // 下发数据时请求设备的资源路径,默认 down
+	BlockSize      int           `json:"blockSize"`      // 块传输的块大小,16~1024 之间的 2 的幂,默认 512
+	AckTimeout     time.Duration `json:"ackTimeout"`     // CON 报文等待确认的初始超时,默认 2 秒
+	MaxRetransmit  int           `json:"maxRetransmit"`  // CON 报文最大重传次数,默认 4
+}
+
+// MQTTBrokerConfig 定义了内置 MQTT Broker 的配置，现场设备连接网关内置的 Broker，与上行平台的 MQTT 连接相互独立
+// 主题中的 {deviceKey} 占位符匹配设备标识，其余部分支持 + 与 # 通配符
+type MQTTBrokerConfig struct {
+	UpTopic   string           `json:"upTopic"`   // 设备上报主题,默认 device/{deviceKey}/up,不含 {deviceKey} 时使用客户端的设备标识
+	DownTopic string           `json:"downTopic"` // 下发主题,默认 device/{deviceKey}/down
+	QoS       byte             `json:"qos"`       // 下发消息的 QoS,0/1/2,默认 0
+	Users     []MQTTUserConfig `json:"users"`     // 客户端账号,为空时允许匿名连接
+}
+
+// MQTTUserConfig 定义了内置 MQTT Broker 的一个客户端账号
+type MQTTUserConfig struct {
+	Username  string `json:"username"`  // 用户名
+	Password  string `json:"password"`  // 密码
+	ClientID  string `json:"clientId"`  // 限定的客户端标识,为空时不限制
+	DeviceKey string `json:"deviceKey"` // 绑定的设备标识,设置后只能收发该设备的主题;为空时使用客户端标识,并可通过主题上报子设备数据
+}
+
+// MQTTSNConfig 定义了 MQTT-SN(v1.2)网关的配置，传感器等受限设备通过 UDP 以 MQTT-SN 接入
+// 客户端标识作为设备标识，客户端发布的消息作为该设备的数据
+type MQTTSNConfig struct {
+	GatewayID        byte                `json:"gatewayId"`        // 网关标识,应答 SEARCHGW 时使用,默认 1
+	DownTopic        string              `json:"downTopic"`        // 下发主题,默认 device/{deviceKey}/down,客户端需要订阅该主题
+	QoS              int                 `json:"qos"`              // 下发消息的 QoS,0/1,默认 0
+	PredefinedTopics []MQTTSNTopicConfig `json:"predefinedTopics"` // 预定义主题,QoS -1 发布只能使用预定义主题或短主题
+	MaxQueueSize     int                 `json:"maxQueueSize"`     // 每个休眠客户端缓存的下发消息数上限,默认 100,超出时丢弃最早的消息
+	AckTimeout       time.Duration       `json:"ackTimeout"`       // QoS 1 下发消息等待 PUBACK 的超时,默认 10 秒
+	MaxRetransmit    int                 `json:"maxRetransmit"`    // QoS 1 下发消息的最大重传次数,默认 3
+}
+
+// MQTTSNTopicConfig 定义了 MQTT-SN 的一个预定义主题
+type MQTTSNTopicConfig struct {
+	ID   uint16 `json:"id"`   // 主题 ID
+	Name string `json:"name"` // 主题名称
+}
+
+// SemtechConfig 定义了 LoRa 网关 Semtech UDP 转发协议(packet forwarder)的配置
+// 终端按 Class A 接收下发数据，下发在上行后的 RX1 或 RX2 窗口发送，错过窗口时缓存到下一次上行
+type SemtechConfig struct {
+	RX1Delay         time.Duration `json:"rx1Delay"`         // 上行结束到 RX1 窗口的延时,默认 1 秒,RX2 窗口再延后 1 秒
+	JoinAcceptDelay1 time.Duration `json:"joinAcceptDelay1"` // 入网请求到入网接受 RX1 窗口的延时,默认 5 秒
+	JoinAcceptDelay2 time.Duration `json:"joinAcceptDelay2"` // 入网请求到入网接受 RX2 窗口的延时,默认 6 秒
+	RX2Frequency     float64       `json:"rx2Frequency"`     // RX2 窗口的频率,MHz,为 0 时不使用 RX2 窗口
+	RX2DataRate      string        `json:"rx2DataRate"`      // RX2 窗口的速率,如 SF12BW125
+	TxPower          int           `json:"txPower"`          // 下发的发射功率,dBm,默认 14
+	DedupWindow      time.Duration `json:"dedupWindow"`      // 多个网关收到同一上行时的去重时间窗口,默认 200 毫秒
+	MaxQueueSize     int           `json:"maxQueueSize"`     // 每个终端缓存的下发数据数上限,默认 10,超出时丢弃最早的数据
+}
+
+// LwM2MConfig 定义了 LwM2M 1.0 服务器的配置，设备通过 /rd 注册接口注册，终端名称作为设备标识
+type LwM2MConfig struct {
+	Resources []LwM2MResourceConfig `json:"resources"` // 资源与物模型属性的映射
+	Observe   []string              `json:"observe"`   // 设备注册后观察的路径,如 3303/0 或 3303/0/5700,通知中映射的资源作为属性上报
+	Services  []LwM2MServiceConfig  `json:"services"`  // 服务调用与资源操作的映射
+}
+
+// LwM2MResourceConfig 定义了一个资源与物模型属性的映射
+type LwM2MResourceConfig struct {
+	Name string `json:"name"` // 属性标识
+	Path string `json:"path"` // 资源路径,对象/实例/资源,如 3303/0/5700
+	Type string `json:"type"` // 数据类型,string(默认)/integer/float/boolean/opaque/time/objlnk
+}
+
+// LwM2MServiceConfig 定义了一个服务调用对应的资源操作
+type LwM2MServiceConfig struct {
+	Name      string `json:"name"`      // 服务标识
+	Path      string `json:"path"`      // 资源路径
+	Operation string `json:"operation"` // 操作,execute(默认,参数 args 作为执行参数)/read(读取后回复映射的属性)
+}
+
+// SNMPConfig 定义了 SNMP 管理端的配置，网关按轮询组定时读取代理的对象，监听地址用于接收 Trap
+type SNMPConfig struct {
+	Timeout        time.Duration     `json:"timeout"`        // 单次请求等待响应的超时,默认 3s
+	Retries        int               `json:"retries"`        // 超时后的重试次数,默认 1
+	MaxRepetitions int               `json:"maxRepetitions"` // 遍历表时 GETBULK 每次获取的行数,默认 10
+	MaxVarBinds    int               `json:"maxVarBinds"`    // 单个 GET 请求的最大对象数,默认 20
+	Agents         []SNMPAgentConfig `json:"agents"`         // 被管理的代理(设备)
+	Groups         []SNMPGroupConfig `json:"groups"`         // 轮询组
+	TrapCommunity  string            `json:"trapCommunity"`  // v1/v2c Trap 的团体名,为空时不校验
+	TrapUnknown    bool              `json:"trapUnknown"`    // 接收未配置来源的 Trap,以来源 IP 作为设备标识,默认丢弃
+	Traps          []SNMPTrapConfig  `json:"traps"`          // Trap 与事件的映射
+}
+
+// SNMPAgentConfig 定义了一个 SNMP 代理
+type SNMPAgentConfig struct {
+	DeviceKey string         `json:"deviceKey"` // 设备标识
+	Addr      string         `json:"addr"`      // 代理地址,默认端口 161
+	Version   string         `json:"version"`   // 协议版本,1/2c(默认)/3
+	Community string         `json:"community"` // v1/v2c 团体名,默认 public
+	User      SNMPUserConfig `json:"user"`      // v3 用户
+	Context   string         `json:"context"`   // v3 上下文名称
+}
+
+// SNMPUserConfig 定义了 SNMP v3 的 USM 用户
+type SNMPUserConfig struct {
+	Name         string `json:"name"`         // 用户名
+	AuthProtocol string `json:"authProtocol"` // 认证协议,MD5/SHA,为空时不认证
+	AuthPassword string `json:"authPassword"` // 认证口令
+	PrivProtocol string `json:"privProtocol"` // 加密协议,DES/AES,为空时不加密
+	PrivPassword string `json:"privPassword"` // 加密口令
+}
+
+// SNMPGroupConfig 定义了一个 SNMP 轮询组，组内的对象按相同的周期读取
+type SNMPGroupConfig struct {
+	Name       string             `json:"name"`       // 轮询组名称,用于日志
+	DeviceKeys []string           `json:"deviceKeys"` // 轮询的代理
+	Interval   time.Duration      `json:"interval"`   // 轮询周期,默认 60s
+	Objects    []SNMPObjectConfig `json:"objects"`    // 读取的对象
+}
+
+// SNMPObjectConfig 定义了一个对象与物模型属性的映射
+type SNMPObjectConfig struct {
+	Name  string  `json:"name"`  // 属性标识,遍历的对象为 属性标识_索引
+	OID   string  `json:"oid"`   // 对象标识符,标量对象需要包含实例 .0
+	Type  string  `json:"type"`  // 属性类型,int/float/string/hex/bool,为空时按 SNMP 类型转换
+	Scale float64 `json:"scale"` // 数值的倍率,如 0.1
+	Walk  bool    `json:"walk"`  // 遍历 OID 下的全部实例,用于表的列
+}
+
+// SNMPTrapConfig 定义了一个 Trap 与事件的映射
+type SNMPTrapConfig struct {
+	Name    string             `json:"name"`    // 事件标识
+	OID     string             `json:"oid"`     // Trap 的 OID,v1 Trap 按 RFC 3584 转换后匹配
+	Objects []SNMPObjectConfig `json:"objects"` // 变量绑定与事件参数的映射,OID 为对象(不含实例),未映射的变量以 OID 作为参数名
+}
+
+// BACnetConfig 定义了 BACnet/IP 客户端的配置，网关按对象映射定时读取设备的属性，并订阅对象的 COV 通知
+type BACnetConfig struct {
+	Broadcast     string               `json:"broadcast"`     // Who-Is 的广播地址,默认 255.255.255.255:47808
+	Timeout       time.Duration        `json:"timeout"`       // 单次请求等待响应的超时,默认 3s
+	Retries       int                  `json:"retries"`       // 超时后的重试次数,默认 1
+	MaxProperties int                  `json:"maxProperties"` // 单个 ReadPropertyMultiple 请求的最大属性数,默认 20
+	COVLifetime   time.Duration        `json:"covLifetime"`   // COV 订阅的生存期,默认 300s,到期前续订
+	Devices       []BACnetDeviceConfig `json:"devices"`       // 接入的设备
+}
+
+// BACnetDeviceConfig 定义了一个 BACnet 设备，设备以子设备的形式接入
+type BACnetDeviceConfig struct {
+	DeviceKey string               `json:"deviceKey"` // 设备标识
+	Instance  uint32               `json:"instance"`  // 设备对象的实例号
+	Addr      string               `json:"addr"`      // 设备地址,默认端口 47808,为空时通过 Who-Is/I-Am 发现
+	Interval  time.Duration        `json:"interval"`  // 轮询周期,默认 60s
+	Objects   []BACnetObjectConfig `json:"objects"`   // 对象属性与物模型属性的映射
+}
+
+// BACnetObjectConfig 定义了一个对象属性与物模型属性的映射
+type BACnetObjectConfig struct {
+	Name     string  `json:"name"`     // 属性标识
+	Object   string  `json:"object"`   // 对象,类型:实例号,如 analogInput:1、binaryOutput:2
+	Property string  `json:"property"` // 对象的属性,默认 presentValue
+	Type     string  `json:"type"`     // 属性类型,int/float/string/bool,为空时按 BACnet 类型转换
+	Scale    float64 `json:"scale"`    // 数值的倍率,如 0.1
+	COV      bool    `json:"cov"`      // 订阅对象的 COV 通知,值变化时立即上报
+	Priority int     `json:"priority"` // 写入的优先级 1-16,为 0 时不指定
 }
 
 type MqttConfig struct {
@@ -46,3 +340,52 @@ type MqttConfig struct {
 	KeepAliveDuration     time.Duration `json:"keepAliveDuration"`     // mqtt客户端保持连接时长
 	Duration              time.Duration `json:"duration"`              // mqtt客户端心跳时长
 }
+
+// DetectConfig 定义了同一端口接入多种协议设备时的协议识别配置,配置了 Rules 时启用
+// 新连接先读取开头的数据依次匹配识别规则,匹配成功后该连接在整个生命周期内使用对应的协议处理器
+type DetectConfig struct {
+	Rules    []DetectRuleConfig `json:"rules"`    // 识别规则,按顺序匹配
+	Fallback string             `json:"fallback"` // 未识别时使用的协议处理器名称,为空时使用监听的协议处理器
+	Timeout  time.Duration      `json:"timeout"`  // 等待识别数据的超时,默认 3s,超时后使用 Fallback
+	PeekSize int                `json:"peekSize"` // 用于识别的最大字节数,默认 64
+}
+
+// DetectRuleConfig 定义了一条协议识别规则,Magic 与 Prefix 都为空时调用协议处理器实现的 Detect 方法
+type DetectRuleConfig struct {
+	Protocol string `json:"protocol"` // 协议处理器名称,通过 network.RegisterProtocol 注册
+	Magic    string `json:"magic"`    // 十六进制表示的特征字节,如 "68" 或 "7E 01"
+	Prefix   string `json:"prefix"`   // 文本前缀,如 "##"
+	Offset   int    `json:"offset"`   // Magic 或 Prefix 在数据中的偏移
+}
+
+// PollingConfig 定义了网关对不主动上报数据的设备(如 Modbus 设备)的轮询配置
+type PollingConfig struct {
+	Timeout   time.Duration      `json:"timeout"`   // 单次轮询等待响应的超时,默认 3s
+	MaxMisses int                `json:"maxMisses"` // 连续轮询失败多少次后将设备置为离线,默认 3
+	Devices   []PollDeviceConfig `json:"devices"`   // 被轮询的设备,用于按产品匹配设备和确定设备所在总线与从站地址
+	Groups    []PollGroupConfig  `json:"groups"`    // 轮询组
+}
+
+// PollDeviceConfig 定义了一个被轮询的设备
+type PollDeviceConfig struct {
+	DeviceKey  string `json:"deviceKey"`  // 设备标识
+	ProductKey string `json:"productKey"` // 产品标识,轮询组按产品匹配设备时使用
+	Bus        string `json:"bus"`        // 所在总线连接(串口、DTU)的设备标识,同一总线上的设备依次轮询并经该连接访问,为空时设备独立连接
+	Slave      string `json:"slave"`      // 总线上的从站地址,由协议处理器的 SlaveAddresser 补充到下发数据中,为空时使用设备标识
+}
+
+// PollGroupConfig 定义了一组按相同周期下发的轮询命令,DeviceKeys 与 ProductKey 匹配到的设备都会被轮询
+type PollGroupConfig struct {
+	Name       string              `json:"name"`       // 轮询组名称,用于日志
+	DeviceKeys []string            `json:"deviceKeys"` // 轮询的设备标识
+	ProductKey string              `json:"productKey"` // 轮询 Devices 中该产品的全部设备
+	Interval   time.Duration       `json:"interval"`   // 轮询周期,默认 60s
+	Jitter     time.Duration       `json:"jitter"`     // 每次轮询随机延后 0~Jitter,避免大量设备同时轮询
+	Commands   []PollCommandConfig `json:"commands"`   // 每个周期依次下发的命令
+}
+
+// PollCommandConfig 定义了一条轮询命令,Data 和 Params 原样交给协议处理器的 Encode
+type PollCommandConfig struct {
+	Data   interface{} `json:"data"`   // 下发数据
+	Params []string    `json:"params"` // 下发参数
+}
@@ -4,8 +4,22 @@ const (
 	PushAttributeDataToMQTT  = "PushAttributeDataToMQTT"  //属性上报
 	PushServiceResDataToMQTT = "PushServiceResDataToMQTT" //服务调用结果上报
 	PushSetResDataToMQTT     = "PushSetResDataToMQTT"     //属性设置结果上报
+	DeviceOnline             = "DeviceOnline"             //设备上线，事件数据中的 DeviceKey 为设备标识
+	DeviceOffline            = "DeviceOffline"            //设备离线，事件数据中的 DeviceKey 为设备标识
 
 	NetTypeTcpServer  = "tcp"
 	NetTypeUDPServer  = "udp"
 	NetTypeMqttServer = "mqtt"
+	NetTypeSerial     = "serial"
+	NetTypeTcpClient  = "tcp-client"
+	NetTypeWebSocket  = "ws"
+	NetTypeWebSocketS = "wss"
+	NetTypeHTTPServer = "http"
+	NetTypeCoAPServer = "coap"
+	NetTypeMqttBroker = "mqtt-broker"
+	NetTypeMQTTSN     = "mqtt-sn"
+	NetTypeSemtechUDP = "semtech-udp"
+	NetTypeLwM2M      = "lwm2m"
+	NetTypeSNMP       = "snmp"
+	NetTypeBACnet     = "bacnet"
 )
@@ -0,0 +1,185 @@
+package iotgateway
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/events"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// dispatcher 将平台的属性设置与服务调用按设备标识分发给设备所在的网络服务器或其协议处理器
+// 网关启动时注册为属性设置事件与各服务调用事件的监听器，退出时移除
+// 设备上线时订阅设备的属性设置与服务调用主题，离线时取消订阅；总线上的子设备没有自己的连接，在网关启动时订阅
+type dispatcher struct {
+	gw       *Gateway
+	ctx      context.Context
+	timeout  time.Duration
+	services []string
+}
+
+// newDispatcher 创建分发器，服务标识取自实现 network.ServiceCaller 的网络服务器与协议处理器
+func newDispatcher(ctx context.Context, gw *Gateway, protocols []network.ProtocolHandler) *dispatcher {
+	d := &dispatcher{gw: gw, ctx: ctx, timeout: gw.options.GatewayServerConfig.RequestTimeout}
+	if d.timeout <= 0 {
+		d.timeout = 10 * time.Second
+	}
+	callers := make([]interface{}, 0, len(protocols))
+	for _, protocol := range protocols {
+		callers = append(callers, protocol)
+	}
+	gw.mu.Lock()
+	for _, server := range gw.Servers {
+		callers = append(callers, server)
+	}
+	gw.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, caller := range callers {
+		if caller, ok := caller.(network.ServiceCaller); ok {
+			for _, service := range caller.Services() {
+				if !seen[service] {
+					seen[service] = true
+					d.services = append(d.services, service)
+				}
+			}
+		}
+	}
+	return d
+}
+
+// listen 注册事件监听，订阅总线上子设备的主题
+func (d *dispatcher) listen() {
+	event.On(events.PropertySetEvent, d, event.Normal)
+	for _, service := range d.services {
+		event.On(service, d, event.Normal)
+	}
+	event.On(consts.DeviceOnline, d, event.Normal)
+	event.On(consts.DeviceOffline, d, event.Normal)
+	for _, device := range d.gw.options.Polling.Devices {
+		if _, ok := d.gw.busRoute(device.DeviceKey); ok {
+			d.gw.subscribeDevice(device.DeviceKey)
+		}
+	}
+}
+
+// close 移除事件监听
+func (d *dispatcher) close() {
+	event.Std().RemoveListener(events.PropertySetEvent, d)
+	for _, service := range d.services {
+		event.Std().RemoveListener(service, d)
+	}
+	event.Std().RemoveListener(consts.DeviceOnline, d)
+	event.Std().RemoveListener(consts.DeviceOffline, d)
+}
+
+// Handle 实现 event.Listener 接口，在 MQTT 回调中触发，不阻塞等待设备完成
+func (d *dispatcher) Handle(e event.Event) error {
+	params := e.Data()
+	deviceKey := gconv.String(params["DeviceKey"])
+	switch e.Name() {
+	case consts.DeviceOnline:
+		// 其他网关的网络服务器也会触发上线事件，只订阅本网关监听中的设备
+		if server, _ := d.gw.lookupDevice(deviceKey); server != nil {
+			d.gw.subscribeDevice(deviceKey)
+		}
+		return nil
+	case consts.DeviceOffline:
+		// 设备可能仍在其他监听中在线，总线上的子设备经总线连接访问，保持订阅
+		if server, _ := d.gw.lookupDevice(deviceKey); server == nil {
+			if _, ok := d.gw.busRoute(deviceKey); !ok {
+				d.gw.unsubscribeDevice(deviceKey)
+			}
+		}
+		return nil
+	}
+
+	targets, device := d.route(deviceKey)
+	if device == nil {
+		glog.Debugf(d.ctx, "设备 %s 不在网关的监听中，忽略 %s 事件", deviceKey, e.Name())
+		return nil
+	}
+
+	if e.Name() == events.PropertySetEvent {
+		for _, target := range targets {
+			if setter, ok := target.(network.PropertySetter); ok {
+				go d.setProperties(setter, device, params)
+				return nil
+			}
+		}
+		return nil
+	}
+	for _, target := range targets {
+		if caller, ok := target.(network.ServiceCaller); ok && slices.Contains(caller.Services(), e.Name()) {
+			go d.callService(caller, device, e.Name(), params)
+			return nil
+		}
+	}
+	return nil
+}
+
+// route 按设备标识查找设备所在的网络服务器与设备的协议处理器
+// 总线上的子设备使用总线连接的网络服务器与协议处理器，设备以子设备的标识交给处理方，请求经网关按总线路由
+func (d *dispatcher) route(deviceKey string) ([]interface{}, *model.Device) {
+	server, device := d.gw.lookupDevice(deviceKey)
+	if server == nil {
+		route, ok := d.gw.busRoute(deviceKey)
+		if !ok {
+			return nil, nil
+		}
+		var bus *model.Device
+		if server, bus = d.gw.lookupDevice(route.Bus); server == nil {
+			return nil, nil
+		}
+		return []interface{}{server, server.(network.DeviceLocator).DeviceHandler(bus)}, &model.Device{DeviceKey: deviceKey}
+	}
+	return []interface{}{server, server.(network.DeviceLocator).DeviceHandler(device)}, device
+}
+
+// setProperties 写入属性，通过 PushSetResDataToMQTT 回复写入成功的属性
+func (d *dispatcher) setProperties(setter network.PropertySetter, device *model.Device, params map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+	reply, err := setter.SetProperties(ctx, d.gw, device, params)
+	if err != nil {
+		glog.Errorf(ctx, "设备 %s 属性设置失败: %v", device.DeviceKey, err)
+	}
+	if reply == nil {
+		reply = make(map[string]interface{})
+	}
+	if err, _ := event.Fire(consts.PushSetResDataToMQTT, g.Map{
+		"DeviceKey": device.DeviceKey,
+		"MessageID": params["MessageID"],
+		"ReplyData": reply,
+	}); err != nil {
+		glog.Debugf(ctx, "回复设备 %s 属性设置失败: %v", device.DeviceKey, err)
+	}
+}
+
+// callService 执行服务调用，通过 PushServiceResDataToMQTT 回复结果，失败时回复 error
+func (d *dispatcher) callService(caller network.ServiceCaller, device *model.Device, service string, params map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+	reply, err := caller.CallService(ctx, d.gw, device, service, params)
+	if err != nil {
+		glog.Errorf(ctx, "设备 %s 调用服务 %s 失败: %v", device.DeviceKey, service, err)
+		reply = g.Map{"error": err.Error()}
+	}
+	if reply == nil {
+		reply = make(map[string]interface{})
+	}
+	if err, _ := event.Fire(consts.PushServiceResDataToMQTT, g.Map{
+		"DeviceKey": device.DeviceKey,
+		"MessageID": params["MessageID"],
+		"ReplyData": reply,
+	}); err != nil {
+		glog.Debugf(ctx, "回复设备 %s 服务调用失败: %v", device.DeviceKey, err)
+	}
+}
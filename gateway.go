@@ -2,6 +2,7 @@ package iotgateway
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gogf/gf/v2/encoding/gjson"
@@ -14,12 +15,14 @@ import (
 	"github.com/sagoo-cloud/iotgateway/consts"
 	"github.com/sagoo-cloud/iotgateway/events"
 	"github.com/sagoo-cloud/iotgateway/log"
+	"github.com/sagoo-cloud/iotgateway/model"
 	"github.com/sagoo-cloud/iotgateway/mqttClient"
 	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
 	"github.com/sagoo-cloud/iotgateway/network"
 	"github.com/sagoo-cloud/iotgateway/vars"
 	"github.com/sagoo-cloud/iotgateway/version"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,9 +39,12 @@ type Gateway struct {
 	ctx        context.Context // 上下文
 	options    *conf.GatewayConfig
 	MQTTClient mqtt.Client
-	Server     network.NetworkServer
+	Server     network.NetworkServer            // 第一个启动的设备接入服务器
+	Servers    map[string]network.NetworkServer // 监听名称 -> 设备接入服务器
 	Protocol   network.ProtocolHandler
 	cancel     context.CancelFunc
+	mu         sync.Mutex
+	mqttDevice network.ProtocolHandler // mqtt 网络类型监听的协议处理器，未配置 mqtt 监听时为 nil
 }
 
 var ServerGateway *Gateway
@@ -97,61 +103,126 @@ func (gw *Gateway) Start() {
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	gw.mu.Lock()
+	gw.cancel = cancel
+	gw.mu.Unlock()
 
-	//订阅网关设备服务下发事件
+	//订阅网关设备属性设置与服务下发事件
+	gw.SubscribeSetEvent(gw.options.GatewayServerConfig.DeviceKey)
 	gw.SubscribeServiceEvent(gw.options.GatewayServerConfig.DeviceKey)
 
-	go gw.heartbeat(gw.options.GatewayServerConfig.Duration) //启动心跳
-	switch gw.options.GatewayServerConfig.NetType {
-	case consts.NetTypeTcpServer:
-		// 创建 TCP 服务器
-		gw.Server = network.NewTCPServer(
-			network.WithTimeout(1*time.Minute),
-			network.WithProtocolHandler(gw.Protocol),
-			network.WithCleanupInterval(5*time.Minute),
-			network.WithPacketHandling(gw.options.GatewayServerConfig.PacketConfig),
-		)
-		glog.Infof(ctx, "%s started Tcp listening on %v", name, gw.options.GatewayServerConfig.Addr)
-		// 启动 TCP 服务器
-		if err := gw.Server.Start(ctx, gw.options.GatewayServerConfig.Addr); err != nil {
-			log.Info("TCP 服务器错误: %v", err)
-		}
-
-	case consts.NetTypeUDPServer:
-		// 创建 UDP 服务器
-		gw.Server = network.NewUDPServer(
-			network.WithTimeout(1*time.Minute),
-			network.WithProtocolHandler(gw.Protocol),
-			network.WithCleanupInterval(5*time.Minute),
-		)
-		glog.Infof(ctx, "%s started UDP listening on %v", name, gw.options.GatewayServerConfig.Addr)
-		// 启动 UDP 服务器
-		if err := gw.Server.Start(ctx, gw.options.GatewayServerConfig.Addr); err != nil {
-			log.Info("UDP 服务器错误: %v", err)
-		}
-	case consts.NetTypeMqttServer:
-		//启动mqtt类型的设备网关服务
-		glog.Infof(context.Background(), "%s started listening ......", name)
-		//log.Info("%s started listening ......")
-		gw.SubscribeDeviceUpData()
-		select {}
+	go gw.heartbeat(ctx, gw.options.GatewayServerConfig.Duration) //启动心跳
+
+	// 未配置监听列表时使用 server 中的单个监听配置
+	listeners := gw.options.Listeners
+	if len(listeners) == 0 {
+		listeners = []conf.ListenerConfig{gw.options.GatewayServerConfig.Listener()}
 	}
 
-	return
+	var wg sync.WaitGroup
+	var protocols []network.ProtocolHandler
+	var starts []func() // 全部监听创建后再启动，设备接入前已注册属性设置与服务调用的分发
+	for _, listener := range listeners {
+		listenerName := listenerName(listener)
+		protocol, err := gw.listenerProtocol(listener)
+		if err != nil {
+			glog.Errorf(ctx, "监听 %s 配置错误: %v", listenerName, err)
+			continue
+		}
+		if protocol != nil {
+			protocols = append(protocols, protocol)
+		}
+		protocols = append(protocols, detectProtocols(listener.Detect)...)
+
+		if listener.NetType == consts.NetTypeMqttServer {
+			//启动mqtt类型的设备网关服务
+			glog.Infof(ctx, "%s started %s listening ......", name, listenerName)
+			gw.mu.Lock()
+			gw.mqttDevice = protocol
+			gw.mu.Unlock()
+			gw.subscribeDeviceUpData(protocol)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-ctx.Done()
+			}()
+			continue
+		}
+
+		server, err := newServer(listener, protocol)
+		if err != nil {
+			glog.Errorf(ctx, "监听 %s 配置错误: %v", listenerName, err)
+			continue
+		}
+		if err := gw.addServer(listenerName, server); err != nil {
+			glog.Errorf(ctx, "监听 %s 配置错误: %v", listenerName, err)
+			continue
+		}
+
+		starts = append(starts, func() {
+			addr := listener.Addr
+			switch listener.NetType {
+			case consts.NetTypeSerial:
+				addr = listener.Serial.Port
+			case consts.NetTypeTcpClient:
+				addr = "" // 远端地址在 tcpClient.remotes 中配置，Addr 默认是网关的监听地址
+			}
+			glog.Infof(ctx, "%s started %s listening on %v, tls: %v", name, listenerName, addr, listener.TLS.Enable)
+			if err := server.Start(ctx, addr); err != nil {
+				log.Info("监听 %s 错误: %v", listenerName, err)
+			}
+		})
+	}
+
+	// 平台的属性设置与服务调用分发给设备所在的网络服务器或其协议处理器
+	dispatcher := newDispatcher(ctx, gw, protocols)
+	dispatcher.listen()
+	defer dispatcher.close()
+
+	for _, start := range starts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start()
+		}()
+	}
+
+	// 定时轮询不主动上报数据的设备
+	if tasks := pollTasks(gw.options.Polling); len(tasks) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newPoller(gw, gw.options.Polling).run(ctx, tasks)
+		}()
+	}
+	wg.Wait()
+}
+
+// Stop 停止网关的全部监听，各服务器随上下文取消退出，Start 在所有监听退出后返回
+func (gw *Gateway) Stop() {
+	gw.mu.Lock()
+	cancel := gw.cancel
+	gw.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
 }
 
 // heartbeat 网关服务心跳
-func (gw *Gateway) heartbeat(duration time.Duration) {
+func (gw *Gateway) heartbeat(ctx context.Context, duration time.Duration) {
 	if duration == 0 {
 		duration = 60
 	}
 	ticker := time.NewTicker(time.Second * duration)
+	defer ticker.Stop()
 
 	// 立即发送一次心跳消息
 	gw.sendHeartbeat()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			// 发送心跳消息
 			gw.sendHeartbeat()
@@ -195,13 +266,18 @@ func (gw *Gateway) sendHeartbeat() {
 
 // SubscribeDeviceUpData 在mqtt网络类型的设备情况下，订阅设备上传数据
 func (gw *Gateway) SubscribeDeviceUpData() {
+	gw.subscribeDeviceUpData(gw.Protocol)
+}
+
+// subscribeDeviceUpData 订阅设备上传数据，交给指定的协议处理器解码
+func (gw *Gateway) subscribeDeviceUpData(protocol network.ProtocolHandler) {
 	if gw.MQTTClient == nil || !gw.MQTTClient.IsConnected() {
 		log.Error("【IotGateway】SubscribeDeviceUpData error: Client has lost connection with the MQTT broker.")
 		return
 	}
 	log.Debug("订阅设备上传数据topic: ", gw.options.GatewayServerConfig.SerUpTopic)
 	if gw.options.GatewayServerConfig.SerUpTopic != "" {
-		token := gw.MQTTClient.Subscribe(gw.options.GatewayServerConfig.SerUpTopic, 1, onDeviceUpDataMessage)
+		token := gw.MQTTClient.Subscribe(gw.options.GatewayServerConfig.SerUpTopic, 1, onDeviceUpDataMessage(protocol))
 		if token.Error() != nil {
 			log.Debug("subscribe error: ", token.Error())
 		}
@@ -210,26 +286,226 @@ func (gw *Gateway) SubscribeDeviceUpData() {
 }
 
 // onDeviceUpDataMessage 设备上传数据
-var onDeviceUpDataMessage mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-	//忽略_reply结尾的topic
-	if strings.HasSuffix(msg.Topic(), "_reply") {
+func onDeviceUpDataMessage(protocol network.ProtocolHandler) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		//忽略_reply结尾的topic
+		if strings.HasSuffix(msg.Topic(), "_reply") {
+			return
+		}
+		if msg != nil {
+			protocol.Decode(nil, msg.Payload())
+		}
+	}
+}
+
+// subscribeDevice 订阅接入设备的属性设置与服务调用主题，未连接平台时忽略
+func (gw *Gateway) subscribeDevice(deviceKey string) {
+	if gw.MQTTClient == nil || deviceKey == "" {
+		return
+	}
+	gw.SubscribeSetEvent(deviceKey)
+	gw.SubscribeServiceEvent(deviceKey)
+}
+
+// unsubscribeDevice 取消订阅设备的属性设置与服务调用主题
+func (gw *Gateway) unsubscribeDevice(deviceKey string) {
+	if gw.MQTTClient == nil || !gw.MQTTClient.IsConnected() || deviceKey == "" {
 		return
 	}
-	if msg != nil {
-		ServerGateway.Protocol.Decode(nil, msg.Payload())
+	setSubscriptions.Delete(deviceKey)
+	token := gw.MQTTClient.Unsubscribe(fmt.Sprintf(setTopic, deviceKey), fmt.Sprintf(serviceTopic, deviceKey))
+	if token.Error() != nil {
+		glog.Debugf(context.Background(), "取消订阅设备 %s 的主题失败: %v", deviceKey, token.Error())
 	}
 }
 
 // DeviceDownData 在mqtt网络类型的设备情况下，向设备下发数据
 func (gw *Gateway) DeviceDownData(data interface{}) {
+	if err := gw.publishDownData(gw.options.GatewayServerConfig.SerDownTopic, data); err != nil {
+		glog.Errorf(context.Background(), "【IotGateway】DeviceDownData error: %s", err)
+	}
+}
+
+// publishDownData 将下发数据发布到上行平台 Broker 的指定主题，主题为空时忽略
+func (gw *Gateway) publishDownData(topic string, data interface{}) error {
 	if gw.MQTTClient == nil || !gw.MQTTClient.IsConnected() {
-		log.Error("【IotGateway】DeviceDownData error: Client has lost connection with the MQTT broker.")
-		return
+		return errors.New("MQTT 客户端未连接")
 	}
-	if gw.options.GatewayServerConfig.SerDownTopic != "" {
-		token := gw.MQTTClient.Publish(gw.options.GatewayServerConfig.SerDownTopic, 1, false, data)
-		if token.Error() != nil {
-			log.Error("publish error: %s", token.Error())
+	if topic == "" {
+		return nil
+	}
+	token := gw.MQTTClient.Publish(topic, 1, false, data)
+	token.Wait()
+	return token.Error()
+}
+
+// SendToDevice 按设备标识向设备下发数据，依次在所有监听中查找设备当前的连接
+// 设备没有连接但在轮询配置中属于某条总线时，经总线连接下发，见 SendToSlave
+// 设备从未接入时返回 network.ErrDeviceNotFound，曾经接入但已断开时返回 network.ErrDeviceOffline
+// 配置了 mqtt 网络类型的监听且其他监听中没有该设备时，经协议处理器编码后发布到 serDownTopic，主题中的 {deviceKey} 替换为设备标识
+func (gw *Gateway) SendToDevice(ctx context.Context, deviceKey string, data interface{}, params ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if server, _ := gw.lookupDevice(deviceKey); server == nil {
+		if route, ok := gw.busRoute(deviceKey); ok {
+			return gw.SendToSlave(ctx, route.Bus, route.Slave, data, params...)
+		}
+	}
+	return gw.sendToDevice(ctx, deviceKey, data, params...)
+}
+
+// sendToDevice 向设备标识对应的连接下发数据，不经总线路由
+func (gw *Gateway) sendToDevice(ctx context.Context, deviceKey string, data interface{}, params ...string) error {
+	server, device := gw.lookupDevice(deviceKey)
+	if server == nil {
+		return gw.sendToMQTTDevice(deviceKey, data, params...)
+	}
+
+	// 部分网络类型(如 CoAP)下发时需要等待设备确认，上下文取消时不再等待
+	done := make(chan error, 1)
+	go func() {
+		done <- server.SendData(device, data, params...)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Request 按设备标识向设备下发请求并等待响应，返回协议处理器在 Decode 中通过 network.Respond 交回的响应
+// 调用方通过 ctx 控制超时；同一设备的请求按协议处理器实现的 RequestKeyProvider/RequestPipeliner 串行或流水线执行
+// 设备没有连接但在轮询配置中属于某条总线时，经总线连接请求，见 RequestSlave
+func (gw *Gateway) Request(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error) {
+	if server, _ := gw.lookupDevice(deviceKey); server == nil {
+		if route, ok := gw.busRoute(deviceKey); ok {
+			return gw.RequestSlave(ctx, route.Bus, route.Slave, data, params...)
 		}
 	}
+	return gw.request(ctx, deviceKey, data, params...)
+}
+
+// request 向设备标识对应的连接下发请求并等待响应，不经总线路由
+func (gw *Gateway) request(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error) {
+	var protocol network.ProtocolHandler
+	server, device := gw.lookupDevice(deviceKey)
+	if server != nil {
+		protocol = server.(network.DeviceLocator).DeviceHandler(device)
+	} else {
+		gw.mu.Lock()
+		protocol = gw.mqttDevice
+		gw.mu.Unlock()
+		device = &model.Device{DeviceKey: deviceKey}
+	}
+
+	// 先登记请求再下发，避免响应先于登记到达
+	request, err := network.BeginRequest(ctx, deviceKey, protocol, device, data, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer request.Done()
+	if err := gw.sendToDevice(ctx, deviceKey, data, params...); err != nil {
+		return nil, err
+	}
+	return request.Wait(ctx)
+}
+
+// SendToSlave 经总线连接(RS-485 串口、DTU)向总线上的子设备下发数据
+// busKey 为总线连接的设备标识，slave 为从站地址，由协议处理器实现的 network.SlaveAddresser 补充到下发数据中
+func (gw *Gateway) SendToSlave(ctx context.Context, busKey, slave string, data interface{}, params ...string) error {
+	data, err := gw.addressSlave(busKey, slave, data)
+	if err != nil {
+		return err
+	}
+	return gw.sendToDevice(ctx, busKey, data, params...)
+}
+
+// RequestSlave 经总线连接向总线上的子设备下发请求并等待响应，请求按总线连接的设备标识关联，同一总线上的请求由协议处理器串行或流水线执行
+func (gw *Gateway) RequestSlave(ctx context.Context, busKey, slave string, data interface{}, params ...string) (interface{}, error) {
+	data, err := gw.addressSlave(busKey, slave, data)
+	if err != nil {
+		return nil, err
+	}
+	return gw.request(ctx, busKey, data, params...)
+}
+
+// addressSlave 由总线连接的协议处理器为下发数据补充从站地址，总线不在线或协议处理器未实现 network.SlaveAddresser 时原样返回
+func (gw *Gateway) addressSlave(busKey, slave string, data interface{}) (interface{}, error) {
+	server, device := gw.lookupDevice(busKey)
+	if server == nil {
+		return data, nil
+	}
+	addresser, ok := server.(network.DeviceLocator).DeviceHandler(device).(network.SlaveAddresser)
+	if !ok {
+		return data, nil
+	}
+	data, err := addresser.AddressSlave(data, slave)
+	if err != nil {
+		return nil, fmt.Errorf("总线 %s 从站 %s 寻址失败: %w", busKey, slave, err)
+	}
+	return data, nil
+}
+
+// busRoute 在轮询配置中查找子设备所在的总线，从站地址未配置时使用设备标识
+func (gw *Gateway) busRoute(deviceKey string) (conf.PollDeviceConfig, bool) {
+	if gw.options == nil {
+		return conf.PollDeviceConfig{}, false
+	}
+	for _, device := range gw.options.Polling.Devices {
+		if device.DeviceKey != deviceKey || device.Bus == "" || device.Bus == deviceKey {
+			continue
+		}
+		if device.Slave == "" {
+			device.Slave = deviceKey
+		}
+		return device, true
+	}
+	return conf.PollDeviceConfig{}, false
+}
+
+// lookupDevice 在所有监听中查找设备当前的连接，下发数据可缓存的网络服务器同时返回离线期间仍可寻址的设备
+func (gw *Gateway) lookupDevice(deviceKey string) (network.NetworkServer, *model.Device) {
+	gw.mu.Lock()
+	servers := make([]network.NetworkServer, 0, len(gw.Servers))
+	for _, server := range gw.Servers {
+		servers = append(servers, server)
+	}
+	gw.mu.Unlock()
+
+	for _, server := range servers {
+		if locator, ok := server.(network.DeviceLocator); ok {
+			if device := locator.LookupDevice(deviceKey); device != nil {
+				return server, device
+			}
+		}
+	}
+	return nil, nil
+}
+
+// sendToMQTTDevice 未找到设备连接时，通过 mqtt 网络类型监听下发，没有 mqtt 监听时返回设备不存在或不在线
+func (gw *Gateway) sendToMQTTDevice(deviceKey string, data interface{}, params ...string) error {
+	gw.mu.Lock()
+	protocol := gw.mqttDevice
+	gw.mu.Unlock()
+	if protocol == nil {
+		if _, err := vars.GetDevice(deviceKey); err != nil {
+			return fmt.Errorf("%w: %s", network.ErrDeviceNotFound, deviceKey)
+		}
+		return fmt.Errorf("%w: %s", network.ErrDeviceOffline, deviceKey)
+	}
+
+	payload, err := protocol.Encode(&model.Device{DeviceKey: deviceKey}, data, params...)
+	if err != nil {
+		return fmt.Errorf("编码数据失败: %v", err)
+	}
+	topic := strings.ReplaceAll(gw.options.GatewayServerConfig.SerDownTopic, "{deviceKey}", deviceKey)
+	if topic == "" {
+		return errors.New("未配置 serDownTopic")
+	}
+	if err := gw.publishDownData(topic, payload); err != nil {
+		return fmt.Errorf("%w: %v", network.ErrDeviceOffline, err)
+	}
+	return nil
 }
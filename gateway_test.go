@@ -0,0 +1,582 @@
+package iotgateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/events"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// prefixProtocol 测试用协议处理器，回复时加上协议名称前缀
+type prefixProtocol struct {
+	name string
+}
+
+func (p *prefixProtocol) Init(device *model.Device, data []byte) error {
+	return nil
+}
+
+func (p *prefixProtocol) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	return data.([]byte), nil
+}
+
+func (p *prefixProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	return append([]byte(p.name+":"), data...), nil
+}
+
+func freeAddr(t *testing.T, network string) string {
+	if network == "udp" {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// startGateway 启动网关，测试结束时停止网关并等待 Start 返回，避免与后续测试的网关同时注册事件监听
+func startGateway(t *testing.T, gw *Gateway) {
+	done := make(chan struct{})
+	go func() {
+		gw.Start()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		gw.Stop()
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Error("Stop 后 Start 未返回")
+		}
+	})
+}
+
+func TestGatewayMultipleListeners(t *testing.T) {
+	network.RegisterProtocol("line", &prefixProtocol{name: "line"})
+	network.RegisterProtocol("datagram", &prefixProtocol{name: "datagram"})
+	tcpAddr, udpAddr := freeAddr(t, "tcp"), freeAddr(t, "udp")
+
+	gw := &Gateway{options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{Name: "tcp", NetType: consts.NetTypeTcpServer, Addr: tcpAddr, Protocol: "line",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+			{Name: "udp", NetType: consts.NetTypeUDPServer, Addr: udpAddr, Protocol: "datagram"},
+			{Name: "bad", NetType: consts.NetTypeTcpServer, Addr: freeAddr(t, "tcp"), Protocol: "missing"},
+		},
+	}}
+	done := make(chan struct{})
+	go func() {
+		gw.Start()
+		close(done)
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", tcpAddr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a\n"))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "line:a\n" {
+		t.Fatalf("TCP 监听的回复不正确: %q %v", line, err)
+	}
+
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	udp.Write([]byte("b"))
+	udp.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buffer := make([]byte, 64)
+	if n, err := udp.Read(buffer); err != nil || string(buffer[:n]) != "datagram:b" {
+		t.Fatalf("UDP 监听的回复不正确: %q %v", buffer[:n], err)
+	}
+
+	gw.mu.Lock()
+	servers := len(gw.Servers)
+	gw.mu.Unlock()
+	if servers != 2 {
+		t.Fatalf("协议处理器未注册的监听不应启动，实际启动 %d 个", servers)
+	}
+
+	gw.Stop()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop 后 Start 未返回")
+	}
+}
+
+// loginProtocol 测试用协议处理器，连接的第一帧数据为设备标识
+type loginProtocol struct {
+	prefixProtocol
+}
+
+func (p *loginProtocol) Init(device *model.Device, data []byte) error {
+	if device != nil && device.DeviceKey == "" {
+		device.DeviceKey = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+func (p *loginProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func TestGatewaySendToDevice(t *testing.T) {
+	network.RegisterProtocol("login", &loginProtocol{})
+	tcpAddr, udpAddr := freeAddr(t, "tcp"), freeAddr(t, "udp")
+	gw := &Gateway{options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{NetType: consts.NetTypeTcpServer, Addr: tcpAddr, Protocol: "login",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+			{NetType: consts.NetTypeUDPServer, Addr: udpAddr, Protocol: "login"},
+		},
+	}}
+	startGateway(t, gw)
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", tcpAddr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	conn.Write([]byte("send-tcp-001\n"))
+	udp.Write([]byte("send-udp-001"))
+
+	ctx := context.Background()
+	online := func(deviceKey string) bool {
+		server, _ := gw.lookupDevice(deviceKey)
+		return server != nil
+	}
+	for i := 0; i < 100 && !(online("send-tcp-001") && online("send-udp-001")); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := gw.SendToDevice(ctx, "send-tcp-001", []byte("tcp-down\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "tcp-down\n" {
+		t.Fatalf("TCP 设备收到 %q %v", line, err)
+	}
+	if err := gw.SendToDevice(ctx, "send-udp-001", []byte("udp-down")); err != nil {
+		t.Fatal(err)
+	}
+	udp.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buffer := make([]byte, 64)
+	if n, err := udp.Read(buffer); err != nil || string(buffer[:n]) != "udp-down" {
+		t.Fatalf("UDP 设备收到 %q %v", buffer[:n], err)
+	}
+
+	if err := gw.SendToDevice(ctx, "send-unknown", []byte("x")); !errors.Is(err, network.ErrDeviceNotFound) {
+		t.Fatalf("未知设备应返回 ErrDeviceNotFound，实际 %v", err)
+	}
+	conn.Close()
+	for i := 0; i < 100 && online("send-tcp-001"); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := gw.SendToDevice(ctx, "send-tcp-001", []byte("x")); !errors.Is(err, network.ErrDeviceOffline) {
+		t.Fatalf("离线设备应返回 ErrDeviceOffline，实际 %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := gw.SendToDevice(cancelled, "send-udp-001", []byte("x")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("上下文已取消时应返回 context.Canceled，实际 %v", err)
+	}
+
+	// mqtt 网络类型的设备经上行平台 Broker 下发，未连接时视为不在线
+	gw.mu.Lock()
+	gw.mqttDevice = &loginProtocol{}
+	gw.mu.Unlock()
+	gw.options.GatewayServerConfig.SerDownTopic = "device/{deviceKey}/down"
+	if err := gw.SendToDevice(ctx, "send-mqtt-001", []byte("x")); !errors.Is(err, network.ErrDeviceOffline) {
+		t.Fatalf("MQTT 未连接时应返回 ErrDeviceOffline，实际 %v", err)
+	}
+}
+
+// seqProtocol 测试用协议处理器，数据格式为 "序列号:内容"，序列号作为请求的关联键
+type seqProtocol struct {
+	loginProtocol
+}
+
+func (p *seqProtocol) RequestKey(device *model.Device, data interface{}, param ...string) string {
+	seq, _, _ := strings.Cut(string(data.([]byte)), ":")
+	return seq
+}
+
+func (p *seqProtocol) MaxInflight() int {
+	return 4
+}
+
+func (p *seqProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	if seq, content, ok := strings.Cut(string(data), ":"); ok {
+		network.Respond(device.DeviceKey, seq, strings.TrimSpace(content))
+	}
+	return nil, nil
+}
+
+func TestGatewayRequest(t *testing.T) {
+	network.RegisterProtocol("seq", &seqProtocol{})
+	addr := freeAddr(t, "tcp")
+	gw := &Gateway{options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{NetType: consts.NetTypeTcpServer, Addr: addr, Protocol: "seq",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+		},
+	}}
+	startGateway(t, gw)
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("req-tcp-001\n"))
+	for i := 0; i < 100; i++ {
+		if server, _ := gw.lookupDevice("req-tcp-001"); server != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 设备收齐两个请求后倒序回复
+	go func() {
+		reader := bufio.NewReader(conn)
+		var requests []string
+		for len(requests) < 2 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			requests = append(requests, strings.TrimSpace(line))
+		}
+		for i := len(requests) - 1; i >= 0; i-- {
+			seq, _, _ := strings.Cut(requests[i], ":")
+			conn.Write([]byte(seq + ":re-" + requests[i] + "\n"))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	results := make(chan string, 2)
+	for _, seq := range []string{"1", "2"} {
+		go func(seq string) {
+			response, err := gw.Request(ctx, "req-tcp-001", []byte(seq+":get\n"))
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- seq + "=" + response.(string)
+		}(seq)
+	}
+	got := map[string]bool{<-results: true, <-results: true}
+	if !got["1=re-1:get"] || !got["2=re-2:get"] {
+		t.Fatalf("响应与请求不匹配: %v", got)
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := gw.Request(timeout, "req-tcp-001", []byte("3:get\n")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("设备未响应时应超时，实际 %v", err)
+	}
+	if _, err := gw.Request(ctx, "req-unknown", []byte("4:get\n")); !errors.Is(err, network.ErrDeviceNotFound) {
+		t.Fatalf("未知设备应返回 ErrDeviceNotFound，实际 %v", err)
+	}
+}
+
+// dispatchProtocol 测试用协议处理器，属性设置经请求写入设备，服务调用直接返回参数
+type dispatchProtocol struct {
+	seqProtocol
+}
+
+func (p *dispatchProtocol) SetProperties(ctx context.Context, requester network.Requester, device *model.Device, properties map[string]interface{}) (map[string]interface{}, error) {
+	response, err := requester.Request(ctx, device.DeviceKey, []byte("9:set\n"))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"power": properties["power"], "result": response}, nil
+}
+
+func (p *dispatchProtocol) Services() []string {
+	return []string{"dispatchEcho", "dispatchFail"}
+}
+
+func (p *dispatchProtocol) CallService(ctx context.Context, requester network.Requester, device *model.Device, service string, params map[string]interface{}) (map[string]interface{}, error) {
+	if service == "dispatchFail" {
+		return nil, errors.New("failed")
+	}
+	return map[string]interface{}{"value": params["value"]}, nil
+}
+
+func TestDispatcherDetectProtocols(t *testing.T) {
+	// 共享端口时识别出的协议处理器提供的服务同样需要分发，未注册的协议处理器忽略
+	network.RegisterProtocol("dispatch", &dispatchProtocol{})
+	protocols := detectProtocols(conf.DetectConfig{
+		Rules:    []conf.DetectRuleConfig{{Protocol: "dispatch-unregistered"}, {Protocol: "dispatch", Prefix: "#"}},
+		Fallback: "dispatch",
+	})
+	d := newDispatcher(context.Background(), &Gateway{options: &conf.GatewayConfig{}}, protocols)
+	if got := strings.Join(d.services, " "); got != "dispatchEcho dispatchFail" {
+		t.Fatalf("分发的服务 %s", got)
+	}
+}
+
+func TestGatewayDispatcher(t *testing.T) {
+	network.RegisterProtocol("dispatch", &dispatchProtocol{})
+	addr := freeAddr(t, "tcp")
+	gw := &Gateway{options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{NetType: consts.NetTypeTcpServer, Addr: addr, Protocol: "dispatch",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+		},
+	}}
+	replies := make(chan event.Event, 4)
+	record := event.ListenerFunc(func(e event.Event) error {
+		if e.Data()["DeviceKey"] == "dispatch-001" {
+			select {
+			case replies <- e:
+			default:
+			}
+		}
+		return nil
+	})
+	event.On(consts.PushSetResDataToMQTT, record)
+	event.On(consts.PushServiceResDataToMQTT, record)
+	done := make(chan struct{})
+	go func() {
+		gw.Start()
+		close(done)
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("dispatch-001\n"))
+	for i := 0; i < 100; i++ {
+		if server, _ := gw.lookupDevice("dispatch-001"); server != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			seq, _, _ := strings.Cut(line, ":")
+			conn.Write([]byte(seq + ":ok\n"))
+		}
+	}()
+
+	expect := func(name string, data map[string]interface{}) {
+		t.Helper()
+		select {
+		case e := <-replies:
+			if e.Name() != name || e.Data()["MessageID"] != "m1" || !reflect.DeepEqual(e.Data()["ReplyData"], data) {
+				t.Fatalf("回复 %s %v", e.Name(), e.Data())
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("没有回复 %s", name)
+		}
+	}
+
+	// 不在网关监听中的设备不处理
+	event.MustFire(events.PropertySetEvent, event.M{"DeviceKey": "dispatch-unknown", "MessageID": "m0", "power": 1})
+	event.MustFire(events.PropertySetEvent, event.M{"DeviceKey": "dispatch-001", "MessageID": "m1", "power": 1})
+	expect(consts.PushSetResDataToMQTT, map[string]interface{}{"power": 1, "result": "ok"})
+	event.MustFire("dispatchEcho", event.M{"DeviceKey": "dispatch-001", "MessageID": "m1", "value": true})
+	expect(consts.PushServiceResDataToMQTT, map[string]interface{}{"value": true})
+	event.MustFire("dispatchFail", event.M{"DeviceKey": "dispatch-001", "MessageID": "m1"})
+	expect(consts.PushServiceResDataToMQTT, map[string]interface{}{"error": "failed"})
+
+	// 网关退出后移除监听
+	gw.Stop()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop 后 Start 未返回")
+	}
+	if event.HasListeners("dispatchEcho") {
+		t.Fatal("网关退出后应移除服务调用的监听")
+	}
+}
+
+// mqttTestClient 连接测试用 MQTT Broker 的客户端
+func mqttTestClient(t *testing.T, addr, clientID string) mqtt.Client {
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + addr).SetClientID(clientID))
+	var err error
+	for i := 0; i < 50; i++ {
+		if token := client.Connect(); token.WaitTimeout(time.Second) && token.Error() == nil {
+			t.Cleanup(func() { client.Disconnect(0) })
+			return client
+		} else {
+			err = token.Error()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("连接 MQTT Broker 失败: %v", err)
+	return nil
+}
+
+func TestGatewayDispatcherMQTT(t *testing.T) {
+	network.RegisterProtocol("dispatch", &dispatchProtocol{})
+	// 平台的 MQTT Broker，不使用网关的内置 Broker，以免其设备上下线事件与测试注册监听并发
+	brokerAddr := freeAddr(t, "tcp")
+	broker := mochi.New(&mochi.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	broker.AddHook(new(auth.AllowHook), nil)
+	if err := broker.AddListener(listeners.NewTCP(listeners.Config{ID: "platform", Address: brokerAddr})); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	platform := mqttTestClient(t, brokerAddr, "platform")
+
+	addr := freeAddr(t, "tcp")
+	gw := &Gateway{MQTTClient: mqttTestClient(t, brokerAddr, "gateway"), options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{NetType: consts.NetTypeTcpServer, Addr: addr, Protocol: "dispatch",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+		},
+	}}
+	replies := make(chan event.Event, 8)
+	event.On(consts.PushSetResDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		if e.Data()["DeviceKey"] == "dispatch-mqtt" {
+			select {
+			case replies <- e:
+			default:
+			}
+		}
+		return nil
+	}))
+	startGateway(t, gw)
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("dispatch-mqtt\n"))
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			seq, _, _ := strings.Cut(line, ":")
+			conn.Write([]byte(seq + ":ok\n"))
+		}
+	}()
+
+	// 设备上线后网关订阅设备的属性设置主题，平台经该主题下发的属性设置写入设备
+	publishSet := func(id string) {
+		payload := `{"id":"` + id + `","version":"1.0","method":"thing.service.property.set","params":{"power":1}}`
+		platform.Publish("/sys/dispatch/dispatch-mqtt/thing/service/property/set", 1, false, payload).WaitTimeout(time.Second)
+	}
+	var reply event.Event
+	for i := 0; reply == nil; i++ {
+		if i == 30 {
+			t.Fatal("经 MQTT 主题下发的属性设置没有回复")
+		}
+		publishSet("m1")
+		select {
+		case reply = <-replies:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	data := reply.Data()
+	if data["MessageID"] != "m1" || gconv.Int(data["ReplyData"].(map[string]interface{})["power"]) != 1 || data["ReplyData"].(map[string]interface{})["result"] != "ok" {
+		t.Fatalf("属性设置回复 %v", data)
+	}
+
+	// 设备离线后取消订阅
+	conn.Close()
+	for i := 0; i < 100; i++ {
+		if server, _ := gw.lookupDevice("dispatch-mqtt"); server == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for len(replies) > 0 {
+		<-replies
+	}
+	publishSet("m2")
+	select {
+	case e := <-replies:
+		t.Fatalf("设备离线后不应再收到属性设置: %v", e.Data())
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,171 @@
+package iotgateway
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// newServer 根据监听配置创建设备接入服务器
+func newServer(listener conf.ListenerConfig, protocol network.ProtocolHandler) (network.NetworkServer, error) {
+	options := []network.Option{
+		network.WithTimeout(1 * time.Minute),
+		network.WithProtocolHandler(protocol),
+		network.WithCleanupInterval(5 * time.Minute),
+	}
+
+	switch listener.NetType {
+	case consts.NetTypeTcpServer:
+		return network.NewTCPServer(append(options,
+			network.WithPacketHandling(listener.PacketConfig),
+			network.WithTLSConfig(listener.TLS),
+			network.WithDetectConfig(listener.Detect),
+		)...), nil
+
+	case consts.NetTypeTcpClient:
+		// 客户端模式只连接 tcpClient.remotes 中的远端，不使用 Addr
+		return network.NewTCPClient(append(options,
+			network.WithPacketHandling(listener.PacketConfig),
+			network.WithTCPClientConfig(listener.TCPClient),
+			network.WithDetectConfig(listener.Detect),
+		)...), nil
+
+	case consts.NetTypeWebSocket, consts.NetTypeWebSocketS:
+		// wss 时强制启用 TLS
+		tlsConfig := listener.TLS
+		if listener.NetType == consts.NetTypeWebSocketS {
+			tlsConfig.Enable = true
+		}
+		return network.NewWebSocketServer(append(options,
+			network.WithTLSConfig(tlsConfig),
+			network.WithWebSocketConfig(listener.WebSocket),
+		)...), nil
+
+	case consts.NetTypeHTTPServer:
+		return network.NewHTTPServer(append(options,
+			network.WithTLSConfig(listener.TLS),
+			network.WithHTTPConfig(listener.HTTP),
+		)...), nil
+
+	case consts.NetTypeCoAPServer:
+		return network.NewCoAPServer(append(options,
+			network.WithCoAPConfig(listener.CoAP),
+		)...), nil
+
+	case consts.NetTypeUDPServer:
+		return network.NewUDPServer(options...), nil
+
+	case consts.NetTypeSerial:
+		return network.NewSerialServer(append(options,
+			network.WithPacketHandling(listener.PacketConfig),
+			network.WithSerialConfig(listener.Serial),
+		)...), nil
+
+	case consts.NetTypeMqttBroker:
+		// 现场设备连接网关内置的 Broker，与上行平台的 MQTT 连接相互独立
+		return network.NewMQTTBroker(append(options,
+			network.WithTLSConfig(listener.TLS),
+			network.WithMQTTBrokerConfig(listener.MQTTBroker),
+		)...), nil
+
+	case consts.NetTypeMQTTSN:
+		return network.NewMQTTSNGateway(append(options,
+			network.WithMQTTSNConfig(listener.MQTTSN),
+		)...), nil
+
+	case consts.NetTypeSemtechUDP:
+		return network.NewSemtechUDPServer(append(options,
+			network.WithSemtechConfig(listener.Semtech),
+		)...), nil
+
+	case consts.NetTypeLwM2M:
+		return network.NewLwM2MServer(append(options,
+			network.WithCoAPConfig(listener.CoAP),
+			network.WithLwM2MConfig(listener.LwM2M),
+		)...), nil
+
+	case consts.NetTypeSNMP:
+		return network.NewSNMPServer(append(options,
+			network.WithSNMPConfig(listener.SNMP),
+		)...), nil
+
+	case consts.NetTypeBACnet:
+		return network.NewBACnetServer(append(options,
+			network.WithBACnetConfig(listener.BACnet),
+		)...), nil
+	}
+	return nil, fmt.Errorf("不支持的网络类型: %s", listener.NetType)
+}
+
+// listenerName 获取监听名称，未配置时为 netType@addr
+func listenerName(listener conf.ListenerConfig) string {
+	if listener.Name != "" {
+		return listener.Name
+	}
+	addr := listener.Addr
+	if listener.NetType == consts.NetTypeSerial {
+		addr = listener.Serial.Port
+	}
+	return listener.NetType + "@" + addr
+}
+
+// listenerProtocol 获取监听使用的协议处理器，未指定名称时使用创建网关时传入的协议处理器
+func (gw *Gateway) listenerProtocol(listener conf.ListenerConfig) (network.ProtocolHandler, error) {
+	if listener.Protocol == "" {
+		if listener.NetType == consts.NetTypeMQTTSN {
+			return nil, nil // 未指定协议处理器时由 MQTT-SN 网关将发布的消息转换为属性
+		}
+		if listener.NetType == consts.NetTypeLwM2M {
+			return nil, nil // 未指定协议处理器时由 LwM2M 服务器按资源映射转换为属性
+		}
+		if listener.NetType == consts.NetTypeSNMP {
+			return nil, nil // SNMP 管理端按对象映射转换为属性，不使用协议处理器
+		}
+		if listener.NetType == consts.NetTypeBACnet {
+			return nil, nil // BACnet 客户端按对象映射转换为属性，不使用协议处理器
+		}
+		if gw.Protocol == nil {
+			return nil, fmt.Errorf("未设置协议处理器")
+		}
+		return gw.Protocol, nil
+	}
+	protocol, ok := network.GetProtocol(listener.Protocol)
+	if !ok {
+		return nil, fmt.Errorf("协议处理器 %s 未注册", listener.Protocol)
+	}
+	return protocol, nil
+}
+
+// detectProtocols 获取协议识别规则与 Fallback 指定的协议处理器，未注册的忽略(识别时同样跳过)
+func detectProtocols(config conf.DetectConfig) []network.ProtocolHandler {
+	var protocols []network.ProtocolHandler
+	for _, rule := range config.Rules {
+		if protocol, ok := network.GetProtocol(rule.Protocol); ok {
+			protocols = append(protocols, protocol)
+		}
+	}
+	if protocol, ok := network.GetProtocol(config.Fallback); config.Fallback != "" && ok {
+		protocols = append(protocols, protocol)
+	}
+	return protocols
+}
+
+// addServer 记录已创建的设备接入服务器，第一个服务器同时赋值给 Server
+func (gw *Gateway) addServer(name string, server network.NetworkServer) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if _, ok := gw.Servers[name]; ok {
+		return fmt.Errorf("监听名称 %s 重复", name)
+	}
+	if gw.Servers == nil {
+		gw.Servers = make(map[string]network.NetworkServer)
+	}
+	gw.Servers[name] = server
+	if gw.Server == nil {
+		gw.Server = server
+	}
+	return nil
+}
@@ -0,0 +1,793 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/bacnet"
+)
+
+const (
+	bacnetDefaultPort     = 47808
+	bacnetMaxDatagramSize = 64 * 1024
+)
+
+// ErrBACnetTimeout BACnet 请求重试次数用尽仍未收到响应
+var ErrBACnetTimeout = errors.New("BACnet 请求超时")
+
+// BACnetServer 结构体表示 BACnet/IP 客户端，用于接入楼宇自控系统中的空调控制器、DDC 等设备
+// 设备按配置的地址访问或通过 Who-Is/I-Am 发现，按对象映射以 ReadPropertyMultiple(设备不支持时为 ReadProperty)定时读取属性，
+// 并订阅对象的 COV 通知，值变化时立即上报；平台的属性设置与 SendData 以 WriteProperty 写入。
+// 设备以配置的设备标识作为子设备接入，读取成功后上线、超时后离线
+type BACnetServer struct {
+	*BaseServer
+	conn      *net.UDPConn
+	broadcast *net.UDPAddr
+	processID uint32 // COV 订阅的进程标识
+
+	mu         sync.Mutex
+	configured map[string]*bacnetDevice     // 设备标识 -> 配置的设备
+	instances  map[uint32]*bacnetDevice     // 设备实例号 -> 设备
+	pending    map[string]chan *bacnet.APDU // 地址#调用ID -> 等待响应的请求
+	invokeID   byte
+	iAms       map[chan bacnet.IAm]struct{} // 等待 I-Am 的发现请求
+}
+
+// bacnetDevice 表示一个 BACnet 设备
+type bacnetDevice struct {
+	config  conf.BACnetDeviceConfig
+	objects []bacnetObject
+
+	mu       sync.Mutex
+	addr     *bacnet.Address // 为空时通过 Who-Is 发现
+	resolved chan struct{}   // 发现设备后关闭
+	noRPM    bool            // 设备不支持 ReadPropertyMultiple
+	device   *model.Device   // 读取成功后上线的设备
+}
+
+// bacnetObject 表示一个对象属性与物模型属性的映射
+type bacnetObject struct {
+	config conf.BACnetObjectConfig
+	ref    bacnet.PropertyRef
+}
+
+// NewBACnetServer 创建一个新的 BACnet/IP 客户端实例
+func NewBACnetServer(options ...Option) NetworkServer {
+	s := &BACnetServer{
+		BaseServer: NewBaseServer(options...),
+		processID:  mrand.Uint32N(1<<22) + 1,
+		configured: make(map[string]*bacnetDevice),
+		instances:  make(map[uint32]*bacnetDevice),
+		pending:    make(map[string]chan *bacnet.APDU),
+		iAms:       make(map[chan bacnet.IAm]struct{}),
+	}
+	return s
+}
+
+// Start 启动 BACnet/IP 客户端，addr 为本地的 BACnet/IP 端口，设备的 I-Am 与 COV 通知发送到该端口
+func (s *BACnetServer) Start(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":47808"
+	}
+	broadcast := s.bacnetConfig.Broadcast
+	if broadcast == "" {
+		broadcast = "255.255.255.255:47808"
+	}
+	var err error
+	if s.broadcast, err = resolveBACnetAddr(broadcast); err != nil {
+		return fmt.Errorf("解析 BACnet 广播地址失败: %v", err)
+	}
+	for _, config := range s.bacnetConfig.Devices {
+		device, err := newBACnetDevice(config)
+		if err != nil {
+			return err
+		}
+		if s.configured[config.DeviceKey] != nil {
+			return fmt.Errorf("BACnet 设备 %s 重复配置", config.DeviceKey)
+		}
+		s.configured[config.DeviceKey] = device
+		s.instances[config.Instance] = device
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("解析 UDP 地址失败: %v", err)
+	}
+	s.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("BACnet/IP 监听失败: %v", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	for _, device := range s.configured {
+		go s.pollLoop(ctx, device)
+		for _, object := range device.objects {
+			if object.config.COV {
+				go s.covLoop(ctx, device)
+				break
+			}
+		}
+	}
+
+	buffer := make([]byte, bacnetMaxDatagramSize)
+	for {
+		n, remoteAddr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil // 正常关闭
+			}
+			glog.Debugf(context.Background(), "读取 BACnet 报文失败: %v", err)
+			continue
+		}
+		s.handlePacket(remoteAddr, append([]byte(nil), buffer[:n]...))
+	}
+}
+
+// Stop 停止 BACnet/IP 客户端
+func (s *BACnetServer) Stop() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// SendData 按对象映射以 WriteProperty 写入设备，data 为 属性标识 -> 值，值为 nil 时释放该优先级的命令
+func (s *BACnetServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	dev, err := s.device(device.DeviceKey)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout()*time.Duration(s.retries()+1)*2)
+	defer cancel()
+	properties := gconv.Map(data)
+	var errs []error
+	written := false
+	for _, object := range dev.objects {
+		value, ok := properties[object.config.Name]
+		if !ok {
+			continue
+		}
+		written = true
+		if err := s.write(ctx, dev, object, value); err != nil {
+			errs = append(errs, fmt.Errorf("属性 %s: %w", object.config.Name, err))
+		}
+	}
+	if !written {
+		return errors.New("BACnet 下发数据中没有已映射的属性")
+	}
+	return errors.Join(errs...)
+}
+
+// Discover 广播 Who-Is,收集实例号在 low 到 high 之间的设备的 I-Am,直到 ctx 结束
+func (s *BACnetServer) Discover(ctx context.Context, low, high uint32) ([]bacnet.IAm, error) {
+	iAms := make(chan bacnet.IAm, 64)
+	s.mu.Lock()
+	s.iAms[iAms] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.iAms, iAms)
+		s.mu.Unlock()
+	}()
+	if err := s.whoIs(low, high); err != nil {
+		return nil, err
+	}
+
+	var result []bacnet.IAm
+	seen := make(map[uint32]bool)
+	for {
+		select {
+		case iAm := <-iAms:
+			if iAm.Device.Instance >= low && iAm.Device.Instance <= high && !seen[iAm.Device.Instance] {
+				seen[iAm.Device.Instance] = true
+				result = append(result, iAm)
+			}
+		case <-ctx.Done():
+			return result, nil
+		}
+	}
+}
+
+// ReadProperty 读取设备对象的一个属性，数组属性返回多个值
+func (s *BACnetServer) ReadProperty(ctx context.Context, deviceKey string, ref bacnet.PropertyRef) ([]bacnet.Value, error) {
+	dev, err := s.device(deviceKey)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.request(ctx, dev, bacnet.ServiceReadProperty, bacnet.EncodeReadProperty(ref))
+	if err != nil {
+		return nil, err
+	}
+	_, values, err := bacnet.DecodeReadPropertyAck(resp.Data)
+	return values, err
+}
+
+// ReadPropertyMultiple 读取设备对象的多个属性，属性数超过 MaxProperties 时分多次请求
+// 设备不支持该服务或响应过长时改为逐个 ReadProperty,单个属性的错误在结果的 Err 中返回
+func (s *BACnetServer) ReadPropertyMultiple(ctx context.Context, deviceKey string, refs []bacnet.PropertyRef) ([]bacnet.PropertyResult, error) {
+	dev, err := s.device(deviceKey)
+	if err != nil {
+		return nil, err
+	}
+	var results []bacnet.PropertyResult
+	for start := 0; start < len(refs); start += s.maxProperties() {
+		chunk := refs[start:min(start+s.maxProperties(), len(refs))]
+		dev.mu.Lock()
+		noRPM := dev.noRPM
+		dev.mu.Unlock()
+		if !noRPM {
+			resp, err := s.request(ctx, dev, bacnet.ServiceReadPropertyMultiple, bacnet.EncodeReadPropertyMultiple(chunk))
+			if err == nil {
+				chunkResults, err := bacnet.DecodeReadPropertyMultipleAck(resp.Data)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, chunkResults...)
+				continue
+			}
+			if !rpmUnsupported(err) {
+				return nil, err
+			}
+			var abort *bacnet.AbortError
+			if !errors.As(err, &abort) {
+				dev.mu.Lock()
+				dev.noRPM = true
+				dev.mu.Unlock()
+				glog.Debugf(ctx, "BACnet 设备 %s 不支持 ReadPropertyMultiple: %v\n", deviceKey, err)
+			}
+		}
+		for _, ref := range chunk {
+			values, err := s.ReadProperty(ctx, deviceKey, ref)
+			result := bacnet.PropertyResult{Ref: ref, Values: values}
+			if !errors.As(err, &result.Err) && err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// WriteProperty 写入设备对象的一个属性，priority 为 0 时不指定优先级
+func (s *BACnetServer) WriteProperty(ctx context.Context, deviceKey string, ref bacnet.PropertyRef, value bacnet.Value, priority byte) error {
+	dev, err := s.device(deviceKey)
+	if err != nil {
+		return err
+	}
+	request := &bacnet.WritePropertyRequest{Ref: ref, Values: []bacnet.Value{value}, Priority: priority}
+	data, err := request.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = s.request(ctx, dev, bacnet.ServiceWriteProperty, data)
+	return err
+}
+
+// SubscribeCOV 订阅设备对象的非确认 COV 通知，lifetime 为 0 时取消订阅
+func (s *BACnetServer) SubscribeCOV(ctx context.Context, deviceKey string, object bacnet.ObjectID, lifetime time.Duration) error {
+	dev, err := s.device(deviceKey)
+	if err != nil {
+		return err
+	}
+	request := &bacnet.SubscribeCOVRequest{ProcessID: s.processID, Object: object, Lifetime: uint32(lifetime / time.Second), Cancel: lifetime <= 0}
+	_, err = s.request(ctx, dev, bacnet.ServiceSubscribeCOV, request.Encode())
+	return err
+}
+
+// pollLoop 按设备的轮询周期读取对象属性
+func (s *BACnetServer) pollLoop(ctx context.Context, dev *bacnetDevice) {
+	for {
+		s.poll(ctx, dev)
+		timer := time.NewTimer(dev.interval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// poll 读取设备的全部对象属性并上报，请求超时时设备离线
+func (s *BACnetServer) poll(ctx context.Context, dev *bacnetDevice) {
+	deviceKey := dev.config.DeviceKey
+	refs := make([]bacnet.PropertyRef, 0, len(dev.objects))
+	for _, object := range dev.objects {
+		refs = append(refs, object.ref)
+	}
+	results, err := s.ReadPropertyMultiple(ctx, deviceKey, refs)
+	if err != nil {
+		glog.Debugf(ctx, "轮询 BACnet 设备 %s 失败: %v\n", deviceKey, err)
+		if errors.Is(err, ErrBACnetTimeout) {
+			s.offline(dev)
+		}
+		return
+	}
+	values := make(map[bacnet.PropertyRef]bacnet.PropertyResult, len(results))
+	for _, result := range results {
+		values[result.Ref] = result
+	}
+	properties := make(map[string]interface{})
+	for _, object := range dev.objects {
+		result, ok := values[object.ref]
+		if !ok {
+			continue
+		}
+		if result.Err != nil {
+			glog.Debugf(ctx, "读取 BACnet 设备 %s 的 %s 失败: %v\n", deviceKey, object.ref, result.Err)
+			continue
+		}
+		s.convert(properties, object, result.Values)
+	}
+	s.report(dev, properties)
+}
+
+// covLoop 订阅设备对象的 COV 通知，在生存期过半时续订，订阅失败时按轮询周期重试
+func (s *BACnetServer) covLoop(ctx context.Context, dev *bacnetDevice) {
+	lifetime := s.covLifetime()
+	for {
+		wait := lifetime / 2
+		subscribed := make(map[bacnet.ObjectID]bool)
+		for _, object := range dev.objects {
+			if !object.config.COV || subscribed[object.ref.Object] {
+				continue
+			}
+			subscribed[object.ref.Object] = true
+			if err := s.SubscribeCOV(ctx, dev.config.DeviceKey, object.ref.Object, lifetime); err != nil {
+				glog.Debugf(ctx, "订阅 BACnet 设备 %s 的 %s 失败: %v\n", dev.config.DeviceKey, object.ref.Object, err)
+				wait = min(wait, dev.interval())
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// convert 转换属性值并加入属性
+func (s *BACnetServer) convert(properties map[string]interface{}, object bacnetObject, values []bacnet.Value) {
+	value, err := bacnet.Convert(values, object.config.Type, object.config.Scale)
+	if err != nil {
+		glog.Debugf(context.Background(), "转换 BACnet 属性 %s 失败: %v\n", object.config.Name, err)
+		return
+	}
+	properties[object.config.Name] = value
+}
+
+// report 设备上线并上报属性
+func (s *BACnetServer) report(dev *bacnetDevice, properties map[string]interface{}) {
+	s.online(dev)
+	if len(properties) == 0 {
+		return
+	}
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         dev.config.DeviceKey,
+		"PropertieDataList": properties,
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 BACnet 设备 %s 数据失败: %v\n", dev.config.DeviceKey, err)
+	}
+}
+
+// online 设备上线
+func (s *BACnetServer) online(dev *bacnetDevice) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	deviceKey := dev.config.DeviceKey
+	if dev.device == nil || s.getDevice(deviceKey) != dev.device {
+		dev.device = s.handleConnect(deviceKey, nil)
+		s.bindDevice(dev.device, deviceKey)
+	}
+	dev.device.LastActive = time.Now()
+}
+
+// offline 设备离线，通过 Who-Is 发现的设备在下次请求时重新发现
+func (s *BACnetServer) offline(dev *bacnetDevice) {
+	dev.mu.Lock()
+	device := dev.device
+	dev.device = nil
+	if dev.config.Addr == "" && dev.addr != nil {
+		dev.addr, dev.resolved = nil, make(chan struct{})
+	}
+	dev.mu.Unlock()
+	if device != nil {
+		s.handleDisconnect(device)
+	}
+}
+
+// write 按对象映射转换属性值后写入
+func (s *BACnetServer) write(ctx context.Context, dev *bacnetDevice, object bacnetObject, value interface{}) error {
+	v, err := bacnet.WriteValue(object.ref.Object.Type, object.ref.Property, object.config.Type, object.config.Scale, value)
+	if err != nil {
+		return err
+	}
+	return s.WriteProperty(ctx, dev.config.DeviceKey, object.ref, v, byte(object.config.Priority))
+}
+
+// SetProperties 实现 PropertySetter 接口，按对象映射以 WriteProperty 写入属性设置，返回写入成功的属性
+func (s *BACnetServer) SetProperties(ctx context.Context, requester Requester, device *model.Device, properties map[string]interface{}) (map[string]interface{}, error) {
+	dev, err := s.device(device.DeviceKey)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout()*time.Duration(s.retries()+1)*2)
+	defer cancel()
+	reply := make(map[string]interface{})
+	for _, object := range dev.objects {
+		value, ok := properties[object.config.Name]
+		if !ok {
+			continue
+		}
+		if err := s.write(ctx, dev, object, value); err != nil {
+			glog.Errorf(ctx, "BACnet 设备 %s 写入属性 %s 失败: %v", dev.config.DeviceKey, object.config.Name, err)
+			continue
+		}
+		reply[object.config.Name] = value
+	}
+	return reply, nil
+}
+
+// handlePacket 处理收到的报文：响应交给等待的请求，I-Am 与 COV 通知由客户端处理，其他确认请求以 Reject 拒绝
+func (s *BACnetServer) handlePacket(remoteAddr *net.UDPAddr, data []byte) {
+	packet, err := bacnet.Unmarshal(data)
+	if err != nil {
+		glog.Debugf(context.Background(), "解析 BACnet 报文失败 %s: %v\n", remoteAddr, err)
+		return
+	}
+	if packet.Network || len(packet.APDU) == 0 {
+		return // 网络层报文与 BVLC-Result 等
+	}
+	src := bacnet.Address{IP: remoteAddr, Net: packet.SNet, MAC: packet.SAddr}
+	if packet.Origin != nil {
+		src.IP = packet.Origin
+	}
+	apdu, err := bacnet.UnmarshalAPDU(packet.APDU)
+	if err != nil {
+		glog.Debugf(context.Background(), "解析 BACnet APDU 失败 %s: %v\n", src, err)
+		return
+	}
+
+	switch apdu.Type {
+	case bacnet.PDUSimpleAck, bacnet.PDUComplexAck, bacnet.PDUError, bacnet.PDUReject, bacnet.PDUAbort:
+		s.mu.Lock()
+		response := s.pending[bacnetPendingKey(src, apdu.InvokeID)]
+		s.mu.Unlock()
+		if response == nil {
+			return // 超时后到达的响应
+		}
+		select {
+		case response <- apdu:
+		default: // 重发后收到的重复响应
+		}
+	case bacnet.PDUUnconfirmedRequest:
+		switch apdu.Service {
+		case bacnet.ServiceIAm:
+			s.handleIAm(src, apdu.Data)
+		case bacnet.ServiceUnconfirmedCOVNotification:
+			s.handleCOV(src, apdu.Data)
+		}
+	case bacnet.PDUConfirmedRequest:
+		reply := &bacnet.APDU{Type: bacnet.PDUReject, InvokeID: apdu.InvokeID, Reason: bacnet.RejectUnrecognizedService}
+		if apdu.Service == bacnet.ServiceConfirmedCOVNotification && !apdu.Segmented {
+			if s.handleCOV(src, apdu.Data) {
+				reply = &bacnet.APDU{Type: bacnet.PDUSimpleAck, InvokeID: apdu.InvokeID, Service: apdu.Service}
+			} else {
+				reply = &bacnet.APDU{Type: bacnet.PDUError, InvokeID: apdu.InvokeID, Service: apdu.Service,
+					Data: bacnet.EncodeError(bacnet.ErrorClassServices, bacnet.ErrorServiceDenied)}
+			}
+		}
+		if err := s.send(src, reply, false); err != nil {
+			glog.Debugf(context.Background(), "回复 BACnet 请求失败 %s: %v\n", src, err)
+		}
+	}
+}
+
+// handleIAm 记录发现的设备地址，并交给等待的发现请求
+func (s *BACnetServer) handleIAm(src bacnet.Address, data []byte) {
+	iAm, err := bacnet.DecodeIAm(data)
+	if err != nil || iAm.Device.Type != bacnet.Device {
+		glog.Debugf(context.Background(), "解析 BACnet I-Am 失败 %s: %v\n", src, err)
+		return
+	}
+	iAm.Addr = src
+	s.mu.Lock()
+	dev := s.instances[iAm.Device.Instance]
+	for iAms := range s.iAms {
+		select {
+		case iAms <- iAm:
+		default:
+		}
+	}
+	s.mu.Unlock()
+	if dev == nil || dev.config.Addr != "" {
+		return
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.addr == nil || dev.addr.String() != src.String() {
+		glog.Debugf(context.Background(), "发现 BACnet 设备 %s(%d): %s\n", dev.config.DeviceKey, iAm.Device.Instance, src)
+	}
+	dev.addr = &src
+	select {
+	case <-dev.resolved:
+	default:
+		close(dev.resolved)
+	}
+}
+
+// handleCOV 将 COV 通知中已映射的属性上报，通知来自未配置的设备时返回 false
+func (s *BACnetServer) handleCOV(src bacnet.Address, data []byte) bool {
+	notification, err := bacnet.DecodeCOVNotification(data)
+	if err != nil {
+		glog.Debugf(context.Background(), "解析 BACnet COV 通知失败 %s: %v\n", src, err)
+		return false
+	}
+	s.mu.Lock()
+	dev := s.instances[notification.Device.Instance]
+	s.mu.Unlock()
+	if dev == nil {
+		return false
+	}
+	properties := make(map[string]interface{})
+	for _, v := range notification.Values {
+		for _, object := range dev.objects {
+			if object.ref.Object == notification.Object && object.ref.Property == v.Property && object.ref.Index == v.Index {
+				s.convert(properties, object, v.Values)
+			}
+		}
+	}
+	s.report(dev, properties)
+	return true
+}
+
+// request 向设备发送确认请求并返回响应，超时后按 Retries 重发
+func (s *BACnetServer) request(ctx context.Context, dev *bacnetDevice, service byte, data []byte) (*bacnet.APDU, error) {
+	addr, err := s.resolve(ctx, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make(chan *bacnet.APDU, 1)
+	s.mu.Lock()
+	key := ""
+	for i := 0; i < 256; i++ {
+		s.invokeID++
+		candidate := bacnetPendingKey(addr, s.invokeID)
+		if s.pending[candidate] == nil {
+			key = candidate
+			break
+		}
+	}
+	if key == "" {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("BACnet 设备 %s 等待响应的请求过多", dev.config.DeviceKey)
+	}
+	invokeID := s.invokeID
+	s.pending[key] = response
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+	}()
+
+	apdu := &bacnet.APDU{Type: bacnet.PDUConfirmedRequest, MaxAPDU: bacnet.MaxAPDU1476, InvokeID: invokeID, Service: service, Data: data}
+	for attempt := 0; attempt <= s.retries(); attempt++ {
+		if err := s.send(addr, apdu, true); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(s.requestTimeout())
+		select {
+		case resp := <-response:
+			timer.Stop()
+			if err := resp.Err(); err != nil {
+				return nil, fmt.Errorf("BACnet 设备 %s: %w", dev.config.DeviceKey, err)
+			}
+			if resp.Segmented {
+				abort := &bacnet.APDU{Type: bacnet.PDUAbort, InvokeID: invokeID, Reason: bacnet.AbortSegmentationNotSupported}
+				s.send(addr, abort, false)
+				return nil, fmt.Errorf("BACnet 设备 %s: %w", dev.config.DeviceKey, &bacnet.AbortError{Reason: bacnet.AbortSegmentationNotSupported})
+			}
+			if resp.Service != service {
+				return nil, fmt.Errorf("BACnet 设备 %s 响应的服务 %d 错误", dev.config.DeviceKey, resp.Service)
+			}
+			return resp, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil, fmt.Errorf("%w: 设备 %s", ErrBACnetTimeout, dev.config.DeviceKey)
+}
+
+// resolve 获取设备的地址，未知时广播 Who-Is 等待设备的 I-Am
+func (s *BACnetServer) resolve(ctx context.Context, dev *bacnetDevice) (bacnet.Address, error) {
+	dev.mu.Lock()
+	addr, resolved := dev.addr, dev.resolved
+	dev.mu.Unlock()
+	if addr != nil {
+		return *addr, nil
+	}
+	for attempt := 0; attempt <= s.retries(); attempt++ {
+		if err := s.whoIs(dev.config.Instance, dev.config.Instance); err != nil {
+			return bacnet.Address{}, err
+		}
+		timer := time.NewTimer(s.requestTimeout())
+		select {
+		case <-resolved:
+			timer.Stop()
+			dev.mu.Lock()
+			defer dev.mu.Unlock()
+			if dev.addr == nil {
+				return bacnet.Address{}, fmt.Errorf("%w: 设备 %s 离线", ErrBACnetTimeout, dev.config.DeviceKey)
+			}
+			return *dev.addr, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return bacnet.Address{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return bacnet.Address{}, fmt.Errorf("%w: 设备 %s(%d) 未响应 Who-Is", ErrBACnetTimeout, dev.config.DeviceKey, dev.config.Instance)
+}
+
+// whoIs 向全部网络广播 Who-Is
+func (s *BACnetServer) whoIs(low, high uint32) error {
+	apdu := &bacnet.APDU{Type: bacnet.PDUUnconfirmedRequest, Service: bacnet.ServiceWhoIs, Data: bacnet.EncodeWhoIs(low, high)}
+	packet := &bacnet.Packet{Function: bacnet.BVLCOriginalBroadcastNPDU, NPDU: bacnet.NPDU{DNet: bacnet.GlobalNetwork, APDU: apdu.Marshal()}}
+	data, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.WriteToUDP(data, s.broadcast); err != nil {
+		return fmt.Errorf("发送 BACnet Who-Is 失败: %v", err)
+	}
+	return nil
+}
+
+// send 向设备发送 APDU,远程网络的设备经路由器转发
+func (s *BACnetServer) send(addr bacnet.Address, apdu *bacnet.APDU, expectingReply bool) error {
+	packet := &bacnet.Packet{Function: bacnet.BVLCOriginalUnicastNPDU, NPDU: bacnet.NPDU{
+		DNet: addr.Net, DAddr: addr.MAC, ExpectingReply: expectingReply, APDU: apdu.Marshal(),
+	}}
+	data, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.WriteToUDP(data, addr.IP); err != nil {
+		return fmt.Errorf("发送 BACnet 请求失败: %v", err)
+	}
+	return nil
+}
+
+// device 获取设备标识对应的设备
+func (s *BACnetServer) device(deviceKey string) (*bacnetDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dev := s.configured[deviceKey]
+	if dev == nil {
+		return nil, fmt.Errorf("%w: BACnet 设备 %s 未配置", ErrDeviceNotFound, deviceKey)
+	}
+	return dev, nil
+}
+
+// requestTimeout 获取单次请求等待响应的超时
+func (s *BACnetServer) requestTimeout() time.Duration {
+	if s.bacnetConfig.Timeout <= 0 {
+		return 3 * time.Second
+	}
+	return s.bacnetConfig.Timeout
+}
+
+// retries 获取超时后的重试次数
+func (s *BACnetServer) retries() int {
+	if s.bacnetConfig.Retries <= 0 {
+		return 1
+	}
+	return s.bacnetConfig.Retries
+}
+
+// maxProperties 获取单个 ReadPropertyMultiple 请求的最大属性数
+func (s *BACnetServer) maxProperties() int {
+	if s.bacnetConfig.MaxProperties <= 0 {
+		return 20
+	}
+	return s.bacnetConfig.MaxProperties
+}
+
+// covLifetime 获取 COV 订阅的生存期
+func (s *BACnetServer) covLifetime() time.Duration {
+	if s.bacnetConfig.COVLifetime < time.Second {
+		return 300 * time.Second
+	}
+	return s.bacnetConfig.COVLifetime
+}
+
+// newBACnetDevice 解析设备的地址与对象映射
+func newBACnetDevice(config conf.BACnetDeviceConfig) (*bacnetDevice, error) {
+	if config.DeviceKey == "" {
+		return nil, errors.New("BACnet 设备缺少设备标识")
+	}
+	if config.Instance > bacnet.MaxInstance {
+		return nil, fmt.Errorf("BACnet 设备 %s 的实例号 %d 超出范围", config.DeviceKey, config.Instance)
+	}
+	dev := &bacnetDevice{config: config, resolved: make(chan struct{})}
+	if config.Addr != "" {
+		addr, err := resolveBACnetAddr(config.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("BACnet 设备 %s 的地址错误: %v", config.DeviceKey, err)
+		}
+		dev.addr = &bacnet.Address{IP: addr}
+		close(dev.resolved)
+	}
+	for _, object := range config.Objects {
+		id, err := bacnet.ParseObjectID(object.Object)
+		if err != nil {
+			return nil, fmt.Errorf("BACnet 设备 %s 的属性 %s: %v", config.DeviceKey, object.Name, err)
+		}
+		property, err := bacnet.ParseProperty(object.Property)
+		if err != nil {
+			return nil, fmt.Errorf("BACnet 设备 %s 的属性 %s: %v", config.DeviceKey, object.Name, err)
+		}
+		if object.Priority < 0 || object.Priority > 16 {
+			return nil, fmt.Errorf("BACnet 设备 %s 的属性 %s 的写入优先级 %d 超出范围", config.DeviceKey, object.Name, object.Priority)
+		}
+		dev.objects = append(dev.objects, bacnetObject{
+			config: object,
+			ref:    bacnet.PropertyRef{Object: id, Property: property, Index: bacnet.IndexAll},
+		})
+	}
+	return dev, nil
+}
+
+// interval 获取设备的轮询周期
+func (d *bacnetDevice) interval() time.Duration {
+	if d.config.Interval <= 0 {
+		return 60 * time.Second
+	}
+	return d.config.Interval
+}
+
+// resolveBACnetAddr 解析 BACnet/IP 地址，未指定端口时使用 47808
+func resolveBACnetAddr(addr string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(bacnetDefaultPort))
+	}
+	return net.ResolveUDPAddr("udp4", addr)
+}
+
+// bacnetPendingKey 等待响应的请求的键，由设备地址与调用ID组成
+func bacnetPendingKey(addr bacnet.Address, invokeID byte) string {
+	return addr.String() + "#" + strconv.Itoa(int(invokeID))
+}
+
+// rpmUnsupported 判断 ReadPropertyMultiple 的错误是否应改为逐个 ReadProperty:
+// 设备拒绝或不支持该服务，或响应需要分段
+func rpmUnsupported(err error) bool {
+	var reject *bacnet.RejectError
+	var abort *bacnet.AbortError
+	var bacnetErr *bacnet.Error
+	return errors.As(err, &reject) ||
+		errors.As(err, &abort) && abort.Reason == bacnet.AbortSegmentationNotSupported ||
+		errors.As(err, &bacnetErr) && bacnetErr.Class == bacnet.ErrorClassServices
+}
@@ -0,0 +1,270 @@
+package bacnet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPacketEncoding(t *testing.T) {
+	// 读取 analogInput:1 的 presentValue
+	ref := PropertyRef{Object: ObjectID{Type: AnalogInput, Instance: 1}, Property: PropPresentValue, Index: IndexAll}
+	apdu := &APDU{Type: PDUConfirmedRequest, MaxAPDU: MaxAPDU1476, InvokeID: 1, Service: ServiceReadProperty, Data: EncodeReadProperty(ref)}
+	packet := &Packet{Function: BVLCOriginalUnicastNPDU, NPDU: NPDU{ExpectingReply: true, APDU: apdu.Marshal()}}
+	data, err := packet.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("810a001101040005010c0c000000011955")
+	if !bytes.Equal(data, want) {
+		t.Fatalf("编码结果 % X", data)
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, packet) {
+		t.Fatalf("解码结果 %+v", got)
+	}
+	gotAPDU, err := UnmarshalAPDU(got.APDU)
+	if err != nil || !reflect.DeepEqual(gotAPDU, apdu) {
+		t.Fatalf("APDU 解码结果 %+v %v", gotAPDU, err)
+	}
+	if gotRef, err := DecodeReadProperty(gotAPDU.Data); err != nil || gotRef != ref {
+		t.Fatalf("ReadProperty 解码结果 %v %v", gotRef, err)
+	}
+
+	// 经路由器转发的报文
+	routed := &Packet{
+		Function: BVLCForwardedNPDU,
+		Origin:   &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20).To4(), Port: 47808},
+		NPDU:     NPDU{DNet: 5, DAddr: []byte{0x0A}, SNet: 2, SAddr: []byte{0x01, 0x02}, HopCount: 254, APDU: []byte{0x10, 0x08}},
+	}
+	data, err = routed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Unmarshal(data); err != nil || !reflect.DeepEqual(got, routed) {
+		t.Fatalf("转发报文解码结果 %+v %v", got, err)
+	}
+	if _, err := Unmarshal(data[:len(data)-1]); !errors.Is(err, ErrInvalidAPDU) {
+		t.Fatalf("长度不符的报文应返回 ErrInvalidAPDU: %v", err)
+	}
+
+	errorAPDU := &APDU{Type: PDUError, InvokeID: 3, Service: ServiceWriteProperty, Data: EncodeError(ErrorClassProperty, ErrorWriteDenied)}
+	decoded, _ := UnmarshalAPDU(errorAPDU.Marshal())
+	var bacnetErr *Error
+	if err := decoded.Err(); !errors.As(err, &bacnetErr) || *bacnetErr != (Error{ErrorClassProperty, ErrorWriteDenied}) || err.Error() != "BACnet 错误 property/write-access-denied" {
+		t.Fatalf("错误报文 %v", err)
+	}
+	decoded, _ = UnmarshalAPDU((&APDU{Type: PDUReject, InvokeID: 4, Reason: RejectUnrecognizedService}).Marshal())
+	var reject *RejectError
+	if !errors.As(decoded.Err(), &reject) || reject.Reason != RejectUnrecognizedService {
+		t.Fatalf("拒绝报文 %+v", decoded)
+	}
+}
+
+func TestWhoIsIAm(t *testing.T) {
+	iAm := IAm{Device: ObjectID{Type: Device, Instance: 1}, MaxAPDU: 1476, Segmentation: SegmentationBoth, VendorID: 15}
+	if data := iAm.Encode(); hex.EncodeToString(data) != "c4020000012205c49100210f" {
+		t.Fatalf("I-Am 编码为 % X", data)
+	}
+	if got, err := DecodeIAm(iAm.Encode()); err != nil || !reflect.DeepEqual(got, iAm) {
+		t.Fatalf("I-Am 解码结果 %+v %v", got, err)
+	}
+	if low, high, err := DecodeWhoIs(EncodeWhoIs(1000, 1000)); err != nil || low != 1000 || high != 1000 {
+		t.Fatalf("Who-Is 解码结果 %d-%d %v", low, high, err)
+	}
+	if data := EncodeWhoIs(0, MaxInstance); len(data) != 0 {
+		t.Fatalf("不限定范围的 Who-Is 编码为 % X", data)
+	}
+}
+
+func TestValueEncoding(t *testing.T) {
+	long := strings.Repeat("冷冻水供水温度", 20)
+	values := []Value{
+		{Tag: TagNull},
+		{Tag: TagBoolean, Value: true},
+		{Tag: TagUnsigned, Value: uint64(256)},
+		{Tag: TagSigned, Value: int64(-129)},
+		{Tag: TagReal, Value: float32(21.5)},
+		{Tag: TagDouble, Value: 1234.5678},
+		{Tag: TagOctetString, Value: []byte{0xC0, 0xA8}},
+		{Tag: TagCharacterString, Value: "AHU-1"},
+		{Tag: TagCharacterString, Value: long},
+		{Tag: TagBitString, Value: []bool{false, true, false, false}},
+		{Tag: TagEnumerated, Value: uint64(1)},
+		{Tag: TagDate, Value: Date{124, 3, 15, 5}},
+		{Tag: TagTime, Value: Time{13, 30, 0, 0}},
+		{Tag: TagObjectID, Value: ObjectID{Type: AnalogValue, Instance: 4194303}},
+	}
+	data, err := appendValues(nil, 3, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDecoder(data)
+	d.opening(3)
+	got, err := d.values(3)
+	if err != nil || !reflect.DeepEqual(got, values) || !d.done() {
+		t.Fatalf("值解码结果 %+v %v", got, err)
+	}
+
+	if data, _ := appendValue(nil, Value{Tag: TagSigned, Value: int64(-129)}); !bytes.Equal(data, []byte{0x32, 0xFF, 0x7F}) {
+		t.Fatalf("-129 编码为 % X", data)
+	}
+	if data, _ := appendValue(nil, Value{Tag: TagBitString, Value: []bool{false, true, false, false}}); !bytes.Equal(data, []byte{0x82, 0x04, 0x40}) {
+		t.Fatalf("状态标志编码为 % X", data)
+	}
+	if _, err := appendValue(nil, Value{Tag: TagReal, Value: 21.5}); err == nil {
+		t.Fatal("Real 的值应为 float32")
+	}
+	if s, _ := decodeString(append([]byte{charsetUCS2}, 0x6E, 0x29, 0x00, 0x43)); s != "温C" {
+		t.Fatalf("UCS-2 字符串解码为 %q", s)
+	}
+}
+
+func TestServices(t *testing.T) {
+	ai1 := ObjectID{Type: AnalogInput, Instance: 1}
+	bo2 := ObjectID{Type: BinaryOutput, Instance: 2}
+	refs := []PropertyRef{
+		{Object: ai1, Property: PropPresentValue, Index: IndexAll},
+		{Object: ai1, Property: PropStatusFlags, Index: IndexAll},
+		{Object: bo2, Property: PropPriorityArray, Index: 8},
+	}
+	if got, err := DecodeReadPropertyMultiple(EncodeReadPropertyMultiple(refs)); err != nil || !reflect.DeepEqual(got, refs) {
+		t.Fatalf("ReadPropertyMultiple 解码结果 %+v %v", got, err)
+	}
+	results := []PropertyResult{
+		{Ref: refs[0], Values: []Value{{Tag: TagReal, Value: float32(7.25)}}},
+		{Ref: refs[1], Values: []Value{{Tag: TagBitString, Value: []bool{false, false, false, false}}}},
+		{Ref: refs[2], Err: &Error{Class: ErrorClassProperty, Code: ErrorInvalidIndex}},
+		{Ref: PropertyRef{Object: ObjectID{Type: AnalogValue, Instance: 9}, Property: PropPresentValue, Index: IndexAll}, Err: &Error{Class: ErrorClassObject, Code: ErrorUnknownObject}},
+	}
+	data, err := EncodeReadPropertyMultipleAck(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeReadPropertyMultipleAck(data); err != nil || !reflect.DeepEqual(got, results) {
+		t.Fatalf("ReadPropertyMultiple 响应解码结果 %+v %v", got, err)
+	}
+
+	data, _ = EncodeReadPropertyAck(refs[2], []Value{{Tag: TagNull}})
+	if ref, values, err := DecodeReadPropertyAck(data); err != nil || ref != refs[2] || !reflect.DeepEqual(values, []Value{{Tag: TagNull}}) {
+		t.Fatalf("ReadProperty 响应解码结果 %v %+v %v", ref, values, err)
+	}
+
+	write := &WritePropertyRequest{Ref: PropertyRef{Object: bo2, Property: PropPresentValue, Index: IndexAll}, Values: []Value{{Tag: TagEnumerated, Value: uint64(1)}}, Priority: 8}
+	data, _ = write.Encode()
+	if got, err := DecodeWriteProperty(data); err != nil || !reflect.DeepEqual(got, write) {
+		t.Fatalf("WriteProperty 解码结果 %+v %v", got, err)
+	}
+
+	for _, subscribe := range []*SubscribeCOVRequest{
+		{ProcessID: 7, Object: ai1, Lifetime: 300},
+		{ProcessID: 7, Object: ai1, Cancel: true},
+	} {
+		if got, err := DecodeSubscribeCOV(subscribe.Encode()); err != nil || *got != *subscribe {
+			t.Fatalf("SubscribeCOV 解码结果 %+v %v", got, err)
+		}
+	}
+
+	notification := &COVNotification{
+		ProcessID: 7, Device: ObjectID{Type: Device, Instance: 1001}, Object: ai1, TimeRemaining: 299,
+		Values: []PropertyValue{
+			{Property: PropPresentValue, Index: IndexAll, Values: []Value{{Tag: TagReal, Value: float32(7.5)}}},
+			{Property: PropStatusFlags, Index: IndexAll, Values: []Value{{Tag: TagBitString, Value: []bool{true, false, false, false}}}},
+		},
+	}
+	data, _ = notification.Encode()
+	if got, err := DecodeCOVNotification(data); err != nil || !reflect.DeepEqual(got, notification) {
+		t.Fatalf("COV 通知解码结果 %+v %v", got, err)
+	}
+}
+
+func TestParseObjectID(t *testing.T) {
+	tests := map[string]ObjectID{
+		"analogInput:1":       {Type: AnalogInput, Instance: 1},
+		"analog-value:20":     {Type: AnalogValue, Instance: 20},
+		"MULTI_STATE_VALUE:3": {Type: MultiStateValue, Instance: 3},
+		"130:5":               {Type: 130, Instance: 5},
+	}
+	for s, want := range tests {
+		if got, err := ParseObjectID(s); err != nil || got != want {
+			t.Fatalf("%s 解析为 %v %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"analogInput", "unknown:1", "device:4194304"} {
+		if _, err := ParseObjectID(s); err == nil {
+			t.Fatalf("%s 应解析失败", s)
+		}
+	}
+	if p, err := ParseProperty("present-value"); err != nil || p != PropPresentValue {
+		t.Fatalf("属性解析为 %v %v", p, err)
+	}
+	if p, _ := ParseProperty(""); p != PropPresentValue {
+		t.Fatalf("默认属性为 %v", p)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		values []Value
+		typ    string
+		scale  float64
+		want   interface{}
+	}{
+		{[]Value{{Tag: TagReal, Value: float32(21.3)}}, ValueAuto, 0, 21.3},
+		{[]Value{{Tag: TagUnsigned, Value: uint64(215)}}, ValueAuto, 0.1, 21.5},
+		{[]Value{{Tag: TagEnumerated, Value: uint64(1)}}, ValueBool, 0, true},
+		{[]Value{{Tag: TagEnumerated, Value: uint64(3)}}, ValueAuto, 0, uint64(3)},
+		{[]Value{{Tag: TagReal, Value: float32(49.6)}}, ValueInt, 0, int64(50)},
+		{[]Value{{Tag: TagBitString, Value: []bool{false, true, false, false}}}, ValueAuto, 0, "0100"},
+		{[]Value{{Tag: TagCharacterString, Value: "AHU-1"}}, ValueAuto, 0, "AHU-1"},
+		{[]Value{{Tag: TagObjectID, Value: ObjectID{Type: AnalogInput, Instance: 2}}}, ValueString, 0, "analogInput:2"},
+	}
+	for _, tt := range tests {
+		got, err := Convert(tt.values, tt.typ, tt.scale)
+		if err != nil || got != tt.want {
+			t.Fatalf("%+v 按 %q 转换为 %#v %v, 期望 %#v", tt.values, tt.typ, got, err, tt.want)
+		}
+	}
+	list, err := Convert([]Value{{Tag: TagNull}, {Tag: TagReal, Value: float32(1)}}, ValueAuto, 0)
+	if err != nil || !reflect.DeepEqual(list, []interface{}{nil, 1.0}) {
+		t.Fatalf("数组转换为 %#v %v", list, err)
+	}
+	if _, err := Convert([]Value{{Tag: TagNull}}, ValueAuto, 0); err == nil {
+		t.Fatal("Null 应返回错误")
+	}
+
+	writes := []struct {
+		object   ObjectType
+		property PropertyID
+		typ      string
+		scale    float64
+		value    interface{}
+		want     Value
+	}{
+		{AnalogValue, PropPresentValue, ValueAuto, 0, 22, Value{Tag: TagReal, Value: float32(22)}},
+		{BinaryOutput, PropPresentValue, ValueBool, 0, true, Value{Tag: TagEnumerated, Value: uint64(1)}},
+		{MultiStateValue, PropPresentValue, ValueAuto, 0, "3", Value{Tag: TagUnsigned, Value: uint64(3)}},
+		{AnalogOutput, PropPresentValue, ValueFloat, 0.1, 21.5, Value{Tag: TagReal, Value: float32(215)}},
+		{AnalogOutput, PropPresentValue, ValueAuto, 0, nil, Value{Tag: TagNull}},
+		{AnalogInput, PropOutOfService, ValueAuto, 0, true, Value{Tag: TagBoolean, Value: true}},
+		{AnalogInput, PropDescription, ValueAuto, 0, "供水温度", Value{Tag: TagCharacterString, Value: "供水温度"}},
+		{AnalogInput, PropCOVIncrement, ValueFloat, 0, 1, Value{Tag: TagReal, Value: float32(1)}},
+		{IntegerValue, PropPresentValue, ValueAuto, 0, -5, Value{Tag: TagSigned, Value: int64(-5)}},
+	}
+	for _, tt := range writes {
+		got, err := WriteValue(tt.object, tt.property, tt.typ, tt.scale, tt.value)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%v/%v 写入 %v 转换为 %+v %v, 期望 %+v", tt.object, tt.property, tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := WriteValue(MultiStateValue, PropPresentValue, ValueAuto, 0, -1); err == nil {
+		t.Fatal("Unsigned 不能写入负数")
+	}
+}
@@ -0,0 +1,216 @@
+package bacnet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxInstance 对象实例号的最大值，也用于 Who-Is 不限定范围
+const MaxInstance = 0x3FFFFF
+
+// IndexAll 不指定数组下标，读取或写入整个属性
+const IndexAll = 0xFFFFFFFF
+
+// ObjectType 对象类型
+type ObjectType uint16
+
+// 常用的对象类型
+const (
+	AnalogInput          ObjectType = 0
+	AnalogOutput         ObjectType = 1
+	AnalogValue          ObjectType = 2
+	BinaryInput          ObjectType = 3
+	BinaryOutput         ObjectType = 4
+	BinaryValue          ObjectType = 5
+	Calendar             ObjectType = 6
+	Device               ObjectType = 8
+	Loop                 ObjectType = 12
+	MultiStateInput      ObjectType = 13
+	MultiStateOutput     ObjectType = 14
+	NotificationClass    ObjectType = 15
+	Schedule             ObjectType = 17
+	MultiStateValue      ObjectType = 19
+	TrendLog             ObjectType = 20
+	Accumulator          ObjectType = 23
+	IntegerValue         ObjectType = 45
+	LargeAnalogValue     ObjectType = 46
+	PositiveIntegerValue ObjectType = 48
+)
+
+var objectTypeNames = map[ObjectType]string{
+	AnalogInput:          "analogInput",
+	AnalogOutput:         "analogOutput",
+	AnalogValue:          "analogValue",
+	BinaryInput:          "binaryInput",
+	BinaryOutput:         "binaryOutput",
+	BinaryValue:          "binaryValue",
+	Calendar:             "calendar",
+	Device:               "device",
+	Loop:                 "loop",
+	MultiStateInput:      "multiStateInput",
+	MultiStateOutput:     "multiStateOutput",
+	NotificationClass:    "notificationClass",
+	Schedule:             "schedule",
+	MultiStateValue:      "multiStateValue",
+	TrendLog:             "trendLog",
+	Accumulator:          "accumulator",
+	IntegerValue:         "integerValue",
+	LargeAnalogValue:     "largeAnalogValue",
+	PositiveIntegerValue: "positiveIntegerValue",
+}
+
+// String 返回对象类型的名称，未知类型返回编号
+func (t ObjectType) String() string {
+	if name, ok := objectTypeNames[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
+
+// PropertyID 属性标识
+type PropertyID uint32
+
+// 常用的属性标识
+const (
+	PropActiveText        PropertyID = 4
+	PropCOVIncrement      PropertyID = 22
+	PropDescription       PropertyID = 28
+	PropEventState        PropertyID = 36
+	PropHighLimit         PropertyID = 45
+	PropInactiveText      PropertyID = 46
+	PropLowLimit          PropertyID = 59
+	PropMaxAPDULength     PropertyID = 62
+	PropModelName         PropertyID = 70
+	PropNumberOfStates    PropertyID = 74
+	PropObjectIdentifier  PropertyID = 75
+	PropObjectList        PropertyID = 76
+	PropObjectName        PropertyID = 77
+	PropObjectType        PropertyID = 79
+	PropOutOfService      PropertyID = 81
+	PropPresentValue      PropertyID = 85
+	PropPriorityArray     PropertyID = 87
+	PropReliability       PropertyID = 103
+	PropRelinquishDefault PropertyID = 104
+	PropSegmentation      PropertyID = 107
+	PropStateText         PropertyID = 110
+	PropStatusFlags       PropertyID = 111
+	PropSystemStatus      PropertyID = 112
+	PropUnits             PropertyID = 117
+	PropVendorIdentifier  PropertyID = 120
+	PropVendorName        PropertyID = 121
+	PropPropertyList      PropertyID = 371
+)
+
+var propertyNames = map[PropertyID]string{
+	PropActiveText:        "activeText",
+	PropCOVIncrement:      "covIncrement",
+	PropDescription:       "description",
+	PropEventState:        "eventState",
+	PropHighLimit:         "highLimit",
+	PropInactiveText:      "inactiveText",
+	PropLowLimit:          "lowLimit",
+	PropMaxAPDULength:     "maxApduLengthAccepted",
+	PropModelName:         "modelName",
+	PropNumberOfStates:    "numberOfStates",
+	PropObjectIdentifier:  "objectIdentifier",
+	PropObjectList:        "objectList",
+	PropObjectName:        "objectName",
+	PropObjectType:        "objectType",
+	PropOutOfService:      "outOfService",
+	PropPresentValue:      "presentValue",
+	PropPriorityArray:     "priorityArray",
+	PropReliability:       "reliability",
+	PropRelinquishDefault: "relinquishDefault",
+	PropSegmentation:      "segmentationSupported",
+	PropStateText:         "stateText",
+	PropStatusFlags:       "statusFlags",
+	PropSystemStatus:      "systemStatus",
+	PropUnits:             "units",
+	PropVendorIdentifier:  "vendorIdentifier",
+	PropVendorName:        "vendorName",
+	PropPropertyList:      "propertyList",
+}
+
+// String 返回属性的名称，未知属性返回编号
+func (p PropertyID) String() string {
+	if name, ok := propertyNames[p]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(p), 10)
+}
+
+// ObjectID 对象标识，由对象类型与实例号组成
+type ObjectID struct {
+	Type     ObjectType
+	Instance uint32
+}
+
+// String 返回 类型:实例号 格式的对象标识，如 analogInput:1
+func (id ObjectID) String() string {
+	return id.Type.String() + ":" + strconv.FormatUint(uint64(id.Instance), 10)
+}
+
+// ParseObjectID 解析 类型:实例号 格式的对象标识，类型可以是名称(analogInput、analog-input)或编号
+func ParseObjectID(s string) (ObjectID, error) {
+	typ, instance, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return ObjectID{}, fmt.Errorf("BACnet 对象 %q 应为 类型:实例号 格式", s)
+	}
+	t, err := parseName(typ, objectTypeNames, 1023)
+	if err != nil {
+		return ObjectID{}, fmt.Errorf("BACnet 对象 %q 的类型错误", s)
+	}
+	n, err := strconv.ParseUint(instance, 10, 32)
+	if err != nil || n > MaxInstance {
+		return ObjectID{}, fmt.Errorf("BACnet 对象 %q 的实例号错误", s)
+	}
+	return ObjectID{Type: ObjectType(t), Instance: uint32(n)}, nil
+}
+
+// ParseProperty 解析属性标识，可以是名称(presentValue、present-value)或编号，为空时为 presentValue
+func ParseProperty(s string) (PropertyID, error) {
+	if strings.TrimSpace(s) == "" {
+		return PropPresentValue, nil
+	}
+	p, err := parseName(s, propertyNames, 1<<22-1)
+	if err != nil {
+		return 0, fmt.Errorf("BACnet 属性 %q 错误", s)
+	}
+	return PropertyID(p), nil
+}
+
+// parseName 按名称或编号解析枚举，名称不区分大小写，忽略 - 与 _
+func parseName[T ObjectType | PropertyID](s string, names map[T]string, maxValue uint64) (T, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		if n > maxValue {
+			return 0, strconv.ErrRange
+		}
+		return T(n), nil
+	}
+	normalize := strings.NewReplacer("-", "", "_", "")
+	key := strings.ToLower(normalize.Replace(s))
+	for value, name := range names {
+		if strings.ToLower(name) == key {
+			return value, nil
+		}
+	}
+	return 0, strconv.ErrSyntax
+}
+
+// PropertyRef 表示对象的一个属性，Index 为 IndexAll 时不指定数组下标
+type PropertyRef struct {
+	Object   ObjectID
+	Property PropertyID
+	Index    uint32
+}
+
+// String 返回 对象/属性[下标] 格式的属性
+func (r PropertyRef) String() string {
+	s := r.Object.String() + "/" + r.Property.String()
+	if r.Index != IndexAll {
+		s += "[" + strconv.FormatUint(uint64(r.Index), 10) + "]"
+	}
+	return s
+}
@@ -0,0 +1,403 @@
+package bacnet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// BVLC 功能
+const (
+	BVLCResult                byte = 0x00
+	BVLCForwardedNPDU         byte = 0x04
+	BVLCOriginalUnicastNPDU   byte = 0x0A
+	BVLCOriginalBroadcastNPDU byte = 0x0B
+	bvlcType                  byte = 0x81
+	bvlcHeaderLength               = 4
+)
+
+// 全局广播的目的网络
+const GlobalNetwork = 0xFFFF
+
+// APDU 类型
+const (
+	PDUConfirmedRequest   byte = 0x00
+	PDUUnconfirmedRequest byte = 0x10
+	PDUSimpleAck          byte = 0x20
+	PDUComplexAck         byte = 0x30
+	PDUSegmentAck         byte = 0x40
+	PDUError              byte = 0x50
+	PDUReject             byte = 0x60
+	PDUAbort              byte = 0x70
+)
+
+// 确认服务
+const (
+	ServiceConfirmedCOVNotification byte = 1
+	ServiceSubscribeCOV             byte = 5
+	ServiceReadProperty             byte = 12
+	ServiceReadPropertyMultiple     byte = 14
+	ServiceWriteProperty            byte = 15
+)
+
+// 非确认服务
+const (
+	ServiceIAm                        byte = 0
+	ServiceUnconfirmedCOVNotification byte = 2
+	ServiceWhoIs                      byte = 8
+)
+
+// 确认请求可接受的最大 APDU 长度
+const (
+	MaxAPDU480  byte = 3
+	MaxAPDU1476 byte = 5
+)
+
+// 错误类别与常用的错误代码
+const (
+	ErrorClassDevice     uint32 = 0
+	ErrorClassObject     uint32 = 1
+	ErrorClassProperty   uint32 = 2
+	ErrorClassResources  uint32 = 3
+	ErrorClassSecurity   uint32 = 4
+	ErrorClassServices   uint32 = 5
+	ErrorCodeOther       uint32 = 0
+	ErrorInvalidDataType uint32 = 9
+	ErrorReadDenied      uint32 = 27
+	ErrorServiceDenied   uint32 = 29
+	ErrorUnknownObject   uint32 = 31
+	ErrorUnknownProperty uint32 = 32
+	ErrorValueOutOfRange uint32 = 37
+	ErrorWriteDenied     uint32 = 40
+	ErrorInvalidIndex    uint32 = 42
+	ErrorNotCOVProperty  uint32 = 44
+)
+
+// Reject 与 Abort 的常用原因
+const (
+	RejectUnrecognizedService     byte = 9
+	AbortSegmentationNotSupported byte = 4
+)
+
+var errorClassNames = []string{"device", "object", "property", "resources", "security", "services", "vt", "communication"}
+
+var errorCodeNames = map[uint32]string{
+	ErrorCodeOther:       "other",
+	ErrorInvalidDataType: "invalid-data-type",
+	ErrorReadDenied:      "read-access-denied",
+	ErrorServiceDenied:   "service-request-denied",
+	ErrorUnknownObject:   "unknown-object",
+	ErrorUnknownProperty: "unknown-property",
+	ErrorValueOutOfRange: "value-out-of-range",
+	ErrorWriteDenied:     "write-access-denied",
+	ErrorInvalidIndex:    "invalid-array-index",
+	ErrorNotCOVProperty:  "not-cov-property",
+}
+
+// Address 表示 BACnet 设备的地址，经路由器访问的远程网络设备 Net 不为 0,MAC 为设备在该网络的地址
+type Address struct {
+	IP  *net.UDPAddr
+	Net uint16
+	MAC []byte
+}
+
+// String 返回地址的字符串形式，远程网络设备为 IP:端口/网络号:MAC
+func (a Address) String() string {
+	s := ""
+	if a.IP != nil {
+		s = a.IP.String()
+	}
+	if a.Net != 0 {
+		s += "/" + strconv.Itoa(int(a.Net)) + ":" + hex.EncodeToString(a.MAC)
+	}
+	return s
+}
+
+// NPDU 网络层协议数据单元
+type NPDU struct {
+	DNet           uint16 // 目的网络,0 为本地网络
+	DAddr          []byte // 目的地址，为空时在目的网络广播
+	SNet           uint16 // 源网络，经路由器转发时不为 0
+	SAddr          []byte
+	HopCount       byte
+	ExpectingReply bool
+	Priority       byte
+	Network        bool   // 网络层报文，不含 APDU
+	APDU           []byte // 应用层数据，网络层报文为报文类型及其内容
+}
+
+// Packet 表示一个 BACnet/IP 报文
+type Packet struct {
+	Function byte
+	Origin   *net.UDPAddr // 转发报文(Forwarded-NPDU)的原始发送方
+	NPDU
+}
+
+// Marshal 编码报文
+func (p *Packet) Marshal() ([]byte, error) {
+	buf := []byte{bvlcType, p.Function, 0, 0}
+	if p.Function == BVLCForwardedNPDU {
+		if p.Origin == nil || p.Origin.IP.To4() == nil {
+			return nil, fmt.Errorf("BACnet 转发报文缺少原始地址")
+		}
+		buf = binary.BigEndian.AppendUint16(append(buf, p.Origin.IP.To4()...), uint16(p.Origin.Port))
+	}
+	control := p.Priority & 0x03
+	if p.Network {
+		control |= 0x80
+	}
+	if p.DNet != 0 {
+		control |= 0x20
+	}
+	if p.SNet != 0 {
+		control |= 0x08
+	}
+	if p.ExpectingReply {
+		control |= 0x04
+	}
+	buf = append(buf, 0x01, control)
+	if p.DNet != 0 {
+		buf = binary.BigEndian.AppendUint16(buf, p.DNet)
+		buf = append(append(buf, byte(len(p.DAddr))), p.DAddr...)
+	}
+	if p.SNet != 0 {
+		buf = binary.BigEndian.AppendUint16(buf, p.SNet)
+		buf = append(append(buf, byte(len(p.SAddr))), p.SAddr...)
+	}
+	if p.DNet != 0 {
+		hopCount := p.HopCount
+		if hopCount == 0 {
+			hopCount = 255
+		}
+		buf = append(buf, hopCount)
+	}
+	buf = append(buf, p.APDU...)
+	if len(buf) > 0xFFFF {
+		return nil, fmt.Errorf("BACnet 报文长度 %d 超出限制", len(buf))
+	}
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)))
+	return buf, nil
+}
+
+// Unmarshal 解码 BACnet/IP 报文，BVLC 的其他功能(如 BVLC-Result)只解码头部
+func Unmarshal(data []byte) (*Packet, error) {
+	if len(data) < bvlcHeaderLength || data[0] != bvlcType || int(binary.BigEndian.Uint16(data[2:])) != len(data) {
+		return nil, ErrInvalidAPDU
+	}
+	p := &Packet{Function: data[1]}
+	pos := bvlcHeaderLength
+	switch p.Function {
+	case BVLCOriginalUnicastNPDU, BVLCOriginalBroadcastNPDU:
+	case BVLCForwardedNPDU:
+		if len(data) < pos+6 {
+			return nil, ErrInvalidAPDU
+		}
+		p.Origin = &net.UDPAddr{IP: net.IP(append([]byte(nil), data[pos:pos+4]...)), Port: int(binary.BigEndian.Uint16(data[pos+4:]))}
+		pos += 6
+	default:
+		return p, nil
+	}
+
+	if len(data) < pos+2 || data[pos] != 0x01 {
+		return nil, ErrInvalidAPDU
+	}
+	control := data[pos+1]
+	pos += 2
+	p.Network = control&0x80 != 0
+	p.ExpectingReply = control&0x04 != 0
+	p.Priority = control & 0x03
+	address := func() (uint16, []byte, error) {
+		if len(data) < pos+3 || len(data) < pos+3+int(data[pos+2]) {
+			return 0, nil, ErrInvalidAPDU
+		}
+		network := binary.BigEndian.Uint16(data[pos:])
+		length := int(data[pos+2])
+		addr := append([]byte(nil), data[pos+3:pos+3+length]...)
+		pos += 3 + length
+		return network, addr, nil
+	}
+	var err error
+	if control&0x20 != 0 {
+		if p.DNet, p.DAddr, err = address(); err != nil {
+			return nil, err
+		}
+	}
+	if control&0x08 != 0 {
+		if p.SNet, p.SAddr, err = address(); err != nil {
+			return nil, err
+		}
+	}
+	if control&0x20 != 0 {
+		if len(data) < pos+1 {
+			return nil, ErrInvalidAPDU
+		}
+		p.HopCount = data[pos]
+		pos++
+	}
+	p.APDU = data[pos:]
+	return p, nil
+}
+
+// APDU 应用层协议数据单元，不支持分段
+type APDU struct {
+	Type      byte
+	Service   byte
+	InvokeID  byte
+	MaxAPDU   byte // 确认请求可接受的最大 APDU 长度
+	Segmented bool // 分段报文，Data 为分段的内容
+	Server    bool // Abort 由服务端发送
+	Reason    byte // Reject 与 Abort 的原因
+	Data      []byte
+}
+
+// Marshal 编码 APDU
+func (a *APDU) Marshal() []byte {
+	switch a.Type {
+	case PDUConfirmedRequest:
+		return append([]byte{PDUConfirmedRequest, a.MaxAPDU & 0x0F, a.InvokeID, a.Service}, a.Data...)
+	case PDUUnconfirmedRequest:
+		return append([]byte{PDUUnconfirmedRequest, a.Service}, a.Data...)
+	case PDUSimpleAck:
+		return []byte{PDUSimpleAck, a.InvokeID, a.Service}
+	case PDUComplexAck, PDUError:
+		return append([]byte{a.Type, a.InvokeID, a.Service}, a.Data...)
+	case PDUReject:
+		return []byte{PDUReject, a.InvokeID, a.Reason}
+	case PDUAbort:
+		b := PDUAbort
+		if a.Server {
+			b |= 0x01
+		}
+		return []byte{b, a.InvokeID, a.Reason}
+	}
+	return nil
+}
+
+// UnmarshalAPDU 解码 APDU
+func UnmarshalAPDU(data []byte) (*APDU, error) {
+	if len(data) < 2 {
+		return nil, ErrInvalidAPDU
+	}
+	a := &APDU{Type: data[0] & 0xF0}
+	segmented := data[0]&0x08 != 0
+	switch a.Type {
+	case PDUConfirmedRequest:
+		if len(data) < 4 {
+			return nil, ErrInvalidAPDU
+		}
+		a.MaxAPDU, a.InvokeID = data[1]&0x0F, data[2]
+		pos := 3
+		if segmented {
+			a.Segmented, pos = true, 5
+		}
+		if len(data) < pos+1 {
+			return nil, ErrInvalidAPDU
+		}
+		a.Service, a.Data = data[pos], data[pos+1:]
+	case PDUUnconfirmedRequest:
+		a.Service, a.Data = data[1], data[2:]
+	case PDUSimpleAck, PDUError:
+		if len(data) < 3 {
+			return nil, ErrInvalidAPDU
+		}
+		a.InvokeID, a.Service, a.Data = data[1], data[2], data[3:]
+	case PDUComplexAck:
+		pos := 2
+		if segmented {
+			a.Segmented, pos = true, 4
+		}
+		if len(data) < pos+1 {
+			return nil, ErrInvalidAPDU
+		}
+		a.InvokeID, a.Service, a.Data = data[1], data[pos], data[pos+1:]
+	case PDUSegmentAck:
+		a.InvokeID, a.Server = data[1], data[0]&0x01 != 0
+	case PDUReject, PDUAbort:
+		if len(data) < 3 {
+			return nil, ErrInvalidAPDU
+		}
+		a.InvokeID, a.Reason, a.Server = data[1], data[2], data[0]&0x01 != 0
+	default:
+		return nil, ErrInvalidAPDU
+	}
+	return a, nil
+}
+
+// Err 返回 Error、Reject、Abort 报文对应的错误，其他报文返回 nil
+func (a *APDU) Err() error {
+	switch a.Type {
+	case PDUError:
+		e, err := decodeError(a.Data)
+		if err != nil {
+			return err
+		}
+		return e
+	case PDUReject:
+		return &RejectError{Reason: a.Reason}
+	case PDUAbort:
+		return &AbortError{Reason: a.Reason}
+	}
+	return nil
+}
+
+// Error 设备以 Error 报文返回的错误
+type Error struct {
+	Class uint32
+	Code  uint32
+}
+
+func (e *Error) Error() string {
+	class := strconv.FormatUint(uint64(e.Class), 10)
+	if int(e.Class) < len(errorClassNames) {
+		class = errorClassNames[e.Class]
+	}
+	code, ok := errorCodeNames[e.Code]
+	if !ok {
+		code = strconv.FormatUint(uint64(e.Code), 10)
+	}
+	return "BACnet 错误 " + class + "/" + code
+}
+
+// EncodeError 编码 Error 报文的内容
+func EncodeError(class, code uint32) []byte {
+	buf, _ := appendValue(nil, Value{Tag: TagEnumerated, Value: uint64(class)})
+	buf, _ = appendValue(buf, Value{Tag: TagEnumerated, Value: uint64(code)})
+	return buf
+}
+
+// decodeError 解码 Error 报文的内容，部分服务的错误包含在上下文标签 0 中
+func decodeError(data []byte) (*Error, error) {
+	d := newDecoder(data)
+	if d.isOpening(0) {
+		d.opening(0)
+	}
+	class, err := d.application()
+	if err != nil || class.Tag != TagEnumerated {
+		return nil, ErrInvalidAPDU
+	}
+	code, err := d.application()
+	if err != nil || code.Tag != TagEnumerated {
+		return nil, ErrInvalidAPDU
+	}
+	return &Error{Class: uint32(class.Value.(uint64)), Code: uint32(code.Value.(uint64))}, nil
+}
+
+// RejectError 设备以 Reject 报文拒绝请求
+type RejectError struct {
+	Reason byte
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("BACnet 请求被拒绝，原因 %d", e.Reason)
+}
+
+// AbortError 请求被 Abort 报文中止
+type AbortError struct {
+	Reason byte
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("BACnet 请求被中止，原因 %d", e.Reason)
+}
@@ -0,0 +1,459 @@
+package bacnet
+
+// 设备支持的分段方式
+const (
+	SegmentationBoth = 0
+	SegmentationNone = 3
+)
+
+// EncodeWhoIs 编码 Who-Is 请求，实例号范围为 0 到 MaxInstance 时不限定范围
+func EncodeWhoIs(low, high uint32) []byte {
+	if low == 0 && high >= MaxInstance {
+		return nil
+	}
+	buf := appendContextUnsigned(nil, 0, uint64(low))
+	return appendContextUnsigned(buf, 1, uint64(high))
+}
+
+// DecodeWhoIs 解码 Who-Is 请求，返回设备实例号的范围
+func DecodeWhoIs(data []byte) (uint32, uint32, error) {
+	if len(data) == 0 {
+		return 0, MaxInstance, nil
+	}
+	d := newDecoder(data)
+	low, err := d.contextUnsigned(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	high, err := d.contextUnsigned(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(low), uint32(high), nil
+}
+
+// IAm 表示 I-Am 通告
+type IAm struct {
+	Device       ObjectID
+	MaxAPDU      uint32
+	Segmentation uint32
+	VendorID     uint32
+	Addr         Address // 发送 I-Am 的设备地址，不参与编码
+}
+
+// Encode 编码 I-Am 通告
+func (i IAm) Encode() []byte {
+	buf, _ := appendValue(nil, Value{Tag: TagObjectID, Value: i.Device})
+	buf, _ = appendValue(buf, Value{Tag: TagUnsigned, Value: uint64(i.MaxAPDU)})
+	buf, _ = appendValue(buf, Value{Tag: TagEnumerated, Value: uint64(i.Segmentation)})
+	buf, _ = appendValue(buf, Value{Tag: TagUnsigned, Value: uint64(i.VendorID)})
+	return buf
+}
+
+// DecodeIAm 解码 I-Am 通告
+func DecodeIAm(data []byte) (IAm, error) {
+	d := newDecoder(data)
+	var values [4]Value
+	tags := [4]byte{TagObjectID, TagUnsigned, TagEnumerated, TagUnsigned}
+	for i := range values {
+		v, err := d.application()
+		if err != nil {
+			return IAm{}, err
+		}
+		if v.Tag != tags[i] {
+			return IAm{}, ErrInvalidAPDU
+		}
+		values[i] = v
+	}
+	return IAm{
+		Device:       values[0].Value.(ObjectID),
+		MaxAPDU:      uint32(values[1].Value.(uint64)),
+		Segmentation: uint32(values[2].Value.(uint64)),
+		VendorID:     uint32(values[3].Value.(uint64)),
+	}, nil
+}
+
+// EncodeReadProperty 编码 ReadProperty 请求
+func EncodeReadProperty(ref PropertyRef) []byte {
+	return appendRef(nil, ref, 0)
+}
+
+// DecodeReadProperty 解码 ReadProperty 请求
+func DecodeReadProperty(data []byte) (PropertyRef, error) {
+	return decodeRef(newDecoder(data), 0)
+}
+
+// EncodeReadPropertyAck 编码 ReadProperty 的响应
+func EncodeReadPropertyAck(ref PropertyRef, values []Value) ([]byte, error) {
+	return appendValues(appendRef(nil, ref, 0), 3, values)
+}
+
+// DecodeReadPropertyAck 解码 ReadProperty 的响应，数组属性返回多个值
+func DecodeReadPropertyAck(data []byte) (PropertyRef, []Value, error) {
+	d := newDecoder(data)
+	ref, err := decodeRef(d, 0)
+	if err != nil {
+		return ref, nil, err
+	}
+	if err := d.opening(3); err != nil {
+		return ref, nil, err
+	}
+	values, err := d.values(3)
+	return ref, values, err
+}
+
+// PropertyResult 表示 ReadPropertyMultiple 响应中一个属性的值或错误
+type PropertyResult struct {
+	Ref    PropertyRef
+	Values []Value
+	Err    *Error
+}
+
+// EncodeReadPropertyMultiple 编码 ReadPropertyMultiple 请求，相邻的同一对象的属性合并
+func EncodeReadPropertyMultiple(refs []PropertyRef) []byte {
+	var buf []byte
+	for i, ref := range refs {
+		if i == 0 || refs[i-1].Object != ref.Object {
+			if i > 0 {
+				buf = appendClosing(buf, 1)
+			}
+			buf = appendOpening(appendContextObjectID(buf, 0, ref.Object), 1)
+		}
+		buf = appendContextUnsigned(buf, 0, uint64(ref.Property))
+		if ref.Index != IndexAll {
+			buf = appendContextUnsigned(buf, 1, uint64(ref.Index))
+		}
+	}
+	if len(refs) > 0 {
+		buf = appendClosing(buf, 1)
+	}
+	return buf
+}
+
+// DecodeReadPropertyMultiple 解码 ReadPropertyMultiple 请求
+func DecodeReadPropertyMultiple(data []byte) ([]PropertyRef, error) {
+	d := newDecoder(data)
+	var refs []PropertyRef
+	for !d.done() {
+		object, err := d.contextObjectID(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.opening(1); err != nil {
+			return nil, err
+		}
+		for !d.isClosing(1) {
+			property, err := d.contextUnsigned(0)
+			if err != nil {
+				return nil, err
+			}
+			ref := PropertyRef{Object: object, Property: PropertyID(property), Index: IndexAll}
+			if d.isContext(1) {
+				index, _ := d.contextUnsigned(1)
+				ref.Index = uint32(index)
+			}
+			refs = append(refs, ref)
+		}
+		d.closing(1)
+	}
+	return refs, nil
+}
+
+// EncodeReadPropertyMultipleAck 编码 ReadPropertyMultiple 的响应，相邻的同一对象的结果合并
+func EncodeReadPropertyMultipleAck(results []PropertyResult) ([]byte, error) {
+	var buf []byte
+	var err error
+	for i, result := range results {
+		if i == 0 || results[i-1].Ref.Object != result.Ref.Object {
+			if i > 0 {
+				buf = appendClosing(buf, 1)
+			}
+			buf = appendOpening(appendContextObjectID(buf, 0, result.Ref.Object), 1)
+		}
+		buf = appendContextUnsigned(buf, 2, uint64(result.Ref.Property))
+		if result.Ref.Index != IndexAll {
+			buf = appendContextUnsigned(buf, 3, uint64(result.Ref.Index))
+		}
+		if result.Err != nil {
+			buf = appendClosing(append(appendOpening(buf, 5), EncodeError(result.Err.Class, result.Err.Code)...), 5)
+			continue
+		}
+		if buf, err = appendValues(buf, 4, result.Values); err != nil {
+			return nil, err
+		}
+	}
+	if len(results) > 0 {
+		buf = appendClosing(buf, 1)
+	}
+	return buf, nil
+}
+
+// DecodeReadPropertyMultipleAck 解码 ReadPropertyMultiple 的响应
+func DecodeReadPropertyMultipleAck(data []byte) ([]PropertyResult, error) {
+	d := newDecoder(data)
+	var results []PropertyResult
+	for !d.done() {
+		object, err := d.contextObjectID(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.opening(1); err != nil {
+			return nil, err
+		}
+		for !d.isClosing(1) {
+			property, err := d.contextUnsigned(2)
+			if err != nil {
+				return nil, err
+			}
+			result := PropertyResult{Ref: PropertyRef{Object: object, Property: PropertyID(property), Index: IndexAll}}
+			if d.isContext(3) {
+				index, _ := d.contextUnsigned(3)
+				result.Ref.Index = uint32(index)
+			}
+			switch {
+			case d.isOpening(4):
+				d.opening(4)
+				if result.Values, err = d.values(4); err != nil {
+					return nil, err
+				}
+			case d.isOpening(5):
+				d.opening(5)
+				start := d.pos
+				for !d.isClosing(5) {
+					if err := d.skip(); err != nil {
+						return nil, err
+					}
+				}
+				if result.Err, err = decodeError(d.data[start:d.pos]); err != nil {
+					return nil, err
+				}
+				d.closing(5)
+			default:
+				return nil, ErrInvalidAPDU
+			}
+			results = append(results, result)
+		}
+		d.closing(1)
+	}
+	return results, nil
+}
+
+// WritePropertyRequest 表示 WriteProperty 请求，Priority 为 0 时不指定优先级
+type WritePropertyRequest struct {
+	Ref      PropertyRef
+	Values   []Value
+	Priority byte
+}
+
+// Encode 编码 WriteProperty 请求
+func (w *WritePropertyRequest) Encode() ([]byte, error) {
+	buf, err := appendValues(appendRef(nil, w.Ref, 0), 3, w.Values)
+	if err != nil {
+		return nil, err
+	}
+	if w.Priority != 0 {
+		buf = appendContextUnsigned(buf, 4, uint64(w.Priority))
+	}
+	return buf, nil
+}
+
+// DecodeWriteProperty 解码 WriteProperty 请求
+func DecodeWriteProperty(data []byte) (*WritePropertyRequest, error) {
+	d := newDecoder(data)
+	ref, err := decodeRef(d, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.opening(3); err != nil {
+		return nil, err
+	}
+	w := &WritePropertyRequest{Ref: ref}
+	if w.Values, err = d.values(3); err != nil {
+		return nil, err
+	}
+	if d.isContext(4) {
+		priority, _ := d.contextUnsigned(4)
+		w.Priority = byte(priority)
+	}
+	return w, nil
+}
+
+// SubscribeCOVRequest 表示 SubscribeCOV 请求，Cancel 为 true 时取消订阅
+type SubscribeCOVRequest struct {
+	ProcessID uint32
+	Object    ObjectID
+	Confirmed bool   // 以确认的 COV 通知发送
+	Lifetime  uint32 // 订阅的生存期，单位为秒,0 为永久
+	Cancel    bool
+}
+
+// Encode 编码 SubscribeCOV 请求
+func (s *SubscribeCOVRequest) Encode() []byte {
+	buf := appendContextUnsigned(nil, 0, uint64(s.ProcessID))
+	buf = appendContextObjectID(buf, 1, s.Object)
+	if s.Cancel {
+		return buf
+	}
+	confirmed := byte(0)
+	if s.Confirmed {
+		confirmed = 1
+	}
+	buf = appendContext(buf, 2, []byte{confirmed})
+	return appendContextUnsigned(buf, 3, uint64(s.Lifetime))
+}
+
+// DecodeSubscribeCOV 解码 SubscribeCOV 请求
+func DecodeSubscribeCOV(data []byte) (*SubscribeCOVRequest, error) {
+	d := newDecoder(data)
+	processID, err := d.contextUnsigned(0)
+	if err != nil {
+		return nil, err
+	}
+	object, err := d.contextObjectID(1)
+	if err != nil {
+		return nil, err
+	}
+	s := &SubscribeCOVRequest{ProcessID: uint32(processID), Object: object, Cancel: d.done()}
+	if s.Cancel {
+		return s, nil
+	}
+	if !d.isContext(2) {
+		return nil, ErrInvalidAPDU
+	}
+	_, content, err := d.next()
+	if err != nil || len(content) != 1 {
+		return nil, ErrInvalidAPDU
+	}
+	s.Confirmed = content[0] != 0
+	if d.isContext(3) {
+		lifetime, _ := d.contextUnsigned(3)
+		s.Lifetime = uint32(lifetime)
+	}
+	return s, nil
+}
+
+// PropertyValue 表示 COV 通知中的一个属性值，Priority 为 0 时不指定优先级
+type PropertyValue struct {
+	Property PropertyID
+	Index    uint32
+	Values   []Value
+	Priority byte
+}
+
+// COVNotification 表示确认或非确认的 COV 通知
+type COVNotification struct {
+	ProcessID     uint32
+	Device        ObjectID // 发起通知的设备
+	Object        ObjectID // 被监视的对象
+	TimeRemaining uint32   // 订阅剩余的时间，单位为秒
+	Values        []PropertyValue
+}
+
+// Encode 编码 COV 通知
+func (n *COVNotification) Encode() ([]byte, error) {
+	buf := appendContextUnsigned(nil, 0, uint64(n.ProcessID))
+	buf = appendContextObjectID(buf, 1, n.Device)
+	buf = appendContextObjectID(buf, 2, n.Object)
+	buf = appendOpening(appendContextUnsigned(buf, 3, uint64(n.TimeRemaining)), 4)
+	var err error
+	for _, v := range n.Values {
+		buf = appendContextUnsigned(buf, 0, uint64(v.Property))
+		if v.Index != IndexAll {
+			buf = appendContextUnsigned(buf, 1, uint64(v.Index))
+		}
+		if buf, err = appendValues(buf, 2, v.Values); err != nil {
+			return nil, err
+		}
+		if v.Priority != 0 {
+			buf = appendContextUnsigned(buf, 3, uint64(v.Priority))
+		}
+	}
+	return appendClosing(buf, 4), nil
+}
+
+// DecodeCOVNotification 解码 COV 通知
+func DecodeCOVNotification(data []byte) (*COVNotification, error) {
+	d := newDecoder(data)
+	processID, err := d.contextUnsigned(0)
+	if err != nil {
+		return nil, err
+	}
+	n := &COVNotification{ProcessID: uint32(processID)}
+	if n.Device, err = d.contextObjectID(1); err != nil {
+		return nil, err
+	}
+	if n.Object, err = d.contextObjectID(2); err != nil {
+		return nil, err
+	}
+	timeRemaining, err := d.contextUnsigned(3)
+	if err != nil {
+		return nil, err
+	}
+	n.TimeRemaining = uint32(timeRemaining)
+	if err := d.opening(4); err != nil {
+		return nil, err
+	}
+	for !d.isClosing(4) {
+		property, err := d.contextUnsigned(0)
+		if err != nil {
+			return nil, err
+		}
+		v := PropertyValue{Property: PropertyID(property), Index: IndexAll}
+		if d.isContext(1) {
+			index, _ := d.contextUnsigned(1)
+			v.Index = uint32(index)
+		}
+		if err := d.opening(2); err != nil {
+			return nil, err
+		}
+		if v.Values, err = d.values(2); err != nil {
+			return nil, err
+		}
+		if d.isContext(3) {
+			priority, _ := d.contextUnsigned(3)
+			v.Priority = byte(priority)
+		}
+		n.Values = append(n.Values, v)
+	}
+	return n, d.closing(4)
+}
+
+// appendRef 从上下文标签 first 开始追加对象、属性与数组下标
+func appendRef(buf []byte, ref PropertyRef, first byte) []byte {
+	buf = appendContextObjectID(buf, first, ref.Object)
+	buf = appendContextUnsigned(buf, first+1, uint64(ref.Property))
+	if ref.Index != IndexAll {
+		buf = appendContextUnsigned(buf, first+2, uint64(ref.Index))
+	}
+	return buf
+}
+
+// decodeRef 从上下文标签 first 开始读取对象、属性与可选的数组下标
+func decodeRef(d *decoder, first byte) (PropertyRef, error) {
+	object, err := d.contextObjectID(first)
+	if err != nil {
+		return PropertyRef{}, err
+	}
+	property, err := d.contextUnsigned(first + 1)
+	if err != nil {
+		return PropertyRef{}, err
+	}
+	ref := PropertyRef{Object: object, Property: PropertyID(property), Index: IndexAll}
+	if d.isContext(first + 2) {
+		index, _ := d.contextUnsigned(first + 2)
+		ref.Index = uint32(index)
+	}
+	return ref, nil
+}
+
+// appendValues 追加包含在开始与结束标签中的值
+func appendValues(buf []byte, number byte, values []Value) ([]byte, error) {
+	buf = appendOpening(buf, number)
+	var err error
+	for _, v := range values {
+		if buf, err = appendValue(buf, v); err != nil {
+			return nil, err
+		}
+	}
+	return appendClosing(buf, number), nil
+}
@@ -0,0 +1,567 @@
+// Package bacnet 实现 BACnet/IP(ASHRAE 135 附录 J)的报文编解码，包括 BVLC、NPDU、APDU 与常用服务
+package bacnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// 应用标签
+const (
+	TagNull            byte = 0
+	TagBoolean         byte = 1
+	TagUnsigned        byte = 2
+	TagSigned          byte = 3
+	TagReal            byte = 4
+	TagDouble          byte = 5
+	TagOctetString     byte = 6
+	TagCharacterString byte = 7
+	TagBitString       byte = 8
+	TagEnumerated      byte = 9
+	TagDate            byte = 10
+	TagTime            byte = 11
+	TagObjectID        byte = 12
+)
+
+// 字符集
+const (
+	charsetUTF8   = 0
+	charsetUCS2   = 4
+	charsetLatin1 = 5
+)
+
+// ErrInvalidAPDU 报文格式错误
+var ErrInvalidAPDU = errors.New("BACnet 报文格式错误")
+
+// Value 表示一个带应用标签的值
+// Value 的类型:Null 为 nil,Boolean 为 bool,Unsigned 与 Enumerated 为 uint64,Signed 为 int64,
+// Real 为 float32,Double 为 float64,OctetString 为 []byte,CharacterString 为 string,
+// BitString 为 []bool,Date 为 Date,Time 为 Time,ObjectIdentifier 为 ObjectID
+type Value struct {
+	Tag   byte
+	Value interface{}
+}
+
+// Date 日期，年为 1900 起的偏移，各字段为 0xFF 时表示任意值
+type Date struct {
+	Year, Month, Day, Weekday byte
+}
+
+// String 返回 2006-01-02 格式的日期，任意值以 * 表示
+func (d Date) String() string {
+	year := "*"
+	if d.Year != 0xFF {
+		year = fmt.Sprint(1900 + int(d.Year))
+	}
+	return year + "-" + wildcard(d.Month) + "-" + wildcard(d.Day)
+}
+
+// Time 时间，各字段为 0xFF 时表示任意值
+type Time struct {
+	Hour, Minute, Second, Hundredths byte
+}
+
+// String 返回 15:04:05.00 格式的时间，任意值以 * 表示
+func (t Time) String() string {
+	return wildcard(t.Hour) + ":" + wildcard(t.Minute) + ":" + wildcard(t.Second) + "." + wildcard(t.Hundredths)
+}
+
+func wildcard(b byte) string {
+	if b == 0xFF {
+		return "*"
+	}
+	return fmt.Sprintf("%02d", b)
+}
+
+// tag 表示一个标签头
+type tag struct {
+	number  byte
+	context bool
+	opening bool
+	closing bool
+	length  int // 应用标签 Boolean 的长度字段为值
+}
+
+// decoder 顺序读取标签
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{data: data}
+}
+
+// done 判断是否已读取全部数据
+func (d *decoder) done() bool {
+	return d.pos >= len(d.data)
+}
+
+// peek 读取下一个标签头但不移动位置
+func (d *decoder) peek() (tag, int, error) {
+	pos := d.pos
+	if pos >= len(d.data) {
+		return tag{}, 0, ErrInvalidAPDU
+	}
+	b := d.data[pos]
+	pos++
+	t := tag{number: b >> 4, context: b&0x08 != 0}
+	if t.number == 0x0F {
+		if pos >= len(d.data) {
+			return tag{}, 0, ErrInvalidAPDU
+		}
+		t.number = d.data[pos]
+		pos++
+	}
+	length := int(b & 0x07)
+	switch {
+	case t.context && length == 6:
+		t.opening = true
+		return t, pos - d.pos, nil
+	case t.context && length == 7:
+		t.closing = true
+		return t, pos - d.pos, nil
+	case length == 5:
+		if pos >= len(d.data) {
+			return tag{}, 0, ErrInvalidAPDU
+		}
+		length = int(d.data[pos])
+		pos++
+		switch length {
+		case 254:
+			if pos+2 > len(d.data) {
+				return tag{}, 0, ErrInvalidAPDU
+			}
+			length = int(binary.BigEndian.Uint16(d.data[pos:]))
+			pos += 2
+		case 255:
+			if pos+4 > len(d.data) {
+				return tag{}, 0, ErrInvalidAPDU
+			}
+			length = int(binary.BigEndian.Uint32(d.data[pos:]))
+			pos += 4
+		}
+	}
+	t.length = length
+	return t, pos - d.pos, nil
+}
+
+// next 读取下一个标签及其内容，开始标签与结束标签没有内容
+func (d *decoder) next() (tag, []byte, error) {
+	t, n, err := d.peek()
+	if err != nil {
+		return t, nil, err
+	}
+	d.pos += n
+	if t.opening || t.closing || (!t.context && t.number == TagBoolean) {
+		return t, nil, nil
+	}
+	if t.length < 0 || d.pos+t.length > len(d.data) {
+		return t, nil, ErrInvalidAPDU
+	}
+	content := d.data[d.pos : d.pos+t.length]
+	d.pos += t.length
+	return t, content, nil
+}
+
+// isContext 判断下一个标签是否为指定编号的上下文标签
+func (d *decoder) isContext(number byte) bool {
+	t, _, err := d.peek()
+	return err == nil && t.context && !t.opening && !t.closing && t.number == number
+}
+
+// isOpening 判断下一个标签是否为指定编号的开始标签
+func (d *decoder) isOpening(number byte) bool {
+	t, _, err := d.peek()
+	return err == nil && t.opening && t.number == number
+}
+
+// isClosing 判断下一个标签是否为指定编号的结束标签
+func (d *decoder) isClosing(number byte) bool {
+	t, _, err := d.peek()
+	return err == nil && t.closing && t.number == number
+}
+
+// opening 读取指定编号的开始标签
+func (d *decoder) opening(number byte) error {
+	if !d.isOpening(number) {
+		return ErrInvalidAPDU
+	}
+	_, _, err := d.next()
+	return err
+}
+
+// closing 读取指定编号的结束标签
+func (d *decoder) closing(number byte) error {
+	if !d.isClosing(number) {
+		return ErrInvalidAPDU
+	}
+	_, _, err := d.next()
+	return err
+}
+
+// contextUnsigned 读取指定编号的上下文标签中的无符号整数
+func (d *decoder) contextUnsigned(number byte) (uint64, error) {
+	if !d.isContext(number) {
+		return 0, ErrInvalidAPDU
+	}
+	_, content, err := d.next()
+	if err != nil {
+		return 0, err
+	}
+	return decodeUnsigned(content)
+}
+
+// contextObjectID 读取指定编号的上下文标签中的对象标识
+func (d *decoder) contextObjectID(number byte) (ObjectID, error) {
+	if !d.isContext(number) {
+		return ObjectID{}, ErrInvalidAPDU
+	}
+	_, content, err := d.next()
+	if err != nil {
+		return ObjectID{}, err
+	}
+	return decodeObjectID(content)
+}
+
+// application 读取一个应用标签的值
+func (d *decoder) application() (Value, error) {
+	t, content, err := d.next()
+	if err != nil {
+		return Value{}, err
+	}
+	if t.context || t.opening || t.closing {
+		return Value{}, ErrInvalidAPDU
+	}
+	v := Value{Tag: t.number}
+	switch t.number {
+	case TagNull:
+	case TagBoolean:
+		v.Value = t.length != 0
+	case TagUnsigned, TagEnumerated:
+		v.Value, err = decodeUnsigned(content)
+	case TagSigned:
+		v.Value, err = decodeSigned(content)
+	case TagReal:
+		if len(content) != 4 {
+			return v, ErrInvalidAPDU
+		}
+		v.Value = math.Float32frombits(binary.BigEndian.Uint32(content))
+	case TagDouble:
+		if len(content) != 8 {
+			return v, ErrInvalidAPDU
+		}
+		v.Value = math.Float64frombits(binary.BigEndian.Uint64(content))
+	case TagOctetString:
+		v.Value = append([]byte(nil), content...)
+	case TagCharacterString:
+		v.Value, err = decodeString(content)
+	case TagBitString:
+		if len(content) == 0 || content[0] > 7 {
+			return v, ErrInvalidAPDU
+		}
+		bits := make([]bool, 0, (len(content)-1)*8)
+		for i, b := range content[1:] {
+			for j := 0; j < 8; j++ {
+				if i == len(content)-2 && j >= 8-int(content[0]) {
+					break // 最后一个字节的未使用位
+				}
+				bits = append(bits, b&(0x80>>j) != 0)
+			}
+		}
+		v.Value = bits
+	case TagDate, TagTime:
+		if len(content) != 4 {
+			return v, ErrInvalidAPDU
+		}
+		if t.number == TagDate {
+			v.Value = Date{content[0], content[1], content[2], content[3]}
+		} else {
+			v.Value = Time{content[0], content[1], content[2], content[3]}
+		}
+	case TagObjectID:
+		v.Value, err = decodeObjectID(content)
+	default:
+		v.Value = append([]byte(nil), content...) // 保留的标签
+	}
+	return v, err
+}
+
+// values 读取应用标签的值，直到指定编号的结束标签
+func (d *decoder) values(closing byte) ([]Value, error) {
+	var values []Value
+	for !d.isClosing(closing) {
+		if d.done() {
+			return nil, ErrInvalidAPDU
+		}
+		if t, _, err := d.peek(); err == nil && (t.context || t.opening) {
+			// 构造类型的值，如 BACnetDateTime 以外的复杂结构，跳过
+			if err := d.skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := d.application()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, d.closing(closing)
+}
+
+// skip 跳过一个标签，开始标签跳过到对应的结束标签
+func (d *decoder) skip() error {
+	t, _, err := d.next()
+	if err != nil || !t.opening {
+		return err
+	}
+	for !d.isClosing(t.number) {
+		if d.done() {
+			return ErrInvalidAPDU
+		}
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	return d.closing(t.number)
+}
+
+func decodeUnsigned(content []byte) (uint64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, ErrInvalidAPDU
+	}
+	var v uint64
+	for _, b := range content {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func decodeSigned(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, ErrInvalidAPDU
+	}
+	v := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+func decodeObjectID(content []byte) (ObjectID, error) {
+	if len(content) != 4 {
+		return ObjectID{}, ErrInvalidAPDU
+	}
+	v := binary.BigEndian.Uint32(content)
+	return ObjectID{Type: ObjectType(v >> 22), Instance: v & MaxInstance}, nil
+}
+
+func decodeString(content []byte) (string, error) {
+	if len(content) == 0 {
+		return "", ErrInvalidAPDU
+	}
+	switch content[0] {
+	case charsetUTF8:
+		return string(content[1:]), nil
+	case charsetUCS2:
+		if len(content)%2 != 1 {
+			return "", ErrInvalidAPDU
+		}
+		units := make([]uint16, 0, len(content)/2)
+		for i := 1; i < len(content); i += 2 {
+			units = append(units, binary.BigEndian.Uint16(content[i:]))
+		}
+		return string(utf16.Decode(units)), nil
+	case charsetLatin1:
+		runes := make([]rune, 0, len(content)-1)
+		for _, b := range content[1:] {
+			runes = append(runes, rune(b))
+		}
+		return string(runes), nil
+	}
+	return "", fmt.Errorf("不支持的 BACnet 字符集 %d", content[0])
+}
+
+// appendTag 追加标签头
+func appendTag(buf []byte, number byte, context bool, length int) []byte {
+	b := byte(0)
+	if context {
+		b = 0x08
+	}
+	var extended []byte
+	if number >= 15 {
+		b |= 0xF0
+		extended = []byte{number}
+	} else {
+		b |= number << 4
+	}
+	switch {
+	case length < 5:
+		buf = append(buf, b|byte(length))
+		return append(buf, extended...)
+	case length < 254:
+		buf = append(append(buf, b|5), extended...)
+		return append(buf, byte(length))
+	case length < 65536:
+		buf = append(append(buf, b|5), extended...)
+		return binary.BigEndian.AppendUint16(append(buf, 254), uint16(length))
+	}
+	buf = append(append(buf, b|5), extended...)
+	return binary.BigEndian.AppendUint32(append(buf, 255), uint32(length))
+}
+
+// appendOpening 追加开始标签
+func appendOpening(buf []byte, number byte) []byte {
+	if number >= 15 {
+		return append(buf, 0xFE, number)
+	}
+	return append(buf, number<<4|0x0E)
+}
+
+// appendClosing 追加结束标签
+func appendClosing(buf []byte, number byte) []byte {
+	if number >= 15 {
+		return append(buf, 0xFF, number)
+	}
+	return append(buf, number<<4|0x0F)
+}
+
+// appendContext 追加上下文标签及其内容
+func appendContext(buf []byte, number byte, content []byte) []byte {
+	return append(appendTag(buf, number, true, len(content)), content...)
+}
+
+// appendContextUnsigned 追加上下文标签的无符号整数
+func appendContextUnsigned(buf []byte, number byte, v uint64) []byte {
+	return appendContext(buf, number, encodeUnsigned(v))
+}
+
+// appendContextObjectID 追加上下文标签的对象标识
+func appendContextObjectID(buf []byte, number byte, id ObjectID) []byte {
+	return appendContext(buf, number, encodeObjectID(id))
+}
+
+// appendValue 追加应用标签的值
+func appendValue(buf []byte, v Value) ([]byte, error) {
+	var content []byte
+	switch v.Tag {
+	case TagNull:
+	case TagBoolean:
+		b, ok := v.Value.(bool)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		length := 0
+		if b {
+			length = 1
+		}
+		return appendTag(buf, TagBoolean, false, length), nil
+	case TagUnsigned, TagEnumerated:
+		n, ok := v.Value.(uint64)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = encodeUnsigned(n)
+	case TagSigned:
+		n, ok := v.Value.(int64)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = encodeSigned(n)
+	case TagReal:
+		f, ok := v.Value.(float32)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = binary.BigEndian.AppendUint32(nil, math.Float32bits(f))
+	case TagDouble:
+		f, ok := v.Value.(float64)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = binary.BigEndian.AppendUint64(nil, math.Float64bits(f))
+	case TagOctetString:
+		data, ok := v.Value.([]byte)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = data
+	case TagCharacterString:
+		s, ok := v.Value.(string)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = append([]byte{charsetUTF8}, s...)
+	case TagBitString:
+		bits, ok := v.Value.([]bool)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = make([]byte, 1+(len(bits)+7)/8)
+		content[0] = byte((8 - len(bits)%8) % 8)
+		for i, bit := range bits {
+			if bit {
+				content[1+i/8] |= 0x80 >> (i % 8)
+			}
+		}
+	case TagDate:
+		date, ok := v.Value.(Date)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = []byte{date.Year, date.Month, date.Day, date.Weekday}
+	case TagTime:
+		t, ok := v.Value.(Time)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = []byte{t.Hour, t.Minute, t.Second, t.Hundredths}
+	case TagObjectID:
+		id, ok := v.Value.(ObjectID)
+		if !ok {
+			return nil, valueTypeError(v)
+		}
+		content = encodeObjectID(id)
+	default:
+		return nil, fmt.Errorf("不支持的 BACnet 应用标签 %d", v.Tag)
+	}
+	return append(appendTag(buf, v.Tag, false, len(content)), content...), nil
+}
+
+func valueTypeError(v Value) error {
+	return fmt.Errorf("BACnet 应用标签 %d 的值不支持 %T 类型", v.Tag, v.Value)
+}
+
+func encodeUnsigned(v uint64) []byte {
+	n := 1
+	for v>>(8*n) != 0 && n < 8 {
+		n++
+	}
+	content := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		content[i] = byte(v)
+		v >>= 8
+	}
+	return content
+}
+
+func encodeSigned(v int64) []byte {
+	n := 1
+	for n < 8 && (v < -(1<<(8*n-1)) || v >= 1<<(8*n-1)) {
+		n++
+	}
+	content := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		content[i] = byte(v)
+		v >>= 8
+	}
+	return content
+}
+
+func encodeObjectID(id ObjectID) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(id.Type)<<22|id.Instance&MaxInstance)
+}
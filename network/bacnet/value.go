@@ -0,0 +1,261 @@
+package bacnet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 属性的数据类型
+const (
+	ValueAuto   = ""       // 按 BACnet 类型转换:Real 为浮点数，枚举与整数为整数，位串为 "0100" 形式的字符串
+	ValueInt    = "int"    // 整数
+	ValueFloat  = "float"  // 浮点数，常与 scale 一起使用
+	ValueString = "string" // 字符串
+	ValueBool   = "bool"   // 布尔值，二进制对象的 active(1)为 true
+)
+
+// Convert 按属性的数据类型转换读取到的值，数组属性转换为切片，scale 不为 0 时数值乘以 scale
+func Convert(values []Value, typ string, scale float64) (interface{}, error) {
+	if len(values) == 1 {
+		if values[0].Tag == TagNull {
+			return nil, fmt.Errorf("属性没有值")
+		}
+		return convert(values[0], typ, scale)
+	}
+	list := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if v.Tag == TagNull {
+			list = append(list, nil) // 优先级数组中未使用的优先级
+			continue
+		}
+		value, err := convert(v, typ, scale)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+// convert 转换一个值
+func convert(v Value, typ string, scale float64) (interface{}, error) {
+	var number float64
+	numeric := true
+	switch value := v.Value.(type) {
+	case uint64:
+		number = float64(value)
+	case int64:
+		number = float64(value)
+	case float32:
+		number = cleanFloat(value)
+	case float64:
+		number = value
+	default:
+		numeric = false
+	}
+
+	switch typ {
+	case ValueAuto:
+		if numeric && scale != 0 {
+			return number * scale, nil
+		}
+		switch value := v.Value.(type) {
+		case float32:
+			return number, nil
+		case []byte:
+			return strings.ToUpper(hex.EncodeToString(value)), nil
+		case []bool:
+			bits := make([]byte, len(value))
+			for i, bit := range value {
+				bits[i] = '0'
+				if bit {
+					bits[i] = '1'
+				}
+			}
+			return string(bits), nil
+		case Date, Time, ObjectID:
+			return fmt.Sprint(value), nil
+		}
+		return v.Value, nil
+	case ValueInt:
+		if b, ok := v.Value.(bool); ok {
+			if b {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		if s, ok := v.Value.(string); ok {
+			return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		}
+		if numeric {
+			if scale != 0 {
+				number *= scale
+			}
+			return int64(math.Round(number)), nil
+		}
+	case ValueFloat:
+		if s, ok := v.Value.(string); ok {
+			var err error
+			if number, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+				return nil, fmt.Errorf("值 %q 不是数值", s)
+			}
+			numeric = true
+		}
+		if numeric {
+			if scale != 0 {
+				number *= scale
+			}
+			return number, nil
+		}
+	case ValueString:
+		if s, ok := v.Value.(string); ok {
+			return s, nil
+		}
+		value, err := convert(v, ValueAuto, scale)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprint(value), nil
+	case ValueBool:
+		if b, ok := v.Value.(bool); ok {
+			return b, nil
+		}
+		if numeric {
+			return number != 0, nil
+		}
+	default:
+		return nil, fmt.Errorf("不支持的属性类型 %s", typ)
+	}
+	return nil, fmt.Errorf("BACnet 应用标签 %d 的值无法转换为 %s", v.Tag, typ)
+}
+
+// cleanFloat 将单精度浮点数转换为最短表示的双精度浮点数，避免 21.3 变为 21.299999237060547
+func cleanFloat(f float32) float64 {
+	value, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return value
+}
+
+// WriteValue 将属性值转换为写入的值，值为 nil 时为 Null(释放该优先级的命令)
+// 模拟量、二进制、多态对象的 presentValue 与 relinquishDefault 按对象类型编码为 Real、Enumerated、Unsigned,
+// 其他属性按属性类型编码，未指定类型时按值的类型编码
+func WriteValue(object ObjectType, property PropertyID, typ string, scale float64, value interface{}) (Value, error) {
+	if value == nil {
+		return Value{Tag: TagNull}, nil
+	}
+	tag := valueTag(object, property, typ, value)
+
+	if tag == TagCharacterString {
+		return Value{Tag: tag, Value: fmt.Sprint(value)}, nil
+	}
+	var number float64
+	switch v := value.(type) {
+	case bool:
+		if tag == TagBoolean {
+			return Value{Tag: tag, Value: v}, nil
+		}
+		if v {
+			number = 1
+		}
+	default:
+		var err error
+		if number, err = toFloat(value); err != nil {
+			return Value{}, err
+		}
+		if scale != 0 {
+			number /= scale
+		}
+	}
+
+	switch tag {
+	case TagBoolean:
+		return Value{Tag: tag, Value: number != 0}, nil
+	case TagReal:
+		return Value{Tag: tag, Value: float32(number)}, nil
+	case TagDouble:
+		return Value{Tag: tag, Value: number}, nil
+	case TagSigned:
+		return Value{Tag: tag, Value: int64(math.Round(number))}, nil
+	}
+	if number < 0 {
+		return Value{}, fmt.Errorf("值 %v 不能为负数", value)
+	}
+	return Value{Tag: tag, Value: uint64(math.Round(number))}, nil
+}
+
+// valueTag 确定写入值的应用标签
+func valueTag(object ObjectType, property PropertyID, typ string, value interface{}) byte {
+	if property == PropPresentValue || property == PropRelinquishDefault {
+		switch object {
+		case AnalogInput, AnalogOutput, AnalogValue:
+			return TagReal
+		case BinaryInput, BinaryOutput, BinaryValue:
+			return TagEnumerated
+		case MultiStateInput, MultiStateOutput, MultiStateValue, PositiveIntegerValue:
+			return TagUnsigned
+		case IntegerValue:
+			return TagSigned
+		case LargeAnalogValue:
+			return TagDouble
+		}
+	}
+	switch typ {
+	case ValueFloat:
+		return TagReal
+	case ValueBool:
+		return TagBoolean
+	case ValueString:
+		return TagCharacterString
+	case ValueInt:
+		if f, err := toFloat(value); err == nil && f < 0 {
+			return TagSigned
+		}
+		return TagUnsigned
+	}
+	switch v := value.(type) {
+	case bool:
+		return TagBoolean
+	case string:
+		return TagCharacterString
+	case float32, float64:
+		return TagReal
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return TagReal
+		}
+	}
+	if f, err := toFloat(value); err == nil && f < 0 {
+		return TagSigned
+	}
+	return TagUnsigned
+}
+
+// toFloat 将数值或数字字符串转换为 float64
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("%v 不是数值", value)
+}
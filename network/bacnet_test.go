@@ -0,0 +1,319 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/network/bacnet"
+)
+
+// bacnetTestDevice 测试用 BACnet 设备，rpm 为 false 时拒绝 ReadPropertyMultiple,订阅 COV 后对象的值变化时发送通知
+type bacnetTestDevice struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	instance uint32
+	rpm      bool
+
+	mu          sync.Mutex
+	values      map[bacnet.PropertyRef][]bacnet.Value
+	priorities  map[bacnet.PropertyRef]byte
+	subscribers map[bacnet.ObjectID]bacnetTestSubscriber
+}
+
+type bacnetTestSubscriber struct {
+	addr      *net.UDPAddr
+	processID uint32
+}
+
+func newBACnetTestDevice(t *testing.T, ip net.IP, instance uint32, rpm bool, values map[bacnet.PropertyRef][]bacnet.Value) *bacnetTestDevice {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	d := &bacnetTestDevice{t: t, conn: conn, instance: instance, rpm: rpm, values: values,
+		priorities: make(map[bacnet.PropertyRef]byte), subscribers: make(map[bacnet.ObjectID]bacnetTestSubscriber)}
+	go d.serve()
+	return d
+}
+
+func (d *bacnetTestDevice) serve() {
+	buffer := make([]byte, 2048)
+	for {
+		n, addr, err := d.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		packet, err := bacnet.Unmarshal(buffer[:n])
+		if err != nil {
+			d.t.Error(err)
+			continue
+		}
+		apdu, err := bacnet.UnmarshalAPDU(packet.APDU)
+		if err != nil {
+			d.t.Error(err)
+			continue
+		}
+		if apdu.Type == bacnet.PDUUnconfirmedRequest && apdu.Service == bacnet.ServiceWhoIs {
+			if low, high, err := bacnet.DecodeWhoIs(apdu.Data); err == nil && d.instance >= low && d.instance <= high {
+				iAm := bacnet.IAm{Device: bacnet.ObjectID{Type: bacnet.Device, Instance: d.instance}, MaxAPDU: 1476, Segmentation: bacnet.SegmentationNone, VendorID: 15}
+				d.send(addr, &bacnet.APDU{Type: bacnet.PDUUnconfirmedRequest, Service: bacnet.ServiceIAm, Data: iAm.Encode()})
+			}
+			continue
+		}
+		if apdu.Type == bacnet.PDUConfirmedRequest {
+			reply, notify := d.respond(addr, apdu)
+			d.send(addr, reply)
+			if notify != nil {
+				d.notify(*notify)
+			}
+		}
+	}
+}
+
+// respond 处理确认请求，写入或订阅的对象需要发送 COV 通知时返回该对象
+func (d *bacnetTestDevice) respond(addr *net.UDPAddr, req *bacnet.APDU) (*bacnet.APDU, *bacnet.ObjectID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ack := &bacnet.APDU{Type: bacnet.PDUComplexAck, InvokeID: req.InvokeID, Service: req.Service}
+	fail := func(code uint32) *bacnet.APDU {
+		return &bacnet.APDU{Type: bacnet.PDUError, InvokeID: req.InvokeID, Service: req.Service, Data: bacnet.EncodeError(bacnet.ErrorClassObject, code)}
+	}
+	var err error
+	switch req.Service {
+	case bacnet.ServiceReadProperty:
+		ref, _ := bacnet.DecodeReadProperty(req.Data)
+		values, ok := d.values[ref]
+		if !ok {
+			return fail(bacnet.ErrorUnknownObject), nil
+		}
+		ack.Data, err = bacnet.EncodeReadPropertyAck(ref, values)
+	case bacnet.ServiceReadPropertyMultiple:
+		if !d.rpm {
+			return &bacnet.APDU{Type: bacnet.PDUReject, InvokeID: req.InvokeID, Reason: bacnet.RejectUnrecognizedService}, nil
+		}
+		refs, _ := bacnet.DecodeReadPropertyMultiple(req.Data)
+		results := make([]bacnet.PropertyResult, 0, len(refs))
+		for _, ref := range refs {
+			result := bacnet.PropertyResult{Ref: ref, Values: d.values[ref]}
+			if result.Values == nil {
+				result.Err = &bacnet.Error{Class: bacnet.ErrorClassObject, Code: bacnet.ErrorUnknownObject}
+			}
+			results = append(results, result)
+		}
+		ack.Data, err = bacnet.EncodeReadPropertyMultipleAck(results)
+	case bacnet.ServiceWriteProperty:
+		write, _ := bacnet.DecodeWriteProperty(req.Data)
+		if _, ok := d.values[write.Ref]; !ok {
+			return fail(bacnet.ErrorUnknownObject), nil
+		}
+		d.values[write.Ref] = write.Values
+		d.priorities[write.Ref] = write.Priority
+		ack = &bacnet.APDU{Type: bacnet.PDUSimpleAck, InvokeID: req.InvokeID, Service: req.Service}
+		if _, ok := d.subscribers[write.Ref.Object]; ok {
+			return ack, &write.Ref.Object
+		}
+		return ack, nil
+	case bacnet.ServiceSubscribeCOV:
+		subscribe, _ := bacnet.DecodeSubscribeCOV(req.Data)
+		d.subscribers[subscribe.Object] = bacnetTestSubscriber{addr: addr, processID: subscribe.ProcessID}
+		// 订阅后立即发送一次当前值
+		return &bacnet.APDU{Type: bacnet.PDUSimpleAck, InvokeID: req.InvokeID, Service: req.Service}, &subscribe.Object
+	default:
+		return &bacnet.APDU{Type: bacnet.PDUReject, InvokeID: req.InvokeID, Reason: bacnet.RejectUnrecognizedService}, nil
+	}
+	if err != nil {
+		d.t.Error(err)
+	}
+	return ack, nil
+}
+
+// notify 发送对象的 presentValue 与 statusFlags 的非确认 COV 通知
+func (d *bacnetTestDevice) notify(object bacnet.ObjectID) {
+	d.mu.Lock()
+	subscriber := d.subscribers[object]
+	notification := &bacnet.COVNotification{
+		ProcessID:     subscriber.processID,
+		Device:        bacnet.ObjectID{Type: bacnet.Device, Instance: d.instance},
+		Object:        object,
+		TimeRemaining: 300,
+		Values: []bacnet.PropertyValue{
+			{Property: bacnet.PropPresentValue, Index: bacnet.IndexAll, Values: d.values[bacnet.PropertyRef{Object: object, Property: bacnet.PropPresentValue, Index: bacnet.IndexAll}]},
+			{Property: bacnet.PropStatusFlags, Index: bacnet.IndexAll, Values: []bacnet.Value{{Tag: bacnet.TagBitString, Value: []bool{false, false, false, false}}}},
+		},
+	}
+	d.mu.Unlock()
+	data, err := notification.Encode()
+	if err != nil {
+		d.t.Error(err)
+		return
+	}
+	d.send(subscriber.addr, &bacnet.APDU{Type: bacnet.PDUUnconfirmedRequest, Service: bacnet.ServiceUnconfirmedCOVNotification, Data: data})
+}
+
+func (d *bacnetTestDevice) send(addr *net.UDPAddr, apdu *bacnet.APDU) {
+	packet := &bacnet.Packet{Function: bacnet.BVLCOriginalUnicastNPDU, NPDU: bacnet.NPDU{APDU: apdu.Marshal()}}
+	data, err := packet.Marshal()
+	if err != nil {
+		d.t.Error(err)
+		return
+	}
+	d.conn.WriteToUDP(data, addr)
+}
+
+func (d *bacnetTestDevice) value(ref bacnet.PropertyRef) ([]bacnet.Value, byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.values[ref], d.priorities[ref]
+}
+
+func bacnetTestRef(object bacnet.ObjectType, instance uint32, property bacnet.PropertyID) bacnet.PropertyRef {
+	return bacnet.PropertyRef{Object: bacnet.ObjectID{Type: object, Instance: instance}, Property: property, Index: bacnet.IndexAll}
+}
+
+func TestBACnetServer(t *testing.T) {
+	supplyTemp := bacnetTestRef(bacnet.AnalogInput, 1, bacnet.PropPresentValue)
+	setpoint := bacnetTestRef(bacnet.AnalogValue, 2, bacnet.PropPresentValue)
+	fan := bacnetTestRef(bacnet.BinaryOutput, 1, bacnet.PropPresentValue)
+	damper := bacnetTestRef(bacnet.AnalogOutput, 1, bacnet.PropPresentValue)
+	// 空调机组通过 Who-Is 发现，支持 ReadPropertyMultiple 与 COV
+	ahu := newBACnetTestDevice(t, net.IPv4(127, 0, 0, 1), 1001, true, map[bacnet.PropertyRef][]bacnet.Value{
+		supplyTemp: {{Tag: bacnet.TagReal, Value: float32(21.3)}},
+		bacnetTestRef(bacnet.AnalogInput, 1, bacnet.PropStatusFlags): {{Tag: bacnet.TagBitString, Value: []bool{false, true, false, false}}},
+		setpoint: {{Tag: bacnet.TagReal, Value: float32(22)}},
+		fan:      {{Tag: bacnet.TagEnumerated, Value: uint64(1)}},
+	})
+	// 末端按地址访问，只支持 ReadProperty
+	vav := newBACnetTestDevice(t, net.IPv4(127, 0, 0, 2), 2002, false, map[bacnet.PropertyRef][]bacnet.Value{
+		damper: {{Tag: bacnet.TagReal, Value: float32(45)}},
+		bacnetTestRef(bacnet.MultiStateValue, 1, bacnet.PropPresentValue): {{Tag: bacnet.TagUnsigned, Value: uint64(2)}},
+	})
+
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	localAddr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	// 监听器需要在服务器启动前注册
+	reports := make(chan map[string]interface{}, 16)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		switch e.Data()["DeviceKey"] {
+		case "AHU-01", "VAV-01":
+			reports <- e.Data()
+		}
+		return nil
+	}))
+
+	server := NewBACnetServer(WithBACnetConfig(conf.BACnetConfig{
+		Broadcast: ahu.conn.LocalAddr().String(), // 测试中 Who-Is 只发送给空调机组
+		Timeout:   200 * time.Millisecond,
+		Devices: []conf.BACnetDeviceConfig{
+			{DeviceKey: "AHU-01", Instance: 1001, Interval: time.Hour, Objects: []conf.BACnetObjectConfig{
+				{Name: "supplyTemp", Object: "analogInput:1"},
+				{Name: "supplyStatus", Object: "analog-input:1", Property: "status-flags"},
+				{Name: "setpoint", Object: "analogValue:2", COV: true, Priority: 8},
+				{Name: "fan", Object: "binaryOutput:1", Type: bacnet.ValueBool},
+				{Name: "missing", Object: "analogInput:9"},
+			}},
+			{DeviceKey: "VAV-01", Instance: 2002, Addr: vav.conn.LocalAddr().String(), Interval: time.Hour, Objects: []conf.BACnetObjectConfig{
+				{Name: "damper", Object: "analogOutput:1", Scale: 0.01},
+				{Name: "occupancy", Object: "multiStateValue:1", Type: bacnet.ValueInt},
+			}},
+		},
+	})).(*BACnetServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, localAddr.String())
+
+	// 两个设备的轮询与订阅后的 COV 通知各上报一次，顺序不定
+	expectReports := func(want ...g.Map) {
+		t.Helper()
+		for len(want) > 0 {
+			select {
+			case report := <-reports:
+				found := false
+				for i, w := range want {
+					if report["DeviceKey"] == w["DeviceKey"] && reflect.DeepEqual(report["PropertieDataList"], w["PropertieDataList"]) {
+						want = append(want[:i], want[i+1:]...)
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Fatalf("设备 %s 上报 %v", report["DeviceKey"], report["PropertieDataList"])
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("没有上报 %v", want)
+			}
+		}
+	}
+	expectReports(
+		g.Map{"DeviceKey": "AHU-01", "PropertieDataList": map[string]interface{}{
+			"supplyTemp": 21.3, "supplyStatus": "0100", "setpoint": 22.0, "fan": true,
+		}},
+		g.Map{"DeviceKey": "AHU-01", "PropertieDataList": map[string]interface{}{"setpoint": 22.0}},
+		g.Map{"DeviceKey": "VAV-01", "PropertieDataList": map[string]interface{}{"damper": 0.45, "occupancy": int64(2)}},
+	)
+	device := server.LookupDevice("VAV-01")
+	if device == nil || server.LookupDevice("AHU-01") == nil {
+		t.Fatal("读取成功后设备应上线")
+	}
+
+	// Who-Is 发现
+	discoverCtx, discoverCancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer discoverCancel()
+	iAms, err := server.Discover(discoverCtx, 0, bacnet.MaxInstance)
+	if err != nil || len(iAms) != 1 || iAms[0].Device.Instance != 1001 || iAms[0].Addr.String() != ahu.conn.LocalAddr().String() {
+		t.Fatalf("发现的设备 %+v %v", iAms, err)
+	}
+
+	// 属性设置以 WriteProperty 写入，值变化后的 COV 通知立即上报
+	reply, err := server.SetProperties(ctx, nil, server.LookupDevice("AHU-01"), map[string]interface{}{"setpoint": 23.5, "fan": false})
+	if err != nil || !reflect.DeepEqual(reply, map[string]interface{}{"setpoint": 23.5, "fan": false}) {
+		t.Fatalf("属性设置返回 %v %v", reply, err)
+	}
+	if values, priority := ahu.value(setpoint); !reflect.DeepEqual(values, []bacnet.Value{{Tag: bacnet.TagReal, Value: float32(23.5)}}) || priority != 8 {
+		t.Fatalf("写入的设定值 %v 优先级 %d", values, priority)
+	}
+	if values, _ := ahu.value(fan); !reflect.DeepEqual(values, []bacnet.Value{{Tag: bacnet.TagEnumerated, Value: uint64(0)}}) {
+		t.Fatalf("写入的风机命令 %v", values)
+	}
+	expectReports(g.Map{"DeviceKey": "AHU-01", "PropertieDataList": map[string]interface{}{"setpoint": 23.5}})
+
+	// SendData 按缩放写入只支持 ReadProperty 的设备
+	if err := server.SendData(device, map[string]interface{}{"damper": 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := vav.value(damper); !reflect.DeepEqual(values, []bacnet.Value{{Tag: bacnet.TagReal, Value: float32(50)}}) {
+		t.Fatalf("写入的风阀开度 %v", values)
+	}
+	if err := server.SendData(device, map[string]interface{}{"unknown": 1}); err == nil {
+		t.Fatal("没有已映射属性的下发应返回错误")
+	}
+	var bacnetErr *bacnet.Error
+	if err := server.SendData(server.LookupDevice("AHU-01"), map[string]interface{}{"missing": 1}); !errors.As(err, &bacnetErr) || bacnetErr.Code != bacnet.ErrorUnknownObject {
+		t.Fatalf("写入不存在的对象返回 %v", err)
+	}
+	values, err := server.ReadProperty(ctx, "VAV-01", damper)
+	if err != nil || !reflect.DeepEqual(values, []bacnet.Value{{Tag: bacnet.TagReal, Value: float32(50)}}) {
+		t.Fatalf("读取风阀开度 %v %v", values, err)
+	}
+
+	// 设备无响应时离线，发现的地址失效
+	ahu.conn.Close()
+	server.poll(ctx, server.configured["AHU-01"])
+	if server.LookupDevice("AHU-01") != nil || server.configured["AHU-01"].addr != nil {
+		t.Fatal("轮询超时后设备应离线")
+	}
+}
@@ -0,0 +1,812 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/coap"
+)
+
+// CoAP 传输参数(RFC 7252 4.8)
+const (
+	coapAckRandomFactor  = 1.5
+	coapExchangeLifetime = 247 * time.Second
+	coapMaxDatagramSize  = 64 * 1024
+)
+
+var (
+	// ErrCoAPReset 设备以 RST 拒绝了请求
+	ErrCoAPReset = errors.New("CoAP 请求被设备复位")
+	// ErrCoAPTimeout CON 请求重传次数用尽仍未收到确认
+	ErrCoAPTimeout = errors.New("CoAP 请求重传超时")
+)
+
+// CoAPServer 结构体表示 CoAP(RFC 7252)服务器，用于 NB-IoT 等受限设备接入
+// 设备以 {Path}/{deviceKey} 资源上报数据，请求体交给协议处理器，Decode 的回复作为响应体
+// 下发数据时以 CoAP 请求发往设备最近一次上报的地址
+type CoAPServer struct {
+	*BaseServer
+	conn      *net.UDPConn
+	messageID atomic.Uint32
+	addrs     sync.Map // 设备标识 -> 设备最近一次上报的地址
+
+	// route 替换默认的请求处理，用于 LwM2M 等基于 CoAP 的协议，返回的函数在响应发送后执行
+	route func(addr *net.UDPAddr, req *coap.Message) (*coap.Message, func())
+
+	mu        sync.Mutex
+	pending   map[string]*coapExchange   // 地址|消息ID -> 等待确认的 CON 请求
+	tokens    map[string]*coapExchange   // Token -> 等待响应的请求或观察
+	dedup     map[string]*coapCacheEntry // 地址|消息ID -> 已发送的响应，用于重复报文去重
+	uploads   map[string]*coapCacheEntry // 地址|路径 -> Block1 接收中的请求体
+	downloads map[string]*coapCacheEntry // 地址|路径 -> Block2 发送中的响应体
+}
+
+// coapExchange 表示一次发往设备的请求，观察请求在取消前一直保留
+type coapExchange struct {
+	ackOnce  sync.Once
+	acked    chan struct{}
+	response chan *coap.Message
+	notify   func(*coap.Message) // 观察通知回调
+
+	mu          sync.Mutex
+	observeSeq  uint32
+	observeTime time.Time
+}
+
+// coapCacheEntry 表示按地址缓存的报文或块传输数据
+type coapCacheEntry struct {
+	data []byte
+	code coap.Code
+	time time.Time
+}
+
+// NewCoAPServer 创建一个新的 CoAP 服务器实例
+func NewCoAPServer(options ...Option) NetworkServer {
+	s := &CoAPServer{
+		BaseServer: NewBaseServer(options...),
+		pending:    make(map[string]*coapExchange),
+		tokens:     make(map[string]*coapExchange),
+		dedup:      make(map[string]*coapCacheEntry),
+		uploads:    make(map[string]*coapCacheEntry),
+		downloads:  make(map[string]*coapCacheEntry),
+	}
+	return s
+}
+
+// Start 启动 CoAP 服务器
+func (s *CoAPServer) Start(ctx context.Context, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("解析 UDP 地址失败: %v", err)
+	}
+	s.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("CoAP 监听失败: %v", err)
+	}
+	s.messageID.Store(mrand.Uint32N(1 << 16))
+
+	if s.route == nil {
+		go s.cleanupInactiveDevices(ctx) // 自定义请求处理时由其管理设备的在线状态
+	}
+	go s.cleanupExchanges(ctx)
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	buffer := make([]byte, coapMaxDatagramSize)
+	for {
+		n, remoteAddr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil // 正常关闭
+			}
+			glog.Debugf(context.Background(), "读取 CoAP 数据失败: %v", err)
+			continue
+		}
+		msg, err := coap.Unmarshal(buffer[:n])
+		if err != nil {
+			glog.Debugf(context.Background(), "解析 CoAP 报文失败 %s: %v\n", remoteAddr, err)
+			if n >= 4 && buffer[0]>>4 == 0x04 { // 格式错误的 CON 报文以 RST 拒绝
+				s.sendEmpty(remoteAddr, coap.Reset, binary.BigEndian.Uint16(buffer[2:4]))
+			}
+			continue
+		}
+		s.handleMessage(remoteAddr, msg)
+	}
+}
+
+// Stop 停止 CoAP 服务器
+func (s *CoAPServer) Stop() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// SendData 以 CON POST 请求向设备的 DownPath 资源下发数据，并等待设备确认
+func (s *CoAPServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	var encodedData []byte
+	var err error
+
+	if s.protocolHandler != nil {
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else {
+		encodedData = []byte(fmt.Sprintf("%v", data))
+	}
+	if encodedData, err = s.encodeFrame(encodedData); err != nil {
+		return fmt.Errorf("数据成帧失败: %v", err)
+	}
+
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, Payload: encodedData}
+	req.SetPath(s.downPath())
+	ctx, cancel := context.WithTimeout(context.Background(), s.exchangeTimeout())
+	defer cancel()
+	resp, err := s.Request(ctx, device, req)
+	if err != nil {
+		return fmt.Errorf("CoAP 下发失败: %w", err)
+	}
+	if !resp.Code.IsSuccess() {
+		return fmt.Errorf("CoAP 下发失败: 设备返回 %s %s", resp.Code, resp.Payload)
+	}
+	return nil
+}
+
+// Request 向设备发送 CoAP 请求并等待响应，消息ID与 Token 由服务器生成
+// 请求体超过块大小时按 Block1 分块发送，响应按 Block2 分块时自动获取全部块后合并返回
+func (s *CoAPServer) Request(ctx context.Context, device *model.Device, req *coap.Message) (*coap.Message, error) {
+	addr, err := s.deviceAddr(device)
+	if err != nil {
+		return nil, err
+	}
+	if req.Type != coap.Confirmable && req.Type != coap.NonConfirmable {
+		return nil, fmt.Errorf("CoAP 请求类型错误: %s", req.Type)
+	}
+
+	resp, err := s.requestBlock1(ctx, addr, req)
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok, err := resp.Block(coap.Block2)
+	if err != nil || !ok || !block.More {
+		return resp, err
+	}
+	payload := append([]byte(nil), resp.Payload...)
+	for block.More {
+		next := s.copyRequest(req, nil)
+		next.RemoveOption(coap.Block1)
+		next.SetBlock(coap.Block2, coap.Block{Num: block.Num + 1, SZX: block.SZX})
+		part, err := s.exchange(ctx, addr, next, newCoAPExchange(nil))
+		if err != nil {
+			return nil, err
+		}
+		if !part.Code.IsSuccess() {
+			return part, nil
+		}
+		if block, ok, err = part.Block(coap.Block2); err != nil {
+			return nil, err
+		} else if !ok {
+			block.More = false
+		}
+		payload = append(payload, part.Payload...)
+		if len(payload) > s.maxFrameLength() {
+			return nil, ErrFrameTooLong
+		}
+	}
+	resp.Payload = payload
+	resp.RemoveOption(coap.Block2)
+	return resp, nil
+}
+
+// Observe 观察设备资源(RFC 7641)，首个响应与之后的每个通知都交给 handler 处理
+// handler 为 nil 时通知的负载交给协议处理器，ctx 取消后停止观察
+func (s *CoAPServer) Observe(ctx context.Context, device *model.Device, path string, handler func(device *model.Device, msg *coap.Message)) (*coap.Message, error) {
+	addr, err := s.deviceAddr(device)
+	if err != nil {
+		return nil, err
+	}
+	if handler == nil {
+		handler = s.handleNotify
+	}
+
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.GET, Token: newCoAPToken()}
+	req.SetPath(path)
+	req.SetUint(coap.Observe, 0)
+	ex := newCoAPExchange(func(msg *coap.Message) { handler(device, msg) })
+	resp, err := s.exchange(ctx, addr, req, ex)
+	if err != nil {
+		s.forget(req.Token)
+		return nil, err
+	}
+	if _, ok := resp.Uint(coap.Observe); !ok || !resp.Code.IsSuccess() {
+		s.forget(req.Token)
+		return resp, fmt.Errorf("设备资源 %s 不支持观察: %s", path, resp.Code)
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.forget(req.Token)
+		// 主动取消观察，设备未收到时会在下一次通知被 RST 拒绝后取消
+		cancelReq := &coap.Message{Type: coap.NonConfirmable, Code: coap.GET, Token: req.Token, MessageID: s.nextMessageID()}
+		cancelReq.SetPath(path)
+		cancelReq.SetUint(coap.Observe, 1)
+		s.send(addr, cancelReq)
+	}()
+	return resp, nil
+}
+
+// handleNotify 将观察通知的负载交给协议处理器
+func (s *CoAPServer) handleNotify(device *model.Device, msg *coap.Message) {
+	if !msg.Code.IsSuccess() {
+		glog.Debugf(context.Background(), "CoAP 设备 %s 观察通知错误: %s\n", device.DeviceKey, msg.Code)
+		return
+	}
+	resData, err := s.handleReceiveData(device, msg.Payload)
+	if err != nil {
+		glog.Debugf(context.Background(), "处理数据错误: %v\n", err)
+		return
+	}
+	if resData != nil {
+		if err := s.SendData(device, resData); err != nil {
+			glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
+		}
+	}
+}
+
+// handleMessage 按报文类型分发：请求交给请求处理，确认、复位与响应交给对应的请求
+func (s *CoAPServer) handleMessage(addr *net.UDPAddr, msg *coap.Message) {
+	switch {
+	case msg.Code.IsRequest():
+		go s.handleRequest(addr, msg) // 协议处理器中可能同步下发数据，不能阻塞读取
+	case msg.Code == coap.Empty && msg.Type == coap.Confirmable:
+		s.sendEmpty(addr, coap.Reset, msg.MessageID) // CoAP Ping
+	default:
+		s.handleResponse(addr, msg)
+	}
+}
+
+// handleResponse 处理设备的确认、复位与响应报文
+func (s *CoAPServer) handleResponse(addr *net.UDPAddr, msg *coap.Message) {
+	if msg.Type == coap.Acknowledgement || msg.Type == coap.Reset {
+		key := coapKey(addr, msg.MessageID)
+		s.mu.Lock()
+		ex := s.pending[key]
+		delete(s.pending, key)
+		s.mu.Unlock()
+		if ex == nil {
+			return
+		}
+		ex.ack()
+		if msg.Type == coap.Reset || msg.Code != coap.Empty {
+			ex.deliver(msg) // 复位或附带响应
+		}
+		return // 空 ACK 表示分离响应，继续按 Token 等待
+	}
+	if msg.Code == coap.Empty {
+		return
+	}
+
+	s.mu.Lock()
+	ex := s.tokens[string(msg.Token)]
+	s.mu.Unlock()
+	if ex == nil {
+		if msg.Type == coap.Confirmable {
+			s.sendEmpty(addr, coap.Reset, msg.MessageID) // 未知的响应或已取消的观察
+		}
+		return
+	}
+	if msg.Type == coap.Confirmable {
+		s.sendEmpty(addr, coap.Acknowledgement, msg.MessageID)
+	}
+	ex.deliver(msg)
+}
+
+// handleRequest 处理设备请求，重复的报文直接重发缓存的响应(RFC 7252 4.5)
+func (s *CoAPServer) handleRequest(addr *net.UDPAddr, req *coap.Message) {
+	key := coapKey(addr, req.MessageID)
+	s.mu.Lock()
+	if entry, ok := s.dedup[key]; ok {
+		data := entry.data
+		s.mu.Unlock()
+		if data != nil {
+			s.conn.WriteToUDP(data, addr)
+		}
+		return // 处理中的重复报文直接丢弃
+	}
+	entry := &coapCacheEntry{time: time.Now()}
+	s.dedup[key] = entry
+	s.mu.Unlock()
+
+	var resp *coap.Message
+	var after func()
+	if s.route != nil {
+		resp, after = s.route(addr, req)
+	} else {
+		resp = s.serveRequest(addr, req)
+	}
+	resp.Token = req.Token
+	if req.Type == coap.Confirmable {
+		resp.Type, resp.MessageID = coap.Acknowledgement, req.MessageID // 附带响应
+	} else {
+		resp.Type, resp.MessageID = coap.NonConfirmable, s.nextMessageID()
+	}
+	data, err := resp.Marshal()
+	if err != nil {
+		glog.Debugf(context.Background(), "编码 CoAP 响应失败: %v\n", err)
+		return
+	}
+	s.mu.Lock()
+	entry.data = data
+	s.mu.Unlock()
+	if _, err := s.conn.WriteToUDP(data, addr); err != nil {
+		glog.Debugf(context.Background(), "发送 CoAP 响应失败: %v\n", err)
+	}
+	if after != nil {
+		after()
+	}
+}
+
+// serveRequest 识别设备并处理请求，返回响应报文
+func (s *CoAPServer) serveRequest(addr *net.UDPAddr, req *coap.Message) *coap.Message {
+	deviceKey := s.requestDeviceKey(req)
+	if deviceKey == "" {
+		return &coap.Message{Code: coap.NotFound}
+	}
+	transferKey := addr.String() + "|" + req.Path()
+
+	// Block2 后续块直接从缓存的响应体中获取，不再重复处理
+	block2, ok, err := req.Block(coap.Block2)
+	if err != nil {
+		return &coap.Message{Code: coap.BadOption, Payload: []byte(err.Error())}
+	}
+	if ok && block2.Num > 0 {
+		return s.nextBlock(transferKey, block2)
+	}
+
+	payload := req.Payload
+	block1, hasBlock1, err := req.Block(coap.Block1)
+	if err != nil {
+		return &coap.Message{Code: coap.BadOption, Payload: []byte(err.Error())}
+	}
+	if hasBlock1 {
+		var resp *coap.Message
+		if payload, resp = s.receiveBlock(transferKey, block1, req.Payload); resp != nil {
+			return resp
+		}
+	}
+
+	device := s.getDevice(deviceKey)
+	if device == nil {
+		device = s.handleConnect(deviceKey, nil)
+		s.bindDevice(device, deviceKey)
+	}
+	s.addrs.Store(deviceKey, addr)
+
+	resData, err := s.handleReceiveData(device, payload)
+	if err != nil {
+		glog.Debugf(context.Background(), "处理 CoAP 设备 %s 数据错误: %v\n", deviceKey, err)
+		return &coap.Message{Code: coap.BadRequest, Payload: []byte(err.Error())}
+	}
+
+	resp := &coap.Message{Code: coapResponseCode(req.Code)}
+	if resData != nil && s.protocolHandler != nil {
+		if resp.Payload, err = s.protocolHandler.Encode(device, resData); err != nil {
+			glog.Debugf(context.Background(), "编码回复失败: %v\n", err)
+			return &coap.Message{Code: coap.InternalServerError}
+		}
+	}
+	if hasBlock1 {
+		resp.SetBlock(coap.Block1, block1)
+	}
+	return s.firstBlock(transferKey, req, resp)
+}
+
+// receiveBlock 接收 Block1 分块的请求体，未接收完时返回 2.31 Continue 响应
+func (s *CoAPServer) receiveBlock(key string, block coap.Block, part []byte) ([]byte, *coap.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.uploads[key]
+	if block.Num == 0 {
+		entry = &coapCacheEntry{}
+		s.uploads[key] = entry
+	}
+	if entry == nil || block.Offset() != len(entry.data) {
+		delete(s.uploads, key)
+		return nil, &coap.Message{Code: coap.RequestEntityIncomplete}
+	}
+	if len(entry.data)+len(part) > s.maxFrameLength() {
+		delete(s.uploads, key)
+		return nil, &coap.Message{Code: coap.RequestEntityTooLarge}
+	}
+	entry.data = append(entry.data, part...)
+	entry.time = time.Now()
+	if block.More {
+		resp := &coap.Message{Code: coap.Continue}
+		resp.SetBlock(coap.Block1, block)
+		return nil, resp
+	}
+	delete(s.uploads, key)
+	return entry.data, nil
+}
+
+// firstBlock 响应体超过块大小时缓存完整响应体，返回第一块
+func (s *CoAPServer) firstBlock(key string, req, resp *coap.Message) *coap.Message {
+	size := s.blockSize()
+	if block, ok, _ := req.Block(coap.Block2); ok && block.Size() < size {
+		size = block.Size() // 设备要求更小的块
+	}
+	if len(resp.Payload) <= size {
+		return resp
+	}
+	s.mu.Lock()
+	s.downloads[key] = &coapCacheEntry{data: resp.Payload, code: resp.Code, time: time.Now()}
+	s.mu.Unlock()
+
+	block := coap.Block{SZX: coap.SZXForSize(size)}
+	total := len(resp.Payload)
+	resp.Payload, _ = block.Slice(resp.Payload)
+	resp.SetBlock(coap.Block2, block)
+	resp.SetUint(coap.Size2, uint32(total))
+	return resp
+}
+
+// nextBlock 从缓存的响应体中获取 Block2 后续块
+func (s *CoAPServer) nextBlock(key string, block coap.Block) *coap.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.downloads[key]
+	if entry == nil {
+		return &coap.Message{Code: coap.RequestEntityIncomplete}
+	}
+	part, err := block.Slice(entry.data)
+	if err != nil {
+		return &coap.Message{Code: coap.BadOption, Payload: []byte(err.Error())}
+	}
+	entry.time = time.Now()
+	if !block.More {
+		delete(s.downloads, key)
+	}
+	resp := &coap.Message{Code: entry.code, Payload: part}
+	resp.SetBlock(coap.Block2, block)
+	return resp
+}
+
+// requestBlock1 发送请求，请求体超过块大小时按 Block1 分块依次发送
+func (s *CoAPServer) requestBlock1(ctx context.Context, addr *net.UDPAddr, req *coap.Message) (*coap.Message, error) {
+	size := s.blockSize()
+	if len(req.Payload) <= size {
+		return s.exchange(ctx, addr, s.copyRequest(req, req.Payload), newCoAPExchange(nil))
+	}
+
+	block := coap.Block{SZX: coap.SZXForSize(size)}
+	for {
+		part, err := block.Slice(req.Payload)
+		if err != nil {
+			return nil, err
+		}
+		msg := s.copyRequest(req, part)
+		msg.SetBlock(coap.Block1, block)
+		resp, err := s.exchange(ctx, addr, msg, newCoAPExchange(nil))
+		if err != nil {
+			return nil, err
+		}
+		if !block.More || resp.Code != coap.Continue {
+			return resp, nil
+		}
+
+		// 设备可以在响应中要求更小的块
+		offset := block.Offset() + block.Size()
+		if accepted, ok, _ := resp.Block(coap.Block1); ok && accepted.SZX < block.SZX {
+			block.SZX = accepted.SZX
+		}
+		block.Num = uint32(offset / block.Size())
+	}
+}
+
+// exchange 发送一个报文并等待响应，CON 报文按指数退避重传直到收到确认
+func (s *CoAPServer) exchange(ctx context.Context, addr *net.UDPAddr, req *coap.Message, ex *coapExchange) (*coap.Message, error) {
+	req.MessageID = s.nextMessageID()
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	key := coapKey(addr, req.MessageID)
+	s.mu.Lock()
+	s.tokens[string(req.Token)] = ex
+	if req.Type == coap.Confirmable {
+		s.pending[key] = ex
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		if ex.notify == nil {
+			delete(s.tokens, string(req.Token))
+		}
+		s.mu.Unlock()
+	}()
+
+	if _, err := s.conn.WriteToUDP(data, addr); err != nil {
+		return nil, err
+	}
+
+	// 初始超时在 [AckTimeout, AckTimeout*1.5] 之间随机，每次重传加倍
+	var retransmit <-chan time.Time
+	if req.Type == coap.Confirmable {
+		timeout := time.Duration(float64(s.ackTimeout()) * (1 + mrand.Float64()*(coapAckRandomFactor-1)))
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		retransmit = timer.C
+		for attempt := 0; ; {
+			select {
+			case resp := <-ex.response:
+				return coapResult(resp)
+			case <-ex.acked:
+				retransmit = nil
+			case <-retransmit:
+				if attempt >= s.maxRetransmit() {
+					return nil, ErrCoAPTimeout
+				}
+				attempt++
+				timeout *= 2
+				if _, err := s.conn.WriteToUDP(data, addr); err != nil {
+					return nil, err
+				}
+				timer.Reset(timeout)
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if retransmit == nil {
+				break
+			}
+		}
+	}
+
+	select {
+	case resp := <-ex.response:
+		return coapResult(resp)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// coapResult 将设备的复位转换为错误
+func coapResult(resp *coap.Message) (*coap.Message, error) {
+	if resp.Type == coap.Reset {
+		return nil, ErrCoAPReset
+	}
+	return resp, nil
+}
+
+// copyRequest 复制请求的方法与选项，每个报文使用新的 Token 并设置负载
+func (s *CoAPServer) copyRequest(req *coap.Message, payload []byte) *coap.Message {
+	return &coap.Message{
+		Type:    req.Type,
+		Code:    req.Code,
+		Token:   newCoAPToken(),
+		Options: append([]coap.Option(nil), req.Options...),
+		Payload: payload,
+	}
+}
+
+// forget 删除观察请求
+func (s *CoAPServer) forget(token []byte) {
+	s.mu.Lock()
+	delete(s.tokens, string(token))
+	s.mu.Unlock()
+}
+
+// send 发送报文
+func (s *CoAPServer) send(addr *net.UDPAddr, msg *coap.Message) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteToUDP(data, addr)
+	return err
+}
+
+// sendEmpty 发送空的确认或复位报文
+func (s *CoAPServer) sendEmpty(addr *net.UDPAddr, typ coap.Type, messageID uint16) {
+	if err := s.send(addr, &coap.Message{Type: typ, MessageID: messageID}); err != nil {
+		glog.Debugf(context.Background(), "发送 CoAP %s 失败: %v\n", typ, err)
+	}
+}
+
+// cleanupExchanges 清理过期的去重记录与块传输缓存
+func (s *CoAPServer) cleanupExchanges(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, entry := range s.dedup {
+				if now.Sub(entry.time) > coapExchangeLifetime {
+					delete(s.dedup, key)
+				}
+			}
+			for _, transfers := range []map[string]*coapCacheEntry{s.uploads, s.downloads} {
+				for key, entry := range transfers {
+					if now.Sub(entry.time) > coapExchangeLifetime {
+						delete(transfers, key)
+					}
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// deviceAddr 获取设备最近一次上报的地址
+func (s *CoAPServer) deviceAddr(device *model.Device) (*net.UDPAddr, error) {
+	deviceKey := device.DeviceKey
+	if deviceKey == "" {
+		deviceKey = device.ClientID
+	}
+	if addr, ok := s.addrs.Load(deviceKey); ok {
+		return addr.(*net.UDPAddr), nil
+	}
+	return nil, fmt.Errorf("CoAP 设备 %s 地址未知，设备需先上报数据", deviceKey)
+}
+
+// requestDeviceKey 从资源路径 {Path}/{deviceKey} 或查询参数中获取设备标识
+func (s *CoAPServer) requestDeviceKey(req *coap.Message) string {
+	base := strings.Split(s.basePath(), "/")
+	segments := req.Segments()
+	if len(segments) < len(base) || strings.Join(segments[:len(base)], "/") != s.basePath() {
+		return ""
+	}
+	switch len(segments) - len(base) {
+	case 0:
+		query := s.coapConfig.DeviceKeyQuery
+		if query == "" {
+			query = "ep"
+		}
+		return req.Query(query)
+	case 1:
+		return segments[len(base)]
+	}
+	return ""
+}
+
+// basePath 获取接收数据的资源路径
+func (s *CoAPServer) basePath() string {
+	if path := strings.Trim(s.coapConfig.Path, "/"); path != "" {
+		return path
+	}
+	return "up"
+}
+
+// downPath 获取下发数据的资源路径
+func (s *CoAPServer) downPath() string {
+	if path := strings.Trim(s.coapConfig.DownPath, "/"); path != "" {
+		return path
+	}
+	return "down"
+}
+
+// blockSize 获取块传输的块大小
+func (s *CoAPServer) blockSize() int {
+	if s.coapConfig.BlockSize <= 0 {
+		return 512
+	}
+	return 1 << (coap.SZXForSize(s.coapConfig.BlockSize) + 4)
+}
+
+// ackTimeout 获取 CON 报文的初始确认超时
+func (s *CoAPServer) ackTimeout() time.Duration {
+	if s.coapConfig.AckTimeout <= 0 {
+		return 2 * time.Second
+	}
+	return s.coapConfig.AckTimeout
+}
+
+// maxRetransmit 获取 CON 报文的最大重传次数
+func (s *CoAPServer) maxRetransmit() int {
+	if s.coapConfig.MaxRetransmit <= 0 {
+		return 4
+	}
+	return s.coapConfig.MaxRetransmit
+}
+
+// exchangeTimeout 获取一次下发的最长等待时间：重传用尽的时间加上等待分离响应的时间
+func (s *CoAPServer) exchangeTimeout() time.Duration {
+	maxTransmitWait := time.Duration(float64(s.ackTimeout()) * float64(int(1)<<(s.maxRetransmit()+1)-1) * coapAckRandomFactor)
+	return maxTransmitWait + s.timeout
+}
+
+// nextMessageID 获取下一个消息ID
+func (s *CoAPServer) nextMessageID() uint16 {
+	return uint16(s.messageID.Add(1))
+}
+
+// newCoAPExchange 创建发往设备的请求，notify 不为 nil 时为观察请求
+func newCoAPExchange(notify func(*coap.Message)) *coapExchange {
+	return &coapExchange{
+		acked:    make(chan struct{}),
+		response: make(chan *coap.Message, 1),
+		notify:   notify,
+	}
+}
+
+// ack 标记请求已被确认，停止重传
+func (e *coapExchange) ack() {
+	e.ackOnce.Do(func() { close(e.acked) })
+}
+
+// deliver 交付响应，观察请求的通知按序号丢弃过期的乱序通知(RFC 7641 3.4)后交给回调
+func (e *coapExchange) deliver(msg *coap.Message) {
+	select {
+	case e.response <- msg:
+	default:
+	}
+	if e.notify == nil || msg.Type == coap.Reset || !e.fresh(msg) {
+		return
+	}
+	go e.notify(msg)
+}
+
+// fresh 判断观察通知是否比上一个通知更新
+func (e *coapExchange) fresh(msg *coap.Message) bool {
+	seq, ok := msg.Uint(coap.Observe)
+	if !ok {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if !e.observeTime.IsZero() {
+		last := e.observeSeq
+		newer := (last < seq && seq-last < 1<<23) || (last > seq && last-seq > 1<<23) || now.Sub(e.observeTime) > 128*time.Second
+		if !newer {
+			return false
+		}
+	}
+	e.observeSeq, e.observeTime = seq, now
+	return true
+}
+
+// coapKey 生成地址与消息ID组成的键
+func coapKey(addr *net.UDPAddr, messageID uint16) string {
+	return addr.String() + "|" + strconv.Itoa(int(messageID))
+}
+
+// coapResponseCode 根据请求方法获取成功响应码
+func coapResponseCode(method coap.Code) coap.Code {
+	switch method {
+	case coap.GET:
+		return coap.Content
+	case coap.DELETE:
+		return coap.Deleted
+	}
+	return coap.Changed
+}
+
+// newCoAPToken 生成随机 Token
+func newCoAPToken() []byte {
+	token := make([]byte, 8)
+	rand.Read(token)
+	return token
+}
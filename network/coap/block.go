@@ -0,0 +1,76 @@
+package coap
+
+import "fmt"
+
+// Block 块传输选项(RFC 7959)，用于 Block1(请求体分块)与 Block2(响应体分块)
+type Block struct {
+	Num  uint32 // 块序号
+	More bool   // 是否还有后续块
+	SZX  uint8  // 块大小指数，块大小为 2^(SZX+4)
+}
+
+// Size 返回块大小
+func (b Block) Size() int {
+	return 1 << (b.SZX + 4)
+}
+
+// Offset 返回块在完整数据中的偏移
+func (b Block) Offset() int {
+	return int(b.Num) * b.Size()
+}
+
+// Uint 编码为选项值
+func (b Block) Uint() uint32 {
+	v := b.Num<<4 | uint32(b.SZX)
+	if b.More {
+		v |= 0x08
+	}
+	return v
+}
+
+// ParseBlock 解析块传输选项值
+func ParseBlock(v uint32) (Block, error) {
+	b := Block{Num: v >> 4, More: v&0x08 != 0, SZX: uint8(v & 0x07)}
+	if b.SZX == 7 {
+		return b, fmt.Errorf("%w: 块大小指数 7 为保留值", ErrInvalidFormat)
+	}
+	return b, nil
+}
+
+// SZXForSize 返回不超过指定大小的块大小指数，size 取值 16~1024
+func SZXForSize(size int) uint8 {
+	var szx uint8
+	for szx < 6 && 1<<(szx+5) <= size {
+		szx++
+	}
+	return szx
+}
+
+// Block 返回指定的块传输选项
+func (m *Message) Block(id OptionID) (Block, bool, error) {
+	v, ok := m.Uint(id)
+	if !ok {
+		return Block{}, false, nil
+	}
+	b, err := ParseBlock(v)
+	return b, true, err
+}
+
+// SetBlock 设置块传输选项
+func (m *Message) SetBlock(id OptionID, b Block) {
+	m.SetUint(id, b.Uint())
+}
+
+// Slice 按块返回完整数据中对应的部分，并设置 More 标记
+func (b *Block) Slice(data []byte) ([]byte, error) {
+	offset := b.Offset()
+	if offset > len(data) || (offset == len(data) && offset > 0) {
+		return nil, fmt.Errorf("块序号 %d 超出数据范围", b.Num)
+	}
+	end := offset + b.Size()
+	b.More = end < len(data)
+	if end > len(data) {
+		end = len(data)
+	}
+	return data[offset:end], nil
+}
@@ -0,0 +1,431 @@
+// Package coap 实现了 CoAP(RFC 7252)报文的编解码，以及块传输(RFC 7959)与观察(RFC 7641)所需的选项
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Type CoAP 报文类型
+type Type uint8
+
+const (
+	Confirmable     Type = 0 // CON，需要确认
+	NonConfirmable  Type = 1 // NON，无需确认
+	Acknowledgement Type = 2 // ACK，确认
+	Reset           Type = 3 // RST，复位
+)
+
+// String 返回报文类型名称
+func (t Type) String() string {
+	switch t {
+	case Confirmable:
+		return "CON"
+	case NonConfirmable:
+		return "NON"
+	case Acknowledgement:
+		return "ACK"
+	case Reset:
+		return "RST"
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// Code CoAP 请求方法或响应码，高3位为类别，低5位为详情
+type Code uint8
+
+const (
+	Empty  Code = 0
+	GET    Code = 1
+	POST   Code = 2
+	PUT    Code = 3
+	DELETE Code = 4
+
+	Created  Code = 2<<5 | 1  // 2.01
+	Deleted  Code = 2<<5 | 2  // 2.02
+	Valid    Code = 2<<5 | 3  // 2.03
+	Changed  Code = 2<<5 | 4  // 2.04
+	Content  Code = 2<<5 | 5  // 2.05
+	Continue Code = 2<<5 | 31 // 2.31
+
+	BadRequest               Code = 4<<5 | 0  // 4.00
+	Unauthorized             Code = 4<<5 | 1  // 4.01
+	BadOption                Code = 4<<5 | 2  // 4.02
+	Forbidden                Code = 4<<5 | 3  // 4.03
+	NotFound                 Code = 4<<5 | 4  // 4.04
+	MethodNotAllowed         Code = 4<<5 | 5  // 4.05
+	NotAcceptable            Code = 4<<5 | 6  // 4.06
+	RequestEntityIncomplete  Code = 4<<5 | 8  // 4.08
+	PreconditionFailed       Code = 4<<5 | 12 // 4.12
+	RequestEntityTooLarge    Code = 4<<5 | 13 // 4.13
+	UnsupportedContentFormat Code = 4<<5 | 15 // 4.15
+
+	InternalServerError Code = 5<<5 | 0 // 5.00
+	NotImplemented      Code = 5<<5 | 1 // 5.01
+	ServiceUnavailable  Code = 5<<5 | 3 // 5.03
+	GatewayTimeout      Code = 5<<5 | 4 // 5.04
+)
+
+// Class 返回响应码类别，如 2.05 返回 2
+func (c Code) Class() uint8 {
+	return uint8(c) >> 5
+}
+
+// IsRequest 判断是否为请求方法
+func (c Code) IsRequest() bool {
+	return c != Empty && c.Class() == 0
+}
+
+// IsSuccess 判断是否为成功响应(2.xx)
+func (c Code) IsSuccess() bool {
+	return c.Class() == 2
+}
+
+// String 返回 c.dd 形式的响应码，请求方法返回方法名
+func (c Code) String() string {
+	switch c {
+	case GET:
+		return "GET"
+	case POST:
+		return "POST"
+	case PUT:
+		return "PUT"
+	case DELETE:
+		return "DELETE"
+	}
+	return fmt.Sprintf("%d.%02d", c.Class(), uint8(c)&0x1F)
+}
+
+// OptionID CoAP 选项编号
+type OptionID uint16
+
+const (
+	IfMatch       OptionID = 1
+	URIHost       OptionID = 3
+	ETag          OptionID = 4
+	IfNoneMatch   OptionID = 5
+	Observe       OptionID = 6
+	URIPort       OptionID = 7
+	LocationPath  OptionID = 8
+	URIPath       OptionID = 11
+	ContentFormat OptionID = 12
+	MaxAge        OptionID = 14
+	URIQuery      OptionID = 15
+	Accept        OptionID = 17
+	LocationQuery OptionID = 20
+	Block2        OptionID = 23
+	Block1        OptionID = 27
+	Size2         OptionID = 28
+	ProxyURI      OptionID = 35
+	ProxyScheme   OptionID = 39
+	Size1         OptionID = 60
+)
+
+// 常用的内容格式
+const (
+	TextPlain     uint32 = 0
+	AppLinkFormat uint32 = 40
+	AppOctets     uint32 = 42
+	AppJSON       uint32 = 50
+	AppCBOR       uint32 = 60
+)
+
+// Option CoAP 选项
+type Option struct {
+	ID    OptionID
+	Value []byte
+}
+
+// Message CoAP 报文
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+var (
+	// ErrMessageTooShort 报文长度不足
+	ErrMessageTooShort = errors.New("CoAP 报文长度不足")
+	// ErrInvalidVersion 报文版本错误
+	ErrInvalidVersion = errors.New("CoAP 报文版本错误")
+	// ErrInvalidFormat 报文格式错误
+	ErrInvalidFormat = errors.New("CoAP 报文格式错误")
+)
+
+// Unmarshal 解析 CoAP 报文
+func Unmarshal(data []byte) (*Message, error) {
+	if len(data) < 4 {
+		return nil, ErrMessageTooShort
+	}
+	if data[0]>>6 != 1 {
+		return nil, ErrInvalidVersion
+	}
+	tokenLength := int(data[0] & 0x0F)
+	if tokenLength > 8 || len(data) < 4+tokenLength {
+		return nil, ErrInvalidFormat
+	}
+	m := &Message{
+		Type:      Type(data[0] >> 4 & 0x03),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+	}
+	if tokenLength > 0 {
+		m.Token = append([]byte(nil), data[4:4+tokenLength]...)
+	}
+
+	data = data[4+tokenLength:]
+	var id int
+	for len(data) > 0 {
+		if data[0] == 0xFF {
+			if len(data) == 1 {
+				return nil, fmt.Errorf("%w: 负载标记后没有数据", ErrInvalidFormat)
+			}
+			m.Payload = append([]byte(nil), data[1:]...)
+			break
+		}
+		delta, length := int(data[0]>>4), int(data[0]&0x0F)
+		data = data[1:]
+		var err error
+		if delta, data, err = extendedValue(delta, data); err != nil {
+			return nil, err
+		}
+		if length, data, err = extendedValue(length, data); err != nil {
+			return nil, err
+		}
+		if len(data) < length {
+			return nil, fmt.Errorf("%w: 选项长度超出报文", ErrInvalidFormat)
+		}
+		id += delta
+		m.Options = append(m.Options, Option{ID: OptionID(id), Value: append([]byte(nil), data[:length]...)})
+		data = data[length:]
+	}
+	return m, nil
+}
+
+// extendedValue 解析选项的扩展增量或扩展长度
+func extendedValue(v int, data []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(data) < 1 {
+			return 0, nil, ErrInvalidFormat
+		}
+		return int(data[0]) + 13, data[1:], nil
+	case 14:
+		if len(data) < 2 {
+			return 0, nil, ErrInvalidFormat
+		}
+		return int(binary.BigEndian.Uint16(data)) + 269, data[2:], nil
+	case 15:
+		return 0, nil, fmt.Errorf("%w: 选项增量或长度为保留值 15", ErrInvalidFormat)
+	}
+	return v, data, nil
+}
+
+// Marshal 编码 CoAP 报文，选项按编号排序
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Token) > 8 {
+		return nil, fmt.Errorf("%w: Token 长度超过 8 字节", ErrInvalidFormat)
+	}
+	buf := make([]byte, 4, 4+len(m.Token)+len(m.Payload)+32)
+	buf[0] = 1<<6 | byte(m.Type)<<4 | byte(len(m.Token))
+	buf[1] = byte(m.Code)
+	binary.BigEndian.PutUint16(buf[2:], m.MessageID)
+	buf = append(buf, m.Token...)
+
+	options := append([]Option(nil), m.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].ID < options[j].ID })
+	var last OptionID
+	for _, option := range options {
+		delta, length := int(option.ID-last), len(option.Value)
+		last = option.ID
+		deltaNibble, deltaExt := nibble(delta)
+		lengthNibble, lengthExt := nibble(length)
+		buf = append(buf, deltaNibble<<4|lengthNibble)
+		buf = append(buf, deltaExt...)
+		buf = append(buf, lengthExt...)
+		buf = append(buf, option.Value...)
+	}
+	if len(m.Payload) > 0 {
+		buf = append(buf, 0xFF)
+		buf = append(buf, m.Payload...)
+	}
+	return buf, nil
+}
+
+// nibble 计算选项增量或长度的 4 位值与扩展字节
+func nibble(v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		return 14, []byte{byte((v - 269) >> 8), byte(v - 269)}
+	}
+}
+
+// Option 返回第一个指定编号的选项值
+func (m *Message) Option(id OptionID) ([]byte, bool) {
+	for _, option := range m.Options {
+		if option.ID == id {
+			return option.Value, true
+		}
+	}
+	return nil, false
+}
+
+// OptionValues 返回所有指定编号的选项值
+func (m *Message) OptionValues(id OptionID) [][]byte {
+	var values [][]byte
+	for _, option := range m.Options {
+		if option.ID == id {
+			values = append(values, option.Value)
+		}
+	}
+	return values
+}
+
+// AddOption 添加选项
+func (m *Message) AddOption(id OptionID, value []byte) {
+	m.Options = append(m.Options, Option{ID: id, Value: value})
+}
+
+// RemoveOption 删除所有指定编号的选项
+func (m *Message) RemoveOption(id OptionID) {
+	options := m.Options[:0]
+	for _, option := range m.Options {
+		if option.ID != id {
+			options = append(options, option)
+		}
+	}
+	m.Options = options
+}
+
+// SetOption 设置选项，替换已有的同编号选项
+func (m *Message) SetOption(id OptionID, value []byte) {
+	m.RemoveOption(id)
+	m.AddOption(id, value)
+}
+
+// Uint 返回无符号整数类型的选项值
+func (m *Message) Uint(id OptionID) (uint32, bool) {
+	value, ok := m.Option(id)
+	if !ok || len(value) > 4 {
+		return 0, false
+	}
+	return DecodeUint(value), true
+}
+
+// SetUint 设置无符号整数类型的选项
+func (m *Message) SetUint(id OptionID, v uint32) {
+	m.SetOption(id, EncodeUint(v))
+}
+
+// Path 返回以 / 分隔的 Uri-Path，不含开头的 /
+func (m *Message) Path() string {
+	return m.joinOptions(URIPath, "/")
+}
+
+// Segments 返回 Uri-Path 的各段
+func (m *Message) Segments() []string {
+	values := m.OptionValues(URIPath)
+	segments := make([]string, len(values))
+	for i, value := range values {
+		segments[i] = string(value)
+	}
+	return segments
+}
+
+// SetPath 按 / 拆分路径设置 Uri-Path
+func (m *Message) SetPath(path string) {
+	m.setSplitOptions(URIPath, path, "/")
+}
+
+// Queries 返回所有 Uri-Query
+func (m *Message) Queries() []string {
+	values := m.OptionValues(URIQuery)
+	queries := make([]string, len(values))
+	for i, value := range values {
+		queries[i] = string(value)
+	}
+	return queries
+}
+
+// Query 返回 key=value 形式的 Uri-Query 中指定参数的值
+func (m *Message) Query(key string) string {
+	for _, query := range m.Queries() {
+		if k, v, ok := strings.Cut(query, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// AddQuery 添加 Uri-Query
+func (m *Message) AddQuery(query string) {
+	m.AddOption(URIQuery, []byte(query))
+}
+
+// LocationPath 返回以 / 分隔的 Location-Path
+func (m *Message) LocationPath() string {
+	return m.joinOptions(LocationPath, "/")
+}
+
+// SetLocationPath 按 / 拆分路径设置 Location-Path
+func (m *Message) SetLocationPath(path string) {
+	m.setSplitOptions(LocationPath, path, "/")
+}
+
+// ContentFormat 返回内容格式
+func (m *Message) ContentFormat() (uint32, bool) {
+	return m.Uint(ContentFormat)
+}
+
+// joinOptions 拼接字符串类型的选项
+func (m *Message) joinOptions(id OptionID, sep string) string {
+	var parts []string
+	for _, value := range m.OptionValues(id) {
+		parts = append(parts, string(value))
+	}
+	return strings.Join(parts, sep)
+}
+
+// setSplitOptions 拆分字符串设置选项
+func (m *Message) setSplitOptions(id OptionID, s, sep string) {
+	m.RemoveOption(id)
+	for _, part := range strings.Split(strings.Trim(s, sep), sep) {
+		if part != "" {
+			m.AddOption(id, []byte(part))
+		}
+	}
+}
+
+// EncodeUint 按最短字节编码无符号整数，0 编码为空
+func EncodeUint(v uint32) []byte {
+	switch {
+	case v == 0:
+		return []byte{}
+	case v < 1<<8:
+		return []byte{byte(v)}
+	case v < 1<<16:
+		return []byte{byte(v >> 8), byte(v)}
+	case v < 1<<24:
+		return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// DecodeUint 解码无符号整数
+func DecodeUint(value []byte) uint32 {
+	var v uint32
+	for _, b := range value {
+		v = v<<8 | uint32(b)
+	}
+	return v
+}
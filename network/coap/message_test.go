@@ -0,0 +1,93 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		Type:      Confirmable,
+		Code:      POST,
+		MessageID: 0x1234,
+		Token:     []byte{1, 2, 3, 4},
+		Payload:   []byte("hello"),
+	}
+	m.SetPath("/up/dev-1")
+	m.AddQuery("ep=dev-1")
+	m.SetUint(ContentFormat, AppJSON)
+	m.SetBlock(Block1, Block{Num: 3, More: true, SZX: 2})
+	m.AddOption(ProxyURI, []byte(strings.Repeat("x", 300))) // 扩展增量与 2 字节扩展长度
+	m.AddOption(OptionID(2048), []byte{0xAA})               // 2 字节扩展增量
+
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != m.Type || got.Code != m.Code || got.MessageID != m.MessageID || !bytes.Equal(got.Token, m.Token) || string(got.Payload) != "hello" {
+		t.Fatalf("报文头或负载不正确: %+v", got)
+	}
+	if got.Path() != "up/dev-1" || got.Query("ep") != "dev-1" {
+		t.Fatalf("路径或查询参数不正确: %q %v", got.Path(), got.Queries())
+	}
+	if cf, _ := got.ContentFormat(); cf != AppJSON {
+		t.Fatalf("内容格式不正确: %d", cf)
+	}
+	if block, ok, err := got.Block(Block1); err != nil || !ok || block != (Block{Num: 3, More: true, SZX: 2}) {
+		t.Fatalf("Block1 不正确: %+v %v %v", block, ok, err)
+	}
+	if v, _ := got.Option(ProxyURI); len(v) != 300 {
+		t.Fatalf("长选项不正确: %d", len(v))
+	}
+	if v, ok := got.Option(OptionID(2048)); !ok || !bytes.Equal(v, []byte{0xAA}) {
+		t.Fatalf("大编号选项不正确: %v", v)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"长度不足":    {0x40, 0x01},
+		"版本错误":    {0x80, 0x01, 0x00, 0x01},
+		"Token过长": {0x49, 0x01, 0x00, 0x01},
+		"选项越界":    {0x40, 0x01, 0x00, 0x01, 0xB5, 'u'},
+		"空负载":     {0x40, 0x01, 0x00, 0x01, 0xFF},
+		"保留增量":    {0x40, 0x01, 0x00, 0x01, 0xF0},
+	}
+	for name, data := range cases {
+		if _, err := Unmarshal(data); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+	if _, err := Unmarshal([]byte{0x80, 0x01, 0x00, 0x01}); !errors.Is(err, ErrInvalidVersion) {
+		t.Fatalf("错误类型不正确: %v", err)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	if SZXForSize(1024) != 6 || SZXForSize(512) != 5 || SZXForSize(16) != 0 || SZXForSize(100) != 2 {
+		t.Fatal("块大小指数不正确")
+	}
+	data := bytes.Repeat([]byte{1}, 40)
+	block := Block{Num: 2, SZX: 0}
+	part, err := block.Slice(data)
+	if err != nil || len(part) != 8 || block.More {
+		t.Fatalf("最后一块不正确: %d %v %v", len(part), block.More, err)
+	}
+	block = Block{Num: 1, SZX: 0}
+	if part, _ = block.Slice(data); len(part) != 16 || !block.More {
+		t.Fatalf("中间块不正确: %d %v", len(part), block.More)
+	}
+	block = Block{Num: 3, SZX: 0}
+	if _, err := block.Slice(data); err == nil {
+		t.Fatal("超出范围的块应返回错误")
+	}
+	if _, err := ParseBlock(0x07); err == nil {
+		t.Fatal("保留的块大小指数应返回错误")
+	}
+}
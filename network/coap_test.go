@@ -0,0 +1,298 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/coap"
+	"github.com/sagoo-cloud/iotgateway/vars"
+)
+
+// coapClient 测试用 CoAP 设备端
+type coapClient struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+func (c *coapClient) send(msg *coap.Message) {
+	data, err := msg.Marshal()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *coapClient) receive(timeout time.Duration) (*coap.Message, error) {
+	buffer := make([]byte, 2048)
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := c.conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return coap.Unmarshal(buffer[:n])
+}
+
+func (c *coapClient) roundTrip(msg *coap.Message) *coap.Message {
+	c.send(msg)
+	resp, err := c.receive(3 * time.Second)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+func startCoAPServer(t *testing.T, protocol ProtocolHandler, config conf.CoAPConfig) (*CoAPServer, *coapClient) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	server := NewCoAPServer(WithProtocolHandler(protocol), WithCoAPConfig(config)).(*CoAPServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Start(ctx, addr.String())
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := &coapClient{t: t, conn: conn}
+
+	// 等待服务器开始监听
+	ping := &coap.Message{Type: coap.Confirmable, MessageID: 1}
+	for i := 0; i < 50; i++ {
+		client.send(ping)
+		if resp, err := client.receive(50 * time.Millisecond); err == nil {
+			if resp.Type != coap.Reset || resp.MessageID != 1 {
+				t.Fatalf("CoAP Ping 应回复 RST: %+v", resp)
+			}
+			return server, client
+		}
+		time.Sleep(20 * time.Millisecond) // 服务器未监听时连接的 UDP 套接字会立即返回错误
+	}
+	t.Fatal("CoAP 服务器未启动")
+	return nil, nil
+}
+
+func TestCoAPServerRequest(t *testing.T) {
+	protocol := &echoProtocol{}
+	_, client := startCoAPServer(t, protocol, conf.CoAPConfig{})
+
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 100, Token: []byte{1, 2}, Payload: []byte("hello")}
+	req.SetPath("up/nb-001")
+	resp := client.roundTrip(req)
+	if resp.Type != coap.Acknowledgement || resp.MessageID != 100 || !bytes.Equal(resp.Token, req.Token) ||
+		resp.Code != coap.Changed || string(resp.Payload) != "ack:hello" {
+		t.Fatalf("附带响应不正确: %+v", resp)
+	}
+
+	// 重复的 CON 报文返回相同的响应且不重复处理
+	if dup := client.roundTrip(req); dup.MessageID != 100 || string(dup.Payload) != "ack:hello" {
+		t.Fatalf("重复报文的响应不正确: %+v", dup)
+	}
+	if n := len(protocol.received()); n != 1 {
+		t.Fatalf("重复报文被处理了 %d 次", n)
+	}
+	if _, err := vars.GetDevice("nb-001"); err != nil {
+		t.Fatalf("设备未注册: %v", err)
+	}
+
+	// 查询参数携带设备标识，NON 请求以 NON 响应
+	req = &coap.Message{Type: coap.NonConfirmable, Code: coap.POST, MessageID: 101, Token: []byte{3}, Payload: []byte("q")}
+	req.SetPath("up")
+	req.AddQuery("ep=nb-002")
+	if resp = client.roundTrip(req); resp.Type != coap.NonConfirmable || string(resp.Payload) != "ack:q" {
+		t.Fatalf("NON 响应不正确: %+v", resp)
+	}
+
+	req = &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 102, Payload: []byte("x")}
+	req.SetPath("other/nb-001")
+	if resp = client.roundTrip(req); resp.Code != coap.NotFound {
+		t.Fatalf("未知路径应返回 4.04: %s", resp.Code)
+	}
+}
+
+func TestCoAPServerBlockwise(t *testing.T) {
+	protocol := &echoProtocol{}
+	_, client := startCoAPServer(t, protocol, conf.CoAPConfig{BlockSize: 32})
+
+	// Block1 分块上报 40 字节
+	body := bytes.Repeat([]byte("0123456789"), 4)
+	var resp *coap.Message
+	for num := uint32(0); num < 3; num++ {
+		block := coap.Block{Num: num, SZX: 0}
+		part, _ := block.Slice(body)
+		req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: uint16(200 + num), Token: []byte{byte(num)}, Payload: part}
+		req.SetPath("up/nb-003")
+		req.SetBlock(coap.Block1, block)
+		resp = client.roundTrip(req)
+		if block.More && resp.Code != coap.Continue {
+			t.Fatalf("第 %d 块应返回 2.31: %s", num, resp.Code)
+		}
+	}
+	if got := protocol.received(); len(got) != 1 || !bytes.Equal(got[0], body) {
+		t.Fatalf("合并后的请求体不正确: %q", got)
+	}
+
+	// 44 字节的响应按 32 字节 Block2 分块
+	block, ok, _ := resp.Block(coap.Block2)
+	if resp.Code != coap.Changed || !ok || !block.More || len(resp.Payload) != 32 {
+		t.Fatalf("Block2 第一块不正确: %s %+v %d", resp.Code, block, len(resp.Payload))
+	}
+	payload := append([]byte(nil), resp.Payload...)
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 210}
+	req.SetPath("up/nb-003")
+	req.SetBlock(coap.Block2, coap.Block{Num: 1, SZX: block.SZX})
+	resp = client.roundTrip(req)
+	if block, _, _ = resp.Block(coap.Block2); block.More {
+		t.Fatal("Block2 最后一块不应有 More 标记")
+	}
+	payload = append(payload, resp.Payload...)
+	if string(payload) != "ack:"+string(body) {
+		t.Fatalf("合并后的响应体不正确: %q", payload)
+	}
+
+	// 缺少前面的块时返回 4.08
+	req = &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 211, Payload: []byte("x")}
+	req.SetPath("up/nb-003")
+	req.SetBlock(coap.Block1, coap.Block{Num: 2, More: true})
+	if resp = client.roundTrip(req); resp.Code != coap.RequestEntityIncomplete {
+		t.Fatalf("不连续的块应返回 4.08: %s", resp.Code)
+	}
+}
+
+func TestCoAPServerDownlink(t *testing.T) {
+	protocol := &echoProtocol{}
+	server, client := startCoAPServer(t, protocol, conf.CoAPConfig{AckTimeout: 100 * time.Millisecond})
+	device := &model.Device{DeviceKey: "nb-004"}
+
+	if err := server.SendData(device, []byte("cmd")); err == nil {
+		t.Fatal("设备未上报前下发应返回错误")
+	}
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 300, Payload: []byte("up")}
+	req.SetPath("up/nb-004")
+	client.roundTrip(req)
+
+	// 设备忽略第一次发送，服务器重传后设备回复附带响应
+	done := make(chan error, 1)
+	go func() { done <- server.SendData(device, []byte("cmd")) }()
+	first, err := client.receive(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Type != coap.Confirmable || first.Code != coap.POST || first.Path() != "down" || string(first.Payload) != "cmd" {
+		t.Fatalf("下发请求不正确: %+v", first)
+	}
+	retry, err := client.receive(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.MessageID != first.MessageID {
+		t.Fatal("重传报文的消息ID应保持不变")
+	}
+	client.send(&coap.Message{Type: coap.Acknowledgement, Code: coap.Changed, MessageID: retry.MessageID, Token: retry.Token})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// 设备以 RST 拒绝
+	go func() { done <- server.SendData(device, []byte("cmd2")) }()
+	msg, err := client.receive(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.send(&coap.Message{Type: coap.Reset, MessageID: msg.MessageID})
+	if err := <-done; err == nil {
+		t.Fatal("设备复位时应返回错误")
+	}
+}
+
+func TestCoAPServerObserve(t *testing.T) {
+	protocol := &echoProtocol{}
+	server, client := startCoAPServer(t, protocol, conf.CoAPConfig{})
+	device := &model.Device{DeviceKey: "nb-005"}
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 400, Payload: []byte("up")}
+	req.SetPath("up/nb-005")
+	client.roundTrip(req)
+
+	var mu sync.Mutex
+	var values []string
+	handler := func(device *model.Device, msg *coap.Message) {
+		mu.Lock()
+		values = append(values, string(msg.Payload))
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type result struct {
+		resp *coap.Message
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := server.Observe(ctx, device, "3303/0/5700", handler)
+		done <- result{resp, err}
+	}()
+
+	reg, err := client.receive(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if observe, ok := reg.Uint(coap.Observe); !ok || observe != 0 || reg.Code != coap.GET || reg.Path() != "3303/0/5700" {
+		t.Fatalf("观察请求不正确: %+v", reg)
+	}
+	notify := func(typ coap.Type, messageID uint16, seq uint32, payload string) {
+		msg := &coap.Message{Type: typ, Code: coap.Content, MessageID: messageID, Token: reg.Token, Payload: []byte(payload)}
+		msg.SetUint(coap.Observe, seq)
+		client.send(msg)
+	}
+	notify(coap.Acknowledgement, reg.MessageID, 1, "20")
+	if r := <-done; r.err != nil || string(r.resp.Payload) != "20" {
+		t.Fatalf("观察响应不正确: %+v %v", r.resp, r.err)
+	}
+
+	notify(coap.NonConfirmable, 500, 3, "21")
+	time.Sleep(50 * time.Millisecond)
+	notify(coap.NonConfirmable, 501, 2, "stale") // 过期的乱序通知被丢弃
+	notify(coap.Confirmable, 502, 4, "22")
+	if ack, err := client.receive(3 * time.Second); err != nil || ack.Type != coap.Acknowledgement || ack.MessageID != 502 {
+		t.Fatalf("CON 通知应被确认: %+v %v", ack, err)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(values) == 3
+	})
+	mu.Lock()
+	sort.Strings(values)
+	if values[0] != "20" || values[1] != "21" || values[2] != "22" {
+		t.Fatalf("观察通知不正确: %v", values)
+	}
+	mu.Unlock()
+
+	// 取消观察后发送取消请求，之后的 CON 通知被 RST 拒绝
+	cancel()
+	dereg, err := client.receive(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if observe, _ := dereg.Uint(coap.Observe); observe != 1 || !bytes.Equal(dereg.Token, reg.Token) {
+		t.Fatalf("取消观察请求不正确: %+v", dereg)
+	}
+	notify(coap.Confirmable, 503, 5, "23")
+	if rst, err := client.receive(3 * time.Second); err != nil || rst.Type != coap.Reset || rst.MessageID != 503 {
+		t.Fatalf("取消后的通知应被 RST 拒绝: %+v %v", rst, err)
+	}
+}
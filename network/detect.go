@@ -0,0 +1,200 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+const (
+	defaultDetectTimeout  = 3 * time.Second
+	defaultDetectPeekSize = 64
+)
+
+// MetadataProtocol 设备元数据中保存协议识别结果(协议处理器名称)的键，使用监听的默认协议处理器时为空字符串
+const MetadataProtocol = "protocol.name"
+
+// ProtocolDetector 协议处理器可选实现的接口，用于在共享端口上根据连接开头的数据识别协议
+type ProtocolDetector interface {
+	// Detect 判断连接开头已读取的数据是否属于本协议
+	// 排在前面的规则还需要更多数据时，收到新数据后会再次调用
+	Detect(data []byte) bool
+}
+
+// detectRule 编译后的识别规则
+type detectRule struct {
+	protocol string
+	pattern  []byte // 为空时调用协议处理器的 Detect 方法
+	offset   int
+}
+
+// protocolDetector 按识别规则确定新连接使用的协议处理器
+type protocolDetector struct {
+	rules    []detectRule
+	fallback string
+	timeout  time.Duration
+	peekSize int
+}
+
+// newProtocolDetector 根据协议识别配置创建识别器，未配置识别规则时返回 nil
+func newProtocolDetector(config conf.DetectConfig) (*protocolDetector, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+	d := &protocolDetector{
+		fallback: config.Fallback,
+		timeout:  config.Timeout,
+		peekSize: config.PeekSize,
+	}
+	if d.timeout <= 0 {
+		d.timeout = defaultDetectTimeout
+	}
+	if d.peekSize <= 0 {
+		d.peekSize = defaultDetectPeekSize
+	}
+	for i, rule := range config.Rules {
+		if rule.Protocol == "" {
+			return nil, fmt.Errorf("第 %d 条识别规则未指定协议处理器", i+1)
+		}
+		if rule.Magic != "" && rule.Prefix != "" {
+			return nil, fmt.Errorf("识别规则 %s 不能同时配置 magic 和 prefix", rule.Protocol)
+		}
+		if rule.Offset < 0 {
+			return nil, fmt.Errorf("识别规则 %s 的偏移不能为负数", rule.Protocol)
+		}
+		compiled := detectRule{protocol: rule.Protocol, offset: rule.Offset, pattern: []byte(rule.Prefix)}
+		if rule.Magic != "" {
+			magic, err := hex.DecodeString(strings.NewReplacer(" ", "", "0x", "", "0X", "").Replace(rule.Magic))
+			if err != nil {
+				return nil, fmt.Errorf("识别规则 %s 的 magic 格式错误: %v", rule.Protocol, err)
+			}
+			compiled.pattern = magic
+		}
+		if compiled.offset+len(compiled.pattern) > d.peekSize {
+			return nil, fmt.Errorf("识别规则 %s 超出识别字节数 %d", rule.Protocol, d.peekSize)
+		}
+		d.rules = append(d.rules, compiled)
+	}
+	return d, nil
+}
+
+// match 按顺序匹配识别规则，返回匹配的协议处理器名称
+// 排在前面的特征字节规则数据不足时 pending 为 true，需要继续读取数据
+func (d *protocolDetector) match(data []byte) (name string, handler ProtocolHandler, pending bool) {
+	for _, rule := range d.rules {
+		protocol, ok := GetProtocol(rule.protocol)
+		if !ok {
+			continue
+		}
+		if len(rule.pattern) == 0 {
+			if detector, ok := protocol.(ProtocolDetector); ok && detector.Detect(data) {
+				return rule.protocol, protocol, false
+			}
+			continue
+		}
+		end := rule.offset + len(rule.pattern)
+		if len(data) < end {
+			if bytes.HasPrefix(rule.pattern, data[min(rule.offset, len(data)):]) {
+				return "", nil, true // 已读取的部分与特征字节一致，等待更多数据
+			}
+			continue
+		}
+		if bytes.Equal(data[rule.offset:end], rule.pattern) {
+			return rule.protocol, protocol, false
+		}
+	}
+	return "", nil, false
+}
+
+// detectProtocol 读取连接开头的数据识别协议，返回识别出的协议处理器名称、协议处理器和已读取的数据
+// 超时或数据不匹配任何规则时使用 Fallback 协议处理器，Fallback 为空时使用监听的协议处理器
+func (s *BaseServer) detectProtocol(conn net.Conn, d *protocolDetector) (string, ProtocolHandler, []byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(d.timeout)); err != nil {
+		return "", nil, nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 0, d.peekSize)
+	for len(buf) < d.peekSize {
+		n, err := conn.Read(buf[len(buf):d.peekSize])
+		buf = buf[:len(buf)+n]
+		if n > 0 {
+			name, handler, pending := d.match(buf)
+			if handler != nil {
+				return name, handler, buf, nil
+			}
+			if !pending {
+				break
+			}
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break // 设备不主动上报数据，使用 Fallback 协议处理器
+			}
+			return "", nil, buf, err
+		}
+	}
+
+	if d.fallback == "" {
+		if s.protocolHandler == nil {
+			return "", nil, buf, errors.New("未识别的协议且未设置协议处理器")
+		}
+		return "", s.protocolHandler, buf, nil
+	}
+	handler, ok := GetProtocol(d.fallback)
+	if !ok {
+		return "", nil, buf, fmt.Errorf("协议处理器 %s 未注册", d.fallback)
+	}
+	return d.fallback, handler, buf, nil
+}
+
+// bindProtocol 将设备绑定到协议识别得到的协议处理器，连接关闭时调用 unbindProtocol
+func (s *BaseServer) bindProtocol(device *model.Device, handler ProtocolHandler) {
+	s.boundHandlers.Store(device, handler)
+}
+
+// unbindProtocol 解除设备绑定的协议处理器
+func (s *BaseServer) unbindProtocol(device *model.Device) {
+	s.boundHandlers.Delete(device)
+}
+
+// deviceProtocol 获取设备使用的协议处理器，未绑定时使用监听的协议处理器
+func (s *BaseServer) deviceProtocol(device *model.Device) ProtocolHandler {
+	if device != nil {
+		if handler, ok := s.boundHandlers.Load(device); ok {
+			return handler.(ProtocolHandler)
+		}
+	}
+	return s.protocolHandler
+}
+
+// DeviceHandler 获取设备使用的协议处理器，协议识别后为连接绑定的协议处理器
+func (s *BaseServer) DeviceHandler(device *model.Device) ProtocolHandler {
+	return s.deviceProtocol(device)
+}
+
+// DeviceProtocol 获取设备连接协议识别得到的协议处理器名称，未启用协议识别或使用默认协议处理器时返回空字符串
+func DeviceProtocol(device *model.Device) string {
+	if device == nil || device.Metadata == nil {
+		return ""
+	}
+	name, _ := device.Metadata[MetadataProtocol].(string)
+	return name
+}
+
+// replayConn 将协议识别时已读取的数据放回连接的读取流
+func replayConn(conn net.Conn, data []byte) io.Reader {
+	if len(data) == 0 {
+		return conn
+	}
+	return io.MultiReader(bytes.NewReader(data), conn)
+}
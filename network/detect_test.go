@@ -0,0 +1,193 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// namedProtocol 测试用协议处理器，回复时加上协议名称前缀
+type namedProtocol struct {
+	name    string
+	detect  func(data []byte) bool
+	decoder FrameDecoder
+}
+
+func (p *namedProtocol) Init(device *model.Device, data []byte) error {
+	return nil
+}
+
+func (p *namedProtocol) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	return append(data.([]byte), '\n'), nil
+}
+
+func (p *namedProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	return append([]byte(p.name+":"), bytes.TrimRight(data, "\r\n")...), nil
+}
+
+// detectingProtocol 实现了 Detect 方法的测试用协议处理器
+type detectingProtocol struct {
+	namedProtocol
+}
+
+func (p *detectingProtocol) Detect(data []byte) bool {
+	return p.detect(data)
+}
+
+// framedProtocol 提供自身帧解码器的测试用协议处理器
+type framedProtocol struct {
+	namedProtocol
+}
+
+func (p *framedProtocol) FrameDecoder() FrameDecoder {
+	return p.decoder
+}
+
+func TestProtocolDetectorMatch(t *testing.T) {
+	RegisterProtocol("detect-magic", &namedProtocol{name: "magic"})
+	RegisterProtocol("detect-prefix", &namedProtocol{name: "prefix"})
+	RegisterProtocol("detect-json", &detectingProtocol{namedProtocol{name: "json", detect: func(data []byte) bool {
+		return data[0] == '{'
+	}}})
+
+	d, err := newProtocolDetector(conf.DetectConfig{Rules: []conf.DetectRuleConfig{
+		{Protocol: "detect-magic", Magic: "68 aa", Offset: 1},
+		{Protocol: "detect-prefix", Prefix: "##"},
+		{Protocol: "detect-json"},
+		{Protocol: "detect-missing", Prefix: "$"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data    string
+		name    string
+		pending bool
+	}{
+		{"\x00\x68\xaa\x01", "detect-magic", false},
+		{"\x00", "", true},     // 特征字节所在位置尚未读取
+		{"\x00\x68", "", true}, // 已读取的部分与特征字节一致
+		{"\x00\x69##", "", false},
+		{"#", "", true}, // 排在前面的规则还需要更多数据
+		{"##0101", "detect-prefix", false},
+		{"#\x00##", "", false},
+		{"{\"a\":1}", "detect-json", false},
+		{"$GPRMC", "", false}, // 协议处理器未注册的规则被跳过
+	}
+	for _, tt := range tests {
+		name, _, pending := d.match([]byte(tt.data))
+		if name != tt.name || pending != tt.pending {
+			t.Errorf("match(%q) = %q, %v, 期望 %q, %v", tt.data, name, pending, tt.name, tt.pending)
+		}
+	}
+	if name, _, _ := d.match([]byte("{\x68\xaa")); name != "detect-magic" {
+		t.Errorf("特征字节规则应优先匹配，实际 %q", name)
+	}
+
+	for _, config := range []conf.DetectConfig{
+		{Rules: []conf.DetectRuleConfig{{Magic: "68"}}},
+		{Rules: []conf.DetectRuleConfig{{Protocol: "a", Magic: "6"}}},
+		{Rules: []conf.DetectRuleConfig{{Protocol: "a", Magic: "68", Prefix: "#"}}},
+		{Rules: []conf.DetectRuleConfig{{Protocol: "a", Prefix: "#", Offset: 64}}},
+	} {
+		if _, err := newProtocolDetector(config); err == nil {
+			t.Errorf("识别配置 %+v 应返回错误", config)
+		}
+	}
+}
+
+func TestTCPServerDetectProtocol(t *testing.T) {
+	RegisterProtocol("shared-hj212", &namedProtocol{name: "hj212"})
+	RegisterProtocol("shared-fixed", &framedProtocol{namedProtocol{name: "fixed", decoder: fixedLengthDecoder(4)}})
+	RegisterProtocol("shared-json", &detectingProtocol{namedProtocol{name: "json", detect: func(data []byte) bool {
+		return data[0] == '{'
+	}}})
+	RegisterProtocol("shared-raw", &namedProtocol{name: "raw"})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := NewTCPServer(
+		WithProtocolHandler(&namedProtocol{name: "default"}),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\n"}),
+		WithDetectConfig(conf.DetectConfig{
+			Rules: []conf.DetectRuleConfig{
+				{Protocol: "shared-hj212", Prefix: "##"},
+				{Protocol: "shared-fixed", Magic: "68"},
+				{Protocol: "shared-json"},
+			},
+			Fallback: "shared-raw",
+			Timeout:  200 * time.Millisecond,
+		}),
+	).(*TCPServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	dial := func() net.Conn {
+		for i := 0; i < 50; i++ {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				return conn
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("服务器未启动")
+		return nil
+	}
+	expect := func(conn net.Conn, reader *bufio.Reader, want string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil || strings.TrimSuffix(line, "\n") != want {
+			t.Fatalf("回复 %q %v, 期望 %q", line, err, want)
+		}
+	}
+
+	tests := []struct {
+		send    []string
+		replies []string
+	}{
+		{[]string{"##", "0101\n##02\n"}, []string{"hj212:##0101", "hj212:##02"}}, // 前缀分两次到达
+		{[]string{"\x68abc\x68def"}, []string{"fixed:\x68abc", "fixed:\x68def"}}, // 按协议自身的帧解码器切分
+		{[]string{"{\"a\":1}\n"}, []string{"json:{\"a\":1}"}},
+		{[]string{"zz\n"}, []string{"raw:zz"}},           // 不匹配任何规则
+		{[]string{"", "later\n"}, []string{"raw:later"}}, // 超时未上报数据
+	}
+	for _, tt := range tests {
+		conn := dial()
+		reader := bufio.NewReader(conn)
+		for _, data := range tt.send {
+			if data == "" {
+				time.Sleep(400 * time.Millisecond)
+				continue
+			}
+			conn.Write([]byte(data))
+			time.Sleep(20 * time.Millisecond)
+		}
+		for _, reply := range tt.replies {
+			expect(conn, reader, reply)
+		}
+
+		// 下发数据使用连接绑定的协议处理器编码
+		device := server.getDevice(conn.LocalAddr().String())
+		if device == nil {
+			t.Fatal("设备未上线")
+		}
+		if err := server.SendData(device, []byte("down")); err != nil {
+			t.Fatal(err)
+		}
+		expect(conn, reader, "down")
+		conn.Close()
+	}
+}
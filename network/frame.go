@@ -0,0 +1,282 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+)
+
+// FrameDecoder 接口定义了从字节流中切分完整数据帧的方法
+// Decode 可能被多个连接并发调用，实现中不应保存连接相关的状态
+type FrameDecoder interface {
+	// Decode 从 buf 的开头切分出一个完整帧，返回帧数据和消耗的字节数
+	// 数据不足一帧时返回 consumed 为 0；consumed 大于 0 而 frame 为 nil 时表示丢弃这些字节(如帧头之前的无效数据)
+	// 返回错误表示数据流已无法继续切分，连接将被断开
+	Decode(buf []byte) (frame []byte, consumed int, err error)
+}
+
+// FrameEncoder 接口定义了对下发数据进行成帧的方法，在 ProtocolHandler.Encode 之后调用
+type FrameEncoder interface {
+	Encode(data []byte) ([]byte, error)
+}
+
+// FrameDecoderProvider 协议处理器可选实现的接口，用于提供协议自身的帧解码器
+type FrameDecoderProvider interface {
+	FrameDecoder() FrameDecoder
+}
+
+// FrameEncoderProvider 协议处理器可选实现的接口，用于提供协议自身的帧编码器
+type FrameEncoderProvider interface {
+	FrameEncoder() FrameEncoder
+}
+
+// FrameDecoderFunc 将普通函数适配为 FrameDecoder
+type FrameDecoderFunc func(buf []byte) ([]byte, int, error)
+
+// Decode 实现 FrameDecoder 接口
+func (f FrameDecoderFunc) Decode(buf []byte) ([]byte, int, error) {
+	return f(buf)
+}
+
+// FrameEncoderFunc 将普通函数适配为 FrameEncoder
+type FrameEncoderFunc func(data []byte) ([]byte, error)
+
+// Encode 实现 FrameEncoder 接口
+func (f FrameEncoderFunc) Encode(data []byte) ([]byte, error) {
+	return f(data)
+}
+
+// fixedLengthDecoder 定长帧解码器
+type fixedLengthDecoder int
+
+// NewFixedLengthDecoder 创建定长帧解码器
+func NewFixedLengthDecoder(length int) (FrameDecoder, error) {
+	if length <= 0 {
+		return nil, errors.New("定长帧长度必须大于0")
+	}
+	return fixedLengthDecoder(length), nil
+}
+
+// Decode 实现 FrameDecoder 接口
+func (d fixedLengthDecoder) Decode(buf []byte) ([]byte, int, error) {
+	if len(buf) < int(d) {
+		return nil, 0, nil
+	}
+	return buf[:d], int(d), nil
+}
+
+// delimiterDecoder 分隔符帧解码器，返回的帧包含分隔符
+type delimiterDecoder []byte
+
+// NewDelimiterDecoder 创建分隔符帧解码器，返回的帧包含分隔符
+func NewDelimiterDecoder(delimiter []byte) (FrameDecoder, error) {
+	if len(delimiter) == 0 {
+		return nil, errors.New("分隔符不能为空")
+	}
+	return delimiterDecoder(delimiter), nil
+}
+
+// Decode 实现 FrameDecoder 接口
+func (d delimiterDecoder) Decode(buf []byte) ([]byte, int, error) {
+	i := bytes.Index(buf, d)
+	if i < 0 {
+		return nil, 0, nil
+	}
+	n := i + len(d)
+	return buf[:n], n, nil
+}
+
+// NewLengthFieldDecoder 根据粘包配置中的长度字段参数创建帧解码器
+func NewLengthFieldDecoder(config conf.PacketConfig) (FrameDecoder, error) {
+	return newLengthField(config)
+}
+
+// NewFrameDecoder 根据粘包配置创建内置的帧解码器，NoHandling 时返回 nil，表示按读取到的数据原样处理
+func NewFrameDecoder(config conf.PacketConfig) (FrameDecoder, error) {
+	switch config.Type {
+	case NoHandling:
+		return nil, nil
+	case FixedLength:
+		return NewFixedLengthDecoder(config.FixedLength)
+	case HeaderBodySeparate:
+		return NewLengthFieldDecoder(config)
+	case Delimiter:
+		if config.Delimiter == "" {
+			return NewDelimiterDecoder([]byte("\r\n"))
+		}
+		return NewDelimiterDecoder([]byte(config.Delimiter))
+	default:
+		return NewDelimiterDecoder([]byte("\r\n"))
+	}
+}
+
+// frameDecoder 获取当前服务使用的帧解码器
+// 优先级：WithFrameDecoder 选项 > 协议处理器实现的 FrameDecoderProvider > 粘包配置
+func (s *BaseServer) frameDecoder() (FrameDecoder, error) {
+	return s.protocolFrameDecoder(s.protocolHandler)
+}
+
+// protocolFrameDecoder 获取使用指定协议处理器时的帧解码器，协议识别后按连接绑定的协议处理器获取
+func (s *BaseServer) protocolFrameDecoder(handler ProtocolHandler) (FrameDecoder, error) {
+	if s.decoder != nil {
+		return s.decoder, nil
+	}
+	if provider, ok := handler.(FrameDecoderProvider); ok {
+		if decoder := provider.FrameDecoder(); decoder != nil {
+			return decoder, nil
+		}
+	}
+	return NewFrameDecoder(s.packetConfig)
+}
+
+// encodeFrame 使用帧编码器对下发数据成帧，未设置帧编码器时原样返回
+// 优先级：WithFrameEncoder 选项 > 协议处理器实现的 FrameEncoderProvider
+func (s *BaseServer) encodeFrame(data []byte) ([]byte, error) {
+	return s.protocolEncodeFrame(s.protocolHandler, data)
+}
+
+// protocolEncodeFrame 使用指定协议处理器的帧编码器对下发数据成帧
+func (s *BaseServer) protocolEncodeFrame(handler ProtocolHandler, data []byte) ([]byte, error) {
+	encoder := s.encoder
+	if encoder == nil {
+		if provider, ok := handler.(FrameEncoderProvider); ok {
+			encoder = provider.FrameEncoder()
+		}
+	}
+	if encoder == nil {
+		return data, nil
+	}
+	return encoder.Encode(data)
+}
+
+// maxFrameLength 获取最大帧长度
+func (s *BaseServer) maxFrameLength() int {
+	if s.packetConfig.MaxFrameLength > 0 {
+		return s.packetConfig.MaxFrameLength
+	}
+	return defaultMaxFrameLength
+}
+
+// rawDecoder 不做切分，缓冲区中的全部数据作为一帧
+type rawDecoder struct{}
+
+// Decode 实现 FrameDecoder 接口
+func (rawDecoder) Decode(buf []byte) ([]byte, int, error) {
+	return buf, len(buf), nil
+}
+
+// frameBufferSize 连接读取缓冲区的初始大小
+const frameBufferSize = 4096
+
+// frameBufferPool 读取缓冲区对象池，连接关闭或缓冲区扩容后又清空时归还
+var frameBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, frameBufferSize)
+		return &buf
+	},
+}
+
+// frameReader 从数据流中按帧解码器读取完整数据帧
+// 读取到的数据累积在缓冲区中，一次读取包含多个帧时依次切分，跨读取的半帧保留到下次读取
+type frameReader struct {
+	reader    io.Reader
+	decoder   FrameDecoder
+	buf       []byte
+	pooled    *[]byte // buf 来自对象池时的原始指针
+	r, w      int     // buf[r:w] 为尚未切分的数据
+	maxLength int
+}
+
+// newFrameReader 创建帧读取器
+func newFrameReader(reader io.Reader, decoder FrameDecoder, maxLength int) *frameReader {
+	f := &frameReader{
+		reader:    reader,
+		decoder:   decoder,
+		maxLength: maxLength,
+	}
+	f.reset()
+	return f
+}
+
+// reset 使用初始大小的缓冲区，能从对象池获取时优先使用对象池
+func (f *frameReader) reset() {
+	if f.maxLength < frameBufferSize {
+		f.buf = make([]byte, f.maxLength)
+		return
+	}
+	f.pooled = frameBufferPool.Get().(*[]byte)
+	f.buf = *f.pooled
+}
+
+// release 归还缓冲区，连接关闭时调用
+func (f *frameReader) release() {
+	if f.pooled != nil {
+		frameBufferPool.Put(f.pooled)
+		f.pooled = nil
+	}
+	f.buf = nil
+	f.r, f.w = 0, 0
+}
+
+// ReadFrame 读取下一个完整帧，读取出错时已读取的数据会保留到下次调用
+// 帧解码器返回的错误统一包装为 ErrInvalidFrame，帧超过最大长度时返回 ErrFrameTooLong
+func (f *frameReader) ReadFrame() ([]byte, error) {
+	for {
+		if f.w > f.r {
+			frame, n, err := f.decoder.Decode(f.buf[f.r:f.w])
+			if err != nil {
+				if errors.Is(err, ErrFrameTooLong) || errors.Is(err, ErrInvalidFrame) {
+					return nil, err
+				}
+				return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+			}
+			if n > 0 {
+				if n > f.w-f.r {
+					return nil, fmt.Errorf("%w: 帧解码器消耗的字节数 %d 超出缓冲数据长度 %d", ErrInvalidFrame, n, f.w-f.r)
+				}
+				f.r += n
+				if frame != nil {
+					frame = append([]byte(nil), frame...) // 复制后协议处理器可以安全持有帧数据
+				}
+				if f.r == f.w {
+					f.r, f.w = 0, 0
+					if f.pooled == nil && len(f.buf) > frameBufferSize {
+						f.reset() // 大帧处理完后缩回初始缓冲区，避免长期占用内存
+					}
+				}
+				if frame != nil {
+					return frame, nil
+				}
+				continue
+			}
+		}
+
+		if f.w-f.r >= f.maxLength {
+			return nil, ErrFrameTooLong
+		}
+		// 将未切分的数据移到缓冲区开头，缓冲区已满时扩容
+		if f.r > 0 {
+			f.w = copy(f.buf, f.buf[f.r:f.w])
+			f.r = 0
+		}
+		if f.w == len(f.buf) {
+			buf := make([]byte, min(2*len(f.buf), f.maxLength))
+			copy(buf, f.buf[:f.w])
+			if f.pooled != nil {
+				frameBufferPool.Put(f.pooled)
+				f.pooled = nil
+			}
+			f.buf = buf
+		}
+
+		n, err := f.reader.Read(f.buf[f.w:])
+		f.w += n
+		if err != nil {
+			return nil, err
+		}
+	}
+}
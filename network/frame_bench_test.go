@@ -0,0 +1,155 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// splitReader 按固定大小返回数据，模拟帧跨越多次读取
+type splitReader struct {
+	data  []byte
+	chunk int
+}
+
+func (r *splitReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(r.chunk, len(p), len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func BenchmarkFrameReaderDelimiter(b *testing.B) {
+	frame := append(bytes.Repeat([]byte("x"), 62), '\r', '\n')
+	stream := bytes.Repeat(frame, 1024)
+	decoder, _ := NewDelimiterDecoder([]byte("\r\n"))
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader := newFrameReader(&splitReader{data: stream, chunk: 1000}, decoder, defaultMaxFrameLength)
+		for {
+			if _, err := reader.ReadFrame(); err != nil {
+				break
+			}
+		}
+		reader.release()
+	}
+}
+
+func BenchmarkFrameReaderLengthField(b *testing.B) {
+	frame := append([]byte{0x00, 0x3E}, bytes.Repeat([]byte{0xAB}, 62)...)
+	stream := bytes.Repeat(frame, 1024)
+	decoder, _ := NewLengthFieldDecoder(conf.PacketConfig{LengthFieldLength: 2})
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader := newFrameReader(&splitReader{data: stream, chunk: 1000}, decoder, defaultMaxFrameLength)
+		for {
+			if _, err := reader.ReadFrame(); err != nil {
+				break
+			}
+		}
+		reader.release()
+	}
+}
+
+// countProtocol 只统计收到的帧数，不回复
+type countProtocol struct{ frames atomic.Int64 }
+
+func (p *countProtocol) Init(device *model.Device, data []byte) error { return nil }
+
+func (p *countProtocol) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	return nil, nil
+}
+
+func (p *countProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	p.frames.Add(1)
+	return nil, nil
+}
+
+// BenchmarkTCPServer10kConnections 测试一万个连接同时上报时 TCP 服务器的吞吐量
+// 每次迭代所有连接各发送两个帧，其中第二个帧被拆分到两次写入中
+// 客户端与服务端在同一进程内，连接数受文件描述符上限限制
+func BenchmarkTCPServer10kConnections(b *testing.B) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil && limit.Cur < limit.Max {
+		limit.Cur = limit.Max
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	}
+	connCount := min(10000, int(limit.Cur-256)/2)
+	if connCount < 100 {
+		b.Skipf("文件描述符上限过低: %d", limit.Cur)
+	}
+
+	glog.SetLevelStr("info")
+	defer glog.SetLevelStr("all")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	protocol := &countProtocol{}
+	server := NewTCPServer(
+		WithProtocolHandler(protocol),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\r\n"}),
+		WithTimeout(time.Minute),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+	time.Sleep(100 * time.Millisecond)
+
+	conns := make([]net.Conn, 0, connCount)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < connCount; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatalf("建立第 %d 个连接失败: %v", i+1, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	payload := []byte(fmt.Sprintf("{\"temperature\":%d}\r\n{\"humidity\":", 25))
+	tail := []byte("60}\r\n")
+	frame := append(append([]byte(nil), payload...), tail...)
+	b.SetBytes(int64(len(frame) * connCount))
+	b.ResetTimer()
+
+	var want int64
+	for i := 0; i < b.N; i++ {
+		for _, conn := range conns {
+			conn.Write(payload)
+		}
+		for _, conn := range conns {
+			conn.Write(tail)
+		}
+		want += int64(2 * connCount)
+		for protocol.frames.Load() < want {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(connCount), "conns")
+	b.ReportMetric(float64(want)/b.Elapsed().Seconds(), "frames/s")
+}
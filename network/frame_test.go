@@ -0,0 +1,137 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+)
+
+// stxEtxDecoder 测试用的起止符帧解码器：0x02 开头、0x03 结尾，0x10 为转义符
+var stxEtxDecoder = FrameDecoderFunc(func(buf []byte) ([]byte, int, error) {
+	start := bytes.IndexByte(buf, 0x02)
+	if start < 0 {
+		return nil, len(buf), nil // 丢弃帧头之前的无效数据
+	}
+	if start > 0 {
+		return nil, start, nil
+	}
+	var frame []byte
+	for i := 1; i < len(buf); i++ {
+		switch buf[i] {
+		case 0x10:
+			if i+1 >= len(buf) {
+				return nil, 0, nil
+			}
+			i++
+			frame = append(frame, buf[i])
+		case 0x03:
+			return frame, i + 1, nil
+		default:
+			frame = append(frame, buf[i])
+		}
+	}
+	return nil, 0, nil
+})
+
+// chunkReader 每次只返回一个字节，用于模拟数据被拆分到多次读取
+type chunkReader struct{ data []byte }
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
+
+func TestFrameReaderCustomDecoder(t *testing.T) {
+	stream := []byte{0xFF, 0x02, 'a', 0x10, 0x03, 'b', 0x03, 0x00, 0x02, 'c', 0x03}
+	reader := newFrameReader(&chunkReader{data: stream}, stxEtxDecoder, 64)
+	for _, want := range [][]byte{{'a', 0x03, 'b'}, {'c'}} {
+		got, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("帧 = % x, 期望 % x", got, want)
+		}
+	}
+	if _, err := reader.ReadFrame(); err != io.EOF {
+		t.Fatalf("期望 io.EOF, 实际 %v", err)
+	}
+}
+
+func TestFrameReaderErrors(t *testing.T) {
+	decoder, _ := NewDelimiterDecoder([]byte("\n"))
+	reader := newFrameReader(bytes.NewReader(bytes.Repeat([]byte("x"), 100)), decoder, 16)
+	if _, err := reader.ReadFrame(); !errors.Is(err, ErrFrameTooLong) {
+		t.Errorf("期望 ErrFrameTooLong, 实际 %v", err)
+	}
+
+	failing := FrameDecoderFunc(func(buf []byte) ([]byte, int, error) {
+		return nil, 0, errors.New("校验失败")
+	})
+	reader = newFrameReader(bytes.NewReader([]byte{1}), failing, 16)
+	if _, err := reader.ReadFrame(); !errors.Is(err, ErrInvalidFrame) {
+		t.Errorf("期望 ErrInvalidFrame, 实际 %v", err)
+	}
+}
+
+// stxEtxProtocol 通过 FrameDecoderProvider/FrameEncoderProvider 提供帧编解码器的协议处理器
+type stxEtxProtocol struct{ echoProtocol }
+
+func (p *stxEtxProtocol) FrameDecoder() FrameDecoder { return stxEtxDecoder }
+
+func (p *stxEtxProtocol) FrameEncoder() FrameEncoder {
+	return FrameEncoderFunc(func(data []byte) ([]byte, error) {
+		return append(append([]byte{0x02}, data...), 0x03), nil
+	})
+}
+
+func TestTCPServerFrameDecoderProvider(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	protocol := &stxEtxProtocol{}
+	server := NewTCPServer(
+		WithProtocolHandler(protocol),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\n"}), // 协议提供的解码器优先
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte{0x02, 'h', 'i', 0x03})
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	reply := make([]byte, 16)
+	n, err := io.ReadAtLeast(conn, reply, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x02, 'a', 'c', 'k', ':', 'h', 'i', 0x03}; !bytes.Equal(reply[:n], want) {
+		t.Fatalf("回复 = % x, 期望 % x", reply[:n], want)
+	}
+}
@@ -0,0 +1,309 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// HTTPServer 结构体表示 HTTP 接入服务器，用于定时唤醒后通过 HTTP 上报数据的设备
+// 请求体作为一个完整帧交给协议处理器，响应体为 Decode 的回复与缓存的下发数据
+type HTTPServer struct {
+	*BaseServer
+	server   *http.Server
+	mu       sync.Mutex
+	queues   map[string][][]byte      // 按设备标识缓存的待下发数据
+	known    map[string]*model.Device // 按设备标识记录上报过的设备，离线期间下发仍可缓存
+	lastSeen map[string]time.Time     // 按设备标识记录最近一次上报的时间，用于清除长期未上报的设备
+}
+
+// NewHTTPServer 创建一个新的 HTTP 接入服务器实例
+func NewHTTPServer(options ...Option) NetworkServer {
+	s := &HTTPServer{
+		BaseServer: NewBaseServer(options...),
+		queues:     make(map[string][][]byte),
+		known:      make(map[string]*model.Device),
+		lastSeen:   make(map[string]time.Time),
+	}
+	return s
+}
+
+// Start 启动 HTTP 接入服务器
+func (s *HTTPServer) Start(ctx context.Context, addr string) error {
+	path := s.basePath()
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.handleRequest)
+	mux.HandleFunc(path+"/", s.handleRequest)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("HTTP 监听失败: %v", err)
+	}
+	s.server = &http.Server{Handler: mux}
+	if s.tlsConfig.Enable {
+		tlsConfig, err := NewTLSConfig(s.tlsConfig)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("TLS 配置错误: %v", err)
+		}
+		s.server.TLSConfig = tlsConfig
+	}
+
+	go s.cleanupInactiveDevices(ctx)
+	go s.cleanupIdleDevices(ctx)
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	if s.tlsConfig.Enable {
+		err = s.server.ServeTLS(listener, "", "")
+	} else {
+		err = s.server.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil // 正常关闭
+	}
+	return err
+}
+
+// Stop 停止 HTTP 接入服务器
+func (s *HTTPServer) Stop() error {
+	if s.server != nil {
+		return s.server.Close()
+	}
+	return nil
+}
+
+// SendData 缓存下发给 HTTP 设备的数据，设备下一次上报时在响应中返回
+func (s *HTTPServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	deviceKey := device.DeviceKey
+	if deviceKey == "" {
+		deviceKey = device.ClientID
+	}
+	if deviceKey == "" {
+		return errors.New("HTTP 设备标识为空")
+	}
+
+	var encodedData []byte
+	var err error
+
+	if s.protocolHandler != nil {
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else {
+		encodedData = []byte(fmt.Sprintf("%v", data))
+	}
+	if encodedData, err = s.encodeFrame(encodedData); err != nil {
+		return fmt.Errorf("数据成帧失败: %v", err)
+	}
+
+	maxQueueSize := s.httpConfig.MaxQueueSize
+	if maxQueueSize <= 0 {
+		maxQueueSize = 100
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := append(s.queues[deviceKey], encodedData)
+	if len(queue) > maxQueueSize {
+		glog.Debugf(context.Background(), "HTTP 设备 %s 下发队列已满，丢弃最早的 %d 条数据", deviceKey, len(queue)-maxQueueSize)
+		queue = queue[len(queue)-maxQueueSize:]
+	}
+	s.queues[deviceKey] = queue
+	return nil
+}
+
+// LookupDevice 按设备标识查找设备，设备定时唤醒上报，离线期间仍返回最近一次上报的设备，下发数据缓存到下一次上报
+func (s *HTTPServer) LookupDevice(deviceKey string) *model.Device {
+	if device := s.BaseServer.LookupDevice(deviceKey); device != nil {
+		return device
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.known[deviceKey]
+}
+
+// Pending 返回设备待下发的数据条数
+func (s *HTTPServer) Pending(deviceKey string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queues[deviceKey])
+}
+
+// handleRequest 处理设备上报请求
+func (s *HTTPServer) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deviceKey := s.requestDeviceKey(r)
+	if deviceKey == "" {
+		http.Error(w, "missing device key", http.StatusBadRequest)
+		return
+	}
+	// 认证通过前不创建设备，也不取出缓存的下发数据
+	if !s.authorize(r, deviceKey) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	maxBodySize := s.httpConfig.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = 1 << 20
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	device := s.getDevice(deviceKey)
+	if device == nil {
+		device = s.handleConnect(deviceKey, nil)
+		s.bindDevice(device, deviceKey)
+	}
+	s.remember(device)
+
+	resData, err := s.handleReceiveData(device, body)
+	if err != nil {
+		glog.Debugf(context.Background(), "处理 HTTP 设备 %s 数据错误: %v\n", deviceKey, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if resData != nil {
+		if err := s.SendData(device, resData); err != nil {
+			glog.Debugf(context.Background(), "编码回复失败: %v\n", err)
+		}
+	}
+
+	// 一次取出全部缓存的下发数据，按顺序拼接到响应体中，由协议自身的帧格式区分
+	s.mu.Lock()
+	queue := s.queues[deviceKey]
+	delete(s.queues, deviceKey)
+	s.mu.Unlock()
+
+	if len(queue) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	contentType := s.httpConfig.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Downlink-Count", strconv.Itoa(len(queue)))
+	w.Write(bytes.Join(queue, nil))
+}
+
+// authorize 校验请求能否代表设备标识上报
+// 使用已验证的客户端证书时证书中的设备标识须与请求的一致，配置了令牌时须携带该设备的令牌
+func (s *HTTPServer) authorize(r *http.Request, deviceKey string) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if certKey := CertificateDeviceKey(r.TLS.PeerCertificates[0], s.tlsConfig.DeviceKeyFrom); certKey != "" && certKey != deviceKey {
+			return false
+		}
+	}
+	if len(s.httpConfig.Tokens) == 0 {
+		return true
+	}
+	token := s.httpConfig.Tokens[deviceKey]
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(bearer)) == 1
+}
+
+// remember 记录上报的设备，设备数量超过上限时清除最久未上报的设备
+func (s *HTTPServer) remember(device *model.Device) {
+	maxDevices := s.httpConfig.MaxDevices
+	if maxDevices <= 0 {
+		maxDevices = 10000
+	}
+	s.mu.Lock()
+	if _, ok := s.known[device.DeviceKey]; !ok && len(s.known) >= maxDevices {
+		oldest := ""
+		for deviceKey, seen := range s.lastSeen {
+			if oldest == "" || seen.Before(s.lastSeen[oldest]) {
+				oldest = deviceKey
+			}
+		}
+		s.forget(oldest)
+		glog.Debugf(context.Background(), "HTTP 设备数量达到上限 %d，清除最久未上报的设备 %s", maxDevices, oldest)
+	}
+	s.known[device.DeviceKey] = device
+	s.lastSeen[device.DeviceKey] = time.Now()
+	s.mu.Unlock()
+}
+
+// forget 清除设备记录与缓存的下发数据，调用方需持有 s.mu
+func (s *HTTPServer) forget(deviceKey string) {
+	delete(s.known, deviceKey)
+	delete(s.lastSeen, deviceKey)
+	delete(s.queues, deviceKey)
+}
+
+// cleanupIdleDevices 定期清除超过 IdleTimeout 未上报的设备记录与缓存的下发数据
+func (s *HTTPServer) cleanupIdleDevices(ctx context.Context) {
+	idleTimeout := s.httpConfig.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 24 * time.Hour
+	}
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			for deviceKey, seen := range s.lastSeen {
+				if time.Since(seen) > idleTimeout {
+					s.forget(deviceKey)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// requestDeviceKey 从请求路径、请求头、查询参数中获取设备标识
+func (s *HTTPServer) requestDeviceKey(r *http.Request) string {
+	if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, s.basePath()), "/"); rest != "" && !strings.Contains(rest, "/") {
+		return rest
+	}
+	header := s.httpConfig.DeviceKeyHeader
+	if header == "" {
+		header = "X-Device-Key"
+	}
+	if deviceKey := r.Header.Get(header); deviceKey != "" {
+		return deviceKey
+	}
+	query := s.httpConfig.DeviceKeyQuery
+	if query == "" {
+		query = "deviceKey"
+	}
+	return r.URL.Query().Get(query)
+}
+
+// basePath 获取接收数据的路径
+func (s *HTTPServer) basePath() string {
+	if s.httpConfig.Path == "" {
+		return "/data"
+	}
+	return "/" + strings.Trim(s.httpConfig.Path, "/")
+}
@@ -0,0 +1,153 @@
+package network
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+func TestHTTPServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	protocol := &echoProtocol{}
+	server := NewHTTPServer(
+		WithProtocolHandler(protocol),
+		WithHTTPConfig(conf.HTTPConfig{Path: "/up"}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	post := func(url string, header map[string]string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader("data"))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		var resp *http.Response
+		for i := 0; i < 50; i++ {
+			if resp, err = http.DefaultClient.Do(req); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// 设备休眠期间缓存的下发数据在下一次上报时随 Decode 的回复一起返回
+	if err := server.SendData(&model.Device{DeviceKey: "lte-001"}, []byte("cmd1;")); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := post("http://"+addr+"/up/lte-001", nil)
+	if resp.StatusCode != http.StatusOK || body != "cmd1;ack:data" || resp.Header.Get("X-Downlink-Count") != "2" {
+		t.Fatalf("响应不正确: %d %q", resp.StatusCode, body)
+	}
+	if server.(*HTTPServer).Pending("lte-001") != 0 {
+		t.Fatal("下发队列未清空")
+	}
+
+	// 设备超时离线后仍可寻址，下发数据继续缓存
+	httpServer := server.(*HTTPServer)
+	device := httpServer.LookupDevice("lte-001")
+	if device == nil {
+		t.Fatal("上报过的设备应可查找")
+	}
+	httpServer.handleDisconnect(device)
+	if device = httpServer.LookupDevice("lte-001"); device == nil || device.OnlineStatus {
+		t.Fatalf("离线设备应可查找: %+v", device)
+	}
+	if err := server.SendData(device, []byte("cmd2;")); err != nil || httpServer.Pending("lte-001") != 1 {
+		t.Fatalf("离线设备的下发未缓存: %v", err)
+	}
+	if httpServer.LookupDevice("lte-009") != nil {
+		t.Fatal("未上报过的设备不应可查找")
+	}
+
+	resp, _ = post("http://"+addr+"/up?deviceKey=lte-002", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("查询参数识别设备失败: %d", resp.StatusCode)
+	}
+	resp, _ = post("http://"+addr+"/up", map[string]string{"X-Device-Key": "lte-003"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("请求头识别设备失败: %d", resp.StatusCode)
+	}
+	resp, _ = post("http://"+addr+"/up", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("缺少设备标识应返回 400, 实际 %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPServerAuth(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := NewHTTPServer(
+		WithProtocolHandler(&echoProtocol{}),
+		WithHTTPConfig(conf.HTTPConfig{Tokens: map[string]string{"lte-001": "secret", "lte-002": "secret2"}, MaxDevices: 1}),
+	).(*HTTPServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	post := func(deviceKey, token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/data/"+deviceKey, strings.NewReader("data"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		var resp *http.Response
+		for i := 0; i < 50; i++ {
+			if resp, err = http.DefaultClient.Do(req); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// 未配置令牌的设备与令牌错误的请求不创建设备，也不取出缓存的下发数据
+	server.SendData(&model.Device{DeviceKey: "lte-001"}, []byte("cmd;"))
+	if code, _ := post("lte-unknown", "secret"); code != http.StatusUnauthorized || server.LookupDevice("lte-unknown") != nil {
+		t.Fatalf("未知设备应被拒绝: %d", code)
+	}
+	if code, _ := post("lte-001", "wrong"); code != http.StatusUnauthorized || server.Pending("lte-001") != 1 {
+		t.Fatalf("令牌错误的请求应被拒绝: %d", code)
+	}
+	if code, body := post("lte-001", "secret"); code != http.StatusOK || body != "cmd;ack:data" {
+		t.Fatalf("认证通过的响应不正确: %d %q", code, body)
+	}
+
+	// 设备数量达到上限时清除最久未上报的设备
+	server.SendData(server.LookupDevice("lte-001"), []byte("cmd;"))
+	if code, _ := post("lte-002", "secret2"); code != http.StatusOK {
+		t.Fatalf("认证通过的响应不正确: %d", code)
+	}
+	server.handleDisconnect(server.LookupDevice("lte-001"))
+	if server.LookupDevice("lte-001") != nil || server.Pending("lte-001") != 0 {
+		t.Fatal("超出上限的设备记录与缓存应被清除")
+	}
+}
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+)
+
+// defaultMaxFrameLength 默认最大帧长度
+const defaultMaxFrameLength = 64 * 1024
+
+var (
+	// ErrFrameTooLong 数据帧超过最大长度
+	ErrFrameTooLong = errors.New("数据帧超过最大长度")
+	// ErrInvalidFrame 数据帧格式错误，无法继续从数据流中切分
+	ErrInvalidFrame = errors.New("数据帧格式错误")
+)
+
+// lengthField 描述了长度字段帧的解析规则
+type lengthField struct {
+	offset     int              // 长度字段偏移
+	size       int              // 长度字段字节数
+	order      binary.ByteOrder // 长度字段字节序
+	adjustment int              // 长度修正值
+	strip      int              // 剥离的首部字节数
+	maxLength  int              // 最大帧长度
+}
+
+// newLengthField 根据粘包配置生成长度字段解析规则
+// 未设置 LengthFieldLength 时兼容旧配置：头部为 HeaderLength 字节，前 4 字节为大端的体长度，只返回体数据
+func newLengthField(config conf.PacketConfig) (lengthField, error) {
+	field := lengthField{
+		offset:     config.LengthFieldOffset,
+		size:       config.LengthFieldLength,
+		order:      binary.BigEndian,
+		adjustment: config.LengthAdjustment,
+		strip:      config.InitialBytesToStrip,
+		maxLength:  config.MaxFrameLength,
+	}
+	if field.size == 0 {
+		if config.HeaderLength <= 0 {
+			return field, errors.New("未配置头部长度或长度字段")
+		}
+		field.offset = 0
+		field.size = min(config.HeaderLength, 4)
+		field.adjustment = config.HeaderLength - field.size
+		field.strip = config.HeaderLength
+	}
+
+	switch field.size {
+	case 1, 2, 3, 4, 8:
+	default:
+		return field, fmt.Errorf("不支持的长度字段字节数: %d", field.size)
+	}
+	switch strings.ToLower(config.ByteOrder) {
+	case "", "big", "be":
+	case "little", "le":
+		field.order = binary.LittleEndian
+	default:
+		return field, fmt.Errorf("不支持的字节序: %s", config.ByteOrder)
+	}
+	if field.offset < 0 || field.strip < 0 {
+		return field, errors.New("长度字段偏移与剥离字节数不能为负数")
+	}
+	if field.maxLength <= 0 {
+		field.maxLength = defaultMaxFrameLength
+	}
+	return field, nil
+}
+
+// headerLength 返回能够解析出帧长度所需的最少字节数
+func (f lengthField) headerLength() int {
+	return f.offset + f.size
+}
+
+// frameLength 根据帧首部计算完整帧长度，header 长度不少于 headerLength
+func (f lengthField) frameLength(header []byte) (int, error) {
+	b := header[f.offset : f.offset+f.size]
+	var value uint64
+	switch f.size {
+	case 1:
+		value = uint64(b[0])
+	case 2:
+		value = uint64(f.order.Uint16(b))
+	case 3:
+		if f.order == binary.BigEndian {
+			value = uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		} else {
+			value = uint64(b[2])<<16 | uint64(b[1])<<8 | uint64(b[0])
+		}
+	case 4:
+		value = uint64(f.order.Uint32(b))
+	case 8:
+		value = f.order.Uint64(b)
+	}
+	if value > uint64(f.maxLength) {
+		return 0, fmt.Errorf("%w: 长度字段值 %d", ErrFrameTooLong, value)
+	}
+
+	length := f.headerLength() + int(value) + f.adjustment
+	if length < f.headerLength() || length < f.strip {
+		return 0, fmt.Errorf("%w: 长度字段值 %d 计算出的帧长度 %d 无效", ErrInvalidFrame, value, length)
+	}
+	if length > f.maxLength {
+		return 0, fmt.Errorf("%w: %d > %d", ErrFrameTooLong, length, f.maxLength)
+	}
+	return length, nil
+}
+
+// Decode 实现 FrameDecoder 接口，按长度字段切分完整帧并剥离配置的首部字节
+func (f lengthField) Decode(buf []byte) ([]byte, int, error) {
+	if len(buf) < f.headerLength() {
+		return nil, 0, nil
+	}
+	length, err := f.frameLength(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(buf) < length {
+		return nil, 0, nil
+	}
+	return buf[f.strip:length], length, nil
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+)
+
+func TestLengthFieldRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		config conf.PacketConfig
+		stream []byte
+		want   [][]byte
+	}{
+		{
+			name:   "兼容旧配置",
+			config: conf.PacketConfig{HeaderLength: 4},
+			stream: []byte{0, 0, 0, 2, 'h', 'i', 0, 0, 0, 1, '!'},
+			want:   [][]byte{[]byte("hi"), []byte("!")},
+		},
+		{
+			name:   "Modbus TCP MBAP",
+			config: conf.PacketConfig{LengthFieldOffset: 4, LengthFieldLength: 2},
+			stream: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x0A},
+			want:   [][]byte{{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}},
+		},
+		{
+			name: "小端长度包含头部并剥离头部",
+			config: conf.PacketConfig{LengthFieldOffset: 1, LengthFieldLength: 2, ByteOrder: "little",
+				LengthAdjustment: -3, InitialBytesToStrip: 3},
+			stream: []byte{0xAA, 0x05, 0x00, 0x01, 0x02, 0xAA, 0x04, 0x00, 0x03},
+			want:   [][]byte{{0x01, 0x02}, {0x03}},
+		},
+		{
+			name:   "单字节长度不含校验",
+			config: conf.PacketConfig{LengthFieldOffset: 1, LengthFieldLength: 1, LengthAdjustment: 1},
+			stream: []byte{0x68, 0x02, 0x10, 0x20, 0x30, 0x68, 0x00, 0xFF},
+			want:   [][]byte{{0x68, 0x02, 0x10, 0x20, 0x30}, {0x68, 0x00, 0xFF}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, err := newLengthField(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := newFrameReader(bytes.NewReader(tt.stream), field, field.maxLength)
+			for i, want := range tt.want {
+				got, err := reader.ReadFrame()
+				if err != nil {
+					t.Fatalf("第 %d 帧读取失败: %v", i+1, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("第 %d 帧 = % x, 期望 % x", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFieldErrors(t *testing.T) {
+	field, err := newLengthField(conf.PacketConfig{LengthFieldLength: 2, MaxFrameLength: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := field.Decode([]byte{0x00, 0xFF}); !errors.Is(err, ErrFrameTooLong) {
+		t.Errorf("超长帧应返回 ErrFrameTooLong, 实际 %v", err)
+	}
+
+	field, _ = newLengthField(conf.PacketConfig{LengthFieldLength: 1, LengthAdjustment: -5})
+	if _, _, err := field.Decode([]byte{0x01, 0x00}); !errors.Is(err, ErrInvalidFrame) {
+		t.Errorf("无效长度应返回 ErrInvalidFrame, 实际 %v", err)
+	}
+
+	if _, err := newLengthField(conf.PacketConfig{LengthFieldLength: 5}); err == nil {
+		t.Error("不支持的长度字段字节数应当报错")
+	}
+}
@@ -0,0 +1,455 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/coap"
+	"github.com/sagoo-cloud/iotgateway/network/lwm2m"
+)
+
+const (
+	lwm2mDefaultLifetime = 86400 * time.Second // 注册请求未携带 lt 时的生存期
+	lwm2mCheckInterval   = time.Second         // 检查注册是否过期的间隔
+)
+
+// LwM2MServer 结构体表示 LwM2M 1.0 服务器，基于 CoAP 传输接入只支持 LwM2M 的 NB-IoT 模组
+// 设备通过 /rd 注册、更新与注销，终端名称(ep)作为设备标识；注册后观察配置的路径，通知中映射的资源通过 PushAttributeDataToMQTT 上报。
+// 平台的属性设置转换为资源写入，服务调用转换为资源执行或读取
+type LwM2MServer struct {
+	*CoAPServer
+	ctx context.Context
+
+	regMu         sync.Mutex                    // 同时保护终端设备的 LastActive
+	registrations map[string]*lwm2mRegistration // 注册ID -> 注册
+	endpoints     map[string]*lwm2mRegistration // 终端名称 -> 注册
+	nextID        uint64
+}
+
+// lwm2mRegistration 表示一个终端的注册
+type lwm2mRegistration struct {
+	id       string
+	endpoint string
+	lifetime time.Duration
+	updated  time.Time
+	objects  []string // 注册时上报的 对象 或 对象/实例
+	device   *model.Device
+	cancel   context.CancelFunc // 取消该注册的所有观察
+}
+
+// NewLwM2MServer 创建一个新的 LwM2M 服务器实例
+func NewLwM2MServer(options ...Option) NetworkServer {
+	s := &LwM2MServer{
+		CoAPServer:    NewCoAPServer(options...).(*CoAPServer),
+		registrations: make(map[string]*lwm2mRegistration),
+		endpoints:     make(map[string]*lwm2mRegistration),
+	}
+	s.route = s.serveLwM2M
+	return s
+}
+
+// Start 启动 LwM2M 服务器
+func (s *LwM2MServer) Start(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":5683"
+	}
+	s.ctx = ctx
+	go s.checkRegistrations(ctx)
+	return s.CoAPServer.Start(ctx, addr)
+}
+
+// SendData 按资源映射将属性写入设备，data 为 属性标识 -> 值
+func (s *LwM2MServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	properties := gconv.Map(data)
+	ctx, cancel := context.WithTimeout(context.Background(), s.exchangeTimeout())
+	defer cancel()
+	written := 0
+	for _, resource := range s.lwm2mConfig.Resources {
+		value, ok := properties[resource.Name]
+		if !ok {
+			continue
+		}
+		if err := s.Write(ctx, device, resource.Path, value); err != nil {
+			return err
+		}
+		written++
+	}
+	if written == 0 {
+		return errors.New("LwM2M 下发数据中没有已映射的属性")
+	}
+	return nil
+}
+
+// Read 读取设备的对象、实例或资源，返回映射的属性
+func (s *LwM2MServer) Read(ctx context.Context, device *model.Device, path string) (map[string]interface{}, error) {
+	path, _, err := lwm2m.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.GET}
+	req.SetPath(path)
+	resp, err := s.Request(ctx, device, req)
+	if err != nil {
+		return nil, fmt.Errorf("LwM2M 读取 %s 失败: %w", path, err)
+	}
+	if resp.Code != coap.Content {
+		return nil, fmt.Errorf("LwM2M 读取 %s 失败: 设备返回 %s", path, resp.Code)
+	}
+	return s.decodeProperties(path, resp)
+}
+
+// Write 写入设备资源，值按资源映射中的数据类型以文本或二进制格式编码
+func (s *LwM2MServer) Write(ctx context.Context, device *model.Device, path string, value interface{}) error {
+	path, ids, err := lwm2m.ParsePath(path)
+	if err != nil {
+		return err
+	}
+	if len(ids) < 3 {
+		return fmt.Errorf("LwM2M 写入路径 %s 不是资源", path)
+	}
+	payload, format, err := lwm2m.Encode(s.resourceType(path), value)
+	if err != nil {
+		return fmt.Errorf("LwM2M 资源 %s: %w", path, err)
+	}
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.PUT, Payload: payload}
+	req.SetPath(path)
+	req.SetUint(coap.ContentFormat, format)
+	resp, err := s.Request(ctx, device, req)
+	if err != nil {
+		return fmt.Errorf("LwM2M 写入 %s 失败: %w", path, err)
+	}
+	if resp.Code != coap.Changed {
+		return fmt.Errorf("LwM2M 写入 %s 失败: 设备返回 %s", path, resp.Code)
+	}
+	return nil
+}
+
+// Execute 执行设备资源，args 为执行参数，如 0='on',1
+func (s *LwM2MServer) Execute(ctx context.Context, device *model.Device, path string, args string) error {
+	path, ids, err := lwm2m.ParsePath(path)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 3 {
+		return fmt.Errorf("LwM2M 执行路径 %s 不是资源", path)
+	}
+	req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, Payload: []byte(args)}
+	req.SetPath(path)
+	if args != "" {
+		req.SetUint(coap.ContentFormat, lwm2m.FormatText)
+	}
+	resp, err := s.Request(ctx, device, req)
+	if err != nil {
+		return fmt.Errorf("LwM2M 执行 %s 失败: %w", path, err)
+	}
+	if resp.Code != coap.Changed {
+		return fmt.Errorf("LwM2M 执行 %s 失败: 设备返回 %s", path, resp.Code)
+	}
+	return nil
+}
+
+// serveLwM2M 处理注册接口的请求，返回的函数在注册响应发送后开始观察
+func (s *LwM2MServer) serveLwM2M(addr *net.UDPAddr, req *coap.Message) (*coap.Message, func()) {
+	segments := req.Segments()
+	if len(segments) == 0 || len(segments) > 2 || segments[0] != "rd" {
+		return &coap.Message{Code: coap.NotFound}, nil
+	}
+	if len(segments) == 1 {
+		if req.Code != coap.POST {
+			return &coap.Message{Code: coap.MethodNotAllowed}, nil
+		}
+		return s.register(addr, req)
+	}
+	switch req.Code {
+	case coap.POST:
+		return s.update(addr, segments[1], req), nil
+	case coap.DELETE:
+		return s.deregister(segments[1]), nil
+	}
+	return &coap.Message{Code: coap.MethodNotAllowed}, nil
+}
+
+// register 处理注册请求，相同终端名称的注册被替换
+func (s *LwM2MServer) register(addr *net.UDPAddr, req *coap.Message) (*coap.Message, func()) {
+	endpoint := req.Query("ep")
+	if endpoint == "" {
+		return &coap.Message{Code: coap.BadRequest, Payload: []byte("缺少终端名称 ep")}, nil
+	}
+	if version := req.Query("lwm2m"); version != "" && !strings.HasPrefix(version, "1.") {
+		return &coap.Message{Code: coap.PreconditionFailed, Payload: []byte("不支持的 LwM2M 版本 " + version)}, nil
+	}
+	if binding := req.Query("b"); binding != "" && !strings.Contains(binding, "U") {
+		return &coap.Message{Code: coap.BadRequest, Payload: []byte("只支持 UDP 绑定")}, nil
+	}
+	lifetime, err := lwm2mLifetime(req.Query("lt"), lwm2mDefaultLifetime)
+	if err != nil {
+		return &coap.Message{Code: coap.BadRequest, Payload: []byte(err.Error())}, nil
+	}
+
+	device := s.handleConnect(endpoint, nil)
+	s.bindDevice(device, endpoint)
+	s.addrs.Store(endpoint, addr)
+	ctx, cancel := context.WithCancel(s.ctx)
+	reg := &lwm2mRegistration{
+		endpoint: endpoint,
+		lifetime: lifetime,
+		updated:  time.Now(),
+		objects:  lwm2m.ParseLinks(string(req.Payload)),
+		device:   device,
+		cancel:   cancel,
+	}
+
+	s.regMu.Lock()
+	if old := s.endpoints[endpoint]; old != nil {
+		delete(s.registrations, old.id)
+		old.cancel()
+	}
+	s.nextID++
+	reg.id = strconv.FormatUint(s.nextID, 10)
+	s.registrations[reg.id] = reg
+	s.endpoints[endpoint] = reg
+	s.regMu.Unlock()
+	glog.Debugf(context.Background(), "LwM2M 终端 %s 注册 %s, 生存期 %s, 对象 %v\n", endpoint, reg.id, lifetime, reg.objects)
+
+	resp := &coap.Message{Code: coap.Created}
+	resp.SetLocationPath("rd/" + reg.id)
+	return resp, func() { s.observe(ctx, reg) }
+}
+
+// update 处理更新请求，更新生存期、地址与对象列表
+func (s *LwM2MServer) update(addr *net.UDPAddr, id string, req *coap.Message) *coap.Message {
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+	reg := s.registrations[id]
+	if reg == nil {
+		return &coap.Message{Code: coap.NotFound}
+	}
+	lifetime, err := lwm2mLifetime(req.Query("lt"), reg.lifetime)
+	if err != nil {
+		return &coap.Message{Code: coap.BadRequest, Payload: []byte(err.Error())}
+	}
+	reg.lifetime, reg.updated = lifetime, time.Now()
+	if len(req.Payload) > 0 {
+		reg.objects = lwm2m.ParseLinks(string(req.Payload))
+	}
+	reg.device.LastActive = reg.updated
+	s.addrs.Store(reg.endpoint, addr)
+	return &coap.Message{Code: coap.Changed}
+}
+
+// deregister 处理注销请求，设备离线
+func (s *LwM2MServer) deregister(id string) *coap.Message {
+	s.regMu.Lock()
+	reg := s.registrations[id]
+	if reg != nil {
+		s.removeRegistration(reg)
+	}
+	s.regMu.Unlock()
+	if reg == nil {
+		return &coap.Message{Code: coap.NotFound}
+	}
+	glog.Debugf(context.Background(), "LwM2M 终端 %s 注销 %s\n", reg.endpoint, id)
+	s.handleDisconnect(reg.device)
+	return &coap.Message{Code: coap.Deleted}
+}
+
+// removeRegistration 删除注册并取消观察，调用时需持有 regMu
+func (s *LwM2MServer) removeRegistration(reg *lwm2mRegistration) {
+	delete(s.registrations, reg.id)
+	if s.endpoints[reg.endpoint] == reg {
+		delete(s.endpoints, reg.endpoint)
+	}
+	reg.cancel()
+}
+
+// checkRegistrations 删除超过生存期未更新的注册
+func (s *LwM2MServer) checkRegistrations(ctx context.Context) {
+	ticker := time.NewTicker(lwm2mCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			var expired []*lwm2mRegistration
+			s.regMu.Lock()
+			for _, reg := range s.registrations {
+				if now.Sub(reg.updated) > reg.lifetime {
+					s.removeRegistration(reg)
+					expired = append(expired, reg)
+				}
+			}
+			s.regMu.Unlock()
+			for _, reg := range expired {
+				glog.Debugf(context.Background(), "LwM2M 终端 %s 注册 %s 已过期\n", reg.endpoint, reg.id)
+				s.handleDisconnect(reg.device)
+			}
+		}
+	}
+}
+
+// observe 观察注册的对象中配置的路径
+func (s *LwM2MServer) observe(ctx context.Context, reg *lwm2mRegistration) {
+	for _, path := range s.lwm2mConfig.Observe {
+		path, _, err := lwm2m.ParsePath(path)
+		if err != nil {
+			glog.Debugf(context.Background(), "LwM2M 观察路径错误: %v\n", err)
+			continue
+		}
+		if !lwm2mRegistered(reg.objects, path) {
+			continue
+		}
+		if _, err := s.Observe(ctx, reg.device, path, func(device *model.Device, msg *coap.Message) {
+			s.handleObserve(device, path, msg)
+		}); err != nil {
+			glog.Debugf(context.Background(), "LwM2M 终端 %s 观察 %s 失败: %v\n", reg.endpoint, path, err)
+		}
+	}
+}
+
+// handleObserve 将观察通知中映射的资源作为属性上报，设置了协议处理器时负载交给协议处理器
+func (s *LwM2MServer) handleObserve(device *model.Device, path string, msg *coap.Message) {
+	if s.protocolHandler != nil {
+		s.handleNotify(device, msg)
+		return
+	}
+	if !msg.Code.IsSuccess() {
+		glog.Debugf(context.Background(), "LwM2M 终端 %s 观察 %s 通知错误: %s\n", device.DeviceKey, path, msg.Code)
+		return
+	}
+	properties, err := s.decodeProperties(path, msg)
+	if err != nil {
+		glog.Debugf(context.Background(), "解析 LwM2M 终端 %s 通知失败: %v\n", device.DeviceKey, err)
+		return
+	}
+	s.regMu.Lock()
+	device.LastActive = time.Now()
+	s.regMu.Unlock()
+	if len(properties) == 0 {
+		return
+	}
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         device.DeviceKey,
+		"PropertieDataList": properties,
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 LwM2M 终端 %s 数据失败: %v\n", device.DeviceKey, err)
+	}
+}
+
+// decodeProperties 解析读取或观察通知的响应，返回 属性标识 -> 值
+func (s *LwM2MServer) decodeProperties(path string, msg *coap.Message) (map[string]interface{}, error) {
+	types := make(map[string]string, len(s.lwm2mConfig.Resources))
+	names := make(map[string]string, len(s.lwm2mConfig.Resources))
+	for _, resource := range s.lwm2mConfig.Resources {
+		if resourcePath, _, err := lwm2m.ParsePath(resource.Path); err == nil {
+			types[resourcePath] = resource.Type
+			names[resourcePath] = resource.Name
+		}
+	}
+	format, _ := msg.ContentFormat()
+	values, err := lwm2m.Decode(path, format, msg.Payload, types)
+	if err != nil {
+		return nil, err
+	}
+	properties := make(map[string]interface{}, len(values))
+	for resourcePath, value := range values {
+		properties[names[resourcePath]] = value
+	}
+	return properties, nil
+}
+
+// resourceType 获取资源映射中的数据类型
+func (s *LwM2MServer) resourceType(path string) string {
+	for _, resource := range s.lwm2mConfig.Resources {
+		if resourcePath, _, err := lwm2m.ParsePath(resource.Path); err == nil && resourcePath == path {
+			return resource.Type
+		}
+	}
+	return ""
+}
+
+// SetProperties 实现 PropertySetter 接口，按资源映射将属性设置写入终端，返回写入成功的属性
+func (s *LwM2MServer) SetProperties(ctx context.Context, requester Requester, device *model.Device, properties map[string]interface{}) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.exchangeTimeout())
+	defer cancel()
+	reply := make(map[string]interface{})
+	for _, resource := range s.lwm2mConfig.Resources {
+		value, ok := properties[resource.Name]
+		if !ok {
+			continue
+		}
+		if err := s.Write(ctx, device, resource.Path, value); err != nil {
+			glog.Errorf(ctx, "LwM2M 终端 %s 写入属性 %s 失败: %v", device.DeviceKey, resource.Name, err)
+			continue
+		}
+		reply[resource.Name] = value
+	}
+	return reply, nil
+}
+
+// Services 实现 ServiceCaller 接口，返回配置的服务标识
+func (s *LwM2MServer) Services() []string {
+	services := make([]string, 0, len(s.lwm2mConfig.Services))
+	for _, service := range s.lwm2mConfig.Services {
+		services = append(services, service.Name)
+	}
+	return services
+}
+
+// CallService 实现 ServiceCaller 接口，将服务调用转换为资源执行或读取，读取返回映射的属性
+func (s *LwM2MServer) CallService(ctx context.Context, requester Requester, device *model.Device, service string, params map[string]interface{}) (map[string]interface{}, error) {
+	for _, item := range s.lwm2mConfig.Services {
+		if item.Name != service {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.exchangeTimeout())
+		defer cancel()
+		if item.Operation == "read" {
+			return s.Read(ctx, device, item.Path)
+		}
+		if err := s.Execute(ctx, device, item.Path, gconv.String(params["args"])); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{}, nil
+	}
+	return nil, fmt.Errorf("LwM2M 服务 %s 未配置", service)
+}
+
+// lwm2mLifetime 解析注册的生存期，单位为秒
+func lwm2mLifetime(value string, defaultLifetime time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultLifetime, nil
+	}
+	seconds, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || seconds == 0 {
+		return 0, fmt.Errorf("生存期 lt=%s 错误", value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// lwm2mRegistered 判断观察路径所属的对象或实例是否在注册的对象列表中，列表为空时视为全部注册
+func lwm2mRegistered(objects []string, path string) bool {
+	if len(objects) == 0 {
+		return true
+	}
+	for _, object := range objects {
+		if path == object || strings.HasPrefix(path, object+"/") || strings.HasPrefix(object, path+"/") {
+			return true
+		}
+	}
+	return false
+}
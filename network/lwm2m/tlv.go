@@ -0,0 +1,123 @@
+// Package lwm2m 实现 LwM2M 1.0 的资源路径、TLV 与 JSON 数据格式以及注册接口的链接格式解析
+package lwm2m
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TLV 标识类型
+const (
+	TLVObjectInstance   byte = 0x00
+	TLVResourceInstance byte = 0x01
+	TLVMultipleResource byte = 0x02
+	TLVResource         byte = 0x03
+)
+
+// ErrInvalidTLV TLV 格式错误
+var ErrInvalidTLV = errors.New("LwM2M TLV 格式错误")
+
+// TLV 一个 TLV 项，对象实例与多实例资源的值为嵌套的 TLV 项
+type TLV struct {
+	Type     byte
+	ID       uint16
+	Value    []byte // 资源、资源实例的值
+	Children []TLV  // 对象实例、多实例资源包含的项
+}
+
+// ParseTLV 解析 TLV 格式的数据
+func ParseTLV(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(data) > 0 {
+		header := data[0]
+		tlv := TLV{Type: header >> 6}
+		data = data[1:]
+
+		idLength := 1
+		if header&0x20 != 0 {
+			idLength = 2
+		}
+		lengthType := int(header >> 3 & 0x03)
+		if len(data) < idLength+lengthType {
+			return nil, ErrInvalidTLV
+		}
+		if idLength == 1 {
+			tlv.ID = uint16(data[0])
+		} else {
+			tlv.ID = binary.BigEndian.Uint16(data)
+		}
+		data = data[idLength:]
+
+		length := int(header & 0x07)
+		if lengthType > 0 {
+			length = 0
+			for _, b := range data[:lengthType] {
+				length = length<<8 | int(b)
+			}
+			data = data[lengthType:]
+		}
+		if len(data) < length {
+			return nil, ErrInvalidTLV
+		}
+		value := data[:length]
+		data = data[length:]
+
+		if tlv.Type == TLVObjectInstance || tlv.Type == TLVMultipleResource {
+			children, err := ParseTLV(value)
+			if err != nil {
+				return nil, err
+			}
+			tlv.Children = children
+		} else {
+			tlv.Value = value
+		}
+		tlvs = append(tlvs, tlv)
+	}
+	return tlvs, nil
+}
+
+// MarshalTLV 编码 TLV 项
+func MarshalTLV(tlvs []TLV) ([]byte, error) {
+	var data []byte
+	for _, tlv := range tlvs {
+		value := tlv.Value
+		if tlv.Type == TLVObjectInstance || tlv.Type == TLVMultipleResource {
+			var err error
+			if value, err = MarshalTLV(tlv.Children); err != nil {
+				return nil, err
+			}
+		}
+		if len(value) > 0xFFFFFF {
+			return nil, fmt.Errorf("LwM2M TLV 值长度 %d 超出范围", len(value))
+		}
+
+		header := tlv.Type << 6
+		var id []byte
+		if tlv.ID > 0xFF {
+			header |= 0x20
+			id = binary.BigEndian.AppendUint16(nil, tlv.ID)
+		} else {
+			id = []byte{byte(tlv.ID)}
+		}
+		var length []byte
+		switch n := len(value); {
+		case n <= 0x07:
+			header |= byte(n)
+		case n <= 0xFF:
+			header |= 0x01 << 3
+			length = []byte{byte(n)}
+		case n <= 0xFFFF:
+			header |= 0x02 << 3
+			length = binary.BigEndian.AppendUint16(nil, uint16(n))
+		default:
+			header |= 0x03 << 3
+			length = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+		}
+		data = append(data, header)
+		data = append(data, id...)
+		data = append(data, length...)
+		data = append(data, value...)
+	}
+	return data, nil
+}
@@ -0,0 +1,329 @@
+package lwm2m
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LwM2M 1.0 的内容格式，文本与二进制使用 CoAP 的 0 与 42
+const (
+	FormatText   uint32 = 0
+	FormatOpaque uint32 = 42
+	FormatTLV    uint32 = 11542
+	FormatJSON   uint32 = 11543
+)
+
+// 资源的数据类型
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeFloat   = "float"
+	TypeBoolean = "boolean"
+	TypeOpaque  = "opaque" // 属性值为大写的十六进制字符串
+	TypeTime    = "time"   // Unix 时间戳,秒
+	TypeObjlnk  = "objlnk" // 对象链接,属性值为 对象:实例
+	typeDefault = TypeString
+)
+
+// ParsePath 解析 对象/实例/资源/资源实例 形式的路径，返回去掉首尾斜杠后的规范路径
+func ParsePath(path string) (string, []uint16, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", nil, fmt.Errorf("LwM2M 路径为空")
+	}
+	segments := strings.Split(path, "/")
+	if len(segments) > 4 {
+		return "", nil, fmt.Errorf("LwM2M 路径 %s 层级过多", path)
+	}
+	ids := make([]uint16, len(segments))
+	for i, segment := range segments {
+		id, err := strconv.ParseUint(segment, 10, 16)
+		if err != nil || id == math.MaxUint16 {
+			return "", nil, fmt.Errorf("LwM2M 路径 %s 格式错误", path)
+		}
+		ids[i] = uint16(id)
+	}
+	return path, ids, nil
+}
+
+// Decode 按内容格式解析读取或观察通知的响应，path 为请求的路径
+// types 为 资源路径 -> 数据类型，只返回其中的资源，资源路径 -> 值
+func Decode(path string, format uint32, payload []byte, types map[string]string) (map[string]interface{}, error) {
+	path, ids, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	switch format {
+	case FormatText, FormatOpaque:
+		typ, ok := types[path]
+		if !ok {
+			return values, nil
+		}
+		value, err := decodeText(typ, format, payload)
+		if err != nil {
+			return nil, fmt.Errorf("资源 %s: %w", path, err)
+		}
+		values[path] = value
+	case FormatTLV:
+		tlvs, err := ParseTLV(payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeTLV(tlvs, ids, types, values); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		if err := decodeJSON(payload, types, values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的 LwM2M 内容格式 %d", format)
+	}
+	return values, nil
+}
+
+// decodeTLV 按 TLV 项的类型确定资源路径，ids 为请求路径
+func decodeTLV(tlvs []TLV, ids []uint16, types map[string]string, values map[string]interface{}) error {
+	for _, tlv := range tlvs {
+		var path []uint16
+		switch {
+		case tlv.Type == TLVObjectInstance && len(ids) >= 1:
+			path = []uint16{ids[0], tlv.ID}
+		case (tlv.Type == TLVResource || tlv.Type == TLVMultipleResource) && len(ids) >= 2:
+			path = []uint16{ids[0], ids[1], tlv.ID}
+		case tlv.Type == TLVResourceInstance && len(ids) >= 3:
+			path = []uint16{ids[0], ids[1], ids[2], tlv.ID}
+		default:
+			return fmt.Errorf("%w: 类型 %d 与请求路径不符", ErrInvalidTLV, tlv.Type)
+		}
+		if tlv.Type == TLVObjectInstance || tlv.Type == TLVMultipleResource {
+			if err := decodeTLV(tlv.Children, path, types, values); err != nil {
+				return err
+			}
+			continue
+		}
+		name := formatPath(path)
+		typ, ok := types[name]
+		if !ok {
+			continue
+		}
+		value, err := decodeBinary(typ, tlv.Value)
+		if err != nil {
+			return fmt.Errorf("资源 %s: %w", name, err)
+		}
+		values[name] = value
+	}
+	return nil
+}
+
+// decodeJSON 解析 LwM2M 1.0 的 JSON 格式 {"bn":"/3303/0/","e":[{"n":"5700","v":21.5}]}
+func decodeJSON(payload []byte, types map[string]string, values map[string]interface{}) error {
+	var document struct {
+		BaseName string `json:"bn"`
+		Entries  []struct {
+			Name   string   `json:"n"`
+			Float  *float64 `json:"v"`
+			Bool   *bool    `json:"bv"`
+			String *string  `json:"sv"`
+			Objlnk *string  `json:"ov"`
+		} `json:"e"`
+	}
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return fmt.Errorf("LwM2M JSON 格式错误: %v", err)
+	}
+	for _, entry := range document.Entries {
+		name, _, err := ParsePath(document.BaseName + entry.Name)
+		if err != nil {
+			return err
+		}
+		typ, ok := types[name]
+		if !ok {
+			continue
+		}
+		var value interface{}
+		switch {
+		case entry.Float != nil:
+			value = *entry.Float
+			if typ == TypeInteger || typ == TypeTime {
+				value = int64(*entry.Float)
+			}
+		case entry.Bool != nil:
+			value = *entry.Bool
+		case entry.String != nil:
+			if value, err = decodeText(typ, FormatText, []byte(*entry.String)); err != nil {
+				return fmt.Errorf("资源 %s: %w", name, err)
+			}
+		case entry.Objlnk != nil:
+			value = *entry.Objlnk
+		default:
+			continue
+		}
+		values[name] = value
+	}
+	return nil
+}
+
+// decodeBinary 解析 TLV 中的资源值
+func decodeBinary(typ string, data []byte) (interface{}, error) {
+	switch typ {
+	case TypeInteger, TypeTime:
+		switch len(data) {
+		case 1:
+			return int64(int8(data[0])), nil
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(data))), nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(data))), nil
+		case 8:
+			return int64(binary.BigEndian.Uint64(data)), nil
+		}
+	case TypeFloat:
+		switch len(data) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+		}
+	case TypeBoolean:
+		if len(data) == 1 && data[0] <= 1 {
+			return data[0] == 1, nil
+		}
+	case TypeOpaque:
+		return fmt.Sprintf("%X", data), nil
+	case TypeObjlnk:
+		if len(data) == 4 {
+			return fmt.Sprintf("%d:%d", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])), nil
+		}
+	default:
+		return string(data), nil
+	}
+	return nil, fmt.Errorf("%s 类型的值长度 %d 错误", typ, len(data))
+}
+
+// decodeText 解析文本或二进制格式的资源值
+func decodeText(typ string, format uint32, data []byte) (interface{}, error) {
+	if typ == TypeOpaque || format == FormatOpaque {
+		return fmt.Sprintf("%X", data), nil
+	}
+	text := string(data)
+	switch typ {
+	case TypeInteger, TypeTime:
+		return strconv.ParseInt(text, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(text, 64)
+	case TypeBoolean:
+		switch text {
+		case "0":
+			return false, nil
+		case "1":
+			return true, nil
+		}
+		return nil, fmt.Errorf("布尔值 %q 错误", text)
+	}
+	return text, nil
+}
+
+// Encode 将写入资源的值编码为文本格式，opaque 类型的 []byte 或十六进制字符串编码为二进制格式
+func Encode(typ string, value interface{}) ([]byte, uint32, error) {
+	if typ == "" {
+		typ = typeDefault
+	}
+	switch typ {
+	case TypeOpaque:
+		switch v := value.(type) {
+		case []byte:
+			return v, FormatOpaque, nil
+		case string:
+			data, err := hex.DecodeString(v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("opaque 值 %q 不是十六进制字符串", v)
+			}
+			return data, FormatOpaque, nil
+		}
+		return nil, 0, fmt.Errorf("opaque 值不支持 %T 类型", value)
+	case TypeInteger, TypeTime:
+		v, err := toFloat(value)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []byte(strconv.FormatInt(int64(v), 10)), FormatText, nil
+	case TypeFloat:
+		v, err := toFloat(value)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []byte(strconv.FormatFloat(v, 'g', -1, 64)), FormatText, nil
+	case TypeBoolean:
+		var v bool
+		switch b := value.(type) {
+		case bool:
+			v = b
+		case string:
+			v = b == "1" || strings.EqualFold(b, "true")
+		default:
+			f, err := toFloat(value)
+			if err != nil {
+				return nil, 0, err
+			}
+			v = f != 0
+		}
+		if v {
+			return []byte("1"), FormatText, nil
+		}
+		return []byte("0"), FormatText, nil
+	}
+	return []byte(fmt.Sprint(value)), FormatText, nil
+}
+
+// toFloat 将数值或数字字符串转换为 float64
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("%v 不是数值", value)
+}
+
+// formatPath 将路径的各级 ID 转换为路径字符串
+func formatPath(ids []uint16) string {
+	segments := make([]string, len(ids))
+	for i, id := range ids {
+		segments[i] = strconv.Itoa(int(id))
+	}
+	return strings.Join(segments, "/")
+}
+
+// ParseLinks 解析注册与更新请求中 CoRE 链接格式的对象列表，返回 对象 或 对象/实例 路径
+func ParseLinks(payload string) []string {
+	var paths []string
+	for _, link := range strings.Split(payload, ",") {
+		target, _, _ := strings.Cut(strings.TrimSpace(link), ";")
+		target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+		if path, _, err := ParsePath(target); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,145 @@
+package lwm2m
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestTLVRoundTrip(t *testing.T) {
+	temperature := binary.BigEndian.AppendUint64(nil, math.Float64bits(21.5))
+	tlvs := []TLV{{Type: TLVObjectInstance, ID: 0, Children: []TLV{
+		{Type: TLVResource, ID: 5700, Value: temperature},
+		{Type: TLVResource, ID: 5701, Value: []byte("Cel")},
+		{Type: TLVMultipleResource, ID: 7, Children: []TLV{
+			{Type: TLVResourceInstance, ID: 0, Value: []byte{0x01}},
+			{Type: TLVResourceInstance, ID: 1, Value: bytes.Repeat([]byte{0xAA}, 300)},
+		}},
+	}}}
+	data, err := MarshalTLV(tlvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseTLV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tlvs) {
+		t.Fatalf("解码结果 %+v, 期望 %+v", got, tlvs)
+	}
+
+	// LwM2M 1.0 规范 6.4.3.1 的示例：资源 0 的值为 20 字节的字符串
+	data, _ = MarshalTLV([]TLV{{Type: TLVResource, ID: 0, Value: []byte("Open Mobile Alliance")}})
+	if !bytes.HasPrefix(data, []byte{0xC8, 0x00, 0x14}) {
+		t.Fatalf("TLV 编码 % X", data)
+	}
+	if _, err := ParseTLV(data[:10]); !errors.Is(err, ErrInvalidTLV) {
+		t.Fatalf("截断的 TLV 应返回 ErrInvalidTLV: %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	types := map[string]string{
+		"3303/0/5700": TypeFloat,
+		"3303/0/5701": TypeString,
+		"3303/0/5605": TypeInteger,
+		"3311/0/5850": TypeBoolean,
+	}
+	instance, _ := MarshalTLV([]TLV{{Type: TLVObjectInstance, ID: 0, Children: []TLV{
+		{Type: TLVResource, ID: 5700, Value: binary.BigEndian.AppendUint32(nil, math.Float32bits(21.5))},
+		{Type: TLVResource, ID: 5701, Value: []byte("Cel")},
+		{Type: TLVResource, ID: 5605, Value: []byte{0xFF, 0x38}},
+		{Type: TLVResource, ID: 5750, Value: []byte("未映射")},
+	}}})
+	resources, _ := MarshalTLV([]TLV{{Type: TLVResource, ID: 5700, Value: binary.BigEndian.AppendUint64(nil, math.Float64bits(-3.25))}})
+
+	tests := []struct {
+		name    string
+		path    string
+		format  uint32
+		payload []byte
+		want    map[string]interface{}
+	}{
+		{"TLV 对象", "/3303", FormatTLV, instance, map[string]interface{}{"3303/0/5700": 21.5, "3303/0/5701": "Cel", "3303/0/5605": int64(-200)}},
+		{"TLV 实例", "3303/0", FormatTLV, resources, map[string]interface{}{"3303/0/5700": -3.25}},
+		{"JSON", "3303/0", FormatJSON, []byte(`{"bn":"/3303/0/","e":[{"n":"5700","v":22.5},{"n":"5701","sv":"Cel"},{"n":"5605","v":7},{"n":"5750","sv":"x"}]}`),
+			map[string]interface{}{"3303/0/5700": 22.5, "3303/0/5701": "Cel", "3303/0/5605": int64(7)}},
+		{"文本", "3303/0/5605", FormatText, []byte("42"), map[string]interface{}{"3303/0/5605": int64(42)}},
+		{"文本布尔", "/3311/0/5850", FormatText, []byte("1"), map[string]interface{}{"3311/0/5850": true}},
+		{"二进制", "3303/0/5701", FormatOpaque, []byte{0x01, 0xAB}, map[string]interface{}{"3303/0/5701": "01AB"}},
+		{"未映射", "3303/0/5750", FormatText, []byte("x"), map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		got, err := Decode(tt.path, tt.format, tt.payload, types)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: 解析结果 %v, 期望 %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Decode("3303/0/5605", FormatText, []byte("abc"), types); err == nil {
+		t.Fatal("整数资源的值错误时应返回错误")
+	}
+	if _, err := Decode("3303/0", FormatTLV, []byte{0xC1}, types); !errors.Is(err, ErrInvalidTLV) {
+		t.Fatalf("截断的 TLV 应返回 ErrInvalidTLV: %v", err)
+	}
+	if _, err := Decode("3303/0", 50, nil, types); err == nil {
+		t.Fatal("不支持的内容格式应返回错误")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		typ    string
+		value  interface{}
+		data   string
+		format uint32
+	}{
+		{"", "on", "on", FormatText},
+		{TypeInteger, 12.0, "12", FormatText},
+		{TypeInteger, "-5", "-5", FormatText},
+		{TypeFloat, 21.5, "21.5", FormatText},
+		{TypeBoolean, true, "1", FormatText},
+		{TypeBoolean, "false", "0", FormatText},
+		{TypeBoolean, 0, "0", FormatText},
+		{TypeOpaque, "01ab", "\x01\xab", FormatOpaque},
+		{TypeOpaque, []byte{0x02}, "\x02", FormatOpaque},
+	}
+	for _, tt := range tests {
+		data, format, err := Encode(tt.typ, tt.value)
+		if err != nil {
+			t.Fatalf("%s %v: %v", tt.typ, tt.value, err)
+		}
+		if string(data) != tt.data || format != tt.format {
+			t.Fatalf("%s %v 编码为 %q 格式 %d, 期望 %q 格式 %d", tt.typ, tt.value, data, format, tt.data, tt.format)
+		}
+	}
+	if _, _, err := Encode(TypeOpaque, "xyz"); err == nil {
+		t.Fatal("opaque 值不是十六进制时应返回错误")
+	}
+	if _, _, err := Encode(TypeInteger, "abc"); err == nil {
+		t.Fatal("整数值不是数字时应返回错误")
+	}
+}
+
+func TestParsePathAndLinks(t *testing.T) {
+	path, ids, err := ParsePath("/3303/0/5700/")
+	if err != nil || path != "3303/0/5700" || !reflect.DeepEqual(ids, []uint16{3303, 0, 5700}) {
+		t.Fatalf("解析路径结果 %s %v %v", path, ids, err)
+	}
+	for _, invalid := range []string{"", "/", "a/0", "1/2/3/4/5", "65535"} {
+		if _, _, err := ParsePath(invalid); err == nil {
+			t.Fatalf("路径 %q 应返回错误", invalid)
+		}
+	}
+
+	links := ParseLinks(`</>;rt="oma.lwm2m";ct=11543, </1/0>,</3/0>,</3303>;ver=1.1,<bad>`)
+	if want := []string{"1/0", "3/0", "3303"}; !reflect.DeepEqual(links, want) {
+		t.Fatalf("解析链接结果 %v, 期望 %v", links, want)
+	}
+}
@@ -0,0 +1,260 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/network/coap"
+	"github.com/sagoo-cloud/iotgateway/network/lwm2m"
+)
+
+func TestLwM2MServer(t *testing.T) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	// 监听器需要在服务器启动前注册
+	replies := make(chan event.Event, 8)
+	record := event.ListenerFunc(func(e event.Event) error {
+		if deviceKey := e.Data()["DeviceKey"]; deviceKey == "NB-001" || deviceKey == "NB-002" {
+			replies <- e
+		}
+		return nil
+	})
+	event.On(consts.PushAttributeDataToMQTT, record)
+
+	server := NewLwM2MServer(WithCoAPConfig(conf.CoAPConfig{AckTimeout: 200 * time.Millisecond}), WithLwM2MConfig(conf.LwM2MConfig{
+		Resources: []conf.LwM2MResourceConfig{
+			{Name: "temperature", Path: "3303/0/5700", Type: lwm2m.TypeFloat},
+			{Name: "unit", Path: "3303/0/5701"},
+			{Name: "switch", Path: "/3311/0/5850", Type: lwm2m.TypeBoolean},
+		},
+		Observe: []string{"3303/0", "3311/0/5850"},
+		Services: []conf.LwM2MServiceConfig{
+			{Name: "lwm2mReboot", Path: "3/0/4"},
+			{Name: "lwm2mReadTemperature", Path: "3303/0", Operation: "read"},
+		},
+	})).(*LwM2MServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr.String())
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := &coapClient{t: t, conn: conn}
+
+	// 等待服务器开始监听
+	for i := 0; ; i++ {
+		client.send(&coap.Message{Type: coap.Confirmable, MessageID: 1})
+		if _, err := client.receive(50 * time.Millisecond); err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal("LwM2M 服务器未启动")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	request := func(code coap.Code, path string, payload string, queries ...string) *coap.Message {
+		t.Helper()
+		req := &coap.Message{Type: coap.Confirmable, Code: code, MessageID: uint16(time.Now().UnixNano()), Token: []byte{0x01}, Payload: []byte(payload)}
+		req.SetPath(path)
+		for _, query := range queries {
+			req.AddQuery(query)
+		}
+		return client.roundTrip(req)
+	}
+	expectRequest := func(code coap.Code, path string) *coap.Message {
+		t.Helper()
+		req, err := client.receive(3 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Code != code || req.Path() != path {
+			t.Fatalf("期望 %s %s, 收到 %s %s", code, path, req.Code, req.Path())
+		}
+		return req
+	}
+	respond := func(req *coap.Message, code coap.Code, format uint32, payload []byte) {
+		resp := &coap.Message{Type: coap.Acknowledgement, Code: code, MessageID: req.MessageID, Token: req.Token, Payload: payload}
+		if payload != nil {
+			resp.SetUint(coap.ContentFormat, format)
+		}
+		client.send(resp)
+	}
+	expectReply := func(name string, want g.Map) event.Event {
+		t.Helper()
+		select {
+		case e := <-replies:
+			data := e.Data()
+			if e.Name() != name || !reflect.DeepEqual(data["PropertieDataList"], want) {
+				t.Fatalf("期望 %s %v, 收到 %s %v", name, want, e.Name(), data)
+			}
+			return e
+		case <-time.After(3 * time.Second):
+			t.Fatalf("没有 %s 事件", name)
+		}
+		return nil
+	}
+
+	if resp := request(coap.GET, "rd", ""); resp.Code != coap.MethodNotAllowed {
+		t.Fatalf("GET /rd 应返回 4.05: %s", resp.Code)
+	}
+	if resp := request(coap.POST, "up/NB-001", ""); resp.Code != coap.NotFound {
+		t.Fatalf("注册接口以外的路径应返回 4.04: %s", resp.Code)
+	}
+	if resp := request(coap.POST, "rd", "</3/0>", "lt=60"); resp.Code != coap.BadRequest {
+		t.Fatalf("缺少终端名称应返回 4.00: %s", resp.Code)
+	}
+
+	// 注册后观察已注册对象中的路径，3311 未注册不观察
+	resp := request(coap.POST, "rd", `</>;rt="oma.lwm2m",</1/0>,</3/0>,</3303/0>`, "ep=NB-001", "lt=60", "lwm2m=1.0", "b=U")
+	if resp.Code != coap.Created || resp.LocationPath() != "rd/1" {
+		t.Fatalf("注册应返回 2.01 rd/1: %s %s", resp.Code, resp.LocationPath())
+	}
+	observe := expectRequest(coap.GET, "3303/0")
+	if value, ok := observe.Uint(coap.Observe); !ok || value != 0 {
+		t.Fatal("观察请求缺少 Observe 选项")
+	}
+	temperature := binary.BigEndian.AppendUint32(nil, math.Float32bits(21.5))
+	tlv, _ := lwm2m.MarshalTLV([]lwm2m.TLV{
+		{Type: lwm2m.TLVResource, ID: 5700, Value: temperature},
+		{Type: lwm2m.TLVResource, ID: 5701, Value: []byte("Cel")},
+	})
+	first := &coap.Message{Type: coap.Acknowledgement, Code: coap.Content, MessageID: observe.MessageID, Token: observe.Token, Payload: tlv}
+	first.SetUint(coap.Observe, 1)
+	first.SetUint(coap.ContentFormat, lwm2m.FormatTLV)
+	client.send(first)
+	expectReply(consts.PushAttributeDataToMQTT, g.Map{"temperature": 21.5, "unit": "Cel"})
+
+	notify := &coap.Message{Type: coap.Confirmable, Code: coap.Content, MessageID: 100, Token: observe.Token,
+		Payload: []byte(`{"bn":"/3303/0/","e":[{"n":"5700","v":22}]}`)}
+	notify.SetUint(coap.Observe, 2)
+	notify.SetUint(coap.ContentFormat, lwm2m.FormatJSON)
+	if ack := client.roundTrip(notify); ack.Type != coap.Acknowledgement || ack.MessageID != 100 {
+		t.Fatalf("CON 通知应被确认: %+v", ack)
+	}
+	expectReply(consts.PushAttributeDataToMQTT, g.Map{"temperature": 22.0})
+
+	// 属性设置与服务调用等待终端响应，在单独的协程中执行
+	type result struct {
+		reply map[string]interface{}
+		err   error
+	}
+	async := func(call func() (map[string]interface{}, error)) <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			reply, err := call()
+			done <- result{reply, err}
+		}()
+		return done
+	}
+	expectResult := func(done <-chan result) result {
+		t.Helper()
+		select {
+		case r := <-done:
+			return r
+		case <-time.After(3 * time.Second):
+			t.Fatal("没有返回")
+		}
+		return result{}
+	}
+	device := server.LookupDevice("NB-001")
+
+	// 属性设置转换为资源写入
+	done := async(func() (map[string]interface{}, error) {
+		return server.SetProperties(ctx, nil, device, map[string]interface{}{"switch": true, "unknown": 1})
+	})
+	write := expectRequest(coap.PUT, "3311/0/5850")
+	if format, _ := write.ContentFormat(); string(write.Payload) != "1" || format != lwm2m.FormatText {
+		t.Fatalf("写入负载 %q 格式 %d", write.Payload, format)
+	}
+	respond(write, coap.Changed, 0, nil)
+	if r := expectResult(done); r.err != nil || !reflect.DeepEqual(r.reply, map[string]interface{}{"switch": true}) {
+		t.Fatalf("属性设置返回 %v %v", r.reply, r.err)
+	}
+
+	// 服务调用转换为执行与读取
+	if services := server.Services(); !reflect.DeepEqual(services, []string{"lwm2mReboot", "lwm2mReadTemperature"}) {
+		t.Fatalf("服务标识 %v", services)
+	}
+	done = async(func() (map[string]interface{}, error) {
+		return server.CallService(ctx, nil, device, "lwm2mReboot", map[string]interface{}{})
+	})
+	respond(expectRequest(coap.POST, "3/0/4"), coap.Changed, 0, nil)
+	if r := expectResult(done); r.err != nil || len(r.reply) != 0 {
+		t.Fatalf("执行返回 %v %v", r.reply, r.err)
+	}
+
+	done = async(func() (map[string]interface{}, error) {
+		return server.CallService(ctx, nil, device, "lwm2mReboot", map[string]interface{}{"args": "0='now'"})
+	})
+	execute := expectRequest(coap.POST, "3/0/4")
+	if string(execute.Payload) != "0='now'" {
+		t.Fatalf("执行参数 %q", execute.Payload)
+	}
+	respond(execute, coap.MethodNotAllowed, 0, nil)
+	if r := expectResult(done); r.err == nil {
+		t.Fatal("执行失败应返回错误")
+	}
+
+	done = async(func() (map[string]interface{}, error) {
+		return server.CallService(ctx, nil, device, "lwm2mReadTemperature", map[string]interface{}{})
+	})
+	respond(expectRequest(coap.GET, "3303/0"), coap.Content, lwm2m.FormatJSON, []byte(`{"bn":"/3303/0/","e":[{"n":"5700","v":23.5}]}`))
+	if r := expectResult(done); r.err != nil || !reflect.DeepEqual(r.reply, map[string]interface{}{"temperature": 23.5}) {
+		t.Fatalf("读取返回 %v %v", r.reply, r.err)
+	}
+
+	if _, err := server.CallService(ctx, nil, device, "lwm2mUnknown", nil); err == nil {
+		t.Fatal("未配置的服务应返回错误")
+	}
+
+	// 更新与注销
+	if resp := request(coap.POST, "rd/1", "", "lt=120"); resp.Code != coap.Changed {
+		t.Fatalf("更新应返回 2.04: %s", resp.Code)
+	}
+	if resp := request(coap.POST, "rd/9", ""); resp.Code != coap.NotFound {
+		t.Fatalf("更新不存在的注册应返回 4.04: %s", resp.Code)
+	}
+	if resp := request(coap.DELETE, "rd/1", ""); resp.Code != coap.Deleted {
+		t.Fatalf("注销应返回 2.02: %s", resp.Code)
+	}
+	if server.LookupDevice("NB-001") != nil {
+		t.Fatal("注销后设备应离线")
+	}
+	if cancelReq := expectRequest(coap.GET, "3303/0"); !reflect.DeepEqual(cancelReq.Token, observe.Token) {
+		t.Fatal("注销后应取消观察")
+	} else if value, _ := cancelReq.Uint(coap.Observe); value != 1 {
+		t.Fatalf("取消观察的 Observe 为 %d", value)
+	}
+
+	// 超过生存期未更新的注册过期
+	if resp := request(coap.POST, "rd", "</1/0>", "ep=NB-002", "lt=1"); resp.Code != coap.Created {
+		t.Fatalf("注册应返回 2.01: %s", resp.Code)
+	}
+	if server.LookupDevice("NB-002") == nil {
+		t.Fatal("注册后设备应在线")
+	}
+	for i := 0; server.LookupDevice("NB-002") != nil; i++ {
+		if i == 40 {
+			t.Fatal("注册过期后设备应离线")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,368 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gogf/gf/v2/os/glog"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+const (
+	defaultBrokerUpTopic   = "device/{deviceKey}/up"
+	defaultBrokerDownTopic = "device/{deviceKey}/down"
+	topicDeviceKey         = "{deviceKey}"
+)
+
+// MQTTBroker 结构体表示网关内置的 MQTT Broker，用于接入现场的 MQTT 设备
+// 设备连接与断开驱动设备上下线，发布到上报主题的消息交给协议处理器，其他消息按普通 Broker 转发
+type MQTTBroker struct {
+	*BaseServer
+	server   *mqtt.Server
+	mu       sync.Mutex
+	sessions map[string]*brokerSession // 客户端标识 -> 会话
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// brokerSession 表示一个已连接的 MQTT 客户端，通过主题上报的子设备与客户端同时上下线
+type brokerSession struct {
+	client    *mqtt.Client
+	deviceKey string                   // 客户端的设备标识
+	bound     bool                     // 设备标识是否由账号绑定
+	devices   map[string]*model.Device // 设备标识 -> 设备
+}
+
+// NewMQTTBroker 创建一个新的内置 MQTT Broker 实例
+func NewMQTTBroker(options ...Option) NetworkServer {
+	s := &MQTTBroker{
+		BaseServer: NewBaseServer(options...),
+		sessions:   make(map[string]*brokerSession),
+		done:       make(chan struct{}),
+	}
+	return s
+}
+
+// Start 启动内置 MQTT Broker，阻塞直到停止
+func (s *MQTTBroker) Start(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":1883"
+	}
+	s.server = mqtt.New(&mqtt.Options{
+		InlineClient: true, // 用于向设备下发数据
+		Logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	if err := s.server.AddHook(&brokerHook{broker: s}, nil); err != nil {
+		return fmt.Errorf("添加 MQTT Broker 钩子失败: %v", err)
+	}
+
+	config := listeners.Config{ID: "device", Address: addr}
+	if s.tlsConfig.Enable {
+		tlsConfig, err := NewTLSConfig(s.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("TLS 配置错误: %v", err)
+		}
+		config.TLSConfig = tlsConfig
+	}
+	if err := s.server.AddListener(listeners.NewTCP(config)); err != nil {
+		return fmt.Errorf("MQTT Broker 监听失败: %v", err)
+	}
+	if err := s.server.Serve(); err != nil {
+		return fmt.Errorf("MQTT Broker 监听失败: %v", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return s.Stop()
+	case <-s.done:
+		return nil
+	}
+}
+
+// Stop 停止内置 MQTT Broker，已连接的设备全部离线
+func (s *MQTTBroker) Stop() error {
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.done)
+		if s.server != nil {
+			err = s.server.Close()
+		}
+	})
+	return err
+}
+
+// SendData 将数据发布到设备的下发主题
+func (s *MQTTBroker) SendData(device *model.Device, data interface{}, param ...string) error {
+	deviceKey := device.DeviceKey
+	if deviceKey == "" {
+		deviceKey = device.ClientID
+	}
+	if deviceKey == "" {
+		return errors.New("MQTT 设备标识为空")
+	}
+	if s.server == nil {
+		return errors.New("MQTT Broker 未启动")
+	}
+
+	var encodedData []byte
+	var err error
+
+	if s.protocolHandler != nil {
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else {
+		encodedData = []byte(fmt.Sprintf("%v", data))
+	}
+
+	topic := strings.ReplaceAll(s.downTopic(), topicDeviceKey, deviceKey)
+	return s.server.Publish(topic, encodedData, false, s.mqttBrokerConfig.QoS)
+}
+
+// authenticate 校验客户端账号，未配置账号时允许匿名连接
+func (s *MQTTBroker) authenticate(clientID string, username, password []byte) bool {
+	if len(s.mqttBrokerConfig.Users) == 0 {
+		return true
+	}
+	user := s.findUser(username)
+	if user == nil || subtle.ConstantTimeCompare([]byte(user.Password), password) != 1 {
+		return false
+	}
+	return user.ClientID == "" || user.ClientID == clientID
+}
+
+// findUser 按用户名查找账号
+func (s *MQTTBroker) findUser(username []byte) *conf.MQTTUserConfig {
+	for i := range s.mqttBrokerConfig.Users {
+		if s.mqttBrokerConfig.Users[i].Username == string(username) {
+			return &s.mqttBrokerConfig.Users[i]
+		}
+	}
+	return nil
+}
+
+// handleSessionEstablished 客户端连接成功，设备上线
+func (s *MQTTBroker) handleSessionEstablished(cl *mqtt.Client) {
+	session := &brokerSession{client: cl, deviceKey: cl.ID, devices: make(map[string]*model.Device)}
+	if user := s.findUser(cl.Properties.Username); user != nil && user.DeviceKey != "" {
+		session.deviceKey, session.bound = user.DeviceKey, true
+	}
+
+	s.mu.Lock()
+	old := s.sessions[cl.ID]
+	s.sessions[cl.ID] = session
+	s.mu.Unlock()
+	if old != nil {
+		s.disconnectSession(old) // 相同客户端标识重新连接，旧连接被接管
+	}
+	s.sessionDevice(session, session.deviceKey)
+}
+
+// handleClientDisconnect 客户端断开，客户端及其子设备全部离线
+func (s *MQTTBroker) handleClientDisconnect(cl *mqtt.Client) {
+	s.mu.Lock()
+	session := s.sessions[cl.ID]
+	if session == nil || session.client != cl {
+		s.mu.Unlock()
+		return // 已被新的连接接管
+	}
+	delete(s.sessions, cl.ID)
+	s.mu.Unlock()
+	s.disconnectSession(session)
+}
+
+// disconnectSession 将会话的全部设备置为离线
+func (s *MQTTBroker) disconnectSession(session *brokerSession) {
+	s.mu.Lock()
+	devices := make([]*model.Device, 0, len(session.devices))
+	for _, device := range session.devices {
+		devices = append(devices, device)
+	}
+	session.devices = make(map[string]*model.Device)
+	s.mu.Unlock()
+	for _, device := range devices {
+		s.handleDisconnect(device)
+	}
+}
+
+// sessionDevice 获取会话中指定设备标识的设备，不存在时上线
+func (s *MQTTBroker) sessionDevice(session *brokerSession, deviceKey string) *model.Device {
+	s.mu.Lock()
+	device := session.devices[deviceKey]
+	s.mu.Unlock()
+	if device != nil {
+		return device
+	}
+
+	clientID := session.client.ID
+	if deviceKey != session.deviceKey {
+		clientID += "/" + deviceKey // 通过主题上报的子设备
+	}
+	device = s.handleConnect(clientID, session.client.Net.Conn)
+	s.bindDevice(device, deviceKey)
+	s.mu.Lock()
+	session.devices[deviceKey] = device
+	s.mu.Unlock()
+	return device
+}
+
+// handlePublish 处理设备发布的消息，上报主题的消息交给协议处理器
+func (s *MQTTBroker) handlePublish(cl *mqtt.Client, pk packets.Packet) {
+	deviceKey, ok := matchTopic(s.upTopic(), pk.TopicName)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	session := s.sessions[cl.ID]
+	s.mu.Unlock()
+	if session == nil {
+		return
+	}
+	if deviceKey == "" {
+		deviceKey = session.deviceKey
+	}
+	device := s.sessionDevice(session, deviceKey)
+
+	resData, err := s.handleReceiveData(device, pk.Payload)
+	if err != nil {
+		glog.Debugf(context.Background(), "处理 MQTT 设备 %s 数据错误: %v\n", deviceKey, err)
+		return
+	}
+	if resData != nil {
+		if err := s.SendData(device, resData); err != nil {
+			glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
+		}
+	}
+}
+
+// checkACL 绑定了设备标识的客户端只能收发自身设备标识的上报与下发主题
+// 默认拒绝：主题必须与上报或下发主题完全匹配，且不能含通配符，避免通过 # 或 + 订阅到其他设备的主题
+func (s *MQTTBroker) checkACL(cl *mqtt.Client, topic string) bool {
+	if cl.Net.Inline {
+		return true
+	}
+	s.mu.Lock()
+	session := s.sessions[cl.ID]
+	s.mu.Unlock()
+	if session == nil || !session.bound {
+		return true
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return false
+	}
+	for _, pattern := range []string{s.upTopic(), s.downTopic()} {
+		if deviceKey, ok := matchTopic(pattern, topic); ok && (deviceKey == "" || deviceKey == session.deviceKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// upTopic 获取设备上报主题
+func (s *MQTTBroker) upTopic() string {
+	if s.mqttBrokerConfig.UpTopic == "" {
+		return defaultBrokerUpTopic
+	}
+	return s.mqttBrokerConfig.UpTopic
+}
+
+// downTopic 获取设备下发主题
+func (s *MQTTBroker) downTopic() string {
+	if s.mqttBrokerConfig.DownTopic == "" {
+		return defaultBrokerDownTopic
+	}
+	return s.mqttBrokerConfig.DownTopic
+}
+
+// matchTopic 判断主题是否匹配模式，返回 {deviceKey} 占位符对应的设备标识
+// 模式中的 + 匹配一级，# 匹配剩余所有级
+func matchTopic(pattern, topic string) (string, bool) {
+	patterns := strings.Split(pattern, "/")
+	levels := strings.Split(topic, "/")
+	var deviceKey string
+	for i, p := range patterns {
+		if p == "#" {
+			return deviceKey, true
+		}
+		if i >= len(levels) {
+			return "", false
+		}
+		switch p {
+		case "+":
+		case topicDeviceKey:
+			if levels[i] == "" || strings.ContainsAny(levels[i], "+#") {
+				return "", false
+			}
+			deviceKey = levels[i]
+		default:
+			if p != levels[i] {
+				return "", false
+			}
+		}
+	}
+	if len(patterns) != len(levels) {
+		return "", false
+	}
+	return deviceKey, true
+}
+
+// brokerHook 将 MQTT Broker 的连接、断开、发布与权限事件交给 MQTTBroker 处理
+type brokerHook struct {
+	mqtt.HookBase
+	broker *MQTTBroker
+}
+
+// ID 实现 mqtt.Hook 接口
+func (h *brokerHook) ID() string {
+	return "iotgateway"
+}
+
+// Provides 实现 mqtt.Hook 接口
+func (h *brokerHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+		mqtt.OnSessionEstablished,
+		mqtt.OnDisconnect,
+		mqtt.OnPublished,
+	}, []byte{b})
+}
+
+// OnConnectAuthenticate 校验客户端账号
+func (h *brokerHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	return h.broker.authenticate(cl.ID, pk.Connect.Username, pk.Connect.Password)
+}
+
+// OnACLCheck 校验客户端的主题权限
+func (h *brokerHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	return h.broker.checkACL(cl, topic)
+}
+
+// OnSessionEstablished 客户端连接成功
+func (h *brokerHook) OnSessionEstablished(cl *mqtt.Client, pk packets.Packet) {
+	h.broker.handleSessionEstablished(cl)
+}
+
+// OnDisconnect 客户端断开
+func (h *brokerHook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	h.broker.handleClientDisconnect(cl)
+}
+
+// OnPublished 消息已通过权限校验并转发给订阅者
+func (h *brokerHook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	if !cl.Net.Inline {
+		h.broker.handlePublish(cl, pk)
+	}
+}
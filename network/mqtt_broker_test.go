@@ -0,0 +1,145 @@
+package network
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/vars"
+)
+
+func TestMQTTBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	protocol := &echoProtocol{}
+	broker := NewMQTTBroker(
+		WithProtocolHandler(protocol),
+		WithMQTTBrokerConfig(conf.MQTTBrokerConfig{
+			QoS: 1,
+			Users: []conf.MQTTUserConfig{
+				{Username: "meter", Password: "secret", DeviceKey: "meter-001"},
+				{Username: "gw", Password: "secret"},
+			},
+		}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- broker.Start(ctx, addr) }()
+	server := broker.(*MQTTBroker)
+
+	connect := func(clientID, username, password string) (paho.Client, error) {
+		opts := paho.NewClientOptions().AddBroker("tcp://" + addr).SetClientID(clientID).
+			SetUsername(username).SetPassword(password).SetAutoReconnect(false)
+		client := paho.NewClient(opts)
+		var err error
+		for i := 0; i < 50; i++ {
+			token := client.Connect()
+			token.Wait()
+			if err = token.Error(); err == nil || !strings.Contains(err.Error(), "connection refused") {
+				break // 连接成功或被 Broker 拒绝
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return client, err
+	}
+
+	if _, err := connect("meter-x", "meter", "wrong"); err == nil {
+		t.Fatal("密码错误时应拒绝连接")
+	}
+
+	meter, err := connect("c1", "meter", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 会话建立钩子可能在客户端收到 CONNACK 之后才执行
+	waitFor(t, func() bool { return server.LookupDevice("meter-001") != nil })
+	if _, err := vars.GetDevice("meter-001"); err != nil {
+		t.Fatalf("账号绑定的设备应在连接后上线: %v", err)
+	}
+
+	downlink := make(chan string, 4)
+	meter.Subscribe("device/meter-001/down", 1, func(_ paho.Client, msg paho.Message) {
+		downlink <- string(msg.Payload())
+	}).Wait()
+	meter.Publish("device/meter-001/up", 1, false, "v=1").Wait()
+	select {
+	case data := <-downlink:
+		if data != "ack:v=1" {
+			t.Fatalf("Decode 的回复不正确: %q", data)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("未收到 Decode 的回复")
+	}
+
+	// 绑定了设备标识的客户端不能用通配符订阅其他设备的主题，也不能收发上报与下发以外的主题
+	for _, topic := range []string{"#", "device/+/down", "+/+/down", "device/meter-002/down", "other/topic"} {
+		token := meter.Subscribe(topic, 1, func(paho.Client, paho.Message) {})
+		if token.Wait(); token.Error() != nil {
+			t.Fatal(token.Error())
+		}
+		if code := token.(*paho.SubscribeToken).Result()[topic]; code < 0x80 {
+			t.Fatalf("订阅 %s 应被拒绝，返回码 %#x", topic, code)
+		}
+	}
+
+	// 绑定了设备标识的客户端不能冒充其他设备上报
+	meter.Publish("device/meter-002/up", 1, false, "fake").Wait()
+	time.Sleep(100 * time.Millisecond)
+	if got := protocol.received(); len(got) != 1 {
+		t.Fatalf("冒充其他设备的消息不应交给协议处理器: %q", got)
+	}
+
+	// 未绑定设备标识的客户端可以通过主题上报子设备数据
+	gw, err := connect("gw-01", "gw", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.Publish("device/sub-001/up", 1, false, "sub").Wait()
+	waitFor(t, func() bool { return len(protocol.received()) == 2 })
+	if _, err := vars.GetDevice("sub-001"); err != nil || server.LookupDevice("sub-001") == nil {
+		t.Fatalf("子设备应上线: %v", err)
+	}
+
+	// 断开连接后客户端及其子设备离线
+	gw.Disconnect(100)
+	waitFor(t, func() bool { return server.getDevice("gw-01/sub-001") == nil && server.getDevice("gw-01") == nil })
+	meter.Disconnect(100)
+	waitFor(t, func() bool { return server.getDevice("c1") == nil })
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("MQTT Broker 未停止")
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, topic, deviceKey string
+		ok                        bool
+	}{
+		{"device/{deviceKey}/up", "device/d1/up", "d1", true},
+		{"device/{deviceKey}/up", "device/d1/down", "", false},
+		{"device/{deviceKey}/up", "device/d1/up/x", "", false},
+		{"+/{deviceKey}/#", "sensor/d2/a/b", "d2", true},
+		{"data/#", "data/x", "", true},
+		{"data/+", "data", "", false},
+	}
+	for _, c := range cases {
+		deviceKey, ok := matchTopic(c.pattern, c.topic)
+		if deviceKey != c.deviceKey || ok != c.ok {
+			t.Errorf("matchTopic(%q, %q) = %q, %v", c.pattern, c.topic, deviceKey, ok)
+		}
+	}
+}
@@ -0,0 +1,730 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/mqttsn"
+)
+
+const (
+	mqttsnMaxDatagramSize = 64 * 1024
+	mqttsnCheckInterval   = time.Second
+	mqttsnKeepAliveFactor = 1.5 // 超过保活时间或休眠时间的 1.5 倍没有收到报文时认为客户端已丢失
+)
+
+// MQTT-SN 客户端状态
+const (
+	mqttsnConnecting = iota // 等待遗嘱主题与遗嘱消息
+	mqttsnActive
+	mqttsnAsleep
+)
+
+// MQTTSNGateway 结构体表示 MQTT-SN(v1.2)网关，基于 UDP 接入传感器等受限设备
+// 客户端标识作为设备标识，客户端发布的消息交给协议处理器；未设置协议处理器时，JSON 对象作为属性、其他消息以主题最后一级为属性名，
+// 通过 PushAttributeDataToMQTT 事件以子设备上报。休眠客户端的下发消息缓存到客户端唤醒时发送
+type MQTTSNGateway struct {
+	*BaseServer
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	clients   map[string]*mqttsnClient // 客户端标识 -> 客户端
+	addrs     map[string]*mqttsnClient // 地址 -> 客户端
+	anonymous map[string]time.Time     // 未连接、以 QoS -1 发布的地址 -> 最近一次发布的时间
+}
+
+// mqttsnClient 表示一个 MQTT-SN 客户端的会话
+type mqttsnClient struct {
+	id            string
+	addr          *net.UDPAddr
+	device        *model.Device
+	state         int
+	keepAlive     time.Duration // 保活时间，休眠时为休眠时间
+	lastSeen      time.Time
+	topics        map[string]uint16 // 主题名称 -> 注册的主题 ID
+	topicNames    map[uint16]string // 注册的主题 ID -> 主题名称
+	subscriptions map[string]bool   // 订阅的主题过滤器
+	queue         []mqttsnMessage   // 休眠期间缓存的下发消息
+	inflight      map[uint16]chan struct{}
+	lastTopicID   uint16
+	lastMessageID uint16
+}
+
+// mqttsnMessage 表示一条下发消息
+type mqttsnMessage struct {
+	topic   string
+	payload []byte
+}
+
+// NewMQTTSNGateway 创建一个新的 MQTT-SN 网关实例
+func NewMQTTSNGateway(options ...Option) NetworkServer {
+	s := &MQTTSNGateway{
+		BaseServer: NewBaseServer(options...),
+		clients:    make(map[string]*mqttsnClient),
+		addrs:      make(map[string]*mqttsnClient),
+		anonymous:  make(map[string]time.Time),
+	}
+	return s
+}
+
+// Start 启动 MQTT-SN 网关
+func (s *MQTTSNGateway) Start(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":1884"
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("解析 UDP 地址失败: %v", err)
+	}
+	s.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("MQTT-SN 监听失败: %v", err)
+	}
+
+	go s.checkClients(ctx)
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	buffer := make([]byte, mqttsnMaxDatagramSize)
+	for {
+		n, remoteAddr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil // 正常关闭
+			}
+			glog.Debugf(context.Background(), "读取 MQTT-SN 数据失败: %v", err)
+			continue
+		}
+		packet, err := mqttsn.Unmarshal(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			glog.Debugf(context.Background(), "解析 MQTT-SN 报文失败 %s: %v\n", remoteAddr, err)
+			continue
+		}
+		s.handlePacket(remoteAddr, packet)
+	}
+}
+
+// Stop 停止 MQTT-SN 网关
+func (s *MQTTSNGateway) Stop() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// SendData 将数据发布到客户端订阅的下发主题，客户端休眠时缓存到唤醒后发送
+func (s *MQTTSNGateway) SendData(device *model.Device, data interface{}, param ...string) error {
+	var encodedData []byte
+	var err error
+
+	if s.protocolHandler != nil {
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else if encodedData, err = mqttsnPayload(data); err != nil {
+		return fmt.Errorf("编码数据失败: %w", err)
+	}
+
+	s.mu.Lock()
+	client := s.clients[device.ClientID]
+	if client == nil || client.device != device {
+		s.mu.Unlock()
+		return ErrDeviceOffline
+	}
+	topic := strings.ReplaceAll(s.downTopic(), topicDeviceKey, device.DeviceKey)
+	if !client.subscribed(topic) {
+		s.mu.Unlock()
+		return fmt.Errorf("MQTT-SN 客户端 %s 未订阅下发主题 %s", client.id, topic)
+	}
+	if client.state != mqttsnActive {
+		client.enqueue(mqttsnMessage{topic: topic, payload: encodedData}, s.maxQueueSize())
+		s.mu.Unlock()
+		return nil
+	}
+	packets, acked := s.publishPackets(client, mqttsnMessage{topic: topic, payload: encodedData})
+	addr := client.addr
+	s.mu.Unlock()
+
+	for _, packet := range packets {
+		if err := s.send(addr, packet); err != nil {
+			return err
+		}
+	}
+	if acked != nil {
+		go s.retransmit(client, packets[len(packets)-1], acked)
+	}
+	return nil
+}
+
+// handlePacket 按报文类型处理客户端的报文
+func (s *MQTTSNGateway) handlePacket(addr *net.UDPAddr, packet *mqttsn.Packet) {
+	switch packet.Type {
+	case mqttsn.SEARCHGW:
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.GWINFO, GatewayID: s.gatewayID()})
+		return
+	case mqttsn.CONNECT:
+		s.handleConnectPacket(addr, packet)
+		return
+	case mqttsn.PINGREQ:
+		if packet.ClientID != "" {
+			s.handleWakeUp(addr, packet.ClientID)
+			return
+		}
+	case mqttsn.PUBLISH:
+		if packet.QoS() == -1 {
+			s.handlePublish(addr, packet)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	client := s.addrs[addr.String()]
+	if client != nil {
+		client.lastSeen = time.Now()
+	}
+	s.mu.Unlock()
+	if client == nil {
+		if packet.Type != mqttsn.DISCONNECT {
+			s.send(addr, &mqttsn.Packet{Type: mqttsn.DISCONNECT}) // 未连接的客户端，通知其重新连接
+		}
+		return
+	}
+
+	switch packet.Type {
+	case mqttsn.WILLTOPIC:
+		if packet.TopicName == "" {
+			s.completeConnect(client) // 空的遗嘱主题表示没有遗嘱
+		} else {
+			s.send(addr, &mqttsn.Packet{Type: mqttsn.WILLMSGREQ})
+		}
+	case mqttsn.WILLMSG:
+		s.completeConnect(client)
+	case mqttsn.REGISTER:
+		s.mu.Lock()
+		id := client.register(packet.TopicName)
+		s.mu.Unlock()
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.REGACK, TopicID: id, MessageID: packet.MessageID, ReturnCode: mqttsn.Accepted})
+	case mqttsn.PUBLISH:
+		s.handlePublish(addr, packet)
+	case mqttsn.PUBACK:
+		s.mu.Lock()
+		if acked, ok := client.inflight[packet.MessageID]; ok {
+			delete(client.inflight, packet.MessageID)
+			close(acked)
+		}
+		s.mu.Unlock()
+		if packet.ReturnCode != mqttsn.Accepted {
+			glog.Debugf(context.Background(), "MQTT-SN 客户端 %s 拒绝下发消息, 返回码 %d\n", client.id, packet.ReturnCode)
+		}
+	case mqttsn.REGACK:
+		if packet.ReturnCode != mqttsn.Accepted {
+			glog.Debugf(context.Background(), "MQTT-SN 客户端 %s 拒绝注册主题 %d, 返回码 %d\n", client.id, packet.TopicID, packet.ReturnCode)
+		}
+	case mqttsn.SUBSCRIBE:
+		s.handleSubscribe(addr, client, packet)
+	case mqttsn.UNSUBSCRIBE:
+		if topic, ok := s.topicFilter(packet); ok {
+			s.mu.Lock()
+			delete(client.subscriptions, topic)
+			s.mu.Unlock()
+		}
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.UNSUBACK, MessageID: packet.MessageID})
+	case mqttsn.PINGREQ:
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.PINGRESP})
+	case mqttsn.DISCONNECT:
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.DISCONNECT})
+		if packet.Duration > 0 {
+			s.mu.Lock()
+			client.state, client.keepAlive = mqttsnAsleep, time.Duration(packet.Duration)*time.Second
+			s.mu.Unlock()
+			return
+		}
+		s.removeClient(client)
+	}
+}
+
+// handleConnectPacket 处理 CONNECT，相同客户端标识的会话被接管，设置了遗嘱标志时先获取遗嘱主题与遗嘱消息
+func (s *MQTTSNGateway) handleConnectPacket(addr *net.UDPAddr, packet *mqttsn.Packet) {
+	if packet.ClientID == "" {
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.CONNACK, ReturnCode: mqttsn.RejectedNotSupported})
+		return
+	}
+
+	s.mu.Lock()
+	client := s.clients[packet.ClientID]
+	if client == nil || packet.Flags&mqttsn.FlagCleanSession != 0 {
+		old := client
+		client = &mqttsnClient{
+			id:            packet.ClientID,
+			subscriptions: make(map[string]bool),
+			topics:        make(map[string]uint16),
+			topicNames:    make(map[uint16]string),
+			inflight:      make(map[uint16]chan struct{}),
+		}
+		if old != nil {
+			client.device, client.queue = old.device, old.queue
+			delete(s.addrs, old.addr.String())
+		}
+		s.clients[packet.ClientID] = client
+	}
+	if client.addr != nil {
+		delete(s.addrs, client.addr.String())
+	}
+	client.addr, client.lastSeen = addr, time.Now()
+	client.keepAlive = time.Duration(packet.Duration) * time.Second
+	client.state = mqttsnConnecting
+	s.addrs[addr.String()] = client
+	delete(s.anonymous, addr.String())
+	s.mu.Unlock()
+
+	if packet.Flags&mqttsn.FlagWill != 0 {
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.WILLTOPICREQ}) // 遗嘱只完成握手，不发布
+		return
+	}
+	s.completeConnect(client)
+}
+
+// completeConnect 客户端连接成功，设备上线并发送休眠期间缓存的消息
+func (s *MQTTSNGateway) completeConnect(client *mqttsnClient) {
+	s.clientDevice(client)
+	s.mu.Lock()
+	client.state = mqttsnActive
+	addr := client.addr
+	s.mu.Unlock()
+	s.send(addr, &mqttsn.Packet{Type: mqttsn.CONNACK, ReturnCode: mqttsn.Accepted})
+	s.flush(client)
+}
+
+// handleWakeUp 休眠的客户端以带客户端标识的 PINGREQ 唤醒，发送缓存的消息后回复 PINGRESP，客户端重新进入休眠
+func (s *MQTTSNGateway) handleWakeUp(addr *net.UDPAddr, clientID string) {
+	s.mu.Lock()
+	client := s.clients[clientID]
+	if client == nil {
+		s.mu.Unlock()
+		s.send(addr, &mqttsn.Packet{Type: mqttsn.DISCONNECT})
+		return
+	}
+	delete(s.addrs, client.addr.String())
+	client.addr, client.lastSeen = addr, time.Now()
+	s.addrs[addr.String()] = client
+	s.mu.Unlock()
+
+	s.flush(client)
+	s.send(addr, &mqttsn.Packet{Type: mqttsn.PINGRESP})
+}
+
+// handlePublish 处理客户端发布的消息，QoS -1 的发布来自未连接的客户端时以地址作为客户端标识
+func (s *MQTTSNGateway) handlePublish(addr *net.UDPAddr, packet *mqttsn.Packet) {
+	s.mu.Lock()
+	client := s.addrs[addr.String()]
+	var topic string
+	var ok bool
+	switch packet.TopicType() {
+	case mqttsn.TopicNormal:
+		if client != nil {
+			topic, ok = client.topicNames[packet.TopicID]
+		}
+	case mqttsn.TopicPredefined:
+		topic, ok = s.predefinedTopic(packet.TopicID)
+	case mqttsn.TopicShort:
+		topic, ok = mqttsn.ShortTopicName(packet.TopicID), true
+	}
+	if client != nil {
+		client.lastSeen = time.Now()
+	} else {
+		s.anonymous[addr.String()] = time.Now()
+	}
+	s.mu.Unlock()
+
+	reply := &mqttsn.Packet{Type: mqttsn.PUBACK, TopicID: packet.TopicID, MessageID: packet.MessageID, ReturnCode: mqttsn.Accepted}
+	switch {
+	case !ok:
+		reply.ReturnCode = mqttsn.RejectedInvalidTopic
+	case packet.QoS() == 2:
+		reply.ReturnCode = mqttsn.RejectedNotSupported
+	}
+	if reply.ReturnCode != mqttsn.Accepted {
+		if packet.QoS() != -1 {
+			s.send(addr, reply)
+		}
+		return
+	}
+	if packet.QoS() == 1 {
+		s.send(addr, reply)
+	}
+
+	var device *model.Device
+	if client != nil {
+		device = s.clientDevice(client)
+	} else {
+		device = s.getDevice(addr.String())
+		if device == nil {
+			device = s.handleConnect(addr.String(), nil)
+			s.bindDevice(device, addr.String())
+		}
+	}
+	s.receive(device, topic, packet.Data)
+}
+
+// handleSubscribe 处理订阅，主题名称不含通配符时注册主题 ID 并在 SUBACK 中返回
+func (s *MQTTSNGateway) handleSubscribe(addr *net.UDPAddr, client *mqttsnClient, packet *mqttsn.Packet) {
+	reply := &mqttsn.Packet{Type: mqttsn.SUBACK, MessageID: packet.MessageID, ReturnCode: mqttsn.Accepted}
+	reply.SetQoS(min(max(packet.QoS(), 0), 1))
+	topic, ok := s.topicFilter(packet)
+	if !ok {
+		reply.ReturnCode = mqttsn.RejectedInvalidTopic
+		s.send(addr, reply)
+		return
+	}
+
+	s.mu.Lock()
+	client.subscriptions[topic] = true
+	switch {
+	case packet.TopicType() != mqttsn.TopicNormal:
+		reply.TopicID = packet.TopicID
+		if packet.TopicType() == mqttsn.TopicShort {
+			reply.TopicID, _ = mqttsn.ShortTopic(topic)
+		}
+	case !strings.ContainsAny(topic, "+#"):
+		reply.TopicID = client.register(topic)
+	}
+	s.mu.Unlock()
+	s.send(addr, reply)
+}
+
+// receive 将客户端发布的消息交给协议处理器，未设置协议处理器时转换为属性上报
+func (s *MQTTSNGateway) receive(device *model.Device, topic string, payload []byte) {
+	if s.protocolHandler != nil {
+		resData, err := s.handleReceiveData(device, payload)
+		if err != nil {
+			glog.Debugf(context.Background(), "处理 MQTT-SN 设备 %s 数据错误: %v\n", device.DeviceKey, err)
+			return
+		}
+		if resData != nil {
+			if err := s.SendData(device, resData); err != nil {
+				glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
+			}
+		}
+		return
+	}
+
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         device.DeviceKey,
+		"PropertieDataList": mqttsnProperties(topic, payload),
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 MQTT-SN 设备 %s 数据失败: %v\n", device.DeviceKey, err)
+	}
+}
+
+// flush 发送客户端休眠期间缓存的消息
+func (s *MQTTSNGateway) flush(client *mqttsnClient) {
+	s.mu.Lock()
+	queue := client.queue
+	client.queue = nil
+	var packets []*mqttsn.Packet
+	for _, message := range queue {
+		messagePackets, acked := s.publishPackets(client, message)
+		if acked != nil {
+			delete(client.inflight, messagePackets[len(messagePackets)-1].MessageID) // 客户端随后可能再次休眠，不重传
+		}
+		packets = append(packets, messagePackets...)
+	}
+	addr := client.addr
+	s.mu.Unlock()
+	for _, packet := range packets {
+		s.send(addr, packet)
+	}
+}
+
+// publishPackets 生成下发消息的报文，主题未注册时先发送 REGISTER；QoS 1 时返回等待 PUBACK 的通道，调用方持有 s.mu
+func (s *MQTTSNGateway) publishPackets(client *mqttsnClient, message mqttsnMessage) ([]*mqttsn.Packet, chan struct{}) {
+	var packets []*mqttsn.Packet
+	publish := &mqttsn.Packet{Type: mqttsn.PUBLISH, Data: message.payload}
+	if id, ok := s.predefinedID(message.topic); ok {
+		publish.Flags, publish.TopicID = mqttsn.TopicPredefined, id
+	} else if id, ok := mqttsn.ShortTopic(message.topic); ok {
+		publish.Flags, publish.TopicID = mqttsn.TopicShort, id
+	} else {
+		id, registered := client.topics[message.topic]
+		if !registered {
+			id = client.register(message.topic)
+			packets = append(packets, &mqttsn.Packet{Type: mqttsn.REGISTER, TopicID: id, MessageID: client.nextMessageID(), TopicName: message.topic})
+		}
+		publish.TopicID = id
+	}
+
+	var acked chan struct{}
+	if s.mqttsnConfig.QoS >= 1 {
+		publish.SetQoS(1)
+		publish.MessageID = client.nextMessageID()
+		acked = make(chan struct{})
+		client.inflight[publish.MessageID] = acked
+	}
+	return append(packets, publish), acked
+}
+
+// retransmit 重传未收到 PUBACK 的 QoS 1 消息，重传次数用尽或客户端休眠、断开时放弃
+func (s *MQTTSNGateway) retransmit(client *mqttsnClient, publish *mqttsn.Packet, acked chan struct{}) {
+	timer := time.NewTimer(s.ackTimeout())
+	defer timer.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-acked:
+			return
+		case <-timer.C:
+		}
+		s.mu.Lock()
+		active := client.state == mqttsnActive && s.clients[client.id] == client
+		addr := client.addr
+		if !active || i >= s.maxRetransmit() {
+			delete(client.inflight, publish.MessageID)
+		}
+		s.mu.Unlock()
+		if !active {
+			return
+		}
+		if i >= s.maxRetransmit() {
+			glog.Debugf(context.Background(), "MQTT-SN 客户端 %s 未确认下发消息 %d\n", client.id, publish.MessageID)
+			return
+		}
+		retry := *publish
+		retry.Flags |= mqttsn.FlagDUP
+		s.send(addr, &retry)
+		timer.Reset(s.ackTimeout())
+	}
+}
+
+// clientDevice 获取客户端的设备，设备已离线时重新上线
+func (s *MQTTSNGateway) clientDevice(client *mqttsnClient) *model.Device {
+	s.mu.Lock()
+	device := client.device
+	s.mu.Unlock()
+	if device != nil && s.getDevice(client.id) == device {
+		return device
+	}
+	device = s.handleConnect(client.id, nil)
+	s.bindDevice(device, client.id)
+	s.mu.Lock()
+	client.device = device
+	s.mu.Unlock()
+	return device
+}
+
+// removeClient 删除客户端的会话，设备离线
+func (s *MQTTSNGateway) removeClient(client *mqttsnClient) {
+	s.mu.Lock()
+	if s.clients[client.id] == client {
+		delete(s.clients, client.id)
+	}
+	if s.addrs[client.addr.String()] == client {
+		delete(s.addrs, client.addr.String())
+	}
+	device := client.device
+	s.mu.Unlock()
+	if device != nil {
+		s.handleDisconnect(device)
+	}
+}
+
+// checkClients 定时检查客户端，超过保活时间或休眠时间未收到报文的客户端离线
+func (s *MQTTSNGateway) checkClients(ctx context.Context) {
+	ticker := time.NewTicker(mqttsnCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var lost []*mqttsnClient
+			var idle []string
+			s.mu.Lock()
+			for _, client := range s.clients {
+				if client.keepAlive > 0 && now.Sub(client.lastSeen) > time.Duration(float64(client.keepAlive)*mqttsnKeepAliveFactor) {
+					lost = append(lost, client)
+				}
+			}
+			for addr, lastSeen := range s.anonymous {
+				if now.Sub(lastSeen) > s.timeout*2 {
+					delete(s.anonymous, addr)
+					idle = append(idle, addr)
+				}
+			}
+			s.mu.Unlock()
+			for _, client := range lost {
+				glog.Debugf(context.Background(), "MQTT-SN 客户端 %s 超时\n", client.id)
+				s.removeClient(client)
+			}
+			for _, addr := range idle {
+				if device := s.getDevice(addr); device != nil {
+					s.handleDisconnect(device)
+				}
+			}
+		}
+	}
+}
+
+// topicFilter 获取 SUBSCRIBE、UNSUBSCRIBE 中的主题过滤器
+func (s *MQTTSNGateway) topicFilter(packet *mqttsn.Packet) (string, bool) {
+	switch packet.TopicType() {
+	case mqttsn.TopicPredefined:
+		return s.predefinedTopic(packet.TopicID)
+	case mqttsn.TopicShort:
+		return packet.TopicName, len(packet.TopicName) == 2
+	}
+	return packet.TopicName, packet.TopicName != ""
+}
+
+// predefinedTopic 按主题 ID 查找预定义主题
+func (s *MQTTSNGateway) predefinedTopic(id uint16) (string, bool) {
+	for _, topic := range s.mqttsnConfig.PredefinedTopics {
+		if topic.ID == id {
+			return topic.Name, true
+		}
+	}
+	return "", false
+}
+
+// predefinedID 按主题名称查找预定义主题 ID
+func (s *MQTTSNGateway) predefinedID(name string) (uint16, bool) {
+	for _, topic := range s.mqttsnConfig.PredefinedTopics {
+		if topic.Name == name {
+			return topic.ID, true
+		}
+	}
+	return 0, false
+}
+
+// send 编码并发送报文
+func (s *MQTTSNGateway) send(addr *net.UDPAddr, packet *mqttsn.Packet) error {
+	data, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.WriteToUDP(data, addr); err != nil {
+		glog.Debugf(context.Background(), "发送 MQTT-SN 报文失败 %s: %v\n", addr, err)
+		return err
+	}
+	return nil
+}
+
+// gatewayID 获取网关标识
+func (s *MQTTSNGateway) gatewayID() byte {
+	if s.mqttsnConfig.GatewayID == 0 {
+		return 1
+	}
+	return s.mqttsnConfig.GatewayID
+}
+
+// downTopic 获取下发主题
+func (s *MQTTSNGateway) downTopic() string {
+	if s.mqttsnConfig.DownTopic == "" {
+		return defaultBrokerDownTopic
+	}
+	return s.mqttsnConfig.DownTopic
+}
+
+// maxQueueSize 获取休眠客户端缓存的下发消息数上限
+func (s *MQTTSNGateway) maxQueueSize() int {
+	if s.mqttsnConfig.MaxQueueSize <= 0 {
+		return 100
+	}
+	return s.mqttsnConfig.MaxQueueSize
+}
+
+// ackTimeout 获取 QoS 1 下发消息等待 PUBACK 的超时
+func (s *MQTTSNGateway) ackTimeout() time.Duration {
+	if s.mqttsnConfig.AckTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return s.mqttsnConfig.AckTimeout
+}
+
+// maxRetransmit 获取 QoS 1 下发消息的最大重传次数
+func (s *MQTTSNGateway) maxRetransmit() int {
+	if s.mqttsnConfig.MaxRetransmit <= 0 {
+		return 3
+	}
+	return s.mqttsnConfig.MaxRetransmit
+}
+
+// subscribed 客户端是否订阅了主题
+func (c *mqttsnClient) subscribed(topic string) bool {
+	for filter := range c.subscriptions {
+		if _, ok := matchTopic(filter, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// register 获取主题的主题 ID，未注册时分配新的主题 ID
+func (c *mqttsnClient) register(topic string) uint16 {
+	if id, ok := c.topics[topic]; ok {
+		return id
+	}
+	c.lastTopicID++
+	if c.lastTopicID == 0 || c.lastTopicID == 0xFFFF { // 0x0000 与 0xFFFF 为保留值
+		c.lastTopicID = 1
+	}
+	c.topics[topic], c.topicNames[c.lastTopicID] = c.lastTopicID, topic
+	return c.lastTopicID
+}
+
+// enqueue 缓存休眠期间的下发消息，超出上限时丢弃最早的消息
+func (c *mqttsnClient) enqueue(message mqttsnMessage, maxQueueSize int) {
+	c.queue = append(c.queue, message)
+	if len(c.queue) > maxQueueSize {
+		glog.Debugf(context.Background(), "MQTT-SN 客户端 %s 下发队列已满，丢弃最早的 %d 条消息", c.id, len(c.queue)-maxQueueSize)
+		c.queue = c.queue[len(c.queue)-maxQueueSize:]
+	}
+}
+
+// nextMessageID 获取下一个消息 ID，0 为保留值
+func (c *mqttsnClient) nextMessageID() uint16 {
+	c.lastMessageID++
+	if c.lastMessageID == 0 {
+		c.lastMessageID = 1
+	}
+	return c.lastMessageID
+}
+
+// mqttsnPayload 未设置协议处理器时的下发数据：[]byte 与字符串原样发送，其他数据编码为 JSON
+func mqttsnPayload(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return json.Marshal(data)
+}
+
+// mqttsnProperties 将发布的消息转换为属性：JSON 对象的各字段为属性，其他消息以主题最后一级为属性名，数值消息转换为数值
+func mqttsnProperties(topic string, payload []byte) map[string]interface{} {
+	var properties map[string]interface{}
+	if json.Unmarshal(payload, &properties) == nil && properties != nil {
+		return properties
+	}
+	name := topic[strings.LastIndex(topic, "/")+1:]
+	text := strings.TrimSpace(string(payload))
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return map[string]interface{}{name: number}
+	}
+	return map[string]interface{}{name: text}
+}
@@ -0,0 +1,292 @@
+// Package mqttsn 实现 MQTT-SN v1.2 报文的编解码
+package mqttsn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 报文类型
+const (
+	ADVERTISE    byte = 0x00
+	SEARCHGW     byte = 0x01
+	GWINFO       byte = 0x02
+	CONNECT      byte = 0x04
+	CONNACK      byte = 0x05
+	WILLTOPICREQ byte = 0x06
+	WILLTOPIC    byte = 0x07
+	WILLMSGREQ   byte = 0x08
+	WILLMSG      byte = 0x09
+	REGISTER     byte = 0x0A
+	REGACK       byte = 0x0B
+	PUBLISH      byte = 0x0C
+	PUBACK       byte = 0x0D
+	PUBCOMP      byte = 0x0E
+	PUBREC       byte = 0x0F
+	PUBREL       byte = 0x10
+	SUBSCRIBE    byte = 0x12
+	SUBACK       byte = 0x13
+	UNSUBSCRIBE  byte = 0x14
+	UNSUBACK     byte = 0x15
+	PINGREQ      byte = 0x16
+	PINGRESP     byte = 0x17
+	DISCONNECT   byte = 0x18
+)
+
+// 标志位
+const (
+	FlagDUP          byte = 0x80
+	FlagRetain       byte = 0x10
+	FlagWill         byte = 0x08
+	FlagCleanSession byte = 0x04
+
+	flagQoSMask     byte = 0x60
+	flagTopicIDMask byte = 0x03
+)
+
+// 主题类型
+const (
+	TopicNormal     byte = 0x00 // 注册得到的主题 ID,SUBSCRIBE 中为主题名称
+	TopicPredefined byte = 0x01 // 预定义主题 ID
+	TopicShort      byte = 0x02 // 2 字节的短主题名称
+)
+
+// 返回码
+const (
+	Accepted             byte = 0x00
+	RejectedCongestion   byte = 0x01
+	RejectedInvalidTopic byte = 0x02
+	RejectedNotSupported byte = 0x03
+	protocolID           byte = 0x01
+	longLengthIndicator  byte = 0x01
+	maxShortPacketLength      = 0xFF
+)
+
+// ErrInvalidPacket 报文格式错误
+var ErrInvalidPacket = errors.New("MQTT-SN 报文格式错误")
+
+// Packet MQTT-SN 报文，各字段按报文类型使用
+type Packet struct {
+	Type       byte
+	Flags      byte   // CONNECT、WILLTOPIC、PUBLISH、SUBSCRIBE、SUBACK、UNSUBSCRIBE
+	Duration   uint16 // CONNECT 的保活时间、DISCONNECT 的休眠时间,秒
+	TopicID    uint16 // REGISTER、REGACK、PUBLISH、PUBACK、SUBACK,SUBSCRIBE 和 UNSUBSCRIBE 中为预定义主题 ID
+	MessageID  uint16 // REGISTER、REGACK、PUBLISH、PUBACK、SUBSCRIBE、SUBACK、UNSUBSCRIBE、UNSUBACK
+	ReturnCode byte   // CONNACK、REGACK、PUBACK、SUBACK
+	GatewayID  byte   // ADVERTISE、GWINFO
+	Radius     byte   // SEARCHGW
+	ClientID   string // CONNECT、PINGREQ
+	TopicName  string // REGISTER、WILLTOPIC,SUBSCRIBE 和 UNSUBSCRIBE 中主题类型为名称或短主题时使用
+	Data       []byte // PUBLISH、WILLMSG
+}
+
+// QoS 返回标志位中的 QoS，-1 表示无连接发布
+func (p *Packet) QoS() int {
+	if qos := int(p.Flags & flagQoSMask >> 5); qos != 3 {
+		return qos
+	}
+	return -1
+}
+
+// SetQoS 设置标志位中的 QoS
+func (p *Packet) SetQoS(qos int) {
+	p.Flags = p.Flags&^flagQoSMask | byte(qos&0x03)<<5
+}
+
+// TopicType 返回标志位中的主题类型
+func (p *Packet) TopicType() byte {
+	return p.Flags & flagTopicIDMask
+}
+
+// Marshal 编码报文，长度超过 255 字节时使用 3 字节长度
+func (p *Packet) Marshal() ([]byte, error) {
+	var body []byte
+	switch p.Type {
+	case ADVERTISE:
+		body = binary.BigEndian.AppendUint16([]byte{p.GatewayID}, p.Duration)
+	case SEARCHGW:
+		body = []byte{p.Radius}
+	case GWINFO:
+		body = []byte{p.GatewayID}
+	case CONNECT:
+		body = binary.BigEndian.AppendUint16([]byte{p.Flags, protocolID}, p.Duration)
+		body = append(body, p.ClientID...)
+	case CONNACK:
+		body = []byte{p.ReturnCode}
+	case WILLTOPICREQ, WILLMSGREQ, PINGRESP:
+	case WILLTOPIC:
+		if p.TopicName != "" {
+			body = append([]byte{p.Flags}, p.TopicName...)
+		}
+	case WILLMSG:
+		body = p.Data
+	case REGISTER:
+		body = binary.BigEndian.AppendUint16(nil, p.TopicID)
+		body = binary.BigEndian.AppendUint16(body, p.MessageID)
+		body = append(body, p.TopicName...)
+	case REGACK, PUBACK:
+		body = binary.BigEndian.AppendUint16(nil, p.TopicID)
+		body = binary.BigEndian.AppendUint16(body, p.MessageID)
+		body = append(body, p.ReturnCode)
+	case PUBLISH:
+		body = binary.BigEndian.AppendUint16([]byte{p.Flags}, p.TopicID)
+		body = binary.BigEndian.AppendUint16(body, p.MessageID)
+		body = append(body, p.Data...)
+	case PUBCOMP, PUBREC, PUBREL, UNSUBACK:
+		body = binary.BigEndian.AppendUint16(nil, p.MessageID)
+	case SUBSCRIBE, UNSUBSCRIBE:
+		body = binary.BigEndian.AppendUint16([]byte{p.Flags}, p.MessageID)
+		if p.TopicType() == TopicPredefined {
+			body = binary.BigEndian.AppendUint16(body, p.TopicID)
+		} else {
+			body = append(body, p.TopicName...)
+		}
+	case SUBACK:
+		body = binary.BigEndian.AppendUint16([]byte{p.Flags}, p.TopicID)
+		body = binary.BigEndian.AppendUint16(body, p.MessageID)
+		body = append(body, p.ReturnCode)
+	case PINGREQ:
+		body = []byte(p.ClientID)
+	case DISCONNECT:
+		if p.Duration > 0 {
+			body = binary.BigEndian.AppendUint16(nil, p.Duration)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的 MQTT-SN 报文类型 0x%02X", p.Type)
+	}
+
+	if length := len(body) + 2; length <= maxShortPacketLength {
+		return append([]byte{byte(length), p.Type}, body...), nil
+	}
+	length := len(body) + 4
+	if length > 0xFFFF {
+		return nil, fmt.Errorf("MQTT-SN 报文长度 %d 超出范围", length)
+	}
+	data := binary.BigEndian.AppendUint16([]byte{longLengthIndicator}, uint16(length))
+	return append(append(data, p.Type), body...), nil
+}
+
+// Unmarshal 解码一个完整的报文
+func Unmarshal(data []byte) (*Packet, error) {
+	if len(data) < 2 {
+		return nil, ErrInvalidPacket
+	}
+	length, header := int(data[0]), 1
+	if data[0] == longLengthIndicator {
+		if len(data) < 4 {
+			return nil, ErrInvalidPacket
+		}
+		length, header = int(binary.BigEndian.Uint16(data[1:])), 3
+	}
+	if length != len(data) || length < header+1 {
+		return nil, ErrInvalidPacket
+	}
+	p := &Packet{Type: data[header]}
+	body := data[header+1:]
+
+	// need 检查报文体的最小长度
+	need := func(n int) error {
+		if len(body) < n {
+			return fmt.Errorf("%w: 类型 0x%02X 长度 %d", ErrInvalidPacket, p.Type, len(data))
+		}
+		return nil
+	}
+	switch p.Type {
+	case ADVERTISE:
+		if err := need(3); err != nil {
+			return nil, err
+		}
+		p.GatewayID, p.Duration = body[0], binary.BigEndian.Uint16(body[1:])
+	case SEARCHGW:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		p.Radius = body[0]
+	case GWINFO:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		p.GatewayID = body[0]
+	case CONNECT:
+		if err := need(4); err != nil {
+			return nil, err
+		}
+		if body[1] != protocolID {
+			return nil, fmt.Errorf("%w: 协议标识 0x%02X", ErrInvalidPacket, body[1])
+		}
+		p.Flags, p.Duration, p.ClientID = body[0], binary.BigEndian.Uint16(body[2:]), string(body[4:])
+	case CONNACK:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		p.ReturnCode = body[0]
+	case WILLTOPICREQ, WILLMSGREQ, PINGRESP:
+	case WILLTOPIC:
+		if len(body) > 0 {
+			p.Flags, p.TopicName = body[0], string(body[1:])
+		}
+	case WILLMSG:
+		p.Data = body
+	case REGISTER:
+		if err := need(4); err != nil {
+			return nil, err
+		}
+		p.TopicID, p.MessageID, p.TopicName = binary.BigEndian.Uint16(body), binary.BigEndian.Uint16(body[2:]), string(body[4:])
+	case REGACK, PUBACK:
+		if err := need(5); err != nil {
+			return nil, err
+		}
+		p.TopicID, p.MessageID, p.ReturnCode = binary.BigEndian.Uint16(body), binary.BigEndian.Uint16(body[2:]), body[4]
+	case PUBLISH:
+		if err := need(5); err != nil {
+			return nil, err
+		}
+		p.Flags, p.TopicID, p.MessageID, p.Data = body[0], binary.BigEndian.Uint16(body[1:]), binary.BigEndian.Uint16(body[3:]), body[5:]
+	case PUBCOMP, PUBREC, PUBREL, UNSUBACK:
+		if err := need(2); err != nil {
+			return nil, err
+		}
+		p.MessageID = binary.BigEndian.Uint16(body)
+	case SUBSCRIBE, UNSUBSCRIBE:
+		if err := need(3); err != nil {
+			return nil, err
+		}
+		p.Flags, p.MessageID = body[0], binary.BigEndian.Uint16(body[1:])
+		if p.TopicType() == TopicPredefined {
+			if err := need(5); err != nil {
+				return nil, err
+			}
+			p.TopicID = binary.BigEndian.Uint16(body[3:])
+		} else {
+			p.TopicName = string(body[3:])
+		}
+	case SUBACK:
+		if err := need(6); err != nil {
+			return nil, err
+		}
+		p.Flags, p.TopicID, p.MessageID, p.ReturnCode = body[0], binary.BigEndian.Uint16(body[1:]), binary.BigEndian.Uint16(body[3:]), body[5]
+	case PINGREQ:
+		p.ClientID = string(body)
+	case DISCONNECT:
+		if len(body) >= 2 {
+			p.Duration = binary.BigEndian.Uint16(body)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的 MQTT-SN 报文类型 0x%02X", p.Type)
+	}
+	return p, nil
+}
+
+// ShortTopic 将 2 字节的短主题名称编码为主题 ID
+func ShortTopic(name string) (uint16, bool) {
+	if len(name) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16([]byte(name)), true
+}
+
+// ShortTopicName 将短主题的主题 ID 还原为主题名称
+func ShortTopicName(id uint16) string {
+	return string(binary.BigEndian.AppendUint16(nil, id))
+}
@@ -0,0 +1,90 @@
+package mqttsn
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	publish := &Packet{Type: PUBLISH, Flags: TopicPredefined | FlagRetain, TopicID: 0x0102, MessageID: 7, Data: []byte("21.5")}
+	publish.SetQoS(-1)
+	packets := []*Packet{
+		{Type: SEARCHGW, Radius: 1},
+		{Type: GWINFO, GatewayID: 3},
+		{Type: CONNECT, Flags: FlagCleanSession | FlagWill, Duration: 60, ClientID: "sensor-1"},
+		{Type: CONNACK, ReturnCode: RejectedCongestion},
+		{Type: WILLTOPIC, Flags: FlagRetain, TopicName: "will"},
+		{Type: REGISTER, TopicID: 0, MessageID: 1, TopicName: "sensors/temp"},
+		{Type: REGACK, TopicID: 5, MessageID: 1, ReturnCode: Accepted},
+		publish,
+		{Type: SUBSCRIBE, Flags: TopicPredefined, MessageID: 2, TopicID: 9},
+		{Type: SUBSCRIBE, Flags: TopicNormal, MessageID: 3, TopicName: "device/+/down"},
+		{Type: SUBACK, Flags: 0x20, TopicID: 5, MessageID: 3, ReturnCode: Accepted},
+		{Type: UNSUBACK, MessageID: 4},
+		{Type: PINGREQ, ClientID: "sensor-1"},
+		{Type: PINGRESP},
+		{Type: DISCONNECT, Duration: 300},
+	}
+	for _, p := range packets {
+		data, err := p.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(data[0]) != len(data) {
+			t.Fatalf("类型 0x%02X 长度字段 %d, 实际 %d", p.Type, data[0], len(data))
+		}
+		got, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Fatalf("类型 0x%02X 解码 %+v, 期望 %+v", p.Type, got, p)
+		}
+	}
+	if publish.QoS() != -1 || publish.TopicType() != TopicPredefined {
+		t.Fatalf("QoS %d 主题类型 %d", publish.QoS(), publish.TopicType())
+	}
+}
+
+func TestLongPacket(t *testing.T) {
+	p := &Packet{Type: PUBLISH, TopicID: 1, MessageID: 1, Data: []byte(strings.Repeat("x", 300))}
+	data, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:4], []byte{0x01, 0x01, 0x35, PUBLISH}) {
+		t.Fatalf("3 字节长度 % X", data[:4])
+	}
+	if got, err := Unmarshal(data); err != nil || !bytes.Equal(got.Data, p.Data) {
+		t.Fatalf("解码长报文 %v", err)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x03, PUBLISH},                   // 长度与实际不符
+		{0x04, PUBLISH, 0x00, 0x00},       // 报文体不足
+		{0x06, CONNECT, 0x04, 0x02, 0, 1}, // 协议标识错误
+		{0x01, 0x00, 0x04},                // 3 字节长度不足
+	} {
+		if _, err := Unmarshal(data); !errors.Is(err, ErrInvalidPacket) {
+			t.Fatalf("% X 应返回格式错误, 实际 %v", data, err)
+		}
+	}
+	if _, err := Unmarshal([]byte{0x02, 0x30}); err == nil {
+		t.Fatal("不支持的报文类型应返回错误")
+	}
+}
+
+func TestShortTopic(t *testing.T) {
+	id, ok := ShortTopic("tp")
+	if !ok || id != 0x7470 || ShortTopicName(id) != "tp" {
+		t.Fatalf("短主题 %04X", id)
+	}
+	if _, ok := ShortTopic("temp"); ok {
+		t.Fatal("超过 2 字节的主题名称不是短主题")
+	}
+}
@@ -0,0 +1,211 @@
+package network
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/network/mqttsn"
+)
+
+// mqttsnTestClient 测试用 MQTT-SN 客户端
+type mqttsnTestClient struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+func (c *mqttsnTestClient) send(p *mqttsn.Packet) {
+	data, err := p.Marshal()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *mqttsnTestClient) receive(timeout time.Duration) (*mqttsn.Packet, error) {
+	buffer := make([]byte, 2048)
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := c.conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return mqttsn.Unmarshal(buffer[:n])
+}
+
+// expect 接收指定类型的报文
+func (c *mqttsnTestClient) expect(typ byte) *mqttsn.Packet {
+	c.t.Helper()
+	p, err := c.receive(3 * time.Second)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if p.Type != typ {
+		c.t.Fatalf("收到报文类型 0x%02X, 期望 0x%02X", p.Type, typ)
+	}
+	return p
+}
+
+func TestMQTTSNGateway(t *testing.T) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	// 监听器需要在网关启动前注册
+	reports := make(chan map[string]interface{}, 4)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		select {
+		case reports <- e.Data():
+		default: // 监听器是全局的，测试结束后不阻塞其他测试的上报
+		}
+		return nil
+	}))
+	server := NewMQTTSNGateway(WithMQTTSNConfig(conf.MQTTSNConfig{
+		QoS:              1,
+		AckTimeout:       100 * time.Millisecond,
+		PredefinedTopics: []conf.MQTTSNTopicConfig{{ID: 1, Name: "sensors/battery"}},
+	})).(*MQTTSNGateway)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr.String())
+
+	dial := func() *mqttsnTestClient {
+		conn, err := net.DialUDP("udp", nil, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &mqttsnTestClient{t: t, conn: conn}
+	}
+	client := dial()
+	// 等待网关开始监听
+	for i := 0; ; i++ {
+		client.send(&mqttsn.Packet{Type: mqttsn.SEARCHGW, Radius: 1})
+		if p, err := client.receive(50 * time.Millisecond); err == nil {
+			if p.Type != mqttsn.GWINFO || p.GatewayID != 1 {
+				t.Fatalf("SEARCHGW 应回复 GWINFO: %+v", p)
+			}
+			break
+		}
+		if i == 50 {
+			t.Fatal("MQTT-SN 网关未启动")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	expectReport := func(deviceKey string, properties map[string]interface{}) {
+		t.Helper()
+		select {
+		case report := <-reports:
+			if report["DeviceKey"] != deviceKey || !reflect.DeepEqual(report["PropertieDataList"], properties) {
+				t.Fatalf("上报数据 %v", report)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("没有上报数据")
+		}
+	}
+
+	// 未连接时发送的报文以 DISCONNECT 拒绝
+	client.send(&mqttsn.Packet{Type: mqttsn.PINGREQ})
+	client.expect(mqttsn.DISCONNECT)
+
+	// 带遗嘱的连接
+	client.send(&mqttsn.Packet{Type: mqttsn.CONNECT, Flags: mqttsn.FlagCleanSession | mqttsn.FlagWill, Duration: 60, ClientID: "sensor-1"})
+	client.expect(mqttsn.WILLTOPICREQ)
+	client.send(&mqttsn.Packet{Type: mqttsn.WILLTOPIC, TopicName: "sensors/status"})
+	client.expect(mqttsn.WILLMSGREQ)
+	client.send(&mqttsn.Packet{Type: mqttsn.WILLMSG, Data: []byte("offline")})
+	if p := client.expect(mqttsn.CONNACK); p.ReturnCode != mqttsn.Accepted {
+		t.Fatalf("CONNACK 返回码 %d", p.ReturnCode)
+	}
+	if server.LookupDevice("sensor-1") == nil {
+		t.Fatal("连接后设备应上线")
+	}
+
+	client.send(&mqttsn.Packet{Type: mqttsn.REGISTER, MessageID: 1, TopicName: "sensors/temp"})
+	regack := client.expect(mqttsn.REGACK)
+	if regack.ReturnCode != mqttsn.Accepted || regack.TopicID == 0 || regack.MessageID != 1 {
+		t.Fatalf("REGACK %+v", regack)
+	}
+	publish := &mqttsn.Packet{Type: mqttsn.PUBLISH, TopicID: regack.TopicID, MessageID: 2, Data: []byte("21.5")}
+	publish.SetQoS(1)
+	client.send(publish)
+	if p := client.expect(mqttsn.PUBACK); p.MessageID != 2 || p.ReturnCode != mqttsn.Accepted {
+		t.Fatalf("PUBACK %+v", p)
+	}
+	expectReport("sensor-1", map[string]interface{}{"temp": 21.5})
+
+	client.send(&mqttsn.Packet{Type: mqttsn.PUBLISH, TopicID: regack.TopicID, Data: []byte(`{"humidity":40,"ok":true}`)})
+	expectReport("sensor-1", map[string]interface{}{"humidity": 40.0, "ok": true})
+
+	// 未注册的主题 ID
+	client.send(&mqttsn.Packet{Type: mqttsn.PUBLISH, TopicID: 99, MessageID: 3})
+	if p := client.expect(mqttsn.PUBACK); p.ReturnCode != mqttsn.RejectedInvalidTopic {
+		t.Fatalf("未注册主题的 PUBACK 返回码 %d", p.ReturnCode)
+	}
+
+	// 订阅下发主题后下发 QoS 1 消息，未确认时重传
+	device := server.LookupDevice("sensor-1")
+	if err := server.SendData(device, map[string]interface{}{"led": "on"}); err == nil {
+		t.Fatal("未订阅下发主题时应返回错误")
+	}
+	subscribe := &mqttsn.Packet{Type: mqttsn.SUBSCRIBE, MessageID: 4, TopicName: "device/sensor-1/down"}
+	subscribe.SetQoS(1)
+	client.send(subscribe)
+	suback := client.expect(mqttsn.SUBACK)
+	if suback.ReturnCode != mqttsn.Accepted || suback.TopicID == 0 || suback.QoS() != 1 {
+		t.Fatalf("SUBACK %+v", suback)
+	}
+	if err := server.SendData(device, map[string]interface{}{"led": "on"}); err != nil {
+		t.Fatal(err)
+	}
+	first := client.expect(mqttsn.PUBLISH)
+	if first.TopicID != suback.TopicID || first.QoS() != 1 || string(first.Data) != `{"led":"on"}` {
+		t.Fatalf("下发消息 %+v", first)
+	}
+	retry := client.expect(mqttsn.PUBLISH)
+	if retry.Flags&mqttsn.FlagDUP == 0 || retry.MessageID != first.MessageID {
+		t.Fatalf("重传消息 %+v", retry)
+	}
+	client.send(&mqttsn.Packet{Type: mqttsn.PUBACK, TopicID: retry.TopicID, MessageID: retry.MessageID})
+	if p, err := client.receive(300 * time.Millisecond); err == nil {
+		t.Fatalf("确认后不应再重传: %+v", p)
+	}
+
+	// 休眠期间的下发消息在唤醒时发送
+	client.send(&mqttsn.Packet{Type: mqttsn.DISCONNECT, Duration: 60})
+	client.expect(mqttsn.DISCONNECT)
+	if err := server.SendData(device, "sleep"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := client.receive(200 * time.Millisecond); err == nil {
+		t.Fatalf("休眠时不应下发: %+v", p)
+	}
+	client.send(&mqttsn.Packet{Type: mqttsn.PINGREQ, ClientID: "sensor-1"})
+	if p := client.expect(mqttsn.PUBLISH); string(p.Data) != "sleep" {
+		t.Fatalf("唤醒后下发 %+v", p)
+	}
+	client.expect(mqttsn.PINGRESP)
+
+	// 未连接的客户端以预定义主题发布 QoS -1 消息，以地址作为客户端标识
+	anonymous := dial()
+	oneShot := &mqttsn.Packet{Type: mqttsn.PUBLISH, Flags: mqttsn.TopicPredefined, TopicID: 1, Data: []byte("3.3")}
+	oneShot.SetQoS(-1)
+	anonymous.send(oneShot)
+	expectReport(anonymous.conn.LocalAddr().String(), map[string]interface{}{"battery": 3.3})
+
+	client.send(&mqttsn.Packet{Type: mqttsn.DISCONNECT})
+	client.expect(mqttsn.DISCONNECT)
+	if server.LookupDevice("sensor-1") != nil {
+		t.Fatal("断开后设备应离线")
+	}
+}
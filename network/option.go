@@ -35,3 +35,108 @@ func WithPacketHandling(config conf.PacketConfig) Option {
 		s.packetConfig = config
 	}
 }
+
+// WithFrameDecoder 设置自定义帧解码器选项，设置后忽略粘包处理配置
+func WithFrameDecoder(decoder FrameDecoder) Option {
+	return func(s *BaseServer) {
+		s.decoder = decoder
+	}
+}
+
+// WithFrameEncoder 设置自定义帧编码器选项
+func WithFrameEncoder(encoder FrameEncoder) Option {
+	return func(s *BaseServer) {
+		s.encoder = encoder
+	}
+}
+
+// WithSerialConfig 设置串口参数选项
+func WithSerialConfig(config conf.SerialConfig) Option {
+	return func(s *BaseServer) {
+		s.serialConfig = config
+	}
+}
+
+// WithTCPClientConfig 设置 TCP 客户端模式的远端列表与重连选项
+func WithTCPClientConfig(config conf.TCPClientConfig) Option {
+	return func(s *BaseServer) {
+		s.tcpClientConfig = config
+	}
+}
+
+// WithTLSConfig 设置设备接入的 TLS 选项
+func WithTLSConfig(config conf.TLSConfig) Option {
+	return func(s *BaseServer) {
+		s.tlsConfig = config
+	}
+}
+
+// WithWebSocketConfig 设置 WebSocket 选项
+func WithWebSocketConfig(config conf.WebSocketConfig) Option {
+	return func(s *BaseServer) {
+		s.wsConfig = config
+	}
+}
+
+// WithHTTPConfig 设置 HTTP 接入选项
+func WithHTTPConfig(config conf.HTTPConfig) Option {
+	return func(s *BaseServer) {
+		s.httpConfig = config
+	}
+}
+
+// WithCoAPConfig 设置 CoAP 接入选项
+func WithCoAPConfig(config conf.CoAPConfig) Option {
+	return func(s *BaseServer) {
+		s.coapConfig = config
+	}
+}
+
+// WithMQTTBrokerConfig 设置内置 MQTT Broker 选项
+func WithMQTTBrokerConfig(config conf.MQTTBrokerConfig) Option {
+	return func(s *BaseServer) {
+		s.mqttBrokerConfig = config
+	}
+}
+
+// WithMQTTSNConfig 设置 MQTT-SN 网关选项
+func WithMQTTSNConfig(config conf.MQTTSNConfig) Option {
+	return func(s *BaseServer) {
+		s.mqttsnConfig = config
+	}
+}
+
+// WithSemtechConfig 设置 Semtech UDP 转发协议选项
+func WithSemtechConfig(config conf.SemtechConfig) Option {
+	return func(s *BaseServer) {
+		s.semtechConfig = config
+	}
+}
+
+// WithLwM2MConfig 设置 LwM2M 服务器选项
+func WithLwM2MConfig(config conf.LwM2MConfig) Option {
+	return func(s *BaseServer) {
+		s.lwm2mConfig = config
+	}
+}
+
+// WithSNMPConfig 设置 SNMP 管理端选项
+func WithSNMPConfig(config conf.SNMPConfig) Option {
+	return func(s *BaseServer) {
+		s.snmpConfig = config
+	}
+}
+
+// WithBACnetConfig 设置 BACnet/IP 客户端选项
+func WithBACnetConfig(config conf.BACnetConfig) Option {
+	return func(s *BaseServer) {
+		s.bacnetConfig = config
+	}
+}
+
+// WithDetectConfig 设置协议识别选项
+func WithDetectConfig(config conf.DetectConfig) Option {
+	return func(s *BaseServer) {
+		s.detectConfig = config
+	}
+}
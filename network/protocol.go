@@ -8,3 +8,12 @@ type ProtocolHandler interface {
 	Encode(device *model.Device, data interface{}, param ...string) ([]byte, error)
 	Decode(device *model.Device, data []byte) ([]byte, error)
 }
+
+// SessionHandler 协议处理器可选实现的接口，在 TCP 连接建立和断开时调用
+// 用于连接建立后需要主动握手、或需要按连接维护会话状态和定时器的协议(如 IEC 104)
+type SessionHandler interface {
+	// OnConnect 在连接建立、设备绑定之后，读取数据之前调用，返回错误时断开连接
+	OnConnect(device *model.Device) error
+	// OnDisconnect 在连接断开时调用，之后不会再收到该连接的数据
+	OnDisconnect(device *model.Device)
+}
@@ -0,0 +1,23 @@
+package network
+
+import "sync"
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   = make(map[string]ProtocolHandler)
+)
+
+// RegisterProtocol 按名称注册协议处理器，供监听配置中的 protocol 引用，重复注册时覆盖
+func RegisterProtocol(name string, handler ProtocolHandler) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	protocols[name] = handler
+}
+
+// GetProtocol 按名称获取已注册的协议处理器
+func GetProtocol(name string) (ProtocolHandler, bool) {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	handler, ok := protocols[name]
+	return handler, ok
+}
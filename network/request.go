@@ -0,0 +1,164 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// ErrRequestKeyConflict 同一设备上相同关联键的请求仍在等待响应
+var ErrRequestKeyConflict = errors.New("相同关联键的请求正在等待响应")
+
+// RequestKeyProvider 协议处理器可选实现的接口，返回下发数据的关联键(如序列号、功能码)
+// 设备的响应在 Decode 中通过 Respond 按相同的关联键交给等待的请求；未实现时关联键为空字符串，同一设备的请求串行执行
+type RequestKeyProvider interface {
+	RequestKey(device *model.Device, data interface{}, param ...string) string
+}
+
+// RequestPipeliner 协议处理器可选实现的接口，返回同一设备允许同时等待响应的请求数
+// 未实现或未实现 RequestKeyProvider 时为 1，即同一设备的请求串行执行
+type RequestPipeliner interface {
+	MaxInflight() int
+}
+
+// SlaveAddresser 协议处理器可选实现的接口，为经总线连接(RS-485 串口、DTU)下发给子设备的数据补充从站地址
+// slave 为从站地址或协议配置中子设备的标识(如 Modbus 的 slaves、DL/T 645 的 meters)，请求与响应仍按总线连接的设备标识关联
+type SlaveAddresser interface {
+	AddressSlave(data interface{}, slave string) (interface{}, error)
+}
+
+// Requester 按设备标识下发请求并等待响应，通常为网关，总线上的子设备经总线连接请求
+type Requester interface {
+	Request(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error)
+}
+
+// PropertySetter 网络服务器或协议处理器可选实现的接口，将平台的属性设置写入设备
+// 网关按设备标识找到设备所在的网络服务器，依次检查网络服务器与设备的协议处理器，返回的属性(写入成功的属性)回复平台
+type PropertySetter interface {
+	SetProperties(ctx context.Context, requester Requester, device *model.Device, properties map[string]interface{}) (map[string]interface{}, error)
+}
+
+// ServiceCaller 网络服务器或协议处理器可选实现的接口，执行平台的服务调用
+// Services 返回支持的服务标识，网关启动时监听这些服务调用事件，返回的数据回复平台，返回错误时回复 error
+type ServiceCaller interface {
+	Services() []string
+	CallService(ctx context.Context, requester Requester, device *model.Device, service string, params map[string]interface{}) (map[string]interface{}, error)
+}
+
+// requestQueue 一个设备的请求队列，slots 的容量为同时等待响应的请求数
+type requestQueue struct {
+	slots   chan struct{}
+	pending map[string]*PendingRequest // 关联键 -> 等待响应的请求
+	refs    int                        // 持有或等待槽位的请求数，为 0 时删除队列
+}
+
+var (
+	requestsMu    sync.Mutex
+	requestQueues = make(map[string]*requestQueue) // 设备标识 -> 请求队列
+)
+
+// PendingRequest 表示一个已登记、等待设备响应的请求
+type PendingRequest struct {
+	deviceKey string
+	key       string
+	queue     *requestQueue
+	response  chan interface{}
+	done      sync.Once
+}
+
+// BeginRequest 登记一个发往设备的请求，需要在下发数据之前调用，之后通过 Wait 等待响应，结束时必须调用 Done
+// 同一设备等待响应的请求数达到上限时阻塞，直到有请求结束或 ctx 取消
+func BeginRequest(ctx context.Context, deviceKey string, protocol ProtocolHandler, device *model.Device, data interface{}, param ...string) (*PendingRequest, error) {
+	key, maxInflight := "", 1
+	if provider, ok := protocol.(RequestKeyProvider); ok {
+		key = provider.RequestKey(device, data, param...)
+		if pipeliner, ok := protocol.(RequestPipeliner); ok && pipeliner.MaxInflight() > 1 {
+			maxInflight = pipeliner.MaxInflight()
+		}
+	}
+
+	requestsMu.Lock()
+	queue := requestQueues[deviceKey]
+	if queue == nil {
+		queue = &requestQueue{slots: make(chan struct{}, maxInflight), pending: make(map[string]*PendingRequest)}
+		requestQueues[deviceKey] = queue
+	}
+	queue.refs++
+	requestsMu.Unlock()
+
+	select {
+	case queue.slots <- struct{}{}:
+	case <-ctx.Done():
+		releaseQueue(deviceKey, queue)
+		return nil, ctx.Err()
+	}
+
+	request := &PendingRequest{deviceKey: deviceKey, key: key, queue: queue, response: make(chan interface{}, 1)}
+	requestsMu.Lock()
+	if _, ok := queue.pending[key]; ok {
+		requestsMu.Unlock()
+		<-queue.slots
+		releaseQueue(deviceKey, queue)
+		return nil, fmt.Errorf("%w: 设备 %s 关联键 %s", ErrRequestKeyConflict, deviceKey, key)
+	}
+	queue.pending[key] = request
+	requestsMu.Unlock()
+	return request, nil
+}
+
+// Wait 等待设备的响应，ctx 取消或超时时返回 ctx.Err()，响应为 error 时(如设备返回的异常响应)作为错误返回
+func (r *PendingRequest) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case response := <-r.response:
+		if err, ok := response.(error); ok {
+			return nil, err
+		}
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done 结束请求并释放槽位，之后到达的响应不再交给该请求，可以重复调用
+func (r *PendingRequest) Done() {
+	r.done.Do(func() {
+		requestsMu.Lock()
+		if r.queue.pending[r.key] == r {
+			delete(r.queue.pending, r.key)
+		}
+		requestsMu.Unlock()
+		<-r.queue.slots
+		releaseQueue(r.deviceKey, r.queue)
+	})
+}
+
+// releaseQueue 减少请求队列的引用，没有请求时删除队列
+func releaseQueue(deviceKey string, queue *requestQueue) {
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	queue.refs--
+	if queue.refs == 0 && requestQueues[deviceKey] == queue {
+		delete(requestQueues, deviceKey)
+	}
+}
+
+// Respond 将设备的响应交给关联键相同的等待请求，通常在协议处理器的 Decode 中调用，response 为 error 时请求返回该错误
+// 返回 false 表示没有匹配的请求(如请求已超时或是设备主动上报的数据)
+func Respond(deviceKey, key string, response interface{}) bool {
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	queue := requestQueues[deviceKey]
+	if queue == nil {
+		return false
+	}
+	request, ok := queue.pending[key]
+	if !ok {
+		return false
+	}
+	delete(queue.pending, key) // 重复的响应不再匹配
+	request.response <- response
+	return true
+}
@@ -0,0 +1,100 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// keyedProtocol 以下发数据作为关联键、允许流水线请求的测试用协议处理器
+type keyedProtocol struct {
+	namedProtocol
+	inflight int
+}
+
+func (p *keyedProtocol) RequestKey(device *model.Device, data interface{}, param ...string) string {
+	return data.(string)
+}
+
+func (p *keyedProtocol) MaxInflight() int {
+	return p.inflight
+}
+
+func TestRequestSerialized(t *testing.T) {
+	ctx := context.Background()
+	protocol := &namedProtocol{name: "serial"}
+	first, err := BeginRequest(ctx, "req-serial", protocol, nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 未实现 RequestKeyProvider 时同一设备的请求串行执行
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := BeginRequest(timeout, "req-serial", protocol, nil, "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("前一个请求未结束时应等待，实际 %v", err)
+	}
+
+	if !Respond("req-serial", "", "ok") {
+		t.Fatal("响应未匹配到请求")
+	}
+	if Respond("req-serial", "", "again") {
+		t.Fatal("重复的响应不应再匹配")
+	}
+	if response, err := first.Wait(ctx); err != nil || response != "ok" {
+		t.Fatalf("响应 %v %v", response, err)
+	}
+	first.Done()
+	first.Done()
+
+	second, err := BeginRequest(ctx, "req-serial", protocol, nil, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := second.Wait(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("没有响应时应超时，实际 %v", err)
+	}
+	second.Done()
+
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	if _, ok := requestQueues["req-serial"]; ok {
+		t.Fatal("请求全部结束后应删除请求队列")
+	}
+}
+
+func TestRequestPipelined(t *testing.T) {
+	ctx := context.Background()
+	protocol := &keyedProtocol{inflight: 2}
+	one, err := BeginRequest(ctx, "req-pipe", protocol, nil, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Done()
+	if _, err := BeginRequest(ctx, "req-pipe", protocol, nil, "1"); !errors.Is(err, ErrRequestKeyConflict) {
+		t.Fatalf("相同关联键应返回 ErrRequestKeyConflict，实际 %v", err)
+	}
+	two, err := BeginRequest(ctx, "req-pipe", protocol, nil, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Done()
+
+	// 响应乱序到达时按关联键交给各自的请求
+	Respond("req-pipe", "2", "second")
+	Respond("req-pipe", "1", "first")
+	if response, _ := one.Wait(ctx); response != "first" {
+		t.Fatalf("请求 1 收到 %v", response)
+	}
+	if response, _ := two.Wait(ctx); response != "second" {
+		t.Fatalf("请求 2 收到 %v", response)
+	}
+	if Respond("req-other", "1", "x") {
+		t.Fatal("其他设备的响应不应匹配")
+	}
+}
@@ -0,0 +1,504 @@
+package network
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/semtech"
+)
+
+const (
+	semtechMaxDatagramSize = 64 * 1024
+	semtechScheduleMargin  = 100 * time.Millisecond // PULL_RESP 需要在接收窗口前到达网关
+	semtechRX2Delay        = time.Second            // RX2 窗口在 RX1 窗口后 1 秒
+	semtechPendingTimeout  = 10 * time.Second       // 等待 TX_ACK 的时间
+)
+
+// SemtechUDPServer 结构体表示 LoRa 网关 Semtech UDP 转发协议(packet forwarder)服务器
+// 网关转发的上行 PHYPayload 与射频参数以 semtech.Uplink 的 JSON 交给协议处理器，入网请求以 DevEUI、数据帧以 DevAddr 作为设备标识。
+// 多个网关收到的同一上行在去重窗口内合并，下发经信号最好的网关在 Class A 接收窗口发送
+type SemtechUDPServer struct {
+	*BaseServer
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	gateways  map[string]*semtechGateway  // 网关 EUI -> 网关
+	terminals map[string]*semtechTerminal // 设备标识 -> 终端
+	uplinks   map[string]*semtechUplink   // PHYPayload -> 去重窗口内的上行
+	pending   map[uint16]semtechDownlink  // PULL_RESP 令牌 -> 等待 TX_ACK 的下发
+	token     uint16
+}
+
+// semtechGateway 表示一个 LoRa 网关
+type semtechGateway struct {
+	addr    *net.UDPAddr // 最近一次 PULL_DATA 的地址，下发数据发往该地址
+	version byte
+}
+
+// semtechTerminal 表示一个 LoRa 终端最近一次上行的接收信息与缓存的下发数据
+type semtechTerminal struct {
+	device   *model.Device // 最近一次上行的设备，离线期间下发仍可缓存
+	gateway  string
+	rxpk     semtech.RXPK
+	mtype    byte // 最近一次上行的消息类型，入网请求的接收窗口使用入网接受的延时
+	received time.Time
+	used     bool     // 本次上行的接收窗口已安排下发
+	queue    [][]byte // 错过接收窗口的下发 PHYPayload
+}
+
+// semtechUplink 表示去重窗口内的一个上行
+type semtechUplink struct {
+	gateway  string
+	rxpk     semtech.RXPK
+	received time.Time
+}
+
+// semtechDownlink 表示一个等待 TX_ACK 的下发
+type semtechDownlink struct {
+	key     string
+	payload []byte
+	sent    time.Time
+}
+
+// NewSemtechUDPServer 创建一个新的 Semtech UDP 转发协议服务器实例
+func NewSemtechUDPServer(options ...Option) NetworkServer {
+	s := &SemtechUDPServer{
+		BaseServer: NewBaseServer(options...),
+		gateways:   make(map[string]*semtechGateway),
+		terminals:  make(map[string]*semtechTerminal),
+		uplinks:    make(map[string]*semtechUplink),
+		pending:    make(map[uint16]semtechDownlink),
+	}
+	return s
+}
+
+// Start 启动 Semtech UDP 转发协议服务器
+func (s *SemtechUDPServer) Start(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":1700"
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("解析 UDP 地址失败: %v", err)
+	}
+	s.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("Semtech UDP 监听失败: %v", err)
+	}
+
+	go s.cleanupInactiveDevices(ctx)
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	buffer := make([]byte, semtechMaxDatagramSize)
+	for {
+		n, remoteAddr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil // 正常关闭
+			}
+			glog.Debugf(context.Background(), "读取 Semtech UDP 数据失败: %v", err)
+			continue
+		}
+		packet, err := semtech.Unmarshal(append([]byte(nil), buffer[:n]...))
+		if err != nil {
+			glog.Debugf(context.Background(), "解析 Semtech UDP 报文失败 %s: %v\n", remoteAddr, err)
+			continue
+		}
+		s.handlePacket(remoteAddr, packet)
+	}
+}
+
+// Stop 停止 Semtech UDP 转发协议服务器
+func (s *SemtechUDPServer) Stop() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// SendData 向终端下发数据，数据编码后作为 PHYPayload 在最近一次上行的接收窗口发送，错过窗口时缓存到下一次上行
+// 数据为 semtech.TXPK 时不做调度，原样经最近一次收到终端上行的网关发送
+func (s *SemtechUDPServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	switch txpk := data.(type) {
+	case semtech.TXPK:
+		return s.sendTXPK(device.DeviceKey, txpk, nil)
+	case *semtech.TXPK:
+		return s.sendTXPK(device.DeviceKey, *txpk, nil)
+	}
+
+	var payload []byte
+	var err error
+	if s.protocolHandler != nil {
+		payload, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else if payload, err = semtechPayload(data); err != nil {
+		return fmt.Errorf("编码数据失败: %w", err)
+	}
+	if len(payload) == 0 {
+		return errors.New("下发的 PHYPayload 为空")
+	}
+
+	s.mu.Lock()
+	terminal := s.terminals[device.DeviceKey]
+	if terminal == nil {
+		s.mu.Unlock()
+		return ErrDeviceOffline
+	}
+	txpk, ok := s.classATXPK(terminal, payload)
+	if !ok {
+		s.enqueue(device.DeviceKey, terminal, payload)
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	return s.sendTXPK(device.DeviceKey, txpk, payload)
+}
+
+// LookupDevice 按设备标识查找终端，Class A 终端离线期间仍返回最近一次上行的设备，下发数据缓存到下一次上行
+func (s *SemtechUDPServer) LookupDevice(deviceKey string) *model.Device {
+	if device := s.BaseServer.LookupDevice(deviceKey); device != nil {
+		return device
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if terminal := s.terminals[deviceKey]; terminal != nil {
+		return terminal.device
+	}
+	return nil
+}
+
+// handlePacket 按报文类型处理网关的报文
+func (s *SemtechUDPServer) handlePacket(addr *net.UDPAddr, packet *semtech.Packet) {
+	switch packet.Type {
+	case semtech.PushData:
+		s.send(addr, &semtech.Packet{Version: packet.Version, Token: packet.Token, Type: semtech.PushAck})
+		s.handlePushData(packet)
+	case semtech.PullData:
+		s.mu.Lock()
+		s.gateways[packet.GatewayEUI] = &semtechGateway{addr: addr, version: packet.Version}
+		s.mu.Unlock()
+		s.send(addr, &semtech.Packet{Version: packet.Version, Token: packet.Token, Type: semtech.PullAck})
+	case semtech.TxAck:
+		s.handleTxAck(packet)
+	default:
+		glog.Debugf(context.Background(), "忽略 Semtech UDP 报文类型 0x%02X %s\n", packet.Type, addr)
+	}
+}
+
+// handlePushData 处理网关上报的数据，上行在去重窗口结束后交给协议处理器
+func (s *SemtechUDPServer) handlePushData(packet *semtech.Packet) {
+	var payload semtech.PushDataPayload
+	if err := json.Unmarshal(packet.Payload, &payload); err != nil {
+		glog.Debugf(context.Background(), "解析网关 %s 的 PUSH_DATA 失败: %v\n", packet.GatewayEUI, err)
+		return
+	}
+	if payload.Stat != nil {
+		glog.Debugf(context.Background(), "网关 %s 状态: 接收 %d, 转发 %d, 发射 %d\n",
+			packet.GatewayEUI, payload.Stat.RXNb, payload.Stat.RXFW, payload.Stat.TXNb)
+	}
+
+	now := time.Now()
+	for _, rxpk := range payload.RXPK {
+		if rxpk.Stat == -1 {
+			continue // CRC 错误
+		}
+		s.mu.Lock()
+		if uplink, ok := s.uplinks[rxpk.Data]; ok {
+			if now.Sub(uplink.received) <= s.dedupWindow() && rxpk.LSNR > uplink.rxpk.LSNR {
+				uplink.gateway, uplink.rxpk = packet.GatewayEUI, rxpk // 选择信噪比最好的网关
+			}
+			s.mu.Unlock()
+			continue
+		}
+		s.uplinks[rxpk.Data] = &semtechUplink{gateway: packet.GatewayEUI, rxpk: rxpk, received: now}
+		s.mu.Unlock()
+
+		phy := rxpk.Data
+		time.AfterFunc(s.dedupWindow(), func() { s.handleUplink(phy) })
+	}
+}
+
+// handleUplink 去重窗口结束后处理上行，Decode 没有回复时发送缓存的下发数据
+// 去重记录随即删除，之后收到的相同 PHYPayload(如未收到确认的确认帧重传)作为新的上行处理
+func (s *SemtechUDPServer) handleUplink(phy string) {
+	s.mu.Lock()
+	pending := s.uplinks[phy]
+	var uplink semtechUplink
+	if pending != nil {
+		uplink = *pending
+	}
+	delete(s.uplinks, phy)
+	s.mu.Unlock()
+	if pending == nil {
+		return
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(uplink.rxpk.Data)
+	if err != nil {
+		glog.Debugf(context.Background(), "网关 %s 上行的数据不是 Base64: %v\n", uplink.gateway, err)
+		return
+	}
+	identity, err := semtech.ParseIdentity(payload)
+	if err != nil {
+		glog.Debugf(context.Background(), "网关 %s 上行的 PHYPayload 无法识别: %v\n", uplink.gateway, err)
+		return
+	}
+	key := identity.Key()
+
+	s.mu.Lock()
+	terminal := s.terminals[key]
+	if terminal == nil {
+		terminal = &semtechTerminal{}
+		s.terminals[key] = terminal
+	}
+	terminal.gateway, terminal.rxpk, terminal.received, terminal.used = uplink.gateway, uplink.rxpk, uplink.received, false
+	terminal.mtype = identity.MType
+	s.mu.Unlock()
+
+	device := s.getDevice(key)
+	if device == nil {
+		device = s.handleConnect(key, nil)
+		s.bindDevice(device, key)
+	}
+	device.LastActive = time.Now()
+	s.mu.Lock()
+	terminal.device = device
+	s.mu.Unlock()
+
+	data, err := json.Marshal(semtech.Uplink{
+		MType:      identity.MType,
+		DevEUI:     identity.DevEUI,
+		JoinEUI:    identity.JoinEUI,
+		DevAddr:    identity.DevAddr,
+		FCnt:       identity.FCnt,
+		PHYPayload: uplink.rxpk.Data,
+		GatewayEUI: uplink.gateway,
+		Time:       uplink.rxpk.Time,
+		Tmst:       uplink.rxpk.Tmst,
+		Frequency:  uplink.rxpk.Freq,
+		DataRate:   uplink.rxpk.DatR.String(),
+		CodingRate: uplink.rxpk.CodR,
+		RSSI:       uplink.rxpk.RSSI,
+		SNR:        uplink.rxpk.LSNR,
+		Channel:    uplink.rxpk.Chan,
+		RFChain:    uplink.rxpk.RFCh,
+	})
+	if err != nil {
+		glog.Debugf(context.Background(), "编码终端 %s 的上行数据失败: %v\n", key, err)
+		return
+	}
+	resData, err := s.handleReceiveData(device, data)
+	if err != nil {
+		glog.Debugf(context.Background(), "处理终端 %s 数据错误: %v\n", key, err)
+	}
+	if resData != nil {
+		if err := s.SendData(device, resData); err != nil {
+			glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	var txpk semtech.TXPK
+	var queued []byte
+	ok := len(terminal.queue) > 0
+	if ok {
+		queued = terminal.queue[0]
+		if txpk, ok = s.classATXPK(terminal, queued); ok {
+			terminal.queue = terminal.queue[1:]
+		}
+	}
+	s.mu.Unlock()
+	if ok {
+		if err := s.sendTXPK(key, txpk, queued); err != nil {
+			glog.Debugf(context.Background(), "发送终端 %s 缓存的下发数据失败: %v\n", key, err)
+		}
+	}
+}
+
+// handleTxAck 处理网关对下发的确认，错过发送时间或冲突的下发重新缓存到下一次上行
+func (s *SemtechUDPServer) handleTxAck(packet *semtech.Packet) {
+	var ack semtech.TxAckPayload
+	if len(packet.Payload) > 0 {
+		if err := json.Unmarshal(packet.Payload, &ack); err != nil {
+			glog.Debugf(context.Background(), "解析网关 %s 的 TX_ACK 失败: %v\n", packet.GatewayEUI, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	downlink, ok := s.pending[packet.Token]
+	delete(s.pending, packet.Token)
+	result := ack.TXPKAck.Error
+	if !ok || result == "" || result == semtech.TxAckNone {
+		return
+	}
+	glog.Debugf(context.Background(), "网关 %s 未能向终端 %s 发送下发数据: %s\n", packet.GatewayEUI, downlink.key, result)
+	switch result {
+	case semtech.TxAckTooLate, semtech.TxAckTooEarly, semtech.TxAckCollision:
+		if terminal := s.terminals[downlink.key]; terminal != nil && downlink.payload != nil {
+			terminal.queue = append([][]byte{downlink.payload}, terminal.queue...)
+		}
+	}
+}
+
+// classATXPK 生成终端最近一次上行的 RX1 或 RX2 窗口的下发，窗口已错过或已使用时返回 false，调用方持有 s.mu
+// 入网请求后的窗口使用入网接受的延时，其他上行使用 RX1 的延时
+func (s *SemtechUDPServer) classATXPK(terminal *semtechTerminal, payload []byte) (semtech.TXPK, bool) {
+	if terminal.used {
+		return semtech.TXPK{}, false
+	}
+	txpk := semtech.TXPK{
+		Powe: s.txPower(),
+		Modu: terminal.rxpk.Modu,
+		CodR: terminal.rxpk.CodR,
+		IPol: true,
+		Size: len(payload),
+		Data: base64.StdEncoding.EncodeToString(payload),
+	}
+	rx1, rx2 := s.rx1Delay(), s.rx1Delay()+semtechRX2Delay
+	if terminal.mtype == semtech.MTypeJoinRequest {
+		rx1, rx2 = s.joinAcceptDelay1(), s.joinAcceptDelay2()
+	}
+	elapsed := time.Since(terminal.received) + semtechScheduleMargin
+	switch {
+	case elapsed < rx1:
+		txpk.Tmst = terminal.rxpk.Tmst + uint32(rx1/time.Microsecond)
+		txpk.Freq, txpk.DatR = terminal.rxpk.Freq, terminal.rxpk.DatR
+	case s.semtechConfig.RX2Frequency > 0 && elapsed < rx2:
+		txpk.Tmst = terminal.rxpk.Tmst + uint32(rx2/time.Microsecond)
+		txpk.Freq, txpk.DatR = s.semtechConfig.RX2Frequency, semtech.DataRate{LoRa: s.semtechConfig.RX2DataRate}
+	default:
+		return semtech.TXPK{}, false
+	}
+	terminal.used = true
+	return txpk, true
+}
+
+// sendTXPK 经最近一次收到终端上行的网关发送 PULL_RESP，payload 不为空时在网关拒绝发送后重新缓存
+func (s *SemtechUDPServer) sendTXPK(key string, txpk semtech.TXPK, payload []byte) error {
+	data, err := json.Marshal(semtech.PullRespPayload{TXPK: txpk})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	terminal := s.terminals[key]
+	if terminal == nil {
+		s.mu.Unlock()
+		return ErrDeviceOffline
+	}
+	gateway := s.gateways[terminal.gateway]
+	if gateway == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("网关 %s 未发送 PULL_DATA，无法下发", terminal.gateway)
+	}
+	now := time.Now()
+	for token, downlink := range s.pending {
+		if now.Sub(downlink.sent) > semtechPendingTimeout {
+			delete(s.pending, token)
+		}
+	}
+	s.token++
+	token := s.token
+	s.pending[token] = semtechDownlink{key: key, payload: payload, sent: now}
+	addr, version := gateway.addr, gateway.version
+	s.mu.Unlock()
+
+	return s.send(addr, &semtech.Packet{Version: version, Token: token, Type: semtech.PullResp, Payload: data})
+}
+
+// enqueue 缓存错过接收窗口的下发数据，超出上限时丢弃最早的数据，调用方持有 s.mu
+func (s *SemtechUDPServer) enqueue(key string, terminal *semtechTerminal, payload []byte) {
+	terminal.queue = append(terminal.queue, payload)
+	if len(terminal.queue) > s.maxQueueSize() {
+		glog.Debugf(context.Background(), "终端 %s 下发队列已满，丢弃最早的 %d 条数据", key, len(terminal.queue)-s.maxQueueSize())
+		terminal.queue = terminal.queue[len(terminal.queue)-s.maxQueueSize():]
+	}
+}
+
+// send 编码并发送报文
+func (s *SemtechUDPServer) send(addr *net.UDPAddr, packet *semtech.Packet) error {
+	data, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.WriteToUDP(data, addr); err != nil {
+		glog.Debugf(context.Background(), "发送 Semtech UDP 报文失败 %s: %v\n", addr, err)
+		return err
+	}
+	return nil
+}
+
+// rx1Delay 获取 RX1 窗口的延时
+func (s *SemtechUDPServer) rx1Delay() time.Duration {
+	if s.semtechConfig.RX1Delay <= 0 {
+		return time.Second
+	}
+	return s.semtechConfig.RX1Delay
+}
+
+// joinAcceptDelay1 获取入网接受 RX1 窗口的延时
+func (s *SemtechUDPServer) joinAcceptDelay1() time.Duration {
+	if s.semtechConfig.JoinAcceptDelay1 <= 0 {
+		return 5 * time.Second
+	}
+	return s.semtechConfig.JoinAcceptDelay1
+}
+
+// joinAcceptDelay2 获取入网接受 RX2 窗口的延时
+func (s *SemtechUDPServer) joinAcceptDelay2() time.Duration {
+	if s.semtechConfig.JoinAcceptDelay2 <= 0 {
+		return s.joinAcceptDelay1() + semtechRX2Delay
+	}
+	return s.semtechConfig.JoinAcceptDelay2
+}
+
+// txPower 获取下发的发射功率
+func (s *SemtechUDPServer) txPower() int {
+	if s.semtechConfig.TxPower <= 0 {
+		return 14
+	}
+	return s.semtechConfig.TxPower
+}
+
+// dedupWindow 获取上行的去重时间窗口
+func (s *SemtechUDPServer) dedupWindow() time.Duration {
+	if s.semtechConfig.DedupWindow <= 0 {
+		return 200 * time.Millisecond
+	}
+	return s.semtechConfig.DedupWindow
+}
+
+// maxQueueSize 获取每个终端缓存的下发数据数上限
+func (s *SemtechUDPServer) maxQueueSize() int {
+	if s.semtechConfig.MaxQueueSize <= 0 {
+		return 10
+	}
+	return s.semtechConfig.MaxQueueSize
+}
+
+// semtechPayload 未设置协议处理器时，[]byte 与 string 作为 PHYPayload 原样下发
+func semtechPayload(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("未设置协议处理器时不支持下发 %T 类型的数据", data)
+}
@@ -0,0 +1,105 @@
+package semtech
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// LoRaWAN 消息类型(MHDR 中的 MType)
+const (
+	MTypeJoinRequest     byte = 0x00
+	MTypeJoinAccept      byte = 0x01
+	MTypeUnconfirmedUp   byte = 0x02
+	MTypeUnconfirmedDown byte = 0x03
+	MTypeConfirmedUp     byte = 0x04
+	MTypeConfirmedDown   byte = 0x05
+	MTypeRejoinRequest   byte = 0x06
+	MTypeProprietary     byte = 0x07
+)
+
+const (
+	joinRequestLength = 23 // MHDR + JoinEUI + DevEUI + DevNonce + MIC
+	dataFrameLength   = 12 // MHDR + FHDR 最小长度 + MIC
+)
+
+// ErrInvalidPHYPayload LoRaWAN PHYPayload 格式错误
+var ErrInvalidPHYPayload = errors.New("LoRaWAN PHYPayload 格式错误")
+
+// Identity 从 LoRaWAN PHYPayload 未加密的部分获取的终端标识
+type Identity struct {
+	MType   byte
+	DevEUI  string // 入网请求、重入网请求
+	JoinEUI string // 入网请求、类型 1 的重入网请求
+	DevAddr string // 上行数据帧
+	FCnt    uint16 // 上行数据帧的帧计数低 16 位
+}
+
+// Key 终端的设备标识，入网请求为 DevEUI，数据帧为 DevAddr
+func (i Identity) Key() string {
+	if i.DevEUI != "" {
+		return i.DevEUI
+	}
+	return i.DevAddr
+}
+
+// ParseIdentity 解析上行 PHYPayload 中的终端标识，下行消息与私有消息返回错误
+func ParseIdentity(phy []byte) (Identity, error) {
+	if len(phy) == 0 {
+		return Identity{}, ErrInvalidPHYPayload
+	}
+	id := Identity{MType: phy[0] >> 5}
+	switch id.MType {
+	case MTypeJoinRequest:
+		if len(phy) != joinRequestLength {
+			return Identity{}, ErrInvalidPHYPayload
+		}
+		id.JoinEUI, id.DevEUI = littleEndianEUI(phy[1:9]), littleEndianEUI(phy[9:17])
+	case MTypeUnconfirmedUp, MTypeConfirmedUp:
+		if len(phy) < dataFrameLength {
+			return Identity{}, ErrInvalidPHYPayload
+		}
+		id.DevAddr = fmt.Sprintf("%08X", binary.LittleEndian.Uint32(phy[1:5]))
+		id.FCnt = binary.LittleEndian.Uint16(phy[6:8])
+	case MTypeRejoinRequest:
+		switch {
+		case len(phy) == 19 && (phy[1] == 0 || phy[1] == 2): // 类型 0/2:NetID + DevEUI
+			id.DevEUI = littleEndianEUI(phy[5:13])
+		case len(phy) == 24 && phy[1] == 1: // 类型 1:JoinEUI + DevEUI
+			id.JoinEUI, id.DevEUI = littleEndianEUI(phy[2:10]), littleEndianEUI(phy[10:18])
+		default:
+			return Identity{}, ErrInvalidPHYPayload
+		}
+	default:
+		return Identity{}, fmt.Errorf("%w: 不是上行消息,MType %d", ErrInvalidPHYPayload, id.MType)
+	}
+	return id, nil
+}
+
+// littleEndianEUI 将报文中小端序的 EUI 转换为十六进制字符串
+func littleEndianEUI(data []byte) string {
+	eui := slices.Clone(data)
+	slices.Reverse(eui)
+	return FormatEUI(eui)
+}
+
+// Uplink 交给协议处理器的上行数据，以 JSON 编码作为 Decode 的数据
+type Uplink struct {
+	MType      byte    `json:"mType"`
+	DevEUI     string  `json:"devEui,omitempty"`
+	JoinEUI    string  `json:"joinEui,omitempty"`
+	DevAddr    string  `json:"devAddr,omitempty"`
+	FCnt       uint16  `json:"fCnt,omitempty"`
+	PHYPayload string  `json:"phyPayload"` // Base64 编码的 PHYPayload
+	GatewayEUI string  `json:"gatewayEui"` // 接收的网关,多个网关收到时为信号最好的网关
+	Time       string  `json:"time,omitempty"`
+	Tmst       uint32  `json:"tmst"`
+	Frequency  float64 `json:"frequency"` // MHz
+	DataRate   string  `json:"dataRate"`
+	CodingRate string  `json:"codingRate,omitempty"`
+	RSSI       int     `json:"rssi"` // dBm
+	SNR        float64 `json:"snr"`  // dB
+	Channel    int     `json:"channel"`
+	RFChain    int     `json:"rfChain"`
+}
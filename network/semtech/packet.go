@@ -0,0 +1,214 @@
+// Package semtech 实现 LoRa 网关 Semtech UDP 转发协议(packet forwarder)报文的编解码
+package semtech
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 报文类型
+const (
+	PushData byte = 0x00 // 网关上报接收到的数据与状态
+	PushAck  byte = 0x01
+	PullData byte = 0x02 // 网关定时发送，用于建立下行通道
+	PullResp byte = 0x03 // 下发数据
+	PullAck  byte = 0x04
+	TxAck    byte = 0x05 // 网关对 PULL_RESP 的确认
+)
+
+// 协议版本
+const (
+	Version1 byte = 0x01
+	Version2 byte = 0x02
+)
+
+// TX_ACK 中的错误
+const (
+	TxAckNone      = "NONE"
+	TxAckTooLate   = "TOO_LATE"
+	TxAckTooEarly  = "TOO_EARLY"
+	TxAckCollision = "COLLISION_PACKET"
+)
+
+const (
+	headerLength = 4 // 版本、令牌、类型
+	euiLength    = 8
+)
+
+// ErrInvalidPacket 报文格式错误
+var ErrInvalidPacket = errors.New("Semtech UDP 报文格式错误")
+
+// Packet Semtech UDP 转发协议报文
+type Packet struct {
+	Version    byte
+	Token      uint16
+	Type       byte
+	GatewayEUI string // PUSH_DATA、PULL_DATA、TX_ACK,16 位十六进制
+	Payload    []byte // PUSH_DATA、PULL_RESP、TX_ACK 的 JSON 数据
+}
+
+// Marshal 编码报文
+func (p *Packet) Marshal() ([]byte, error) {
+	data := binary.BigEndian.AppendUint16([]byte{p.Version}, p.Token)
+	data = append(data, p.Type)
+	switch p.Type {
+	case PushData, PullData, TxAck:
+		eui, err := ParseEUI(p.GatewayEUI)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, eui...)
+		if p.Type != PullData {
+			data = append(data, p.Payload...)
+		}
+	case PullResp:
+		data = append(data, p.Payload...)
+	case PushAck, PullAck:
+	default:
+		return nil, fmt.Errorf("不支持的 Semtech UDP 报文类型 0x%02X", p.Type)
+	}
+	return data, nil
+}
+
+// Unmarshal 解码一个完整的报文
+func Unmarshal(data []byte) (*Packet, error) {
+	if len(data) < headerLength || (data[0] != Version1 && data[0] != Version2) {
+		return nil, ErrInvalidPacket
+	}
+	p := &Packet{Version: data[0], Token: binary.BigEndian.Uint16(data[1:]), Type: data[3]}
+	body := data[headerLength:]
+	switch p.Type {
+	case PushData, PullData, TxAck:
+		if len(body) < euiLength {
+			return nil, fmt.Errorf("%w: 类型 0x%02X 长度 %d", ErrInvalidPacket, p.Type, len(data))
+		}
+		p.GatewayEUI = FormatEUI(body[:euiLength])
+		if p.Type != PullData && len(body) > euiLength {
+			p.Payload = body[euiLength:]
+		}
+	case PullResp:
+		p.Payload = body
+	case PushAck, PullAck:
+	default:
+		return nil, fmt.Errorf("不支持的 Semtech UDP 报文类型 0x%02X", p.Type)
+	}
+	return p, nil
+}
+
+// PushDataPayload PUSH_DATA 的 JSON 数据
+type PushDataPayload struct {
+	RXPK []RXPK `json:"rxpk,omitempty"`
+	Stat *Stat  `json:"stat,omitempty"`
+}
+
+// RXPK 网关接收到的一个射频数据包
+type RXPK struct {
+	Time string   `json:"time,omitempty"` // UTC 接收时间
+	Tmst uint32   `json:"tmst"`           // 接收完成时网关的内部计时,微秒
+	Chan int      `json:"chan"`           // 中频信道
+	RFCh int      `json:"rfch"`           // 射频链
+	Freq float64  `json:"freq"`           // 频率,MHz
+	Stat int      `json:"stat"`           // CRC 状态,1 正确,-1 错误,0 无 CRC
+	Modu string   `json:"modu"`           // 调制方式,LORA/FSK
+	DatR DataRate `json:"datr"`           // 速率
+	CodR string   `json:"codr,omitempty"` // LoRa 编码率
+	RSSI int      `json:"rssi"`           // 信号强度,dBm
+	LSNR float64  `json:"lsnr"`           // LoRa 信噪比,dB
+	Size int      `json:"size"`           // 数据长度
+	Data string   `json:"data"`           // Base64 编码的 PHYPayload
+}
+
+// Stat 网关状态
+type Stat struct {
+	Time string  `json:"time"`
+	Lati float64 `json:"lati,omitempty"`
+	Long float64 `json:"long,omitempty"`
+	Alti int     `json:"alti,omitempty"`
+	RXNb int     `json:"rxnb"` // 接收的数据包数
+	RXOK int     `json:"rxok"` // CRC 正确的数据包数
+	RXFW int     `json:"rxfw"` // 转发的数据包数
+	ACKR float64 `json:"ackr"` // 上行数据得到确认的百分比
+	DWNb int     `json:"dwnb"` // 收到的下发数据包数
+	TXNb int     `json:"txnb"` // 发射的数据包数
+}
+
+// PullRespPayload PULL_RESP 的 JSON 数据
+type PullRespPayload struct {
+	TXPK TXPK `json:"txpk"`
+}
+
+// TXPK 网关发射的一个射频数据包
+type TXPK struct {
+	Imme bool     `json:"imme,omitempty"` // 立即发送,忽略 Tmst
+	Tmst uint32   `json:"tmst,omitempty"` // 按网关的内部计时发送,微秒
+	Freq float64  `json:"freq"`           // 频率,MHz
+	RFCh int      `json:"rfch"`           // 射频链
+	Powe int      `json:"powe"`           // 发射功率,dBm
+	Modu string   `json:"modu"`           // 调制方式,LORA/FSK
+	DatR DataRate `json:"datr"`           // 速率
+	CodR string   `json:"codr,omitempty"` // LoRa 编码率
+	FDev int      `json:"fdev,omitempty"` // FSK 频偏,Hz
+	IPol bool     `json:"ipol"`           // LoRa 极性反转,下发给终端时为 true
+	Prea int      `json:"prea,omitempty"` // 前导码长度
+	Size int      `json:"size"`           // 数据长度
+	Data string   `json:"data"`           // Base64 编码的 PHYPayload
+	NCRC bool     `json:"ncrc,omitempty"` // 不附加 CRC
+}
+
+// TxAckPayload TX_ACK 的 JSON 数据，协议版本 1 没有数据
+type TxAckPayload struct {
+	TXPKAck struct {
+		Error string `json:"error,omitempty"`
+		Warn  string `json:"warn,omitempty"`
+	} `json:"txpk_ack"`
+}
+
+// DataRate 速率，LoRa 为 SF7BW125 形式的字符串，FSK 为比特率
+type DataRate struct {
+	LoRa string
+	FSK  uint32
+}
+
+// String 返回速率的字符串形式
+func (d DataRate) String() string {
+	if d.LoRa != "" {
+		return d.LoRa
+	}
+	return strconv.FormatUint(uint64(d.FSK), 10)
+}
+
+// MarshalJSON 实现 json.Marshaler 接口
+func (d DataRate) MarshalJSON() ([]byte, error) {
+	if d.LoRa != "" {
+		return json.Marshal(d.LoRa)
+	}
+	return json.Marshal(d.FSK)
+}
+
+// UnmarshalJSON 实现 json.Unmarshaler 接口
+func (d *DataRate) UnmarshalJSON(data []byte) error {
+	*d = DataRate{}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &d.LoRa)
+	}
+	return json.Unmarshal(data, &d.FSK)
+}
+
+// FormatEUI 将 8 字节的 EUI 转换为大写的十六进制字符串
+func FormatEUI(eui []byte) string {
+	return strings.ToUpper(hex.EncodeToString(eui))
+}
+
+// ParseEUI 将 16 位十六进制字符串转换为 8 字节的 EUI
+func ParseEUI(eui string) ([]byte, error) {
+	data, err := hex.DecodeString(eui)
+	if err != nil || len(data) != euiLength {
+		return nil, fmt.Errorf("EUI %s 格式错误", eui)
+	}
+	return data, nil
+}
@@ -0,0 +1,115 @@
+package semtech
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	packets := []*Packet{
+		{Version: Version2, Token: 0x1234, Type: PushData, GatewayEUI: "B827EBFFFE123456", Payload: []byte(`{"stat":{}}`)},
+		{Version: Version2, Token: 0x1234, Type: PushAck},
+		{Version: Version2, Token: 0x5678, Type: PullData, GatewayEUI: "B827EBFFFE123456"},
+		{Version: Version2, Token: 0x5678, Type: PullAck},
+		{Version: Version2, Token: 0x0001, Type: PullResp, Payload: []byte(`{"txpk":{}}`)},
+		{Version: Version2, Token: 0x0001, Type: TxAck, GatewayEUI: "B827EBFFFE123456", Payload: []byte(`{"txpk_ack":{"error":"NONE"}}`)},
+		{Version: Version1, Token: 0x0001, Type: TxAck, GatewayEUI: "B827EBFFFE123456"},
+	}
+	for _, p := range packets {
+		data, err := p.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Fatalf("类型 0x%02X 解码结果 %+v, 期望 %+v", p.Type, got, p)
+		}
+	}
+
+	data, _ := (&Packet{Version: Version2, Token: 0xABCD, Type: PullData, GatewayEUI: "0102030405060708"}).Marshal()
+	if want := []byte{0x02, 0xAB, 0xCD, 0x02, 1, 2, 3, 4, 5, 6, 7, 8}; !bytes.Equal(data, want) {
+		t.Fatalf("PULL_DATA 编码 % X", data)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x02, 0x00},
+		{0x03, 0x00, 0x00, PushAck},              // 不支持的版本
+		{0x02, 0x00, 0x00, PullData, 0x01, 0x02}, // 缺少网关 EUI
+		{0x02, 0x00, 0x00, 0x09},
+	} {
+		if _, err := Unmarshal(data); err == nil {
+			t.Fatalf("% X 应解码失败", data)
+		}
+	}
+	if _, err := (&Packet{Version: Version2, Type: PullData, GatewayEUI: "0102"}).Marshal(); err == nil {
+		t.Fatal("EUI 长度错误时应编码失败")
+	}
+}
+
+func TestDataRateJSON(t *testing.T) {
+	var payload PushDataPayload
+	data := `{"rxpk":[{"tmst":1000,"freq":868.1,"stat":1,"modu":"LORA","datr":"SF7BW125","codr":"4/5","rssi":-60,"lsnr":7.5,"size":2,"data":"QAE="},` +
+		`{"tmst":2000,"freq":868.8,"stat":1,"modu":"FSK","datr":50000,"rssi":-70,"size":2,"data":"QAE="}]}`
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.RXPK[0].DatR.String() != "SF7BW125" || payload.RXPK[1].DatR.String() != "50000" || payload.RXPK[0].LSNR != 7.5 {
+		t.Fatalf("解析 rxpk %+v", payload.RXPK)
+	}
+	encoded, err := json.Marshal(PullRespPayload{TXPK: TXPK{Tmst: 5, Freq: 869.525, Modu: "LORA", DatR: DataRate{LoRa: "SF12BW125"}, IPol: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txpk map[string]map[string]interface{}
+	json.Unmarshal(encoded, &txpk)
+	if txpk["txpk"]["datr"] != "SF12BW125" || txpk["txpk"]["ipol"] != true {
+		t.Fatalf("编码 txpk %s", encoded)
+	}
+	encoded, _ = json.Marshal(DataRate{FSK: 50000})
+	if string(encoded) != "50000" {
+		t.Fatalf("FSK 速率编码 %s", encoded)
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	join := []byte{0x00,
+		0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, // JoinEUI
+		0x18, 0x17, 0x16, 0x15, 0x14, 0x13, 0x12, 0x11, // DevEUI
+		0x01, 0x00, // DevNonce
+		0xA1, 0xA2, 0xA3, 0xA4}
+	id, err := ParseIdentity(join)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.MType != MTypeJoinRequest || id.JoinEUI != "0102030405060708" || id.DevEUI != "1112131415161718" || id.Key() != id.DevEUI {
+		t.Fatalf("入网请求 %+v", id)
+	}
+
+	data := []byte{0x80, 0xDA, 0x1B, 0x01, 0x26, 0x00, 0x2A, 0x00, 0x01, 0xFF, 0xA1, 0xA2, 0xA3, 0xA4}
+	if id, err = ParseIdentity(data); err != nil {
+		t.Fatal(err)
+	}
+	if id.MType != MTypeConfirmedUp || id.DevAddr != "26011BDA" || id.FCnt != 42 || id.Key() != "26011BDA" {
+		t.Fatalf("数据帧 %+v", id)
+	}
+
+	rejoin := append([]byte{0xC0, 0x00, 0x13, 0x00, 0x00}, join[9:17]...)
+	rejoin = append(rejoin, 0x00, 0x00, 0xA1, 0xA2, 0xA3, 0xA4)
+	if id, err = ParseIdentity(rejoin); err != nil || id.DevEUI != "1112131415161718" {
+		t.Fatalf("重入网请求 %+v %v", id, err)
+	}
+
+	for _, phy := range [][]byte{nil, join[:20], data[:8], {0x60, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, {0xE0, 1, 2}} {
+		if _, err := ParseIdentity(phy); !errors.Is(err, ErrInvalidPHYPayload) {
+			t.Fatalf("% X 应解析失败: %v", phy, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/semtech"
+)
+
+// semtechProtocol 测试用协议处理器，记录上行数据，确认帧回复 ACK
+type semtechProtocol struct {
+	uplinks chan semtech.Uplink
+}
+
+func (p *semtechProtocol) Init(device *model.Device, data []byte) error {
+	return nil
+}
+
+func (p *semtechProtocol) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	return data.([]byte), nil
+}
+
+func (p *semtechProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	var uplink semtech.Uplink
+	if err := json.Unmarshal(data, &uplink); err != nil {
+		return nil, err
+	}
+	p.uplinks <- uplink
+	if uplink.MType == semtech.MTypeConfirmedUp {
+		return []byte{0x60, 0xAC, 0x4B}, nil
+	}
+	return nil, nil
+}
+
+// semtechTestGateway 测试用 LoRa 网关
+type semtechTestGateway struct {
+	t    *testing.T
+	eui  string
+	conn *net.UDPConn
+}
+
+func (g *semtechTestGateway) send(p *semtech.Packet) {
+	data, err := p.Marshal()
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	if _, err := g.conn.Write(data); err != nil {
+		g.t.Fatal(err)
+	}
+}
+
+func (g *semtechTestGateway) receive(timeout time.Duration) (*semtech.Packet, error) {
+	buffer := make([]byte, 2048)
+	g.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := g.conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return semtech.Unmarshal(buffer[:n])
+}
+
+func (g *semtechTestGateway) expect(typ byte) *semtech.Packet {
+	g.t.Helper()
+	p, err := g.receive(3 * time.Second)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	if p.Type != typ {
+		g.t.Fatalf("网关 %s 收到报文类型 0x%02X, 期望 0x%02X", g.eui, p.Type, typ)
+	}
+	return p
+}
+
+// push 上报一个上行数据包
+func (g *semtechTestGateway) push(token uint16, phy []byte, tmst uint32, snr float64) {
+	g.t.Helper()
+	payload, _ := json.Marshal(semtech.PushDataPayload{RXPK: []semtech.RXPK{{
+		Tmst: tmst, Chan: 2, Freq: 868.5, Stat: 1, Modu: "LORA", DatR: semtech.DataRate{LoRa: "SF9BW125"},
+		CodR: "4/5", RSSI: -80, LSNR: snr, Size: len(phy), Data: base64.StdEncoding.EncodeToString(phy),
+	}}})
+	g.send(&semtech.Packet{Version: semtech.Version2, Token: token, Type: semtech.PushData, GatewayEUI: g.eui, Payload: payload})
+	if p := g.expect(semtech.PushAck); p.Token != token {
+		g.t.Fatalf("PUSH_ACK 令牌 %04X", p.Token)
+	}
+}
+
+// txpk 接收 PULL_RESP 中的 txpk
+func (g *semtechTestGateway) txpk() (*semtech.Packet, semtech.TXPK) {
+	g.t.Helper()
+	p := g.expect(semtech.PullResp)
+	var payload semtech.PullRespPayload
+	if err := json.Unmarshal(p.Payload, &payload); err != nil {
+		g.t.Fatal(err)
+	}
+	return p, payload.TXPK
+}
+
+func TestSemtechUDPServer(t *testing.T) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	protocol := &semtechProtocol{uplinks: make(chan semtech.Uplink, 4)}
+	server := NewSemtechUDPServer(WithProtocolHandler(protocol), WithSemtechConfig(conf.SemtechConfig{
+		RX2Frequency: 869.525,
+		RX2DataRate:  "SF12BW125",
+		DedupWindow:  50 * time.Millisecond,
+	})).(*SemtechUDPServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr.String())
+
+	dial := func(eui string) *semtechTestGateway {
+		conn, err := net.DialUDP("udp", nil, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		gateway := &semtechTestGateway{t: t, eui: eui, conn: conn}
+		// 等待服务器开始监听，PULL_DATA 建立下行通道
+		for i := 0; ; i++ {
+			gateway.send(&semtech.Packet{Version: semtech.Version2, Token: uint16(i), Type: semtech.PullData, GatewayEUI: eui})
+			if p, err := gateway.receive(50 * time.Millisecond); err == nil {
+				if p.Type != semtech.PullAck || p.Token != uint16(i) {
+					t.Fatalf("PULL_DATA 应回复 PULL_ACK: %+v", p)
+				}
+				return gateway
+			}
+			if i == 50 {
+				t.Fatal("Semtech UDP 服务器未启动")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	gatewayA, gatewayB := dial("AA555A0000000001"), dial("AA555A0000000002")
+	expectUplink := func() semtech.Uplink {
+		t.Helper()
+		select {
+		case uplink := <-protocol.uplinks:
+			return uplink
+		case <-time.After(3 * time.Second):
+			t.Fatal("协议处理器没有收到上行")
+		}
+		return semtech.Uplink{}
+	}
+
+	// 两个网关收到同一上行，只处理一次并选择信噪比最好的网关
+	unconfirmed := []byte{0x40, 0xDA, 0x1B, 0x01, 0x26, 0x00, 0x01, 0x00, 0x01, 0x55, 0xA1, 0xA2, 0xA3, 0xA4}
+	gatewayA.push(1, unconfirmed, 1000000, 2)
+	gatewayB.push(2, unconfirmed, 2000000, 7.5)
+	uplink := expectUplink()
+	if uplink.DevAddr != "26011BDA" || uplink.FCnt != 1 || uplink.GatewayEUI != gatewayB.eui || uplink.SNR != 7.5 ||
+		uplink.RSSI != -80 || uplink.Frequency != 868.5 || uplink.DataRate != "SF9BW125" ||
+		uplink.PHYPayload != base64.StdEncoding.EncodeToString(unconfirmed) {
+		t.Fatalf("上行数据 %+v", uplink)
+	}
+	select {
+	case duplicate := <-protocol.uplinks:
+		t.Fatalf("重复的上行 %+v", duplicate)
+	case <-time.After(100 * time.Millisecond):
+	}
+	device := server.LookupDevice("26011BDA")
+	if device == nil {
+		t.Fatal("上行后终端应上线")
+	}
+
+	// RX1 窗口下发，同一窗口只能下发一次，之后的数据缓存
+	if err := server.SendData(device, []byte{0x60, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	resp, txpk := gatewayB.txpk()
+	if txpk.Tmst != 3000000 || txpk.Freq != 868.5 || txpk.DatR.LoRa != "SF9BW125" || !txpk.IPol || txpk.Powe != 14 ||
+		txpk.Size != 2 || txpk.Data != base64.StdEncoding.EncodeToString([]byte{0x60, 0x01}) {
+		t.Fatalf("RX1 下发 %+v", txpk)
+	}
+	gatewayB.send(&semtech.Packet{Version: semtech.Version2, Token: resp.Token, Type: semtech.TxAck, GatewayEUI: gatewayB.eui})
+	if err := server.SendData(device, []byte{0x60, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := gatewayB.receive(200 * time.Millisecond); err == nil {
+		t.Fatalf("窗口已使用时不应下发: %+v", p)
+	}
+
+	// 确认帧的回复优先于缓存的数据，网关拒绝发送时回复重新缓存
+	confirmed := []byte{0x80, 0xDA, 0x1B, 0x01, 0x26, 0x00, 0x02, 0x00, 0x01, 0x56, 0xA1, 0xA2, 0xA3, 0xA4}
+	gatewayA.push(3, confirmed, 5000000, 3)
+	expectUplink()
+	resp, txpk = gatewayA.txpk()
+	if txpk.Tmst != 6000000 || txpk.Data != base64.StdEncoding.EncodeToString([]byte{0x60, 0xAC, 0x4B}) {
+		t.Fatalf("回复下发 %+v", txpk)
+	}
+	nack, _ := json.Marshal(map[string]interface{}{"txpk_ack": map[string]string{"error": semtech.TxAckTooLate}})
+	gatewayA.send(&semtech.Packet{Version: semtech.Version2, Token: resp.Token, Type: semtech.TxAck, GatewayEUI: gatewayA.eui, Payload: nack})
+	time.Sleep(50 * time.Millisecond)
+
+	next := []byte{0x40, 0xDA, 0x1B, 0x01, 0x26, 0x00, 0x03, 0x00, 0x01, 0x57, 0xA1, 0xA2, 0xA3, 0xA4}
+	for _, want := range [][]byte{{0x60, 0xAC, 0x4B}, {0x60, 0x02}} {
+		gatewayA.push(4, next, 8000000, 3)
+		expectUplink()
+		_, txpk = gatewayA.txpk()
+		if data, _ := base64.StdEncoding.DecodeString(txpk.Data); !bytes.Equal(data, want) {
+			t.Fatalf("缓存的下发 % X, 期望 % X", data, want)
+		}
+		next[6]++ // 下一帧
+	}
+
+	// 错过 RX1 窗口时使用 RX2 窗口
+	server.mu.Lock()
+	server.terminals["26011BDA"].received = time.Now().Add(-1200 * time.Millisecond)
+	server.terminals["26011BDA"].used = false
+	server.mu.Unlock()
+	if err := server.SendData(device, []byte{0x60, 0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if _, txpk = gatewayA.txpk(); txpk.Tmst != 10000000 || txpk.Freq != 869.525 || txpk.DatR.LoRa != "SF12BW125" {
+		t.Fatalf("RX2 下发 %+v", txpk)
+	}
+
+	// 入网请求以 DevEUI 作为设备标识
+	join := []byte{0x00, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, 0x18, 0x17, 0x16, 0x15, 0x14, 0x13, 0x12, 0x11, 0x01, 0x00, 0xA1, 0xA2, 0xA3, 0xA4}
+	gatewayA.push(5, join, 9000000, 3)
+	if uplink = expectUplink(); uplink.DevEUI != "1112131415161718" || uplink.JoinEUI != "0102030405060708" || uplink.MType != semtech.MTypeJoinRequest {
+		t.Fatalf("入网请求 %+v", uplink)
+	}
+	joined := server.LookupDevice("1112131415161718")
+	if joined == nil {
+		t.Fatal("入网请求的终端应上线")
+	}
+
+	// 入网接受使用入网接受的延时
+	if err := server.SendData(joined, []byte{0x20, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if _, txpk = gatewayA.txpk(); txpk.Tmst != 14000000 || txpk.Freq != 868.5 {
+		t.Fatalf("入网接受下发 %+v", txpk)
+	}
+
+	// 去重窗口结束后，确认帧的重传作为新的上行处理并再次回复
+	gatewayA.push(6, confirmed, 12000000, 3)
+	expectUplink()
+	if _, txpk = gatewayA.txpk(); txpk.Tmst != 13000000 || txpk.Data != base64.StdEncoding.EncodeToString([]byte{0x60, 0xAC, 0x4B}) {
+		t.Fatalf("重传的确认帧回复 %+v", txpk)
+	}
+}
@@ -0,0 +1,290 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"go.bug.st/serial"
+)
+
+// SerialServer 结构体表示串口(RS-232/RS-485)服务器
+// 一个串口对应一个设备连接，总线上的多台设备由协议处理器按地址区分
+type SerialServer struct {
+	*BaseServer
+	mu     sync.Mutex // 保护 port 的打开、关闭与写入
+	port   serial.Port
+	closed bool
+}
+
+// NewSerialServer 创建一个新的串口服务器实例
+func NewSerialServer(options ...Option) NetworkServer {
+	s := &SerialServer{
+		BaseServer: NewBaseServer(options...),
+	}
+	return s
+}
+
+// Start 打开串口并开始读取数据，portName 为空时使用 WithSerialConfig 中配置的串口
+func (s *SerialServer) Start(ctx context.Context, portName string) error {
+	if portName == "" {
+		portName = s.serialConfig.Port
+	}
+	if portName == "" {
+		return errors.New("未配置串口设备")
+	}
+	mode, err := serialMode(s.serialConfig.BaudRate, s.serialConfig.DataBits, s.serialConfig.StopBits, s.serialConfig.Parity)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := s.frameDecoder()
+	if err != nil {
+		return fmt.Errorf("创建帧解码器失败: %v", err)
+	}
+
+	port, err := s.open(portName, mode, decoder == nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	retryDelay := s.serialConfig.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = 3 * time.Second
+	}
+
+	for {
+		s.serve(ctx, portName, port, decoder)
+		if s.isClosed() || ctx.Err() != nil {
+			return nil
+		}
+
+		// 串口异常断开(如 USB 转串口被拔出)，间隔重试直到重新打开或服务停止
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(retryDelay):
+			}
+			if port, err = s.open(portName, mode, decoder == nil); err == nil {
+				break
+			}
+			glog.Debugf(context.Background(), "重新打开串口 %s 失败: %v", portName, err)
+		}
+	}
+}
+
+// Stop 停止串口服务器
+func (s *SerialServer) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.port != nil {
+		err := s.port.Close()
+		s.port = nil
+		return err
+	}
+	return nil
+}
+
+// SendData 向串口设备发送数据
+func (s *SerialServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	var encodedData []byte
+	var err error
+
+	if s.protocolHandler != nil {
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else {
+		encodedData = []byte(fmt.Sprintf("%v", data))
+	}
+	if encodedData, err = s.encodeFrame(encodedData); err != nil {
+		return fmt.Errorf("数据成帧失败: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.port == nil {
+		return errors.New("串口未打开")
+	}
+	_, err = s.port.Write(encodedData)
+	return err
+}
+
+// open 打开串口，splitByGap 为 true 时设置读取超时用于按帧间隔切分
+func (s *SerialServer) open(portName string, mode *serial.Mode, splitByGap bool) (serial.Port, error) {
+	port, err := serial.Open(portName, mode)
+	if err != nil {
+		return nil, fmt.Errorf("打开串口 %s 失败: %v", portName, err)
+	}
+	if splitByGap {
+		// 没有帧解码器时按帧间隔切分，读取超时即视为一帧结束
+		if err := port.SetReadTimeout(s.frameGap(mode.BaudRate)); err != nil {
+			port.Close()
+			return nil, fmt.Errorf("设置串口读取超时失败: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		port.Close()
+		return nil, errors.New("串口服务器已停止")
+	}
+	s.port = port
+	return port, nil
+}
+
+// serve 读取串口数据直到串口关闭或出错
+func (s *SerialServer) serve(ctx context.Context, portName string, port serial.Port, decoder FrameDecoder) {
+	device := s.handleConnect(portName, nil)
+	s.bindDevice(device, s.serialConfig.DeviceKey)
+	defer func() {
+		s.handleDisconnect(device)
+		s.mu.Lock()
+		if s.port == port {
+			s.port.Close()
+			s.port = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	var frames *frameReader
+	if decoder != nil {
+		frames = newFrameReader(port, decoder, s.maxFrameLength())
+	}
+	defer func() {
+		if frames != nil {
+			frames.release()
+		}
+	}()
+	buffer := make([]byte, 1024)
+
+	for ctx.Err() == nil {
+		var data []byte
+		var err error
+		if frames == nil {
+			data, err = readUntilGap(port, buffer)
+		} else {
+			data, err = frames.ReadFrame()
+		}
+		if errors.Is(err, ErrFrameTooLong) || errors.Is(err, ErrInvalidFrame) {
+			// 串口无法断开重连，丢弃已缓存的数据后重新同步
+			glog.Debugf(context.Background(), "串口 %s 数据帧错误: %v\n", portName, err)
+			frames.release()
+			frames = newFrameReader(port, decoder, s.maxFrameLength())
+			continue
+		}
+		if err != nil {
+			if !s.isClosed() && !errors.Is(err, io.EOF) {
+				glog.Debugf(context.Background(), "读取串口 %s 错误: %v\n", portName, err)
+			}
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		resData, err := s.handleReceiveData(device, data)
+		if err != nil {
+			glog.Debugf(context.Background(), "处理数据错误: %v\n", err)
+			continue
+		}
+
+		if resData != nil {
+			if err := s.SendData(device, resData); err != nil {
+				glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// isClosed 判断串口服务器是否已停止
+func (s *SerialServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// frameGap 获取帧间隔，未配置时按 3.5 个字符(每字符 11 位)时间计算，且不小于 5 毫秒
+func (s *SerialServer) frameGap(baudRate int) time.Duration {
+	if s.serialConfig.FrameGap > 0 {
+		return s.serialConfig.FrameGap
+	}
+	gap := time.Duration(float64(time.Second) * 3.5 * 11 / float64(baudRate))
+	if gap < 5*time.Millisecond {
+		gap = 5 * time.Millisecond
+	}
+	return gap
+}
+
+// readUntilGap 读取数据直到串口在帧间隔内没有新数据
+func readUntilGap(port serial.Port, buffer []byte) ([]byte, error) {
+	var frame []byte
+	for {
+		n, err := port.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// 读取超时：已有数据则一帧结束，否则继续等待
+			if len(frame) > 0 {
+				return frame, nil
+			}
+			return nil, nil
+		}
+		frame = append(frame, buffer[:n]...)
+	}
+}
+
+// serialMode 根据配置生成串口参数
+func serialMode(baudRate, dataBits, stopBits int, parity string) (*serial.Mode, error) {
+	mode := &serial.Mode{
+		BaudRate: baudRate,
+		DataBits: dataBits,
+		StopBits: serial.OneStopBit,
+		Parity:   serial.NoParity,
+	}
+	if mode.BaudRate <= 0 {
+		mode.BaudRate = 9600
+	}
+	if mode.DataBits <= 0 {
+		mode.DataBits = 8
+	}
+
+	switch stopBits {
+	case 0, 1:
+	case 2:
+		mode.StopBits = serial.TwoStopBits
+	default:
+		return nil, fmt.Errorf("不支持的串口停止位: %d", stopBits)
+	}
+
+	switch strings.ToLower(parity) {
+	case "", "n", "none":
+	case "o", "odd":
+		mode.Parity = serial.OddParity
+	case "e", "even":
+		mode.Parity = serial.EvenParity
+	case "m", "mark":
+		mode.Parity = serial.MarkParity
+	case "s", "space":
+		mode.Parity = serial.SpaceParity
+	default:
+		return nil, fmt.Errorf("不支持的串口校验位: %s", parity)
+	}
+	return mode, nil
+}
@@ -0,0 +1,198 @@
+//go:build linux
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"golang.org/x/sys/unix"
+)
+
+// echoProtocol 测试用协议处理器，记录收到的帧并原样回复
+type echoProtocol struct {
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (p *echoProtocol) Init(device *model.Device, data []byte) error {
+	return nil
+}
+
+func (p *echoProtocol) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	return data.([]byte), nil
+}
+
+func (p *echoProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	p.mu.Lock()
+	p.frames = append(p.frames, append([]byte(nil), data...))
+	p.mu.Unlock()
+	return append([]byte("ack:"), data...), nil
+}
+
+func (p *echoProtocol) received() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.frames...)
+}
+
+// openPty 打开一对伪终端，返回主端与从端设备路径
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("无法打开伪终端: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Skipf("解锁伪终端失败: %v", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Skipf("获取伪终端编号失败: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSerialServerDelimiter(t *testing.T) {
+	master, slave := openPty(t)
+	protocol := &echoProtocol{}
+	server := NewSerialServer(
+		WithProtocolHandler(protocol),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\r\n"}),
+		WithSerialConfig(conf.SerialConfig{BaudRate: 115200, Parity: "even"}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx, slave) }()
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := master.Write([]byte("hello\r\nwor")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.Write([]byte("ld\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(protocol.received()) == 2 })
+
+	frames := protocol.received()
+	if string(frames[0]) != "hello\r\n" || string(frames[1]) != "world\r\n" {
+		t.Fatalf("收到的帧不正确: %q", frames)
+	}
+
+	reply := make([]byte, 64)
+	var got []byte
+	for !bytes.Contains(got, []byte("ack:world\r\n")) {
+		master.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := master.Read(reply)
+		if err != nil {
+			t.Fatalf("读取回复失败: %v, 已收到 %q", err, got)
+		}
+		got = append(got, reply[:n]...)
+	}
+	if string(got) != "ack:hello\r\nack:world\r\n" {
+		t.Fatalf("回复不正确: %q", got)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("串口服务器未能停止")
+	}
+}
+
+func TestSerialServerMarkOffline(t *testing.T) {
+	master, slave := openPty(t)
+	server := NewSerialServer(
+		WithProtocolHandler(&echoProtocol{}),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\n"}),
+		WithSerialConfig(conf.SerialConfig{BaudRate: 9600, DeviceKey: "serial-001"}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, slave)
+	locator := server.(DeviceLocator)
+	waitFor(t, func() bool { return locator.LookupDevice("serial-001") != nil })
+
+	// 串口设备没有自己的连接，置为离线后再次收到数据时重新上线
+	if !locator.MarkOffline("serial-001") || locator.LookupDevice("serial-001") != nil {
+		t.Fatal("设备应被置为离线")
+	}
+	if _, err := master.Write([]byte("v=1\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return locator.LookupDevice("serial-001") != nil })
+}
+
+func TestSerialServerFrameGap(t *testing.T) {
+	master, slave := openPty(t)
+	protocol := &echoProtocol{}
+	server := NewSerialServer(
+		WithProtocolHandler(protocol),
+		WithPacketHandling(conf.PacketConfig{Type: NoHandling}),
+		WithSerialConfig(conf.SerialConfig{BaudRate: 9600, FrameGap: 50 * time.Millisecond, DeviceKey: "rs485-1"}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, slave)
+	time.Sleep(100 * time.Millisecond)
+
+	master.Write([]byte{0x01, 0x03, 0x00})
+	master.Write([]byte{0x00, 0x00, 0x02})
+	time.Sleep(200 * time.Millisecond)
+	master.Write([]byte{0x02, 0x03})
+	waitFor(t, func() bool { return len(protocol.received()) == 2 })
+
+	frames := protocol.received()
+	if !bytes.Equal(frames[0], []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02}) || !bytes.Equal(frames[1], []byte{0x02, 0x03}) {
+		t.Fatalf("收到的帧不正确: % x", frames)
+	}
+
+	device := server.(*SerialServer).LookupDevice("rs485-1")
+	if device == nil || device.ClientID != slave {
+		t.Fatalf("串口连接未绑定设备标识: %+v", device)
+	}
+	if err := server.SendData(device, []byte{0xAA}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSerialMode(t *testing.T) {
+	if _, err := serialMode(9600, 8, 3, ""); err == nil {
+		t.Error("停止位 3 应当报错")
+	}
+	if _, err := serialMode(9600, 8, 1, "x"); err == nil {
+		t.Error("未知校验位应当报错")
+	}
+	mode, err := serialMode(0, 0, 2, "O")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode.BaudRate != 9600 || mode.DataBits != 8 {
+		t.Errorf("默认参数不正确: %+v", mode)
+	}
+}
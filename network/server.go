@@ -7,12 +7,22 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gookit/event"
 	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
 	"github.com/sagoo-cloud/iotgateway/model"
 	"github.com/sagoo-cloud/iotgateway/vars"
 )
 
+var (
+	// ErrDeviceNotFound 设备标识不存在，网关从未接入过该设备
+	ErrDeviceNotFound = errors.New("设备不存在")
+	// ErrDeviceOffline 设备曾经接入但当前没有可用的连接
+	ErrDeviceOffline = errors.New("设备不在线")
+)
+
 // NetworkServer 接口定义了网络服务器的通用方法
 type NetworkServer interface {
 	Start(ctx context.Context, addr string) error
@@ -20,6 +30,14 @@ type NetworkServer interface {
 	SendData(device *model.Device, data interface{}, param ...string) error
 }
 
+// DeviceLocator 网络服务器可选实现的接口，按设备标识查找当前在线的设备，所有内置的网络服务器均已实现
+// 下发数据缓存到设备下一次上报的网络服务器(如 HTTP、Semtech UDP)，设备离线期间 LookupDevice 仍返回设备，下发进入缓存队列
+type DeviceLocator interface {
+	LookupDevice(deviceKey string) *model.Device
+	DeviceHandler(device *model.Device) ProtocolHandler
+	MarkOffline(deviceKey string) bool
+}
+
 // BaseServer 结构体包含 TCP 和 UDP 服务器的共同字段
 type BaseServer struct {
 	devices         sync.Map
@@ -27,6 +45,28 @@ type BaseServer struct {
 	protocolHandler ProtocolHandler
 	cleanupInterval time.Duration
 	packetConfig    conf.PacketConfig
+	decoder         FrameDecoder
+	encoder         FrameEncoder
+	boundHandlers   sync.Map // *model.Device -> 协议识别后绑定的协议处理器
+	deviceKeys      sync.Map // 设备标识 -> 在线设备，在设备的读取协程中更新
+	transportConfig
+}
+
+// transportConfig 各传输方式的配置，由对应的选项设置，只被对应的网络服务器读取
+type transportConfig struct {
+	serialConfig     conf.SerialConfig
+	tcpClientConfig  conf.TCPClientConfig
+	detectConfig     conf.DetectConfig
+	tlsConfig        conf.TLSConfig
+	wsConfig         conf.WebSocketConfig
+	httpConfig       conf.HTTPConfig
+	coapConfig       conf.CoAPConfig
+	mqttBrokerConfig conf.MQTTBrokerConfig
+	mqttsnConfig     conf.MQTTSNConfig
+	semtechConfig    conf.SemtechConfig
+	lwm2mConfig      conf.LwM2MConfig
+	snmpConfig       conf.SNMPConfig
+	bacnetConfig     conf.BACnetConfig
 }
 
 // NewBaseServer 创建一个新的基础服务器实例
@@ -52,6 +92,30 @@ func (s *BaseServer) handleConnect(clientID string, conn net.Conn) *model.Device
 	return device
 }
 
+// bindDevice 将连接绑定到指定的设备标识，用于在协议登录之前就已知设备身份的场景
+func (s *BaseServer) bindDevice(device *model.Device, deviceKey string) {
+	if device == nil || deviceKey == "" {
+		return
+	}
+	device.DeviceKey = deviceKey
+	vars.UpdateDeviceMap(deviceKey, device)
+	s.storeDeviceKey(device)
+}
+
+// storeDeviceKey 记录设备标识对应的在线设备，设备标识上线(首次绑定或重新连接)时触发 DeviceOnline 事件
+func (s *BaseServer) storeDeviceKey(device *model.Device) {
+	if previous, loaded := s.deviceKeys.Swap(device.DeviceKey, device); !loaded || previous != device {
+		fireDeviceStatus(consts.DeviceOnline, device.DeviceKey)
+	}
+}
+
+// fireDeviceStatus 触发设备上线或离线事件，事件数据中的 DeviceKey 为设备标识
+func fireDeviceStatus(name, deviceKey string) {
+	if err, _ := event.Fire(name, g.Map{"DeviceKey": deviceKey}); err != nil {
+		glog.Debugf(context.Background(), "触发设备 %s 的 %s 事件失败: %v", deviceKey, name, err)
+	}
+}
+
 // getDevice 获取设备实例
 func (s *BaseServer) getDevice(clientID string) *model.Device {
 	if device, ok := s.devices.Load(clientID); ok {
@@ -63,10 +127,40 @@ func (s *BaseServer) getDevice(clientID string) *model.Device {
 	return nil
 }
 
+// LookupDevice 按设备标识查找当前在线的设备，未找到时返回 nil
+func (s *BaseServer) LookupDevice(deviceKey string) *model.Device {
+	value, ok := s.deviceKeys.Load(deviceKey)
+	if !ok {
+		return nil
+	}
+	device := value.(*model.Device)
+	if current := s.getDevice(device.ClientID); current != device {
+		return nil // 设备已离线或相同客户端标识已重新连接
+	}
+	return device
+}
+
+// MarkOffline 将设备置为离线，有连接的设备同时断开连接，设备重新连接或再次上报数据后重新上线
+// 设备当前不在线时返回 false
+func (s *BaseServer) MarkOffline(deviceKey string) bool {
+	device := s.LookupDevice(deviceKey)
+	if device == nil {
+		return false
+	}
+	s.handleDisconnect(device) // 先置为离线再关闭连接，对端感知到断开时设备已不可查找
+	if device.Conn != nil {
+		device.Conn.Close()
+	}
+	return true
+}
+
 // handleDisconnect 处理设备离线事件
 func (s *BaseServer) handleDisconnect(device *model.Device) {
 	if _, ok := s.devices.LoadAndDelete(device.ClientID); ok {
 		device.OnlineStatus = false
+		if s.deviceKeys.CompareAndDelete(device.DeviceKey, device) {
+			fireDeviceStatus(consts.DeviceOffline, device.DeviceKey)
+		}
 		glog.Debugf(context.Background(), "设备 %s 离线, %s\n", device.DeviceKey, device.ClientID)
 
 		// ✅ 清理设备相关的所有消息缓存，防止内存泄漏
@@ -78,18 +172,32 @@ func (s *BaseServer) handleDisconnect(device *model.Device) {
 
 // handleReceiveData 处理接收数据事件
 func (s *BaseServer) handleReceiveData(device *model.Device, data []byte) (resData interface{}, err error) {
-	if s.protocolHandler == nil {
+	handler := s.deviceProtocol(device)
+	if handler == nil {
 		return nil, errors.New("未设置协议处理器")
 	}
-	s.protocolHandler.Init(device, data) // 初始化协议处理器
+	handler.Init(device, data) // 初始化协议处理器
 	if device != nil {
+		if device.Conn == nil {
+			// 串口等没有自己连接的设备被置为离线后连接仍在读取，再次收到数据时重新上线
+			if _, loaded := s.devices.LoadOrStore(device.ClientID, device); !loaded {
+				glog.Debugf(context.Background(), "设备 %s 重新上线\n", device.ClientID)
+			}
+		}
 		device.OnlineStatus = true
 		device.LastActive = time.Now() // 更新设备最后活跃时间
 		if device.DeviceKey != "" {
 			vars.UpdateDeviceMap(device.DeviceKey, device) // 更新到全局设备列表
 		}
 	}
-	return s.protocolHandler.Decode(device, data) // 解码数据
+	res, err := handler.Decode(device, data) // 解码数据
+	if device != nil && device.DeviceKey != "" {
+		s.storeDeviceKey(device) // 设备标识可能在 Init 或 Decode 中确定
+	}
+	if err != nil || len(res) == 0 {
+		return nil, err // 没有回复数据时返回 nil，避免下发空数据
+	}
+	return res, nil
 }
 
 // cleanupInactiveDevices 清理不活跃的设备
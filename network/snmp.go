@@ -0,0 +1,766 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network/snmp"
+)
+
+const (
+	snmpMaxDatagramSize = 64 * 1024
+	snmpMaxMessageSize  = 65507 // v3 报文中声明的最大报文长度
+	snmpTrapEvent       = "trap"
+)
+
+// ErrSNMPTimeout SNMP 请求重试次数用尽仍未收到响应
+var ErrSNMPTimeout = errors.New("SNMP 请求超时")
+
+// SNMPServer 结构体表示 SNMP v1/v2c/v3 管理端，用于接入 UPS、交换机、精密空调等网络设备
+// 按轮询组定时读取代理的对象(表的列通过遍历读取)，转换后作为属性上报；监听地址接收 Trap 与 Inform，作为事件上报。
+// 代理以配置的设备标识接入，轮询成功后上线、超时后离线
+type SNMPServer struct {
+	*BaseServer
+	conn      *net.UDPConn // 接收 Trap
+	client    *net.UDPConn // 发送请求、接收响应
+	requestID atomic.Uint32
+
+	mu        sync.Mutex
+	agents    map[string]*snmpAgent    // 设备标识 -> 代理
+	pending   map[int32]*snmpPending   // 请求ID(v3 为消息ID) -> 等待响应的请求
+	trapKeys  map[string]*snmp.Keys    // 引擎ID|用户名 -> v3 Trap 的本地化密钥
+	connected map[string]*model.Device // 设备标识 -> 轮询或 Trap 上线的设备
+}
+
+// snmpAgent 表示一个 SNMP 代理
+type snmpAgent struct {
+	config  conf.SNMPAgentConfig
+	addr    *net.UDPAddr
+	version int
+	user    snmp.User
+
+	// v3 引擎发现得到的参数
+	mu          sync.Mutex
+	engineID    []byte
+	engineBoots int32
+	engineTime  int32
+	syncedAt    time.Time
+	keys        *snmp.Keys
+}
+
+// snmpPending 表示一个等待响应的请求
+type snmpPending struct {
+	addr     string
+	response chan snmpResponse
+}
+
+// snmpResponse 表示收到的响应及其原始报文，v3 报文需要用原始报文认证
+type snmpResponse struct {
+	msg  *snmp.Message
+	data []byte
+}
+
+// NewSNMPServer 创建一个新的 SNMP 管理端实例
+func NewSNMPServer(options ...Option) NetworkServer {
+	s := &SNMPServer{
+		BaseServer: NewBaseServer(options...),
+		agents:     make(map[string]*snmpAgent),
+		pending:    make(map[int32]*snmpPending),
+		trapKeys:   make(map[string]*snmp.Keys),
+		connected:  make(map[string]*model.Device),
+	}
+	s.requestID.Store(mrand.Uint32N(1 << 30))
+	return s
+}
+
+// Start 启动 SNMP 管理端，addr 为接收 Trap 的地址
+func (s *SNMPServer) Start(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = ":162"
+	}
+	for _, config := range s.snmpConfig.Agents {
+		agent, err := newSNMPAgent(config)
+		if err != nil {
+			return err
+		}
+		s.agents[config.DeviceKey] = agent
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("解析 UDP 地址失败: %v", err)
+	}
+	s.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("SNMP Trap 监听失败: %v", err)
+	}
+	s.client, err = net.ListenUDP("udp", nil)
+	if err != nil {
+		s.conn.Close()
+		return fmt.Errorf("SNMP 请求端口监听失败: %v", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	go s.readResponses()
+	for _, group := range s.snmpConfig.Groups {
+		for _, deviceKey := range group.DeviceKeys {
+			agent := s.agents[deviceKey]
+			if agent == nil {
+				glog.Debugf(context.Background(), "SNMP 轮询组 %s 中的代理 %s 未配置\n", group.Name, deviceKey)
+				continue
+			}
+			go s.pollLoop(ctx, agent, group)
+		}
+	}
+
+	buffer := make([]byte, snmpMaxDatagramSize)
+	for {
+		n, remoteAddr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil // 正常关闭
+			}
+			glog.Debugf(context.Background(), "读取 SNMP Trap 失败: %v", err)
+			continue
+		}
+		s.handleTrap(remoteAddr, append([]byte(nil), buffer[:n]...))
+	}
+}
+
+// Stop 停止 SNMP 管理端
+func (s *SNMPServer) Stop() error {
+	if s.client != nil {
+		s.client.Close()
+	}
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// SendData 按对象映射以 SET 请求写入代理，data 为 属性标识 -> 值，也可以是 []snmp.Variable
+func (s *SNMPServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout()*time.Duration(s.retries()+1))
+	defer cancel()
+	if variables, ok := data.([]snmp.Variable); ok {
+		_, err := s.Set(ctx, device.DeviceKey, variables...)
+		return err
+	}
+
+	properties := gconv.Map(data)
+	var variables []snmp.Variable
+	for _, group := range s.snmpConfig.Groups {
+		if !slices.Contains(group.DeviceKeys, device.DeviceKey) {
+			continue
+		}
+		for _, object := range group.Objects {
+			value, ok := properties[object.Name]
+			if !ok || object.Walk {
+				continue
+			}
+			variable, err := snmp.SetValue(object.OID, object.Type, object.Scale, value)
+			if err != nil {
+				return fmt.Errorf("属性 %s: %w", object.Name, err)
+			}
+			variables = append(variables, variable)
+			delete(properties, object.Name) // 多个轮询组包含同一对象时只写入一次
+		}
+	}
+	if len(variables) == 0 {
+		return errors.New("SNMP 下发数据中没有已映射的属性")
+	}
+	_, err := s.Set(ctx, device.DeviceKey, variables...)
+	return err
+}
+
+// Get 读取代理的对象，对象数超过 MaxVarBinds 时分多次请求
+func (s *SNMPServer) Get(ctx context.Context, deviceKey string, oids ...string) ([]snmp.Variable, error) {
+	agent, err := s.agent(deviceKey)
+	if err != nil {
+		return nil, err
+	}
+	var result []snmp.Variable
+	for start := 0; start < len(oids); start += s.maxVarBinds() {
+		chunk := oids[start:min(start+s.maxVarBinds(), len(oids))]
+		for len(chunk) > 0 {
+			pdu, err := s.request(ctx, agent, snmp.PDU{Type: snmp.GetRequest, Variables: nullVariables(chunk)})
+			var status *snmp.StatusError
+			if errors.As(err, &status) && status.Status == snmp.NoSuchName && status.Index >= 1 && status.Index <= len(chunk) {
+				// v1 中有对象不存在时整个请求失败，去掉该对象后重试
+				chunk = append(chunk[:status.Index-1:status.Index-1], chunk[status.Index:]...)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, pdu.Variables...)
+			break
+		}
+	}
+	return result, nil
+}
+
+// Walk 遍历代理中 oid 子树下的全部实例,v2c/v3 使用 GETBULK,v1 使用 GETNEXT
+func (s *SNMPServer) Walk(ctx context.Context, deviceKey string, oid string) ([]snmp.Variable, error) {
+	agent, err := s.agent(deviceKey)
+	if err != nil {
+		return nil, err
+	}
+	oid = strings.TrimPrefix(oid, ".")
+	var result []snmp.Variable
+	next := oid
+	for {
+		pdu := snmp.PDU{Type: snmp.GetNextRequest, Variables: nullVariables([]string{next})}
+		if agent.version != snmp.Version1 {
+			pdu.Type, pdu.ErrorIndex = snmp.GetBulkRequest, s.maxRepetitions()
+		}
+		resp, err := s.request(ctx, agent, pdu)
+		var status *snmp.StatusError
+		if errors.As(err, &status) && status.Status == snmp.NoSuchName {
+			return result, nil // v1 遍历到 MIB 末尾
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Variables) == 0 {
+			return result, nil
+		}
+		for _, v := range resp.Variables {
+			if v.Type == snmp.TypeEndOfMibView || !snmp.HasPrefix(v.OID, oid) {
+				return result, nil
+			}
+			if snmp.CompareOID(v.OID, next) <= 0 {
+				return nil, fmt.Errorf("SNMP 代理 %s 遍历 %s 时 OID 未递增: %s", deviceKey, oid, v.OID)
+			}
+			result = append(result, v)
+			next = v.OID
+		}
+	}
+}
+
+// Set 以 SET 请求写入代理的对象
+func (s *SNMPServer) Set(ctx context.Context, deviceKey string, variables ...snmp.Variable) ([]snmp.Variable, error) {
+	agent, err := s.agent(deviceKey)
+	if err != nil {
+		return nil, err
+	}
+	pdu, err := s.request(ctx, agent, snmp.PDU{Type: snmp.SetRequest, Variables: variables})
+	if err != nil {
+		return nil, err
+	}
+	return pdu.Variables, nil
+}
+
+// pollLoop 按轮询组的周期轮询一个代理
+func (s *SNMPServer) pollLoop(ctx context.Context, agent *snmpAgent, group conf.SNMPGroupConfig) {
+	interval := group.Interval
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+	for {
+		s.poll(ctx, agent, group)
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// poll 读取轮询组的全部对象并作为属性上报，请求超时时代理离线
+func (s *SNMPServer) poll(ctx context.Context, agent *snmpAgent, group conf.SNMPGroupConfig) {
+	deviceKey := agent.config.DeviceKey
+	properties := make(map[string]interface{})
+	var scalars []conf.SNMPObjectConfig
+	var oids []string
+	for _, object := range group.Objects {
+		if !object.Walk {
+			scalars = append(scalars, object)
+			oids = append(oids, strings.TrimPrefix(object.OID, "."))
+			continue
+		}
+		variables, err := s.Walk(ctx, deviceKey, object.OID)
+		if err != nil {
+			s.pollFailed(agent, group, err)
+			return
+		}
+		root := strings.TrimPrefix(object.OID, ".")
+		for _, v := range variables {
+			index := strings.ReplaceAll(strings.TrimPrefix(v.OID, root+"."), ".", "_")
+			s.convert(properties, object.Name+"_"+index, v, object)
+		}
+	}
+	if len(oids) > 0 {
+		variables, err := s.Get(ctx, deviceKey, oids...)
+		if err != nil {
+			s.pollFailed(agent, group, err)
+			return
+		}
+		values := make(map[string]snmp.Variable, len(variables))
+		for _, v := range variables {
+			values[v.OID] = v
+		}
+		for i, object := range scalars {
+			if v, ok := values[oids[i]]; ok {
+				s.convert(properties, object.Name, v, object)
+			}
+		}
+	}
+
+	s.online(deviceKey)
+	if len(properties) == 0 {
+		return
+	}
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         deviceKey,
+		"PropertieDataList": properties,
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 SNMP 代理 %s 数据失败: %v\n", deviceKey, err)
+	}
+}
+
+// pollFailed 记录轮询失败，请求超时时代理离线
+func (s *SNMPServer) pollFailed(agent *snmpAgent, group conf.SNMPGroupConfig, err error) {
+	deviceKey := agent.config.DeviceKey
+	glog.Debugf(context.Background(), "轮询 SNMP 代理 %s(%s)失败: %v\n", deviceKey, group.Name, err)
+	if !errors.Is(err, ErrSNMPTimeout) {
+		return
+	}
+	s.mu.Lock()
+	device := s.connected[deviceKey]
+	delete(s.connected, deviceKey)
+	s.mu.Unlock()
+	if device != nil {
+		s.handleDisconnect(device)
+	}
+}
+
+// convert 转换变量的值并加入属性，不存在的对象忽略
+func (s *SNMPServer) convert(properties map[string]interface{}, name string, v snmp.Variable, object conf.SNMPObjectConfig) {
+	if v.Exception() {
+		return
+	}
+	value, err := snmp.Convert(v, object.Type, object.Scale)
+	if err != nil {
+		glog.Debugf(context.Background(), "转换 SNMP 对象 %s 失败: %v\n", name, err)
+		return
+	}
+	properties[name] = value
+}
+
+// online 代理或 Trap 的来源上线
+func (s *SNMPServer) online(deviceKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device := s.connected[deviceKey]
+	if device == nil || s.getDevice(deviceKey) != device {
+		device = s.handleConnect(deviceKey, nil)
+		s.bindDevice(device, deviceKey)
+		s.connected[deviceKey] = device
+	}
+	device.LastActive = time.Now()
+}
+
+// request 向代理发送请求并返回响应的 PDU,v3 请求前先发现代理的引擎
+// 代理以 Report 返回时间窗口错误或引擎ID未知时同步后重试一次
+func (s *SNMPServer) request(ctx context.Context, agent *snmpAgent, pdu snmp.PDU) (*snmp.PDU, error) {
+	for retry := 0; ; retry++ {
+		msg := &snmp.Message{Version: agent.version, Community: agent.config.Community, PDU: pdu}
+		if msg.Community == "" {
+			msg.Community = "public"
+		}
+		var keys *snmp.Keys
+		if agent.version == snmp.Version3 {
+			if err := s.discover(ctx, agent); err != nil {
+				return nil, err
+			}
+			agent.mu.Lock()
+			keys = agent.keys
+			msg.MaxSize = snmpMaxMessageSize
+			msg.Flags = agent.user.Flags() | snmp.FlagReportable
+			msg.Security = snmp.SecurityParams{
+				EngineID:    agent.engineID,
+				EngineBoots: agent.engineBoots,
+				EngineTime:  agent.engineTime + int32(time.Since(agent.syncedAt)/time.Second),
+				UserName:    agent.user.Name,
+			}
+			msg.ContextEngineID, msg.ContextName = agent.engineID, agent.config.Context
+			agent.mu.Unlock()
+		}
+
+		resp, data, err := s.roundTrip(ctx, agent, msg, keys)
+		if err != nil {
+			return nil, err
+		}
+		if agent.version == snmp.Version3 {
+			if err := resp.Open(data, keys); err != nil {
+				return nil, fmt.Errorf("SNMP 代理 %s: %w", agent.config.DeviceKey, err)
+			}
+			if resp.PDU.Type == snmp.Report {
+				var oid string
+				if len(resp.PDU.Variables) > 0 {
+					oid = resp.PDU.Variables[0].OID
+				}
+				if retry == 0 && (oid == snmp.OIDNotInTimeWindows || oid == snmp.OIDUnknownEngineIDs) {
+					agent.synchronize(resp.Security, oid == snmp.OIDUnknownEngineIDs)
+					continue
+				}
+				return nil, fmt.Errorf("SNMP 代理 %s 拒绝请求: %s", agent.config.DeviceKey, oid)
+			}
+		}
+		if resp.PDU.Type != snmp.GetResponse {
+			return nil, fmt.Errorf("SNMP 代理 %s 响应的 PDU 类型 0x%02X 错误", agent.config.DeviceKey, resp.PDU.Type)
+		}
+		if resp.PDU.ErrorStatus != snmp.NoError {
+			return nil, &snmp.StatusError{Status: resp.PDU.ErrorStatus, Index: resp.PDU.ErrorIndex}
+		}
+		return &resp.PDU, nil
+	}
+}
+
+// discover 以不认证的空请求获取 v3 代理的引擎ID，并生成本地化的密钥
+func (s *SNMPServer) discover(ctx context.Context, agent *snmpAgent) error {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	if agent.engineID != nil {
+		return nil
+	}
+	msg := &snmp.Message{Version: snmp.Version3, MaxSize: snmpMaxMessageSize, Flags: snmp.FlagReportable, PDU: snmp.PDU{Type: snmp.GetRequest}}
+	resp, _, err := s.roundTrip(ctx, agent, msg, nil)
+	if err != nil {
+		return fmt.Errorf("SNMP 代理 %s 引擎发现失败: %w", agent.config.DeviceKey, err)
+	}
+	if resp.PDU.Type != snmp.Report || len(resp.Security.EngineID) == 0 {
+		return fmt.Errorf("SNMP 代理 %s 引擎发现失败: 响应中没有引擎ID", agent.config.DeviceKey)
+	}
+	keys, err := snmp.LocalizeKeys(agent.user, resp.Security.EngineID)
+	if err != nil {
+		return err
+	}
+	agent.engineID, agent.keys = resp.Security.EngineID, keys
+	agent.engineBoots, agent.engineTime, agent.syncedAt = resp.Security.EngineBoots, resp.Security.EngineTime, time.Now()
+	return nil
+}
+
+// synchronize 按 Report 中的参数同步代理的引擎时间，引擎ID未知时重新发现
+func (a *snmpAgent) synchronize(security snmp.SecurityParams, rediscover bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if rediscover {
+		a.engineID, a.keys = nil, nil
+		return
+	}
+	a.engineBoots, a.engineTime, a.syncedAt = security.EngineBoots, security.EngineTime, time.Now()
+}
+
+// roundTrip 发送请求并等待响应，超时后按 Retries 重发
+func (s *SNMPServer) roundTrip(ctx context.Context, agent *snmpAgent, msg *snmp.Message, keys *snmp.Keys) (*snmp.Message, []byte, error) {
+	id := int32(s.requestID.Add(1) & 0x7FFFFFFF)
+	msg.PDU.RequestID = id
+	if msg.Version == snmp.Version3 {
+		msg.MsgID = id
+	}
+	data, err := msg.Marshal(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response := make(chan snmpResponse, 1)
+	s.mu.Lock()
+	s.pending[id] = &snmpPending{addr: agent.addr.String(), response: response}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	for attempt := 0; attempt <= s.retries(); attempt++ {
+		if _, err := s.client.WriteToUDP(data, agent.addr); err != nil {
+			return nil, nil, fmt.Errorf("发送 SNMP 请求失败: %v", err)
+		}
+		timer := time.NewTimer(s.requestTimeout())
+		select {
+		case resp := <-response:
+			timer.Stop()
+			return resp.msg, resp.data, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil, nil, ErrSNMPTimeout
+}
+
+// readResponses 接收代理的响应，按请求ID交给等待的请求
+func (s *SNMPServer) readResponses() {
+	buffer := make([]byte, snmpMaxDatagramSize)
+	for {
+		n, remoteAddr, err := s.client.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			glog.Debugf(context.Background(), "读取 SNMP 响应失败: %v", err)
+			continue
+		}
+		data := append([]byte(nil), buffer[:n]...)
+		msg, err := snmp.Unmarshal(data)
+		if err != nil {
+			glog.Debugf(context.Background(), "解析 SNMP 响应失败 %s: %v\n", remoteAddr, err)
+			continue
+		}
+		id := msg.PDU.RequestID
+		if msg.Version == snmp.Version3 {
+			id = msg.MsgID
+		}
+		s.mu.Lock()
+		pending := s.pending[id]
+		s.mu.Unlock()
+		if pending == nil || pending.addr != remoteAddr.String() {
+			continue // 超时后到达的响应或来源不符
+		}
+		select {
+		case pending.response <- snmpResponse{msg: msg, data: data}:
+		default: // 重发后收到的重复响应
+		}
+	}
+}
+
+// handleTrap 处理 Trap 与 Inform,按来源地址匹配代理，作为事件上报
+func (s *SNMPServer) handleTrap(addr *net.UDPAddr, data []byte) {
+	msg, err := snmp.Unmarshal(data)
+	if err != nil {
+		glog.Debugf(context.Background(), "解析 SNMP Trap 失败 %s: %v\n", addr, err)
+		return
+	}
+	switch msg.Version {
+	case snmp.Version1, snmp.Version2c:
+		if community := s.snmpConfig.TrapCommunity; community != "" && msg.Community != community {
+			glog.Debugf(context.Background(), "SNMP Trap 团体名错误 %s\n", addr)
+			return
+		}
+	case snmp.Version3:
+		if err := s.openTrap(msg, data); err != nil {
+			glog.Debugf(context.Background(), "SNMP v3 Trap 认证失败 %s: %v\n", addr, err)
+			return
+		}
+	}
+
+	switch msg.PDU.Type {
+	case snmp.TrapV1, snmp.TrapV2, snmp.InformRequest:
+	default:
+		return
+	}
+	deviceKey, ok := s.trapDeviceKey(addr, &msg.PDU)
+	if !ok {
+		glog.Debugf(context.Background(), "丢弃未配置来源的 SNMP Trap %s\n", addr)
+		return
+	}
+
+	if msg.PDU.Type == snmp.InformRequest {
+		if msg.Version == snmp.Version3 {
+			glog.Debugf(context.Background(), "不支持 SNMP v3 Inform %s\n", addr)
+			return
+		}
+		reply := &snmp.Message{Version: msg.Version, Community: msg.Community, PDU: snmp.PDU{
+			Type: snmp.GetResponse, RequestID: msg.PDU.RequestID, Variables: msg.PDU.Variables,
+		}}
+		if data, err := reply.Marshal(nil); err == nil {
+			s.conn.WriteToUDP(data, addr)
+		}
+	}
+
+	name, params := s.trapEvent(&msg.PDU)
+	s.online(deviceKey)
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":     deviceKey,
+		"EventDataList": g.Map{name: params},
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 SNMP 代理 %s 事件失败: %v\n", deviceKey, err)
+	}
+}
+
+// openTrap 按用户名匹配 v3 代理的用户，安全级别不能低于用户的配置，以发送方的引擎ID本地化密钥后认证并解密
+func (s *SNMPServer) openTrap(msg *snmp.Message, data []byte) error {
+	var user *snmp.User
+	for _, agent := range s.agents {
+		if agent.version == snmp.Version3 && agent.user.Name == msg.Security.UserName {
+			user = &agent.user
+			break
+		}
+	}
+	if user == nil {
+		return fmt.Errorf("未知的用户 %s", msg.Security.UserName)
+	}
+	if flags := user.Flags(); msg.Flags&flags != flags {
+		return fmt.Errorf("用户 %s 的安全级别低于配置", user.Name)
+	}
+	if msg.Flags&(snmp.FlagAuth|snmp.FlagPriv) == 0 {
+		return nil // 用户配置为 noAuthNoPriv
+	}
+	cacheKey := string(msg.Security.EngineID) + "|" + msg.Security.UserName
+	s.mu.Lock()
+	keys := s.trapKeys[cacheKey]
+	s.mu.Unlock()
+	if keys == nil {
+		var err error
+		if keys, err = snmp.LocalizeKeys(*user, msg.Security.EngineID); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.trapKeys[cacheKey] = keys
+		s.mu.Unlock()
+	}
+	return msg.Open(data, keys)
+}
+
+// trapDeviceKey 按来源地址(v1 为 agent-addr)匹配代理的设备标识
+// 未配置的来源只在配置了 trapUnknown 时接收，以 IP 地址作为设备标识
+func (s *SNMPServer) trapDeviceKey(addr *net.UDPAddr, pdu *snmp.PDU) (string, bool) {
+	for deviceKey, agent := range s.agents {
+		if agent.addr.IP.Equal(addr.IP) || (pdu.Type == snmp.TrapV1 && agent.addr.IP.String() == pdu.AgentAddr) {
+			return deviceKey, true
+		}
+	}
+	return addr.IP.String(), s.snmpConfig.TrapUnknown
+}
+
+// trapEvent 按 Trap 的 OID 匹配事件，变量绑定按对象映射转换为事件参数
+// 未配置的 Trap 作为 trap 事件上报，参数 trapOid 为 Trap 的 OID
+func (s *SNMPServer) trapEvent(pdu *snmp.PDU) (string, map[string]interface{}) {
+	trapOID := snmp.TrapOID(pdu)
+	params := make(map[string]interface{})
+	name := snmpTrapEvent
+	var objects []conf.SNMPObjectConfig
+	for _, trap := range s.snmpConfig.Traps {
+		if strings.TrimPrefix(trap.OID, ".") == trapOID {
+			name, objects = trap.Name, trap.Objects
+			break
+		}
+	}
+	if name == snmpTrapEvent {
+		params["trapOid"] = trapOID
+	}
+
+	for _, v := range pdu.Variables {
+		if v.OID == snmp.OIDSysUpTime || v.OID == snmp.OIDSnmpTrapOID {
+			continue
+		}
+		object := conf.SNMPObjectConfig{Name: v.OID}
+		for _, candidate := range objects {
+			oid := strings.TrimPrefix(candidate.OID, ".")
+			if v.OID == oid || snmp.HasPrefix(v.OID, oid) {
+				object = candidate
+				break
+			}
+		}
+		s.convert(params, object.Name, v, object)
+	}
+	return name, params
+}
+
+// agent 获取设备标识对应的代理
+func (s *SNMPServer) agent(deviceKey string) (*snmpAgent, error) {
+	agent := s.agents[deviceKey]
+	if agent == nil {
+		return nil, fmt.Errorf("%w: SNMP 代理 %s 未配置", ErrDeviceNotFound, deviceKey)
+	}
+	return agent, nil
+}
+
+// requestTimeout 获取单次请求等待响应的超时
+func (s *SNMPServer) requestTimeout() time.Duration {
+	if s.snmpConfig.Timeout <= 0 {
+		return 3 * time.Second
+	}
+	return s.snmpConfig.Timeout
+}
+
+// retries 获取超时后的重试次数
+func (s *SNMPServer) retries() int {
+	if s.snmpConfig.Retries <= 0 {
+		return 1
+	}
+	return s.snmpConfig.Retries
+}
+
+// maxRepetitions 获取 GETBULK 每次获取的行数
+func (s *SNMPServer) maxRepetitions() int {
+	if s.snmpConfig.MaxRepetitions <= 0 {
+		return 10
+	}
+	return s.snmpConfig.MaxRepetitions
+}
+
+// maxVarBinds 获取单个 GET 请求的最大对象数
+func (s *SNMPServer) maxVarBinds() int {
+	if s.snmpConfig.MaxVarBinds <= 0 {
+		return 20
+	}
+	return s.snmpConfig.MaxVarBinds
+}
+
+// newSNMPAgent 解析代理的地址与版本
+func newSNMPAgent(config conf.SNMPAgentConfig) (*snmpAgent, error) {
+	address := config.Addr
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("SNMP 代理 %s 地址错误: %v", config.DeviceKey, err)
+	}
+	agent := &snmpAgent{config: config, addr: addr}
+	switch strings.ToLower(config.Version) {
+	case "1", "v1":
+		agent.version = snmp.Version1
+	case "", "2c", "v2c":
+		agent.version = snmp.Version2c
+	case "3", "v3":
+		agent.version = snmp.Version3
+		agent.user = snmp.User{
+			Name:         config.User.Name,
+			AuthProtocol: config.User.AuthProtocol,
+			AuthPassword: config.User.AuthPassword,
+			PrivProtocol: config.User.PrivProtocol,
+			PrivPassword: config.User.PrivPassword,
+		}
+		if _, err := snmp.LocalizeKeys(agent.user, nil); err != nil {
+			return nil, fmt.Errorf("SNMP 代理 %s: %w", config.DeviceKey, err)
+		}
+	default:
+		return nil, fmt.Errorf("SNMP 代理 %s 的版本 %s 错误", config.DeviceKey, config.Version)
+	}
+	return agent, nil
+}
+
+// nullVariables 创建值为 Null 的变量绑定
+func nullVariables(oids []string) []snmp.Variable {
+	variables := make([]snmp.Variable, len(oids))
+	for i, oid := range oids {
+		variables[i] = snmp.Variable{OID: strings.TrimPrefix(oid, "."), Type: snmp.TypeNull}
+	}
+	return variables
+}
@@ -0,0 +1,270 @@
+// Package snmp 实现 SNMP v1/v2c/v3 报文的 BER 编解码与 v3 的 USM 认证加密
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BER 与 SNMP 的数据类型
+const (
+	TypeInteger        byte = 0x02
+	TypeOctetString    byte = 0x04
+	TypeNull           byte = 0x05
+	TypeOID            byte = 0x06
+	TypeSequence       byte = 0x30
+	TypeIPAddress      byte = 0x40
+	TypeCounter32      byte = 0x41
+	TypeGauge32        byte = 0x42
+	TypeTimeTicks      byte = 0x43
+	TypeOpaque         byte = 0x44
+	TypeCounter64      byte = 0x46
+	TypeNoSuchObject   byte = 0x80
+	TypeNoSuchInstance byte = 0x81
+	TypeEndOfMibView   byte = 0x82
+)
+
+// ErrInvalidBER BER 编码错误
+var ErrInvalidBER = errors.New("SNMP BER 编码错误")
+
+// decoder 按 TLV 依次读取 BER 数据，偏移量相对于整个报文，用于定位 v3 的认证参数
+type decoder struct {
+	data []byte
+	pos  int
+	end  int
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{data: data, end: len(data)}
+}
+
+// more 判断是否还有未读取的数据
+func (d *decoder) more() bool {
+	return d.pos < d.end
+}
+
+// next 读取一个 TLV，返回类型、值与值在报文中的偏移量
+func (d *decoder) next() (byte, []byte, int, error) {
+	if d.end-d.pos < 2 {
+		return 0, nil, 0, ErrInvalidBER
+	}
+	tag := d.data[d.pos]
+	length := int(d.data[d.pos+1])
+	d.pos += 2
+	if length&0x80 != 0 {
+		n := length & 0x7F
+		if n == 0 || n > 4 || d.end-d.pos < n {
+			return 0, nil, 0, ErrInvalidBER
+		}
+		length = 0
+		for _, b := range d.data[d.pos : d.pos+n] {
+			length = length<<8 | int(b)
+		}
+		d.pos += n
+	}
+	if length < 0 || d.end-d.pos < length {
+		return 0, nil, 0, ErrInvalidBER
+	}
+	start := d.pos
+	d.pos += length
+	return tag, d.data[start:d.pos], start, nil
+}
+
+// expect 读取一个指定类型的 TLV
+func (d *decoder) expect(tag byte) ([]byte, int, error) {
+	t, value, start, err := d.next()
+	if err != nil {
+		return nil, 0, err
+	}
+	if t != tag {
+		return nil, 0, fmt.Errorf("%w: 期望类型 0x%02X, 实际 0x%02X", ErrInvalidBER, tag, t)
+	}
+	return value, start, nil
+}
+
+// sequence 读取一个构造类型，返回读取其内容的解码器
+func (d *decoder) sequence(tag byte) (*decoder, error) {
+	value, start, err := d.expect(tag)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{data: d.data, pos: start, end: start + len(value)}, nil
+}
+
+// integer 读取一个 INTEGER
+func (d *decoder) integer() (int64, error) {
+	value, _, err := d.expect(TypeInteger)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInteger(value)
+}
+
+// octets 读取一个 OCTET STRING
+func (d *decoder) octets() ([]byte, int, error) {
+	return d.expect(TypeOctetString)
+}
+
+// decodeInteger 解码有符号整数
+func decodeInteger(value []byte) (int64, error) {
+	if len(value) == 0 || len(value) > 8 {
+		return 0, ErrInvalidBER
+	}
+	n := int64(int8(value[0]))
+	for _, b := range value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// decodeUnsigned 解码无符号整数，Counter64 最多 9 个字节(首字节为 0)
+func decodeUnsigned(value []byte) (uint64, error) {
+	if len(value) == 0 || len(value) > 9 || (len(value) == 9 && value[0] != 0) {
+		return 0, ErrInvalidBER
+	}
+	var n uint64
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// decodeOID 解码对象标识符为 1.3.6.1 形式的字符串
+func decodeOID(value []byte) (string, error) {
+	if len(value) == 0 {
+		return "", ErrInvalidBER
+	}
+	var arcs []string
+	var arc uint64
+	for i, b := range value {
+		if arc > math.MaxUint64>>7 {
+			return "", ErrInvalidBER
+		}
+		arc = arc<<7 | uint64(b&0x7F)
+		if b&0x80 != 0 {
+			if i == len(value)-1 {
+				return "", ErrInvalidBER
+			}
+			continue
+		}
+		if len(arcs) == 0 {
+			first := min(arc/40, 2)
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(arc-first*40, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+		}
+		arc = 0
+	}
+	return strings.Join(arcs, "."), nil
+}
+
+// appendTLV 追加一个 TLV
+func appendTLV(dst []byte, tag byte, value []byte) []byte {
+	dst = append(dst, tag)
+	switch n := len(value); {
+	case n < 0x80:
+		dst = append(dst, byte(n))
+	case n <= 0xFF:
+		dst = append(dst, 0x81, byte(n))
+	case n <= 0xFFFF:
+		dst = append(dst, 0x82, byte(n>>8), byte(n))
+	default:
+		dst = append(dst, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, value...)
+}
+
+// appendInteger 追加一个 INTEGER
+func appendInteger(dst []byte, n int64) []byte {
+	return appendTLV(dst, TypeInteger, encodeInteger(n))
+}
+
+// encodeInteger 以最少的字节编码有符号整数
+func encodeInteger(n int64) []byte {
+	value := []byte{byte(n)}
+	for n >>= 8; ; n >>= 8 {
+		if (n == 0 && value[0]&0x80 == 0) || (n == -1 && value[0]&0x80 != 0) {
+			return value
+		}
+		value = append([]byte{byte(n)}, value...)
+	}
+}
+
+// encodeUnsigned 以最少的字节编码无符号整数，最高位为 1 时补 0
+func encodeUnsigned(n uint64) []byte {
+	value := []byte{byte(n)}
+	for n >>= 8; n != 0; n >>= 8 {
+		value = append([]byte{byte(n)}, value...)
+	}
+	if value[0]&0x80 != 0 {
+		value = append([]byte{0}, value...)
+	}
+	return value
+}
+
+// encodeOID 编码 1.3.6.1 形式的对象标识符
+func encodeOID(oid string) ([]byte, error) {
+	arcs, err := ParseOID(oid)
+	if err != nil {
+		return nil, err
+	}
+	if len(arcs) < 2 || arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("OID %s 格式错误", oid)
+	}
+	var value []byte
+	for _, arc := range append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...) {
+		encoded := []byte{byte(arc & 0x7F)}
+		for arc >>= 7; arc != 0; arc >>= 7 {
+			encoded = append([]byte{byte(arc&0x7F) | 0x80}, encoded...)
+		}
+		value = append(value, encoded...)
+	}
+	return value, nil
+}
+
+// ParseOID 解析 1.3.6.1 形式的对象标识符，允许以点开头
+func ParseOID(oid string) ([]uint64, error) {
+	oid = strings.TrimPrefix(oid, ".")
+	if oid == "" {
+		return nil, fmt.Errorf("OID 为空")
+	}
+	segments := strings.Split(oid, ".")
+	arcs := make([]uint64, len(segments))
+	for i, segment := range segments {
+		arc, err := strconv.ParseUint(segment, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("OID %s 格式错误", oid)
+		}
+		arcs[i] = arc
+	}
+	return arcs, nil
+}
+
+// CompareOID 按字典序比较两个对象标识符，a 小于、等于、大于 b 时分别返回 -1、0、1
+func CompareOID(a, b string) int {
+	x, _ := ParseOID(a)
+	y, _ := ParseOID(b)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			if x[i] < y[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+// HasPrefix 判断对象标识符是否位于 prefix 子树下(不含 prefix 本身)
+func HasPrefix(oid, prefix string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(oid, "."), strings.TrimPrefix(prefix, ".")+".")
+}
@@ -0,0 +1,543 @@
+package snmp
+
+import (
+	"fmt"
+	"net"
+)
+
+// 协议版本
+const (
+	Version1  = 0
+	Version2c = 1
+	Version3  = 3
+)
+
+// PDU 类型
+const (
+	GetRequest     byte = 0xA0
+	GetNextRequest byte = 0xA1
+	GetResponse    byte = 0xA2
+	SetRequest     byte = 0xA3
+	TrapV1         byte = 0xA4 // v1 Trap
+	GetBulkRequest byte = 0xA5
+	InformRequest  byte = 0xA6
+	TrapV2         byte = 0xA7 // v2c/v3 Trap
+	Report         byte = 0xA8
+)
+
+// 响应中的错误状态
+const (
+	NoError    = 0
+	TooBig     = 1
+	NoSuchName = 2 // v1 中对象不存在
+	BadValue   = 3
+	ReadOnly   = 4
+	GenErr     = 5
+)
+
+// v3 报文标志
+const (
+	FlagAuth       byte = 0x01
+	FlagPriv       byte = 0x02
+	FlagReportable byte = 0x04
+)
+
+const (
+	securityModelUSM = 3
+	authParamsLength = 12 // HMAC-MD5-96、HMAC-SHA-96 截取的长度
+)
+
+// Variable 变量绑定
+type Variable struct {
+	OID  string
+	Type byte
+	// Value 的类型：INTEGER 为 int64，OCTET STRING、Opaque 为 []byte，OID、IpAddress 为字符串，
+	// Counter32、Gauge32、TimeTicks、Counter64 为 uint64，Null 与 noSuchObject 等异常为 nil
+	Value interface{}
+}
+
+// PDU 协议数据单元
+type PDU struct {
+	Type        byte
+	RequestID   int32
+	ErrorStatus int // GETBULK 中为 non-repeaters
+	ErrorIndex  int // GETBULK 中为 max-repetitions
+	Variables   []Variable
+
+	// v1 Trap 的字段
+	Enterprise   string
+	AgentAddr    string
+	GenericTrap  int
+	SpecificTrap int
+	Timestamp    uint64
+}
+
+// SecurityParams v3 USM 安全参数
+type SecurityParams struct {
+	EngineID    []byte
+	EngineBoots int32
+	EngineTime  int32
+	UserName    string
+	AuthParams  []byte
+	PrivParams  []byte
+}
+
+// Message SNMP 报文
+type Message struct {
+	Version   int
+	Community string // v1、v2c
+	PDU       PDU
+
+	// v3 的字段
+	MsgID           int32
+	MaxSize         int32
+	Flags           byte
+	Security        SecurityParams
+	ContextEngineID []byte
+	ContextName     string
+	Encrypted       []byte // 收到的加密 ScopedPDU，Open 后解密到 PDU
+
+	authOffset int // 收到的报文中认证参数的偏移量
+}
+
+// StatusError 响应中的错误状态
+type StatusError struct {
+	Status int
+	Index  int // 出错的变量绑定序号，从 1 开始
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("SNMP 错误状态 %d, 变量序号 %d", e.Status, e.Index)
+}
+
+// Marshal 编码报文，v3 报文按 Flags 使用 keys 认证与加密
+func (m *Message) Marshal(keys *Keys) ([]byte, error) {
+	pdu, err := marshalPDU(&m.PDU)
+	if err != nil {
+		return nil, err
+	}
+	if m.Version != Version3 {
+		body := appendInteger(nil, int64(m.Version))
+		body = appendTLV(body, TypeOctetString, []byte(m.Community))
+		body = append(body, pdu...)
+		return appendTLV(nil, TypeSequence, body), nil
+	}
+
+	if m.Flags&(FlagAuth|FlagPriv) != 0 && keys == nil {
+		return nil, fmt.Errorf("SNMP v3 报文需要认证密钥")
+	}
+	scoped := appendTLV(nil, TypeOctetString, m.ContextEngineID)
+	scoped = appendTLV(scoped, TypeOctetString, []byte(m.ContextName))
+	scoped = appendTLV(nil, TypeSequence, append(scoped, pdu...))
+
+	security := m.Security
+	msgData := scoped
+	if m.Flags&FlagPriv != 0 {
+		encrypted, privParams, err := keys.encrypt(scoped, security.EngineBoots, security.EngineTime)
+		if err != nil {
+			return nil, err
+		}
+		msgData = appendTLV(nil, TypeOctetString, encrypted)
+		security.PrivParams = privParams
+	}
+	if m.Flags&FlagAuth != 0 {
+		security.AuthParams = make([]byte, authParamsLength)
+	}
+	build := func() []byte {
+		global := appendInteger(nil, int64(m.MsgID))
+		global = appendInteger(global, int64(m.MaxSize))
+		global = appendTLV(global, TypeOctetString, []byte{m.Flags})
+		global = appendInteger(global, securityModelUSM)
+
+		params := appendTLV(nil, TypeOctetString, security.EngineID)
+		params = appendInteger(params, int64(security.EngineBoots))
+		params = appendInteger(params, int64(security.EngineTime))
+		params = appendTLV(params, TypeOctetString, []byte(security.UserName))
+		params = appendTLV(params, TypeOctetString, security.AuthParams)
+		params = appendTLV(params, TypeOctetString, security.PrivParams)
+
+		body := appendInteger(nil, Version3)
+		body = appendTLV(body, TypeSequence, global)
+		body = appendTLV(body, TypeOctetString, appendTLV(nil, TypeSequence, params))
+		body = append(body, msgData...)
+		return appendTLV(nil, TypeSequence, body)
+	}
+	data := build()
+	if m.Flags&FlagAuth != 0 {
+		// 认证参数置零时计算摘要，填入摘要后报文长度不变
+		security.AuthParams = keys.sign(data)
+		data = build()
+	}
+	return data, nil
+}
+
+// Unmarshal 解码报文，v3 的加密 ScopedPDU 保存在 Encrypted 中，需要通过 Open 认证并解密
+func Unmarshal(data []byte) (*Message, error) {
+	d, err := newDecoder(data).sequence(TypeSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, err := d.integer()
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Version: int(version)}
+	switch m.Version {
+	case Version1, Version2c:
+		community, _, err := d.octets()
+		if err != nil {
+			return nil, err
+		}
+		m.Community = string(community)
+		if err := unmarshalPDU(d, &m.PDU); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case Version3:
+	default:
+		return nil, fmt.Errorf("不支持的 SNMP 版本 %d", version)
+	}
+
+	global, err := d.sequence(TypeSequence)
+	if err != nil {
+		return nil, err
+	}
+	msgID, err := global.integer()
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := global.integer()
+	if err != nil {
+		return nil, err
+	}
+	flags, _, err := global.octets()
+	if err != nil || len(flags) != 1 {
+		return nil, ErrInvalidBER
+	}
+	if model, err := global.integer(); err != nil || model != securityModelUSM {
+		return nil, fmt.Errorf("不支持的 SNMP 安全模型")
+	}
+	m.MsgID, m.MaxSize, m.Flags = int32(msgID), int32(maxSize), flags[0]
+
+	params, err := d.sequenceIn(TypeOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.unmarshalSecurity(params); err != nil {
+		return nil, err
+	}
+
+	if m.Flags&FlagPriv != 0 {
+		if m.Encrypted, _, err = d.octets(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if err := m.unmarshalScoped(d); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Open 认证并解密 v3 报文，data 为 Unmarshal 时的原始报文
+func (m *Message) Open(data []byte, keys *Keys) error {
+	if m.Version != Version3 || m.Flags&(FlagAuth|FlagPriv) == 0 {
+		return nil
+	}
+	if keys == nil {
+		return fmt.Errorf("SNMP v3 报文需要认证密钥")
+	}
+	if m.Flags&FlagAuth != 0 && !keys.verify(data, m.authOffset, m.Security.AuthParams) {
+		return ErrAuthentication
+	}
+	if m.Flags&FlagPriv != 0 {
+		plain, err := keys.decrypt(m.Encrypted, m.Security)
+		if err != nil {
+			return err
+		}
+		if err := m.unmarshalScoped(newDecoder(plain)); err != nil {
+			return err
+		}
+		m.Encrypted = nil
+	}
+	return nil
+}
+
+// sequenceIn 读取内容为一个构造类型的 OCTET STRING
+func (d *decoder) sequenceIn(tag byte) (*decoder, error) {
+	value, start, err := d.expect(tag)
+	if err != nil {
+		return nil, err
+	}
+	inner := &decoder{data: d.data, pos: start, end: start + len(value)}
+	return inner.sequence(TypeSequence)
+}
+
+// unmarshalSecurity 解码 USM 安全参数
+func (m *Message) unmarshalSecurity(d *decoder) error {
+	var err error
+	if m.Security.EngineID, _, err = d.octets(); err != nil {
+		return err
+	}
+	boots, err := d.integer()
+	if err != nil {
+		return err
+	}
+	engineTime, err := d.integer()
+	if err != nil {
+		return err
+	}
+	m.Security.EngineBoots, m.Security.EngineTime = int32(boots), int32(engineTime)
+	user, _, err := d.octets()
+	if err != nil {
+		return err
+	}
+	m.Security.UserName = string(user)
+	if m.Security.AuthParams, m.authOffset, err = d.octets(); err != nil {
+		return err
+	}
+	m.Security.PrivParams, _, err = d.octets()
+	return err
+}
+
+// unmarshalScoped 解码 ScopedPDU，解密后的数据末尾可能有填充
+func (m *Message) unmarshalScoped(d *decoder) error {
+	scoped, err := d.sequence(TypeSequence)
+	if err != nil {
+		return err
+	}
+	if m.ContextEngineID, _, err = scoped.octets(); err != nil {
+		return err
+	}
+	name, _, err := scoped.octets()
+	if err != nil {
+		return err
+	}
+	m.ContextName = string(name)
+	return unmarshalPDU(scoped, &m.PDU)
+}
+
+// marshalPDU 编码 PDU
+func marshalPDU(p *PDU) ([]byte, error) {
+	var body []byte
+	if p.Type == TrapV1 {
+		enterprise, err := encodeOID(p.Enterprise)
+		if err != nil {
+			return nil, err
+		}
+		addr := net.ParseIP(p.AgentAddr).To4()
+		if addr == nil {
+			addr = net.IPv4zero.To4()
+		}
+		body = appendTLV(body, TypeOID, enterprise)
+		body = appendTLV(body, TypeIPAddress, addr)
+		body = appendInteger(body, int64(p.GenericTrap))
+		body = appendInteger(body, int64(p.SpecificTrap))
+		body = appendTLV(body, TypeTimeTicks, encodeUnsigned(p.Timestamp))
+	} else {
+		body = appendInteger(body, int64(p.RequestID))
+		body = appendInteger(body, int64(p.ErrorStatus))
+		body = appendInteger(body, int64(p.ErrorIndex))
+	}
+
+	var list []byte
+	for _, v := range p.Variables {
+		oid, err := encodeOID(v.OID)
+		if err != nil {
+			return nil, err
+		}
+		value, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("变量 %s: %w", v.OID, err)
+		}
+		binding := appendTLV(nil, TypeOID, oid)
+		binding = appendTLV(binding, v.Type, value)
+		list = appendTLV(list, TypeSequence, binding)
+	}
+	body = appendTLV(body, TypeSequence, list)
+	return appendTLV(nil, p.Type, body), nil
+}
+
+// unmarshalPDU 解码 PDU
+func unmarshalPDU(d *decoder, p *PDU) error {
+	tag, _, _, err := (&decoder{data: d.data, pos: d.pos, end: d.end}).next()
+	if err != nil {
+		return err
+	}
+	if tag < GetRequest || tag > Report {
+		return fmt.Errorf("%w: PDU 类型 0x%02X", ErrInvalidBER, tag)
+	}
+	body, err := d.sequence(tag)
+	if err != nil {
+		return err
+	}
+	p.Type = tag
+	if tag == TrapV1 {
+		value, _, err := body.expect(TypeOID)
+		if err != nil {
+			return err
+		}
+		if p.Enterprise, err = decodeOID(value); err != nil {
+			return err
+		}
+		addr, _, err := body.expect(TypeIPAddress)
+		if err != nil || len(addr) != 4 {
+			return ErrInvalidBER
+		}
+		p.AgentAddr = net.IP(addr).String()
+		generic, err := body.integer()
+		if err != nil {
+			return err
+		}
+		specific, err := body.integer()
+		if err != nil {
+			return err
+		}
+		p.GenericTrap, p.SpecificTrap = int(generic), int(specific)
+		timestamp, _, err := body.expect(TypeTimeTicks)
+		if err != nil {
+			return err
+		}
+		if p.Timestamp, err = decodeUnsigned(timestamp); err != nil {
+			return err
+		}
+	} else {
+		id, err := body.integer()
+		if err != nil {
+			return err
+		}
+		status, err := body.integer()
+		if err != nil {
+			return err
+		}
+		index, err := body.integer()
+		if err != nil {
+			return err
+		}
+		p.RequestID, p.ErrorStatus, p.ErrorIndex = int32(id), int(status), int(index)
+	}
+
+	list, err := body.sequence(TypeSequence)
+	if err != nil {
+		return err
+	}
+	p.Variables = nil
+	for list.more() {
+		binding, err := list.sequence(TypeSequence)
+		if err != nil {
+			return err
+		}
+		value, _, err := binding.expect(TypeOID)
+		if err != nil {
+			return err
+		}
+		v := Variable{}
+		if v.OID, err = decodeOID(value); err != nil {
+			return err
+		}
+		typ, raw, _, err := binding.next()
+		if err != nil {
+			return err
+		}
+		v.Type = typ
+		if v.Value, err = decodeValue(typ, raw); err != nil {
+			return fmt.Errorf("变量 %s: %w", v.OID, err)
+		}
+		p.Variables = append(p.Variables, v)
+	}
+	return nil
+}
+
+// encodeValue 编码变量的值
+func encodeValue(v Variable) ([]byte, error) {
+	switch v.Type {
+	case TypeInteger:
+		n, ok := toInt64(v.Value)
+		if !ok {
+			return nil, fmt.Errorf("INTEGER 值不支持 %T 类型", v.Value)
+		}
+		return encodeInteger(n), nil
+	case TypeOctetString, TypeOpaque:
+		switch value := v.Value.(type) {
+		case []byte:
+			return value, nil
+		case string:
+			return []byte(value), nil
+		}
+		return nil, fmt.Errorf("OCTET STRING 值不支持 %T 类型", v.Value)
+	case TypeOID:
+		oid, ok := v.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("OID 值不支持 %T 类型", v.Value)
+		}
+		return encodeOID(oid)
+	case TypeIPAddress:
+		addr, _ := v.Value.(string)
+		ip := net.ParseIP(addr).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("IpAddress 值 %v 错误", v.Value)
+		}
+		return ip, nil
+	case TypeCounter32, TypeGauge32, TypeTimeTicks, TypeCounter64:
+		n, ok := toInt64(v.Value)
+		if u, unsigned := v.Value.(uint64); unsigned {
+			return encodeUnsigned(u), nil
+		}
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("无符号值 %v 错误", v.Value)
+		}
+		return encodeUnsigned(uint64(n)), nil
+	case TypeNull, TypeNoSuchObject, TypeNoSuchInstance, TypeEndOfMibView:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("不支持的类型 0x%02X", v.Type)
+}
+
+// decodeValue 解码变量的值
+func decodeValue(typ byte, raw []byte) (interface{}, error) {
+	switch typ {
+	case TypeInteger:
+		return decodeInteger(raw)
+	case TypeOctetString, TypeOpaque:
+		return append([]byte(nil), raw...), nil
+	case TypeOID:
+		return decodeOID(raw)
+	case TypeIPAddress:
+		if len(raw) != 4 {
+			return nil, ErrInvalidBER
+		}
+		return net.IP(raw).String(), nil
+	case TypeCounter32, TypeGauge32, TypeTimeTicks, TypeCounter64:
+		return decodeUnsigned(raw)
+	case TypeNull, TypeNoSuchObject, TypeNoSuchInstance, TypeEndOfMibView:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("不支持的类型 0x%02X", typ)
+}
+
+// toInt64 将整数类型转换为 int64
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
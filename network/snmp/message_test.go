@@ -0,0 +1,211 @@
+package snmp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessageEncoding(t *testing.T) {
+	// snmpget -v2c -c public 目标 1.3.6.1.2.1.1.1.0 的请求报文
+	msg := &Message{Version: Version2c, Community: "public", PDU: PDU{
+		Type: GetRequest, RequestID: 1,
+		Variables: []Variable{{OID: "1.3.6.1.2.1.1.1.0", Type: TypeNull}},
+	}}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("302602010104067075626c6963a01902010102010002010030" + "0e300c06082b060102010101000500")
+	if !bytes.Equal(data, want) {
+		t.Fatalf("编码结果 % X", data)
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Fatalf("解码结果 %+v", got)
+	}
+
+	if value := encodeInteger(128); !bytes.Equal(value, []byte{0x00, 0x80}) {
+		t.Fatalf("128 编码为 % X", value)
+	}
+	if value := encodeInteger(-129); !bytes.Equal(value, []byte{0xFF, 0x7F}) {
+		t.Fatalf("-129 编码为 % X", value)
+	}
+	if value := encodeUnsigned(0xFFFFFFFF); !bytes.Equal(value, []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Fatalf("0xFFFFFFFF 编码为 % X", value)
+	}
+	if value, _ := encodeOID(".1.3.6.1.4.1.2680"); !bytes.Equal(value, []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0x94, 0x78}) {
+		t.Fatalf("OID 编码为 % X", value)
+	}
+	if _, err := Unmarshal(data[:20]); !errors.Is(err, ErrInvalidBER) {
+		t.Fatalf("截断的报文应返回 ErrInvalidBER: %v", err)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	variables := []Variable{
+		{OID: "1.3.6.1.2.1.1.5.0", Type: TypeOctetString, Value: []byte("ups-01")},
+		{OID: "1.3.6.1.2.1.1.3.0", Type: TypeTimeTicks, Value: uint64(123456)},
+		{OID: "1.3.6.1.2.1.2.2.1.10.1", Type: TypeCounter32, Value: uint64(0xFFFFFFFF)},
+		{OID: "1.3.6.1.2.1.31.1.1.1.6.1", Type: TypeCounter64, Value: uint64(1 << 63)},
+		{OID: "1.3.6.1.2.1.33.1.2.3.0", Type: TypeInteger, Value: int64(-40)},
+		{OID: "1.3.6.1.2.1.4.20.1.1.10.0.0.1", Type: TypeIPAddress, Value: "10.0.0.1"},
+		{OID: "1.3.6.1.6.3.1.1.4.1.0", Type: TypeOID, Value: "1.3.6.1.6.3.1.1.5.3"},
+		{OID: "1.3.6.1.2.1.1.9.0", Type: TypeNoSuchObject},
+	}
+	messages := []*Message{
+		{Version: Version2c, Community: "private", PDU: PDU{Type: GetResponse, RequestID: -5, Variables: variables}},
+		{Version: Version1, Community: "public", PDU: PDU{
+			Type: TrapV1, Enterprise: "1.3.6.1.4.1.318", AgentAddr: "192.168.1.10", GenericTrap: 6, SpecificTrap: 5, Timestamp: 99,
+			Variables: variables[:1],
+		}},
+	}
+	for _, msg := range messages {
+		data, err := msg.Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Fatalf("解码结果 %+v, 期望 %+v", got, msg)
+		}
+	}
+
+	engineID := []byte{0x80, 0x00, 0x1F, 0x88, 0x04, 0x01}
+	users := []User{
+		{Name: "none"},
+		{Name: "md5des", AuthProtocol: AuthMD5, AuthPassword: "authpass1", PrivProtocol: PrivDES, PrivPassword: "privpass1"},
+		{Name: "shaaes", AuthProtocol: AuthSHA, AuthPassword: "authpass2", PrivProtocol: PrivAES, PrivPassword: "privpass2"},
+		{Name: "sha", AuthProtocol: AuthSHA, AuthPassword: "authpass3"},
+	}
+	for _, user := range users {
+		keys, err := LocalizeKeys(user, engineID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &Message{
+			Version: Version3, MsgID: 42, MaxSize: 65507, Flags: user.Flags() | FlagReportable,
+			Security:        SecurityParams{EngineID: engineID, EngineBoots: 3, EngineTime: 1000, UserName: user.Name},
+			ContextEngineID: engineID, ContextName: "",
+			PDU: PDU{Type: GetResponse, RequestID: 42, Variables: variables},
+		}
+		data, err := msg.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.PrivProtocol != "" && (got.Encrypted == nil || got.PDU.Variables != nil) {
+			t.Fatalf("用户 %s 的 PDU 应被加密", user.Name)
+		}
+		if err := got.Open(data, keys); err != nil {
+			t.Fatalf("用户 %s: %v", user.Name, err)
+		}
+		if !reflect.DeepEqual(got.PDU, msg.PDU) || got.Security.UserName != user.Name || got.MsgID != 42 {
+			t.Fatalf("用户 %s 解码结果 %+v", user.Name, got)
+		}
+
+		if user.AuthProtocol != "" {
+			tampered := append([]byte(nil), data...)
+			tampered[len(tampered)-1] ^= 0x01
+			got, err := Unmarshal(tampered)
+			if err == nil {
+				err = got.Open(tampered, keys)
+			}
+			if err == nil {
+				t.Fatalf("用户 %s 篡改的报文应认证失败", user.Name)
+			}
+		}
+	}
+}
+
+func TestLocalizeKey(t *testing.T) {
+	// RFC 3414 A.3 的测试向量
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	if key := localizeKey(md5.New, "maplesyrup", engineID); hex.EncodeToString(key) != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Fatalf("MD5 本地化密钥 %x", key)
+	}
+	if key := localizeKey(sha1.New, "maplesyrup", engineID); hex.EncodeToString(key) != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Fatalf("SHA 本地化密钥 %x", key)
+	}
+	if _, err := LocalizeKeys(User{Name: "u", AuthProtocol: AuthMD5, AuthPassword: "short"}, engineID); err == nil {
+		t.Fatal("认证口令过短应返回错误")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		variable Variable
+		typ      string
+		scale    float64
+		want     interface{}
+	}{
+		{Variable{Type: TypeInteger, Value: int64(230)}, ValueAuto, 0, int64(230)},
+		{Variable{Type: TypeInteger, Value: int64(2305)}, ValueAuto, 0.1, 230.5},
+		{Variable{Type: TypeGauge32, Value: uint64(95)}, ValueFloat, 0, 95.0},
+		{Variable{Type: TypeOctetString, Value: []byte("Smart-UPS")}, ValueAuto, 0, "Smart-UPS"},
+		{Variable{Type: TypeOctetString, Value: []byte{0x00, 0x1A, 0x2B}}, ValueAuto, 0, "001A2B"},
+		{Variable{Type: TypeOctetString, Value: []byte(" 21.5")}, ValueFloat, 0, 21.5},
+		{Variable{Type: TypeOctetString, Value: []byte("12")}, ValueInt, 0, int64(12)},
+		{Variable{Type: TypeInteger, Value: int64(1)}, ValueBool, 0, true},
+		{Variable{Type: TypeInteger, Value: int64(2)}, ValueBool, 0, false},
+		{Variable{Type: TypeTimeTicks, Value: uint64(100)}, ValueString, 0, "100"},
+	}
+	for _, tt := range tests {
+		got, err := Convert(tt.variable, tt.typ, tt.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("%v 按 %q 转换为 %#v, 期望 %#v", tt.variable.Value, tt.typ, got, tt.want)
+		}
+	}
+	if _, err := Convert(Variable{Type: TypeNoSuchInstance}, ValueAuto, 0); err == nil {
+		t.Fatal("异常变量应返回错误")
+	}
+	if _, err := Convert(Variable{Type: TypeOctetString, Value: []byte("x")}, ValueBool, 0); err == nil {
+		t.Fatal("字符串不能转换为 bool")
+	}
+
+	v, err := SetValue("1.3.6.1.4.1.318.1.1.1.5.2.3.0", ValueFloat, 0.1, 23.5)
+	if err != nil || v.Type != TypeInteger || v.Value != int64(235) {
+		t.Fatalf("SET 变量 %+v %v", v, err)
+	}
+	if v, _ := SetValue("1.3.6.1.2.1.1.5.0", ValueString, 0, "ups-02"); v.Type != TypeOctetString || string(v.Value.([]byte)) != "ups-02" {
+		t.Fatalf("SET 变量 %+v", v)
+	}
+	if v, _ := SetValue("1.3.6.1.2.1.2.2.1.7.1", ValueBool, 0, false); v.Value != int64(2) {
+		t.Fatalf("SET 变量 %+v", v)
+	}
+}
+
+func TestTrapOID(t *testing.T) {
+	if oid := TrapOID(&PDU{Type: TrapV1, GenericTrap: 2}); oid != "1.3.6.1.6.3.1.1.5.3" {
+		t.Fatalf("linkDown 转换为 %s", oid)
+	}
+	if oid := TrapOID(&PDU{Type: TrapV1, Enterprise: "1.3.6.1.4.1.318", GenericTrap: 6, SpecificTrap: 5}); oid != "1.3.6.1.4.1.318.0.5" {
+		t.Fatalf("企业 Trap 转换为 %s", oid)
+	}
+	pdu := &PDU{Type: TrapV2, Variables: []Variable{
+		{OID: OIDSysUpTime, Type: TypeTimeTicks, Value: uint64(1)},
+		{OID: OIDSnmpTrapOID, Type: TypeOID, Value: "1.3.6.1.4.1.318.0.5"},
+	}}
+	if oid := TrapOID(pdu); oid != "1.3.6.1.4.1.318.0.5" {
+		t.Fatalf("v2c Trap 的 OID 为 %s", oid)
+	}
+	if CompareOID("1.3.6.1.2.1.2.2.1.10.2", "1.3.6.1.2.1.2.2.1.10.10") != -1 || !HasPrefix("1.3.6.1.2.1.2.2.1.10.2", ".1.3.6.1.2.1.2.2.1.10") {
+		t.Fatal("OID 比较错误")
+	}
+}
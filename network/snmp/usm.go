@@ -0,0 +1,217 @@
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync/atomic"
+)
+
+// 认证与加密协议
+const (
+	AuthMD5 = "MD5"
+	AuthSHA = "SHA"
+	PrivDES = "DES"
+	PrivAES = "AES" // AES-128-CFB(RFC 3826)
+)
+
+// USM 统计对象，代理以 Report 返回认证失败的原因(RFC 3414)
+const (
+	OIDUnsupportedSecLevels = "1.3.6.1.6.3.15.1.1.1.0"
+	OIDNotInTimeWindows     = "1.3.6.1.6.3.15.1.1.2.0"
+	OIDUnknownUserNames     = "1.3.6.1.6.3.15.1.1.3.0"
+	OIDUnknownEngineIDs     = "1.3.6.1.6.3.15.1.1.4.0"
+	OIDWrongDigests         = "1.3.6.1.6.3.15.1.1.5.0"
+	OIDDecryptionErrors     = "1.3.6.1.6.3.15.1.1.6.0"
+)
+
+// ErrAuthentication v3 报文认证失败
+var ErrAuthentication = errors.New("SNMP v3 报文认证失败")
+
+// salt 加密使用的本地计数器，随机初始化
+var salt atomic.Uint64
+
+func init() {
+	var b [8]byte
+	rand.Read(b[:])
+	salt.Store(binary.BigEndian.Uint64(b[:]))
+}
+
+// User v3 用户
+type User struct {
+	Name         string
+	AuthProtocol string // MD5/SHA,为空时不认证
+	AuthPassword string
+	PrivProtocol string // DES/AES,为空时不加密
+	PrivPassword string
+}
+
+// Flags 返回用户的安全级别对应的报文标志
+func (u User) Flags() byte {
+	var flags byte
+	if u.AuthProtocol != "" {
+		flags |= FlagAuth
+		if u.PrivProtocol != "" {
+			flags |= FlagPriv
+		}
+	}
+	return flags
+}
+
+// Keys 按引擎ID本地化后的密钥
+type Keys struct {
+	auth    func() hash.Hash
+	authKey []byte
+	priv    string
+	privKey []byte
+}
+
+// LocalizeKeys 按 RFC 3414 A.2 由口令和引擎ID生成本地化的认证与加密密钥，用户不认证时返回 nil
+func LocalizeKeys(user User, engineID []byte) (*Keys, error) {
+	if user.AuthProtocol == "" {
+		return nil, nil
+	}
+	keys := &Keys{priv: strings.ToUpper(user.PrivProtocol)}
+	switch strings.ToUpper(user.AuthProtocol) {
+	case AuthMD5:
+		keys.auth = md5.New
+	case AuthSHA:
+		keys.auth = sha1.New
+	default:
+		return nil, fmt.Errorf("不支持的 SNMP 认证协议 %s", user.AuthProtocol)
+	}
+	if len(user.AuthPassword) < 8 {
+		return nil, fmt.Errorf("SNMP 用户 %s 的认证口令至少 8 个字符", user.Name)
+	}
+	keys.authKey = localizeKey(keys.auth, user.AuthPassword, engineID)
+
+	switch keys.priv {
+	case "":
+		return keys, nil
+	case PrivDES, PrivAES:
+	default:
+		return nil, fmt.Errorf("不支持的 SNMP 加密协议 %s", user.PrivProtocol)
+	}
+	if len(user.PrivPassword) < 8 {
+		return nil, fmt.Errorf("SNMP 用户 %s 的加密口令至少 8 个字符", user.Name)
+	}
+	keys.privKey = localizeKey(keys.auth, user.PrivPassword, engineID)[:16]
+	return keys, nil
+}
+
+// localizeKey 将口令重复展开为 1MB 计算摘要，再与引擎ID一起计算本地化的密钥
+func localizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	buffer := make([]byte, 64)
+	for i := 0; i < 1048576; i += len(buffer) {
+		for j := range buffer {
+			buffer[j] = password[(i+j)%len(password)]
+		}
+		h.Write(buffer)
+	}
+	key := h.Sum(nil)
+
+	h.Reset()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// sign 计算报文的摘要，报文中的认证参数需要置零
+func (k *Keys) sign(data []byte) []byte {
+	mac := hmac.New(k.auth, k.authKey)
+	mac.Write(data)
+	return mac.Sum(nil)[:authParamsLength]
+}
+
+// verify 将报文中的认证参数置零后校验摘要
+func (k *Keys) verify(data []byte, offset int, authParams []byte) bool {
+	if len(authParams) != authParamsLength || offset+authParamsLength > len(data) {
+		return false
+	}
+	zeroed := append([]byte(nil), data...)
+	copy(zeroed[offset:offset+authParamsLength], make([]byte, authParamsLength))
+	return subtle.ConstantTimeCompare(k.sign(zeroed), authParams) == 1
+}
+
+// encrypt 加密 ScopedPDU，返回密文与加密参数(salt)
+func (k *Keys) encrypt(plain []byte, boots, engineTime int32) ([]byte, []byte, error) {
+	privParams := make([]byte, 8)
+	switch k.priv {
+	case PrivDES:
+		binary.BigEndian.PutUint32(privParams, uint32(boots))
+		binary.BigEndian.PutUint32(privParams[4:], uint32(salt.Add(1)))
+		block, err := des.NewCipher(k.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, des.BlockSize)
+		for i := range iv {
+			iv[i] = k.privKey[8+i] ^ privParams[i]
+		}
+		padded := append(append([]byte(nil), plain...), make([]byte, (des.BlockSize-len(plain)%des.BlockSize)%des.BlockSize)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+		return padded, privParams, nil
+	case PrivAES:
+		binary.BigEndian.PutUint64(privParams, salt.Add(1))
+		block, err := aes.NewCipher(k.privKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		encrypted := make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, privParams)).XORKeyStream(encrypted, plain)
+		return encrypted, privParams, nil
+	}
+	return nil, nil, fmt.Errorf("SNMP 用户未配置加密协议")
+}
+
+// decrypt 解密 ScopedPDU
+func (k *Keys) decrypt(encrypted []byte, security SecurityParams) ([]byte, error) {
+	if len(security.PrivParams) != 8 {
+		return nil, fmt.Errorf("SNMP 加密参数长度 %d 错误", len(security.PrivParams))
+	}
+	switch k.priv {
+	case PrivDES:
+		if len(encrypted)%des.BlockSize != 0 {
+			return nil, fmt.Errorf("SNMP DES 密文长度 %d 错误", len(encrypted))
+		}
+		block, err := des.NewCipher(k.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, des.BlockSize)
+		for i := range iv {
+			iv[i] = k.privKey[8+i] ^ security.PrivParams[i]
+		}
+		plain := make([]byte, len(encrypted))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, encrypted)
+		return plain, nil
+	case PrivAES:
+		block, err := aes.NewCipher(k.privKey)
+		if err != nil {
+			return nil, err
+		}
+		plain := make([]byte, len(encrypted))
+		cipher.NewCFBDecrypter(block, aesIV(security.EngineBoots, security.EngineTime, security.PrivParams)).XORKeyStream(plain, encrypted)
+		return plain, nil
+	}
+	return nil, fmt.Errorf("SNMP 用户未配置加密协议")
+}
+
+// aesIV AES 的初始向量为 引擎启动次数、引擎时间与 salt 的拼接
+func aesIV(boots, engineTime int32, privParams []byte) []byte {
+	iv := binary.BigEndian.AppendUint32(nil, uint32(boots))
+	iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+	return append(iv, privParams...)
+}
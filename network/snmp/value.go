@@ -0,0 +1,183 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 属性的数据类型
+const (
+	ValueAuto   = ""       // 按 SNMP 类型转换：整数与计数器为数值，可打印的 OCTET STRING 为字符串，否则为十六进制
+	ValueInt    = "int"    // 整数
+	ValueFloat  = "float"  // 浮点数，常与 scale 一起使用，如 0.1 表示原始值为十分之一单位
+	ValueString = "string" // 字符串
+	ValueHex    = "hex"    // 大写十六进制字符串，如 MAC 地址
+	ValueBool   = "bool"   // TruthValue,1 为 true
+)
+
+// 标准 Trap 的 OID(RFC 3584)
+const (
+	OIDSysUpTime      = "1.3.6.1.2.1.1.3.0"
+	OIDSnmpTrapOID    = "1.3.6.1.6.3.1.1.4.1.0"
+	oidStandardTraps  = "1.3.6.1.6.3.1.1.5"
+	genericEnterprise = 6
+)
+
+// Exception 判断变量是否为 noSuchObject、noSuchInstance、endOfMibView 异常
+func (v Variable) Exception() bool {
+	return v.Type == TypeNoSuchObject || v.Type == TypeNoSuchInstance || v.Type == TypeEndOfMibView
+}
+
+// Convert 按属性的数据类型转换变量的值，scale 不为 0 时数值乘以 scale 后作为浮点数
+func Convert(v Variable, typ string, scale float64) (interface{}, error) {
+	if v.Exception() || v.Type == TypeNull {
+		return nil, fmt.Errorf("变量 %s 没有值", v.OID)
+	}
+	var number float64
+	numeric := false
+	switch value := v.Value.(type) {
+	case int64:
+		number, numeric = float64(value), true
+	case uint64:
+		number, numeric = float64(value), true
+	}
+
+	switch typ {
+	case ValueAuto:
+		if numeric && scale != 0 {
+			return number * scale, nil
+		}
+		if data, ok := v.Value.([]byte); ok {
+			if utf8.Valid(data) && printable(data) {
+				return string(data), nil
+			}
+			return strings.ToUpper(hex.EncodeToString(data)), nil
+		}
+		return v.Value, nil
+	case ValueInt:
+		if numeric {
+			if scale != 0 {
+				return int64(number * scale), nil
+			}
+			return v.Value, nil
+		}
+		if data, ok := v.Value.([]byte); ok {
+			return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		}
+	case ValueFloat:
+		if !numeric {
+			data, ok := v.Value.([]byte)
+			if !ok {
+				break
+			}
+			var err error
+			if number, err = strconv.ParseFloat(strings.TrimSpace(string(data)), 64); err != nil {
+				return nil, fmt.Errorf("变量 %s 的值 %q 不是数值", v.OID, data)
+			}
+		}
+		if scale != 0 {
+			number *= scale
+		}
+		return number, nil
+	case ValueString:
+		if data, ok := v.Value.([]byte); ok {
+			return string(data), nil
+		}
+		return fmt.Sprint(v.Value), nil
+	case ValueHex:
+		if data, ok := v.Value.([]byte); ok {
+			return strings.ToUpper(hex.EncodeToString(data)), nil
+		}
+	case ValueBool:
+		if numeric {
+			return number == 1, nil
+		}
+	default:
+		return nil, fmt.Errorf("不支持的属性类型 %s", typ)
+	}
+	return nil, fmt.Errorf("变量 %s 的类型 0x%02X 无法转换为 %s", v.OID, v.Type, typ)
+}
+
+// SetValue 将属性值转换为 SET 请求的变量，字符串与十六进制类型为 OCTET STRING，其余为 INTEGER
+func SetValue(oid string, typ string, scale float64, value interface{}) (Variable, error) {
+	v := Variable{OID: oid, Type: TypeInteger}
+	switch typ {
+	case ValueString:
+		v.Type, v.Value = TypeOctetString, []byte(fmt.Sprint(value))
+		return v, nil
+	case ValueHex:
+		data, err := hex.DecodeString(fmt.Sprint(value))
+		if err != nil {
+			return v, fmt.Errorf("值 %v 不是十六进制字符串", value)
+		}
+		v.Type, v.Value = TypeOctetString, data
+		return v, nil
+	case ValueBool:
+		if b, ok := value.(bool); ok {
+			v.Value = int64(2) // TruthValue 的 false 为 2
+			if b {
+				v.Value = int64(1)
+			}
+			return v, nil
+		}
+	}
+
+	var number float64
+	switch n := value.(type) {
+	case float64:
+		number = n
+	case float32:
+		number = float64(n)
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			if typ == ValueAuto {
+				v.Type, v.Value = TypeOctetString, []byte(n)
+				return v, nil
+			}
+			return v, fmt.Errorf("值 %q 不是数值", n)
+		}
+		number = f
+	default:
+		i, ok := toInt64(value)
+		if !ok {
+			return v, fmt.Errorf("值不支持 %T 类型", value)
+		}
+		number = float64(i)
+	}
+	if scale != 0 {
+		number /= scale
+	}
+	v.Value = int64(number)
+	return v, nil
+}
+
+// TrapOID 返回 Trap 的 OID,v1 Trap 按 RFC 3584 转换，v2c/v3 Trap 取 snmpTrapOID.0 的值
+func TrapOID(p *PDU) string {
+	if p.Type == TrapV1 {
+		if p.GenericTrap != genericEnterprise {
+			return oidStandardTraps + "." + strconv.Itoa(p.GenericTrap+1)
+		}
+		return p.Enterprise + ".0." + strconv.Itoa(p.SpecificTrap)
+	}
+	for _, v := range p.Variables {
+		if v.OID == OIDSnmpTrapOID {
+			oid, _ := v.Value.(string)
+			return oid
+		}
+	}
+	return ""
+}
+
+// printable 判断数据是否都是可打印字符
+func printable(data []byte) bool {
+	for _, r := range string(data) {
+		if r < 0x20 && r != '\t' && r != '\r' && r != '\n' || r == 0x7F {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,342 @@
+package network
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/network/snmp"
+)
+
+// snmpTestAgent 测试用 SNMP 代理，v3 用户不为空时只接受该用户的请求
+type snmpTestAgent struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	user     snmp.User
+	engineID []byte
+
+	mu  sync.Mutex
+	mib map[string]snmp.Variable
+}
+
+func newSNMPTestAgent(t *testing.T, ip net.IP, user snmp.User, mib []snmp.Variable) *snmpTestAgent {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a := &snmpTestAgent{t: t, conn: conn, user: user, engineID: []byte{0x80, 0x00, 0x1F, 0x88, 0x04, 0x74, 0x65, 0x73, 0x74}, mib: make(map[string]snmp.Variable)}
+	for _, v := range mib {
+		a.mib[v.OID] = v
+	}
+	go a.serve()
+	return a
+}
+
+func (a *snmpTestAgent) serve() {
+	keys, _ := snmp.LocalizeKeys(a.user, a.engineID)
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		data := append([]byte(nil), buffer[:n]...)
+		msg, err := snmp.Unmarshal(data)
+		if err != nil {
+			continue
+		}
+		reply := &snmp.Message{Version: msg.Version, Community: msg.Community, MsgID: msg.MsgID, MaxSize: msg.MaxSize}
+		if msg.Version == snmp.Version3 {
+			reply.Security = snmp.SecurityParams{EngineID: a.engineID, EngineBoots: 1, EngineTime: 500, UserName: msg.Security.UserName}
+			reply.ContextEngineID = a.engineID
+			if len(msg.Security.EngineID) == 0 { // 引擎发现
+				reply.PDU = snmp.PDU{Type: snmp.Report, RequestID: msg.PDU.RequestID, Variables: []snmp.Variable{
+					{OID: snmp.OIDUnknownEngineIDs, Type: snmp.TypeCounter32, Value: uint64(1)},
+				}}
+				a.send(addr, reply, nil)
+				continue
+			}
+			if msg.Security.UserName != a.user.Name || msg.Open(data, keys) != nil {
+				continue
+			}
+			reply.Flags = a.user.Flags()
+			if msg.Security.EngineTime < 400 { // 时间窗口外，返回认证的 Report
+				reply.Flags = snmp.FlagAuth
+				reply.PDU = snmp.PDU{Type: snmp.Report, RequestID: msg.PDU.RequestID, Variables: []snmp.Variable{
+					{OID: snmp.OIDNotInTimeWindows, Type: snmp.TypeCounter32, Value: uint64(1)},
+				}}
+				a.send(addr, reply, keys)
+				continue
+			}
+		}
+		reply.PDU = a.respond(&msg.PDU)
+		a.send(addr, reply, keys)
+	}
+}
+
+func (a *snmpTestAgent) send(addr *net.UDPAddr, msg *snmp.Message, keys *snmp.Keys) {
+	data, err := msg.Marshal(keys)
+	if err != nil {
+		a.t.Error(err)
+		return
+	}
+	a.conn.WriteToUDP(data, addr)
+}
+
+// respond 按 MIB 处理 GET、GETNEXT、GETBULK 与 SET
+func (a *snmpTestAgent) respond(req *snmp.PDU) snmp.PDU {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	resp := snmp.PDU{Type: snmp.GetResponse, RequestID: req.RequestID}
+	oids := make([]string, 0, len(a.mib))
+	for oid := range a.mib {
+		oids = append(oids, oid)
+	}
+	sort.Slice(oids, func(i, j int) bool { return snmp.CompareOID(oids[i], oids[j]) < 0 })
+	next := func(oid string) snmp.Variable {
+		for _, candidate := range oids {
+			if snmp.CompareOID(candidate, oid) > 0 {
+				return a.mib[candidate]
+			}
+		}
+		return snmp.Variable{OID: oid, Type: snmp.TypeEndOfMibView}
+	}
+
+	switch req.Type {
+	case snmp.GetRequest:
+		for _, v := range req.Variables {
+			value, ok := a.mib[v.OID]
+			if !ok {
+				value = snmp.Variable{OID: v.OID, Type: snmp.TypeNoSuchInstance}
+			}
+			resp.Variables = append(resp.Variables, value)
+		}
+	case snmp.GetNextRequest:
+		for _, v := range req.Variables {
+			resp.Variables = append(resp.Variables, next(v.OID))
+		}
+	case snmp.GetBulkRequest:
+		oid := req.Variables[0].OID
+		for i := 0; i < req.ErrorIndex; i++ {
+			v := next(oid)
+			resp.Variables = append(resp.Variables, v)
+			if v.Type == snmp.TypeEndOfMibView {
+				break
+			}
+			oid = v.OID
+		}
+	case snmp.SetRequest:
+		for i, v := range req.Variables {
+			if current, ok := a.mib[v.OID]; !ok || current.Type != v.Type {
+				return snmp.PDU{Type: snmp.GetResponse, RequestID: req.RequestID, ErrorStatus: 7, ErrorIndex: i + 1, Variables: req.Variables}
+			}
+			a.mib[v.OID] = v
+		}
+		resp.Variables = req.Variables
+	}
+	return resp
+}
+
+func (a *snmpTestAgent) value(oid string) interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.mib[oid].Value
+}
+
+func TestSNMPServer(t *testing.T) {
+	ups := newSNMPTestAgent(t, net.IPv4(127, 0, 0, 1), snmp.User{}, []snmp.Variable{
+		{OID: "1.3.6.1.2.1.1.5.0", Type: snmp.TypeOctetString, Value: []byte("ups-01")},
+		{OID: "1.3.6.1.2.1.33.1.2.4.0", Type: snmp.TypeInteger, Value: int64(95)},
+		{OID: "1.3.6.1.2.1.33.1.4.4.1.2.1", Type: snmp.TypeInteger, Value: int64(2301)},
+		{OID: "1.3.6.1.2.1.33.1.4.4.1.2.2", Type: snmp.TypeInteger, Value: int64(2298)},
+		{OID: "1.3.6.1.2.1.33.1.4.4.1.2.3", Type: snmp.TypeInteger, Value: int64(2310)},
+		{OID: "1.3.6.1.2.1.33.1.4.4.1.3.1", Type: snmp.TypeInteger, Value: int64(12)},
+		{OID: "1.3.6.1.4.1.318.1.1.1.5.2.3.0", Type: snmp.TypeInteger, Value: int64(250)},
+	})
+	user := snmp.User{Name: "monitor", AuthProtocol: snmp.AuthSHA, AuthPassword: "authpass1", PrivProtocol: snmp.PrivAES, PrivPassword: "privpass1"}
+	// Trap 按来源地址匹配代理，两个代理使用不同的地址
+	crac := newSNMPTestAgent(t, net.IPv4(127, 0, 0, 2), user, []snmp.Variable{
+		{OID: "1.3.6.1.4.1.476.1.42.3.4.1.2.3.1.3.1", Type: snmp.TypeOctetString, Value: []byte("21.5")},
+	})
+
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trapAddr := probe.LocalAddr().(*net.UDPAddr)
+	probe.Close()
+
+	// 监听器需要在服务器启动前注册
+	reports := make(chan map[string]interface{}, 16)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		switch e.Data()["DeviceKey"] {
+		case "UPS-01", "CRAC-01", "127.0.0.3": // 127.0.0.3 为未配置的来源，不应上报
+			reports <- e.Data()
+		}
+		return nil
+	}))
+
+	scale := 0.1
+	server := NewSNMPServer(WithSNMPConfig(conf.SNMPConfig{
+		Timeout:        200 * time.Millisecond,
+		MaxRepetitions: 2,
+		Agents: []conf.SNMPAgentConfig{
+			{DeviceKey: "UPS-01", Addr: ups.conn.LocalAddr().String()},
+			{DeviceKey: "CRAC-01", Addr: crac.conn.LocalAddr().String(), Version: "3", User: conf.SNMPUserConfig{
+				Name: user.Name, AuthProtocol: user.AuthProtocol, AuthPassword: user.AuthPassword, PrivProtocol: user.PrivProtocol, PrivPassword: user.PrivPassword,
+			}},
+		},
+		Groups: []conf.SNMPGroupConfig{
+			{Name: "ups", DeviceKeys: []string{"UPS-01"}, Interval: time.Hour, Objects: []conf.SNMPObjectConfig{
+				{Name: "sysName", OID: ".1.3.6.1.2.1.1.5.0"},
+				{Name: "batteryCapacity", OID: "1.3.6.1.2.1.33.1.2.4.0", Type: "int"},
+				{Name: "outputVoltage", OID: "1.3.6.1.2.1.33.1.4.4.1.2", Scale: scale, Walk: true},
+				{Name: "missing", OID: "1.3.6.1.2.1.1.9.0"},
+				{Name: "lowBatteryDuration", OID: "1.3.6.1.4.1.318.1.1.1.5.2.3.0"},
+			}},
+			{Name: "crac", DeviceKeys: []string{"CRAC-01"}, Interval: time.Hour, Objects: []conf.SNMPObjectConfig{
+				{Name: "returnTemperature", OID: "1.3.6.1.4.1.476.1.42.3.4.1.2.3.1.3.1", Type: "float"},
+			}},
+		},
+		TrapCommunity: "public",
+		Traps: []conf.SNMPTrapConfig{
+			{Name: "onBattery", OID: "1.3.6.1.4.1.318.0.5", Objects: []conf.SNMPObjectConfig{
+				{Name: "message", OID: "1.3.6.1.4.1.318.2.3.3"},
+			}},
+		},
+	})).(*SNMPServer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, trapAddr.String())
+
+	// 两个代理各上报一次，顺序不定
+	want := map[string]map[string]interface{}{
+		"UPS-01": {
+			"sysName": "ups-01", "batteryCapacity": int64(95), "lowBatteryDuration": int64(250),
+			"outputVoltage_1": 2301 * scale, "outputVoltage_2": 2298 * scale, "outputVoltage_3": 2310 * scale,
+		},
+		"CRAC-01": {"returnTemperature": 21.5},
+	}
+	for range want {
+		select {
+		case report := <-reports:
+			deviceKey := report["DeviceKey"].(string)
+			if !reflect.DeepEqual(report["PropertieDataList"], want[deviceKey]) {
+				t.Fatalf("代理 %s 上报 %v", deviceKey, report["PropertieDataList"])
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("没有轮询上报")
+		}
+	}
+	device := server.LookupDevice("UPS-01")
+	if device == nil || server.LookupDevice("CRAC-01") == nil {
+		t.Fatal("轮询成功后代理应上线")
+	}
+
+	// 按对象映射写入
+	if err := server.SendData(device, map[string]interface{}{"lowBatteryDuration": 300, "unknown": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if value := ups.value("1.3.6.1.4.1.318.1.1.1.5.2.3.0"); value != int64(300) {
+		t.Fatalf("写入后的值 %v", value)
+	}
+	if err := server.SendData(device, map[string]interface{}{"sysName": 2}); err == nil {
+		t.Fatal("类型不符的写入应返回错误")
+	}
+
+	// Trap 与 Inform 作为事件上报
+	conn, err := net.DialUDP("udp", nil, trapAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sendTrap := func(msg *snmp.Message) {
+		t.Helper()
+		data, err := msg.Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(data)
+	}
+	expectEvent := func(deviceKey string, events map[string]interface{}) {
+		t.Helper()
+		select {
+		case report := <-reports:
+			if report["DeviceKey"] != deviceKey || !reflect.DeepEqual(report["EventDataList"], events) {
+				t.Fatalf("上报事件 %v", report)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("没有上报事件")
+		}
+	}
+
+	sendTrap(&snmp.Message{Version: snmp.Version2c, Community: "wrong", PDU: snmp.PDU{Type: snmp.TrapV2, RequestID: 1}})
+	sendTrap(&snmp.Message{Version: snmp.Version1, Community: "public", PDU: snmp.PDU{
+		Type: snmp.TrapV1, Enterprise: "1.3.6.1.4.1.318", AgentAddr: "127.0.0.1", GenericTrap: 6, SpecificTrap: 5,
+		Variables: []snmp.Variable{{OID: "1.3.6.1.4.1.318.2.3.3.0", Type: snmp.TypeOctetString, Value: []byte("UPS: On battery power")}},
+	}})
+	expectEvent("UPS-01", map[string]interface{}{"onBattery": map[string]interface{}{"message": "UPS: On battery power"}})
+
+	sendTrap(&snmp.Message{Version: snmp.Version2c, Community: "public", PDU: snmp.PDU{Type: snmp.InformRequest, RequestID: 77, Variables: []snmp.Variable{
+		{OID: snmp.OIDSysUpTime, Type: snmp.TypeTimeTicks, Value: uint64(100)},
+		{OID: snmp.OIDSnmpTrapOID, Type: snmp.TypeOID, Value: "1.3.6.1.6.3.1.1.5.3"},
+		{OID: "1.3.6.1.2.1.2.2.1.1.2", Type: snmp.TypeInteger, Value: int64(2)},
+	}}})
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buffer := make([]byte, 2048)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := snmp.Unmarshal(buffer[:n]); err != nil || reply.PDU.Type != snmp.GetResponse || reply.PDU.RequestID != 77 {
+		t.Fatalf("Inform 应回复响应: %+v %v", reply, err)
+	}
+	expectEvent("UPS-01", map[string]interface{}{"trap": map[string]interface{}{
+		"trapOid": "1.3.6.1.6.3.1.1.5.3", "1.3.6.1.2.1.2.2.1.1.2": int64(2),
+	}})
+
+	// 未配置的来源和安全级别低于配置的 v3 Trap 被丢弃
+	sendFrom := func(ip net.IP, msg *snmp.Message, keys *snmp.Keys) {
+		t.Helper()
+		from, err := net.DialUDP("udp", &net.UDPAddr{IP: ip}, trapAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer from.Close()
+		data, err := msg.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from.Write(data)
+	}
+	linkDown := []snmp.Variable{{OID: snmp.OIDSnmpTrapOID, Type: snmp.TypeOID, Value: "1.3.6.1.6.3.1.1.5.3"}}
+	linkUp := []snmp.Variable{{OID: snmp.OIDSnmpTrapOID, Type: snmp.TypeOID, Value: "1.3.6.1.6.3.1.1.5.4"}}
+	sendFrom(net.IPv4(127, 0, 0, 3), &snmp.Message{Version: snmp.Version2c, Community: "public", PDU: snmp.PDU{Type: snmp.TrapV2, RequestID: 2, Variables: linkDown}}, nil)
+	engineID := []byte{0x80, 0x00, 0x1F, 0x88, 0x04, 0x63, 0x72, 0x61, 0x63}
+	v3 := func(flags byte, variables []snmp.Variable) *snmp.Message {
+		return &snmp.Message{Version: snmp.Version3, MsgID: 3, MaxSize: 1500, Flags: flags, ContextEngineID: engineID,
+			Security: snmp.SecurityParams{EngineID: engineID, UserName: user.Name}, PDU: snmp.PDU{Type: snmp.TrapV2, RequestID: 3, Variables: variables}}
+	}
+	sendFrom(net.IPv4(127, 0, 0, 2), v3(0, linkUp), nil)
+	keys, err := snmp.LocalizeKeys(user, engineID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendFrom(net.IPv4(127, 0, 0, 2), v3(user.Flags(), linkDown), keys)
+	expectEvent("CRAC-01", map[string]interface{}{"trap": map[string]interface{}{"trapOid": "1.3.6.1.6.3.1.1.5.3"}})
+
+	// 代理无响应时离线
+	ups.conn.Close()
+	server.poll(ctx, server.agents["UPS-01"], server.snmpConfig.Groups[0])
+	if server.LookupDevice("UPS-01") != nil {
+		t.Fatal("轮询超时后代理应离线")
+	}
+}
@@ -1,15 +1,13 @@
 package network
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/binary"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/gogf/gf/v2/os/glog"
 	"github.com/sagoo-cloud/iotgateway/model"
-	"github.com/sagoo-cloud/iotgateway/vars"
 	"io"
 	"net"
 	"sync"
@@ -21,22 +19,36 @@ type TCPServer struct {
 	*BaseServer
 	listener net.Listener
 	conns    sync.Map
+	detector *protocolDetector // 协议识别器，未配置识别规则时为 nil
+	handlers sync.WaitGroup    // 正在处理的连接，Start 在返回前等待全部连接处理结束
 }
 
 // NewTCPServer 创建一个新的 TCP 服务器实例
 func NewTCPServer(options ...Option) NetworkServer {
-	return &TCPServer{
+	s := &TCPServer{
 		BaseServer: NewBaseServer(options...),
 	}
+	return s
 }
 
 // Start 启动 TCP 服务器
 func (s *TCPServer) Start(ctx context.Context, addr string) error {
 	var err error
+	if s.detector, err = newProtocolDetector(s.detectConfig); err != nil {
+		return fmt.Errorf("协议识别配置错误: %v", err)
+	}
 	s.listener, err = net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("TCP 监听失败: %v", err)
 	}
+	if s.tlsConfig.Enable {
+		tlsConfig, err := NewTLSConfig(s.tlsConfig)
+		if err != nil {
+			s.listener.Close()
+			return fmt.Errorf("TLS 配置错误: %v", err)
+		}
+		s.listener = tls.NewListener(s.listener, tlsConfig)
+	}
 
 	go s.cleanupInactiveDevices(ctx)
 
@@ -49,12 +61,17 @@ func (s *TCPServer) Start(ctx context.Context, addr string) error {
 		conn, err := s.listener.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
-				return nil // 正常关闭
+				s.handlers.Wait() // 正常关闭，连接已由 Stop 关闭，等待设备离线处理完成
+				return nil
 			}
 			glog.Debugf(context.Background(), "接受 TCP 连接失败: %v", err)
 			continue
 		}
-		go s.handleConnection(ctx, conn)
+		s.handlers.Add(1)
+		go func() {
+			defer s.handlers.Done()
+			s.handleConnection(ctx, conn, "")
+		}()
 	}
 }
 
@@ -76,167 +93,132 @@ func (s *TCPServer) Stop() error {
 func (s *TCPServer) SendData(device *model.Device, data interface{}, param ...string) error {
 	connAny, ok := s.conns.Load(device.ClientID)
 	if !ok {
-		return fmt.Errorf("TCP 设备 %s 未找到", device.ClientID)
+		return fmt.Errorf("%w: TCP 设备 %s 未找到", ErrDeviceOffline, device.ClientID)
 	}
 	conn := connAny.(net.Conn)
 
 	var encodedData []byte
 	var err error
 
-	if s.protocolHandler != nil {
-		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+	handler := s.deviceProtocol(device)
+	if handler != nil {
+		encodedData, err = handler.Encode(device, data, param...)
 		if err != nil {
-			return fmt.Errorf("编码数据失败: %v", err)
+			return fmt.Errorf("编码数据失败: %w", err)
 		}
 	} else {
 		encodedData = []byte(fmt.Sprintf("%v\n", data))
 	}
+	if encodedData, err = s.protocolEncodeFrame(handler, encodedData); err != nil {
+		return fmt.Errorf("数据成帧失败: %v", err)
+	}
 
 	_, err = conn.Write(encodedData)
 	return err
 }
 
-// handleConnection 处理 TCP 设备连接
-func (s *TCPServer) handleConnection(ctx context.Context, conn net.Conn) {
+// handleConnection 处理 TCP 设备连接，deviceKey 不为空时直接绑定设备标识
+func (s *TCPServer) handleConnection(ctx context.Context, conn net.Conn, deviceKey string) {
 	defer conn.Close()
 	clientID := conn.RemoteAddr().String()
+
+	// TLS 连接先完成握手，双向认证时可由客户端证书直接确定设备标识
+	var clientCert *x509.Certificate
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		var err error
+		if clientCert, err = s.tlsHandshake(tlsConn); err != nil {
+			glog.Debugf(context.Background(), "TLS 握手失败 %s: %v\n", clientID, err)
+			return
+		}
+		if deviceKey == "" {
+			deviceKey = CertificateDeviceKey(clientCert, s.tlsConfig.DeviceKeyFrom)
+		}
+	}
+
+	// 共享端口时先读取开头的数据识别协议，识别用掉的数据随后交给帧解码器
+	var reader io.Reader = conn
+	handler := s.protocolHandler
+	metadata := map[string]interface{}{}
+	if s.detector != nil {
+		name, detected, data, err := s.detectProtocol(conn, s.detector)
+		if err != nil {
+			glog.Debugf(context.Background(), "协议识别失败 %s: %v\n", clientID, err)
+			return
+		}
+		glog.Debugf(context.Background(), "连接 %s 识别为协议 %s\n", clientID, name)
+		handler, reader = detected, replayConn(conn, data)
+		metadata[MetadataProtocol] = name
+	}
+	if clientCert != nil {
+		metadata[MetadataClientCertificate] = clientCert
+	}
+
 	device := s.handleConnect(clientID, conn)
+	if len(metadata) > 0 {
+		device.Metadata = metadata
+	}
+	if s.detector != nil {
+		s.bindProtocol(device, handler)
+	}
+	s.bindDevice(device, deviceKey)
 	s.conns.Store(clientID, conn)
 	defer func() {
 		s.handleDisconnect(device)
 		s.conns.Delete(clientID)
+		s.unbindProtocol(device)
 	}()
+	if ctx.Err() != nil {
+		return // 服务已停止，Stop 可能未能关闭这个刚登记的连接
+	}
 
-	// 创建缓冲读取器
-	var reader = bufio.NewReader(conn)
-	buffer := make([]byte, 1024) // 或其他适合的缓冲区大小
-
-	for {
-		select {
-		case <-ctx.Done():
+	decoder, err := s.protocolFrameDecoder(handler)
+	if err != nil {
+		glog.Debugf(context.Background(), "创建帧解码器失败: %v\n", err)
+		return
+	}
+	if session, ok := handler.(SessionHandler); ok {
+		if err := session.OnConnect(device); err != nil {
+			glog.Debugf(context.Background(), "建立会话失败 %s: %v\n", clientID, err)
 			return
-		default:
-			if s.timeout > 0 {
-				if err := conn.SetReadDeadline(time.Now().Add(s.timeout)); err != nil {
-					glog.Debugf(context.Background(), "设置读取超时失败: %v\n", err)
-					return
-				}
-			}
-
-			var data []byte
-			// 直接读取数据
-			n, err := reader.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					glog.Debugf(context.Background(), "读取错误: %v\n", err)
-				}
-				continue
-			}
-			data = buffer[:n]
-			fmt.Println(fmt.Sprintf("data: %x", data))
-
-			// 如果数据长度小于等于头部长度，则初始化协议处理器
-			fmt.Println(fmt.Sprintf("data len: %d, header len: %d", n, s.packetConfig.HeaderLength))
-			if n <= s.packetConfig.HeaderLength {
-				s.protocolHandler.Init(device, data) // 初始化协议处理器
-				if device != nil {
-					device.OnlineStatus = true
-					device.LastActive = time.Now() // 更新设备最后活跃时间
-					if device.DeviceKey != "" {
-						vars.UpdateDeviceMap(device.DeviceKey, device) // 更新到全局设备列表
-					}
-				}
-			} else {
-				if s.packetConfig.Type != NoHandling {
-					var err error
-					data, err = s.readPacket(reader)
-					if err != nil {
-						if err != io.EOF {
-							glog.Debugf(context.Background(), "读取错误: %v\n", err)
-						}
-						continue
-					}
-				}
-
-				device.LastActive = time.Now()
-				resData, err := s.handleReceiveData(device, data)
-				if err != nil {
-					glog.Debugf(context.Background(), "处理数据错误: %v\n", err)
-					continue
-				}
-
-				if resData != nil {
-					if err := s.SendData(device, resData); err != nil {
-						glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
-					}
-				}
-			}
 		}
+		defer session.OnDisconnect(device)
 	}
-}
-
-// readPacket 根据配置的粘包处理方式读取数据
-func (s *TCPServer) readPacket(reader io.Reader) ([]byte, error) {
-	switch s.packetConfig.Type {
-	case FixedLength:
-		data := make([]byte, s.packetConfig.FixedLength)
-		_, err := io.ReadFull(reader, data)
-		return data, err
-	case HeaderBodySeparate:
-		headerBuf := make([]byte, s.packetConfig.HeaderLength)
-		_, err := io.ReadFull(reader, headerBuf)
-		if err != nil {
-			return nil, err
-		}
-		bodyLength := binary.BigEndian.Uint32(headerBuf)
-		data := make([]byte, bodyLength)
-		_, err = io.ReadFull(reader, data)
-		return data, err
-	case Delimiter:
-		return readUntilDelimiter(reader, s.packetConfig.Delimiter)
-	default:
-		return readUntilCRLF(reader)
+	if decoder == nil {
+		decoder = rawDecoder{} // 不做粘包处理，每次读取到的数据作为一帧
 	}
-}
-
-// readUntilDelimiter 读取数据直到遇到指定的分隔符
-func readUntilDelimiter(reader io.Reader, delimiter string) ([]byte, error) {
-	var buffer bytes.Buffer
-	delimiterBytes := []byte(delimiter)
-	delimiterLength := len(delimiterBytes)
-
-	for {
-		b := make([]byte, 1)
-		_, err := reader.Read(b)
-		if err != nil {
-			return nil, err
+	// 每个连接一个累积缓冲区，一次读取中的多个完整帧依次切分，不完整的数据保留到下次读取
+	frames := newFrameReader(reader, decoder, s.maxFrameLength())
+	defer frames.release()
+
+	for ctx.Err() == nil {
+		if s.timeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(s.timeout)); err != nil {
+				glog.Debugf(context.Background(), "设置读取超时失败: %v\n", err)
+				return
+			}
 		}
 
-		buffer.Write(b)
-
-		if buffer.Len() >= delimiterLength {
-			if bytes.Equal(buffer.Bytes()[buffer.Len()-delimiterLength:], delimiterBytes) {
-				return buffer.Bytes(), nil
+		data, err := frames.ReadFrame()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
 			}
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				glog.Debugf(context.Background(), "读取错误: %v\n", err)
+			}
+			return // 连接断开或数据流已无法正确切分
 		}
-	}
-}
 
-// readUntilCRLF 读取数据直到遇到 <CR><LF>
-func readUntilCRLF(reader io.Reader) ([]byte, error) {
-	var buffer bytes.Buffer
-	for {
-		b := make([]byte, 1)
-		_, err := reader.Read(b)
+		resData, err := s.handleReceiveData(device, data)
 		if err != nil {
-			return nil, err
+			glog.Debugf(context.Background(), "处理数据错误: %v\n", err)
+			continue
 		}
 
-		buffer.Write(b)
-
-		if buffer.Len() >= 2 {
-			if buffer.Bytes()[buffer.Len()-2] == '\r' && buffer.Bytes()[buffer.Len()-1] == '\n' {
-				return buffer.Bytes(), nil
+		if resData != nil {
+			if err := s.SendData(device, resData); err != nil {
+				glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
 			}
 		}
 	}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/sagoo-cloud/iotgateway/conf"
+)
+
+// TCPClient 结构体表示 TCP 客户端模式的网络服务
+// 网关主动连接作为 TCP 服务端的 DTU、串口服务器等设备，连接建立后的处理与 TCPServer 完全一致
+type TCPClient struct {
+	*TCPServer
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTCPClient 创建一个新的 TCP 客户端实例
+func NewTCPClient(options ...Option) NetworkServer {
+	c := &TCPClient{
+		TCPServer: NewTCPServer(options...).(*TCPServer),
+	}
+	return c
+}
+
+// Start 连接所有配置的远端设备并保持连接，远端地址只来自 TCPClientConfig.Remotes，addr 不使用
+func (c *TCPClient) Start(ctx context.Context, addr string) error {
+	remotes := c.tcpClientConfig.Remotes
+	if addr != "" {
+		glog.Warningf(context.Background(), "TCP 客户端忽略地址 %s，远端地址在 tcpClient.remotes 中配置", addr)
+	}
+	if len(remotes) == 0 {
+		return errors.New("未配置 TCP 远端地址")
+	}
+	detector, err := newProtocolDetector(c.detectConfig)
+	if err != nil {
+		return fmt.Errorf("协议识别配置错误: %v", err)
+	}
+	c.detector = detector
+
+	c.mu.Lock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.mu.Unlock()
+
+	go c.cleanupInactiveDevices(ctx)
+
+	for _, remote := range remotes {
+		c.wg.Add(1)
+		go func(remote conf.RemoteConfig) {
+			defer c.wg.Done()
+			c.keepConnected(ctx, remote)
+		}(remote)
+	}
+
+	<-ctx.Done()
+	c.closeConns()
+	c.wg.Wait()
+	return nil
+}
+
+// Stop 断开所有远端连接并停止重连
+func (c *TCPClient) Stop() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+	c.closeConns()
+	return nil
+}
+
+// keepConnected 维持与一个远端的连接，断开后按指数退避重连
+func (c *TCPClient) keepConnected(ctx context.Context, remote conf.RemoteConfig) {
+	dialTimeout := c.tcpClientConfig.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = 10 * time.Second
+	}
+	minBackoff := c.tcpClientConfig.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	maxBackoff := c.tcpClientConfig.MaxBackoff
+	if maxBackoff < minBackoff {
+		maxBackoff = time.Minute
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		conn, err := dialer.DialContext(ctx, "tcp", remote.Addr)
+		if err == nil {
+			glog.Debugf(context.Background(), "已连接 TCP 远端 %s", remote.Addr)
+			backoff = minBackoff
+			c.handleConnection(ctx, conn, remote.DeviceKey)
+			glog.Debugf(context.Background(), "TCP 远端 %s 连接断开", remote.Addr)
+		} else {
+			glog.Debugf(context.Background(), "连接 TCP 远端 %s 失败: %v，%v 后重试", remote.Addr, err, backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}
+}
+
+// closeConns 关闭所有已建立的连接
+func (c *TCPClient) closeConns() {
+	c.conns.Range(func(key, value interface{}) bool {
+		value.(net.Conn).Close()
+		return true
+	})
+}
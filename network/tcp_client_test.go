@@ -0,0 +1,69 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/vars"
+)
+
+func TestTCPClientReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	protocol := &echoProtocol{}
+	client := NewTCPClient(
+		WithProtocolHandler(protocol),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\n"}),
+		WithTCPClientConfig(conf.TCPClientConfig{
+			Remotes:    []conf.RemoteConfig{{Addr: listener.Addr().String(), DeviceKey: "dtu-001"}},
+			MinBackoff: 50 * time.Millisecond,
+		}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- client.Start(ctx, "") }()
+
+	for i := 0; i < 2; i++ {
+		listener.(*net.TCPListener).SetDeadline(time.Now().Add(3 * time.Second))
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("第 %d 次等待客户端连接失败: %v", i+1, err)
+		}
+		conn.Write([]byte("ping\n"))
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || reply != "ack:ping\n" {
+			t.Fatalf("回复不正确: %q, %v", reply, err)
+		}
+		device, err := vars.GetDevice("dtu-001")
+		if err != nil || client.(*TCPClient).getDevice(device.ClientID) == nil {
+			t.Fatalf("设备未绑定: %v", err)
+		}
+		// 服务端主动断开，客户端应当重连
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("TCP 客户端未能停止")
+	}
+}
+
+func TestTCPClientIgnoresAddr(t *testing.T) {
+	// 远端地址只来自配置，Start 的 addr(网关中默认是监听地址)不作为远端
+	client := NewTCPClient(WithProtocolHandler(&echoProtocol{}))
+	if err := client.Start(context.Background(), "127.0.0.1:502"); err == nil {
+		t.Fatal("未配置远端时应返回错误")
+	}
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// MetadataClientCertificate 设备元数据中保存已验证客户端证书(*x509.Certificate)的键
+const MetadataClientCertificate = "tls.clientCertificate"
+
+// NewTLSConfig 根据配置创建服务端 TLS 配置
+func NewTLSConfig(config conf.TLSConfig) (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("未配置 TLS 证书或私钥")
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载 TLS 证书失败: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.MinVersion != "" {
+		if tlsConfig.MinVersion, err = tlsVersion(config.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.CipherSuites) > 0 {
+		if tlsConfig.CipherSuites, err = cipherSuites(config.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端 CA 证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("解析客户端 CA 证书失败")
+		}
+		tlsConfig.ClientCAs = pool
+		switch strings.ToLower(config.ClientAuth) {
+		case "", "require":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("不支持的客户端证书校验方式: %s", config.ClientAuth)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// ClientCertificate 获取设备连接已验证的客户端证书，未使用双向认证时返回 nil
+func ClientCertificate(device *model.Device) *x509.Certificate {
+	if device == nil || device.Metadata == nil {
+		return nil
+	}
+	cert, _ := device.Metadata[MetadataClientCertificate].(*x509.Certificate)
+	return cert
+}
+
+// CertificateDeviceKey 按指定字段从客户端证书中获取设备标识
+// field 支持 cn(默认)/dns/uri/email/serial，为 none 或字段不存在时返回空字符串
+func CertificateDeviceKey(cert *x509.Certificate, field string) string {
+	if cert == nil {
+		return ""
+	}
+	switch strings.ToLower(field) {
+	case "", "cn":
+		return cert.Subject.CommonName
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case "uri":
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "serial":
+		return cert.SerialNumber.String()
+	}
+	return ""
+}
+
+// tlsHandshake 在超时时间内完成 TLS 握手，返回已验证的客户端证书
+func (s *BaseServer) tlsHandshake(conn *tls.Conn) (*x509.Certificate, error) {
+	timeout := s.timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	return state.PeerCertificates[0], nil
+}
+
+// tlsVersion 解析 TLS 版本
+func tlsVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("不支持的 TLS 版本: %s", version)
+}
+
+// cipherSuites 按名称解析加密套件，TLS 1.3 的加密套件不可配置
+func cipherSuites(names []string) ([]uint16, error) {
+	all := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, suite := range all {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("不支持的加密套件: %s", name)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,139 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/vars"
+)
+
+// issueCert 签发测试证书，parent 为 nil 时生成自签名 CA
+func issueCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestTCPServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caPEM, _ := issueCert(t, "test-ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := issueCert(t, "gateway", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := issueCert(t, "meter-001", ca, caKey)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tlsConfig := conf.TLSConfig{
+		Enable:       true,
+		CertFile:     write("server.pem", serverPEM),
+		KeyFile:      write("server.key", serverKeyPEM),
+		ClientCAFile: write("ca.pem", caPEM),
+		MinVersion:   "1.2",
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := NewTCPServer(
+		WithProtocolHandler(&echoProtocol{}),
+		WithPacketHandling(conf.PacketConfig{Type: Delimiter, Delimiter: "\n"}),
+		WithTLSConfig(tlsConfig),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+	time.Sleep(100 * time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if reply, err := bufio.NewReader(conn).ReadString('\n'); err != nil || reply != "ack:hello\n" {
+		t.Fatalf("回复不正确: %q, %v", reply, err)
+	}
+
+	device, err := vars.GetDevice("meter-001")
+	if err != nil {
+		t.Fatalf("设备未按证书 CN 绑定: %v", err)
+	}
+	if cert := ClientCertificate(device); cert == nil || cert.Subject.CommonName != "meter-001" {
+		t.Fatalf("设备元数据中的客户端证书不正确: %v", cert)
+	}
+
+	// 未提供客户端证书的连接应当被拒绝
+	bad, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+	if err == nil {
+		bad.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = bad.Read(make([]byte, 1))
+		bad.Close()
+	}
+	if err == nil {
+		t.Fatal("未提供客户端证书的连接应当被拒绝")
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	if _, err := tlsVersion("1.4"); err == nil {
+		t.Error("不支持的 TLS 版本应当报错")
+	}
+	if _, err := cipherSuites([]string{"TLS_UNKNOWN"}); err == nil {
+		t.Error("不支持的加密套件应当报错")
+	}
+	if _, err := NewTLSConfig(conf.TLSConfig{Enable: true}); err == nil {
+		t.Error("未配置证书应当报错")
+	}
+}
@@ -100,9 +100,9 @@ func (s *UDPServer) SendData(device *model.Device, data interface{}, param ...st
 
 	var encodedData []byte
 	if s.protocolHandler != nil {
-		encodedData, err = s.protocolHandler.Encode(nil, data, param...)
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
 		if err != nil {
-			return fmt.Errorf("编码数据失败: %v", err)
+			return fmt.Errorf("编码数据失败: %w", err)
 		}
 	} else {
 		encodedData = []byte(fmt.Sprintf("%v", data))
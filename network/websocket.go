@@ -0,0 +1,287 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gorilla/websocket"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+// WebSocketServer 结构体表示 WebSocket 服务器
+// 每个 WebSocket 连接按 TCP 连接的方式管理，每条消息作为一个完整帧交给协议处理器
+type WebSocketServer struct {
+	*BaseServer
+	server   *http.Server
+	upgrader websocket.Upgrader
+	conns    sync.Map
+}
+
+// NewWebSocketServer 创建一个新的 WebSocket 服务器实例
+func NewWebSocketServer(options ...Option) NetworkServer {
+	s := &WebSocketServer{
+		BaseServer: NewBaseServer(options...),
+	}
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	return s
+}
+
+// Start 启动 WebSocket 服务器，启用 TLS 时为 wss
+func (s *WebSocketServer) Start(ctx context.Context, addr string) error {
+	path := s.wsConfig.Path
+	if path == "" {
+		path = "/ws"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		s.handleUpgrade(ctx, w, r)
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("WebSocket 监听失败: %v", err)
+	}
+	s.server = &http.Server{Handler: mux}
+	if s.tlsConfig.Enable {
+		tlsConfig, err := NewTLSConfig(s.tlsConfig)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("TLS 配置错误: %v", err)
+		}
+		s.server.TLSConfig = tlsConfig
+	}
+
+	go s.cleanupInactiveDevices(ctx)
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	if s.tlsConfig.Enable {
+		err = s.server.ServeTLS(listener, "", "")
+	} else {
+		err = s.server.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil // 正常关闭
+	}
+	return err
+}
+
+// Stop 停止 WebSocket 服务器
+func (s *WebSocketServer) Stop() error {
+	var err error
+	if s.server != nil {
+		err = s.server.Close()
+	}
+	s.conns.Range(func(key, value interface{}) bool {
+		value.(*wsConn).Close()
+		return true
+	})
+	return err
+}
+
+// SendData 向 WebSocket 设备发送数据，每次发送为一条消息
+func (s *WebSocketServer) SendData(device *model.Device, data interface{}, param ...string) error {
+	connAny, ok := s.conns.Load(device.ClientID)
+	if !ok {
+		return fmt.Errorf("%w: WebSocket 设备 %s 未找到", ErrDeviceOffline, device.ClientID)
+	}
+	conn := connAny.(*wsConn)
+
+	var encodedData []byte
+	var err error
+
+	if s.protocolHandler != nil {
+		encodedData, err = s.protocolHandler.Encode(device, data, param...)
+		if err != nil {
+			return fmt.Errorf("编码数据失败: %w", err)
+		}
+	} else {
+		encodedData = []byte(fmt.Sprintf("%v", data))
+	}
+
+	_, err = conn.Write(encodedData)
+	return err
+}
+
+// checkOrigin 校验 Origin，未配置允许的 Origin 时不校验
+func (s *WebSocketServer) checkOrigin(r *http.Request) bool {
+	if len(s.wsConfig.AllowedOrigins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	for _, allowed := range s.wsConfig.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUpgrade 升级 HTTP 连接并处理 WebSocket 消息
+func (s *WebSocketServer) handleUpgrade(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		glog.Debugf(context.Background(), "WebSocket 升级失败 %s: %v\n", r.RemoteAddr, err)
+		return
+	}
+	conn := newWSConn(ws, s.wsConfig.MessageType)
+	defer conn.Close()
+
+	clientID := ws.RemoteAddr().String()
+	device := s.handleConnect(clientID, conn)
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		clientCert := r.TLS.PeerCertificates[0]
+		device.Metadata = map[string]interface{}{MetadataClientCertificate: clientCert}
+		s.bindDevice(device, CertificateDeviceKey(clientCert, s.tlsConfig.DeviceKeyFrom))
+	}
+	s.conns.Store(clientID, conn)
+	defer func() {
+		s.handleDisconnect(device)
+		s.conns.Delete(clientID)
+	}()
+
+	// 设备需在超时时间内发送消息或回复心跳，服务端按超时时间的一半发送 Ping
+	if s.timeout > 0 {
+		ws.SetPongHandler(func(string) error {
+			device.LastActive = time.Now()
+			return ws.SetReadDeadline(time.Now().Add(s.timeout))
+		})
+		go conn.keepAlive(ctx, s.timeout/2)
+	}
+
+	for ctx.Err() == nil {
+		if s.timeout > 0 {
+			if err := ws.SetReadDeadline(time.Now().Add(s.timeout)); err != nil {
+				return
+			}
+		}
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, net.ErrClosed) {
+				glog.Debugf(context.Background(), "读取 WebSocket 消息错误: %v\n", err)
+			}
+			return
+		}
+		conn.received(messageType)
+
+		resData, err := s.handleReceiveData(device, data)
+		if err != nil {
+			glog.Debugf(context.Background(), "处理数据错误: %v\n", err)
+			continue
+		}
+
+		if resData != nil {
+			if err := s.SendData(device, resData); err != nil {
+				glog.Debugf(context.Background(), "发送回复失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// wsConn 将 WebSocket 连接适配为 net.Conn，写入的数据作为一条消息发送
+type wsConn struct {
+	*websocket.Conn
+	mu          sync.Mutex // gorilla/websocket 不支持并发写
+	messageType int        // 下发消息类型
+	fixedType   bool       // 是否固定下发消息类型
+	reader      io.Reader
+	closeOnce   sync.Once
+	closed      chan struct{}
+}
+
+// newWSConn 创建 WebSocket 连接适配器
+func newWSConn(ws *websocket.Conn, messageType string) *wsConn {
+	conn := &wsConn{Conn: ws, messageType: websocket.BinaryMessage, closed: make(chan struct{})}
+	switch strings.ToLower(messageType) {
+	case "text":
+		conn.messageType, conn.fixedType = websocket.TextMessage, true
+	case "binary":
+		conn.fixedType = true
+	}
+	return conn
+}
+
+// received 记录上行消息类型，未固定下发消息类型时按最近一次上行消息的类型下发
+func (c *wsConn) received(messageType int) {
+	if c.fixedType {
+		return
+	}
+	c.mu.Lock()
+	c.messageType = messageType
+	c.mu.Unlock()
+}
+
+// Read 实现 net.Conn 接口，按字节流读取消息内容
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write 实现 net.Conn 接口，每次写入作为一条消息发送
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.WriteMessage(c.messageType, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetDeadline 实现 net.Conn 接口
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// Close 关闭连接
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// keepAlive 定时发送 Ping 直到连接关闭
+func (c *wsConn) keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}
+}
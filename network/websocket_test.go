@@ -0,0 +1,80 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/model"
+)
+
+func TestWebSocketServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	protocol := &echoProtocol{}
+	server := NewWebSocketServer(
+		WithProtocolHandler(protocol),
+		WithWebSocketConfig(conf.WebSocketConfig{Path: "/device"}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx, addr) }()
+
+	var ws *websocket.Conn
+	for i := 0; i < 50; i++ {
+		if ws, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/device", nil); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// 每条消息作为一个完整帧，回复的消息类型与上行消息相同
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"t":1}`))
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	messageType, reply, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != websocket.TextMessage || string(reply) != `ack:{"t":1}` {
+		t.Fatalf("回复不正确: %d %q", messageType, reply)
+	}
+
+	// 通过 SendData 主动下发
+	var device *model.Device
+	server.(*WebSocketServer).devices.Range(func(key, value interface{}) bool {
+		device = value.(*model.Device)
+		return false
+	})
+	if device == nil {
+		t.Fatal("设备未注册")
+	}
+	if err := server.SendData(device, []byte("down")); err != nil {
+		t.Fatal(err)
+	}
+	if _, reply, err = ws.ReadMessage(); err != nil || string(reply) != "down" {
+		t.Fatalf("下发数据不正确: %q, %v", reply, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("WebSocket 服务器未能停止")
+	}
+}
@@ -0,0 +1,271 @@
+package iotgateway
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+const (
+	defaultPollInterval  = 60 * time.Second
+	defaultPollTimeout   = 3 * time.Second
+	defaultPollMaxMisses = 3
+)
+
+// poller 按轮询配置定时向设备下发命令，响应作为属性数据上报
+type poller struct {
+	gw        *Gateway
+	timeout   time.Duration
+	maxMisses int
+
+	mu         sync.Mutex
+	buses      map[string]*sync.Mutex // 总线 -> 总线锁，同一总线上的轮询依次执行
+	misses     map[string]int         // 设备标识 -> 连续失败次数
+	unresolved map[string]int         // 设备标识 -> 连续未找到设备连接的次数
+	offline    map[string]bool        // 因连续轮询失败置为离线的总线从站
+}
+
+// pollTask 一个设备的一个轮询组
+type pollTask struct {
+	group     conf.PollGroupConfig
+	deviceKey string
+	bus       string // 所在总线连接的设备标识，设备独立连接时与设备标识相同
+	slave     string // 总线上的从站地址
+}
+
+// newPoller 创建轮询器
+func newPoller(gw *Gateway, config conf.PollingConfig) *poller {
+	p := &poller{
+		gw:         gw,
+		timeout:    config.Timeout,
+		maxMisses:  config.MaxMisses,
+		buses:      make(map[string]*sync.Mutex),
+		misses:     make(map[string]int),
+		unresolved: make(map[string]int),
+		offline:    make(map[string]bool),
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultPollTimeout
+	}
+	if p.maxMisses <= 0 {
+		p.maxMisses = defaultPollMaxMisses
+	}
+	return p
+}
+
+// pollTasks 展开轮询组，按设备标识和产品匹配设备，同一轮询组中重复的设备只轮询一次
+func pollTasks(config conf.PollingConfig) []pollTask {
+	devices := make(map[string]conf.PollDeviceConfig)
+	for _, device := range config.Devices {
+		devices[device.DeviceKey] = device
+	}
+
+	var tasks []pollTask
+	for _, group := range config.Groups {
+		deviceKeys := append([]string(nil), group.DeviceKeys...)
+		if group.ProductKey != "" {
+			for _, device := range config.Devices {
+				if device.ProductKey == group.ProductKey {
+					deviceKeys = append(deviceKeys, device.DeviceKey)
+				}
+			}
+		}
+		seen := make(map[string]bool)
+		for _, deviceKey := range deviceKeys {
+			if deviceKey == "" || seen[deviceKey] {
+				continue
+			}
+			seen[deviceKey] = true
+			task := pollTask{group: group, deviceKey: deviceKey, bus: devices[deviceKey].Bus, slave: devices[deviceKey].Slave}
+			if task.bus == "" {
+				task.bus = deviceKey // 未配置总线的设备独立轮询
+			}
+			if task.slave == "" {
+				task.slave = deviceKey
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// run 启动全部轮询任务，阻塞直到 ctx 取消
+func (p *poller) run(ctx context.Context, tasks []pollTask) {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task pollTask) {
+			defer wg.Done()
+			p.loop(ctx, task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+// loop 按轮询组的周期轮询一个设备
+func (p *poller) loop(ctx context.Context, task pollTask) {
+	interval := task.group.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	delay := jitter(task.group.Jitter) // 首次轮询随机延后，避免全部设备同时开始
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		p.poll(ctx, task)
+		delay = interval + jitter(task.group.Jitter)
+	}
+}
+
+// jitter 返回 0~max 之间的随机时长
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// poll 占用设备所在的总线，依次下发轮询组的全部命令
+// 设备有自己的连接时直接请求，否则经总线连接请求总线上的从站
+func (p *poller) poll(ctx context.Context, task pollTask) {
+	bus := p.bus(task.bus)
+	bus.Lock()
+	defer bus.Unlock()
+
+	for _, command := range task.group.Commands {
+		if ctx.Err() != nil {
+			return
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		var response interface{}
+		var err error
+		if server, _ := p.gw.lookupDevice(task.deviceKey); server == nil && task.bus != task.deviceKey {
+			response, err = p.gw.RequestSlave(requestCtx, task.bus, task.slave, command.Data, command.Params...)
+		} else {
+			response, err = p.gw.Request(requestCtx, task.deviceKey, command.Data, command.Params...)
+		}
+		cancel()
+		if err != nil {
+			if errors.Is(err, network.ErrDeviceNotFound) || errors.Is(err, network.ErrDeviceOffline) {
+				p.unresolve(task, err) // 设备或总线尚未连接，等待上线，不计入连续失败
+				return
+			}
+			glog.Debugf(context.Background(), "轮询设备 %s(%s)失败: %v", task.deviceKey, task.group.Name, err)
+			p.miss(task)
+			return
+		}
+		p.resolve(task.deviceKey)
+		p.report(task.deviceKey, response)
+	}
+}
+
+// bus 获取总线锁
+func (p *poller) bus(name string) *sync.Mutex {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bus := p.buses[name]
+	if bus == nil {
+		bus = new(sync.Mutex)
+		p.buses[name] = bus
+	}
+	return bus
+}
+
+// miss 记录一次轮询失败，连续失败达到 MaxMisses 时将设备置为离线
+func (p *poller) miss(task pollTask) {
+	deviceKey := task.deviceKey
+	p.mu.Lock()
+	p.misses[deviceKey]++
+	offline := p.misses[deviceKey] >= p.maxMisses
+	if offline {
+		delete(p.misses, deviceKey)
+	}
+	p.mu.Unlock()
+	if !offline {
+		return
+	}
+
+	if server, _ := p.gw.lookupDevice(deviceKey); server != nil {
+		server.(network.DeviceLocator).MarkOffline(deviceKey)
+		glog.Infof(context.Background(), "设备 %s 连续 %d 次轮询失败，已置为离线", deviceKey, p.maxMisses)
+		return
+	}
+	if task.bus == deviceKey {
+		glog.Warningf(context.Background(), "设备 %s 连续 %d 次轮询失败", deviceKey, p.maxMisses)
+		return
+	}
+
+	// 总线上的从站没有自己的连接，只置为离线并触发离线事件，不断开总线连接，以免影响同一总线上的其他设备
+	p.mu.Lock()
+	changed := !p.offline[deviceKey]
+	p.offline[deviceKey] = true
+	p.mu.Unlock()
+	if changed {
+		glog.Infof(context.Background(), "总线 %s 上的设备 %s 连续 %d 次轮询失败，已置为离线", task.bus, deviceKey, p.maxMisses)
+		fireDeviceStatus(consts.DeviceOffline, deviceKey)
+	}
+}
+
+// unresolve 记录一次未找到设备连接的轮询，首次记录时告警，便于发现总线或设备标识配置错误
+func (p *poller) unresolve(task pollTask, err error) {
+	p.mu.Lock()
+	p.unresolved[task.deviceKey]++
+	count := p.unresolved[task.deviceKey]
+	p.mu.Unlock()
+	if count == 1 {
+		glog.Warningf(context.Background(), "轮询设备 %s(%s)未找到连接，总线 %s: %v", task.deviceKey, task.group.Name, task.bus, err)
+		return
+	}
+	glog.Debugf(context.Background(), "轮询设备 %s(%s)仍未找到连接，已连续 %d 次: %v", task.deviceKey, task.group.Name, count, err)
+}
+
+// resolve 轮询成功后清除设备的连续失败次数与未找到连接的次数，已置为离线的总线从站重新上线
+func (p *poller) resolve(deviceKey string) {
+	p.mu.Lock()
+	delete(p.misses, deviceKey)
+	delete(p.unresolved, deviceKey)
+	online := p.offline[deviceKey]
+	delete(p.offline, deviceKey)
+	p.mu.Unlock()
+	if online {
+		glog.Infof(context.Background(), "设备 %s 轮询恢复，已重新上线", deviceKey)
+		fireDeviceStatus(consts.DeviceOnline, deviceKey)
+	}
+}
+
+// fireDeviceStatus 触发设备上线或离线事件，事件数据与网络服务器触发的相同
+func fireDeviceStatus(name, deviceKey string) {
+	if err, _ := event.Fire(name, g.Map{"DeviceKey": deviceKey}); err != nil {
+		glog.Debugf(context.Background(), "触发设备 %s 的 %s 事件失败: %v", deviceKey, name, err)
+	}
+}
+
+// report 将轮询响应作为属性数据上报，响应不是键值形式(如 nil)时表示协议处理器已自行处理
+func (p *poller) report(deviceKey string, response interface{}) {
+	properties := gconv.Map(response)
+	if len(properties) == 0 {
+		return
+	}
+	err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         deviceKey,
+		"PropertieDataList": properties,
+	})
+	if err != nil {
+		glog.Debugf(context.Background(), "上报设备 %s 轮询数据失败: %v", deviceKey, err)
+	}
+}
@@ -0,0 +1,265 @@
+package iotgateway
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// pollProtocol 测试用协议处理器，下发 "寄存器\n"，设备回复 "寄存器=值\n"
+type pollProtocol struct {
+	loginProtocol
+}
+
+func (p *pollProtocol) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	return []byte(gconv.String(data) + "\n"), nil
+}
+
+func (p *pollProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	if name, value, ok := strings.Cut(strings.TrimSpace(string(data)), "="); ok {
+		network.Respond(device.DeviceKey, "", map[string]interface{}{name: value})
+	}
+	return nil, nil
+}
+
+func TestPollTasks(t *testing.T) {
+	tasks := pollTasks(conf.PollingConfig{
+		Devices: []conf.PollDeviceConfig{
+			{DeviceKey: "m1", ProductKey: "meter", Bus: "rs485-1", Slave: "1"},
+			{DeviceKey: "m2", ProductKey: "meter", Bus: "rs485-1"},
+			{DeviceKey: "w1", ProductKey: "water"},
+		},
+		Groups: []conf.PollGroupConfig{
+			{Name: "energy", ProductKey: "meter", DeviceKeys: []string{"m1", "x1"}},
+			{Name: "status", DeviceKeys: []string{"w1"}},
+		},
+	})
+	var got []string
+	for _, task := range tasks {
+		got = append(got, task.group.Name+":"+task.deviceKey+"@"+task.bus+"/"+task.slave)
+	}
+	want := "energy:m1@rs485-1/1 energy:x1@x1/x1 energy:m2@rs485-1/m2 status:w1@w1/w1"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("轮询任务 %v, 期望 %s", got, want)
+	}
+}
+
+func TestPollerSlaveOffline(t *testing.T) {
+	var mu sync.Mutex
+	var states []string
+	record := event.ListenerFunc(func(e event.Event) error {
+		if e.Data()["DeviceKey"] == "slave-off" {
+			mu.Lock()
+			states = append(states, e.Name())
+			mu.Unlock()
+		}
+		return nil
+	})
+	event.On(consts.DeviceOffline, record)
+	event.On(consts.DeviceOnline, record)
+
+	// 总线上的从站连续失败后触发一次离线事件，轮询恢复后触发上线事件
+	p := newPoller(&Gateway{}, conf.PollingConfig{MaxMisses: 2})
+	task := pollTask{deviceKey: "slave-off", bus: "dtu-off", slave: "1"}
+	for i := 0; i < 4; i++ {
+		p.miss(task)
+	}
+	p.resolve(task.deviceKey)
+	p.resolve(task.deviceKey)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(states, " "); got != consts.DeviceOffline+" "+consts.DeviceOnline {
+		t.Fatalf("从站的上下线事件 %s", got)
+	}
+}
+
+// busProtocol 测试用协议处理器，总线上的从站地址作为下发数据的前缀，从站回复 "从站/寄存器=值\n"
+type busProtocol struct {
+	pollProtocol
+}
+
+func (p *busProtocol) AddressSlave(data interface{}, slave string) (interface{}, error) {
+	return slave + "/" + gconv.String(data), nil
+}
+
+func (p *busProtocol) Decode(device *model.Device, data []byte) ([]byte, error) {
+	_, reply, _ := strings.Cut(string(data), "/")
+	return p.pollProtocol.Decode(device, []byte(reply))
+}
+
+func TestGatewayPollingBus(t *testing.T) {
+	var mu sync.Mutex
+	reported := make(map[string]string)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		if deviceKey := gconv.String(e.Data()["DeviceKey"]); strings.HasPrefix(deviceKey, "bus-m") {
+			mu.Lock()
+			reported[deviceKey] = gconv.String(e.Data()["PropertieDataList"])
+			mu.Unlock()
+		}
+		return nil
+	}))
+
+	network.RegisterProtocol("poll-bus", &busProtocol{})
+	addr := freeAddr(t, "tcp")
+	gw := &Gateway{options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{NetType: consts.NetTypeTcpServer, Addr: addr, Protocol: "poll-bus",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+		},
+		Polling: conf.PollingConfig{
+			Timeout: time.Second,
+			Devices: []conf.PollDeviceConfig{
+				{DeviceKey: "bus-m1", ProductKey: "meter", Bus: "dtu-001", Slave: "1"},
+				{DeviceKey: "bus-m2", ProductKey: "meter", Bus: "dtu-001"},
+			},
+			Groups: []conf.PollGroupConfig{{
+				Name:       "energy",
+				ProductKey: "meter",
+				Interval:   50 * time.Millisecond,
+				Commands:   []conf.PollCommandConfig{{Data: "voltage"}},
+			}},
+		},
+	}}
+	startGateway(t, gw)
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("dtu-001\n"))
+
+	// 两个从站经同一 DTU 连接依次轮询，从站地址由协议处理器补充，从站以地址的首字符作为值应答
+	requests := make(chan string, 100)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			request := strings.TrimSpace(line)
+			requests <- request
+			conn.Write([]byte(request + "=" + request[:1] + "\n"))
+		}
+	}()
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		done := reported["bus-m1"] == `{"voltage":"1"}` && reported["bus-m2"] == `{"voltage":"b"}`
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("上报的属性数据 %v", reported)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if request := <-requests; request != "1/voltage" && request != "bus-m2/voltage" {
+			t.Fatalf("下发的轮询命令 %s", request)
+		}
+	}
+
+	// 子设备标识同样可以直接请求，经总线连接下发
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	response, err := gw.Request(ctx, "bus-m1", "current")
+	if err != nil || gconv.String(response) != `{"current":"1"}` {
+		t.Fatalf("子设备请求失败: %v %v", response, err)
+	}
+}
+
+func TestGatewayPolling(t *testing.T) {
+	var mu sync.Mutex
+	var reported []string
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		if e.Data()["DeviceKey"] == "poll-001" {
+			mu.Lock()
+			reported = append(reported, gconv.String(e.Data()["PropertieDataList"]))
+			mu.Unlock()
+		}
+		return nil
+	}))
+
+	network.RegisterProtocol("poll", &pollProtocol{})
+	addr := freeAddr(t, "tcp")
+	gw := &Gateway{options: &conf.GatewayConfig{
+		Listeners: []conf.ListenerConfig{
+			{NetType: consts.NetTypeTcpServer, Addr: addr, Protocol: "poll",
+				PacketConfig: conf.PacketConfig{Type: network.Delimiter, Delimiter: "\n"}},
+		},
+		Polling: conf.PollingConfig{
+			Timeout:   100 * time.Millisecond,
+			MaxMisses: 2,
+			Groups: []conf.PollGroupConfig{{
+				Name:       "energy",
+				DeviceKeys: []string{"poll-001"},
+				Interval:   50 * time.Millisecond,
+				Jitter:     10 * time.Millisecond,
+				Commands:   []conf.PollCommandConfig{{Data: "voltage"}, {Data: "current"}},
+			}},
+		},
+	}}
+	startGateway(t, gw)
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("poll-001\n"))
+
+	// 设备应答前 4 条轮询命令，之后不再应答
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 4; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		register := strings.TrimSpace(line)
+		conn.Write([]byte(register + "=" + register[:1] + "\n"))
+	}
+	mu.Lock()
+	got := strings.Join(reported, " ")
+	mu.Unlock()
+	if !strings.Contains(got, `{"voltage":"v"} {"current":"c"}`) {
+		t.Fatalf("上报的属性数据 %s", got)
+	}
+
+	// 连续 2 次轮询失败后断开设备连接
+	for {
+		if _, err := reader.ReadString('\n'); err != nil {
+			break
+		}
+	}
+	if server, _ := gw.lookupDevice("poll-001"); server != nil {
+		t.Fatal("连续轮询失败后设备应离线")
+	}
+}
@@ -0,0 +1,442 @@
+// Package dlt645 提供 DL/T 645-2007 多功能电能表通信协议处理器，按数据标识表将电表数据映射为物模型属性
+package dlt645
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+const (
+	defaultPreamble    = 4           // 下发报文默认的前导字节个数
+	pendingExpiration  = time.Minute // 未应答的请求保留时长，超时后清理，避免断开的设备残留
+	defaultPassword    = "02000000"
+	defaultOperator    = "00000000"
+	propertyAddress    = "address" // 读通信地址应答中的属性标识
+	passwordSize       = 4
+	operatorSize       = 4
+	dataIdentifierSize = 4
+)
+
+// Config DL/T 645 协议处理器配置
+type Config struct {
+	Meters   map[string]string `json:"meters"`   // 设备标识 -> 表地址,总线上的电表经总线连接按设备标识寻址;未配置时设备标识为 12 位表地址则直接使用,否则使用通配地址
+	Items    []DataItem        `json:"items"`    // 数据标识表
+	Preamble int               `json:"preamble"` // 下发报文前的唤醒字节 0xFE 个数,默认 4,小于 0 时不发送
+	Password string            `json:"password"` // 写数据的权限等级和密码 PA+P2P1P0,如 02123456,默认 02000000
+	Operator string            `json:"operator"` // 写数据的操作者代码 C3C2C1C0,默认 00000000
+	Register bool              `json:"register"` // 连接发送的第一个非 DL/T 645 数据为 DTU 注册包,内容作为设备标识
+}
+
+// Request 表示一个 DL/T 645 请求
+type Request struct {
+	Address string      `json:"address"` // 表地址,为空时使用设备对应的表地址
+	Control byte        `json:"control"` // 控制码,默认读数据
+	DI      string      `json:"di"`      // 数据标识或数据标识表中的属性标识,读数据和写数据时使用
+	Value   interface{} `json:"value"`   // 写数据的值,按数据标识表中的数据格式编码
+	Data    string      `json:"data"`    // 其他控制码的数据域,十六进制,不含 0x33 偏移
+}
+
+// Handler DL/T 645 协议处理器
+// 下发数据可以是 Request、数据标识表中的属性标识或可转换为 Request 的键值数据，第一个参数不为空时作为表地址
+// RS-485 总线半双工，同一连接的请求串行执行，应答按表地址、控制码和数据标识与请求核对后交给等待的请求
+// 读数据应答有后续数据时自动读取后续数据帧，全部数据拼接后再交给等待的请求
+type Handler struct {
+	config   Config
+	items    []DataItem
+	password []byte
+	operator []byte
+
+	mu        sync.Mutex
+	pending   map[*model.Device]pendingRequest // 设备 -> 已下发、等待应答的请求
+	lastPrune time.Time
+}
+
+// pendingRequest 已下发、等待应答的请求
+type pendingRequest struct {
+	control byte
+	di      uint32
+	address string // 请求的表地址，应答的表地址需要与之一致
+	data    []byte // 读后续数据时已收到的数据
+	seq     byte   // 读后续数据的帧序号
+	sent    time.Time
+}
+
+// New 创建 DL/T 645 协议处理器
+func New(config Config) (*Handler, error) {
+	if config.Preamble == 0 {
+		config.Preamble = defaultPreamble
+	}
+	if config.Password == "" {
+		config.Password = defaultPassword
+	}
+	if config.Operator == "" {
+		config.Operator = defaultOperator
+	}
+	h := &Handler{config: config, pending: make(map[*model.Device]pendingRequest)}
+	var err error
+	if h.password, err = decodeReversed(config.Password, passwordSize); err != nil {
+		return nil, fmt.Errorf("写数据密码 %v", err)
+	}
+	// 权限等级 PA 在密码之前
+	h.password = append(h.password[passwordSize-1:], h.password[:passwordSize-1]...)
+	if h.operator, err = decodeReversed(config.Operator, operatorSize); err != nil {
+		return nil, fmt.Errorf("操作者代码 %v", err)
+	}
+	for deviceKey, address := range config.Meters {
+		if _, err := encodeAddress(address); err != nil {
+			return nil, fmt.Errorf("设备 %s 的%v", deviceKey, err)
+		}
+	}
+	names := make(map[string]bool)
+	for _, item := range config.Items {
+		if err := item.validate(); err != nil {
+			return nil, err
+		}
+		if names[item.Name] {
+			return nil, fmt.Errorf("属性 %s 重复", item.Name)
+		}
+		names[item.Name] = true
+		h.items = append(h.items, item)
+	}
+	return h, nil
+}
+
+// Init 实现 network.ProtocolHandler 接口
+// 启用 Register 时将 DTU 注册包的内容作为设备标识；点对点连接(未配置 Meters)时电表发送的第一帧中的表地址作为设备标识
+func (h *Handler) Init(device *model.Device, data []byte) error {
+	if device == nil || device.DeviceKey != "" {
+		return nil
+	}
+	frame, err := ParseFrame(data)
+	if err != nil {
+		if h.config.Register {
+			device.DeviceKey = strings.TrimSpace(string(data))
+		}
+		return nil
+	}
+	h.bind(device, frame)
+	return nil
+}
+
+// Encode 实现 network.ProtocolHandler 接口，生成请求报文并登记等待应答
+// []byte 为 Decode 返回的读后续数据请求，原样发送
+func (h *Handler) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	if raw, ok := data.([]byte); ok {
+		return raw, nil
+	}
+	request, err := h.request(data)
+	if err != nil {
+		return nil, err
+	}
+	if request.Address == "" && len(param) > 0 {
+		request.Address = param[0]
+	}
+	frame := Frame{Address: h.address(device, request), Control: request.Control}
+	var di uint32
+	switch request.Control {
+	case CtrlReadData, CtrlWriteData:
+		item, ok := h.item(request.DI)
+		if !ok {
+			return nil, fmt.Errorf("数据标识 %s 不存在", request.DI)
+		}
+		di = item.di
+		frame.Data = encodeDI(di)
+		if request.Control == CtrlReadData {
+			break
+		}
+		var value []byte
+		if item.Name != "" {
+			value, err = item.encode(request.Value)
+		} else {
+			value, err = hex.DecodeString(request.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		frame.Data = append(frame.Data, h.password...)
+		frame.Data = append(frame.Data, h.operator...)
+		frame.Data = append(frame.Data, value...)
+	case CtrlReadAddress:
+		frame.Address = WildcardAddress
+	default:
+		if frame.Data, err = hex.DecodeString(request.Data); err != nil {
+			return nil, fmt.Errorf("数据域 %s 格式错误", request.Data)
+		}
+	}
+	encoded, err := h.encode(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Sub(h.lastPrune) > pendingExpiration {
+		h.prune(now)
+	}
+	h.pending[device] = pendingRequest{control: request.Control, di: di, address: frame.Address, sent: now}
+	return encoded, nil
+}
+
+// encode 生成报文并加上前导字节
+func (h *Handler) encode(frame Frame) ([]byte, error) {
+	encoded, err := frame.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if h.config.Preamble > 0 {
+		encoded = append(bytes.Repeat([]byte{preamble}, h.config.Preamble), encoded...)
+	}
+	return encoded, nil
+}
+
+// prune 清理超时未应答的请求，调用方持有 h.mu
+func (h *Handler) prune(now time.Time) {
+	h.lastPrune = now
+	for device, request := range h.pending {
+		if now.Sub(request.sent) > pendingExpiration {
+			delete(h.pending, device)
+		}
+	}
+}
+
+// Decode 实现 network.ProtocolHandler 接口，将应答交给等待的请求
+// 读数据应答转换为属性标识 -> 属性值，读通信地址应答为 address -> 表地址，其他应答为 nil，异常应答为 *Exception
+// 读数据应答有后续数据时返回读后续数据的请求报文，由网络服务器发送
+func (h *Handler) Decode(device *model.Device, data []byte) ([]byte, error) {
+	if device == nil {
+		return nil, nil
+	}
+	frame, err := ParseFrame(data)
+	if err != nil || !frame.isResponse() {
+		return nil, nil // 注册包、心跳包等非 DL/T 645 数据
+	}
+	if device.DeviceKey == "" {
+		h.bind(device, frame)
+	}
+
+	control := frame.Control & ctrlFunction
+	h.mu.Lock()
+	request, ok := h.pending[device]
+	if ok && request.matches(frame) {
+		delete(h.pending, device)
+	} else {
+		ok = false
+	}
+	h.mu.Unlock()
+	if !ok {
+		glog.Debugf(context.Background(), "DL/T 645 设备 %s 的应答(表地址 %s)没有对应的请求", device.DeviceKey, frame.Address)
+		return nil, nil
+	}
+
+	switch {
+	case frame.Control&ctrlError != 0:
+		code := byte(0)
+		if len(frame.Data) > 0 {
+			code = frame.Data[0]
+		}
+		network.Respond(device.DeviceKey, "", &Exception{Control: control, Code: code})
+	case control == CtrlReadData || control == CtrlReadFollow:
+		data := frame.Data[dataIdentifierSize:]
+		if control == CtrlReadFollow {
+			if len(data) == 0 {
+				network.Respond(device.DeviceKey, "", ErrInvalidFrame)
+				break
+			}
+			data = data[:len(data)-1] // 去掉帧序号 SEQ
+		}
+		request.data = append(request.data, data...)
+		if frame.Control&ctrlFollow != 0 {
+			return h.readFollow(device, frame.Address, request)
+		}
+		properties, err := h.properties(request.di, request.data)
+		if err != nil {
+			network.Respond(device.DeviceKey, "", err)
+			break
+		}
+		network.Respond(device.DeviceKey, "", properties)
+	case control == CtrlReadAddress:
+		network.Respond(device.DeviceKey, "", map[string]interface{}{propertyAddress: frame.Address})
+	default:
+		network.Respond(device.DeviceKey, "", nil)
+	}
+	return nil, nil
+}
+
+// readFollow 登记并生成读后续数据的请求报文，数据域为数据标识和帧序号
+func (h *Handler) readFollow(device *model.Device, address string, request pendingRequest) ([]byte, error) {
+	request.control, request.address, request.sent = CtrlReadFollow, address, time.Now()
+	request.seq++
+	encoded, err := h.encode(Frame{Address: address, Control: CtrlReadFollow, Data: append(encodeDI(request.di), request.seq)})
+	if err != nil {
+		network.Respond(device.DeviceKey, "", err)
+		return nil, nil
+	}
+	h.mu.Lock()
+	h.pending[device] = request
+	h.mu.Unlock()
+	return encoded, nil
+}
+
+// matches 判断应答是否属于等待的请求：控制码一致，表地址与请求的表地址(可含通配字节)一致，读数据应答的数据标识一致
+func (r pendingRequest) matches(frame Frame) bool {
+	control := frame.Control & ctrlFunction
+	if r.control != control || !addressMatches(r.address, frame.Address) {
+		return false
+	}
+	if frame.Control&ctrlError != 0 || (control != CtrlReadData && control != CtrlReadFollow) {
+		return true
+	}
+	return len(frame.Data) >= dataIdentifierSize && bytes.Equal(frame.Data[:dataIdentifierSize], encodeDI(r.di))
+}
+
+// AddressSlave 实现 network.SlaveAddresser 接口，slave 为 Meters 中电表的设备标识或 12 位表地址
+// 请求已指定表地址时不做修改
+func (h *Handler) AddressSlave(data interface{}, slave string) (interface{}, error) {
+	request, err := h.request(data)
+	if err != nil {
+		return nil, err
+	}
+	if request.Address != "" {
+		return request, nil
+	}
+	if address, ok := h.config.Meters[slave]; ok {
+		request.Address = address
+		return request, nil
+	}
+	if _, err := encodeAddress(slave); err != nil {
+		return nil, err
+	}
+	request.Address = slave
+	return request, nil
+}
+
+// FrameDecoder 实现 network.FrameDecoderProvider 接口
+func (h *Handler) FrameDecoder() network.FrameDecoder {
+	return frameDecoder{}
+}
+
+// ReadRequests 生成读取数据标识表中全部数据的请求，可以作为轮询命令
+func (h *Handler) ReadRequests() []Request {
+	requests := make([]Request, 0, len(h.items))
+	for _, item := range h.items {
+		requests = append(requests, Request{Control: CtrlReadData, DI: item.DI})
+	}
+	return requests
+}
+
+// request 将下发数据转换为请求
+func (h *Handler) request(data interface{}) (Request, error) {
+	var request Request
+	switch v := data.(type) {
+	case Request:
+		request = v
+	case *Request:
+		request = *v
+	case string:
+		request = Request{DI: v}
+	default:
+		if err := gconv.Struct(data, &request); err != nil {
+			return Request{}, fmt.Errorf("DL/T 645 请求格式错误: %v", err)
+		}
+	}
+	if request.Control == 0 {
+		request.Control = CtrlReadData
+	}
+	return request, nil
+}
+
+// item 按属性标识或数据标识查找数据标识表，不在表中的数据标识返回只有数据标识的项
+func (h *Handler) item(di string) (DataItem, bool) {
+	for _, item := range h.items {
+		if item.Name == di || strings.EqualFold(item.DI, di) {
+			return item, true
+		}
+	}
+	value, err := strconv.ParseUint(di, 16, 32)
+	if err != nil || len(di) != 8 {
+		return DataItem{}, false
+	}
+	return DataItem{DI: di, di: uint32(value)}, true
+}
+
+// address 获取请求的表地址
+func (h *Handler) address(device *model.Device, request Request) string {
+	if request.Address != "" {
+		return request.Address
+	}
+	if device != nil {
+		if address, ok := h.config.Meters[device.DeviceKey]; ok {
+			return address
+		}
+		if _, err := encodeAddress(device.DeviceKey); err == nil {
+			return device.DeviceKey
+		}
+	}
+	return WildcardAddress
+}
+
+// bind 点对点连接时将电表的表地址作为连接的设备标识
+// 启用 Register 或配置了 Meters 时连接可能下挂多块电表，不按表地址绑定，电表经总线连接按表地址访问
+func (h *Handler) bind(device *model.Device, frame Frame) {
+	if h.config.Register || len(h.config.Meters) > 0 {
+		return
+	}
+	if frame.Address != WildcardAddress && frame.Address != BroadcastAddress {
+		device.DeviceKey = frame.Address
+	}
+}
+
+// addressMatches 判断应答的表地址是否与请求的表地址一致，请求表地址中的 A(通配)与任意数字匹配，广播地址与任意表地址匹配
+func addressMatches(request, response string) bool {
+	if request == BroadcastAddress {
+		return true
+	}
+	if len(request) != len(response) {
+		return false
+	}
+	for i := 0; i < len(request); i++ {
+		if request[i] != 'A' && request[i] != response[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// properties 将读数据应答按数据标识表转换为属性值，不在表中的数据标识以十六进制原样返回
+func (h *Handler) properties(di uint32, data []byte) (map[string]interface{}, error) {
+	for _, item := range h.items {
+		if item.di != di {
+			continue
+		}
+		value, err := item.decode(data)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{item.Name: value}, nil
+	}
+	return map[string]interface{}{fmt.Sprintf("%08X", di): strings.ToUpper(hex.EncodeToString(data))}, nil
+}
+
+// decodeReversed 将十六进制字符串转换为低字节在前的字节
+func decodeReversed(s string, size int) ([]byte, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != size {
+		return nil, fmt.Errorf("%s 应为 %d 字节十六进制数", s, size)
+	}
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	return data, nil
+}
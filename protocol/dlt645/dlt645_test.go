@@ -0,0 +1,239 @@
+package dlt645
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// meter 模拟的电表，应答读数据和写数据
+type meter struct {
+	mu      sync.Mutex
+	address string
+	values  map[uint32][]byte
+	written []byte // 最近一次写数据的数据域
+}
+
+// serve 读取请求并应答
+func (m *meter) serve(conn net.Conn) {
+	var buf []byte
+	chunk := make([]byte, 256)
+	for {
+		n, err := conn.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			data, consumed, _ := frameDecoder{}.Decode(buf)
+			if consumed == 0 {
+				break
+			}
+			buf = buf[consumed:]
+			if data == nil {
+				continue
+			}
+			request, err := ParseFrame(data)
+			if err != nil || (request.Address != m.address && request.Address != WildcardAddress) {
+				continue
+			}
+			reply := Frame{Address: m.address, Control: request.Control | ctrlResponse}
+			m.mu.Lock()
+			switch request.Control {
+			case CtrlReadData:
+				di := request.Data[:dataIdentifierSize]
+				if value, ok := m.values[uint32(di[0])|uint32(di[1])<<8|uint32(di[2])<<16|uint32(di[3])<<24]; ok {
+					reply.Data = append(append([]byte{}, di...), value...)
+				} else {
+					reply.Control |= ctrlError
+					reply.Data = []byte{0x02}
+				}
+			case CtrlWriteData:
+				m.written = request.Data
+			}
+			m.mu.Unlock()
+			response, _ := reply.Bytes()
+			conn.Write(append([]byte{0xFE, 0xFE}, response...))
+		}
+	}
+}
+
+func TestHandlerOverTCP(t *testing.T) {
+	handler, err := New(Config{
+		Password: "02123456",
+		Items: []DataItem{
+			{Name: "energy", DI: "00010000", Format: "XXXXXX.XX"},
+			{Name: "current", DI: "02020100", Format: "XXX.XXX", Signed: true},
+			{Name: "limit", DI: "04000E03", Format: "XXX.X"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	server := network.NewTCPServer(network.WithProtocolHandler(handler))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	device := &meter{address: "202312345678", values: map[uint32][]byte{
+		0x00010000: {0x78, 0x56, 0x34, 0x12},
+		0x02020100: {0x00, 0x15, 0x80},
+		0x00020000: {0x01, 0x02, 0x03, 0x04},
+	}}
+	// 电表上线后主动发送的第一帧中的表地址作为设备标识
+	online, _ := Frame{Address: device.address, Control: CtrlReadAddress | ctrlResponse, Data: []byte{0x78, 0x56, 0x34, 0x12, 0x23, 0x20}}.Bytes()
+	conn.Write(online)
+	go device.serve(conn)
+
+	locator := server.(network.DeviceLocator)
+	for i := 0; i < 100 && locator.LookupDevice(device.address) == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	request := func(ctx context.Context, data interface{}) (interface{}, error) {
+		target := locator.LookupDevice(device.address)
+		if target == nil {
+			return nil, network.ErrDeviceNotFound
+		}
+		pending, err := network.BeginRequest(ctx, device.address, handler, target, data)
+		if err != nil {
+			return nil, err
+		}
+		defer pending.Done()
+		if err := server.SendData(target, data); err != nil {
+			return nil, err
+		}
+		return pending.Wait(ctx)
+	}
+
+	timeout, cancelRequest := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelRequest()
+	for _, read := range handler.ReadRequests()[:2] {
+		response, err := request(timeout, read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range response.(map[string]interface{}) {
+			if want := map[string]interface{}{"energy": 123456.78, "current": -1.5}[name]; value != want {
+				t.Fatalf("%s 为 %v, 期望 %v", name, value, want)
+			}
+		}
+	}
+
+	var exception *Exception
+	if _, err := request(timeout, "limit"); !errors.As(err, &exception) || exception.Code != 0x02 {
+		t.Fatalf("异常应答应作为错误返回，实际 %v", err)
+	}
+	response, err := request(timeout, map[string]interface{}{"di": "00020000"})
+	if err != nil || !reflect.DeepEqual(response, map[string]interface{}{"00020000": "01020304"}) {
+		t.Fatalf("不在表中的数据标识 %v %v", response, err)
+	}
+
+	if _, err := request(timeout, Request{Control: CtrlWriteData, DI: "limit", Value: 250.5}); err != nil {
+		t.Fatal(err)
+	}
+	device.mu.Lock()
+	written := device.written
+	device.mu.Unlock()
+	want := []byte{0x03, 0x0E, 0x00, 0x04, 0x02, 0x56, 0x34, 0x12, 0x00, 0x00, 0x00, 0x00, 0x05, 0x25}
+	if !bytes.Equal(written, want) {
+		t.Fatalf("写数据的数据域 % X, 期望 % X", written, want)
+	}
+
+	if response, err := request(timeout, Request{Control: CtrlReadAddress}); err != nil ||
+		!reflect.DeepEqual(response, map[string]interface{}{"address": device.address}) {
+		t.Fatalf("读通信地址 %v %v", response, err)
+	}
+}
+
+func TestHandlerBusAndFollow(t *testing.T) {
+	handler, err := New(Config{
+		Preamble: -1,
+		Meters:   map[string]string{"m1": "000000000001", "m2": "000000000002"},
+		Items:    []DataItem{{Name: "energy", DI: "00010000", Format: "XXXXXX.XX"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置了 meters 的连接下挂多块电表，不按第一块电表的表地址绑定
+	bus := &model.Device{}
+	first, _ := Frame{Address: "000000000001", Control: CtrlReadAddress | ctrlResponse}.Bytes()
+	handler.Init(bus, first)
+	if bus.DeviceKey != "" {
+		t.Fatalf("总线连接不应绑定为电表 %s", bus.DeviceKey)
+	}
+	bus.DeviceKey = "rs485-1"
+
+	data, err := handler.AddressSlave("energy", "m2")
+	if err != nil || data.(Request).Address != "000000000002" {
+		t.Fatalf("按设备标识寻址 %+v %v", data, err)
+	}
+	if _, err := handler.AddressSlave("energy", "m9"); err == nil {
+		t.Fatal("未知的电表应返回错误")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pending, err := network.BeginRequest(ctx, bus.DeviceKey, handler, bus, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pending.Done()
+	if _, err := handler.Encode(bus, data); err != nil {
+		t.Fatal(err)
+	}
+	di := []byte{0x00, 0x00, 0x01, 0x00}
+
+	// 其他电表的应答不交给请求
+	other, _ := Frame{Address: "000000000001", Control: CtrlReadData | ctrlResponse, Data: append(append([]byte{}, di...), 0x78, 0x56, 0x34, 0x12)}.Bytes()
+	if reply, _ := handler.Decode(bus, other); reply != nil {
+		t.Fatal("其他电表的应答不应有回复")
+	}
+
+	// 有后续数据时自动读取后续数据帧，数据拼接后交给请求
+	part, _ := Frame{Address: "000000000002", Control: CtrlReadData | ctrlResponse | ctrlFollow, Data: append(append([]byte{}, di...), 0x78, 0x56)}.Bytes()
+	reply, err := handler.Decode(bus, part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	follow, err := ParseFrame(reply)
+	if err != nil || follow.Address != "000000000002" || follow.Control != CtrlReadFollow || !bytes.Equal(follow.Data, append(append([]byte{}, di...), 1)) {
+		t.Fatalf("读后续数据请求 %+v %v", follow, err)
+	}
+	if encoded, _ := handler.Encode(bus, reply); !bytes.Equal(encoded, reply) {
+		t.Fatal("读后续数据请求应原样发送")
+	}
+	last, _ := Frame{Address: "000000000002", Control: CtrlReadFollow | ctrlResponse, Data: append(append([]byte{}, di...), 0x34, 0x12, 1)}.Bytes()
+	if reply, _ := handler.Decode(bus, last); reply != nil {
+		t.Fatal("最后一帧不应再读取后续数据")
+	}
+	if response, err := pending.Wait(ctx); err != nil || !reflect.DeepEqual(response, map[string]interface{}{"energy": 123456.78}) {
+		t.Fatalf("拼接后的数据 %v %v", response, err)
+	}
+}
@@ -0,0 +1,224 @@
+package dlt645
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 控制码(DL/T 645-2007 主站请求)
+const (
+	CtrlBroadcastTime  byte = 0x08 // 广播校时
+	CtrlReadData       byte = 0x11 // 读数据
+	CtrlReadFollow     byte = 0x12 // 读后续数据
+	CtrlReadAddress    byte = 0x13 // 读通信地址
+	CtrlWriteData      byte = 0x14 // 写数据
+	CtrlWriteAddress   byte = 0x15 // 写通信地址
+	CtrlFreeze         byte = 0x16 // 冻结命令
+	CtrlChangeBaudRate byte = 0x17 // 更改通信速率
+	CtrlChangePassword byte = 0x18 // 修改密码
+	CtrlClearDemand    byte = 0x19 // 最大需量清零
+	CtrlClearMeter     byte = 0x1A // 电表清零
+	CtrlClearEvent     byte = 0x1B // 事件清零
+	CtrlControl        byte = 0x1C // 跳合闸、报警、保电
+)
+
+const (
+	ctrlResponse = 0x80 // D7 传送方向，从站应答
+	ctrlError    = 0x40 // D6 从站异常应答
+	ctrlFollow   = 0x20 // D5 有后续数据帧
+	ctrlFunction = 0x1F // D0~D4 功能码
+
+	frameStart  = 0x68
+	frameEnd    = 0x16
+	preamble    = 0xFE
+	dataMask    = 0x33
+	headerSize  = 10 // 68 + 地址 6 字节 + 68 + 控制码 + 长度
+	addressSize = 6
+)
+
+// 特殊通信地址
+const (
+	BroadcastAddress = "999999999999" // 广播地址，仅用于广播校时等无应答命令
+	WildcardAddress  = "AAAAAAAAAAAA" // 通配地址，点对点连接时无需知道表地址
+)
+
+var (
+	// ErrInvalidFrame 报文格式错误
+	ErrInvalidFrame = errors.New("DL/T 645 报文格式错误")
+	// ErrChecksum 报文校验和错误
+	ErrChecksum = errors.New("DL/T 645 报文校验和错误")
+)
+
+// Exception 电表返回的异常应答
+type Exception struct {
+	Control byte // 请求的控制码
+	Code    byte // 错误信息字 ERR
+}
+
+// Error 实现 error 接口
+func (e *Exception) Error() string {
+	names := []string{"其他错误", "无请求数据", "密码错/未授权", "通信速率不能更改", "年时区数超", "日时段数超", "费率数超"}
+	var reasons []string
+	for i, name := range names {
+		if e.Code&(1<<i) != 0 {
+			reasons = append(reasons, name)
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("DL/T 645 异常应答: 控制码 %02X, 错误信息字 %02X", e.Control, e.Code)
+	}
+	return fmt.Sprintf("DL/T 645 异常应答: 控制码 %02X, %s", e.Control, strings.Join(reasons, ","))
+}
+
+// Frame 表示一个 DL/T 645 报文，Data 为去掉 0x33 偏移后的数据域
+type Frame struct {
+	Address string // 通信地址，12 位，高位在前
+	Control byte   // 控制码
+	Data    []byte // 数据域
+}
+
+// Bytes 生成报文(不含前导字节)，数据域加 0x33 偏移
+func (f Frame) Bytes() ([]byte, error) {
+	address, err := encodeAddress(f.Address)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Data) > 0xFF {
+		return nil, fmt.Errorf("数据域长度 %d 超出范围", len(f.Data))
+	}
+	frame := make([]byte, 0, headerSize+len(f.Data)+2)
+	frame = append(frame, frameStart)
+	frame = append(frame, address...)
+	frame = append(frame, frameStart, f.Control, byte(len(f.Data)))
+	for _, b := range f.Data {
+		frame = append(frame, b+dataMask)
+	}
+	return append(frame, checksum(frame), frameEnd), nil
+}
+
+// ParseFrame 解析报文，忽略开头的前导字节
+func ParseFrame(data []byte) (Frame, error) {
+	for len(data) > 0 && data[0] == preamble {
+		data = data[1:]
+	}
+	if len(data) < headerSize+2 || data[0] != frameStart || data[7] != frameStart ||
+		len(data) != headerSize+int(data[9])+2 || data[len(data)-1] != frameEnd {
+		return Frame{}, ErrInvalidFrame
+	}
+	if checksum(data[:len(data)-2]) != data[len(data)-2] {
+		return Frame{}, ErrChecksum
+	}
+	frame := Frame{Address: decodeAddress(data[1:7]), Control: data[8], Data: make([]byte, data[9])}
+	for i := range frame.Data {
+		frame.Data[i] = data[headerSize+i] - dataMask
+	}
+	return frame, nil
+}
+
+// isResponse 判断是否为从站应答
+func (f Frame) isResponse() bool {
+	return f.Control&ctrlResponse != 0
+}
+
+// checksum 从第一个帧起始符到校验码之前所有字节的模 256 和
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// encodeAddress 将 12 位通信地址转换为低字节在前的 BCD 码，通配地址中的 A 原样保留
+func encodeAddress(address string) ([]byte, error) {
+	if len(address) != addressSize*2 {
+		return nil, fmt.Errorf("通信地址 %s 应为 12 位", address)
+	}
+	raw, err := hex.DecodeString(address)
+	if err != nil {
+		return nil, fmt.Errorf("通信地址 %s 格式错误", address)
+	}
+	for i, j := 0, len(raw)-1; i < j; i, j = i+1, j-1 {
+		raw[i], raw[j] = raw[j], raw[i]
+	}
+	return raw, nil
+}
+
+// decodeAddress 将报文中的通信地址转换为 12 位字符串
+func decodeAddress(data []byte) string {
+	raw := make([]byte, len(data))
+	for i, b := range data {
+		raw[len(data)-1-i] = b
+	}
+	return strings.ToUpper(hex.EncodeToString(raw))
+}
+
+// encodeDI 数据标识 DI0 在前
+func encodeDI(di uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, di)
+}
+
+// frameDecoder DL/T 645 帧解码器，丢弃帧前的唤醒字节 0xFE，按长度域切分
+// 不是 DL/T 645 报文的数据(如 DTU 的注册包、心跳包)切分到下一个可能的帧头，作为一帧交给协议处理器，其后的数据重新解码
+type frameDecoder struct{}
+
+// Decode 实现 network.FrameDecoder 接口
+func (frameDecoder) Decode(buf []byte) ([]byte, int, error) {
+	skipped := 0
+	for skipped < len(buf) && buf[skipped] == preamble {
+		skipped++
+	}
+	if skipped > 0 {
+		return nil, skipped, nil
+	}
+	if len(buf) == 0 {
+		return nil, 0, nil
+	}
+	if buf[0] != frameStart {
+		n := resync(buf)
+		return buf[:n], n, nil
+	}
+	if len(buf) < headerSize {
+		return nil, 0, nil
+	}
+	if buf[7] != frameStart {
+		n := resync(buf)
+		return buf[:n], n, nil
+	}
+	length := headerSize + int(buf[9]) + 2
+	if len(buf) < length {
+		return nil, 0, nil
+	}
+	if buf[length-1] != frameEnd || checksum(buf[:length-2]) != buf[length-2] {
+		return nil, resync(buf), nil // 校验失败，丢弃到下一个帧起始符，缓存中随后的完整帧不受影响
+	}
+	return buf[:length], length, nil
+}
+
+// resync 查找下一个可能的帧头，返回帧头之前的字节数
+// 从第二个字节开始，唤醒字节，或两个帧起始符位置正确且校验正确或尚不完整的数据视为帧头
+func resync(buf []byte) int {
+	for i := 1; i < len(buf); i++ {
+		if buf[i] == preamble {
+			return i
+		}
+		if buf[i] != frameStart {
+			continue
+		}
+		frame := buf[i:]
+		if len(frame) < headerSize {
+			return i
+		}
+		if frame[7] != frameStart {
+			continue
+		}
+		length := headerSize + int(frame[9]) + 2
+		if len(frame) < length || frame[length-1] == frameEnd && checksum(frame[:length-2]) == frame[length-2] {
+			return i
+		}
+	}
+	return len(buf)
+}
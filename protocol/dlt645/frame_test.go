@@ -0,0 +1,119 @@
+package dlt645
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFrameBytes(t *testing.T) {
+	tests := []struct {
+		frame Frame
+		want  []byte
+	}{
+		{Frame{Address: WildcardAddress, Control: CtrlReadAddress},
+			[]byte{0x68, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x68, 0x13, 0x00, 0xDF, 0x16}},
+		{Frame{Address: "000000000001", Control: CtrlReadData, Data: encodeDI(0x00010000)},
+			[]byte{0x68, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x68, 0x11, 0x04, 0x33, 0x33, 0x34, 0x33, 0xB3, 0x16}},
+	}
+	for _, tt := range tests {
+		data, err := tt.frame.Bytes()
+		if err != nil || !bytes.Equal(data, tt.want) {
+			t.Errorf("%+v 的报文 % X %v, 期望 % X", tt.frame, data, err, tt.want)
+		}
+	}
+	if _, err := (Frame{Address: "12345"}).Bytes(); err == nil {
+		t.Error("通信地址长度错误时应返回错误")
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	data, _ := Frame{Address: "202312345678", Control: CtrlReadData | ctrlResponse, Data: []byte{0x00, 0x00, 0x01, 0x00, 0x78, 0x56, 0x34, 0x12}}.Bytes()
+	frame, err := ParseFrame(append([]byte{0xFE, 0xFE}, data...))
+	if err != nil || frame.Address != "202312345678" || frame.Control != 0x91 || !bytes.Equal(frame.Data, []byte{0x00, 0x00, 0x01, 0x00, 0x78, 0x56, 0x34, 0x12}) {
+		t.Fatalf("解析报文 %+v %v", frame, err)
+	}
+	if !bytes.Equal(data[1:7], []byte{0x78, 0x56, 0x34, 0x12, 0x23, 0x20}) {
+		t.Fatalf("通信地址应低字节在前 % X", data[1:7])
+	}
+
+	data[len(data)-2]++
+	if _, err := ParseFrame(data); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("校验和错误 %v", err)
+	}
+	if _, err := ParseFrame([]byte("DTU-001")); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("非 DL/T 645 数据 %v", err)
+	}
+}
+
+func TestFrameDecoder(t *testing.T) {
+	frame, _ := Frame{Address: "000000000001", Control: 0x94}.Bytes()
+	stream := append([]byte{0xFE, 0xFE, 0xFE, 0xFE}, frame...)
+	stream = append(stream, []byte("PING")...)
+
+	var decoder frameDecoder
+	var frames [][]byte
+	for len(stream) > 0 {
+		data, consumed, err := decoder.Decode(stream)
+		if err != nil || consumed == 0 {
+			t.Fatalf("解码 % X: %d %v", stream, consumed, err)
+		}
+		if data != nil {
+			frames = append(frames, data)
+		}
+		stream = stream[consumed:]
+	}
+	if len(frames) != 2 || !bytes.Equal(frames[0], frame) || string(frames[1]) != "PING" {
+		t.Fatalf("切分结果 %q", frames)
+	}
+
+	if _, consumed, _ := decoder.Decode(frame[:len(frame)-1]); consumed != 0 {
+		t.Fatal("数据不足一帧时应等待")
+	}
+
+	// 校验失败的帧被跳过，缓存中随后的完整帧仍能切分
+	corrupted := append([]byte(nil), frame...)
+	corrupted[len(corrupted)-2]++
+	stream = append(append(corrupted, 0xFE, 0xFE), frame...)
+	frames = nil
+	for len(stream) > 0 {
+		data, consumed, err := decoder.Decode(stream)
+		if err != nil || consumed == 0 {
+			t.Fatalf("重新同步 % X: %d %v", stream, consumed, err)
+		}
+		if data != nil {
+			frames = append(frames, data)
+		}
+		stream = stream[consumed:]
+	}
+	if len(frames) != 1 || !bytes.Equal(frames[0], frame) {
+		t.Fatalf("重新同步后的帧 % X", frames)
+	}
+
+	// 非 DL/T 645 数据只切分到下一个可能的帧头，随后的完整帧不被吞掉
+	invalidHeader := []byte{0x68, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	stream = append(append([]byte("REG"), frame...), invalidHeader...)
+	stream = append(stream, frame...)
+	frames = nil
+	for len(stream) > 0 {
+		data, consumed, err := decoder.Decode(stream)
+		if err != nil || consumed == 0 {
+			t.Fatalf("切分非 DL/T 645 数据 % X: %d %v", stream, consumed, err)
+		}
+		if data != nil {
+			frames = append(frames, data)
+		}
+		stream = stream[consumed:]
+	}
+	if len(frames) != 4 || string(frames[0]) != "REG" || !bytes.Equal(frames[1], frame) ||
+		!bytes.Equal(frames[2], invalidHeader) || !bytes.Equal(frames[3], frame) {
+		t.Fatalf("切分结果 % X", frames)
+	}
+}
+
+func TestException(t *testing.T) {
+	err := &Exception{Control: CtrlWriteData, Code: 0x04}
+	if err.Error() != "DL/T 645 异常应答: 控制码 14, 密码错/未授权" {
+		t.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,134 @@
+package dlt645
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// DataItem 数据标识表中的一项，将数据标识映射为物模型属性
+type DataItem struct {
+	Name   string `json:"name"`   // 物模型属性标识
+	DI     string `json:"di"`     // 数据标识 DI3DI2DI1DI0,十六进制,如 00010000 为正向有功总电能
+	Format string `json:"format"` // 数据格式,如 XXXXXX.XX,每个字符为一位 BCD 码;只含 X 和小数点时为数值,否则(如 YYMMDDhhmm)为数字串
+	Signed bool   `json:"signed"` // 最高位为符号位,如电流、功率
+
+	di uint32
+}
+
+// validate 检查配置并解析数据标识
+func (d *DataItem) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("数据标识 %s 缺少属性标识", d.DI)
+	}
+	di, err := strconv.ParseUint(d.DI, 16, 32)
+	if err != nil || len(d.DI) != 8 {
+		return fmt.Errorf("%s 的数据标识 %s 应为 8 位十六进制数", d.Name, d.DI)
+	}
+	d.di = uint32(di)
+	if d.Format == "" {
+		return fmt.Errorf("%s 缺少数据格式", d.Name)
+	}
+	if digits := d.digits(); digits == 0 || digits%2 != 0 {
+		return fmt.Errorf("%s 的数据格式 %s 应为偶数位", d.Name, d.Format)
+	}
+	if d.Signed && !d.numeric() {
+		return fmt.Errorf("%s 的数据格式 %s 不是数值,不能带符号", d.Name, d.Format)
+	}
+	return nil
+}
+
+// digits BCD 码位数
+func (d DataItem) digits() int {
+	return len(strings.ReplaceAll(d.Format, ".", ""))
+}
+
+// length 数据长度(字节)
+func (d DataItem) length() int {
+	return d.digits() / 2
+}
+
+// numeric 是否为数值格式
+func (d DataItem) numeric() bool {
+	return strings.Trim(d.Format, "X.") == ""
+}
+
+// decimals 小数位数
+func (d DataItem) decimals() int {
+	if i := strings.IndexByte(d.Format, '.'); i >= 0 {
+		return len(d.Format) - i - 1
+	}
+	return 0
+}
+
+// decode 将数据(低字节在前的 BCD 码)转换为属性值
+// 数值格式有小数位时为 float64,否则为 int64;非数值格式为数字串
+func (d DataItem) decode(data []byte) (interface{}, error) {
+	if len(data) != d.length() {
+		return nil, fmt.Errorf("%s 的数据长度 %d 与格式 %s 不一致", d.Name, len(data), d.Format)
+	}
+	raw := make([]byte, len(data))
+	for i, b := range data {
+		raw[len(data)-1-i] = b
+	}
+	negative := false
+	if d.Signed && raw[0]&0x80 != 0 {
+		negative = true
+		raw[0] &= 0x7F
+	}
+	digits := fmt.Sprintf("%X", raw)
+	if !d.numeric() {
+		return digits, nil
+	}
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s 的数据 %s 不是 BCD 码", d.Name, digits)
+	}
+	if negative {
+		value = -value
+	}
+	if decimals := d.decimals(); decimals > 0 {
+		return float64(value) / math.Pow10(decimals), nil
+	}
+	return value, nil
+}
+
+// encode 将属性值转换为低字节在前的 BCD 码
+func (d DataItem) encode(value interface{}) ([]byte, error) {
+	var digits string
+	negative := false
+	if d.numeric() {
+		scaled := math.Round(gconv.Float64(value) * math.Pow10(d.decimals()))
+		if scaled < 0 {
+			if !d.Signed {
+				return nil, fmt.Errorf("%s 不能为负数", d.Name)
+			}
+			negative, scaled = true, -scaled
+		}
+		digits = strconv.FormatInt(int64(scaled), 10)
+	} else {
+		digits = gconv.String(value)
+		if strings.Trim(digits, "0123456789") != "" {
+			return nil, fmt.Errorf("%s 的值 %s 不是数字串", d.Name, digits)
+		}
+	}
+	if len(digits) > d.digits() {
+		return nil, fmt.Errorf("%s 的值 %v 超出格式 %s 的范围", d.Name, value, d.Format)
+	}
+	digits = strings.Repeat("0", d.digits()-len(digits)) + digits
+	data := make([]byte, d.length())
+	for i := range data {
+		high, low := digits[i*2]-'0', digits[i*2+1]-'0'
+		data[len(data)-1-i] = high<<4 | low
+	}
+	if negative {
+		if data[len(data)-1]&0x80 != 0 {
+			return nil, fmt.Errorf("%s 的值 %v 超出格式 %s 的范围", d.Name, value, d.Format)
+		}
+		data[len(data)-1] |= 0x80
+	}
+	return data, nil
+}
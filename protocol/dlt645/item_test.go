@@ -0,0 +1,55 @@
+package dlt645
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDataItem(t *testing.T) {
+	tests := []struct {
+		item  DataItem
+		data  []byte
+		value interface{}
+	}{
+		{DataItem{Name: "energy", DI: "00010000", Format: "XXXXXX.XX"}, []byte{0x78, 0x56, 0x34, 0x12}, 123456.78},
+		{DataItem{Name: "voltage", DI: "02010100", Format: "XXX.X"}, []byte{0x05, 0x22}, 220.5},
+		{DataItem{Name: "current", DI: "02020100", Format: "XXX.XXX", Signed: true}, []byte{0x00, 0x15, 0x80}, -1.5},
+		{DataItem{Name: "count", DI: "03300000", Format: "XXXXXX"}, []byte{0x12, 0x00, 0x00}, int64(12)},
+		{DataItem{Name: "time", DI: "04000102", Format: "hhmmss"}, []byte{0x59, 0x30, 0x08}, "083059"},
+	}
+	for _, tt := range tests {
+		if err := tt.item.validate(); err != nil {
+			t.Fatal(err)
+		}
+		value, err := tt.item.decode(tt.data)
+		if err != nil || value != tt.value {
+			t.Errorf("%s 解析 % X 得到 %v %v, 期望 %v", tt.item.Name, tt.data, value, err, tt.value)
+		}
+		data, err := tt.item.encode(tt.value)
+		if err != nil || !bytes.Equal(data, tt.data) {
+			t.Errorf("%s 编码 %v 得到 % X %v, 期望 % X", tt.item.Name, tt.value, data, err, tt.data)
+		}
+	}
+
+	item := DataItem{Name: "voltage", DI: "02010100", Format: "XXX.X"}
+	item.validate()
+	if _, err := item.decode([]byte{0x0A, 0x22}); err == nil {
+		t.Error("非 BCD 码应返回错误")
+	}
+	if _, err := item.encode(-1); err == nil {
+		t.Error("无符号数据不能为负数")
+	}
+	if _, err := item.encode(1000); err == nil {
+		t.Error("超出格式范围应返回错误")
+	}
+	for _, invalid := range []DataItem{
+		{Name: "a", DI: "0001", Format: "XX"},
+		{Name: "b", DI: "00010000", Format: "XXX"},
+		{Name: "c", DI: "00010000", Format: "YYMM", Signed: true},
+		{DI: "00010000", Format: "XX"},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("%+v 应返回错误", invalid)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+// Package hj212 提供 HJ 212-2017 污染物在线监控(监测)系统数据传输协议处理器
+package hj212
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// 常用命令编码
+const (
+	CNSetTimeout       = "1000" // 设置超时时间及重发次数
+	CNGetTime          = "1011" // 提取现场机时间
+	CNSetTime          = "1012" // 设置现场机时间
+	CNTimeCalibration  = "1013" // 现场机时间校准请求
+	CNGetRtdInterval   = "1061" // 提取实时数据间隔
+	CNSetRtdInterval   = "1062" // 设置实时数据间隔
+	CNGetMinInterval   = "1063" // 提取分钟数据间隔
+	CNSetMinInterval   = "1064" // 设置分钟数据间隔
+	CNSetPassword      = "1072" // 设置现场机访问密码
+	CNRealtimeData     = "2011" // 实时数据，下发时为取污染物实时数据
+	CNStopRealtimeData = "2012" // 停止察看污染物实时数据
+	CNRunningStatus    = "2021" // 设备运行状态，下发时为取设备运行状态数据
+	CNStopStatus       = "2022" // 停止察看设备运行状态
+	CNDayData          = "2031" // 日历史数据
+	CNRunningTime      = "2041" // 设备运行时间日历史数据
+	CNMinuteData       = "2051" // 分钟数据
+	CNHourData         = "2061" // 小时数据
+	CNZeroCalibration  = "3011" // 零点校准量程校准
+	CNSample           = "3012" // 即时采样
+	CNRequestResponse  = "9011" // 请求应答
+	CNExecuteResponse  = "9012" // 执行结果
+	CNNotifyResponse   = "9013" // 通知应答
+	CNDataResponse     = "9014" // 数据应答
+)
+
+const (
+	systemInteraction = "91" // 系统交互的系统编码
+	defaultPassword   = "123456"
+	fieldDataTime     = "DataTime"
+	fieldQnRtn        = "QnRtn"
+	fieldExeRtn       = "ExeRtn"
+	fieldSystemTime   = "SystemTime"
+	returnSuccess     = "1"
+)
+
+// completions 以请求应答或通知应答结束的命令，其他命令以执行结果结束
+var completions = map[string]string{
+	CNRealtimeData:     CNRequestResponse,
+	CNRunningStatus:    CNRequestResponse,
+	CNStopRealtimeData: CNNotifyResponse,
+	CNStopStatus:       CNNotifyResponse,
+}
+
+// numericFields 数值类型的污染物字段，其他字段(如 Flag、SampleTime)保持字符串
+var numericFields = map[string]bool{
+	"Rtd": true, "Avg": true, "Min": true, "Max": true, "Cou": true,
+	"ZsRtd": true, "ZsAvg": true, "ZsMin": true, "ZsMax": true, "RS": true, "RT": true,
+}
+
+// Config HJ 212 协议处理器配置
+type Config struct {
+	Password   string `json:"password"`   // 下发命令的访问密码,默认使用现场机上报的密码,都没有时为 123456
+	SystemCode string `json:"systemCode"` // 下发命令的系统编码,默认使用现场机上报的系统编码
+}
+
+// Command 下发给现场机的命令
+type Command struct {
+	CN string                 `json:"cn"` // 命令编码
+	CP map[string]interface{} `json:"cp"` // 指令参数,如 RtdInterval、BeginTime,时间类型的值按 yyyyMMddHHmmss 格式化
+}
+
+// ResponseError 现场机拒绝请求或执行失败
+type ResponseError struct {
+	CN   string // 应答的命令编码,9011 或 9012
+	Code string // QnRtn 或 ExeRtn
+}
+
+// Error 实现 error 接口
+func (e *ResponseError) Error() string {
+	if e.CN == CNRequestResponse {
+		names := map[string]string{"2": "请求被拒绝", "3": "PW 错误", "4": "MN 错误", "5": "ST 错误", "6": "Flag 错误",
+			"7": "QN 错误", "8": "CN 错误", "9": "CRC 校验错误", "100": "未知错误"}
+		return fmt.Sprintf("HJ 212 请求应答失败: %s(QnRtn=%s)", names[e.Code], e.Code)
+	}
+	names := map[string]string{"2": "执行失败", "3": "命令请求条件错误", "4": "通讯超时", "5": "系统繁忙不能执行",
+		"6": "系统故障", "100": "没有数据"}
+	return fmt.Sprintf("HJ 212 执行失败: %s(ExeRtn=%s)", names[e.Code], e.Code)
+}
+
+// Handler HJ 212 协议处理器
+// 现场机的设备唯一标识 MN 作为设备标识，上报的数据按污染物编码转换为属性，属性值为 Rtd、Avg、Flag 等字段组成的对象
+// 下发数据可以是 Command、命令编码或可转换为 Command 的键值数据，命令的响应为现场机返回的指令参数
+type Handler struct {
+	config Config
+
+	mu       sync.Mutex
+	stations map[*model.Device]*station
+}
+
+// station 现场机的会话状态
+type station struct {
+	mn, st, pw string          // 现场机上报的设备唯一标识、系统编码和访问密码
+	pending    *pendingCommand // 已下发、等待应答的命令
+}
+
+// pendingCommand 已下发、等待应答的命令
+type pendingCommand struct {
+	qn, cn string
+	result map[string]interface{}
+}
+
+// New 创建 HJ 212 协议处理器
+func New(config Config) *Handler {
+	return &Handler{config: config, stations: make(map[*model.Device]*station)}
+}
+
+// Init 实现 network.ProtocolHandler 接口，将数据包中的 MN 作为设备标识
+func (h *Handler) Init(device *model.Device, data []byte) error {
+	if device == nil || device.DeviceKey != "" {
+		return nil
+	}
+	if packet, err := ParsePacket(data); err == nil && packet.MN != "" {
+		device.DeviceKey = packet.MN
+	}
+	return nil
+}
+
+// OnConnect 实现 network.SessionHandler 接口
+func (h *Handler) OnConnect(device *model.Device) error {
+	return nil
+}
+
+// OnDisconnect 实现 network.SessionHandler 接口，清理现场机的会话状态
+func (h *Handler) OnDisconnect(device *model.Device) {
+	h.mu.Lock()
+	delete(h.stations, device)
+	h.mu.Unlock()
+}
+
+// Encode 实现 network.ProtocolHandler 接口，生成命令数据包并登记等待应答；[]byte 为 Decode 返回的应答包，原样发送
+func (h *Handler) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	if packet, ok := data.([]byte); ok {
+		return packet, nil
+	}
+	command, err := h.command(data)
+	if err != nil {
+		return nil, err
+	}
+	cp := make(map[string]string, len(command.CP))
+	for key, value := range command.CP {
+		cp[key] = formatValue(value)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.station(device)
+	packet := Packet{
+		QN:   newQN(time.Now()),
+		ST:   h.config.SystemCode,
+		CN:   command.CN,
+		PW:   h.password(s),
+		MN:   s.mn,
+		Flag: flagVersion | flagAck,
+		CP:   cp,
+	}
+	if packet.ST == "" {
+		packet.ST = s.st
+	}
+	if packet.MN == "" && device != nil {
+		packet.MN = device.DeviceKey
+	}
+	if packet.ST == "" {
+		return nil, errors.New("未收到现场机上报的系统编码，需要配置 systemCode")
+	}
+	if s.pending != nil && s.pending.qn == packet.QN {
+		return nil, fmt.Errorf("请求编码 %s 重复", packet.QN) // 同一毫秒内的命令
+	}
+	encoded, err := packet.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	s.pending = &pendingCommand{qn: packet.QN, cn: packet.CN}
+	return encoded, nil
+}
+
+// Decode 实现 network.ProtocolHandler 接口
+// 带数据时间的数据包作为属性上报，需要应答时回复数据应答；请求应答、执行结果和命令返回的数据交给等待的命令
+func (h *Handler) Decode(device *model.Device, data []byte) ([]byte, error) {
+	if device == nil {
+		return nil, nil
+	}
+	packet, err := ParsePacket(data)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	s := h.station(device)
+	if packet.MN != "" {
+		s.mn = packet.MN
+	}
+	if packet.ST != "" && packet.ST != systemInteraction {
+		s.st = packet.ST
+	}
+	if packet.PW != "" {
+		s.pw = packet.PW
+	}
+	var pending *pendingCommand
+	if s.pending != nil && s.pending.qn == packet.QN {
+		pending = s.pending
+	}
+	password := h.password(s)
+	h.mu.Unlock()
+
+	switch packet.CN {
+	case CNRequestResponse, CNExecuteResponse, CNNotifyResponse, CNDataResponse:
+		if pending != nil {
+			h.complete(device, pending, packet)
+		}
+		return nil, nil
+	case CNTimeCalibration:
+		// 现场机请求校时，下发设置现场机时间
+		cp := map[string]string{fieldSystemTime: time.Now().Format(timeLayout)}
+		if polID, ok := packet.CP["PolId"]; ok {
+			cp["PolId"] = polID
+		}
+		return Packet{QN: newQN(time.Now()), ST: packet.ST, CN: CNSetTime, PW: password, MN: packet.MN, Flag: flagVersion, CP: cp}.Bytes()
+	}
+
+	if _, ok := packet.CP[fieldDataTime]; ok {
+		h.report(device.DeviceKey, packet)
+	} else if pending != nil {
+		// 提取参数等命令返回的数据
+		h.mu.Lock()
+		if pending.result == nil {
+			pending.result = make(map[string]interface{})
+		}
+		for key, value := range packet.CP {
+			pending.result[key] = value
+		}
+		h.mu.Unlock()
+	}
+	if packet.needAck() {
+		return Packet{QN: packet.QN, ST: systemInteraction, CN: CNDataResponse, PW: packet.PW, MN: packet.MN, Flag: flagVersion}.Bytes()
+	}
+	return nil, nil
+}
+
+// FrameDecoder 实现 network.FrameDecoderProvider 接口
+func (h *Handler) FrameDecoder() network.FrameDecoder {
+	return frameDecoder{}
+}
+
+// complete 处理命令的应答，命令结束时交给等待的请求
+func (h *Handler) complete(device *model.Device, pending *pendingCommand, packet Packet) {
+	completion, ok := completions[pending.cn]
+	if !ok {
+		completion = CNExecuteResponse
+	}
+	var response interface{}
+	switch packet.CN {
+	case CNRequestResponse:
+		if code := packet.CP[fieldQnRtn]; code != returnSuccess {
+			response = &ResponseError{CN: packet.CN, Code: code}
+		} else if completion != CNRequestResponse {
+			return // 准备执行，等待执行结果
+		}
+	case CNExecuteResponse:
+		if code := packet.CP[fieldExeRtn]; code != returnSuccess {
+			response = &ResponseError{CN: packet.CN, Code: code}
+		} else {
+			h.mu.Lock()
+			if pending.result != nil {
+				response = pending.result
+			}
+			h.mu.Unlock()
+		}
+	case CNNotifyResponse:
+		if completion != CNNotifyResponse {
+			return
+		}
+	default:
+		return
+	}
+
+	h.mu.Lock()
+	if s := h.stations[device]; s != nil && s.pending == pending {
+		s.pending = nil
+	}
+	h.mu.Unlock()
+	network.Respond(device.DeviceKey, "", response)
+}
+
+// report 将数据包中的污染物数据作为属性上报，数据时间作为属性的时间
+func (h *Handler) report(deviceKey string, packet Packet) {
+	properties := Properties(packet)
+	if deviceKey == "" || len(properties) == 0 {
+		return
+	}
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         deviceKey,
+		"PropertieDataList": properties,
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 HJ 212 设备 %s 数据失败: %v", deviceKey, err)
+	}
+}
+
+// Properties 将数据包中的指令参数按污染物编码转换为属性
+// w01018-Rtd=101.7,w01018-Flag=N 转换为 w01018: {Rtd: 101.7, Flag: "N"}，有数据时间时属性值为带时间的 mqttProtocol.PropertyNode
+func Properties(packet Packet) map[string]interface{} {
+	pollutants := make(map[string]map[string]interface{})
+	for key, value := range packet.CP {
+		code, field, ok := strings.Cut(key, "-")
+		if !ok {
+			continue
+		}
+		if pollutants[code] == nil {
+			pollutants[code] = make(map[string]interface{})
+		}
+		pollutants[code][field] = value
+		if numericFields[field] {
+			if number, err := strconv.ParseFloat(value, 64); err == nil {
+				pollutants[code][field] = number
+			}
+		}
+	}
+
+	var createTime int64
+	if dataTime, err := time.ParseInLocation(timeLayout, packet.CP[fieldDataTime], time.Local); err == nil {
+		createTime = dataTime.Unix()
+	}
+	properties := make(map[string]interface{}, len(pollutants))
+	for code, fields := range pollutants {
+		if createTime > 0 {
+			properties[code] = mqttProtocol.PropertyNode{Value: fields, CreateTime: createTime}
+		} else {
+			properties[code] = fields
+		}
+	}
+	return properties
+}
+
+// command 将下发数据转换为命令
+func (h *Handler) command(data interface{}) (Command, error) {
+	var command Command
+	switch v := data.(type) {
+	case Command:
+		command = v
+	case *Command:
+		command = *v
+	case string:
+		command = Command{CN: v}
+	default:
+		if err := gconv.Struct(data, &command); err != nil {
+			return Command{}, fmt.Errorf("HJ 212 命令格式错误: %v", err)
+		}
+	}
+	if command.CN == "" {
+		return Command{}, errors.New("HJ 212 命令缺少命令编码")
+	}
+	return command, nil
+}
+
+// password 下发命令使用的访问密码，调用方持有 h.mu
+func (h *Handler) password(s *station) string {
+	switch {
+	case h.config.Password != "":
+		return h.config.Password
+	case s.pw != "":
+		return s.pw
+	}
+	return defaultPassword
+}
+
+// station 获取设备的会话状态，调用方持有 h.mu
+func (h *Handler) station(device *model.Device) *station {
+	s, ok := h.stations[device]
+	if !ok {
+		s = &station{}
+		h.stations[device] = s
+	}
+	return s
+}
@@ -0,0 +1,172 @@
+package hj212
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+const testMN = "010000A8900016F000169DC0"
+
+// analyzer 模拟的现场机，应答上位机下发的命令
+type analyzer struct {
+	conn     net.Conn
+	received chan Packet // 数据应答和校时等非命令数据包
+}
+
+// send 发送现场机的数据包
+func (a *analyzer) send(packet Packet) {
+	packet.MN, packet.PW = testMN, "123456"
+	if packet.ST == "" {
+		packet.ST = "32"
+	}
+	data, _ := packet.Bytes()
+	a.conn.Write(data)
+}
+
+// serve 读取上位机的数据包并应答
+func (a *analyzer) serve() {
+	var buf []byte
+	chunk := make([]byte, 1024)
+	for {
+		n, err := a.conn.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			data, consumed, _ := frameDecoder{}.Decode(buf)
+			if consumed == 0 {
+				break
+			}
+			buf = buf[consumed:]
+			if data == nil {
+				continue
+			}
+			packet, err := ParsePacket(data)
+			if err != nil {
+				continue
+			}
+			switch packet.CN {
+			case CNGetTime:
+				a.send(Packet{QN: packet.QN, ST: systemInteraction, CN: CNRequestResponse, Flag: flagVersion, CP: map[string]string{fieldQnRtn: "1"}})
+				a.send(Packet{QN: packet.QN, CN: CNGetTime, Flag: flagVersion, CP: map[string]string{fieldSystemTime: "20240101120000"}})
+				a.send(Packet{QN: packet.QN, ST: systemInteraction, CN: CNExecuteResponse, Flag: flagVersion, CP: map[string]string{fieldExeRtn: "1"}})
+			case CNSetRtdInterval:
+				a.send(Packet{QN: packet.QN, ST: systemInteraction, CN: CNRequestResponse, Flag: flagVersion, CP: map[string]string{fieldQnRtn: "3"}})
+			default:
+				a.received <- packet
+			}
+		}
+	}
+}
+
+func TestHandlerOverTCP(t *testing.T) {
+	handler := New(Config{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	server := network.NewTCPServer(network.WithProtocolHandler(handler))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reports := make(chan map[string]interface{}, 4)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		reports <- e.Data()
+		return nil
+	}))
+
+	station := &analyzer{conn: conn, received: make(chan Packet, 4)}
+	go station.serve()
+	// 上传实时数据，需要数据应答
+	station.send(Packet{QN: "20240101120000001", CN: CNRealtimeData, Flag: flagVersion | flagAck,
+		CP: map[string]string{fieldDataTime: "20240101120000", "w01018-Rtd": "101.7", "w01018-Flag": "N"}})
+
+	select {
+	case ack := <-station.received:
+		if ack.CN != CNDataResponse || ack.QN != "20240101120000001" || ack.ST != systemInteraction {
+			t.Fatalf("数据应答 %+v", ack)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有收到数据应答")
+	}
+	select {
+	case report := <-reports:
+		want := mqttProtocol.PropertyNode{
+			Value:      map[string]interface{}{"Rtd": 101.7, "Flag": "N"},
+			CreateTime: time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local).Unix(),
+		}
+		properties := report["PropertieDataList"].(map[string]interface{})
+		if report["DeviceKey"] != testMN || !reflect.DeepEqual(properties["w01018"], want) {
+			t.Fatalf("上报数据 %v", report)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有上报数据")
+	}
+
+	locator := server.(network.DeviceLocator)
+	request := func(ctx context.Context, data interface{}) (interface{}, error) {
+		target := locator.LookupDevice(testMN)
+		if target == nil {
+			return nil, network.ErrDeviceNotFound
+		}
+		pending, err := network.BeginRequest(ctx, testMN, handler, target, data)
+		if err != nil {
+			return nil, err
+		}
+		defer pending.Done()
+		if err := server.SendData(target, data); err != nil {
+			return nil, err
+		}
+		return pending.Wait(ctx)
+	}
+
+	timeout, cancelRequest := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelRequest()
+	response, err := request(timeout, CNGetTime)
+	if err != nil || !reflect.DeepEqual(response, map[string]interface{}{fieldSystemTime: "20240101120000"}) {
+		t.Fatalf("提取现场机时间 %v %v", response, err)
+	}
+
+	var rejected *ResponseError
+	_, err = request(timeout, Command{CN: CNSetRtdInterval, CP: map[string]interface{}{"RtdInterval": 60}})
+	if !errors.As(err, &rejected) || rejected.Code != "3" {
+		t.Fatalf("被拒绝的命令应作为错误返回，实际 %v", err)
+	}
+
+	// 现场机请求校时，回复设置现场机时间
+	station.send(Packet{QN: "20240101120000002", CN: CNTimeCalibration, Flag: flagVersion})
+	select {
+	case packet := <-station.received:
+		if packet.CN != CNSetTime || len(packet.CP[fieldSystemTime]) != len(timeLayout) || packet.MN != testMN {
+			t.Fatalf("校时 %+v", packet)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有收到校时")
+	}
+}
@@ -0,0 +1,225 @@
+package hj212
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	packetHeader  = "##"
+	packetTail    = "\r\n"
+	lengthDigits  = 4
+	crcDigits     = 4
+	maxDataLength = 9999
+	cpPrefix      = "CP=&&"
+	cpSuffix      = "&&"
+	timeLayout    = "20060102150405"
+	qnLayout      = "20060102150405.000"
+
+	flagAck     = 0x01 // A 位,需要应答
+	flagSplit   = 0x02 // D 位,分包
+	flagVersion = 0x04 // 版本号 V5~V0 为 000001,即 HJ 212-2017
+)
+
+var (
+	// ErrInvalidPacket 数据包格式错误
+	ErrInvalidPacket = errors.New("HJ 212 数据包格式错误")
+	// ErrCRC 数据包 CRC 校验失败
+	ErrCRC = errors.New("HJ 212 数据包 CRC 校验失败")
+)
+
+// Packet 表示一个 HJ 212 数据包
+type Packet struct {
+	QN   string            // 请求编码,yyyyMMddHHmmssZZZ
+	ST   string            // 系统编码
+	CN   string            // 命令编码
+	PW   string            // 访问密码
+	MN   string            // 设备唯一标识
+	Flag int               // 拆分包及应答标志
+	PNUM string            // 总包数,分包时使用
+	PNO  string            // 包号,分包时使用
+	CP   map[string]string // 指令参数,键为 DataTime、w01018-Rtd 等
+}
+
+// needAck 是否需要应答
+func (p Packet) needAck() bool {
+	return p.Flag&flagAck != 0
+}
+
+// Bytes 生成数据包：包头 + 数据段长度 + 数据段 + CRC + 包尾
+func (p Packet) Bytes() ([]byte, error) {
+	var data strings.Builder
+	for _, field := range [][2]string{{"QN", p.QN}, {"ST", p.ST}, {"CN", p.CN}, {"PW", p.PW}, {"MN", p.MN}} {
+		if field[1] != "" {
+			data.WriteString(field[0] + "=" + field[1] + ";")
+		}
+	}
+	data.WriteString("Flag=" + strconv.Itoa(p.Flag) + ";")
+	if p.Flag&flagSplit != 0 {
+		data.WriteString("PNUM=" + p.PNUM + ";PNO=" + p.PNO + ";")
+	}
+	data.WriteString(cpPrefix + encodeCP(p.CP) + cpSuffix)
+	if data.Len() > maxDataLength {
+		return nil, fmt.Errorf("HJ 212 数据段长度 %d 超出范围", data.Len())
+	}
+	return []byte(fmt.Sprintf("%s%04d%s%04X%s", packetHeader, data.Len(), data.String(), CRC16([]byte(data.String())), packetTail)), nil
+}
+
+// ParsePacket 解析数据包并校验长度和 CRC
+func ParsePacket(raw []byte) (Packet, error) {
+	raw = bytes.TrimSuffix(raw, []byte(packetTail))
+	if len(raw) < len(packetHeader)+lengthDigits+crcDigits || !bytes.HasPrefix(raw, []byte(packetHeader)) {
+		return Packet{}, ErrInvalidPacket
+	}
+	length, err := strconv.Atoi(string(raw[len(packetHeader) : len(packetHeader)+lengthDigits]))
+	data := raw[len(packetHeader)+lengthDigits : len(raw)-crcDigits]
+	if err != nil || length != len(data) {
+		return Packet{}, ErrInvalidPacket
+	}
+	crc, err := strconv.ParseUint(string(raw[len(raw)-crcDigits:]), 16, 16)
+	if err != nil || uint16(crc) != CRC16(data) {
+		return Packet{}, ErrCRC
+	}
+
+	text := string(data)
+	start, end := strings.Index(text, cpPrefix), strings.LastIndex(text, cpSuffix)
+	if start < 0 || end < start+len(cpPrefix) {
+		return Packet{}, ErrInvalidPacket
+	}
+	p := Packet{CP: decodeCP(text[start+len(cpPrefix) : end])}
+	for _, field := range strings.Split(strings.TrimSuffix(text[:start], ";"), ";") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "QN":
+			p.QN = value
+		case "ST":
+			p.ST = value
+		case "CN":
+			p.CN = value
+		case "PW":
+			p.PW = value
+		case "MN":
+			p.MN = value
+		case "Flag":
+			p.Flag, _ = strconv.Atoi(value)
+		case "PNUM":
+			p.PNUM = value
+		case "PNO":
+			p.PNO = value
+		}
+	}
+	if p.CN == "" {
+		return Packet{}, ErrInvalidPacket
+	}
+	return p, nil
+}
+
+// decodeCP 解析指令参数，字段之间以 ; 或 , 分隔
+func decodeCP(cp string) map[string]string {
+	fields := make(map[string]string)
+	for _, group := range strings.Split(cp, ";") {
+		for _, field := range strings.Split(group, ",") {
+			if key, value, ok := strings.Cut(field, "="); ok && key != "" {
+				fields[key] = value
+			}
+		}
+	}
+	return fields
+}
+
+// encodeCP 生成指令参数，按键排序，同一污染物的字段以 , 分隔
+func encodeCP(cp map[string]string) string {
+	keys := make([]string, 0, len(cp))
+	for key := range cp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	var last string
+	for i, key := range keys {
+		code, _, _ := strings.Cut(key, "-")
+		if i > 0 {
+			if code == last && strings.Contains(key, "-") {
+				builder.WriteByte(',')
+			} else {
+				builder.WriteByte(';')
+			}
+		}
+		last = code
+		builder.WriteString(key + "=" + cp[key])
+	}
+	return builder.String()
+}
+
+// formatValue 将指令参数的值转换为字符串，时间按 yyyyMMddHHmmss 格式化
+func formatValue(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(timeLayout)
+	}
+	return gconv.String(value)
+}
+
+// newQN 生成请求编码，精确到毫秒
+func newQN(t time.Time) string {
+	return strings.ReplaceAll(t.Format(qnLayout), ".", "")
+}
+
+// CRC16 计算 HJ 212 数据段的 CRC16 校验值
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc = crc>>8 ^ uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// frameDecoder HJ 212 帧解码器，按包头 ## 和数据段长度切分，丢弃包头之前的数据
+type frameDecoder struct{}
+
+// Decode 实现 network.FrameDecoder 接口
+func (frameDecoder) Decode(buf []byte) ([]byte, int, error) {
+	start := bytes.Index(buf, []byte(packetHeader))
+	switch {
+	case start < 0:
+		// 保留末尾可能是包头一部分的 #
+		if n := len(buf); n > 0 && buf[n-1] == '#' {
+			if n == 1 {
+				return nil, 0, nil
+			}
+			return nil, n - 1, nil
+		}
+		return nil, len(buf), nil
+	case start > 0:
+		return nil, start, nil
+	}
+	headerLength := len(packetHeader) + lengthDigits
+	if len(buf) < headerLength {
+		return nil, 0, nil
+	}
+	length, err := strconv.Atoi(string(buf[len(packetHeader):headerLength]))
+	if err != nil || length < 0 {
+		return nil, len(packetHeader), nil // 长度不是数字，跳过包头重新查找
+	}
+	total := headerLength + length + crcDigits + len(packetTail)
+	if len(buf) < total {
+		return nil, 0, nil
+	}
+	if !bytes.Equal(buf[total-len(packetTail):total], []byte(packetTail)) {
+		return nil, len(packetHeader), nil
+	}
+	return buf[:total], total, nil
+}
@@ -0,0 +1,81 @@
+package hj212
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+)
+
+// 标准附录中的示例数据包
+const samplePacket = "##0101QN=20160801085857223;ST=32;CN=1062;PW=100000;MN=010000A8900016F000169DC0;Flag=5;CP=&&RtdInterval=30&&1C80\r\n"
+
+func TestPacket(t *testing.T) {
+	packet, err := ParsePacket([]byte(samplePacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Packet{QN: "20160801085857223", ST: "32", CN: "1062", PW: "100000", MN: "010000A8900016F000169DC0", Flag: 5,
+		CP: map[string]string{"RtdInterval": "30"}}
+	if !reflect.DeepEqual(packet, want) || !packet.needAck() {
+		t.Fatalf("解析数据包 %+v", packet)
+	}
+	data, err := packet.Bytes()
+	if err != nil || string(data) != samplePacket {
+		t.Fatalf("生成数据包 %q %v", data, err)
+	}
+
+	corrupted := []byte(samplePacket)
+	corrupted[len(corrupted)-4] = '0'
+	if _, err := ParsePacket(corrupted); !errors.Is(err, ErrCRC) {
+		t.Fatalf("CRC 错误 %v", err)
+	}
+	if _, err := ParsePacket([]byte("##0010QN=1;CP=&&&&1C80\r\n")); !errors.Is(err, ErrInvalidPacket) {
+		t.Fatalf("长度错误 %v", err)
+	}
+}
+
+func TestCP(t *testing.T) {
+	cp := decodeCP("DataTime=20160801084000;w01018-Rtd=101.7,w01018-Flag=N;w21003-Rtd=2.5,w21003-Flag=N")
+	want := map[string]string{"DataTime": "20160801084000", "w01018-Rtd": "101.7", "w01018-Flag": "N", "w21003-Rtd": "2.5", "w21003-Flag": "N"}
+	if !reflect.DeepEqual(cp, want) {
+		t.Fatalf("解析指令参数 %v", cp)
+	}
+	if encoded := encodeCP(cp); encoded != "DataTime=20160801084000;w01018-Flag=N,w01018-Rtd=101.7;w21003-Flag=N,w21003-Rtd=2.5" {
+		t.Fatalf("生成指令参数 %s", encoded)
+	}
+
+	properties := Properties(Packet{CP: cp})
+	node, ok := properties["w01018"].(mqttProtocol.PropertyNode)
+	if !ok || node.CreateTime == 0 || !reflect.DeepEqual(node.Value, map[string]interface{}{"Rtd": 101.7, "Flag": "N"}) {
+		t.Fatalf("污染物属性 %v", properties)
+	}
+	if properties := Properties(Packet{CP: map[string]string{"SB1-RS": "1"}}); !reflect.DeepEqual(properties["SB1"], map[string]interface{}{"RS": 1.0}) {
+		t.Fatalf("没有数据时间的属性 %v", properties)
+	}
+}
+
+func TestFrameDecoder(t *testing.T) {
+	stream := []byte("xx" + samplePacket + "##01")
+
+	var decoder frameDecoder
+	if frame, consumed, _ := decoder.Decode(stream); frame != nil || consumed != 2 {
+		t.Fatalf("包头之前的数据应丢弃 %d", consumed)
+	}
+	stream = stream[2:]
+	frame, consumed, err := decoder.Decode(stream)
+	if err != nil || !bytes.Equal(frame, []byte(samplePacket)) {
+		t.Fatalf("切分结果 %q %v", frame, err)
+	}
+	if _, consumed, _ = decoder.Decode(stream[consumed:]); consumed != 0 {
+		t.Fatal("数据不足一包时应等待")
+	}
+	if _, consumed, _ = decoder.Decode([]byte("##00x1")); consumed != 2 {
+		t.Fatal("长度不是数字时应跳过包头")
+	}
+	if _, consumed, _ = decoder.Decode([]byte("abc#")); consumed != 3 {
+		t.Fatal("末尾的 # 可能是包头的一部分，应保留")
+	}
+}
@@ -0,0 +1,119 @@
+package iec104
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	startByte     = 0x68
+	apciLength    = 6   // 启动字符 + 长度 + 4 字节控制域
+	maxAPDULength = 253 // 长度域的最大值
+	seqModulo     = 1 << 15
+)
+
+// U 格式帧的功能
+const (
+	uStartDTAct = 0x07
+	uStartDTCon = 0x0B
+	uStopDTAct  = 0x13
+	uStopDTCon  = 0x23
+	uTestFRAct  = 0x43
+	uTestFRCon  = 0x83
+)
+
+// 帧格式
+const (
+	formatI = iota // 编号的信息传输
+	formatS        // 编号的监视功能
+	formatU        // 未编号的控制功能
+)
+
+// ErrInvalidFrame 报文格式错误
+var ErrInvalidFrame = errors.New("IEC 104 报文格式错误")
+
+// apdu 解析后的 APDU
+type apdu struct {
+	format   int
+	sendSeq  uint16 // I 格式帧的发送序号 N(S)
+	recvSeq  uint16 // I/S 格式帧的接收序号 N(R)
+	function byte   // U 格式帧的功能
+	asdu     []byte // I 格式帧的 ASDU
+}
+
+// parseAPDU 解析一个完整的 APDU
+func parseAPDU(data []byte) (apdu, error) {
+	if len(data) < apciLength || data[0] != startByte || int(data[1]) != len(data)-2 {
+		return apdu{}, ErrInvalidFrame
+	}
+	control := data[2:apciLength]
+	switch {
+	case control[0]&0x01 == 0:
+		if len(data) == apciLength {
+			return apdu{}, ErrInvalidFrame
+		}
+		return apdu{
+			format:  formatI,
+			sendSeq: binary.LittleEndian.Uint16(control[0:]) >> 1,
+			recvSeq: binary.LittleEndian.Uint16(control[2:]) >> 1,
+			asdu:    data[apciLength:],
+		}, nil
+	case control[0]&0x03 == 0x01:
+		return apdu{format: formatS, recvSeq: binary.LittleEndian.Uint16(control[2:]) >> 1}, nil
+	default:
+		return apdu{format: formatU, function: control[0]}, nil
+	}
+}
+
+// iFrame 生成 I 格式帧
+func iFrame(sendSeq, recvSeq uint16, asdu []byte) []byte {
+	frame := []byte{startByte, byte(4 + len(asdu))}
+	frame = binary.LittleEndian.AppendUint16(frame, sendSeq<<1)
+	frame = binary.LittleEndian.AppendUint16(frame, recvSeq<<1)
+	return append(frame, asdu...)
+}
+
+// sFrame 生成 S 格式帧
+func sFrame(recvSeq uint16) []byte {
+	frame := []byte{startByte, 4, 0x01, 0x00}
+	return binary.LittleEndian.AppendUint16(frame, recvSeq<<1)
+}
+
+// uFrame 生成 U 格式帧
+func uFrame(function byte) []byte {
+	return []byte{startByte, 4, function, 0x00, 0x00, 0x00}
+}
+
+// seqDistance 序号 from 到 to 之间相差的帧数
+func seqDistance(from, to uint16) uint16 {
+	return (to - from + seqModulo) % seqModulo
+}
+
+// frameDecoder IEC 104 帧解码器，按长度域切分，丢弃启动字符之前的数据
+type frameDecoder struct{}
+
+// Decode 实现 network.FrameDecoder 接口
+func (frameDecoder) Decode(buf []byte) ([]byte, int, error) {
+	for i, b := range buf {
+		if b == startByte {
+			if i > 0 {
+				return nil, i, nil
+			}
+			break
+		}
+		if i == len(buf)-1 {
+			return nil, len(buf), nil
+		}
+	}
+	if len(buf) < 2 {
+		return nil, 0, nil
+	}
+	length := int(buf[1])
+	if length < 4 || length > maxAPDULength {
+		return nil, 0, ErrInvalidFrame
+	}
+	if len(buf) < length+2 {
+		return nil, 0, nil
+	}
+	return buf[:length+2], length + 2, nil
+}
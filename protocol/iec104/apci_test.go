@@ -0,0 +1,53 @@
+package iec104
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAPDU(t *testing.T) {
+	frame := iFrame(3, 5, []byte{CIcNa1, 1, CauseActivation, 0, 1, 0, 0, 0, 0, qualifierStation})
+	if want := []byte{0x68, 0x0E, 0x06, 0x00, 0x0A, 0x00, 0x64, 0x01, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x14}; !bytes.Equal(frame, want) {
+		t.Fatalf("I 格式帧 % X, 期望 % X", frame, want)
+	}
+	parsed, err := parseAPDU(frame)
+	if err != nil || parsed.format != formatI || parsed.sendSeq != 3 || parsed.recvSeq != 5 || len(parsed.asdu) != 10 {
+		t.Fatalf("解析 I 格式帧 %+v %v", parsed, err)
+	}
+	if parsed, err = parseAPDU(sFrame(0x7FFF)); err != nil || parsed.format != formatS || parsed.recvSeq != 0x7FFF {
+		t.Fatalf("解析 S 格式帧 %+v %v", parsed, err)
+	}
+	if parsed, err = parseAPDU(uFrame(uTestFRAct)); err != nil || parsed.format != formatU || parsed.function != uTestFRAct {
+		t.Fatalf("解析 U 格式帧 %+v %v", parsed, err)
+	}
+	if !bytes.Equal(uFrame(uStartDTAct), []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}) {
+		t.Fatal("STARTDT 激活帧错误")
+	}
+	if _, err := parseAPDU([]byte{0x68, 0x05, 0x00, 0x00, 0x00, 0x00}); err == nil {
+		t.Fatal("长度不一致应返回错误")
+	}
+	if seqDistance(0x7FFE, 1) != 3 || seqDistance(5, 5) != 0 {
+		t.Fatal("序号回绕计算错误")
+	}
+}
+
+func TestFrameDecoder(t *testing.T) {
+	stream := append([]byte{0x00, 0x01}, uFrame(uStartDTCon)...)
+	stream = append(stream, sFrame(1)[:3]...)
+
+	var decoder frameDecoder
+	if frame, consumed, _ := decoder.Decode(stream); frame != nil || consumed != 2 {
+		t.Fatalf("启动字符之前的数据应丢弃 %d", consumed)
+	}
+	stream = stream[2:]
+	frame, consumed, err := decoder.Decode(stream)
+	if err != nil || !bytes.Equal(frame, uFrame(uStartDTCon)) {
+		t.Fatalf("切分结果 % X %v", frame, err)
+	}
+	if _, consumed, _ = decoder.Decode(stream[consumed:]); consumed != 0 {
+		t.Fatal("数据不足一帧时应等待")
+	}
+	if _, _, err := decoder.Decode([]byte{0x68, 0xFF}); err == nil {
+		t.Fatal("长度超出范围应返回错误")
+	}
+}
@@ -0,0 +1,235 @@
+package iec104
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// 类型标识
+const (
+	MSpNa1 byte = 1   // 单点信息
+	MDpNa1 byte = 3   // 双点信息
+	MMeNa1 byte = 9   // 测量值,归一化值
+	MMeNb1 byte = 11  // 测量值,标度化值
+	MMeNc1 byte = 13  // 测量值,短浮点数
+	MItNa1 byte = 15  // 累计量
+	MSpTb1 byte = 30  // 带 CP56Time2a 时标的单点信息
+	MDpTb1 byte = 31  // 带 CP56Time2a 时标的双点信息
+	MMeTd1 byte = 34  // 带 CP56Time2a 时标的测量值,归一化值
+	MMeTe1 byte = 35  // 带 CP56Time2a 时标的测量值,标度化值
+	MMeTf1 byte = 36  // 带 CP56Time2a 时标的测量值,短浮点数
+	MItTb1 byte = 37  // 带 CP56Time2a 时标的累计量
+	CScNa1 byte = 45  // 单命令
+	CDcNa1 byte = 46  // 双命令
+	CSeNa1 byte = 48  // 设定值命令,归一化值
+	CSeNb1 byte = 49  // 设定值命令,标度化值
+	CSeNc1 byte = 50  // 设定值命令,短浮点数
+	CIcNa1 byte = 100 // 总召唤命令
+	CCiNa1 byte = 101 // 电能脉冲召唤命令
+)
+
+// 传送原因
+const (
+	CausePeriodic       = 1  // 周期、循环
+	CauseBackground     = 2  // 背景扫描
+	CauseSpontaneous    = 3  // 突发
+	CauseRequest        = 5  // 请求或被请求
+	CauseActivation     = 6  // 激活
+	CauseActivationCon  = 7  // 激活确认
+	CauseDeactivation   = 8  // 停止激活
+	CauseDeactivateCon  = 9  // 停止激活确认
+	CauseActivationTerm = 10 // 激活终止
+	CauseInterrogated   = 20 // 响应站召唤
+	CauseCounterRequest = 37 // 响应电能脉冲召唤
+	CauseUnknownType    = 44 // 未知的类型标识
+	CauseUnknownCause   = 45 // 未知的传送原因
+	CauseUnknownAddress = 46 // 未知的应用服务数据单元公共地址
+	CauseUnknownIOA     = 47 // 未知的信息对象地址
+
+	causeLastGroup = 41   // 响应第 4 组计数量召唤,21~36 为分组召唤,38~41 为分组计数量召唤
+	causeNegative  = 0x40 // P/N 位,否定确认
+	causeMask      = 0x3F // 去掉 P/N 位和试验位 T
+)
+
+const (
+	asduHeaderLength = 6 // 类型标识 + 可变结构限定词 + 2 字节传送原因 + 2 字节公共地址
+	ioaLength        = 3
+	cp56Length       = 7
+	qualityInvalid   = 0x80 // 品质描述词 IV 位
+	selectBit        = 0x80 // 命令限定词 S/E 位,1 为选择,0 为执行
+)
+
+// ErrUnsupportedType 不支持的类型标识
+var ErrUnsupportedType = errors.New("不支持的 IEC 104 类型标识")
+
+// asdu 应用服务数据单元
+type asdu struct {
+	typeID   byte
+	cause    byte // 传送原因(不含 P/N 和 T 位)
+	negative bool // 否定确认
+	address  uint16
+	objects  []object
+}
+
+// object 信息对象
+type object struct {
+	ioa     uint32
+	value   interface{}
+	invalid bool      // 品质描述词为无效
+	time    time.Time // 带时标的类型的时标
+}
+
+// elementLength 监视方向类型的信息元素长度(含品质描述词和时标)
+func elementLength(typeID byte) (int, bool) {
+	switch typeID {
+	case MSpNa1, MDpNa1:
+		return 1, true
+	case MMeNa1, MMeNb1:
+		return 3, true
+	case MMeNc1, MItNa1:
+		return 5, true
+	case MSpTb1, MDpTb1:
+		return 1 + cp56Length, true
+	case MMeTd1, MMeTe1:
+		return 3 + cp56Length, true
+	case MMeTf1, MItTb1:
+		return 5 + cp56Length, true
+	}
+	return 0, false
+}
+
+// parseASDU 解析 ASDU，监视方向的信息对象解析出值，其他类型只解析信息对象地址
+func parseASDU(data []byte) (asdu, error) {
+	if len(data) < asduHeaderLength {
+		return asdu{}, ErrInvalidFrame
+	}
+	a := asdu{
+		typeID:   data[0],
+		cause:    data[2] & causeMask,
+		negative: data[2]&causeNegative != 0,
+		address:  binary.LittleEndian.Uint16(data[4:]),
+	}
+	sequence, count := data[1]&0x80 != 0, int(data[1]&0x7F)
+	body := data[asduHeaderLength:]
+
+	length, monitor := elementLength(a.typeID)
+	if !monitor {
+		// 控制方向的确认和终止报文，只需要第一个信息对象地址
+		if len(body) < ioaLength {
+			return asdu{}, ErrInvalidFrame
+		}
+		a.objects = []object{{ioa: readIOA(body)}}
+		return a, nil
+	}
+
+	var ioa uint32
+	for i := 0; i < count; i++ {
+		if !sequence || i == 0 {
+			if len(body) < ioaLength {
+				return asdu{}, ErrInvalidFrame
+			}
+			ioa, body = readIOA(body), body[ioaLength:]
+		} else {
+			ioa++
+		}
+		if len(body) < length {
+			return asdu{}, ErrInvalidFrame
+		}
+		a.objects = append(a.objects, decodeElement(a.typeID, ioa, body[:length]))
+		body = body[length:]
+	}
+	if len(body) != 0 {
+		return asdu{}, ErrInvalidFrame
+	}
+	return a, nil
+}
+
+// decodeElement 解析监视方向的信息元素
+// 单点为 bool，双点为 0(中间)/1(分)/2(合)/3(不确定)，归一化值为 -1~1 的 float64，标度化值为 int16，短浮点数为 float32，累计量为 int32
+func decodeElement(typeID byte, ioa uint32, data []byte) object {
+	o := object{ioa: ioa}
+	var quality byte
+	switch typeID {
+	case MSpNa1, MSpTb1:
+		o.value, quality = data[0]&0x01 != 0, data[0]
+	case MDpNa1, MDpTb1:
+		o.value, quality = int(data[0]&0x03), data[0]
+	case MMeNa1, MMeTd1:
+		o.value, quality = float64(int16(binary.LittleEndian.Uint16(data)))/32768, data[2]
+	case MMeNb1, MMeTe1:
+		o.value, quality = int16(binary.LittleEndian.Uint16(data)), data[2]
+	case MMeNc1, MMeTf1:
+		o.value, quality = math.Float32frombits(binary.LittleEndian.Uint32(data)), data[4]
+	case MItNa1, MItTb1:
+		o.value, quality = int32(binary.LittleEndian.Uint32(data)), data[4]
+	}
+	o.invalid = quality&qualityInvalid != 0
+	if typeID >= MSpTb1 {
+		o.time = decodeCP56Time2a(data[len(data)-cp56Length:])
+	}
+	return o
+}
+
+// encodeASDU 生成只有一个信息对象的 ASDU
+func encodeASDU(typeID, cause byte, address uint16, ioa uint32, element []byte) []byte {
+	data := []byte{typeID, 1, cause, 0}
+	data = binary.LittleEndian.AppendUint16(data, address)
+	data = append(data, byte(ioa), byte(ioa>>8), byte(ioa>>16))
+	return append(data, element...)
+}
+
+// encodeCommand 生成控制方向命令的信息元素
+func encodeCommand(typeID byte, value interface{}, selected bool, qualifier byte) ([]byte, error) {
+	var se byte
+	if selected {
+		se = selectBit
+	}
+	switch typeID {
+	case CScNa1:
+		sco := se | qualifier<<2&0x7C
+		if gconv.Bool(value) {
+			sco |= 0x01
+		}
+		return []byte{sco}, nil
+	case CDcNa1:
+		v := gconv.Int(value)
+		if v < 1 || v > 2 {
+			return nil, fmt.Errorf("双命令的值应为 1(分) 或 2(合)，实际为 %v", value)
+		}
+		return []byte{se | qualifier<<2&0x7C | byte(v)}, nil
+	case CSeNa1:
+		v := gconv.Float64(value)
+		if v < -1 || v >= 1 {
+			return nil, fmt.Errorf("归一化设定值应为 -1~1 之间的数值，实际为 %v", value)
+		}
+		return append(binary.LittleEndian.AppendUint16(nil, uint16(int16(math.Round(v*32768)))), se|qualifier&0x7F), nil
+	case CSeNb1:
+		v := gconv.Int(value)
+		if v < math.MinInt16 || v > math.MaxInt16 {
+			return nil, fmt.Errorf("标度化设定值应为 int16 范围内的整数，实际为 %v", value)
+		}
+		return append(binary.LittleEndian.AppendUint16(nil, uint16(int16(v))), se|qualifier&0x7F), nil
+	case CSeNc1:
+		return append(binary.LittleEndian.AppendUint32(nil, math.Float32bits(gconv.Float32(value))), se|qualifier&0x7F), nil
+	case CIcNa1, CCiNa1:
+		return []byte{qualifier}, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedType, typeID)
+}
+
+// readIOA 读取 3 字节信息对象地址
+func readIOA(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+}
+
+// decodeCP56Time2a 解析 7 字节时标，时标按本地时间解释
+func decodeCP56Time2a(data []byte) time.Time {
+	ms := int(binary.LittleEndian.Uint16(data))
+	return time.Date(2000+int(data[6]&0x7F), time.Month(data[5]&0x0F), int(data[4]&0x1F),
+		int(data[3]&0x1F), int(data[2]&0x3F), ms/1000, ms%1000*int(time.Millisecond), time.Local)
+}
@@ -0,0 +1,88 @@
+package iec104
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// encodeCP56Time2a 生成 7 字节时标，模拟子站上送带时标的数据
+func encodeCP56Time2a(t time.Time) []byte {
+	data := binary.LittleEndian.AppendUint16(nil, uint16(t.Second()*1000+t.Nanosecond()/int(time.Millisecond)))
+	return append(data, byte(t.Minute()), byte(t.Hour()), byte(t.Weekday())<<5|byte(t.Day()), byte(t.Month()), byte(t.Year()-2000))
+}
+
+func TestParseASDU(t *testing.T) {
+	// 非顺序的短浮点数，第二个信息对象品质无效
+	data := []byte{MMeNc1, 2, CauseSpontaneous, 0, 1, 0}
+	data = append(data, 0xE9, 0x03, 0x00)
+	data = binary.LittleEndian.AppendUint32(data, math.Float32bits(220.5))
+	data = append(data, 0x00, 0xEA, 0x03, 0x00, 0, 0, 0, 0, qualityInvalid)
+	a, err := parseASDU(data)
+	if err != nil || a.typeID != MMeNc1 || a.cause != CauseSpontaneous || a.address != 1 || len(a.objects) != 2 {
+		t.Fatalf("解析 ASDU %+v %v", a, err)
+	}
+	if a.objects[0].ioa != 1001 || a.objects[0].value != float32(220.5) || a.objects[0].invalid || !a.objects[1].invalid {
+		t.Fatalf("信息对象 %+v", a.objects)
+	}
+
+	// 顺序的单点信息，信息对象地址依次递增
+	a, err = parseASDU([]byte{MSpNa1, 0x80 | 3, CauseInterrogated, 0, 1, 0, 0xD1, 0x07, 0x00, 0x01, 0x00, 0x01})
+	if err != nil || len(a.objects) != 3 || a.objects[2].ioa != 2003 || a.objects[0].value != true || a.objects[1].value != false {
+		t.Fatalf("顺序信息对象 %+v %v", a.objects, err)
+	}
+
+	// 带时标的标度化值和双点信息
+	at := time.Date(2024, 3, 5, 8, 30, 15, 250*int(time.Millisecond), time.Local)
+	data = append([]byte{MMeTe1, 1, CauseSpontaneous, 0, 1, 0, 0x01, 0x00, 0x00, 0x9C, 0xFF, 0x00}, encodeCP56Time2a(at)...)
+	if a, err = parseASDU(data); err != nil || a.objects[0].value != int16(-100) || !a.objects[0].time.Equal(at) {
+		t.Fatalf("带时标的标度化值 %+v %v", a.objects, err)
+	}
+	if a, err = parseASDU([]byte{MDpNa1, 1, CauseSpontaneous, 0, 1, 0, 0x01, 0x00, 0x00, 0x02}); err != nil || a.objects[0].value != 2 {
+		t.Fatalf("双点信息 %+v %v", a.objects, err)
+	}
+
+	// 否定确认
+	if a, err = parseASDU([]byte{CScNa1, 1, CauseActivationCon | causeNegative, 0, 1, 0, 0xB9, 0x0B, 0x00, 0x01}); err != nil ||
+		!a.negative || a.cause != CauseActivationCon || a.objects[0].ioa != 3001 {
+		t.Fatalf("否定确认 %+v %v", a, err)
+	}
+	if _, err = parseASDU([]byte{MMeNc1, 1, CauseSpontaneous, 0, 1, 0, 0x01, 0x00}); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("长度不足应返回错误 %v", err)
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	tests := []struct {
+		typeID    byte
+		value     interface{}
+		selected  bool
+		qualifier byte
+		want      []byte
+	}{
+		{CScNa1, true, true, 0, []byte{0x81}},
+		{CScNa1, "false", false, 1, []byte{0x04}},
+		{CDcNa1, 2, false, 0, []byte{0x02}},
+		{CSeNa1, 0.5, false, 0, []byte{0x00, 0x40, 0x00}},
+		{CSeNb1, -2, true, 0, []byte{0xFE, 0xFF, 0x80}},
+		{CSeNc1, 1.5, false, 0, []byte{0x00, 0x00, 0xC0, 0x3F, 0x00}},
+		{CIcNa1, nil, false, qualifierStation, []byte{0x14}},
+	}
+	for _, tt := range tests {
+		element, err := encodeCommand(tt.typeID, tt.value, tt.selected, tt.qualifier)
+		if err != nil || !bytes.Equal(element, tt.want) {
+			t.Errorf("类型 %d 值 %v 的信息元素 % X %v, 期望 % X", tt.typeID, tt.value, element, err, tt.want)
+		}
+	}
+	for _, invalid := range []struct {
+		typeID byte
+		value  interface{}
+	}{{CDcNa1, 3}, {CSeNa1, 1.0}, {CSeNb1, 40000}, {MSpNa1, 1}} {
+		if _, err := encodeCommand(invalid.typeID, invalid.value, false, 0); err == nil {
+			t.Errorf("类型 %d 值 %v 应返回错误", invalid.typeID, invalid.value)
+		}
+	}
+}
@@ -0,0 +1,567 @@
+// Package iec104 提供 IEC 60870-5-104 主站协议处理器，按点表将信息对象映射为物模型属性，遥控、遥调命令作为服务调用
+package iec104
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// 召唤命令，可以直接作为下发数据或轮询命令
+const (
+	Interrogation        = "interrogation"        // 总召唤，响应为召唤到的全部属性
+	CounterInterrogation = "counterInterrogation" // 电能脉冲召唤，响应为召唤到的全部累计量
+)
+
+const (
+	defaultK  = 12
+	defaultW  = 8
+	defaultT1 = 15 * time.Second
+	defaultT2 = 10 * time.Second
+	defaultT3 = 20 * time.Second
+
+	qualifierStation = 20 // 召唤限定词,站召唤
+	qualifierCounter = 5  // 计数量召唤命令限定词,总的请求计数量
+)
+
+// 命令类型名称与类型标识
+var commandTypes = map[string]byte{
+	"single":     CScNa1,
+	"double":     CDcNa1,
+	"normalized": CSeNa1,
+	"scaled":     CSeNb1,
+	"float":      CSeNc1,
+}
+
+var (
+	// ErrNotStarted 数据传输未启动(未收到 STARTDT 确认)
+	ErrNotStarted = errors.New("IEC 104 数据传输未启动")
+	// ErrWindowFull 未被确认的 I 格式帧达到 k
+	ErrWindowFull = errors.New("IEC 104 发送窗口已满")
+	// ErrNoSession 连接尚未建立会话或已断开，errors.Is(err, ErrNotStarted) 同样成立
+	ErrNoSession = fmt.Errorf("%w: 会话不存在", ErrNotStarted)
+)
+
+// Config IEC 104 协议处理器配置
+type Config struct {
+	CommonAddress uint16            `json:"commonAddress"` // 公共地址,默认 1
+	Stations      map[string]uint16 `json:"stations"`      // 设备标识 -> 公共地址
+	Points        []Point           `json:"points"`        // 点表
+	Commands      []Command         `json:"commands"`      // 遥控、遥调命令
+	K             int               `json:"k"`             // 未被确认的 I 格式帧最大数目,默认 12
+	W             int               `json:"w"`             // 最迟在接收 w 个 I 格式帧后确认,默认 8
+	T1            time.Duration     `json:"t1"`            // 发送或测试 APDU 的超时,默认 15 秒
+	T2            time.Duration     `json:"t2"`            // 无数据报文时确认的超时,默认 10 秒
+	T3            time.Duration     `json:"t3"`            // 长期空闲状态下发送测试帧的超时,默认 20 秒
+}
+
+// Point 点表中的一项，将信息对象地址映射为属性
+type Point struct {
+	Name string `json:"name"` // 物模型属性标识
+	IOA  uint32 `json:"ioa"`  // 信息对象地址
+}
+
+// Command 遥控、遥调命令，以服务调用的形式提供给平台
+type Command struct {
+	Name      string `json:"name"`      // 服务标识
+	IOA       uint32 `json:"ioa"`       // 信息对象地址
+	Type      string `json:"type"`      // 命令类型,single(单命令)/double(双命令)/normalized/scaled/float(设定值命令)
+	Select    bool   `json:"select"`    // 先选择后执行
+	Qualifier byte   `json:"qualifier"` // 命令限定词,单/双命令为 QU,设定值命令为 QL
+}
+
+// Request 表示一个控制方向的命令
+type Request struct {
+	Type      byte        `json:"type"`      // 类型标识
+	IOA       uint32      `json:"ioa"`       // 信息对象地址,召唤命令为 0
+	Value     interface{} `json:"value"`     // 命令的值
+	Select    bool        `json:"select"`    // 选择命令(S/E=1)
+	Qualifier byte        `json:"qualifier"` // 命令限定词或召唤限定词
+}
+
+// NegativeError 子站对命令的否定确认
+type NegativeError struct {
+	Type  byte   // 类型标识
+	IOA   uint32 // 信息对象地址
+	Cause byte   // 传送原因
+}
+
+// Error 实现 error 接口
+func (e *NegativeError) Error() string {
+	return fmt.Sprintf("IEC 104 命令被否定: 类型标识 %d, 信息对象地址 %d, 传送原因 %d", e.Type, e.IOA, e.Cause)
+}
+
+// Handler IEC 104 主站协议处理器，网关作为主站通过 TCP 客户端连接子站(RTU)
+// 下发数据可以是 Request、Interrogation、CounterInterrogation 或可转换为 Request 的键值数据
+// 突发、周期上送的数据直接作为属性上报，召唤命令的响应为召唤期间收到的全部属性
+type Handler struct {
+	config   Config
+	points   map[uint32]string
+	commands map[string]Command
+
+	mu       sync.Mutex
+	sessions map[*model.Device]*session
+}
+
+// session 一个连接的会话状态
+type session struct {
+	write sync.Mutex // 串行发送 I 格式帧，发送序号按写入连接的顺序分配
+
+	started      bool      // 已收到 STARTDT 确认
+	sendSeq      uint16    // 下一个发送序号 N(S)
+	recvSeq      uint16    // 下一个期望的接收序号 N(R)
+	ackSeq       uint16    // 对端已确认到的发送序号
+	unacked      int       // 已接收、未确认的 I 格式帧数
+	unackedSince time.Time // 最早一个未确认的接收帧的时间(t2)
+	sentSince    time.Time // 最早一个未被对端确认的发送帧的时间(t1)
+	startSince   time.Time // STARTDT 的发送时间,收到确认后清零(t1)
+	testSince    time.Time // TESTFR 的发送时间,收到确认后清零(t1)
+	lastRecv     time.Time // 最后收到报文的时间(t3)
+
+	interrogation map[string]interface{} // 召唤期间收集的属性,nil 表示没有进行中的召唤
+	done          chan struct{}
+}
+
+// New 创建 IEC 104 协议处理器
+func New(config Config) (*Handler, error) {
+	if config.CommonAddress == 0 {
+		config.CommonAddress = 1
+	}
+	if config.K <= 0 {
+		config.K = defaultK
+	}
+	if config.W <= 0 {
+		config.W = defaultW
+	}
+	if config.T1 <= 0 {
+		config.T1 = defaultT1
+	}
+	if config.T2 <= 0 {
+		config.T2 = defaultT2
+	}
+	if config.T3 <= 0 {
+		config.T3 = defaultT3
+	}
+	if config.W > config.K {
+		return nil, fmt.Errorf("w(%d) 不能大于 k(%d)", config.W, config.K)
+	}
+
+	h := &Handler{
+		config:   config,
+		points:   make(map[uint32]string),
+		commands: make(map[string]Command),
+		sessions: make(map[*model.Device]*session),
+	}
+	for _, point := range config.Points {
+		if point.Name == "" {
+			return nil, fmt.Errorf("信息对象地址 %d 缺少属性标识", point.IOA)
+		}
+		if _, ok := h.points[point.IOA]; ok {
+			return nil, fmt.Errorf("信息对象地址 %d 重复", point.IOA)
+		}
+		h.points[point.IOA] = point.Name
+	}
+	for _, command := range config.Commands {
+		if _, ok := commandTypes[command.Type]; !ok {
+			return nil, fmt.Errorf("命令 %s 的类型 %s 不支持", command.Name, command.Type)
+		}
+		if _, ok := h.commands[command.Name]; ok || command.Name == "" {
+			return nil, fmt.Errorf("命令标识 %s 为空或重复", command.Name)
+		}
+		h.commands[command.Name] = command
+	}
+	return h, nil
+}
+
+// Init 实现 network.ProtocolHandler 接口，设备标识由 TCP 客户端的远端配置绑定
+func (h *Handler) Init(device *model.Device, data []byte) error {
+	return nil
+}
+
+// OnConnect 实现 network.SessionHandler 接口，发送 STARTDT 启动数据传输并开始维护 t1/t2/t3 定时器
+func (h *Handler) OnConnect(device *model.Device) error {
+	s := &session{startSince: time.Now(), lastRecv: time.Now(), done: make(chan struct{})}
+	h.mu.Lock()
+	h.sessions[device] = s
+	h.mu.Unlock()
+	if _, err := device.Conn.Write(uFrame(uStartDTAct)); err != nil {
+		h.OnDisconnect(device) // 建立会话失败时不会再调用 OnDisconnect
+		return err
+	}
+	go h.keepAlive(device, s)
+	return nil
+}
+
+// OnDisconnect 实现 network.SessionHandler 接口
+func (h *Handler) OnDisconnect(device *model.Device) {
+	h.mu.Lock()
+	if s, ok := h.sessions[device]; ok {
+		close(s.done)
+		delete(h.sessions, device)
+	}
+	h.mu.Unlock()
+}
+
+// keepAlive 按 t1/t2/t3 发送确认和测试帧，超时未收到确认时断开连接，会话结束或连接关闭后退出
+func (h *Handler) keepAlive(device *model.Device, s *session) {
+	interval := min(h.config.T1, h.config.T2, h.config.T3) / 4
+	ticker := time.NewTicker(max(interval, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			var frame []byte
+			h.mu.Lock()
+			expired := !s.startSince.IsZero() && now.Sub(s.startSince) > h.config.T1 ||
+				!s.testSince.IsZero() && now.Sub(s.testSince) > h.config.T1 ||
+				!s.sentSince.IsZero() && now.Sub(s.sentSince) > h.config.T1
+			switch {
+			case expired:
+			case s.unacked > 0 && now.Sub(s.unackedSince) >= h.config.T2:
+				frame, s.unacked = sFrame(s.recvSeq), 0
+			case s.testSince.IsZero() && now.Sub(s.lastRecv) >= h.config.T3:
+				frame, s.testSince = uFrame(uTestFRAct), now
+			}
+			h.mu.Unlock()
+
+			if expired {
+				glog.Debugf(context.Background(), "IEC 104 设备 %s 超过 t1 未收到确认，断开连接", device.DeviceKey)
+				device.Conn.Close()
+				return
+			}
+			if frame != nil {
+				if _, err := device.Conn.Write(frame); err != nil {
+					glog.Debugf(context.Background(), "IEC 104 设备 %s 发送失败，停止维护连接: %v", device.DeviceKey, err)
+					return // 连接已关闭或不可用，读取协程随后结束会话
+				}
+			}
+		}
+	}
+}
+
+// Encode 实现 network.ProtocolHandler 接口，[]byte 为 Decode 返回的确认帧，原样发送
+// 命令的 I 格式帧在会话内串行地分配发送序号并直接写入连接，写入成功后才推进 N(S)，返回的数据为空
+func (h *Handler) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	if frame, ok := data.([]byte); ok {
+		return frame, nil
+	}
+	request, err := h.request(data)
+	if err != nil {
+		return nil, err
+	}
+	element, err := encodeCommand(request.Type, request.Value, request.Select, request.Qualifier)
+	if err != nil {
+		return nil, err
+	}
+	asdu := encodeASDU(request.Type, CauseActivation, h.address(device), request.IOA, element)
+
+	h.mu.Lock()
+	s, err := h.session(device)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.write.Lock()
+	defer s.write.Unlock()
+
+	h.mu.Lock()
+	if !s.started {
+		h.mu.Unlock()
+		return nil, ErrNotStarted
+	}
+	if seqDistance(s.ackSeq, s.sendSeq) >= uint16(h.config.K) {
+		h.mu.Unlock()
+		return nil, ErrWindowFull
+	}
+	sendSeq := s.sendSeq
+	frame := iFrame(sendSeq, s.recvSeq, asdu)
+	h.mu.Unlock()
+
+	if _, err := device.Conn.Write(frame); err != nil {
+		return nil, fmt.Errorf("IEC 104 发送失败: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s.sendSeq == s.ackSeq {
+		s.sentSince = time.Now()
+	}
+	s.sendSeq = (sendSeq + 1) % seqModulo
+	s.unacked = 0 // I 格式帧同时确认了已接收的帧
+	return nil, nil
+}
+
+// Decode 实现 network.ProtocolHandler 接口
+// 处理 U/S 格式帧和序号，I 格式帧中的监视数据上报或交给进行中的召唤，命令的确认交给等待的请求
+func (h *Handler) Decode(device *model.Device, data []byte) ([]byte, error) {
+	frame, err := parseAPDU(data)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	h.mu.Lock()
+	s, err := h.session(device)
+	if err != nil {
+		h.mu.Unlock()
+		return nil, err
+	}
+	s.lastRecv = now
+	var reply []byte
+	switch frame.format {
+	case formatU:
+		switch frame.function {
+		case uStartDTCon:
+			s.started, s.startSince = true, time.Time{}
+		case uStopDTCon:
+			s.started = false
+		case uTestFRAct:
+			reply = uFrame(uTestFRCon)
+		case uTestFRCon:
+			s.testSince = time.Time{}
+		}
+	case formatS:
+		h.acknowledge(s, frame.recvSeq, now)
+	case formatI:
+		if frame.sendSeq != s.recvSeq {
+			h.mu.Unlock()
+			device.Conn.Close() // 序号错误，按规约关闭连接
+			return nil, fmt.Errorf("IEC 104 接收序号错误: 期望 %d, 实际 %d", s.recvSeq, frame.sendSeq)
+		}
+		s.recvSeq = (s.recvSeq + 1) % seqModulo
+		h.acknowledge(s, frame.recvSeq, now)
+		if s.unacked == 0 {
+			s.unackedSince = now
+		}
+		if s.unacked++; s.unacked >= h.config.W {
+			reply, s.unacked = sFrame(s.recvSeq), 0
+		}
+	}
+	h.mu.Unlock()
+
+	if frame.format == formatI {
+		a, err := parseASDU(frame.asdu)
+		if err != nil {
+			return reply, err
+		}
+		h.handleASDU(device, a)
+	}
+	return reply, nil
+}
+
+// FrameDecoder 实现 network.FrameDecoderProvider 接口
+func (h *Handler) FrameDecoder() network.FrameDecoder {
+	return frameDecoder{}
+}
+
+// RequestKey 实现 network.RequestKeyProvider 接口，按类型标识和信息对象地址关联确认报文
+func (h *Handler) RequestKey(device *model.Device, data interface{}, param ...string) string {
+	request, err := h.request(data)
+	if err != nil {
+		return ""
+	}
+	return requestKey(request.Type, request.IOA)
+}
+
+// Services 实现 network.ServiceCaller 接口，返回全部命令的服务标识
+func (h *Handler) Services() []string {
+	services := make([]string, 0, len(h.commands))
+	for _, command := range h.config.Commands {
+		services = append(services, command.Name)
+	}
+	return services
+}
+
+// CallService 实现 network.ServiceCaller 接口，服务的输入参数 value 为命令的值，返回 value
+// 配置了先选择后执行的命令依次下发选择和执行命令
+func (h *Handler) CallService(ctx context.Context, requester network.Requester, device *model.Device, service string, params map[string]interface{}) (map[string]interface{}, error) {
+	command, ok := h.commands[service]
+	if !ok {
+		return nil, fmt.Errorf("IEC 104 命令 %s 不存在", service)
+	}
+	value := params["value"]
+	operate := Request{Type: commandTypes[command.Type], IOA: command.IOA, Value: value, Qualifier: command.Qualifier}
+	if command.Select {
+		selected := operate
+		selected.Select = true
+		if _, err := requester.Request(ctx, device.DeviceKey, selected); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := requester.Request(ctx, device.DeviceKey, operate); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"value": value}, nil
+}
+
+// handleASDU 处理 I 格式帧中的 ASDU
+func (h *Handler) handleASDU(device *model.Device, a asdu) {
+	if _, monitor := elementLength(a.typeID); monitor {
+		properties := h.properties(a)
+		if len(properties) == 0 {
+			return
+		}
+		h.mu.Lock()
+		s, err := h.session(device)
+		collecting := err == nil && s.interrogation != nil && a.cause >= CauseInterrogated && a.cause <= causeLastGroup
+		if collecting {
+			for name, value := range properties {
+				s.interrogation[name] = value
+			}
+		}
+		h.mu.Unlock()
+		if !collecting {
+			h.report(device.DeviceKey, properties)
+		}
+		return
+	}
+
+	ioa := a.objects[0].ioa
+	key := requestKey(a.typeID, ioa)
+	if a.negative || a.cause >= CauseUnknownType && a.cause <= CauseUnknownIOA {
+		network.Respond(device.DeviceKey, key, &NegativeError{Type: a.typeID, IOA: ioa, Cause: a.cause})
+		return
+	}
+	interrogation := a.typeID == CIcNa1 || a.typeID == CCiNa1
+	switch {
+	case a.cause == CauseActivationCon && interrogation:
+		h.mu.Lock()
+		if s, err := h.session(device); err == nil {
+			s.interrogation = make(map[string]interface{})
+		}
+		h.mu.Unlock()
+	case a.cause == CauseActivationCon:
+		network.Respond(device.DeviceKey, key, nil)
+	case a.cause == CauseActivationTerm && interrogation:
+		var properties map[string]interface{}
+		h.mu.Lock()
+		if s, err := h.session(device); err == nil {
+			properties, s.interrogation = s.interrogation, nil
+		}
+		h.mu.Unlock()
+		if properties != nil {
+			network.Respond(device.DeviceKey, key, properties)
+		}
+	}
+}
+
+// properties 按点表将信息对象转换为属性，品质无效和不在点表中的信息对象被忽略，带时标的值使用子站的时标
+func (h *Handler) properties(a asdu) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, o := range a.objects {
+		name, ok := h.points[o.ioa]
+		if !ok || o.invalid {
+			continue
+		}
+		if o.time.IsZero() {
+			properties[name] = o.value
+		} else {
+			properties[name] = mqttProtocol.PropertyNode{Value: o.value, CreateTime: o.time.Unix()}
+		}
+	}
+	return properties
+}
+
+// report 上报突发、周期上送的属性
+func (h *Handler) report(deviceKey string, properties map[string]interface{}) {
+	if deviceKey == "" {
+		return
+	}
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         deviceKey,
+		"PropertieDataList": properties,
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报 IEC 104 设备 %s 数据失败: %v", deviceKey, err)
+	}
+}
+
+// acknowledge 处理对端确认的接收序号，调用方持有 h.mu
+func (h *Handler) acknowledge(s *session, recvSeq uint16, now time.Time) {
+	if seqDistance(recvSeq, s.sendSeq) > seqDistance(s.ackSeq, s.sendSeq) {
+		glog.Debugf(context.Background(), "IEC 104 确认序号 %d 超出已发送范围", recvSeq)
+		return
+	}
+	if recvSeq == s.ackSeq {
+		return
+	}
+	// 有新的帧被确认，剩余未确认的帧重新开始计时
+	s.ackSeq = recvSeq
+	if s.ackSeq == s.sendSeq {
+		s.sentSince = time.Time{}
+	} else {
+		s.sentSince = now
+	}
+}
+
+// session 获取设备的会话，会话只在 OnConnect 中创建，不存在时返回 ErrNoSession，调用方持有 h.mu
+func (h *Handler) session(device *model.Device) (*session, error) {
+	s, ok := h.sessions[device]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return s, nil
+}
+
+// request 将下发数据转换为命令
+func (h *Handler) request(data interface{}) (Request, error) {
+	switch v := data.(type) {
+	case Request:
+		return h.withDefaults(v), nil
+	case *Request:
+		return h.withDefaults(*v), nil
+	case string:
+		switch v {
+		case Interrogation:
+			return h.withDefaults(Request{Type: CIcNa1}), nil
+		case CounterInterrogation:
+			return h.withDefaults(Request{Type: CCiNa1}), nil
+		}
+		return Request{}, fmt.Errorf("不支持的 IEC 104 命令 %s", v)
+	}
+	var request Request
+	if err := gconv.Struct(data, &request); err != nil {
+		return Request{}, fmt.Errorf("IEC 104 命令格式错误: %v", err)
+	}
+	return h.withDefaults(request), nil
+}
+
+// withDefaults 设置召唤命令默认的限定词
+func (h *Handler) withDefaults(request Request) Request {
+	if request.Qualifier == 0 {
+		switch request.Type {
+		case CIcNa1:
+			request.Qualifier = qualifierStation
+		case CCiNa1:
+			request.Qualifier = qualifierCounter
+		}
+	}
+	return request
+}
+
+// address 获取设备的公共地址
+func (h *Handler) address(device *model.Device) uint16 {
+	if device != nil {
+		if address, ok := h.config.Stations[device.DeviceKey]; ok {
+			return address
+		}
+	}
+	return h.config.CommonAddress
+}
+
+// requestKey 命令的关联键
+func requestKey(typeID byte, ioa uint32) string {
+	return fmt.Sprintf("%d:%d", typeID, ioa)
+}
@@ -0,0 +1,305 @@
+package iec104
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/conf"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// requesterFunc 将请求函数适配为 network.Requester
+type requesterFunc func(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error)
+
+func (f requesterFunc) Request(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error) {
+	return f(ctx, deviceKey, data, params...)
+}
+
+// outstation 模拟的子站
+type outstation struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	sendSeq uint16
+	recvSeq uint16
+	acked   uint16 // 主站确认到的序号
+	tests   int    // 收到的 TESTFR 激活帧数
+	selects int    // 收到的选择命令数
+}
+
+// send 发送 I 格式帧
+func (o *outstation) send(asdu []byte) {
+	o.mu.Lock()
+	frame := iFrame(o.sendSeq, o.recvSeq, asdu)
+	o.sendSeq++
+	o.mu.Unlock()
+	o.conn.Write(frame)
+}
+
+// serve 读取主站的报文并应答
+func (o *outstation) serve() {
+	var buf []byte
+	chunk := make([]byte, 256)
+	for {
+		n, err := o.conn.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			data, consumed, _ := frameDecoder{}.Decode(buf)
+			if consumed == 0 {
+				break
+			}
+			buf = buf[consumed:]
+			frame, err := parseAPDU(data)
+			if err != nil {
+				continue
+			}
+			switch frame.format {
+			case formatU:
+				if frame.function == uStartDTAct {
+					o.conn.Write(uFrame(uStartDTCon))
+				} else if frame.function == uTestFRAct {
+					o.mu.Lock()
+					o.tests++
+					o.mu.Unlock()
+					o.conn.Write(uFrame(uTestFRCon))
+				}
+			case formatS:
+				o.mu.Lock()
+				o.acked = frame.recvSeq
+				o.mu.Unlock()
+			case formatI:
+				o.mu.Lock()
+				o.recvSeq++
+				o.acked = frame.recvSeq
+				o.mu.Unlock()
+				o.command(frame.asdu)
+			}
+		}
+	}
+}
+
+// command 处理主站的命令
+func (o *outstation) command(data []byte) {
+	typeID, ioa, element := data[0], readIOA(data[6:]), data[9:]
+	confirm := func(cause byte) {
+		reply := append([]byte{}, data...)
+		reply[2] = cause
+		o.send(reply)
+	}
+	switch typeID {
+	case CIcNa1:
+		confirm(CauseActivationCon)
+		measured := []byte{MMeNc1, 2, CauseInterrogated, 0, 1, 0, 0xE9, 0x03, 0x00}
+		measured = binary.LittleEndian.AppendUint32(measured, math.Float32bits(220.5))
+		measured = append(measured, 0x00, 0xEA, 0x03, 0x00, 0, 0, 0, 0, qualityInvalid)
+		o.send(measured)
+		o.send([]byte{MSpNa1, 0x80 | 2, CauseInterrogated, 0, 1, 0, 0xD1, 0x07, 0x00, 0x01, 0x00})
+		confirm(CauseActivationTerm)
+	case CScNa1:
+		if element[0]&selectBit != 0 {
+			o.mu.Lock()
+			o.selects++
+			o.mu.Unlock()
+		}
+		if ioa == 3002 {
+			confirm(CauseActivationCon | causeNegative)
+			return
+		}
+		confirm(CauseActivationCon)
+		if element[0]&selectBit == 0 {
+			confirm(CauseActivationTerm)
+		}
+	}
+}
+
+func TestHandlerSession(t *testing.T) {
+	handler, err := New(Config{T1: time.Second, T2: time.Second, T3: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := func() int {
+		handler.mu.Lock()
+		defer handler.mu.Unlock()
+		return len(handler.sessions)
+	}
+
+	// 会话只在 OnConnect 中创建，未建立会话时编解码返回错误且不创建会话
+	local, remote := net.Pipe()
+	device := &model.Device{DeviceKey: "rtu-session", Conn: local}
+	if _, err := handler.Decode(device, uFrame(uTestFRAct)); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("未建立会话时解码应返回错误，实际 %v", err)
+	}
+	if _, err := handler.Encode(device, Interrogation); !errors.Is(err, ErrNotStarted) {
+		t.Fatalf("未建立会话时下发应返回 ErrNotStarted，实际 %v", err)
+	}
+	if n := sessions(); n != 0 {
+		t.Fatalf("编解码不应创建会话，实际 %d 个", n)
+	}
+
+	go io.Copy(io.Discard, remote)
+	if err := handler.OnConnect(device); err != nil || sessions() != 1 {
+		t.Fatalf("建立会话失败: %v", err)
+	}
+	handler.OnDisconnect(device)
+	if n := sessions(); n != 0 {
+		t.Fatalf("断开后会话应被移除，实际 %d 个", n)
+	}
+
+	// 建立会话时发送失败，会话同样被移除
+	remote.Close()
+	if err := handler.OnConnect(device); err == nil || sessions() != 0 {
+		t.Fatalf("发送 STARTDT 失败时不应保留会话: %v", err)
+	}
+}
+
+func TestHandlerOverTCPClient(t *testing.T) {
+	handler, err := New(Config{
+		W:  2,
+		T3: 200 * time.Millisecond,
+		Points: []Point{
+			{Name: "voltage", IOA: 1001},
+			{Name: "current", IOA: 1002},
+			{Name: "breaker", IOA: 2001},
+			{Name: "alarm", IOA: 2002},
+			{Name: "frequency", IOA: 1003},
+		},
+		Commands: []Command{
+			{Name: "closeBreaker", IOA: 3001, Type: "single", Select: true},
+			{Name: "lockout", IOA: 3002, Type: "single"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client := network.NewTCPClient(
+		network.WithProtocolHandler(handler),
+		network.WithTCPClientConfig(conf.TCPClientConfig{
+			Remotes: []conf.RemoteConfig{{Addr: listener.Addr().String(), DeviceKey: "rtu-001"}},
+		}),
+	)
+	reports := make(chan map[string]interface{}, 4)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		reports <- e.Data()
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Start(ctx, "")
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(3 * time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	station := &outstation{conn: conn}
+	go station.serve()
+
+	locator := client.(network.DeviceLocator)
+	request := func(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error) {
+		target := locator.LookupDevice(deviceKey)
+		if target == nil {
+			return nil, network.ErrDeviceNotFound
+		}
+		pending, err := network.BeginRequest(ctx, deviceKey, handler, target, data, params...)
+		if err != nil {
+			return nil, err
+		}
+		defer pending.Done()
+		if err := client.SendData(target, data, params...); err != nil {
+			return nil, err
+		}
+		return pending.Wait(ctx)
+	}
+
+	// 总召唤的响应为召唤期间收到的全部有效属性
+	timeout, cancelRequest := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelRequest()
+	var response interface{}
+	for {
+		if response, err = request(timeout, "rtu-001", Interrogation); !errors.Is(err, ErrNotStarted) && !errors.Is(err, network.ErrDeviceNotFound) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	want := map[string]interface{}{"voltage": float32(220.5), "breaker": true, "alarm": false}
+	if err != nil || !reflect.DeepEqual(response, want) {
+		t.Fatalf("总召唤 %v %v", response, err)
+	}
+	// 收到 w 个 I 格式帧后主站应发送确认
+	for i := 0; i < 100; i++ {
+		station.mu.Lock()
+		acked := station.acked
+		station.mu.Unlock()
+		if acked >= 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 突发上送的带时标数据直接上报
+	at := time.Date(2024, 3, 5, 8, 30, 15, 0, time.Local)
+	spontaneous := []byte{MMeTf1, 1, CauseSpontaneous, 0, 1, 0, 0xEB, 0x03, 0x00}
+	spontaneous = binary.LittleEndian.AppendUint32(spontaneous, math.Float32bits(50))
+	station.send(append(append(spontaneous, 0x00), encodeCP56Time2a(at)...))
+	select {
+	case report := <-reports:
+		properties := report["PropertieDataList"].(map[string]interface{})
+		if report["DeviceKey"] != "rtu-001" || properties["frequency"] != (mqttProtocol.PropertyNode{Value: float32(50), CreateTime: at.Unix()}) {
+			t.Fatalf("突发上报 %v", report)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("未上报突发数据")
+	}
+
+	// 先选择后执行的遥控命令
+	reply, err := handler.CallService(timeout, requesterFunc(request), &model.Device{DeviceKey: "rtu-001"}, "closeBreaker", map[string]interface{}{"value": true})
+	if err != nil || !reflect.DeepEqual(reply, map[string]interface{}{"value": true}) {
+		t.Fatalf("遥控回复 %v %v", reply, err)
+	}
+	station.mu.Lock()
+	selects := station.selects
+	station.mu.Unlock()
+	if selects != 1 {
+		t.Fatalf("应先下发选择命令，实际 %d 次", selects)
+	}
+
+	// 否定确认作为错误返回
+	var negative *NegativeError
+	if _, err := request(timeout, "rtu-001", Request{Type: CScNa1, IOA: 3002, Value: true}); !errors.As(err, &negative) || negative.IOA != 3002 {
+		t.Fatalf("否定确认应作为错误返回，实际 %v", err)
+	}
+
+	// 空闲超过 t3 时发送测试帧
+	for i := 0; i < 100; i++ {
+		station.mu.Lock()
+		tests := station.tests
+		station.mu.Unlock()
+		if tests > 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("空闲时未发送测试帧")
+}
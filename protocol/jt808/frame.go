@@ -0,0 +1,247 @@
+package jt808
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	flagByte   = 0x7E
+	escapeByte = 0x7D
+
+	maxBodyLength  = 0x03FF // 消息体属性中消息体长度占 10 位
+	maxFrameLength = 4096   // 转义后的最大帧长度,超出时丢弃
+
+	attrSubpackage = 1 << 13 // 分包标志
+	attrVersion    = 1 << 14 // 版本标识,2019 版为 1
+	attrEncryption = 0x07 << 10
+
+	phoneLength2013 = 6  // 2013 版终端手机号 BCD[6]
+	phoneLength2019 = 10 // 2019 版终端手机号 BCD[10]
+)
+
+var (
+	// ErrInvalidFrame 消息格式错误
+	ErrInvalidFrame = errors.New("JT/T 808 消息格式错误")
+	// ErrChecksum 消息校验码错误
+	ErrChecksum = errors.New("JT/T 808 消息校验码错误")
+)
+
+// Header 消息头
+type Header struct {
+	ID         uint16 // 消息 ID
+	Version    byte   // 协议版本号,仅 2019 版
+	Is2019     bool   // 是否为 2019 版消息头
+	Encryption byte   // 数据加密方式,0 为不加密
+	Phone      string // 终端手机号
+	Serial     uint16 // 消息流水号
+	Total      uint16 // 消息总包数,分包时使用
+	Index      uint16 // 包序号,从 1 开始,分包时使用
+}
+
+// Message 一条 JT/T 808 消息
+type Message struct {
+	Header
+	Body []byte
+}
+
+// subpackaged 是否为分包消息
+func (m Message) subpackaged() bool {
+	return m.Total > 1
+}
+
+// Bytes 生成转义后的消息，分包消息的总包数大于 1 时写入封装项
+func (m Message) Bytes() ([]byte, error) {
+	if len(m.Body) > maxBodyLength {
+		return nil, fmt.Errorf("JT/T 808 消息体长度 %d 超出范围", len(m.Body))
+	}
+	attr := uint16(len(m.Body)) | uint16(m.Encryption)<<10&attrEncryption
+	if m.subpackaged() {
+		attr |= attrSubpackage
+	}
+	phoneLength := phoneLength2013
+	if m.Is2019 {
+		attr |= attrVersion
+		phoneLength = phoneLength2019
+	}
+	phone, err := encodeBCD(m.Phone, phoneLength)
+	if err != nil {
+		return nil, err
+	}
+
+	data := binary.BigEndian.AppendUint16(nil, m.ID)
+	data = binary.BigEndian.AppendUint16(data, attr)
+	if m.Is2019 {
+		data = append(data, m.Version)
+	}
+	data = append(data, phone...)
+	data = binary.BigEndian.AppendUint16(data, m.Serial)
+	if m.subpackaged() {
+		data = binary.BigEndian.AppendUint16(data, m.Total)
+		data = binary.BigEndian.AppendUint16(data, m.Index)
+	}
+	data = append(data, m.Body...)
+	data = append(data, checksum(data))
+	return escape(data), nil
+}
+
+// ParseMessage 解析一条完整的消息(含首尾标识位)，反转义后校验校验码
+func ParseMessage(frame []byte) (Message, error) {
+	if len(frame) < 2 || frame[0] != flagByte || frame[len(frame)-1] != flagByte {
+		return Message{}, ErrInvalidFrame
+	}
+	data, err := unescape(frame[1 : len(frame)-1])
+	if err != nil {
+		return Message{}, err
+	}
+	if len(data) < 4 {
+		return Message{}, ErrInvalidFrame
+	}
+	if checksum(data[:len(data)-1]) != data[len(data)-1] {
+		return Message{}, ErrChecksum
+	}
+	data = data[:len(data)-1]
+
+	attr := binary.BigEndian.Uint16(data[2:])
+	m := Message{Header: Header{
+		ID:         binary.BigEndian.Uint16(data),
+		Is2019:     attr&attrVersion != 0,
+		Encryption: byte(attr & attrEncryption >> 10),
+	}}
+	data = data[4:]
+	phoneLength := phoneLength2013
+	if m.Is2019 {
+		if len(data) < 1 {
+			return Message{}, ErrInvalidFrame
+		}
+		m.Version, data = data[0], data[1:]
+		phoneLength = phoneLength2019
+	}
+	if len(data) < phoneLength+2 {
+		return Message{}, ErrInvalidFrame
+	}
+	m.Phone = decodeBCD(data[:phoneLength])
+	m.Serial = binary.BigEndian.Uint16(data[phoneLength:])
+	data = data[phoneLength+2:]
+	if attr&attrSubpackage != 0 {
+		if len(data) < 4 {
+			return Message{}, ErrInvalidFrame
+		}
+		m.Total, m.Index = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		data = data[4:]
+		if m.Index == 0 || m.Index > m.Total {
+			return Message{}, ErrInvalidFrame
+		}
+	}
+	if len(data) != int(attr&maxBodyLength) {
+		return Message{}, ErrInvalidFrame
+	}
+	m.Body = data
+	return m, nil
+}
+
+// checksum 从消息头开始，与后一字节异或直到校验码前一个字节
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+	return sum
+}
+
+// escape 转义并加上首尾标识位：0x7E 转义为 0x7D 0x02，0x7D 转义为 0x7D 0x01
+func escape(data []byte) []byte {
+	escaped := make([]byte, 0, len(data)+4)
+	escaped = append(escaped, flagByte)
+	for _, b := range data {
+		switch b {
+		case flagByte:
+			escaped = append(escaped, escapeByte, 0x02)
+		case escapeByte:
+			escaped = append(escaped, escapeByte, 0x01)
+		default:
+			escaped = append(escaped, b)
+		}
+	}
+	return append(escaped, flagByte)
+}
+
+// unescape 还原转义的数据
+func unescape(data []byte) ([]byte, error) {
+	raw := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != escapeByte {
+			raw = append(raw, data[i])
+			continue
+		}
+		if i+1 >= len(data) || (data[i+1] != 0x01 && data[i+1] != 0x02) {
+			return nil, ErrInvalidFrame
+		}
+		raw = append(raw, escapeByte+data[i+1]-1) // 0x01 -> 0x7D,0x02 -> 0x7E
+		i++
+	}
+	return raw, nil
+}
+
+// decodeBCD 将 BCD 码转换为数字字符串
+func decodeBCD(data []byte) string {
+	var builder strings.Builder
+	for _, b := range data {
+		builder.WriteByte('0' + b>>4)
+		builder.WriteByte('0' + b&0x0F)
+	}
+	return builder.String()
+}
+
+// encodeBCD 将数字字符串转换为 length 字节的 BCD 码，不足时左侧补 0
+func encodeBCD(digits string, length int) ([]byte, error) {
+	if len(digits) > length*2 {
+		return nil, fmt.Errorf("终端手机号 %s 超出 %d 位", digits, length*2)
+	}
+	digits = strings.Repeat("0", length*2-len(digits)) + digits
+	data := make([]byte, length)
+	for i := range data {
+		high, low := digits[2*i]-'0', digits[2*i+1]-'0'
+		if high > 9 || low > 9 {
+			return nil, fmt.Errorf("终端手机号 %s 不是数字", digits)
+		}
+		data[i] = high<<4 | low
+	}
+	return data, nil
+}
+
+// frameDecoder JT/T 808 帧解码器，按标识位 0x7E 切分，丢弃标识位之前的数据
+type frameDecoder struct{}
+
+// Decode 实现 network.FrameDecoder 接口
+func (frameDecoder) Decode(buf []byte) ([]byte, int, error) {
+	start := -1
+	for i, b := range buf {
+		if b == flagByte {
+			start = i
+			break
+		}
+	}
+	switch {
+	case start < 0:
+		return nil, len(buf), nil
+	case start > 0:
+		return nil, start, nil
+	}
+	for i := 1; i < len(buf); i++ {
+		if buf[i] != flagByte {
+			continue
+		}
+		if i == 1 {
+			// 连续的标识位，前一个是上一帧的结束标识，从后一个重新开始
+			return nil, 1, nil
+		}
+		return buf[:i+1], i + 1, nil
+	}
+	if len(buf) > maxFrameLength {
+		return nil, 1, nil
+	}
+	return nil, 0, nil
+}
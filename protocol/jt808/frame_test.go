@@ -0,0 +1,80 @@
+package jt808
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	// 终端心跳，消息体包含需要转义的 0x7E、0x7D
+	m := Message{Header: Header{ID: MsgHeartbeat, Phone: "013912345678", Serial: 0x7E7D}, Body: []byte{0x7E, 0x7D, 0x01}}
+	frame, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(frame, []byte{flagByte}) != 2 {
+		t.Fatalf("转义后的消息中间不应有标识位 % X", frame)
+	}
+	parsed, err := ParseMessage(frame)
+	if err != nil || !reflect.DeepEqual(parsed, m) {
+		t.Fatalf("解析消息 %+v %v", parsed, err)
+	}
+
+	// 2019 版消息头和分包
+	m = Message{Header: Header{ID: MsgBatchLocation, Is2019: true, Version: 1, Phone: "00000000013912345678", Serial: 1, Total: 2, Index: 2}, Body: []byte{0x01}}
+	if frame, err = m.Bytes(); err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err = ParseMessage(frame); err != nil || !reflect.DeepEqual(parsed, m) {
+		t.Fatalf("解析 2019 版分包消息 %+v %v", parsed, err)
+	}
+
+	frame[len(frame)-2] ^= 0x01
+	if _, err := ParseMessage(frame); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("校验码错误 %v", err)
+	}
+	if _, err := ParseMessage([]byte{flagByte, 0x00, 0x7D, 0x03, flagByte}); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("错误的转义 %v", err)
+	}
+}
+
+func TestBCD(t *testing.T) {
+	data, err := encodeBCD("13912345678", phoneLength2013)
+	if err != nil || !bytes.Equal(data, []byte{0x01, 0x39, 0x12, 0x34, 0x56, 0x78}) {
+		t.Fatalf("编码终端手机号 % X %v", data, err)
+	}
+	if phone := decodeBCD(data); phone != "013912345678" {
+		t.Fatalf("解码终端手机号 %s", phone)
+	}
+	if _, err := encodeBCD("1391234567a", phoneLength2013); err == nil {
+		t.Fatal("非数字的终端手机号应返回错误")
+	}
+}
+
+func TestFrameDecoder(t *testing.T) {
+	heartbeat, _ := Message{Header: Header{ID: MsgHeartbeat, Phone: "013912345678"}}.Bytes()
+	stream := append([]byte{0x00, 0x01}, heartbeat...)
+	stream = append(stream, heartbeat...)
+	stream = append(stream, heartbeat[:5]...)
+
+	var decoder frameDecoder
+	if frame, consumed, _ := decoder.Decode(stream); frame != nil || consumed != 2 {
+		t.Fatalf("标识位之前的数据应丢弃 %d", consumed)
+	}
+	stream = stream[2:]
+	for i := 0; i < 2; i++ {
+		frame, consumed, err := decoder.Decode(stream)
+		if err != nil || !bytes.Equal(frame, heartbeat) {
+			t.Fatalf("切分结果 % X %v", frame, err)
+		}
+		stream = stream[consumed:]
+	}
+	if _, consumed, _ := decoder.Decode(stream); consumed != 0 {
+		t.Fatal("数据不足一帧时应等待")
+	}
+	if _, consumed, _ := decoder.Decode([]byte{flagByte, flagByte, 0x00}); consumed != 1 {
+		t.Fatal("连续的标识位应跳过前一个")
+	}
+}
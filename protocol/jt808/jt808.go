@@ -0,0 +1,460 @@
+// Package jt808 提供 JT/T 808 道路运输车辆卫星定位系统终端通讯协议处理器
+package jt808
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 消息 ID
+const (
+	MsgTerminalResponse       uint16 = 0x0001 // 终端通用应答
+	MsgHeartbeat              uint16 = 0x0002 // 终端心跳
+	MsgLogout                 uint16 = 0x0003 // 终端注销
+	MsgRegister               uint16 = 0x0100 // 终端注册
+	MsgAuth                   uint16 = 0x0102 // 终端鉴权
+	MsgParamsResponse         uint16 = 0x0104 // 查询终端参数应答
+	MsgLocation               uint16 = 0x0200 // 位置信息汇报
+	MsgLocationResponse       uint16 = 0x0201 // 位置信息查询应答
+	MsgVehicleControlResponse uint16 = 0x0500 // 车辆控制应答
+	MsgBatchLocation          uint16 = 0x0704 // 定位数据批量上传
+	MsgPlatformResponse       uint16 = 0x8001 // 平台通用应答
+	MsgRegisterResponse       uint16 = 0x8100 // 终端注册应答
+	MsgSetParams              uint16 = 0x8103 // 设置终端参数
+	MsgQueryParams            uint16 = 0x8104 // 查询终端参数
+	MsgTerminalControl        uint16 = 0x8105 // 终端控制
+	MsgQueryLocation          uint16 = 0x8201 // 位置信息查询
+	MsgTextMessage            uint16 = 0x8300 // 文本信息下发
+	MsgVehicleControl         uint16 = 0x8500 // 车辆控制
+)
+
+// 通用应答结果
+const (
+	ResultSuccess     byte = 0 // 成功/确认
+	ResultFailure     byte = 1 // 失败
+	ResultInvalid     byte = 2 // 消息有误
+	ResultUnsupported byte = 3 // 不支持
+)
+
+// maxSent 每个终端记录的下发消息流水号数量上限
+const maxSent = 64
+
+// Config JT/T 808 协议处理器配置
+type Config struct {
+	AuthCode    string `json:"authCode"`    // 注册应答下发的鉴权码,默认为终端手机号
+	CheckAuth   bool   `json:"checkAuth"`   // 是否校验终端鉴权,开启后未鉴权终端的位置信息不上报并应答失败
+	MaxInflight int    `json:"maxInflight"` // 同一终端同时等待应答的下发消息数,默认 1
+}
+
+// Command 下发给终端的消息
+type Command struct {
+	ID   uint16 `json:"id"`   // 消息 ID
+	Body []byte `json:"body"` // 消息体
+}
+
+// ResultError 终端通用应答的结果不是成功
+type ResultError struct {
+	ID     uint16 // 应答的消息 ID
+	Result byte   // 结果
+}
+
+// Error 实现 error 接口
+func (e *ResultError) Error() string {
+	names := map[byte]string{ResultFailure: "失败", ResultInvalid: "消息有误", ResultUnsupported: "不支持"}
+	return fmt.Sprintf("JT/T 808 终端应答消息 %04X %s(结果 %d)", e.ID, names[e.Result], e.Result)
+}
+
+// Handler JT/T 808 协议处理器
+// 终端手机号作为设备标识，位置信息按 Location.Properties 转换为属性上报，注册、鉴权、心跳等消息由处理器直接应答
+// 下发数据为 Command 或消息 ID，响应为终端通用应答(成功时为 nil)、位置信息查询应答的属性或查询终端参数应答的参数
+type Handler struct {
+	config Config
+
+	mu       sync.Mutex
+	sessions map[*model.Device]*session
+}
+
+// session 终端的会话状态
+type session struct {
+	header        Header                 // 终端最近一条消息的消息头,下发消息使用相同的版本和终端手机号
+	serial        uint16                 // 平台消息流水号
+	authenticated bool                   // 是否已鉴权
+	packages      map[uint16]*subpackage // 消息 ID -> 正在接收的分包消息
+	sent          map[uint16]uint16      // 下发消息的流水号 -> 消息 ID
+}
+
+// subpackage 正在接收的分包消息
+type subpackage struct {
+	total   uint16
+	packets map[uint16][]byte
+}
+
+// New 创建 JT/T 808 协议处理器
+func New(config Config) *Handler {
+	return &Handler{config: config, sessions: make(map[*model.Device]*session)}
+}
+
+// Init 实现 network.ProtocolHandler 接口，将消息头中的终端手机号作为设备标识
+func (h *Handler) Init(device *model.Device, data []byte) error {
+	if device == nil || device.DeviceKey != "" {
+		return nil
+	}
+	if m, err := ParseMessage(data); err == nil {
+		device.DeviceKey = m.Phone
+	}
+	return nil
+}
+
+// OnConnect 实现 network.SessionHandler 接口
+func (h *Handler) OnConnect(device *model.Device) error {
+	return nil
+}
+
+// OnDisconnect 实现 network.SessionHandler 接口，清理终端的会话状态
+func (h *Handler) OnDisconnect(device *model.Device) {
+	h.mu.Lock()
+	delete(h.sessions, device)
+	h.mu.Unlock()
+}
+
+// Encode 实现 network.ProtocolHandler 接口，生成下发消息；[]byte 为 Decode 返回的应答消息，原样发送
+func (h *Handler) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	if frame, ok := data.([]byte); ok {
+		return frame, nil
+	}
+	command, err := h.command(data)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.session(device)
+	m := h.message(device, s, command.ID, command.Body)
+	if len(s.sent) >= maxSent {
+		s.sent = make(map[uint16]uint16)
+	}
+	s.sent[m.Serial] = command.ID
+	return m.Bytes()
+}
+
+// Decode 实现 network.ProtocolHandler 接口
+// 分包消息收齐后再处理，每个分包都回复平台通用应答；注册回复注册应答，应答类消息交给等待的请求，其他消息回复平台通用应答
+func (h *Handler) Decode(device *model.Device, data []byte) ([]byte, error) {
+	if device == nil {
+		return nil, nil
+	}
+	m, err := ParseMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	s := h.session(device)
+	s.header = m.Header
+	body, complete := m.Body, true
+	if m.subpackaged() {
+		body, complete = s.assemble(m)
+	}
+	h.mu.Unlock()
+
+	var id uint16
+	var reply []byte
+	switch {
+	case m.Encryption != 0:
+		id, reply = MsgPlatformResponse, generalResponse(m.Header, ResultUnsupported)
+	case !complete:
+		id, reply = MsgPlatformResponse, generalResponse(m.Header, ResultSuccess)
+	default:
+		id, reply = h.handle(device, m.Header, body)
+	}
+	if id == 0 {
+		return nil, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.message(device, s, id, reply).Bytes()
+}
+
+// FrameDecoder 实现 network.FrameDecoderProvider 接口
+func (h *Handler) FrameDecoder() network.FrameDecoder {
+	return frameDecoder{}
+}
+
+// RequestKey 实现 network.RequestKeyProvider 接口，按下发的消息 ID 关联终端的应答
+func (h *Handler) RequestKey(device *model.Device, data interface{}, param ...string) string {
+	command, err := h.command(data)
+	if err != nil {
+		return ""
+	}
+	return requestKey(command.ID)
+}
+
+// MaxInflight 实现 network.RequestPipeliner 接口
+func (h *Handler) MaxInflight() int {
+	return max(h.config.MaxInflight, 1)
+}
+
+// handle 处理一条完整的消息，返回应答的消息 ID 和消息体，消息 ID 为 0 时不应答
+func (h *Handler) handle(device *model.Device, header Header, body []byte) (uint16, []byte) {
+	switch header.ID {
+	case MsgTerminalResponse:
+		if len(body) < 5 {
+			return 0, nil
+		}
+		id, result := binary.BigEndian.Uint16(body[2:]), body[4]
+		var response interface{}
+		if result != ResultSuccess {
+			response = &ResultError{ID: id, Result: result}
+		}
+		network.Respond(device.DeviceKey, requestKey(id), response)
+		return 0, nil
+	case MsgRegister:
+		// 注册应答：应答流水号 + 结果 + 鉴权码
+		reply := binary.BigEndian.AppendUint16(nil, header.Serial)
+		return MsgRegisterResponse, append(append(reply, ResultSuccess), h.authCode(header.Phone)...)
+	case MsgAuth:
+		code := body
+		if header.Is2019 && len(body) > 0 {
+			// 2019 版为鉴权码长度 + 鉴权码 + IMEI + 软件版本号
+			code = body[1:min(1+int(body[0]), len(body))]
+		}
+		result := ResultSuccess
+		if h.config.CheckAuth && string(code) != h.authCode(header.Phone) {
+			result = ResultFailure
+		}
+		h.mu.Lock()
+		h.session(device).authenticated = result == ResultSuccess
+		h.mu.Unlock()
+		return MsgPlatformResponse, generalResponse(header, result)
+	case MsgLogout:
+		h.mu.Lock()
+		h.session(device).authenticated = false
+		h.mu.Unlock()
+	case MsgLocation, MsgBatchLocation:
+		if !h.authenticated(device) {
+			return MsgPlatformResponse, generalResponse(header, ResultFailure)
+		}
+		locations, err := parseLocations(header.ID, body)
+		if err != nil {
+			glog.Debugf(context.Background(), "解析终端 %s 位置信息失败: %v", header.Phone, err)
+			return MsgPlatformResponse, generalResponse(header, ResultInvalid)
+		}
+		for _, location := range locations {
+			h.report(device.DeviceKey, location)
+		}
+	case MsgLocationResponse, MsgVehicleControlResponse:
+		if len(body) < 2 {
+			return 0, nil
+		}
+		var response interface{}
+		if location, err := ParseLocation(body[2:]); err != nil {
+			response = err
+		} else {
+			response = location.Properties()
+		}
+		network.Respond(device.DeviceKey, h.replyKey(device, binary.BigEndian.Uint16(body), header.ID), response)
+		return 0, nil
+	case MsgParamsResponse:
+		if len(body) < 2 {
+			return 0, nil
+		}
+		var response interface{}
+		if params, err := parseParams(body[2:]); err != nil {
+			response = err
+		} else {
+			response = params
+		}
+		network.Respond(device.DeviceKey, h.replyKey(device, binary.BigEndian.Uint16(body), header.ID), response)
+		return 0, nil
+	}
+	return MsgPlatformResponse, generalResponse(header, ResultSuccess)
+}
+
+// report 将位置信息作为属性上报，位置信息中的时间作为属性的时间
+func (h *Handler) report(deviceKey string, location Location) {
+	if deviceKey == "" {
+		return
+	}
+	if err, _ := event.Fire(consts.PushAttributeDataToMQTT, g.Map{
+		"DeviceKey":         deviceKey,
+		"PropertieDataList": location.propertyNodes(),
+	}); err != nil {
+		glog.Debugf(context.Background(), "上报终端 %s 位置信息失败: %v", deviceKey, err)
+	}
+}
+
+// authenticated 终端是否可以上报数据，未开启鉴权校验时总是可以
+func (h *Handler) authenticated(device *model.Device) bool {
+	if !h.config.CheckAuth {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.session(device).authenticated
+}
+
+// authCode 终端的鉴权码
+func (h *Handler) authCode(phone string) string {
+	if h.config.AuthCode != "" {
+		return h.config.AuthCode
+	}
+	return phone
+}
+
+// replyKey 按应答流水号查找下发的消息 ID，找不到时使用应答消息对应的默认消息 ID
+func (h *Handler) replyKey(device *model.Device, serial, id uint16) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.session(device)
+	if sent, ok := s.sent[serial]; ok {
+		delete(s.sent, serial)
+		return requestKey(sent)
+	}
+	return requestKey(map[uint16]uint16{
+		MsgLocationResponse:       MsgQueryLocation,
+		MsgVehicleControlResponse: MsgVehicleControl,
+		MsgParamsResponse:         MsgQueryParams,
+	}[id])
+}
+
+// message 生成下发消息，版本和终端手机号与终端上报的消息头相同，调用方持有 h.mu
+func (h *Handler) message(device *model.Device, s *session, id uint16, body []byte) Message {
+	header := Header{ID: id, Is2019: s.header.Is2019, Version: s.header.Version, Phone: s.header.Phone, Serial: s.serial}
+	if header.Phone == "" {
+		header.Phone = device.DeviceKey
+	}
+	s.serial++
+	return Message{Header: header, Body: body}
+}
+
+// command 将下发数据转换为消息
+func (h *Handler) command(data interface{}) (Command, error) {
+	var command Command
+	switch v := data.(type) {
+	case Command:
+		command = v
+	case *Command:
+		command = *v
+	case uint16:
+		command = Command{ID: v}
+	default:
+		if err := gconv.Struct(data, &command); err != nil {
+			return Command{}, fmt.Errorf("JT/T 808 下发消息格式错误: %v", err)
+		}
+	}
+	if command.ID == 0 {
+		return Command{}, errors.New("JT/T 808 下发消息缺少消息 ID")
+	}
+	return command, nil
+}
+
+// session 获取终端的会话状态，调用方持有 h.mu
+func (h *Handler) session(device *model.Device) *session {
+	s, ok := h.sessions[device]
+	if !ok {
+		s = &session{packages: make(map[uint16]*subpackage), sent: make(map[uint16]uint16)}
+		h.sessions[device] = s
+	}
+	return s
+}
+
+// assemble 保存分包，收齐后按包序号拼接消息体
+func (s *session) assemble(m Message) ([]byte, bool) {
+	p := s.packages[m.ID]
+	if p == nil || p.total != m.Total {
+		p = &subpackage{total: m.Total, packets: make(map[uint16][]byte)}
+		s.packages[m.ID] = p
+	}
+	p.packets[m.Index] = m.Body
+	if len(p.packets) < int(p.total) {
+		return nil, false
+	}
+	delete(s.packages, m.ID)
+	var body []byte
+	for i := uint16(1); i <= p.total; i++ {
+		body = append(body, p.packets[i]...)
+	}
+	return body, true
+}
+
+// TextMessage 生成文本信息下发消息，标志位含义见 JT/T 808 表 38，文本使用 GBK 编码
+func TextMessage(flag byte, text string) (Command, error) {
+	encoded, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return Command{}, fmt.Errorf("文本信息编码失败: %v", err)
+	}
+	return Command{ID: MsgTextMessage, Body: append([]byte{flag}, encoded...)}, nil
+}
+
+// generalResponse 平台通用应答的消息体：应答流水号 + 应答 ID + 结果
+func generalResponse(header Header, result byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, header.Serial)
+	body = binary.BigEndian.AppendUint16(body, header.ID)
+	return append(body, result)
+}
+
+// parseLocations 解析位置信息汇报或定位数据批量上传中的位置信息
+func parseLocations(id uint16, body []byte) ([]Location, error) {
+	if id == MsgLocation {
+		location, err := ParseLocation(body)
+		if err != nil {
+			return nil, err
+		}
+		return []Location{location}, nil
+	}
+	// 数据项个数 + 位置数据类型 + (位置汇报数据体长度 + 位置汇报数据体)...
+	if len(body) < 3 {
+		return nil, ErrInvalidFrame
+	}
+	count, items := int(binary.BigEndian.Uint16(body)), body[3:]
+	locations := make([]Location, 0, count)
+	for i := 0; i < count; i++ {
+		if len(items) < 2 || len(items) < 2+int(binary.BigEndian.Uint16(items)) {
+			return nil, ErrInvalidFrame
+		}
+		length := int(binary.BigEndian.Uint16(items))
+		location, err := ParseLocation(items[2 : 2+length])
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+		items = items[2+length:]
+	}
+	return locations, nil
+}
+
+// parseParams 解析查询终端参数应答中的参数项，参数 ID 为 8 位十六进制字符串(DWORD)，参数值为原始数据的十六进制字符串
+func parseParams(data []byte) (map[string]interface{}, error) {
+	if len(data) < 1 {
+		return nil, ErrInvalidFrame
+	}
+	count, items := int(data[0]), data[1:]
+	params := make(map[string]interface{}, count)
+	for i := 0; i < count; i++ {
+		if len(items) < 5 || len(items) < 5+int(items[4]) {
+			return nil, ErrInvalidFrame
+		}
+		length := int(items[4])
+		params[fmt.Sprintf("%08X", binary.BigEndian.Uint32(items))] = fmt.Sprintf("%X", items[5:5+length])
+		items = items[5+length:]
+	}
+	return params, nil
+}
+
+// requestKey 下发消息的关联键
+func requestKey(id uint16) string {
+	return fmt.Sprintf("%04X", id)
+}
@@ -0,0 +1,205 @@
+package jt808
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gookit/event"
+	"github.com/sagoo-cloud/iotgateway/consts"
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+const testPhone = "013912345678"
+
+// terminal 模拟的车载终端，应答平台下发的消息
+type terminal struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	serial   uint16
+	received chan Message // 平台的应答消息
+}
+
+// send 发送终端消息
+func (d *terminal) send(m Message) {
+	d.mu.Lock()
+	m.Phone, m.Serial = testPhone, d.serial
+	d.serial++
+	d.mu.Unlock()
+	frame, _ := m.Bytes()
+	d.conn.Write(frame)
+}
+
+// serve 读取平台的消息并应答
+func (d *terminal) serve() {
+	var buf []byte
+	chunk := make([]byte, 1024)
+	for {
+		n, err := d.conn.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			data, consumed, _ := frameDecoder{}.Decode(buf)
+			if consumed == 0 {
+				break
+			}
+			buf = buf[consumed:]
+			if data == nil {
+				continue
+			}
+			m, err := ParseMessage(data)
+			if err != nil {
+				continue
+			}
+			response := binary.BigEndian.AppendUint16(nil, m.Serial)
+			switch m.ID {
+			case MsgQueryLocation:
+				d.send(Message{Header: Header{ID: MsgLocationResponse}, Body: append(response, testLocation(statusPositioned)...)})
+			case MsgTextMessage:
+				d.send(Message{Header: Header{ID: MsgTerminalResponse}, Body: append(binary.BigEndian.AppendUint16(response, m.ID), ResultSuccess)})
+			case MsgQueryParams:
+				// 心跳间隔 0x0001 和厂商自定义参数 0xF0000001
+				params := append(response, 2, 0, 0, 0, 0x01, 4, 0, 0, 0, 30, 0xF0, 0, 0, 0x01, 2, 0xAB, 0xCD)
+				d.send(Message{Header: Header{ID: MsgParamsResponse}, Body: params})
+			case MsgTerminalControl:
+				d.send(Message{Header: Header{ID: MsgTerminalResponse}, Body: append(binary.BigEndian.AppendUint16(response, m.ID), ResultUnsupported)})
+			default:
+				d.received <- m
+			}
+		}
+	}
+}
+
+// expect 等待平台的应答消息
+func (d *terminal) expect(t *testing.T, id uint16) Message {
+	t.Helper()
+	select {
+	case m := <-d.received:
+		if m.ID != id || m.Phone != testPhone {
+			t.Fatalf("平台消息 %04X %s, 期望 %04X", m.ID, m.Phone, id)
+		}
+		return m
+	case <-time.After(3 * time.Second):
+		t.Fatalf("没有收到平台消息 %04X", id)
+	}
+	return Message{}
+}
+
+func TestHandlerOverTCP(t *testing.T) {
+	handler := New(Config{AuthCode: "AUTH0001", CheckAuth: true, MaxInflight: 2})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	server := network.NewTCPServer(network.WithProtocolHandler(handler))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reports := make(chan map[string]interface{}, 4)
+	event.On(consts.PushAttributeDataToMQTT, event.ListenerFunc(func(e event.Event) error {
+		reports <- e.Data()
+		return nil
+	}))
+
+	device := &terminal{conn: conn, received: make(chan Message, 4)}
+	go device.serve()
+
+	// 注册、鉴权之前上报的位置信息应答失败
+	device.send(Message{Header: Header{ID: MsgLocation}, Body: testLocation(statusPositioned)})
+	if reply := device.expect(t, MsgPlatformResponse); reply.Body[4] != ResultFailure {
+		t.Fatalf("未鉴权的位置信息应答 % X", reply.Body)
+	}
+
+	device.send(Message{Header: Header{ID: MsgRegister}, Body: make([]byte, 37)})
+	if reply := device.expect(t, MsgRegisterResponse); string(reply.Body[3:]) != "AUTH0001" || reply.Body[2] != ResultSuccess {
+		t.Fatalf("注册应答 % X", reply.Body)
+	}
+	device.send(Message{Header: Header{ID: MsgAuth}, Body: []byte("AUTH0001")})
+	if reply := device.expect(t, MsgPlatformResponse); binary.BigEndian.Uint16(reply.Body[2:]) != MsgAuth || reply.Body[4] != ResultSuccess {
+		t.Fatalf("鉴权应答 % X", reply.Body)
+	}
+	device.send(Message{Header: Header{ID: MsgHeartbeat}})
+	device.expect(t, MsgPlatformResponse)
+
+	// 分包上传的定位数据，每个分包都有平台通用应答
+	batch := []byte{0x00, 0x01, 0x00}
+	batch = binary.BigEndian.AppendUint16(batch, uint16(len(testLocation(statusPositioned))))
+	batch = append(batch, testLocation(statusPositioned)...)
+	device.send(Message{Header: Header{ID: MsgBatchLocation, Total: 2, Index: 1}, Body: batch[:10]})
+	device.expect(t, MsgPlatformResponse)
+	device.send(Message{Header: Header{ID: MsgBatchLocation, Total: 2, Index: 2}, Body: batch[10:]})
+	device.expect(t, MsgPlatformResponse)
+	select {
+	case report := <-reports:
+		properties := report["PropertieDataList"].(map[string]interface{})
+		node := properties["latitude"].(mqttProtocol.PropertyNode)
+		if report["DeviceKey"] != testPhone || node.Value != 31.230416 || node.CreateTime != time.Date(2024, 1, 2, 15, 30, 45, 0, timeZone).Unix() {
+			t.Fatalf("上报位置信息 %v", report)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有上报位置信息")
+	}
+
+	locator := server.(network.DeviceLocator)
+	request := func(ctx context.Context, data interface{}) (interface{}, error) {
+		target := locator.LookupDevice(testPhone)
+		if target == nil {
+			return nil, network.ErrDeviceNotFound
+		}
+		pending, err := network.BeginRequest(ctx, testPhone, handler, target, data)
+		if err != nil {
+			return nil, err
+		}
+		defer pending.Done()
+		if err := server.SendData(target, data); err != nil {
+			return nil, err
+		}
+		return pending.Wait(ctx)
+	}
+
+	timeout, cancelRequest := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelRequest()
+	response, err := request(timeout, MsgQueryLocation)
+	if err != nil || response.(map[string]interface{})["longitude"] != 121.473701 {
+		t.Fatalf("位置信息查询 %v %v", response, err)
+	}
+	text, err := TextMessage(0x08, "请回公司")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := request(timeout, text); err != nil || response != nil {
+		t.Fatalf("文本信息下发 %v %v", response, err)
+	}
+	response, err = request(timeout, MsgQueryParams)
+	if err != nil || !reflect.DeepEqual(response, map[string]interface{}{"00000001": "0000001E", "F0000001": "ABCD"}) {
+		t.Fatalf("查询终端参数 %v %v", response, err)
+	}
+	var result *ResultError
+	if _, err := request(timeout, Command{ID: MsgTerminalControl, Body: []byte{0x04}}); !errors.As(err, &result) || result.Result != ResultUnsupported {
+		t.Fatalf("终端应答失败时应返回错误，实际 %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package jt808
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/mqttProtocol"
+)
+
+const (
+	locationLength = 28 // 位置基本信息的长度
+
+	statusPositioned = 1 << 1 // 状态位 1,已定位
+	statusSouth      = 1 << 2 // 状态位 2,南纬
+	statusWest       = 1 << 3 // 状态位 3,西经
+)
+
+// 位置附加信息 ID
+const (
+	extraMileage       = 0x01 // 里程,DWORD,1/10 km
+	extraFuel          = 0x02 // 油量,WORD,1/10 L
+	extraRecorderSpeed = 0x03 // 行驶记录功能获取的速度,WORD,1/10 km/h
+	extraSignal        = 0x30 // 无线通信网络信号强度,BYTE
+	extraSatellites    = 0x31 // GNSS 定位卫星数,BYTE
+)
+
+// timeZone 位置信息中的时间为 GMT+8
+var timeZone = time.FixedZone("GMT+8", 8*3600)
+
+// Location 位置信息
+type Location struct {
+	Alarm     uint32          // 报警标志
+	Status    uint32          // 状态
+	Latitude  float64         // 纬度,南纬为负
+	Longitude float64         // 经度,西经为负
+	Altitude  uint16          // 高程,米
+	Speed     float64         // 速度,km/h
+	Direction uint16          // 方向,0~359,正北为 0,顺时针
+	Time      time.Time       // 时间
+	Extras    map[byte][]byte // 位置附加信息项,ID -> 原始数据
+}
+
+// ParseLocation 解析位置基本信息和位置附加信息项
+func ParseLocation(data []byte) (Location, error) {
+	if len(data) < locationLength {
+		return Location{}, ErrInvalidFrame
+	}
+	l := Location{
+		Alarm:     binary.BigEndian.Uint32(data),
+		Status:    binary.BigEndian.Uint32(data[4:]),
+		Latitude:  float64(binary.BigEndian.Uint32(data[8:])) / 1e6,
+		Longitude: float64(binary.BigEndian.Uint32(data[12:])) / 1e6,
+		Altitude:  binary.BigEndian.Uint16(data[16:]),
+		Speed:     float64(binary.BigEndian.Uint16(data[18:])) / 10,
+		Direction: binary.BigEndian.Uint16(data[20:]),
+	}
+	if l.Status&statusSouth != 0 {
+		l.Latitude = -l.Latitude
+	}
+	if l.Status&statusWest != 0 {
+		l.Longitude = -l.Longitude
+	}
+	var err error
+	if l.Time, err = time.ParseInLocation("060102150405", decodeBCD(data[22:locationLength]), timeZone); err != nil {
+		return Location{}, fmt.Errorf("%w: 时间 % X", ErrInvalidFrame, data[22:locationLength])
+	}
+
+	for extras := data[locationLength:]; len(extras) > 0; {
+		if len(extras) < 2 || len(extras) < 2+int(extras[1]) {
+			return Location{}, ErrInvalidFrame
+		}
+		if l.Extras == nil {
+			l.Extras = make(map[byte][]byte)
+		}
+		id, length := extras[0], int(extras[1])
+		l.Extras[id] = extras[2 : 2+length]
+		extras = extras[2+length:]
+	}
+	return l, nil
+}
+
+// Properties 将位置信息转换为属性，附加信息中的里程、油量、信号强度等转换为对应的属性，其他附加信息为 extra_XX 的十六进制字符串
+func (l Location) Properties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"alarm":      l.Alarm,
+		"status":     l.Status,
+		"positioned": l.Status&statusPositioned != 0,
+		"latitude":   l.Latitude,
+		"longitude":  l.Longitude,
+		"altitude":   l.Altitude,
+		"speed":      l.Speed,
+		"direction":  l.Direction,
+	}
+	for id, data := range l.Extras {
+		switch {
+		case id == extraMileage && len(data) == 4:
+			properties["mileage"] = float64(binary.BigEndian.Uint32(data)) / 10
+		case id == extraFuel && len(data) == 2:
+			properties["fuel"] = float64(binary.BigEndian.Uint16(data)) / 10
+		case id == extraRecorderSpeed && len(data) == 2:
+			properties["recorderSpeed"] = float64(binary.BigEndian.Uint16(data)) / 10
+		case id == extraSignal && len(data) == 1:
+			properties["signal"] = data[0]
+		case id == extraSatellites && len(data) == 1:
+			properties["satellites"] = data[0]
+		default:
+			properties[fmt.Sprintf("extra_%02X", id)] = fmt.Sprintf("%X", data)
+		}
+	}
+	return properties
+}
+
+// propertyNodes 将位置信息转换为带时间的属性，用于上报
+func (l Location) propertyNodes() map[string]interface{} {
+	properties := l.Properties()
+	for name, value := range properties {
+		properties[name] = mqttProtocol.PropertyNode{Value: value, CreateTime: l.Time.Unix()}
+	}
+	return properties
+}
@@ -0,0 +1,47 @@
+package jt808
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testLocation 生成位置信息汇报的消息体
+func testLocation(status uint32, extras ...byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, 0x00000001)
+	data = binary.BigEndian.AppendUint32(data, status)
+	data = binary.BigEndian.AppendUint32(data, 31230416)  // 31.230416
+	data = binary.BigEndian.AppendUint32(data, 121473701) // 121.473701
+	data = binary.BigEndian.AppendUint16(data, 12)
+	data = binary.BigEndian.AppendUint16(data, 605)
+	data = binary.BigEndian.AppendUint16(data, 90)
+	data = append(data, 0x24, 0x01, 0x02, 0x15, 0x30, 0x45)
+	return append(data, extras...)
+}
+
+func TestLocation(t *testing.T) {
+	location, err := ParseLocation(testLocation(statusPositioned|statusSouth,
+		extraMileage, 4, 0x00, 0x00, 0x30, 0x39, extraSatellites, 1, 12, 0xE1, 2, 0xAB, 0xCD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 2, 15, 30, 45, 0, timeZone); !location.Time.Equal(want) {
+		t.Fatalf("时间 %v", location.Time)
+	}
+	want := map[string]interface{}{
+		"alarm": uint32(1), "status": uint32(statusPositioned | statusSouth), "positioned": true,
+		"latitude": -31.230416, "longitude": 121.473701, "altitude": uint16(12), "speed": 60.5, "direction": uint16(90),
+		"mileage": 1234.5, "satellites": byte(12), "extra_E1": "ABCD",
+	}
+	if properties := location.Properties(); !reflect.DeepEqual(properties, want) {
+		t.Fatalf("位置属性 %v", properties)
+	}
+
+	if _, err := ParseLocation(testLocation(0, extraMileage, 4, 0x00)); err == nil {
+		t.Fatal("附加信息长度不足时应返回错误")
+	}
+	if _, err := ParseLocation(testLocation(0)[:20]); err == nil {
+		t.Fatal("位置基本信息长度不足时应返回错误")
+	}
+}
@@ -0,0 +1,302 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 功能码
+const (
+	FuncReadCoils              byte = 0x01
+	FuncReadDiscreteInputs     byte = 0x02
+	FuncReadHoldingRegisters   byte = 0x03
+	FuncReadInputRegisters     byte = 0x04
+	FuncWriteSingleCoil        byte = 0x05
+	FuncWriteSingleRegister    byte = 0x06
+	FuncWriteMultipleCoils     byte = 0x0F
+	FuncWriteMultipleRegisters byte = 0x10
+)
+
+const (
+	maxReadBits      = 2000 // 一次最多读取的线圈/离散输入数量
+	maxReadRegisters = 125  // 一次最多读取的寄存器数量
+	maxWriteBits     = 1968
+	maxWriteRegs     = 123
+	mbapHeaderLength = 7
+)
+
+var (
+	// ErrInvalidResponse 响应报文格式错误或与请求不匹配
+	ErrInvalidResponse = errors.New("Modbus 响应格式错误")
+	// ErrCRC RTU 报文 CRC 校验失败
+	ErrCRC = errors.New("Modbus RTU CRC 校验失败")
+)
+
+// Exception 从站返回的异常响应
+type Exception struct {
+	Function byte // 请求的功能码
+	Code     byte // 异常码
+}
+
+// Error 实现 error 接口
+func (e *Exception) Error() string {
+	names := map[byte]string{
+		1: "非法功能码", 2: "非法数据地址", 3: "非法数据值", 4: "从站设备故障",
+		5: "确认", 6: "从站设备忙", 8: "存储奇偶性差错", 10: "网关路径不可用", 11: "网关目标设备响应失败",
+	}
+	if name, ok := names[e.Code]; ok {
+		return fmt.Sprintf("Modbus 异常响应: 功能码 %d, %s", e.Function, name)
+	}
+	return fmt.Sprintf("Modbus 异常响应: 功能码 %d, 异常码 %d", e.Function, e.Code)
+}
+
+// Request 表示一个 Modbus 请求
+type Request struct {
+	SlaveID  byte     `json:"slaveId"`  // 从站地址,为 0 时使用设备配置的从站地址
+	Function byte     `json:"function"` // 功能码
+	Address  uint16   `json:"address"`  // 起始地址
+	Quantity uint16   `json:"quantity"` // 读取或写入的数量,写入时为 0 则按 Values 的长度
+	Values   []uint16 `json:"values"`   // 写入的值,写线圈时非 0 为 ON
+}
+
+// pdu 生成请求的 PDU(功能码 + 数据)
+func (r Request) pdu() ([]byte, error) {
+	pdu := []byte{r.Function}
+	switch r.Function {
+	case FuncReadCoils, FuncReadDiscreteInputs, FuncReadHoldingRegisters, FuncReadInputRegisters:
+		max := uint16(maxReadRegisters)
+		if r.Function == FuncReadCoils || r.Function == FuncReadDiscreteInputs {
+			max = maxReadBits
+		}
+		if r.Quantity == 0 || r.Quantity > max {
+			return nil, fmt.Errorf("读取数量 %d 超出范围 1~%d", r.Quantity, max)
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, r.Address)
+		pdu = binary.BigEndian.AppendUint16(pdu, r.Quantity)
+	case FuncWriteSingleCoil, FuncWriteSingleRegister:
+		if len(r.Values) != 1 {
+			return nil, errors.New("写单个线圈/寄存器需要 1 个值")
+		}
+		value := r.Values[0]
+		if r.Function == FuncWriteSingleCoil && value != 0 {
+			value = 0xFF00
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, r.Address)
+		pdu = binary.BigEndian.AppendUint16(pdu, value)
+	case FuncWriteMultipleCoils:
+		if len(r.Values) == 0 || len(r.Values) > maxWriteBits {
+			return nil, fmt.Errorf("写入线圈数量 %d 超出范围 1~%d", len(r.Values), maxWriteBits)
+		}
+		bits := make([]byte, (len(r.Values)+7)/8)
+		for i, value := range r.Values {
+			if value != 0 {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, r.Address)
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(r.Values)))
+		pdu = append(pdu, byte(len(bits)))
+		pdu = append(pdu, bits...)
+	case FuncWriteMultipleRegisters:
+		if len(r.Values) == 0 || len(r.Values) > maxWriteRegs {
+			return nil, fmt.Errorf("写入寄存器数量 %d 超出范围 1~%d", len(r.Values), maxWriteRegs)
+		}
+		pdu = binary.BigEndian.AppendUint16(pdu, r.Address)
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(r.Values)))
+		pdu = append(pdu, byte(len(r.Values)*2))
+		for _, value := range r.Values {
+			pdu = binary.BigEndian.AppendUint16(pdu, value)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的功能码 %d", r.Function)
+	}
+	return pdu, nil
+}
+
+// parseResponse 解析响应 PDU，读请求返回读取到的值(线圈为 0/1)，写请求返回 nil
+func (r Request) parseResponse(pdu []byte) ([]uint16, error) {
+	if len(pdu) < 2 {
+		return nil, ErrInvalidResponse
+	}
+	if pdu[0] == r.Function|0x80 {
+		return nil, &Exception{Function: r.Function, Code: pdu[1]}
+	}
+	if pdu[0] != r.Function {
+		return nil, fmt.Errorf("%w: 功能码 %d 与请求 %d 不一致", ErrInvalidResponse, pdu[0], r.Function)
+	}
+
+	switch r.Function {
+	case FuncReadCoils, FuncReadDiscreteInputs:
+		count := int(pdu[1])
+		if len(pdu) != 2+count || count < (int(r.Quantity)+7)/8 {
+			return nil, ErrInvalidResponse
+		}
+		values := make([]uint16, r.Quantity)
+		for i := range values {
+			values[i] = uint16(pdu[2+i/8]>>(i%8)) & 1
+		}
+		return values, nil
+	case FuncReadHoldingRegisters, FuncReadInputRegisters:
+		count := int(pdu[1])
+		if len(pdu) != 2+count || count != int(r.Quantity)*2 {
+			return nil, ErrInvalidResponse
+		}
+		values := make([]uint16, r.Quantity)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(pdu[2+i*2:])
+		}
+		return values, nil
+	default:
+		if len(pdu) != 5 || binary.BigEndian.Uint16(pdu[1:]) != r.Address {
+			return nil, ErrInvalidResponse
+		}
+		return nil, nil
+	}
+}
+
+// CRC16 计算 Modbus RTU 的 CRC16 校验值
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// rtuFrame 生成 RTU 报文：从站地址 + PDU + CRC(低字节在前)
+func rtuFrame(slaveID byte, pdu []byte) []byte {
+	frame := append([]byte{slaveID}, pdu...)
+	return binary.LittleEndian.AppendUint16(frame, CRC16(frame))
+}
+
+// tcpFrame 生成 TCP 报文：MBAP 报文头 + PDU
+func tcpFrame(transactionID uint16, slaveID byte, pdu []byte) []byte {
+	frame := make([]byte, mbapHeaderLength, mbapHeaderLength+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], transactionID)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(pdu)+1))
+	frame[6] = slaveID
+	return append(frame, pdu...)
+}
+
+// rtuDecoder RTU 帧解码器，按功能码确定响应长度
+// 不是 Modbus 响应的数据(如 DTU 的注册包、心跳包)切分到下一个校验正确的响应，作为一帧交给协议处理器，没有时整体作为一帧
+type rtuDecoder struct{}
+
+// Decode 实现 network.FrameDecoder 接口
+func (rtuDecoder) Decode(buf []byte) ([]byte, int, error) {
+	length := rtuLength(buf)
+	switch {
+	case length == 0:
+		return nil, 0, nil
+	case length < 0:
+		n := rtuNextFrame(buf)
+		return buf[:n], n, nil
+	case CRC16(buf[:length-2]) != binary.LittleEndian.Uint16(buf[length-2:]):
+		return nil, rtuResync(buf), nil // 校验失败，丢弃到下一个可能的帧头，缓存中随后的完整帧不受影响
+	}
+	return buf[:length], length, nil
+}
+
+// rtuLength 按功能码确定响应长度，数据不足以确定长度或不完整时返回 0，不是 Modbus 响应时返回 -1
+func rtuLength(buf []byte) int {
+	if len(buf) < 2 {
+		return 0
+	}
+	var length int
+	switch function := buf[1]; {
+	case function&0x80 != 0 && function&0x7F >= FuncReadCoils && function&0x7F <= FuncWriteMultipleRegisters:
+		length = 5
+	case function >= FuncReadCoils && function <= FuncReadInputRegisters:
+		if len(buf) < 3 {
+			return 0
+		}
+		if buf[2] > 250 {
+			return -1 // 一次最多读取 125 个寄存器或 2000 个线圈
+		}
+		length = 5 + int(buf[2])
+	case function == FuncWriteSingleCoil || function == FuncWriteSingleRegister ||
+		function == FuncWriteMultipleCoils || function == FuncWriteMultipleRegisters:
+		length = 8
+	default:
+		return -1
+	}
+	if len(buf) < length {
+		return 0
+	}
+	return length
+}
+
+// rtuResync 校验失败后查找下一个可能的帧头，返回需要丢弃的字节数
+// 从第二个字节开始，校验正确或尚不完整的响应视为帧头
+func rtuResync(buf []byte) int {
+	for i := 1; i < len(buf); i++ {
+		length := rtuLength(buf[i:])
+		if length == 0 {
+			return i
+		}
+		if length > 0 && CRC16(buf[i:i+length-2]) == binary.LittleEndian.Uint16(buf[i+length-2:i+length]) {
+			return i
+		}
+	}
+	return len(buf)
+}
+
+// rtuNextFrame 查找下一个完整且校验正确的响应，返回之前的字节数，没有时返回全部长度
+func rtuNextFrame(buf []byte) int {
+	for i := 1; i < len(buf); i++ {
+		if length := rtuLength(buf[i:]); length > 0 && CRC16(buf[i:i+length-2]) == binary.LittleEndian.Uint16(buf[i+length-2:i+length]) {
+			return i
+		}
+	}
+	return len(buf)
+}
+
+// tcpDecoder TCP 帧解码器，按 MBAP 报文头中的长度切分
+// 不是 Modbus TCP 报文的数据切分到下一个完整的报文，作为一帧交给协议处理器，没有时整体作为一帧
+type tcpDecoder struct{}
+
+// Decode 实现 network.FrameDecoder 接口
+func (tcpDecoder) Decode(buf []byte) ([]byte, int, error) {
+	if len(buf) < mbapHeaderLength {
+		return nil, 0, nil
+	}
+	length := tcpLength(buf)
+	if length < 0 {
+		n := tcpNextFrame(buf) // 不是 Modbus TCP 报文
+		return buf[:n], n, nil
+	}
+	if len(buf) < length {
+		return nil, 0, nil
+	}
+	return buf[:length], length, nil
+}
+
+// tcpLength 按 MBAP 报文头确定报文长度，报文头不完整时返回 0，不是 Modbus TCP 报文时返回 -1
+func tcpLength(buf []byte) int {
+	if len(buf) < mbapHeaderLength {
+		return 0
+	}
+	length := int(binary.BigEndian.Uint16(buf[4:]))
+	if binary.BigEndian.Uint16(buf[2:]) != 0 || length < 2 || length > 254 {
+		return -1
+	}
+	return 6 + length
+}
+
+// tcpNextFrame 查找下一个报文头有效且完整的报文，返回之前的字节数，没有时返回全部长度
+func tcpNextFrame(buf []byte) int {
+	for i := 1; i < len(buf); i++ {
+		if length := tcpLength(buf[i:]); length > 0 && i+length <= len(buf) {
+			return i
+		}
+	}
+	return len(buf)
+}
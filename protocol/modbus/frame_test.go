@@ -0,0 +1,142 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	frame := rtuFrame(1, []byte{FuncReadHoldingRegisters, 0x00, 0x00, 0x00, 0x0A})
+	if want := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A, 0xC5, 0xCD}; !bytes.Equal(frame, want) {
+		t.Fatalf("RTU 报文 % X, 期望 % X", frame, want)
+	}
+}
+
+func TestRequestPDU(t *testing.T) {
+	tests := []struct {
+		request Request
+		want    []byte
+	}{
+		{Request{Function: FuncReadCoils, Address: 0x13, Quantity: 0x25}, []byte{0x01, 0x00, 0x13, 0x00, 0x25}},
+		{Request{Function: FuncWriteSingleCoil, Address: 0xAC, Values: []uint16{1}}, []byte{0x05, 0x00, 0xAC, 0xFF, 0x00}},
+		{Request{Function: FuncWriteSingleRegister, Address: 1, Values: []uint16{3}}, []byte{0x06, 0x00, 0x01, 0x00, 0x03}},
+		{Request{Function: FuncWriteMultipleCoils, Address: 0x13, Values: []uint16{1, 0, 1, 1, 0, 0, 1, 1, 1, 0}},
+			[]byte{0x0F, 0x00, 0x13, 0x00, 0x0A, 0x02, 0xCD, 0x01}},
+		{Request{Function: FuncWriteMultipleRegisters, Address: 1, Values: []uint16{0x000A, 0x0102}},
+			[]byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0A, 0x01, 0x02}},
+	}
+	for _, tt := range tests {
+		pdu, err := tt.request.pdu()
+		if err != nil || !bytes.Equal(pdu, tt.want) {
+			t.Errorf("%+v 的 PDU % X %v, 期望 % X", tt.request, pdu, err, tt.want)
+		}
+	}
+	for _, request := range []Request{
+		{Function: FuncReadHoldingRegisters, Quantity: 126},
+		{Function: FuncReadCoils},
+		{Function: FuncWriteSingleRegister, Values: []uint16{1, 2}},
+		{Function: 0x2B},
+	} {
+		if _, err := request.pdu(); err == nil {
+			t.Errorf("%+v 应返回错误", request)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	coils := Request{Function: FuncReadCoils, Quantity: 10}
+	values, err := coils.parseResponse([]byte{0x01, 0x02, 0xCD, 0x01})
+	if err != nil || len(values) != 10 || values[0] != 1 || values[1] != 0 || values[8] != 1 || values[9] != 0 {
+		t.Fatalf("线圈 %v %v", values, err)
+	}
+
+	registers := Request{Function: FuncReadHoldingRegisters, Quantity: 2}
+	values, err = registers.parseResponse([]byte{0x03, 0x04, 0x02, 0x2B, 0x00, 0x64})
+	if err != nil || values[0] != 0x022B || values[1] != 0x64 {
+		t.Fatalf("寄存器 %v %v", values, err)
+	}
+	if _, err := registers.parseResponse([]byte{0x03, 0x02, 0x02, 0x2B}); !errors.Is(err, ErrInvalidResponse) {
+		t.Fatalf("数量不一致时应返回 ErrInvalidResponse，实际 %v", err)
+	}
+
+	var exception *Exception
+	if _, err := registers.parseResponse([]byte{0x83, 0x02}); !errors.As(err, &exception) || exception.Code != 2 {
+		t.Fatalf("异常响应 %v", err)
+	}
+
+	write := Request{Function: FuncWriteSingleRegister, Address: 1, Values: []uint16{3}}
+	if values, err := write.parseResponse([]byte{0x06, 0x00, 0x01, 0x00, 0x03}); err != nil || values != nil {
+		t.Fatalf("写响应 %v %v", values, err)
+	}
+}
+
+func TestRTUDecoder(t *testing.T) {
+	read := rtuFrame(1, []byte{0x03, 0x02, 0x00, 0x64})
+	write := rtuFrame(1, []byte{0x06, 0x00, 0x01, 0x00, 0x03})
+	exception := rtuFrame(1, []byte{0x83, 0x02})
+	stream := append(append(append([]byte(nil), read...), write...), exception...)
+
+	var frames [][]byte
+	for len(stream) > 0 {
+		frame, n, err := rtuDecoder{}.Decode(stream)
+		if err != nil || n == 0 {
+			t.Fatalf("切分失败 % X %v", stream, err)
+		}
+		frames = append(frames, frame)
+		stream = stream[n:]
+	}
+	if len(frames) != 3 || !bytes.Equal(frames[0], read) || !bytes.Equal(frames[1], write) || !bytes.Equal(frames[2], exception) {
+		t.Fatalf("帧 % X", frames)
+	}
+
+	if _, n, _ := (rtuDecoder{}).Decode(read[:4]); n != 0 {
+		t.Fatal("不完整的帧应等待更多数据")
+	}
+	if frame, n, _ := (rtuDecoder{}).Decode([]byte("DTU-001")); n != 7 || string(frame) != "DTU-001" {
+		t.Fatalf("非 Modbus 数据应整体作为一帧，实际 %q", frame)
+	}
+	// 非 Modbus 数据只切分到随后的完整响应，响应不被吞掉
+	if frame, n, _ := (rtuDecoder{}).Decode(append([]byte("DTU-001"), read...)); n != 7 || string(frame) != "DTU-001" {
+		t.Fatalf("非 Modbus 数据应切分到随后的响应，实际 %q", frame)
+	}
+
+	// 校验失败的帧被跳过，缓存中随后的完整帧仍能切分
+	corrupted := append([]byte(nil), read...)
+	corrupted[3] ^= 0xFF
+	stream = append(append(corrupted, write...), exception...)
+	frames = nil
+	for len(stream) > 0 {
+		frame, n, err := rtuDecoder{}.Decode(stream)
+		if err != nil || n == 0 {
+			t.Fatalf("重新同步失败 % X %v", stream, err)
+		}
+		if frame != nil {
+			frames = append(frames, frame)
+		}
+		stream = stream[n:]
+	}
+	if len(frames) != 2 || !bytes.Equal(frames[0], write) || !bytes.Equal(frames[1], exception) {
+		t.Fatalf("重新同步后的帧 % X", frames)
+	}
+}
+
+func TestTCPDecoder(t *testing.T) {
+	first := tcpFrame(1, 1, []byte{0x03, 0x02, 0x00, 0x64})
+	second := tcpFrame(2, 1, []byte{0x06, 0x00, 0x01, 0x00, 0x03})
+	stream := append(append([]byte(nil), first...), second[:5]...)
+	frame, n, err := tcpDecoder{}.Decode(stream)
+	if err != nil || !bytes.Equal(frame, first) {
+		t.Fatalf("帧 % X %v", frame, err)
+	}
+	if _, n, _ = (tcpDecoder{}).Decode(stream[n:]); n != 0 {
+		t.Fatal("不完整的帧应等待更多数据")
+	}
+	// 不是 Modbus TCP 的数据切分到随后的完整报文，没有时整体作为一帧
+	if frame, n, _ := (tcpDecoder{}).Decode(append([]byte("DTU-001"), first...)); n != 7 || string(frame) != "DTU-001" {
+		t.Fatalf("非 Modbus TCP 数据应切分到随后的报文，实际 %q", frame)
+	}
+	if frame, n, _ := (tcpDecoder{}).Decode([]byte("HEARTBEAT")); n != 9 || string(frame) != "HEARTBEAT" {
+		t.Fatalf("非 Modbus TCP 数据应整体作为一帧，实际 %q", frame)
+	}
+}
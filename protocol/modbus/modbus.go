@@ -0,0 +1,368 @@
+// Package modbus 提供 Modbus TCP/RTU 协议处理器，按点表将寄存器映射为物模型属性
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// 传输模式
+const (
+	ModeTCP = "tcp" // MBAP 报文头，不带 CRC
+	ModeRTU = "rtu" // 从站地址 + PDU + CRC，包括串口和 RTU over TCP(DTU 透传)
+)
+
+const (
+	maxPendingRequests = 64          // 每个设备最多保留的未响应请求，超出时丢弃全部(请求方已超时)
+	pendingExpiration  = time.Minute // 未响应的请求保留时长，超时后清理，避免断开的设备残留
+)
+
+// Config Modbus 协议处理器配置
+type Config struct {
+	Mode        string          `json:"mode"`        // 传输模式,tcp(默认)/rtu
+	SlaveID     byte            `json:"slaveId"`     // 默认从站地址,默认 1
+	Slaves      map[string]byte `json:"slaves"`      // 设备标识 -> 从站地址
+	Points      []Point         `json:"points"`      // 点表
+	MaxGap      uint16          `json:"maxGap"`      // 合并读请求时允许跳过的最大地址空隙
+	MaxInflight int             `json:"maxInflight"` // tcp 模式下同一设备同时等待响应的请求数,默认 1
+	Register    bool            `json:"register"`    // 连接发送的第一个非 Modbus 数据为 DTU 注册包,内容作为设备标识
+}
+
+// Handler Modbus 协议处理器
+// 下发数据可以是 Request、点的属性标识(读取该点)或可转换为 Request 的键值数据，读响应按点表转换为属性值交给等待的请求
+type Handler struct {
+	config      Config
+	points      []Point
+	transaction atomic.Uint32
+
+	mu        sync.Mutex
+	pending   map[*model.Device]map[uint16]pendingRequest // 设备 -> 事务标识 -> 等待响应的请求，rtu 模式事务标识为 0
+	lastPrune time.Time
+}
+
+// pendingRequest 已下发、等待响应的请求
+type pendingRequest struct {
+	request Request
+	slaveID byte
+	sent    time.Time
+}
+
+// New 创建 Modbus 协议处理器
+func New(config Config) (*Handler, error) {
+	switch config.Mode {
+	case "":
+		config.Mode = ModeTCP
+	case ModeTCP, ModeRTU:
+	default:
+		return nil, fmt.Errorf("不支持的 Modbus 传输模式: %s", config.Mode)
+	}
+	if config.SlaveID == 0 {
+		config.SlaveID = 1
+	}
+	h := &Handler{config: config, pending: make(map[*model.Device]map[uint16]pendingRequest)}
+	names := make(map[string]bool)
+	for _, point := range config.Points {
+		if err := point.validate(); err != nil {
+			return nil, err
+		}
+		if names[point.Name] {
+			return nil, fmt.Errorf("点 %s 重复", point.Name)
+		}
+		names[point.Name] = true
+		h.points = append(h.points, point)
+	}
+	return h, nil
+}
+
+// Init 实现 network.ProtocolHandler 接口，启用 Register 时将 DTU 注册包的内容作为设备标识
+func (h *Handler) Init(device *model.Device, data []byte) error {
+	if h.config.Register && device != nil && device.DeviceKey == "" && !h.isFrame(data) {
+		device.DeviceKey = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// Encode 实现 network.ProtocolHandler 接口，生成请求报文并登记等待响应
+func (h *Handler) Encode(device *model.Device, data interface{}, param ...string) ([]byte, error) {
+	request, err := h.request(data)
+	if err != nil {
+		return nil, err
+	}
+	pdu, err := request.pdu()
+	if err != nil {
+		return nil, err
+	}
+	slaveID := h.slaveID(device, request)
+
+	var transactionID uint16
+	var frame []byte
+	if h.config.Mode == ModeRTU {
+		frame = rtuFrame(slaveID, pdu)
+	} else {
+		transactionID = uint16(h.transaction.Add(1))
+		frame = tcpFrame(transactionID, slaveID, pdu)
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Sub(h.lastPrune) > pendingExpiration {
+		h.prune(now)
+	}
+	pending := h.pending[device]
+	if pending == nil || len(pending) >= maxPendingRequests {
+		pending = make(map[uint16]pendingRequest)
+		h.pending[device] = pending
+	}
+	pending[transactionID] = pendingRequest{request: request, slaveID: slaveID, sent: now}
+	return frame, nil
+}
+
+// prune 清理超时未响应的请求，调用方持有 h.mu
+func (h *Handler) prune(now time.Time) {
+	h.lastPrune = now
+	for device, pending := range h.pending {
+		for transactionID, request := range pending {
+			if now.Sub(request.sent) > pendingExpiration {
+				delete(pending, transactionID)
+			}
+		}
+		if len(pending) == 0 {
+			delete(h.pending, device)
+		}
+	}
+}
+
+// Decode 实现 network.ProtocolHandler 接口，将响应交给等待的请求
+// 读响应转换为属性标识 -> 属性值，写响应为 nil，异常响应为 *Exception
+func (h *Handler) Decode(device *model.Device, data []byte) ([]byte, error) {
+	if device == nil {
+		return nil, nil
+	}
+	var transactionID uint16
+	var pdu []byte
+	switch {
+	case !h.isFrame(data):
+		return nil, nil // 注册包、心跳包等非 Modbus 数据
+	case h.config.Mode == ModeRTU:
+		pdu = data[1 : len(data)-2]
+	default:
+		transactionID = binary.BigEndian.Uint16(data)
+		pdu = data[mbapHeaderLength:]
+	}
+
+	h.mu.Lock()
+	sent, ok := h.pending[device][transactionID]
+	if ok && h.config.Mode == ModeRTU && sent.slaveID != data[0] {
+		ok = false // 总线上其他从站的应答，继续等待请求的从站
+	}
+	request := sent.request
+	if ok {
+		delete(h.pending[device], transactionID)
+		if len(h.pending[device]) == 0 {
+			delete(h.pending, device)
+		}
+	}
+	h.mu.Unlock()
+	if !ok {
+		glog.Debugf(context.Background(), "Modbus 设备 %s 的响应没有对应的请求", device.DeviceKey)
+		return nil, nil
+	}
+
+	key := h.requestKey(sent.slaveID, request)
+	values, err := request.parseResponse(pdu)
+	if err != nil {
+		network.Respond(device.DeviceKey, key, err)
+		return nil, nil
+	}
+	if values == nil {
+		network.Respond(device.DeviceKey, key, nil)
+		return nil, nil
+	}
+	network.Respond(device.DeviceKey, key, h.properties(request, values))
+	return nil, nil
+}
+
+// FrameDecoder 实现 network.FrameDecoderProvider 接口
+func (h *Handler) FrameDecoder() network.FrameDecoder {
+	if h.config.Mode == ModeRTU {
+		return rtuDecoder{}
+	}
+	return tcpDecoder{}
+}
+
+// RequestKey 实现 network.RequestKeyProvider 接口，按从站地址、功能码和起始地址关联响应，同一总线上不同从站的请求互不冲突
+func (h *Handler) RequestKey(device *model.Device, data interface{}, param ...string) string {
+	request, err := h.request(data)
+	if err != nil {
+		return ""
+	}
+	return h.requestKey(h.slaveID(device, request), request)
+}
+
+// AddressSlave 实现 network.SlaveAddresser 接口，slave 为 slaves 中子设备的标识或十进制从站地址
+// 请求已指定从站地址时不做修改
+func (h *Handler) AddressSlave(data interface{}, slave string) (interface{}, error) {
+	request, err := h.request(data)
+	if err != nil {
+		return nil, err
+	}
+	if request.SlaveID != 0 {
+		return request, nil
+	}
+	if slaveID, ok := h.config.Slaves[slave]; ok {
+		request.SlaveID = slaveID
+		return request, nil
+	}
+	slaveID, err := strconv.ParseUint(slave, 10, 8)
+	if err != nil || slaveID == 0 {
+		return nil, fmt.Errorf("从站地址 %s 无效", slave)
+	}
+	request.SlaveID = byte(slaveID)
+	return request, nil
+}
+
+// MaxInflight 实现 network.RequestPipeliner 接口，rtu 模式的响应不带事务标识，只能串行
+func (h *Handler) MaxInflight() int {
+	if h.config.Mode == ModeRTU || h.config.MaxInflight <= 0 {
+		return 1
+	}
+	return h.config.MaxInflight
+}
+
+// ReadRequests 按点表生成读取全部点的请求，相邻的点合并为一个请求，可以作为轮询命令
+func (h *Handler) ReadRequests() []Request {
+	return readRequests(h.points, h.config.MaxGap)
+}
+
+// WriteRequests 将属性值转换为写请求，不在点表中的属性被忽略
+// 线圈使用功能码 5，单个寄存器使用功能码 6，多个寄存器使用功能码 16
+func (h *Handler) WriteRequests(properties map[string]interface{}) ([]Request, error) {
+	var requests []Request
+	for _, point := range h.points {
+		value, ok := properties[point.Name]
+		if !ok {
+			continue
+		}
+		if !point.writable() {
+			return nil, fmt.Errorf("点 %s 只读", point.Name)
+		}
+		values, err := point.encode(value)
+		if err != nil {
+			return nil, err
+		}
+		request := Request{Address: point.Address, Values: values}
+		switch {
+		case point.Area == AreaCoil:
+			request.Function = FuncWriteSingleCoil
+		case len(values) == 1:
+			request.Function = FuncWriteSingleRegister
+		default:
+			request.Function = FuncWriteMultipleRegisters
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// SetProperties 实现 network.PropertySetter 接口，点表中的属性依次写入设备，返回写入成功的属性
+func (h *Handler) SetProperties(ctx context.Context, requester network.Requester, device *model.Device, properties map[string]interface{}) (map[string]interface{}, error) {
+	requests, err := h.WriteRequests(properties)
+	if err != nil {
+		return nil, err
+	}
+	reply := make(map[string]interface{})
+	for _, write := range requests {
+		if _, err := requester.Request(ctx, device.DeviceKey, write); err != nil {
+			return reply, fmt.Errorf("写入地址 %d 失败: %w", write.Address, err)
+		}
+		for _, point := range h.points {
+			if point.Address == write.Address && point.writable() {
+				if value, ok := properties[point.Name]; ok {
+					reply[point.Name] = value
+				}
+			}
+		}
+	}
+	return reply, nil
+}
+
+// request 将下发数据转换为请求
+func (h *Handler) request(data interface{}) (Request, error) {
+	switch v := data.(type) {
+	case Request:
+		return v, nil
+	case *Request:
+		return *v, nil
+	case string:
+		for _, point := range h.points {
+			if point.Name == v {
+				return Request{Function: point.readFunction(), Address: point.Address, Quantity: point.registers()}, nil
+			}
+		}
+		return Request{}, fmt.Errorf("点 %s 不存在", v)
+	}
+	var request Request
+	if err := gconv.Struct(data, &request); err != nil {
+		return Request{}, fmt.Errorf("Modbus 请求格式错误: %v", err)
+	}
+	if request.Quantity == 0 && len(request.Values) > 1 {
+		request.Quantity = uint16(len(request.Values))
+	}
+	return request, nil
+}
+
+// requestKey 请求的关联键
+func (h *Handler) requestKey(slaveID byte, request Request) string {
+	return fmt.Sprintf("%d:%d:%d", slaveID, request.Function, request.Address)
+}
+
+// slaveID 获取请求的从站地址
+func (h *Handler) slaveID(device *model.Device, request Request) byte {
+	if request.SlaveID != 0 {
+		return request.SlaveID
+	}
+	if device != nil {
+		if slaveID, ok := h.config.Slaves[device.DeviceKey]; ok {
+			return slaveID
+		}
+	}
+	return h.config.SlaveID
+}
+
+// isFrame 判断数据是否为完整的 Modbus 报文
+func (h *Handler) isFrame(data []byte) bool {
+	if h.config.Mode == ModeRTU {
+		return len(data) >= 4 && CRC16(data[:len(data)-2]) == binary.LittleEndian.Uint16(data[len(data)-2:])
+	}
+	return len(data) > mbapHeaderLength && binary.BigEndian.Uint16(data[2:]) == 0 &&
+		int(binary.BigEndian.Uint16(data[4:])) == len(data)-6
+}
+
+// properties 将读响应中的值按点表转换为属性值
+func (h *Handler) properties(request Request, values []uint16) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, point := range h.points {
+		if point.readFunction() != request.Function {
+			continue
+		}
+		start := int(point.Address) - int(request.Address)
+		end := start + int(point.registers())
+		if start < 0 || end > len(values) {
+			continue
+		}
+		properties[point.Name] = point.decode(values[start:end])
+	}
+	return properties
+}
@@ -0,0 +1,224 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sagoo-cloud/iotgateway/model"
+	"github.com/sagoo-cloud/iotgateway/network"
+)
+
+// requesterFunc 将请求函数适配为 network.Requester
+type requesterFunc func(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error)
+
+func (f requesterFunc) Request(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error) {
+	return f(ctx, deviceKey, data, params...)
+}
+
+// slave 模拟的 RTU 从站，保持寄存器与线圈共用一张表
+type slave struct {
+	mu        sync.Mutex
+	id        byte
+	registers map[uint16]uint16
+}
+
+// serve 读取 RTU 请求并应答
+func (s *slave) serve(conn net.Conn) {
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := 8
+		if header[1] == FuncWriteMultipleRegisters || header[1] == FuncWriteMultipleCoils {
+			length = 9 + int(header[6])
+		}
+		frame := append(header, make([]byte, length-7)...)
+		if _, err := io.ReadFull(conn, frame[7:]); err != nil {
+			return
+		}
+		if frame[0] != s.id {
+			continue // 其他从站的请求
+		}
+		function, address := frame[1], binary.BigEndian.Uint16(frame[2:])
+		quantity := binary.BigEndian.Uint16(frame[4:])
+
+		s.mu.Lock()
+		var pdu []byte
+		switch function {
+		case FuncReadHoldingRegisters:
+			if address >= 100 {
+				pdu = []byte{function | 0x80, 0x02}
+				break
+			}
+			pdu = []byte{function, byte(quantity * 2)}
+			for i := uint16(0); i < quantity; i++ {
+				pdu = binary.BigEndian.AppendUint16(pdu, s.registers[address+i])
+			}
+		case FuncWriteSingleRegister:
+			s.registers[address] = quantity
+			pdu = frame[1:6]
+		case FuncWriteMultipleRegisters:
+			for i := uint16(0); i < quantity; i++ {
+				s.registers[address+i] = binary.BigEndian.Uint16(frame[7+i*2:])
+			}
+			pdu = frame[1:6]
+		}
+		s.mu.Unlock()
+		conn.Write(rtuFrame(s.id, pdu))
+	}
+}
+
+func TestHandlerRTUOverTCP(t *testing.T) {
+	handler, err := New(Config{
+		Mode:     ModeRTU,
+		SlaveID:  5,
+		Register: true,
+		Points: []Point{
+			{Name: "voltage", Address: 0, Type: "uint16", Scale: 0.1},
+			{Name: "power", Address: 1, Type: "float32"},
+			{Name: "setpoint", Address: 3, Type: "int16"},
+			{Name: "serial", Address: 4, Type: "uint32", ReadOnly: true},
+			{Name: "broken", Address: 100},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	server := network.NewTCPServer(network.WithProtocolHandler(handler))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, addr)
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("DTU-001")) // DTU 注册包
+	device := &slave{id: 5, registers: map[uint16]uint16{0: 2205, 1: 0x4148, 2: 0x0000, 3: 7, 4: 0, 5: 42}}
+	go device.serve(conn)
+
+	locator := server.(network.DeviceLocator)
+	for i := 0; i < 100 && locator.LookupDevice("DTU-001") == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	request := func(ctx context.Context, deviceKey string, data interface{}, params ...string) (interface{}, error) {
+		target := locator.LookupDevice(deviceKey)
+		if target == nil {
+			return nil, network.ErrDeviceNotFound
+		}
+		pending, err := network.BeginRequest(ctx, deviceKey, handler, target, data, params...)
+		if err != nil {
+			return nil, err
+		}
+		defer pending.Done()
+		if err := server.SendData(target, data, params...); err != nil {
+			return nil, err
+		}
+		return pending.Wait(ctx)
+	}
+
+	timeout, cancelRequest := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelRequest()
+	requests := handler.ReadRequests()
+	if len(requests) != 2 {
+		t.Fatalf("读请求 %+v", requests)
+	}
+	response, err := request(timeout, "DTU-001", requests[0])
+	want := map[string]interface{}{"voltage": 220.5, "power": float32(12.5), "setpoint": int16(7), "serial": uint32(42)}
+	if err != nil || !reflect.DeepEqual(response, want) {
+		t.Fatalf("读取点表 %v %v", response, err)
+	}
+
+	// 轮询配置中的键值形式命令和点的属性标识
+	if response, err = request(timeout, "DTU-001", map[string]interface{}{"function": 3, "address": 3, "quantity": 1}); err != nil ||
+		!reflect.DeepEqual(response, map[string]interface{}{"setpoint": int16(7)}) {
+		t.Fatalf("键值形式的请求 %v %v", response, err)
+	}
+	var exception *Exception
+	if _, err = request(timeout, "DTU-001", "broken"); !errors.As(err, &exception) || exception.Code != 2 {
+		t.Fatalf("异常响应应作为错误返回，实际 %v", err)
+	}
+
+	// 平台属性设置转换为寄存器写入，返回写入成功的属性
+	target := &model.Device{DeviceKey: "DTU-001"}
+	reply, err := handler.SetProperties(timeout, requesterFunc(request), target, map[string]interface{}{
+		"DeviceKey": "DTU-001", "MessageID": "m1", "setpoint": -3, "power": 1.5,
+	})
+	if err != nil || !reflect.DeepEqual(reply, map[string]interface{}{"setpoint": -3, "power": 1.5}) {
+		t.Fatalf("属性设置 %v %v", reply, err)
+	}
+	device.mu.Lock()
+	setpoint, power := device.registers[3], uint32(device.registers[1])<<16|uint32(device.registers[2])
+	device.mu.Unlock()
+	if int16(setpoint) != -3 || power != 0x3FC00000 {
+		t.Fatalf("寄存器写入 %d %X", int16(setpoint), power)
+	}
+
+	if _, err := handler.SetProperties(timeout, requesterFunc(request), target, map[string]interface{}{"serial": 1}); err == nil {
+		t.Fatal("只读点的属性设置应返回错误")
+	}
+}
+
+func TestHandlerSlaveAddress(t *testing.T) {
+	handler, err := New(Config{
+		Mode:   ModeRTU,
+		Slaves: map[string]byte{"m2": 2},
+		Points: []Point{{Name: "voltage", Address: 0, Type: "uint16"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := handler.AddressSlave("voltage", "m2")
+	if err != nil || data.(Request).SlaveID != 2 {
+		t.Fatalf("按子设备标识寻址 %+v %v", data, err)
+	}
+	if data, err := handler.AddressSlave(Request{Function: 3, Quantity: 1}, "7"); err != nil || data.(Request).SlaveID != 7 {
+		t.Fatalf("按从站地址寻址 %+v %v", data, err)
+	}
+	if _, err := handler.AddressSlave("voltage", "m9"); err == nil {
+		t.Fatal("未知的从站应返回错误")
+	}
+
+	// 同一总线上不同从站的请求关联键不同，其他从站的应答不交给请求
+	bus := &model.Device{DeviceKey: "rs485-1"}
+	if handler.RequestKey(bus, data) == handler.RequestKey(bus, Request{Function: 3, Quantity: 1, SlaveID: 7}) {
+		t.Fatal("不同从站的关联键相同")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pending, err := network.BeginRequest(ctx, bus.DeviceKey, handler, bus, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pending.Done()
+	if _, err := handler.Encode(bus, data); err != nil {
+		t.Fatal(err)
+	}
+	handler.Decode(bus, rtuFrame(7, []byte{FuncReadHoldingRegisters, 2, 0, 1}))
+	handler.Decode(bus, rtuFrame(2, []byte{FuncReadHoldingRegisters, 2, 0, 9}))
+	if response, err := pending.Wait(ctx); err != nil || !reflect.DeepEqual(response, map[string]interface{}{"voltage": uint16(9)}) {
+		t.Fatalf("从站 2 的响应 %v %v", response, err)
+	}
+}
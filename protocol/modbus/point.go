@@ -0,0 +1,293 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// 寄存器区
+const (
+	AreaCoil     = "coil"     // 线圈，可读写，功能码 1/5/15
+	AreaDiscrete = "discrete" // 离散输入，只读，功能码 2
+	AreaHolding  = "holding"  // 保持寄存器，可读写，功能码 3/6/16
+	AreaInput    = "input"    // 输入寄存器，只读，功能码 4
+)
+
+// Point 点表中的一个点，将寄存器映射为物模型属性
+type Point struct {
+	Name      string  `json:"name"`      // 物模型属性标识
+	Area      string  `json:"area"`      // 寄存器区,coil/discrete/holding(默认)/input
+	Address   uint16  `json:"address"`   // 起始地址
+	Type      string  `json:"type"`      // 数据类型,bool/int16/uint16(默认)/int32/uint32/int64/uint64/float32/float64
+	ByteOrder string  `json:"byteOrder"` // 寄存器内的字节序,big(默认)/little
+	WordOrder string  `json:"wordOrder"` // 多个寄存器之间的字序,big(默认,高字在前)/little
+	Scale     float64 `json:"scale"`     // 缩放系数,属性值 = 原始值 * Scale + Offset,为 0 时按 1 处理
+	Offset    float64 `json:"offset"`    // 偏移量
+	ReadOnly  bool    `json:"readOnly"`  // 是否只读,discrete/input 区始终只读
+}
+
+// registers 点占用的寄存器(线圈)数量
+func (p Point) registers() uint16 {
+	switch p.Type {
+	case "int32", "uint32", "float32":
+		return 2
+	case "int64", "uint64", "float64":
+		return 4
+	}
+	return 1
+}
+
+// isBit 点是否位于线圈或离散输入区
+func (p Point) isBit() bool {
+	return p.Area == AreaCoil || p.Area == AreaDiscrete
+}
+
+// scaled 是否需要缩放或偏移
+func (p Point) scaled() bool {
+	return (p.Scale != 0 && p.Scale != 1) || p.Offset != 0
+}
+
+// readFunction 读取点使用的功能码
+func (p Point) readFunction() byte {
+	switch p.Area {
+	case AreaCoil:
+		return FuncReadCoils
+	case AreaDiscrete:
+		return FuncReadDiscreteInputs
+	case AreaInput:
+		return FuncReadInputRegisters
+	}
+	return FuncReadHoldingRegisters
+}
+
+// writable 点是否可写
+func (p Point) writable() bool {
+	return !p.ReadOnly && (p.Area == AreaCoil || p.Area == AreaHolding)
+}
+
+// validate 检查点配置，并填充默认值
+func (p *Point) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("地址 %d 的点未配置属性标识", p.Address)
+	}
+	if p.Area == "" {
+		p.Area = AreaHolding
+	}
+	if p.Type == "" {
+		p.Type = "uint16"
+		if p.isBit() {
+			p.Type = "bool"
+		}
+	}
+	switch p.Area {
+	case AreaCoil, AreaDiscrete:
+		if p.Type != "bool" {
+			return fmt.Errorf("点 %s 位于 %s 区，数据类型只能为 bool", p.Name, p.Area)
+		}
+	case AreaHolding, AreaInput:
+	default:
+		return fmt.Errorf("点 %s 的寄存器区 %s 不支持", p.Name, p.Area)
+	}
+	switch p.Type {
+	case "bool", "int16", "uint16", "int32", "uint32", "int64", "uint64", "float32", "float64":
+	default:
+		return fmt.Errorf("点 %s 的数据类型 %s 不支持", p.Name, p.Type)
+	}
+	for _, order := range []string{p.ByteOrder, p.WordOrder} {
+		if order != "" && order != "big" && order != "little" {
+			return fmt.Errorf("点 %s 的字节序 %s 不支持", p.Name, order)
+		}
+	}
+	if int(p.Address)+int(p.registers()) > 0x10000 {
+		return fmt.Errorf("点 %s 的地址超出范围", p.Name)
+	}
+	return nil
+}
+
+// bytes 将寄存器按字节序、字序转换为高位在前的字节
+func (p Point) bytes(registers []uint16) []byte {
+	words := append([]uint16(nil), registers...)
+	if p.WordOrder == "little" {
+		for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+			words[i], words[j] = words[j], words[i]
+		}
+	}
+	data := make([]byte, 0, len(words)*2)
+	for _, word := range words {
+		if p.ByteOrder == "little" {
+			word = word<<8 | word>>8
+		}
+		data = binary.BigEndian.AppendUint16(data, word)
+	}
+	return data
+}
+
+// words 将高位在前的字节按字节序、字序转换为寄存器
+func (p Point) words(data []byte) []uint16 {
+	words := make([]uint16, len(data)/2)
+	for i := range words {
+		word := binary.BigEndian.Uint16(data[i*2:])
+		if p.ByteOrder == "little" {
+			word = word<<8 | word>>8
+		}
+		words[i] = word
+	}
+	if p.WordOrder == "little" {
+		for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+			words[i], words[j] = words[j], words[i]
+		}
+	}
+	return words
+}
+
+// decode 将点的寄存器(线圈)值转换为属性值
+func (p Point) decode(registers []uint16) interface{} {
+	if p.Type == "bool" {
+		return registers[0] != 0
+	}
+	data := p.bytes(registers)
+	var raw float64
+	var value interface{}
+	switch p.Type {
+	case "int16":
+		v := int16(binary.BigEndian.Uint16(data))
+		raw, value = float64(v), v
+	case "uint16":
+		v := binary.BigEndian.Uint16(data)
+		raw, value = float64(v), v
+	case "int32":
+		v := int32(binary.BigEndian.Uint32(data))
+		raw, value = float64(v), v
+	case "uint32":
+		v := binary.BigEndian.Uint32(data)
+		raw, value = float64(v), v
+	case "int64":
+		v := int64(binary.BigEndian.Uint64(data))
+		raw, value = float64(v), v
+	case "uint64":
+		v := binary.BigEndian.Uint64(data)
+		raw, value = float64(v), v
+	case "float32":
+		v := math.Float32frombits(binary.BigEndian.Uint32(data))
+		raw, value = float64(v), v
+	case "float64":
+		v := math.Float64frombits(binary.BigEndian.Uint64(data))
+		raw, value = v, v
+	}
+	if !p.scaled() {
+		return value
+	}
+	scale := p.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return raw*scale + p.Offset
+}
+
+// encode 将属性值转换为点的寄存器(线圈)值
+func (p Point) encode(value interface{}) ([]uint16, error) {
+	if p.Type == "bool" {
+		if gconv.Bool(value) {
+			return []uint16{1}, nil
+		}
+		return []uint16{0}, nil
+	}
+	raw := gconv.Float64(value)
+	if p.scaled() {
+		scale := p.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		raw = (raw - p.Offset) / scale
+	}
+	if p.Type != "float32" && p.Type != "float64" {
+		raw = math.Round(raw)
+	}
+
+	var data []byte
+	switch p.Type {
+	case "int16":
+		if raw < math.MinInt16 || raw > math.MaxInt16 {
+			return nil, fmt.Errorf("属性 %s 的值 %v 超出 int16 范围", p.Name, value)
+		}
+		data = binary.BigEndian.AppendUint16(nil, uint16(int16(raw)))
+	case "uint16":
+		if raw < 0 || raw > math.MaxUint16 {
+			return nil, fmt.Errorf("属性 %s 的值 %v 超出 uint16 范围", p.Name, value)
+		}
+		data = binary.BigEndian.AppendUint16(nil, uint16(raw))
+	case "int32":
+		if raw < math.MinInt32 || raw > math.MaxInt32 {
+			return nil, fmt.Errorf("属性 %s 的值 %v 超出 int32 范围", p.Name, value)
+		}
+		data = binary.BigEndian.AppendUint32(nil, uint32(int32(raw)))
+	case "uint32":
+		if raw < 0 || raw > math.MaxUint32 {
+			return nil, fmt.Errorf("属性 %s 的值 %v 超出 uint32 范围", p.Name, value)
+		}
+		data = binary.BigEndian.AppendUint32(nil, uint32(raw))
+	case "int64":
+		data = binary.BigEndian.AppendUint64(nil, uint64(int64(raw)))
+	case "uint64":
+		if raw < 0 {
+			return nil, fmt.Errorf("属性 %s 的值 %v 超出 uint64 范围", p.Name, value)
+		}
+		data = binary.BigEndian.AppendUint64(nil, uint64(raw))
+	case "float32":
+		data = binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(raw)))
+	case "float64":
+		data = binary.BigEndian.AppendUint64(nil, math.Float64bits(raw))
+	}
+	return p.words(data), nil
+}
+
+// readRequests 按寄存器区合并地址相邻的点，生成尽量少的读请求
+// 同一区内两个点之间的空隙不超过 maxGap 时合并到同一个请求
+func readRequests(points []Point, maxGap uint16) []Request {
+	byFunction := make(map[byte][]Point)
+	var functions []byte
+	for _, point := range points {
+		function := point.readFunction()
+		if _, ok := byFunction[function]; !ok {
+			functions = append(functions, function)
+		}
+		byFunction[function] = append(byFunction[function], point)
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i] < functions[j] })
+
+	var requests []Request
+	for _, function := range functions {
+		group := byFunction[function]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Address < group[j].Address })
+		max := uint32(maxReadRegisters)
+		if function == FuncReadCoils || function == FuncReadDiscreteInputs {
+			max = maxReadBits
+		}
+
+		var current *Request
+		for _, point := range group {
+			start, end := uint32(point.Address), uint32(point.Address)+uint32(point.registers())
+			if current != nil {
+				currentEnd := uint32(current.Address) + uint32(current.Quantity)
+				if start <= currentEnd+uint32(maxGap) && max32(end, currentEnd)-uint32(current.Address) <= max {
+					current.Quantity = uint16(max32(end, currentEnd) - uint32(current.Address))
+					continue
+				}
+			}
+			requests = append(requests, Request{Function: function, Address: point.Address, Quantity: uint16(end - start)})
+			current = &requests[len(requests)-1]
+		}
+	}
+	return requests
+}
+
+func max32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+package modbus
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPointDecode(t *testing.T) {
+	float := math.Float32bits(12.5) // 0x41480000
+	tests := []struct {
+		point     Point
+		registers []uint16
+		want      interface{}
+	}{
+		{Point{Type: "int16"}, []uint16{0xFFFE}, int16(-2)},
+		{Point{Type: "uint16", Scale: 0.1}, []uint16{2205}, 220.5},
+		{Point{Type: "int16", Scale: 1, Offset: -40}, []uint16{65}, 25.0},
+		{Point{Type: "uint16", ByteOrder: "little"}, []uint16{0x3412}, uint16(0x1234)},
+		{Point{Type: "uint32"}, []uint16{0x0001, 0x0002}, uint32(0x00010002)},
+		{Point{Type: "uint32", WordOrder: "little"}, []uint16{0x0002, 0x0001}, uint32(0x00010002)},
+		{Point{Type: "float32"}, []uint16{uint16(float >> 16), uint16(float)}, float32(12.5)},
+		{Point{Type: "float32", ByteOrder: "little", WordOrder: "little"}, []uint16{0x0000, 0x4841}, float32(12.5)},
+		{Point{Type: "int64"}, []uint16{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF}, int64(-1)},
+		{Point{Type: "float64"}, []uint16{0x4029, 0, 0, 0}, 12.5},
+		{Point{Area: AreaCoil, Type: "bool"}, []uint16{1}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.point.decode(tt.registers); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v 解码 %v 为 %v(%T), 期望 %v(%T)", tt.point, tt.registers, got, got, tt.want, tt.want)
+			continue
+		}
+		if tt.point.Type == "bool" {
+			continue
+		}
+		// 编码后应得到原始寄存器值
+		if registers, err := tt.point.encode(tt.want); err != nil || !reflect.DeepEqual(registers, tt.registers) {
+			t.Errorf("%+v 编码 %v 为 %v %v, 期望 %v", tt.point, tt.want, registers, err, tt.registers)
+		}
+	}
+
+	if _, err := (Point{Name: "t", Type: "int16"}).encode(40000); err == nil {
+		t.Error("超出范围的值应返回错误")
+	}
+}
+
+func TestPointValidate(t *testing.T) {
+	point := Point{Name: "switch", Area: AreaCoil}
+	if err := point.validate(); err != nil || point.Type != "bool" {
+		t.Fatalf("线圈默认类型应为 bool: %v %s", err, point.Type)
+	}
+	for _, point := range []Point{
+		{Address: 1},
+		{Name: "a", Area: "file"},
+		{Name: "a", Area: AreaCoil, Type: "int16"},
+		{Name: "a", Type: "string"},
+		{Name: "a", ByteOrder: "middle"},
+		{Name: "a", Type: "uint32", Address: 0xFFFF},
+	} {
+		if err := point.validate(); err == nil {
+			t.Errorf("%+v 应返回错误", point)
+		}
+	}
+}
+
+func TestReadRequests(t *testing.T) {
+	points := []Point{
+		{Name: "voltage", Area: AreaHolding, Address: 0, Type: "uint16"},
+		{Name: "power", Area: AreaHolding, Address: 1, Type: "float32"},
+		{Name: "energy", Area: AreaHolding, Address: 5, Type: "uint32"},
+		{Name: "far", Area: AreaHolding, Address: 200, Type: "uint16"},
+		{Name: "switch", Area: AreaCoil, Address: 3, Type: "bool"},
+		{Name: "temp", Area: AreaInput, Address: 10, Type: "int16"},
+	}
+	want := []Request{
+		{Function: FuncReadCoils, Address: 3, Quantity: 1},
+		{Function: FuncReadHoldingRegisters, Address: 0, Quantity: 3},
+		{Function: FuncReadHoldingRegisters, Address: 5, Quantity: 2},
+		{Function: FuncReadHoldingRegisters, Address: 200, Quantity: 1},
+		{Function: FuncReadInputRegisters, Address: 10, Quantity: 1},
+	}
+	if got := readRequests(points, 0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("读请求 %+v, 期望 %+v", got, want)
+	}
+
+	// 允许跳过空隙时合并，但单个请求不超过 125 个寄存器
+	want = []Request{
+		{Function: FuncReadCoils, Address: 3, Quantity: 1},
+		{Function: FuncReadHoldingRegisters, Address: 0, Quantity: 7},
+		{Function: FuncReadHoldingRegisters, Address: 200, Quantity: 1},
+		{Function: FuncReadInputRegisters, Address: 10, Quantity: 1},
+	}
+	if got := readRequests(points, 100); !reflect.DeepEqual(got, want) {
+		t.Fatalf("合并空隙后的读请求 %+v, 期望 %+v", got, want)
+	}
+}
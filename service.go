@@ -37,6 +37,12 @@ var onServiceMessage mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Mes
 	if strings.HasSuffix(msg.Topic(), "_reply") {
 		return
 	}
+	//已订阅属性设置主题的设备由 onSetMessage 处理，避免重复触发属性设置事件
+	if strings.HasSuffix(msg.Topic(), "/thing/service/property/set") {
+		if _, ok := setSubscriptions.Load(lib.GetTopicInfo("deviceKey", msg.Topic())); ok {
+			return
+		}
+	}
 	if msg != nil {
 		defer func() {
 			if r := recover(); r != nil {
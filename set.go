@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -17,6 +18,9 @@ import (
 	"github.com/sagoo-cloud/iotgateway/vars"
 )
 
+// setSubscriptions 已订阅属性设置主题的设备标识，服务调用主题同样匹配属性设置主题，这些设备的属性设置只由 onSetMessage 处理
+var setSubscriptions sync.Map
+
 // SubscribeSetEvent  订阅平台的属性设置，需要在有新设备接入时调用
 func (gw *Gateway) SubscribeSetEvent(deviceKey string) {
 	if gw.MQTTClient == nil || !gw.MQTTClient.IsConnected() {
@@ -28,7 +32,9 @@ func (gw *Gateway) SubscribeSetEvent(deviceKey string) {
 	token := gw.MQTTClient.Subscribe(topic, 1, onSetMessage)
 	if token.Error() != nil {
 		glog.Debug(context.Background(), "subscribe error: ", token.Error())
+		return
 	}
+	setSubscriptions.Store(deviceKey, true)
 }
 
 // onSetMessage 属性设置调用处理